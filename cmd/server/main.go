@@ -3,114 +3,66 @@ package main
 import (
 	"context"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"user-service/internal/app"
 	"user-service/internal/config"
-	"user-service/internal/database"
-	"user-service/internal/handlers"
-	"user-service/internal/metrics"
-	"user-service/internal/middleware"
-	"user-service/internal/services"
+	"user-service/internal/logging"
 )
 
 func main() {
-	// Setup structured logging
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// Setup structured logging, at LOG_LEVEL once the configuration is loaded
+	var logLevel slog.LevelVar
+	logger := slog.New(logging.NewHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &logLevel})))
 	slog.SetDefault(logger)
 
 	slog.Info("Starting user service...")
 
 	// Load configuration
 	cfg := config.Load()
-
-	// Initialize database connection
-	db, err := database.NewConnection(cfg.DatabaseURL)
-	if err != nil {
-		slog.Error("Failed to connect to database", "error", err)
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
 		os.Exit(1)
 	}
-	defer db.Close(context.Background())
-
-	// Initialize metrics
-	metricsCollector := metrics.New(nil, nil)
-	slog.Info("Metrics initialized")
-
-	// Create service
-	userService := services.NewUserService(db, metricsCollector)
-
-	// Setup routes with middleware
-	mux := setupRoutes(userService, metricsCollector, cfg)
-
-	// Configure server
-	server := &http.Server{
-		Addr:           cfg.Port,
-		Handler:        mux,
-		ReadTimeout:    15 * time.Second,
-		WriteTimeout:   15 * time.Second,
-		IdleTimeout:    60 * time.Second,
-		MaxHeaderBytes: 1 << 20, // 1 MB
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		slog.Warn("Invalid LOG_LEVEL, logging at info", "log_level", cfg.LogLevel)
+	}
+	// Mask personal data in every record from here on, unless LOG_PII=allow
+	if cfg.LogPII != "allow" {
+		slog.SetDefault(slog.New(logging.NewRedactHandler(logger.Handler(), cfg.LogPIIFields)))
 	}
 
-	// Start server in a goroutine
-	go func() {
-		slog.Info("Server starting", "address", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("Server failed to start", "error", err)
-			os.Exit(1)
-		}
-	}()
+	// Wire up the storage, metrics, routes and servers
+	service, err := app.New(cfg, app.Deps{})
+	if err != nil {
+		slog.Error("Failed to set up the service", "error", err)
+		os.Exit(1)
+	}
 
-	// Setup graceful shutdown
+	// Serve until a shutdown signal arrives or a server fails
+	ctx, stop := context.WithCancel(context.Background())
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	// Wait for shutdown signal
-	sig := <-quit
-	slog.Info("Received signal, shutting down gracefully...", "signal", sig)
+	go func() {
+		sig := <-quit
+		slog.Info("Received signal, shutting down gracefully...", "signal", sig)
+		stop()
+	}()
+	exitCode := 0
+	if err := service.Start(ctx); err != nil {
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	}
+	stop()
 
 	// Create shutdown context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-
-	// Attempt graceful shutdown
-	if err := server.Shutdown(ctx); err != nil {
-		slog.Error("Server forced to shutdown", "error", err)
-	} else {
-		slog.Info("Server shutdown complete")
+	if err := service.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down cleanly", "error", err)
 	}
-}
-
-func setupRoutes(userService *services.UserService, metricsCollector *metrics.Metrics, cfg *config.Config) *http.ServeMux {
-	mux := http.NewServeMux()
-
-	// Create handlers
-	userHandler := handlers.NewUserHandler(userService)
-	healthHandler := handlers.NewHealthHandler(userService)
-
-	// Apply middleware chain
-	var handler http.Handler = mux
-	handler = middleware.RequestID()(handler)
-	handler = middleware.Recovery(metricsCollector)(handler)
-	handler = middleware.CORS()(handler)
-	handler = middleware.RateLimit(cfg.GetRateLimiter(), metricsCollector)(handler)
-	handler = middleware.Metrics(metricsCollector)(handler)
-	handler = middleware.Logging()(handler)
-
-	// Register application routes
-	mux.HandleFunc("/user", userHandler.GetUser)
-	mux.HandleFunc("/users", userHandler.ListUsers)
-	mux.HandleFunc("/health", healthHandler.Health)
-
-	// Register metrics endpoint
-	mux.Handle("/metrics", metricsCollector.Handler())
-
-	// Wrap the final handler
-	finalMux := http.NewServeMux()
-	finalMux.Handle("/", handler)
-
-	return finalMux
+	os.Exit(exitCode)
 }
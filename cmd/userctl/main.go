@@ -0,0 +1,349 @@
+// Command userctl runs admin operations against a user service through its HTTP API.
+//
+//	userctl users list [--limit N]
+//	userctl users get <id>
+//	userctl users create --name NAME --email EMAIL [--role ROLE]
+//	userctl users create -          (reads {"name","email","role"} JSON from stdin)
+//	userctl users delete <id>
+//	userctl health
+//
+// Every command takes --addr (default $USERCTL_ADDR or http://localhost:8082),
+// --api-key (default $USERCTL_API_KEY), sent as a bearer token, and --json to print
+// JSON instead of a table. Flags go before positional arguments.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"user-service/pkg/client"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1 // the request failed
+	exitUsage = 2 // the command line is invalid
+)
+
+const defaultAddr = "http://localhost:8082"
+
+const usage = `Usage:
+  userctl users list [--limit N]
+  userctl users get <id>
+  userctl users create --name NAME --email EMAIL [--role ROLE]
+  userctl users create -
+  userctl users delete <id>
+  userctl health
+
+Flags for every command: --addr URL, --api-key KEY, --json, --timeout DURATION
+`
+
+// errUsage reports an invalid command line; the usage has been printed
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+// cli holds the streams and settings of one invocation
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	getenv         func(string) string
+
+	addr    string
+	apiKey  string
+	json    bool
+	timeout time.Duration
+}
+
+// run executes the command in args and returns the process exit code
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, getenv: getenv}
+
+	var err error
+	switch {
+	case len(args) >= 2 && args[0] == "users":
+		err = c.users(ctx, args[1], args[2:])
+	case len(args) >= 1 && args[0] == "health":
+		err = c.health(ctx, args[1:])
+	default:
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return exitError
+	}
+}
+
+// users runs a users subcommand
+func (c *cli) users(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "list":
+		return c.list(ctx, args)
+	case "get":
+		return c.get(ctx, args)
+	case "create":
+		return c.create(ctx, args)
+	case "delete":
+		return c.delete(ctx, args)
+	default:
+		fmt.Fprint(c.stderr, usage)
+		return errUsage
+	}
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	fs := c.flags("users list")
+	limit := fs.Int("limit", 0, "print at most `N` users; 0 prints all")
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return c.usageError(fs, "--limit cannot be negative")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	users, err := c.client().ListUsers(ctx, client.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(users) > *limit {
+		users = users[:*limit]
+	}
+	return c.printUsers(users)
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	fs := c.flags("users get <id>")
+	if err := c.parse(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := c.parseID(fs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	user, err := c.client().GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	return c.printUser(user)
+}
+
+func (c *cli) create(ctx context.Context, args []string) error {
+	fs := c.flags("users create --name NAME --email EMAIL [--role ROLE] | -")
+	name := fs.String("name", "", "the user's `name`")
+	email := fs.String("email", "", "the user's `email`")
+	role := fs.String("role", "", "the user's `role`; the service default when empty")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if err := c.apply(fs); err != nil {
+		return err
+	}
+
+	user := client.User{Name: *name, Email: *email, Role: *role}
+	switch {
+	case fs.NArg() == 1 && fs.Arg(0) == "-":
+		var body struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		decoder := json.NewDecoder(c.stdin)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			return fmt.Errorf("failed to read user from stdin: %w", err)
+		}
+		user = client.User{Name: body.Name, Email: body.Email, Role: body.Role}
+	case fs.NArg() != 0:
+		return c.usageError(fs, "unexpected arguments")
+	case *name == "" || *email == "":
+		return c.usageError(fs, "--name and --email are required, or - to read the user from stdin")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	created, err := c.client().CreateUser(ctx, user)
+	if err != nil {
+		return describe(err)
+	}
+	return c.printUser(created)
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	fs := c.flags("users delete <id>")
+	if err := c.parse(fs, args, 1); err != nil {
+		return err
+	}
+	id, err := c.parseID(fs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if err := c.client().DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]int{"deleted": id})
+	}
+	fmt.Fprintf(c.stdout, "Deleted user %d\n", id)
+	return nil
+}
+
+func (c *cli) health(ctx context.Context, args []string) error {
+	fs := c.flags("health")
+	if err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	health, err := c.client().Health(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(health)
+	}
+	fmt.Fprintf(c.stdout, "%s: %s, %d users\n", health.Service, health.Status, health.UsersCount)
+	return nil
+}
+
+// flags creates the flag set of a command with the flags every command shares
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: userctl %s\n", name)
+		fs.PrintDefaults()
+	}
+
+	addr := c.getenv("USERCTL_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+	fs.StringVar(&c.addr, "addr", addr, "service `URL` ($USERCTL_ADDR)")
+	fs.StringVar(&c.apiKey, "api-key", c.getenv("USERCTL_API_KEY"), "`key` sent as a bearer token ($USERCTL_API_KEY)")
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of a table")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "give up on the request after `duration`")
+	return fs
+}
+
+// parse parses args into fs, requiring exactly positional arguments after the flags
+func (c *cli) parse(fs *flag.FlagSet, args []string, positional int) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != positional {
+		return c.usageError(fs, fmt.Sprintf("expected %d argument(s), got %d", positional, fs.NArg()))
+	}
+	return c.apply(fs)
+}
+
+// apply checks the shared flags once fs is parsed
+func (c *cli) apply(fs *flag.FlagSet) error {
+	if c.timeout <= 0 {
+		return c.usageError(fs, "--timeout must be positive")
+	}
+	return nil
+}
+
+// parseID reads the user ID argument
+func (c *cli) parseID(fs *flag.FlagSet) (int, error) {
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || id < 1 {
+		return 0, c.usageError(fs, fmt.Sprintf("invalid user id %q", fs.Arg(0)))
+	}
+	return id, nil
+}
+
+// usageError prints message with the command's usage
+func (c *cli) usageError(fs *flag.FlagSet, message string) error {
+	fmt.Fprintf(c.stderr, "error: %s\n", message)
+	fs.Usage()
+	return errUsage
+}
+
+// client creates an API client from the shared flags
+func (c *cli) client() *client.Client {
+	var opts []client.Option
+	if c.apiKey != "" {
+		opts = append(opts, client.WithAuth(client.BearerToken(c.apiKey)))
+	}
+	return client.New(c.addr, append(opts, client.WithRetries(2))...)
+}
+
+// printUser prints user as a one-row table, or as a JSON object with --json
+func (c *cli) printUser(user client.User) error {
+	if c.json {
+		return c.printJSON(user)
+	}
+	return c.printUsers([]client.User{user})
+}
+
+// printUsers prints users as a table, or as a JSON array with --json
+func (c *cli) printUsers(users []client.User) error {
+	if c.json {
+		if users == nil {
+			users = []client.User{}
+		}
+		return c.printJSON(users)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tEMAIL\tROLE\tSTATUS\tCREATED")
+	for _, user := range users {
+		created := ""
+		if !user.CreatedAt.IsZero() {
+			created = user.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", user.ID, user.Name, user.Email, user.Role, user.Status, created)
+	}
+	return tw.Flush()
+}
+
+// printJSON prints v as indented JSON
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// describe adds the failed fields of a validation error to its message
+func describe(err error) error {
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || len(apiErr.Details) == 0 {
+		return err
+	}
+	message := err.Error()
+	for _, detail := range apiErr.Details {
+		message += fmt.Sprintf("\n  %s: %s", detail.Field, detail.Message)
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"user-service/internal/app"
+	"user-service/internal/config"
+	"user-service/pkg/client"
+)
+
+// adminToken is the token the test server accepts, which userctl sends by default
+const adminToken = "secret"
+
+// newServer serves the real routes over the seed users
+func newServer(t *testing.T) *httptest.Server {
+	cfg := config.Load()
+	cfg.DBBackend = "memory"
+	cfg.ListCacheTTL = 0
+	cfg.RateLimit.Read = config.RateBudget{RequestsPerSecond: 1000, BurstSize: 1000}
+	cfg.RateLimit.Write = cfg.RateLimit.Read
+	cfg.AdminToken = adminToken
+
+	service, err := app.New(cfg, app.Deps{Registry: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatalf("Failed to set up the service: %v", err)
+	}
+	server := httptest.NewServer(service.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// userctl runs the command against addr and returns its exit code, stdout and stderr
+func userctl(addr, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	getenv := func(key string) string {
+		switch key {
+		case "USERCTL_ADDR":
+			return addr
+		case "USERCTL_API_KEY":
+			return adminToken
+		}
+		return ""
+	}
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr, getenv)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	server := newServer(t)
+
+	tests := []struct {
+		name       string
+		stdin      string
+		args       []string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name:       "list as a table",
+			args:       []string{"users", "list"},
+			wantStdout: []string{"ID  NAME", "1   John Doe           john@example.com", "4   Sylvester Carolan"},
+		},
+		{
+			name:       "list with a limit",
+			args:       []string{"users", "list", "--limit", "1", "--json"},
+			wantStdout: []string{`"name": "John Doe"`},
+		},
+		{
+			name:       "get",
+			args:       []string{"users", "get", "2"},
+			wantStdout: []string{"Jane Smith", "jane@example.com"},
+		},
+		{
+			name:       "get a missing user",
+			args:       []string{"users", "get", "999"},
+			wantCode:   exitError,
+			wantStderr: "error: user service: 404 Not Found: user not found",
+		},
+		{
+			name:       "create from flags",
+			args:       []string{"users", "create", "--name", "Ada Lovelace", "--email", "ada@example.com", "--json"},
+			wantStdout: []string{`"name": "Ada Lovelace"`, `"role": "user"`},
+		},
+		{
+			name:       "create from stdin",
+			stdin:      `{"name":"Grace Hopper","email":"grace@example.com","role":"admin"}`,
+			args:       []string{"users", "create", "-"},
+			wantStdout: []string{"Grace Hopper", "admin"},
+		},
+		{
+			name:       "create with an invalid email",
+			args:       []string{"users", "create", "--name", "Bad", "--email", "nope"},
+			wantCode:   exitError,
+			wantStderr: "email: must contain @",
+		},
+		{
+			name:       "create with a taken email",
+			args:       []string{"users", "create", "--name", "John", "--email", "john@example.com"},
+			wantCode:   exitError,
+			wantStderr: "409 Conflict",
+		},
+		{
+			name:       "create from invalid stdin",
+			stdin:      `{"name":"Ada","mail":"ada@example.com"}`,
+			args:       []string{"users", "create", "-"},
+			wantCode:   exitError,
+			wantStderr: "failed to read user from stdin",
+		},
+		{
+			name:       "create without an email",
+			args:       []string{"users", "create", "--name", "Ada"},
+			wantCode:   exitUsage,
+			wantStderr: "--name and --email are required",
+		},
+		{
+			name:       "delete",
+			args:       []string{"users", "delete", "3"},
+			wantStdout: []string{"Deleted user 3"},
+		},
+		{
+			name:       "delete with a wrong api key",
+			args:       []string{"users", "delete", "--api-key", "guess", "2"},
+			wantCode:   exitError,
+			wantStderr: "401 Unauthorized",
+		},
+		{
+			name:       "delete with an invalid id",
+			args:       []string{"users", "delete", "abc"},
+			wantCode:   exitUsage,
+			wantStderr: `invalid user id "abc"`,
+		},
+		{
+			name:       "health",
+			args:       []string{"health"},
+			wantStdout: []string{"user-service: healthy"},
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"users", "purge"},
+			wantCode:   exitUsage,
+			wantStderr: "Usage:",
+		},
+		{
+			name:       "missing argument",
+			args:       []string{"users", "get"},
+			wantCode:   exitUsage,
+			wantStderr: "expected 1 argument(s), got 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := userctl(server.URL, tt.stdin, tt.args...)
+			if code != tt.wantCode {
+				t.Fatalf("Expected exit code %d, got %d (stderr %q)", tt.wantCode, code, stderr)
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("Expected stdout to contain %q, got %q", want, stdout)
+				}
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("Expected stderr to contain %q, got %q", tt.wantStderr, stderr)
+			}
+		})
+	}
+}
+
+func TestListJSON(t *testing.T) {
+	server := newServer(t)
+
+	code, stdout, stderr := userctl(server.URL, "", "users", "list", "--json", "--limit", "2")
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d (stderr %q)", exitOK, code, stderr)
+	}
+	var users []client.User
+	if err := json.Unmarshal([]byte(stdout), &users); err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != 1 || users[1].ID != 2 {
+		t.Errorf("Expected users 1 and 2, got %+v", users)
+	}
+}
+
+func TestAddrFlagOverridesEnvironment(t *testing.T) {
+	server := newServer(t)
+
+	code, _, stderr := userctl("http://127.0.0.1:1", "", "health", "--addr", server.URL)
+	if code != exitOK {
+		t.Errorf("Expected exit code %d, got %d (stderr %q)", exitOK, code, stderr)
+	}
+
+	code, _, _ = userctl("http://127.0.0.1:1", "", "health", "--timeout", "1s")
+	if code != exitError {
+		t.Errorf("Expected exit code %d for an unreachable service, got %d", exitError, code)
+	}
+}
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"net/http"
+	"slices"
+
+	"golang.org/x/time/rate"
+	"user-service/internal/cache"
+	"user-service/internal/config"
+	"user-service/internal/events"
+	"user-service/internal/handlers"
+	"user-service/internal/health"
+	"user-service/internal/metrics"
+	"user-service/internal/middleware"
+	"user-service/internal/reqctx"
+	"user-service/internal/router"
+	"user-service/internal/services"
+)
+
+// options holds the optional dependencies of the routes
+type options struct {
+	broker      *events.Broker
+	listCache   *middleware.MicroCache
+	emailCache  *middleware.MicroCache
+	checks      *health.CheckRegistry
+	heartbeats  *health.Heartbeats
+	idempotency cache.Cache[string, middleware.IdempotentResponse]
+}
+
+// Option configures SetupRoutes
+type Option func(*options)
+
+// WithEventStream streams the events published to broker at GET /users/events
+func WithEventStream(broker *events.Broker) Option {
+	return func(o *options) {
+		o.broker = broker
+	}
+}
+
+// WithListCache serves GET /users through cache. Invalidate it when users change.
+func WithListCache(cache *middleware.MicroCache) Option {
+	return func(o *options) {
+		o.listCache = cache
+	}
+}
+
+// WithEmailAvailabilityCache serves GET /users/email-available through cache.
+// Invalidate it when users change.
+func WithEmailAvailabilityCache(cache *middleware.MicroCache) Option {
+	return func(o *options) {
+		o.emailCache = cache
+	}
+}
+
+// WithHealthChecks runs the checks registered in checks at /readyz, along with
+// those of the user service
+func WithHealthChecks(checks *health.CheckRegistry) Option {
+	return func(o *options) {
+		o.checks = checks
+	}
+}
+
+// WithHeartbeats reports the service down at /livez once a worker registered in
+// heartbeats stops beating
+func WithHeartbeats(heartbeats *health.Heartbeats) Option {
+	return func(o *options) {
+		o.heartbeats = heartbeats
+	}
+}
+
+// WithIdempotency replays the response to a POST /users repeated with the same
+// Idempotency-Key from store instead of creating the user again
+func WithIdempotency(store cache.Cache[string, middleware.IdempotentResponse]) Option {
+	return func(o *options) {
+		o.idempotency = store
+	}
+}
+
+// SetupRoutes registers every route and wraps them in the middleware chain.
+// It is the single place to add a route for both the server and the tests.
+func SetupRoutes(userService *services.UserService, metricsCollector metrics.Recorder, cfg *config.Config, opts ...Option) http.Handler {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := router.New()
+	r.NotFound(http.HandlerFunc(handlers.RouteNotFound))
+	r.MethodNotAllowed(http.HandlerFunc(handlers.MethodNotAllowed))
+
+	// Create handlers
+	userHandler := handlers.NewUserHandler(userService)
+	checks := o.checks
+	if checks == nil {
+		checks = health.NewCheckRegistry()
+	}
+	checks.Register(userService.ReadinessChecks()...)
+	// Without workers to watch, /livez always answers ok
+	heartbeats := o.heartbeats
+	if heartbeats == nil {
+		heartbeats = health.NewHeartbeats(cfg.HeartbeatTimeout)
+	}
+	importHandler := handlers.NewImportHandler(userService, cfg.ImportMaxBytes)
+	healthHandler := handlers.NewHealthHandler(userService, checks, heartbeats, cfg.HealthDetailToken)
+
+	// Every route is capped at its configured concurrency limit, if any, with its
+	// configured queue, inside its group's middleware. Routes declaring their query parameters reject any others.
+	handle := func(g *router.Group, pattern string, handler http.Handler, params ...middleware.QueryParam) {
+		if len(params) > 0 {
+			handler = middleware.QueryParams(params...)(handler)
+		}
+		route := g.Pattern(pattern)
+		queue := middleware.Queue{Length: cfg.ConcurrencyQueues[route], Timeout: cfg.ConcurrencyQueueTimeout}
+		g.Handle(pattern, middleware.ConcurrencyLimit(route, cfg.ConcurrencyLimits[route], queue, metricsCollector)(handler))
+	}
+	// Any value is accepted; ones that do not parse mean compact output
+	pretty := middleware.QueryParam{Name: "pretty"}
+
+	// Reads are open, while changing users takes an admin caller and the admin
+	// routes take the admin token
+	public := r.Group("")
+	writer := r.Group("").Use(middleware.RequireRole(reqctx.AdminRole))
+	admin := r.Group("").Use(middleware.AdminToken(cfg.AdminToken))
+	adminAPI := admin.Group("/admin")
+
+	// The user routes are served unversioned, for the partner clients, and under
+	// /v2 for internal ones. The versions differ only in the case of their keys,
+	// JSON_FIELD_CASE and JSON_FIELD_CASE_V2, which the FieldCase middleware sets.
+	v2 := r.Group("/v2")
+	versions := []struct{ public, writer *router.Group }{
+		{public, writer},
+		{v2, v2.Group("").Use(middleware.RequireRole(reqctx.AdminRole))},
+	}
+
+	// Both versions draw from one budget for email availability checks
+	emailLimiter := cfg.EmailAvailability.RateLimit.Limiter()
+
+	// Register application routes
+	for _, version := range versions {
+		public, writer := version.public, version.writer
+		handle(public, "/user", http.HandlerFunc(userHandler.GetUser), middleware.QueryParam{Name: "id", Type: middleware.IntParam}, pretty)
+		handle(public, "HEAD /user", http.HandlerFunc(userHandler.HeadUser), middleware.QueryParam{Name: "id", Type: middleware.IntParam})
+		handle(public, "GET /me", http.HandlerFunc(userHandler.GetMe), pretty)
+		handle(writer, "PUT /user", http.HandlerFunc(userHandler.UpdateUser))
+		handle(writer, "PATCH /user", http.HandlerFunc(userHandler.PatchUser))
+		handle(writer, "DELETE /user", http.HandlerFunc(userHandler.DeleteUser))
+		var listUsers http.Handler = http.HandlerFunc(userHandler.ListUsers)
+		if o.listCache != nil {
+			listUsers = o.listCache.Wrap(listUsers)
+		}
+		handle(public, "/users", listUsers,
+			middleware.QueryParam{Name: "role"},
+			middleware.QueryParam{Name: "status"},
+			middleware.QueryParam{Name: "created_after", Type: middleware.TimeParam},
+			middleware.QueryParam{Name: "created_before", Type: middleware.TimeParam},
+			pretty)
+		var createUser http.Handler = http.HandlerFunc(userHandler.CreateUser)
+		if o.idempotency != nil {
+			createUser = middleware.Idempotency(o.idempotency)(createUser)
+		}
+		handle(writer, "POST /users", createUser)
+		handle(public, "/users/count", http.HandlerFunc(userHandler.CountUsers))
+		if cfg.EmailAvailability.Enabled {
+			// Cached answers count against the tighter budget too, so it bounds how
+			// fast emails can be tried
+			var emailAvailable http.Handler = http.HandlerFunc(userHandler.EmailAvailable)
+			if o.emailCache != nil {
+				emailAvailable = o.emailCache.Wrap(emailAvailable)
+			}
+			emailAvailable = middleware.RouteRateLimit("email_availability", emailLimiter, metricsCollector)(emailAvailable)
+			handle(public, "GET /users/email-available", emailAvailable, middleware.QueryParam{Name: "email"}, pretty)
+		}
+	}
+	handle(writer, "POST /users/import", http.HandlerFunc(importHandler.ImportCSV))
+	handle(public, "GET /users/export", http.HandlerFunc(userHandler.ExportUsers))
+	handle(public, "GET /users/export.csv", http.HandlerFunc(userHandler.ExportUsersCSV))
+	handle(public, "/health", http.HandlerFunc(healthHandler.Health))
+	handle(public, "/readyz", http.HandlerFunc(healthHandler.Ready))
+	handle(public, "/livez", http.HandlerFunc(healthHandler.Live))
+	if o.broker != nil {
+		handle(public, "GET /users/events", http.HandlerFunc(handlers.NewEventsHandler(o.broker).Stream))
+	}
+	if cfg.EnableGraphQL {
+		handle(public, "POST /graphql", http.HandlerFunc(handlers.NewGraphQLHandler(userService, metricsCollector).Query))
+	}
+
+	// Register admin routes
+	handle(adminAPI, "GET /users", http.HandlerFunc(userHandler.AdminListUsers))
+	handle(adminAPI, "POST /users/{id}/restore", http.HandlerFunc(userHandler.RestoreUser))
+	handle(adminAPI, "PUT /users/{id}/subject", http.HandlerFunc(userHandler.LinkSubject))
+	handle(adminAPI, "POST /users/import", http.HandlerFunc(importHandler.Import))
+	handle(adminAPI, "GET /audit", http.HandlerFunc(userHandler.AdminAuditLog))
+	handle(adminAPI, "POST /webhooks", http.HandlerFunc(userHandler.AdminCreateWebhook))
+	handle(adminAPI, "GET /webhooks", http.HandlerFunc(userHandler.AdminListWebhooks))
+	handle(adminAPI, "GET /webhooks/{id}", http.HandlerFunc(userHandler.AdminGetWebhook))
+	handle(adminAPI, "PUT /webhooks/{id}", http.HandlerFunc(userHandler.AdminUpdateWebhook))
+	handle(adminAPI, "DELETE /webhooks/{id}", http.HandlerFunc(userHandler.AdminDeleteWebhook))
+	handle(adminAPI, "GET /webhooks/{id}/deliveries", http.HandlerFunc(userHandler.AdminWebhookDeliveries))
+	handle(admin, "POST /users/{id}/disable", http.HandlerFunc(userHandler.DisableUser))
+	handle(admin, "POST /users/{id}/enable", http.HandlerFunc(userHandler.EnableUser))
+
+	// Register the metrics endpoint, unless the metrics are pushed elsewhere
+	if exposer, ok := metricsCollector.(metrics.Exposer); ok {
+		handle(public, "/metrics", exposer.Handler())
+	}
+
+	// Apply middleware chain, outermost first. Both export formats draw from one
+	// budget, and query parameters holding personal data, such as the email
+	// GET /users/email-available takes, are masked in the log like secrets.
+	exportLimiter := cfg.RateLimit.Export.Limiter()
+	redactParams := cfg.LogRedactParams
+	if cfg.LogPII != "allow" {
+		redactParams = append(slices.Clone(redactParams), cfg.LogPIIFields...)
+	}
+	r.Use(
+		middleware.RequestID(),
+		middleware.FieldCase(cfg.JSONFieldCase, map[string]string{"/v2": cfg.JSONFieldCaseV2}),
+		middleware.Authenticate(cfg.AdminToken, cfg.UserTokens),
+		// Streams are slow by design and would drown out the requests worth a warning
+		middleware.Logging(cfg.SlowRequestThreshold, cfg.InternalPaths, middleware.QueryLogging{Enabled: cfg.LogQueryParams, Redact: redactParams}, "GET /users/export", "GET /users/export.csv", "GET /users/events"),
+		middleware.Metrics(metricsCollector, cfg.InternalPaths...),
+		// Health checks and metric scrapes are never throttled by client traffic
+		middleware.RateLimit(middleware.RateLimiters{
+			Read:  cfg.RateLimit.Read.Limiter(),
+			Write: cfg.RateLimit.Write.Limiter(),
+			Routes: map[string]*rate.Limiter{
+				"GET /users/export":     exportLimiter,
+				"GET /users/export.csv": exportLimiter,
+			},
+		}, metricsCollector, "/health", "/readyz", "/livez", "/metrics"),
+		middleware.Concurrency(cfg.MaxConcurrentRequests, metricsCollector, "/health", "/readyz", "/livez", "/metrics"),
+		// Streams run for as long as their client reads
+		middleware.Deadline(cfg.RequestTimeout, "GET /users/export", "GET /users/export.csv", "GET /users/events"),
+		middleware.CORS(cfg.CORS.AllowedOrigins, cfg.CORS.MaxAge),
+		middleware.Recovery(metricsCollector),
+	)
+
+	return r
+}
@@ -0,0 +1,1340 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/audit"
+	"user-service/internal/cache"
+	"user-service/internal/config"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/middleware"
+	"user-service/internal/models"
+	"user-service/internal/outbox"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+	"user-service/internal/webhooks"
+)
+
+func TestSetupRoutes(t *testing.T) {
+	dbMock := &mocks.MockDBTX{}
+	row := &mocks.MockRow{}
+	row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = 3
+	})
+	dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row)
+	// Readiness checks run under the request's context
+	dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row)
+
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+	handler := SetupRoutes(userService, metricsCollector, config.Load())
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"health", "/health", http.StatusOK},
+		{"readiness", "/readyz", http.StatusOK},
+		{"liveness", "/livez", http.StatusOK},
+		{"users count", "/users/count", http.StatusOK},
+		{"metrics", "/metrics", http.StatusOK},
+		{"missing user id", "/user", http.StatusBadRequest},
+		{"anonymous me", "/me", http.StatusUnauthorized},
+		{"unknown route", "/nonexistent", http.StatusNotFound},
+		{"admin disabled without token", "/admin/users", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if rr.Header().Get("X-Request-ID") == "" {
+				t.Error("Expected X-Request-ID header to be set")
+			}
+		})
+	}
+}
+
+func TestMetricsRouteWithStatsD(t *testing.T) {
+	// Dialling UDP sends nothing, so no agent needs to listen
+	metricsCollector, err := metrics.NewStatsD("127.0.0.1:8125", 0, metrics.Options{})
+	if err != nil {
+		t.Fatalf("Failed to create the StatsD recorder: %v", err)
+	}
+	defer metricsCollector.Close()
+	userService := services.NewUserService(repository.NewInMemoryRepository(), metricsCollector)
+	handler := SetupRoutes(userService, metricsCollector, config.Load())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	// StatsD pushes its metrics, so there is nothing to scrape
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestSoftDeleteRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// adminUser returns the user with the given ID from the admin listing, if present
+	adminUser := func(t *testing.T, target string, id int) (models.User, bool) {
+		t.Helper()
+		rr := serve("GET", target, "secret")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d from %s, got %d", http.StatusOK, target, rr.Code)
+		}
+		var response struct {
+			Users []models.User `json:"users"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode admin users: %v", err)
+		}
+		for _, user := range response.Users {
+			if user.ID == id {
+				return user, true
+			}
+		}
+		return models.User{}, false
+	}
+
+	if rr := serve("DELETE", "/user?id=1", "secret"); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d deleting user, got %d", http.StatusNoContent, rr.Code)
+	}
+
+	if rr := serve("GET", "/user?id=1", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected deleted user to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	if user, ok := adminUser(t, "/admin/users?include_deleted=true", 1); !ok {
+		t.Error("Expected deleted user in the admin listing with include_deleted=true")
+	} else if user.DeletedAt == nil {
+		t.Error("Expected deleted user to have deleted_at set")
+	}
+
+	if _, ok := adminUser(t, "/admin/users", 1); ok {
+		t.Error("Expected deleted user to be left out of the admin listing by default")
+	}
+
+	if rr := serve("GET", "/admin/users?include_deleted=true", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without admin token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := serve("POST", "/admin/users/1/restore", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with wrong admin token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	if rr := serve("POST", "/admin/users/1/restore", "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d restoring user, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := serve("POST", "/admin/users/1/restore", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d restoring an active user, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	if rr := serve("GET", "/user?id=1", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected restored user to return %d, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestMeRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	cfg.UserTokens = map[string]string{"alice-token": "auth0|alice"}
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("GET", "/me", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := serve("GET", "/me", "alice-token", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d before the subject is linked, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	if rr := serve("PUT", "/admin/users/2/subject", "alice-token", `{"subject":"auth0|alice"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d linking with a user token, got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := serve("PUT", "/admin/users/2/subject", "secret", `{"subject":"auth0|alice"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d linking the subject, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr := serve("GET", "/me", "alice-token", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d once the subject is linked, got %d", http.StatusOK, rr.Code)
+	}
+	var user models.User
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatalf("Failed to decode user: %v", err)
+	}
+	if user.ID != 2 {
+		t.Errorf("Expected user 2, got %+v", user)
+	}
+
+	if rr := serve("DELETE", "/user?id=2", "alice-token", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d deleting with a user token, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestUserStatusRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// setStatus changes the status of user 1 and returns the user from the response
+	setStatus := func(t *testing.T, action string) models.User {
+		t.Helper()
+		rr := serve("POST", "/users/1/"+action, "secret")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d from %s, got %d", http.StatusOK, action, rr.Code)
+		}
+		var user models.User
+		if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+			t.Fatalf("Failed to decode user: %v", err)
+		}
+		return user
+	}
+
+	// listIDs returns the IDs of the users listed for target
+	listIDs := func(t *testing.T, target string) []int {
+		t.Helper()
+		rr := serve("GET", target, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d from %s, got %d", http.StatusOK, target, rr.Code)
+		}
+		var response struct {
+			Users []models.User `json:"users"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode users: %v", err)
+		}
+		var ids []int
+		for _, user := range response.Users {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	if rr := serve("POST", "/users/1/disable", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without admin token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	if user := setStatus(t, "disable"); user.Status != models.StatusDisabled {
+		t.Errorf("Expected disabled user, got status %q", user.Status)
+	}
+	// Disabling twice is idempotent
+	if user := setStatus(t, "disable"); user.Status != models.StatusDisabled {
+		t.Errorf("Expected user to stay disabled, got status %q", user.Status)
+	}
+
+	if ids := listIDs(t, "/users?status=disabled"); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("Expected only user 1 to be listed as disabled, got %v", ids)
+	}
+	if ids := listIDs(t, "/users?status=active"); len(ids) != 3 {
+		t.Errorf("Expected 3 active users, got %v", ids)
+	}
+	if rr := serve("GET", "/users?status=banned", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown status, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	if user := setStatus(t, "enable"); user.Status != models.StatusActive {
+		t.Errorf("Expected active user, got status %q", user.Status)
+	}
+	if ids := listIDs(t, "/users?status=disabled"); len(ids) != 0 {
+		t.Errorf("Expected no disabled users, got %v", ids)
+	}
+
+	if rr := serve("POST", "/users/99/disable", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d disabling a missing user, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := serve("POST", "/users/abc/enable", "secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid id, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestAuditRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		services.WithAudit(audit.NewMemoryStore(), nil))
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("PUT", "/user?id=1", `{"name":"John Updated","email":"john@example.com"}`, "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d updating user, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := serve("POST", "/users/1/disable", "", "secret"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d disabling user, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := serve("DELETE", "/user?id=2", "", "secret"); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d deleting user, got %d", http.StatusNoContent, rr.Code)
+	}
+
+	var page struct {
+		Entries    []audit.Entry `json:"entries"`
+		NextBefore int64         `json:"next_before"`
+	}
+	rr := serve("GET", "/admin/audit?user_id=1&limit=1", "", "secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d reading the audit log, got %d", http.StatusOK, rr.Code)
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode audit log: %v", err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Action != audit.ActionDisable || page.Entries[0].Actor != middleware.AdminActor {
+		t.Fatalf("Expected the admin's disable first, got %+v", page.Entries)
+	}
+	if page.Entries[0].RequestID == "" {
+		t.Error("Expected the audit entry to carry the request ID")
+	}
+
+	if page.NextBefore != page.Entries[0].ID {
+		t.Fatalf("Expected next_before %d on a full page, got %d", page.Entries[0].ID, page.NextBefore)
+	}
+
+	rr = serve("GET", "/admin/audit?user_id=1&limit=1&before="+strconv.FormatInt(page.NextBefore, 10), "", "secret")
+	page.Entries, page.NextBefore = nil, 0
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode audit log: %v", err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Action != audit.ActionUpdate || page.Entries[0].Actor != middleware.AdminActor {
+		t.Errorf("Expected the admin's update on the second page, got %+v", page.Entries)
+	}
+
+	if rr := serve("GET", "/admin/audit?limit=0", "", "secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid limit, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := serve("GET", "/admin/audit", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without admin token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	queue := outbox.NewMemoryStore()
+	hooks := webhooks.NewMemoryStore()
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		services.WithOutbox(queue, nil), services.WithWebhooks(hooks))
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The partner endpoint accepts only correctly signed deliveries
+	var received atomic.Int32
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhooks.SignatureHeader) != webhooks.Sign("partner-secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received.Add(1)
+	}))
+	defer partner.Close()
+
+	if rr := serve("POST", "/admin/webhooks", `{"url":"`+partner.URL+`","secret":"partner-secret"}`, ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without admin token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	rr := serve("POST", "/admin/webhooks", `{"url":"`+partner.URL+`","secret":"partner-secret","event_types":["user.deleted"]}`, "secret")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d creating webhook, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var webhook webhooks.Webhook
+	if err := json.NewDecoder(rr.Body).Decode(&webhook); err != nil {
+		t.Fatalf("Failed to decode webhook: %v", err)
+	}
+
+	if rr := serve("DELETE", "/user?id=2", "", "secret"); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d deleting user, got %d", http.StatusNoContent, rr.Code)
+	}
+	if err := outbox.NewDispatcher(queue, webhooks.NewPublisher(hooks), metricsCollector, outbox.DefaultInterval).Tick(ctx); err != nil {
+		t.Fatalf("Failed to dispatch outbox: %v", err)
+	}
+	if err := webhooks.NewWorker(hooks, metricsCollector, cfg.WebhookMaxFailures, webhooks.DefaultInterval).Tick(ctx); err != nil {
+		t.Fatalf("Failed to deliver webhooks: %v", err)
+	}
+	if received.Load() != 1 {
+		t.Fatalf("Expected the partner to receive 1 signed delivery, got %d", received.Load())
+	}
+
+	rr = serve("GET", "/admin/webhooks/"+strconv.FormatInt(webhook.ID, 10)+"/deliveries", "", "secret")
+	var page struct {
+		Deliveries []webhooks.Delivery `json:"deliveries"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode deliveries: %v", err)
+	}
+	if len(page.Deliveries) != 1 || page.Deliveries[0].Status != webhooks.StatusSucceeded || page.Deliveries[0].EventType != events.TypeUserDeleted {
+		t.Errorf("Expected one succeeded user.deleted delivery, got %+v", page.Deliveries)
+	}
+
+	if rr := serve("DELETE", "/admin/webhooks/"+strconv.FormatInt(webhook.ID, 10), "", "secret"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d deleting webhook, got %d", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestRequestIDCorrelation(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	queue := outbox.NewMemoryStore()
+	hooks := webhooks.NewMemoryStore()
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		services.WithOutbox(queue, nil), services.WithWebhooks(hooks))
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, body, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("X-Request-ID", requestID)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The ID a client sent comes back in the error body
+	rr := serve("POST", "/users", `{"name":`, "ticket-1")
+	var failure struct {
+		Error struct {
+			Code      string `json:"code"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&failure); err != nil {
+		t.Fatalf("Failed to decode error body: %v", err)
+	}
+	if rr.Code != http.StatusBadRequest || failure.Error.Code != "BAD_REQUEST" || failure.Error.RequestID != "ticket-1" {
+		t.Errorf("Expected a 400 BAD_REQUEST for request ticket-1, got %d %+v", rr.Code, failure.Error)
+	}
+	if got := rr.Header().Get("X-Request-ID"); got != "ticket-1" {
+		t.Errorf("Expected X-Request-ID ticket-1, got %q", got)
+	}
+
+	// And in the event a successful request publishes, in the delivery's payload and headers
+	var header, payload string
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event events.Event
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		header, payload = r.Header.Get("X-Request-ID"), event.RequestID
+	}))
+	defer partner.Close()
+	if _, err := webhooks.Register(ctx, hooks, webhooks.Webhook{URL: partner.URL, Secret: "partner-secret"}); err != nil {
+		t.Fatalf("Failed to register webhook: %v", err)
+	}
+
+	if rr := serve("DELETE", "/user?id=2", "", "ticket-2"); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d deleting user, got %d", http.StatusNoContent, rr.Code)
+	}
+	if err := outbox.NewDispatcher(queue, webhooks.NewPublisher(hooks), metricsCollector, outbox.DefaultInterval).Tick(ctx); err != nil {
+		t.Fatalf("Failed to dispatch outbox: %v", err)
+	}
+	if err := webhooks.NewWorker(hooks, metricsCollector, cfg.WebhookMaxFailures, webhooks.DefaultInterval).Tick(ctx); err != nil {
+		t.Fatalf("Failed to deliver webhooks: %v", err)
+	}
+	if payload != "ticket-2" || header != "ticket-2" {
+		t.Errorf("Expected the delivery to carry request ticket-2, got %q in the payload and %q in X-Request-ID", payload, header)
+	}
+}
+
+func TestJSONFieldCase(t *testing.T) {
+	getUser := func(fieldCase string) map[string]interface{} {
+		reg := prometheus.NewRegistry()
+		metricsCollector := metrics.New(reg, reg)
+		userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+		cfg := config.Load()
+		cfg.JSONFieldCase = fieldCase
+		handler := SetupRoutes(userService, metricsCollector, cfg)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/user?id=1", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		var user map[string]interface{}
+		if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+			t.Fatalf("Failed to decode user: %v", err)
+		}
+		return user
+	}
+
+	snake, camel := getUser("snake"), getUser("camel")
+	if _, ok := snake["created_at"]; !ok {
+		t.Errorf("Expected created_at by default, got %v", snake)
+	}
+	if _, ok := camel["createdAt"]; !ok {
+		t.Errorf("Expected createdAt with camel keys, got %v", camel)
+	}
+	if _, ok := camel["created_at"]; ok {
+		t.Errorf("Expected no created_at with camel keys, got %v", camel)
+	}
+	if camel["id"] != snake["id"] || camel["email"] != snake["email"] {
+		t.Errorf("Expected the same user in either case, got %v and %v", snake, camel)
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	at := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+	repo := repository.NewInMemoryRepository(models.User{ID: 1, Name: "John Doe", Email: "john@example.com", CreatedAt: at, UpdatedAt: at})
+	handler := SetupRoutes(services.NewUserService(repo, metricsCollector), metricsCollector, config.Load())
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{
+			target: "/users",
+			want: `{"total":1,"users":[{"id":1,"name":"John Doe","email":"john@example.com","role":"user","status":"active",` +
+				`"created_at":"2024-03-01T12:30:00Z","updated_at":"2024-03-01T12:30:00Z"}]}` + "\n",
+		},
+		{
+			target: "/v2/users",
+			want: `{"total":1,"users":[{"id":1,"name":"John Doe","email":"john@example.com","role":"user","status":"active",` +
+				`"createdAt":"2024-03-01T12:30:00Z","updatedAt":"2024-03-01T12:30:00Z"}]}` + "\n",
+		},
+		{
+			target: "/v2/users?pretty=true",
+			want: `{
+  "total": 1,
+  "users": [
+    {
+      "id": 1,
+      "name": "John Doe",
+      "email": "john@example.com",
+      "role": "user",
+      "status": "active",
+      "createdAt": "2024-03-01T12:30:00Z",
+      "updatedAt": "2024-03-01T12:30:00Z"
+    }
+  ]
+}
+`,
+		},
+		{
+			target: "/v2/user?id=1",
+			want: `{"id":1,"name":"John Doe","email":"john@example.com","role":"user","status":"active",` +
+				`"createdAt":"2024-03-01T12:30:00Z","updatedAt":"2024-03-01T12:30:00Z"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", tt.target, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status %d, got %d: %s", tt.target, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if got := rr.Body.String(); got != tt.want {
+			t.Errorf("GET %s: expected body %s, got %s", tt.target, tt.want, got)
+		}
+	}
+
+	// Errors answer in the version's case too, on the routes it does not serve as well
+	failures := []struct {
+		method, target string
+		wantStatus     int
+	}{
+		{"POST", "/v2/users", http.StatusUnauthorized},
+		{"GET", "/v2/users/export", http.StatusNotFound},
+	}
+	for _, tt := range failures {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{}`)))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.target, tt.wantStatus, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), `"requestId"`) {
+			t.Errorf("%s %s: expected camelCase error keys, got %s", tt.method, tt.target, rr.Body.String())
+		}
+	}
+}
+
+func TestQueryParamRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	handler := SetupRoutes(userService, metricsCollector, config.Load())
+
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{"/user?id=1&pretty=true", http.StatusOK},
+		{"/user?id=1&id=2", http.StatusBadRequest},
+		{"/user?ID=1", http.StatusBadRequest},
+		{"/users?role=admin&created_after=2000-01-01T00:00:00Z", http.StatusOK},
+		{"/users?created_after=yesterday", http.StatusBadRequest},
+		{"/users?limit=10", http.StatusBadRequest},
+		// Routes that declare no parameters are left alone
+		{"/users/count?anything=1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", tt.target, nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("GET %s: expected status %d, got %d: %s", tt.target, tt.wantStatus, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestHeadUserRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	server := httptest.NewServer(SetupRoutes(userService, metricsCollector, config.Load()))
+	defer server.Close()
+
+	for target, wantStatus := range map[string]int{"/user?id=1": http.StatusOK, "/user?id=999": http.StatusNotFound} {
+		resp, err := http.Head(server.URL + target)
+		if err != nil {
+			t.Fatalf("HEAD %s: %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Errorf("HEAD %s: expected status %d, got %d", target, wantStatus, resp.StatusCode)
+		}
+		if len(body) != 0 {
+			t.Errorf("HEAD %s: expected no body, got %q", target, body)
+		}
+	}
+}
+
+func TestGraphQLRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+
+	serve := func(handler http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ user(id: 2) { email } }"}`))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve(SetupRoutes(userService, metricsCollector, cfg)); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d with GraphQL disabled, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	cfg.EnableGraphQL = true
+	rr := serve(SetupRoutes(userService, metricsCollector, cfg))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d with GraphQL enabled, got %d", http.StatusOK, rr.Code)
+	}
+	if want := `{"data":{"user":{"email":"jane@example.com"}}}`; strings.TrimSpace(rr.Body.String()) != want {
+		t.Errorf("Expected body %s, got %s", want, rr.Body.String())
+	}
+}
+
+func TestEventStreamRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	broker := events.NewBroker()
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		services.WithEventPublisher(broker))
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+
+	rr := httptest.NewRecorder()
+	SetupRoutes(userService, metricsCollector, cfg).ServeHTTP(rr, httptest.NewRequest("GET", "/users/events", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without an event stream, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Stream through the whole middleware chain, which must pass flushes through
+	server := httptest.NewServer(SetupRoutes(userService, metricsCollector, cfg, WithEventStream(broker)))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/users/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	readFrame := func() string {
+		var frame strings.Builder
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read event stream: %v", err)
+			}
+			if line == "\n" {
+				return frame.String()
+			}
+			frame.WriteString(line)
+		}
+	}
+	if frame := readFrame(); frame != ": subscribed\n" {
+		t.Fatalf("Expected the subscribed comment, got %q", frame)
+	}
+
+	req, err := http.NewRequest("POST", server.URL+"/users", strings.NewReader(`{"name":"Grace Hopper","email":"grace@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	created, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Body.Close()
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d creating a user, got %d", http.StatusCreated, created.StatusCode)
+	}
+
+	frame := readFrame()
+	if !strings.HasPrefix(frame, "event: user.created\ndata: ") || !strings.Contains(frame, `"email":"grace@example.com"`) {
+		t.Errorf("Expected a user.created frame for the new user, got %q", frame)
+	}
+}
+
+func TestImportRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	store := audit.NewMemoryStore()
+	userService := services.NewUserService(repository.NewInMemoryRepository(), metricsCollector, services.WithAudit(store, nil))
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	cfg.ImportMaxBytes = 1024
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(csv, token string) *httptest.ResponseRecorder {
+		var body strings.Builder
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("file", "users.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(file, csv)
+		form.Close()
+
+		req := httptest.NewRequest("POST", "/admin/users/import", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("name,email\nAnn,ann@example.com\n", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without admin token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := serve("name,email\n"+strings.Repeat("Ann,ann@example.com\n", 100), "secret"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d above the configured limit, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+
+	rr := serve("name,email\nAnn,ann@example.com\n", "secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d importing users, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	entries, err := store.List(context.Background(), audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != audit.ActionCreate || entries[0].Actor != middleware.AdminActor {
+		t.Errorf("Expected the admin's import in the audit log, got %+v", entries)
+	}
+
+	// POST /users/import takes a raw CSV body from callers with the admin role
+	importCSV := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users/import?mode=atomic", strings.NewReader("name,email\nBob,bob@example.com\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := importCSV(""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d importing CSV without a caller, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := importCSV("secret"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"imported":1`) {
+		t.Errorf("Expected the admin's CSV import to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWriteRoutesRequireAdmin(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, body, token string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	writes := []struct {
+		method, target, body string
+		expected             int
+	}{
+		{"POST", "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`, http.StatusCreated},
+		{"PUT", "/user?id=1", `{"name":"John Updated","email":"john@example.com"}`, http.StatusOK},
+		{"DELETE", "/user?id=2", "", http.StatusNoContent},
+	}
+	for _, w := range writes {
+		if code := serve(w.method, w.target, w.body, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status %d without a caller, got %d", w.method, w.target, http.StatusUnauthorized, code)
+		}
+		if code := serve(w.method, w.target, w.body, "guess"); code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status %d with a wrong token, got %d", w.method, w.target, http.StatusUnauthorized, code)
+		}
+		if code := serve(w.method, w.target, w.body, "secret"); code != w.expected {
+			t.Errorf("%s %s: expected status %d for an admin, got %d", w.method, w.target, w.expected, code)
+		}
+	}
+
+	// Reads stay open
+	for _, target := range []string{"/user?id=1", "/users", "/users/count"} {
+		if code := serve("GET", target, "", ""); code != http.StatusOK {
+			t.Errorf("GET %s: expected status %d without a caller, got %d", target, http.StatusOK, code)
+		}
+	}
+}
+
+func TestRateLimitClasses(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	cfg.RateLimit.Read = config.RateBudget{RequestsPerSecond: 0.001, BurstSize: 3}
+	cfg.RateLimit.Write = config.RateBudget{RequestsPerSecond: 0.001, BurstSize: 1}
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	serve := func(method, target, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// A burst of writes uses up only the write budget
+	if code := serve("POST", "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`); code != http.StatusCreated {
+		t.Fatalf("Expected status %d creating a user, got %d", http.StatusCreated, code)
+	}
+	if code := serve("POST", "/users", `{"name":"Grace Hopper","email":"grace@example.com"}`); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d once writes are used up, got %d", http.StatusTooManyRequests, code)
+	}
+	for range 3 {
+		if code := serve("GET", "/users", ""); code != http.StatusOK {
+			t.Fatalf("Expected reads to succeed while writes are limited, got %d", code)
+		}
+	}
+	if code := serve("GET", "/users", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d once reads are used up, got %d", http.StatusTooManyRequests, code)
+	}
+
+	// Health checks and scrapes are exempt
+	for _, target := range []string{"/health", "/readyz", "/livez", "/metrics"} {
+		if code := serve("GET", target, ""); code == http.StatusTooManyRequests {
+			t.Errorf("Expected %s to be exempt from rate limiting", target)
+		}
+	}
+}
+
+func TestEmailAvailabilityRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+
+	t.Run("has a tighter budget than other reads", func(t *testing.T) {
+		cfg := config.Load()
+		cfg.EmailAvailability.RateLimit = config.RateBudget{RequestsPerSecond: 0.001, BurstSize: 2}
+		cache := middleware.NewMicroCache(time.Minute)
+		handler := SetupRoutes(userService, metricsCollector, cfg, WithEmailAvailabilityCache(cache))
+
+		serve := func(target string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+			return rr
+		}
+
+		if rr := serve("/users/email-available?email=john@example.com"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"available":false`) {
+			t.Fatalf("Expected john@example.com to be taken, got %d %s", rr.Code, rr.Body.String())
+		}
+		// Answered from the cache, which still counts against the budget
+		if rr := serve("/users/email-available?email=john@example.com"); rr.Code != http.StatusOK || rr.Header().Get("X-Cache") != "HIT" {
+			t.Fatalf("Expected a cached answer, got %d with X-Cache %q", rr.Code, rr.Header().Get("X-Cache"))
+		}
+		if rr := serve("/users/email-available?email=new@example.com"); rr.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status %d once the budget is used up, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+		if rr := serve("/users/count"); rr.Code != http.StatusOK {
+			t.Errorf("Expected other reads to keep succeeding, got %d", rr.Code)
+		}
+	})
+
+	t.Run("can be turned off", func(t *testing.T) {
+		cfg := config.Load()
+		cfg.EmailAvailability.Enabled = false
+		handler := SetupRoutes(userService, metricsCollector, cfg)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users/email-available?email=john@example.com", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d with the route turned off, got %d", http.StatusNotFound, rr.Code)
+		}
+	})
+}
+
+func TestCORSOrigins(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	for _, method := range []string{"GET", "OPTIONS"} {
+		req := httptest.NewRequest(method, "/users", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("%s: expected the origin to be echoed, got %q", method, got)
+		}
+		if got := rr.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s: expected Vary to start with Origin, got %q", method, got)
+		}
+	}
+}
+
+func TestListCacheRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	listCache := middleware.NewMicroCache(time.Minute)
+	invalidate := events.PublisherFunc(func(context.Context, events.Event) error {
+		listCache.Invalidate()
+		return nil
+	})
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		services.WithEventPublisher(invalidate))
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg, WithListCache(listCache))
+
+	list := func() (string, int) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+		var response struct {
+			Total int `json:"total"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode users: %v", err)
+		}
+		return rr.Header().Get("X-Cache"), response.Total
+	}
+
+	if cacheStatus, total := list(); cacheStatus != "MISS" || total != 4 {
+		t.Fatalf("Expected a miss listing 4 users, got %s listing %d", cacheStatus, total)
+	}
+	if cacheStatus, total := list(); cacheStatus != "HIT" || total != 4 {
+		t.Fatalf("Expected a hit listing 4 users, got %s listing %d", cacheStatus, total)
+	}
+
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"Ada Lovelace","email":"ada@example.com"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d creating a user, got %d", http.StatusCreated, rr.Code)
+	}
+
+	// The created user's event invalidated the cached list
+	if cacheStatus, total := list(); cacheStatus != "MISS" || total != 5 {
+		t.Errorf("Expected a miss listing 5 users after the create, got %s listing %d", cacheStatus, total)
+	}
+}
+
+func TestIdempotentCreateRoute(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	repo := repository.NewInMemoryRepository()
+	userService := services.NewUserService(repo, metricsCollector)
+	cfg := config.Load()
+	cfg.AdminToken = "secret"
+	handler := SetupRoutes(userService, metricsCollector, cfg,
+		WithIdempotency(cache.NewMemory[string, middleware.IdempotentResponse](100, time.Hour)))
+
+	create := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	count := func() int {
+		n, err := repo.Count(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	const ada = `{"name":"Ada Lovelace","email":"ada@example.com"}`
+	first := create("retry-1", ada)
+	second := create("retry-1", ada)
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+		t.Fatalf("Expected both attempts to answer 201, got %d and %d: %s", first.Code, second.Code, second.Body.String())
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("Expected the retry to return the original body %s, got %s", first.Body.String(), second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected the retry to be marked as replayed")
+	}
+	if n := count(); n != 1 {
+		t.Errorf("Expected one user after a retried create, got %d", n)
+	}
+
+	// Another key is another create
+	if rr := create("retry-2", `{"name":"Grace Hopper","email":"grace@example.com"}`); rr.Code != http.StatusCreated {
+		t.Errorf("Expected a create under another key to answer 201, got %d", rr.Code)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected two users after creates under two keys, got %d", n)
+	}
+
+	// Without a key a repeat is a duplicate
+	if rr := create("", ada); rr.Code != http.StatusConflict {
+		t.Errorf("Expected a repeat without a key to conflict, got %d", rr.Code)
+	}
+}
+
+// stalledWriter holds every write until release is closed, as a slow client does
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.writing) })
+	<-w.release
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestExportConcurrencyLimit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	cfg := config.Load()
+	cfg.ConcurrencyLimits = map[string]int{"GET /users/export": 2}
+	cfg.ConcurrencyQueueTimeout = 10 * time.Millisecond
+	handler := SetupRoutes(userService, metricsCollector, cfg)
+
+	// Hold as many exports open as the limit allows
+	release := make(chan struct{})
+	done := make(chan int, 2)
+	for range 2 {
+		w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: release}
+		go func() {
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/users/export", nil))
+			done <- w.Code
+		}()
+		<-w.writing
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users/export", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a third export to get %d with Retry-After once it waited too long, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	// Other routes, including the CSV export, are not held back
+	for _, target := range []string{"/users", "/users/count", "/users/export.csv"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET %s: expected status %d while exports are saturated, got %d", target, http.StatusOK, rr.Code)
+		}
+	}
+
+	close(release)
+	for range 2 {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("Expected a held export to finish with %d, got %d", http.StatusOK, code)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	newConfig := func() *config.Config {
+		cfg := config.Load()
+		cfg.DBBackend = "memory"
+		cfg.Port = "127.0.0.1:0"
+		cfg.GRPC.Port = "127.0.0.1:0"
+		return cfg
+	}
+
+	t.Run("serves the routes and shuts down", func(t *testing.T) {
+		service, err := New(newConfig(), Deps{Registry: prometheus.NewRegistry()})
+		if err != nil {
+			t.Fatalf("Failed to set up the service: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		service.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/user?id=1", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected a seed user with status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		started := make(chan error, 1)
+		go func() { started <- service.Start(ctx) }()
+		cancel()
+		if err := <-started; err != nil {
+			t.Errorf("Expected Start to return nil once its context is done, got %v", err)
+		}
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		if err := service.Shutdown(shutdownCtx); err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	})
+
+	t.Run("unknown storage backend", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.DBBackend = "sqlite"
+		if _, err := New(cfg, Deps{Registry: prometheus.NewRegistry()}); err == nil || !strings.Contains(err.Error(), `"sqlite"`) {
+			t.Errorf("Expected an unknown backend error, got %v", err)
+		}
+	})
+
+	t.Run("port in use", func(t *testing.T) {
+		busy, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer busy.Close()
+		cfg := newConfig()
+		cfg.Port = busy.Addr().String()
+
+		service, err := New(cfg, Deps{Registry: prometheus.NewRegistry()})
+		if err != nil {
+			t.Fatalf("Failed to set up the service: %v", err)
+		}
+		if err := service.Start(context.Background()); err == nil {
+			t.Error("Expected Start to fail on a port in use")
+		}
+		if err := service.Shutdown(context.Background()); err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	})
+}
+
+func TestMetricsNamespace(t *testing.T) {
+	for _, namespace := range []string{"", "user_service"} {
+		t.Run("namespace "+namespace, func(t *testing.T) {
+			cfg := config.Load()
+			cfg.DBBackend = "memory"
+			cfg.Metrics.Namespace = namespace
+			service, err := New(cfg, Deps{Registry: prometheus.NewRegistry()})
+			if err != nil {
+				t.Fatalf("Failed to set up the service: %v", err)
+			}
+			defer service.Shutdown(context.Background())
+			handler := service.Handler()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/user?id=1", nil))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+
+			var names []string
+			for _, line := range strings.Split(rr.Body.String(), "\n") {
+				if fields := strings.Fields(line); len(fields) >= 3 && fields[1] == "TYPE" {
+					names = append(names, fields[2])
+				}
+			}
+			if len(names) == 0 {
+				t.Fatal("Expected the scrape to expose metrics")
+			}
+			for _, name := range names {
+				// The Go runtime and process metrics keep their standard names
+				if strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") {
+					continue
+				}
+				prefixed := strings.HasPrefix(name, "user_service_")
+				if prefixed != (namespace != "") {
+					t.Errorf("Expected %s to be prefixed with user_service_ only when METRICS_NAMESPACE is set", name)
+				}
+			}
+			if namespace != "" && !strings.Contains(rr.Body.String(), namespace+"_http_requests_total") {
+				t.Errorf("Expected %s_http_requests_total in the scrape", namespace)
+			}
+		})
+	}
+}
+
+func TestUnmatchedRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	handler := SetupRoutes(userService, metricsCollector, config.Load())
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+		wantAllow  string
+	}{
+		{"unknown path", "GET", "/userz", http.StatusNotFound, "ROUTE_NOT_FOUND", ""},
+		{"trailing slash", "GET", "/users/", http.StatusNotFound, "ROUTE_NOT_FOUND", ""},
+		{"wrong method", "POST", "/admin/audit", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "GET, HEAD"},
+		{"wrong method on a method route", "DELETE", "/users/export", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "GET, HEAD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Expected a JSON error, got Content-Type %q", contentType)
+			}
+			if tt.wantAllow != "" && rr.Header().Get("Allow") != tt.wantAllow {
+				t.Errorf("Expected Allow %q, got %q", tt.wantAllow, rr.Header().Get("Allow"))
+			}
+			var body struct {
+				Error struct {
+					Code      string `json:"code"`
+					Message   string `json:"message"`
+					RequestID string `json:"request_id"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode error body: %v", err)
+			}
+			if body.Error.Code != tt.wantCode {
+				t.Errorf("Expected code %s, got %s", tt.wantCode, body.Error.Code)
+			}
+			if body.Error.RequestID == "" || body.Error.RequestID != rr.Header().Get("X-Request-ID") {
+				t.Errorf("Expected the request ID %q in the body, got %q", rr.Header().Get("X-Request-ID"), body.Error.RequestID)
+			}
+			if strings.Contains(body.Error.Message, tt.path) {
+				t.Errorf("Expected the message not to echo the path, got %q", body.Error.Message)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `http_requests_total{endpoint="unmatched",method="GET",status_code="404"} 2`; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("Expected unknown paths to be recorded as %s", want)
+	}
+}
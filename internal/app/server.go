@@ -0,0 +1,428 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"user-service/internal/audit"
+	"user-service/internal/cache"
+	"user-service/internal/config"
+	"user-service/internal/database"
+	"user-service/internal/events"
+	usergrpc "user-service/internal/grpc"
+	"user-service/internal/health"
+	"user-service/internal/lifecycle"
+	"user-service/internal/metrics"
+	"user-service/internal/middleware"
+	"user-service/internal/models"
+	"user-service/internal/outbox"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+	"user-service/internal/webhooks"
+)
+
+// Deps are the dependencies New would otherwise build from the configuration, for
+// tests and programs embedding the service. Any left nil are built.
+type Deps struct {
+	// Registry registers and exposes the Prometheus metrics instead of the default
+	// registry. It is unused with the StatsD backend.
+	Registry *prometheus.Registry
+	// DB is the primary database of the postgres backend instead of a connection to
+	// DATABASE_URL. Shutdown leaves it open. Transactions still run on a pool of
+	// connections to DATABASE_URL.
+	DB *pgx.Conn
+}
+
+// App is the user service: its HTTP and gRPC servers, the user storage behind
+// them and the background workers dispatching its events
+type App struct {
+	cfg         *config.Config
+	metrics     metrics.Recorder
+	promMetrics *metrics.Metrics
+	service     *services.UserService
+	broker      *events.Broker
+	queue       outbox.Store
+	hooks       webhooks.Store
+	publisher   events.EventPublisher
+	handler     http.Handler
+	server      *http.Server
+	grpcServer  *grpc.Server
+	components  *lifecycle.Coordinator
+	// heartbeats watches the background workers for /livez
+	heartbeats *health.Heartbeats
+	// poolStats keeps the transaction pool gauges current; nil without postgres
+	poolStats *database.PoolStats
+	// closers release the connections New opened, after the servers have drained
+	closers []func()
+}
+
+// New builds the service cfg describes, without serving it or starting its
+// workers. Routes are added in SetupRoutes, which New serves.
+func New(cfg *config.Config, deps Deps) (*App, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	models.MaxUserID = cfg.MaxUserID
+	models.AllowedEmailDomains = cfg.AllowedEmailDomains
+	models.GravatarFallback = cfg.GravatarFallback
+
+	a := &App{cfg: cfg, components: lifecycle.New(), heartbeats: health.NewHeartbeats(cfg.HeartbeatTimeout)}
+	if err := a.build(deps); err != nil {
+		_ = a.components.Shutdown(context.Background())
+		a.close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// build wires up the service, leaving what it opened in a.closers for New to
+// release when it fails
+func (a *App) build(deps Deps) error {
+	cfg := a.cfg
+
+	// Initialize metrics
+	metricsOpts := metrics.Options{
+		Namespace:   cfg.Metrics.Namespace,
+		Subsystem:   cfg.Metrics.Subsystem,
+		HTTPBuckets: cfg.Metrics.HTTPBuckets,
+		DBBuckets:   cfg.Metrics.DBBuckets,
+	}
+	if cfg.Metrics.Backend == "statsd" {
+		statsd, err := metrics.NewStatsD(cfg.Metrics.StatsDAddr, cfg.Metrics.StatsDFlushInterval, metricsOpts)
+		if err != nil {
+			return fmt.Errorf("failed to set up StatsD metrics at %s: %w", cfg.Metrics.StatsDAddr, err)
+		}
+		a.metrics = statsd
+	} else {
+		var reg prometheus.Registerer
+		var gatherer prometheus.Gatherer
+		if deps.Registry != nil {
+			reg, gatherer = deps.Registry, deps.Registry
+		}
+		metricsOpts.Heartbeat = a.heartbeats.Register("uptime")
+		a.promMetrics = metrics.NewWithOptions(reg, gatherer, metricsOpts)
+		a.metrics = a.promMetrics
+	}
+	slog.Info("Metrics initialized", "backend", cfg.Metrics.Backend)
+
+	// Background components are stopped on shutdown, the last started first
+	a.components.Register("metrics", a.metrics)
+
+	// Dependencies register their readiness checks; /readyz adds the user service's own
+	checks := health.NewCheckRegistry()
+
+	// Initialize user storage
+	var repo repository.UserRepository
+	var serviceOpts []services.Option
+	switch cfg.DBBackend {
+	case "memory":
+		repo = repository.NewInMemoryRepository(repository.SeedUsers()...)
+		a.queue = outbox.NewMemoryStore()
+		a.hooks = webhooks.NewMemoryStore()
+		serviceOpts = append(serviceOpts,
+			services.WithAudit(audit.NewMemoryStore(), nil),
+			services.WithOutbox(a.queue, nil),
+		)
+		slog.Info("Using in-memory user storage")
+	case "postgres":
+		// Statements are logged at debug level, with their arguments only when DB_LOG_ARGS is set
+		queryLogger := database.NewQueryLogger(a.metrics, cfg.DBLogArgs)
+		if cfg.DBLogArgs {
+			slog.Warn("Logging database statement arguments; DB_LOG_ARGS is meant for development only")
+		}
+		var db database.Conn
+		if deps.DB != nil {
+			db = deps.DB
+		} else {
+			// A connection that breaks, as when Postgres restarts, is redialed rather
+			// than failing every request until the service is restarted
+			conn, err := database.NewReconnectingConn(func() (database.Conn, error) {
+				conn, err := database.NewConnection(cfg.DatabaseURL, queryLogger)
+				if err != nil {
+					return nil, err
+				}
+				return conn, nil
+			}, database.DefaultReconnectBackoff, a.metrics)
+			if err != nil {
+				return fmt.Errorf("failed to connect to database: %w", err)
+			}
+			a.closers = append(a.closers, func() { conn.Close(context.Background()) })
+			checks.Register(health.Check{Name: "database", Run: conn.Ping})
+			db = conn
+		}
+
+		// Each transaction holds a pooled connection of its own until it ends, so
+		// concurrent transactions never interleave their statements on db
+		pool, err := database.NewPool(cfg.DatabaseURL, cfg.DBMaxConns, queryLogger)
+		if err != nil {
+			return fmt.Errorf("failed to connect the database pool: %w", err)
+		}
+		a.closers = append(a.closers, pool.Close)
+		a.poolStats = database.NewPoolStats(database.PgxPoolStats(pool), a.metrics, database.DefaultPoolStatsInterval)
+
+		// Reads go to replicas when configured; an unreachable replica is skipped
+		var replicas []database.DBTX
+		for i, url := range cfg.DatabaseReplicaURLs {
+			replica, err := database.NewConnection(url, queryLogger)
+			if err != nil {
+				slog.Warn("Failed to connect to read replica, skipping it", "replica", i, "error", err)
+				continue
+			}
+			a.closers = append(a.closers, func() { replica.Close(context.Background()) })
+			replicas = append(replicas, replica)
+			// Reads fall back to the primary, so a replica outage only degrades the service.
+			// The check is named as the router labels the replica in its metrics.
+			checks.Register(health.Check{Name: "replica-" + strconv.Itoa(len(replicas)-1), Run: replica.Ping, Optional: true})
+		}
+
+		newRepo := func(db database.DBTX) repository.UserRepository {
+			return repository.NewPgxUserRepository(db, cfg.DBUsersTable)
+		}
+		if len(cfg.DatabaseReplicaURLs) > 0 {
+			slog.Info("Routing reads to replicas", "replicas", len(replicas))
+			repo = newRepo(database.NewRouter(db, replicas, a.metrics))
+		} else {
+			repo = newRepo(db)
+		}
+
+		// Multi-statement writes run in transactions on the primary, with their audit entries and events
+		a.queue = outbox.NewPgxStore(db)
+		a.hooks = webhooks.NewPgxStore(db)
+		serviceOpts = append(serviceOpts,
+			services.WithTxManager(database.NewTxManager(pool), newRepo),
+			services.WithAudit(audit.NewPgxStore(db), audit.NewPgxStore),
+			services.WithOutbox(a.queue, outbox.NewPgxStore),
+		)
+	default:
+		return fmt.Errorf("unknown storage backend %q", cfg.DBBackend)
+	}
+
+	// Create service, sharing the user cache and idempotency keys through Redis when configured
+	cacheOpt := services.WithCache(cfg.Cache.Size, cfg.Cache.TTL)
+	var idempotencyKeys cache.Cache[string, middleware.IdempotentResponse] = cache.NewMemory[string, middleware.IdempotentResponse](cfg.Idempotency.Size, cfg.Idempotency.TTL)
+	if cfg.Cache.RedisAddr != "" {
+		redisClient := cache.NewRedisClient(cfg.Cache.RedisAddr)
+		a.closers = append(a.closers, func() { redisClient.Close() })
+		cacheOpt = services.WithRedisCache(redisClient, cfg.Cache.TTL)
+		idempotencyKeys = cache.NewRedis[string, middleware.IdempotentResponse](redisClient, "idempotency:", cfg.Idempotency.TTL)
+		checks.Register(health.Check{Name: "cache", Optional: true, Run: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}})
+		slog.Info("Using Redis user cache", "address", cfg.Cache.RedisAddr)
+	}
+	serviceOpts = append(serviceOpts, cacheOpt, services.WithWebhooks(a.hooks),
+		services.WithQueryLimits(cfg.DBQueryTimeout, cfg.DBSlowQueryThreshold))
+
+	// Subscribe the configured endpoint to user creations
+	if cfg.WebhookURL != "" {
+		hook := webhooks.Webhook{URL: cfg.WebhookURL, Secret: cfg.WebhookSecret, EventTypes: []string{events.TypeUserCreated}}
+		if _, err := webhooks.Register(context.Background(), a.hooks, hook); err != nil {
+			return fmt.Errorf("failed to register WEBHOOK_URL: %w", err)
+		}
+		slog.Info("Notifying webhook of created users", "url", cfg.WebhookURL)
+	}
+	a.service = services.NewUserService(repo, a.metrics, serviceOpts...)
+
+	// Dispatch queued user events to Kafka when a REST proxy is configured, otherwise just
+	// log them, to the subscribed webhooks and to gRPC watchers and SSE streams
+	publisher := events.NewLogPublisher()
+	if cfg.Events.KafkaURL != "" {
+		publisher = events.NewKafkaPublisher(cfg.Events.KafkaURL, cfg.Events.KafkaTopic, a.metrics)
+		// Undelivered events wait in the outbox, so the broker is optional too
+		checks.Register(health.Check{Name: "events", Run: events.KafkaCheck(cfg.Events.KafkaURL, cfg.Events.KafkaTopic), Optional: true})
+		slog.Info("Publishing user events to Kafka", "proxy", cfg.Events.KafkaURL, "topic", cfg.Events.KafkaTopic)
+	}
+	// Changes also drop the GET /users and GET /users/email-available responses
+	// cached on this replica. Other replicas serve theirs until LIST_CACHE_TTL or
+	// EMAIL_AVAILABILITY_CACHE_TTL passes.
+	a.broker = events.NewBroker()
+	listCache := middleware.NewMicroCache(cfg.ListCacheTTL)
+	emailCache := middleware.NewMicroCache(cfg.EmailAvailability.CacheTTL)
+	invalidate := events.PublisherFunc(func(context.Context, events.Event) error {
+		listCache.Invalidate()
+		emailCache.Invalidate()
+		return nil
+	})
+	a.publisher = events.NewMultiPublisher(publisher, webhooks.NewPublisher(a.hooks), a.broker, invalidate)
+
+	// Setup routes with middleware
+	routeOpts := []Option{WithEventStream(a.broker), WithListCache(listCache), WithEmailAvailabilityCache(emailCache), WithHealthChecks(checks),
+		WithHeartbeats(a.heartbeats)}
+	if cfg.Idempotency.TTL > 0 {
+		routeOpts = append(routeOpts, WithIdempotency(idempotencyKeys))
+	}
+	a.handler = SetupRoutes(a.service, a.metrics, cfg, routeOpts...)
+	slog.Info("Rate limiting requests",
+		"read_rps", cfg.RateLimit.Read.RequestsPerSecond, "read_burst", cfg.RateLimit.Read.BurstSize,
+		"write_rps", cfg.RateLimit.Write.RequestsPerSecond, "write_burst", cfg.RateLimit.Write.BurstSize)
+
+	// Configure servers
+	a.server = &http.Server{
+		Addr:           cfg.Port,
+		Handler:        a.handler,
+		ReadTimeout:    15 * time.Second,
+		WriteTimeout:   15 * time.Second,
+		IdleTimeout:    60 * time.Second,
+		MaxHeaderBytes: 1 << 20, // 1 MB
+	}
+	a.grpcServer = usergrpc.NewServer(a.service, a.broker, a.metrics, cfg.GRPC.AuthToken)
+	return nil
+}
+
+// Handler returns the HTTP routes wrapped in the middleware chain, to serve
+// without Start, as tests do with httptest. Events are only dispatched, and
+// GET /users responses cached by LIST_CACHE_TTL only invalidated, once started.
+func (a *App) Handler() http.Handler {
+	return a.handler
+}
+
+// Start serves HTTP on PORT and gRPC on GRPC_PORT and runs the background workers
+// until ctx is done or a server fails, returning the failure. Call Shutdown
+// afterwards either way.
+func (a *App) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", a.cfg.Port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", a.cfg.Port, err)
+	}
+	grpcListener, err := net.Listen("tcp", a.cfg.GRPC.Port)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("gRPC server failed to listen on %s: %w", a.cfg.GRPC.Port, err)
+	}
+
+	a.components.Go("outbox dispatcher", outbox.NewDispatcher(a.queue, a.publisher, a.metrics, outbox.DefaultInterval).Run)
+	a.components.Go("webhook worker", webhooks.NewWorker(a.hooks, a.metrics, a.cfg.WebhookMaxFailures, webhooks.DefaultInterval).Run)
+	if a.poolStats != nil {
+		a.components.Go("pool stats", a.poolStats.Run)
+	}
+
+	// Keep the active and deleted user gauges current, beating for /livez on each
+	// round whether or not the refresh worked
+	beat := a.heartbeats.Register("user gauges")
+	a.components.Go("user gauges", func(ctx context.Context) {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			if err := a.service.RefreshUserGauges(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to refresh user gauges", "error", err)
+			}
+			beat()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+
+	// End the gRPC watch calls and SSE streams before the servers drain, as neither ends on its own
+	a.components.Register("event streams", lifecycle.CloserFunc(func() error {
+		a.broker.Close()
+		return nil
+	}))
+
+	failed := make(chan error, 2)
+	go func() {
+		slog.Info("Server starting", "address", listener.Addr().String())
+		if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			failed <- fmt.Errorf("server failed: %w", err)
+		}
+	}()
+	// Serve the gRPC API on its own port
+	go func() {
+		slog.Info("gRPC server starting", "address", grpcListener.Addr().String())
+		if err := a.grpcServer.Serve(grpcListener); err != nil {
+			failed <- fmt.Errorf("gRPC server failed: %w", err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-failed:
+		return err
+	}
+}
+
+// Shutdown stops the background workers, lets in-flight requests and gRPC calls
+// drain until ctx is done and then closes the connections New opened. It returns
+// what failed to stop in time.
+func (a *App) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	// Stop the background components; queued events and deliveries are sent on the next start
+	if err := a.components.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Attempt graceful shutdown
+	if err := a.server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("server forced to shutdown: %w", err))
+	} else {
+		slog.Info("Server shutdown complete")
+	}
+
+	// Let in-flight gRPC calls drain, forcing the rest closed if they outlive the
+	// shutdown timeout
+	stopped := make(chan struct{})
+	go func() {
+		a.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		slog.Info("gRPC server shutdown complete")
+	case <-ctx.Done():
+		a.grpcServer.Stop()
+		errs = append(errs, fmt.Errorf("gRPC server forced to shutdown: %w", ctx.Err()))
+	}
+
+	a.summarizeMetrics()
+	a.close()
+	return errors.Join(errs...)
+}
+
+// summarizeMetrics sums up what this replica served, as the last scrape before it
+// stopped missed the final seconds, and pushes the final state when a Pushgateway
+// is configured
+func (a *App) summarizeMetrics() {
+	if a.promMetrics == nil {
+		return
+	}
+	snapshot, err := a.promMetrics.Snapshot()
+	if err != nil {
+		slog.Error("Failed to gather final metrics", "error", err)
+		return
+	}
+	slog.Info("Final request counts", "total", snapshot.TotalRequests(), "by_class", snapshot.Requests,
+		"max_in_flight", snapshot.MaxInFlight, "uptime", snapshot.Uptime)
+
+	if a.cfg.Metrics.PushgatewayURL != "" {
+		instance, _ := os.Hostname()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.promMetrics.Push(ctx, a.cfg.Metrics.PushgatewayURL, "user-service", instance); err != nil {
+			slog.Error("Failed to push final metrics", "error", err, "url", a.cfg.Metrics.PushgatewayURL)
+		} else {
+			slog.Info("Pushed final metrics", "url", a.cfg.Metrics.PushgatewayURL, "instance", instance)
+		}
+	}
+}
+
+// close releases the connections New opened, the last opened first
+func (a *App) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
+	}
+	a.closers = nil
+}
@@ -0,0 +1,83 @@
+// Package audit records who changed which user, and how, for compliance.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+)
+
+// Actions an entry can record
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionDisable = "disable"
+	ActionEnable  = "enable"
+)
+
+// AnonymousActor is recorded for requests without an authenticated caller
+const AnonymousActor = "anonymous"
+
+// Entry is one recorded mutation of a user. Before and After are JSON snapshots
+// of the user, or null when the user was not visible on that side of the change.
+type Entry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	RequestID string          `json:"request_id"`
+	Action    string          `json:"action"`
+	UserID    int             `json:"user_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// NewEntry builds an entry for action on userID, taking the caller and request ID from ctx
+func NewEntry(ctx context.Context, action string, userID int, before, after *models.User) (Entry, error) {
+	entry := Entry{
+		Actor:  AnonymousActor,
+		Action: action,
+		UserID: userID,
+	}
+	if caller, ok := reqctx.CallerFromContext(ctx); ok && caller.Subject != "" {
+		entry.Actor = caller.Subject
+	}
+	entry.RequestID = reqctx.RequestIDFromContext(ctx)
+
+	var err error
+	if entry.Before, err = snapshot(before); err != nil {
+		return Entry{}, err
+	}
+	if entry.After, err = snapshot(after); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// snapshot encodes user as JSON, or returns nil for no user
+func snapshot(user *models.User) (json.RawMessage, error) {
+	if user == nil {
+		return nil, nil
+	}
+	return json.Marshal(user)
+}
+
+// Filter selects a page of entries, newest first. Zero fields do not filter.
+type Filter struct {
+	UserID int
+	// Before is a cursor: only entries with a smaller ID are returned
+	Before int64
+	Limit  int
+}
+
+// Store keeps audit entries. Implementations must be safe for concurrent use.
+type Store interface {
+	// Record appends an entry; the store assigns its ID and timestamp
+	Record(ctx context.Context, entry Entry) error
+	// List returns the entries matching filter, newest first
+	List(ctx context.Context, filter Filter) ([]Entry, error)
+}
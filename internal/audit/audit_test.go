@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/middleware"
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+)
+
+func TestNewEntry(t *testing.T) {
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+
+	t.Run("anonymous without actor", func(t *testing.T) {
+		entry, err := NewEntry(context.Background(), ActionCreate, 1, nil, &john)
+		assert.NoError(t, err)
+		assert.Equal(t, AnonymousActor, entry.Actor)
+		assert.Empty(t, entry.RequestID)
+		assert.Nil(t, entry.Before)
+		assert.Contains(t, string(entry.After), `"name":"John Doe"`)
+	})
+
+	t.Run("actor and request id from context", func(t *testing.T) {
+		ctx := reqctx.WithCaller(context.Background(), reqctx.Caller{Subject: middleware.AdminActor})
+		ctx = reqctx.WithRequestID(ctx, "req-1")
+
+		entry, err := NewEntry(ctx, ActionDelete, 1, &john, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, Entry{
+			Actor:     middleware.AdminActor,
+			RequestID: "req-1",
+			Action:    ActionDelete,
+			UserID:    1,
+			Before:    entry.Before,
+		}, entry)
+		assert.Nil(t, entry.After)
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, entry := range []Entry{
+		{Action: ActionCreate, UserID: 1},
+		{Action: ActionCreate, UserID: 2},
+		{Action: ActionUpdate, UserID: 1},
+		{Action: ActionDelete, UserID: 1},
+	} {
+		assert.NoError(t, store.Record(ctx, entry))
+	}
+
+	entries, err := store.List(ctx, Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4, 3, 2, 1}, entryIDs(entries))
+	assert.False(t, entries[0].CreatedAt.IsZero())
+
+	entries, err = store.List(ctx, Filter{UserID: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4, 3}, entryIDs(entries))
+
+	entries, err = store.List(ctx, Filter{UserID: 1, Limit: 2, Before: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, entryIDs(entries))
+}
+
+func TestListEntriesQuery(t *testing.T) {
+	sql, args := ListEntriesQuery(Filter{})
+	assert.Equal(t, ListEntries+" ORDER BY id DESC", sql)
+	assert.Empty(t, args)
+
+	sql, args = ListEntriesQuery(Filter{UserID: 7, Before: 100, Limit: 50})
+	assert.Equal(t, ListEntries+" WHERE user_id = $1 AND id < $2 ORDER BY id DESC LIMIT $3", sql)
+	assert.Equal(t, []interface{}{7, int64(100), 50}, args)
+}
+
+func TestPgxStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("record", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Exec", ctx, InsertEntry, "admin", "req-1", ActionDelete, 1, []byte(`{"id":1}`), []byte(nil)).Return(pgconn.CommandTag("INSERT 0 1"), nil)
+
+		err := NewPgxStore(db).Record(ctx, Entry{Actor: "admin", RequestID: "req-1", Action: ActionDelete, UserID: 1, Before: []byte(`{"id":1}`)})
+		assert.NoError(t, err)
+		db.AssertExpectations(t)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(true).Once()
+		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
+		rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int64) = 9
+			*arg[1].(*string) = "admin"
+			*arg[3].(*string) = ActionUpdate
+			*arg[4].(*int) = 1
+			*arg[5].(*[]byte) = []byte(`{"id":1}`)
+			*arg[7].(*time.Time) = createdAt
+		})
+		sql, args := ListEntriesQuery(Filter{UserID: 1, Limit: 10})
+		db := &mocks.MockDBTX{}
+		db.On("Query", append([]interface{}{ctx, sql}, args...)...).Return(rows, nil)
+
+		entries, err := NewPgxStore(db).List(ctx, Filter{UserID: 1, Limit: 10})
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, int64(9), entries[0].ID)
+			assert.Equal(t, ActionUpdate, entries[0].Action)
+			assert.JSONEq(t, `{"id":1}`, string(entries[0].Before))
+			assert.Nil(t, entries[0].After)
+			assert.Equal(t, createdAt, entries[0].CreatedAt)
+		}
+		db.AssertExpectations(t)
+	})
+}
+
+func entryIDs(entries []Entry) []int64 {
+	ids := make([]int64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps entries in a slice, for tests and local development
+type memoryStore struct {
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// NewMemoryStore creates an empty in-memory audit store
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+// Record appends an entry with the next ID
+func (s *memoryStore) Record(_ context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = int64(len(s.entries) + 1)
+	entry.CreatedAt = time.Now()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// List returns the entries matching filter, newest first
+func (s *memoryStore) List(_ context.Context, filter Filter) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []Entry
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		entry := s.entries[i]
+		if filter.UserID != 0 && entry.UserID != filter.UserID {
+			continue
+		}
+		if filter.Before != 0 && entry.ID >= filter.Before {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
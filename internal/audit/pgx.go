@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"user-service/internal/database"
+)
+
+// Statements run against the audit_log table
+const (
+	InsertEntry = "INSERT INTO audit_log (actor, request_id, action, user_id, before, after) VALUES ($1, $2, $3, $4, $5, $6)"
+	ListEntries = "SELECT id, actor, request_id, action, user_id, before, after, created_at FROM audit_log"
+)
+
+// pgxStore keeps entries in the Postgres audit_log table
+type pgxStore struct {
+	db database.DBTX
+}
+
+// NewPgxStore creates a store backed by a database connection or transaction. Pass
+// the transaction of a mutation so its entry is only kept if the mutation commits.
+func NewPgxStore(db database.DBTX) Store {
+	return &pgxStore{db: db}
+}
+
+// Record inserts an entry; the database assigns its ID and timestamp
+func (s *pgxStore) Record(ctx context.Context, entry Entry) error {
+	_, err := s.db.Exec(ctx, InsertEntry, InsertEntryArgs(entry)...)
+	return err
+}
+
+// InsertEntryArgs returns the arguments for InsertEntry. Snapshots are passed as
+// []byte so a missing one is stored as NULL.
+func InsertEntryArgs(entry Entry) []interface{} {
+	return []interface{}{entry.Actor, entry.RequestID, entry.Action, entry.UserID, []byte(entry.Before), []byte(entry.After)}
+}
+
+// List returns the entries matching filter, newest first
+func (s *pgxStore) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	sql, args := ListEntriesQuery(filter)
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.RequestID, &entry.Action, &entry.UserID, &before, &after, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ListEntriesQuery returns the list query and its arguments for the set fields of filter
+func ListEntriesQuery(filter Filter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	condition := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, clause+" $"+strconv.Itoa(len(args)))
+	}
+
+	if filter.UserID != 0 {
+		condition("user_id =", filter.UserID)
+	}
+	if filter.Before != 0 {
+		condition("id <", filter.Before)
+	}
+
+	sql := ListEntries
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sql += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		sql += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return sql, args
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a key/value store for cached lookups. Implementations may be remote,
+// so every operation can fail and callers should fall back to the source of truth.
+type Cache[K comparable, V any] interface {
+	Get(ctx context.Context, key K) (V, bool, error)
+	Set(ctx context.Context, key K, value V) error
+	Delete(ctx context.Context, key K) error
+}
+
+// Memory is an in-process Cache backed by an LRU
+type Memory[K comparable, V any] struct {
+	lru *LRU[K, V]
+}
+
+// NewMemory creates an in-process cache holding at most size entries, each valid for ttl
+func NewMemory[K comparable, V any](size int, ttl time.Duration) *Memory[K, V] {
+	return &Memory[K, V]{lru: New[K, V](size, ttl)}
+}
+
+// Get returns the cached value for key if present and not expired
+func (m *Memory[K, V]) Get(_ context.Context, key K) (V, bool, error) {
+	value, ok := m.lru.Get(key)
+	return value, ok, nil
+}
+
+// Set stores value under key
+func (m *Memory[K, V]) Set(_ context.Context, key K, value V) error {
+	m.lru.Set(key, value)
+	return nil
+}
+
+// Delete removes key from the cache
+func (m *Memory[K, V]) Delete(_ context.Context, key K) error {
+	m.lru.Delete(key)
+	return nil
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	t.Run("get and set", func(t *testing.T) {
+		c := New[int, string](2, time.Minute)
+		c.Set(1, "one")
+
+		value, ok := c.Get(1)
+		assert.True(t, ok)
+		assert.Equal(t, "one", value)
+
+		_, ok = c.Get(2)
+		assert.False(t, ok)
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		c := New[int, string](2, time.Minute)
+		c.Set(1, "one")
+		c.Set(2, "two")
+		c.Get(1)
+		c.Set(3, "three")
+
+		_, ok := c.Get(2)
+		assert.False(t, ok)
+		_, ok = c.Get(1)
+		assert.True(t, ok)
+		_, ok = c.Get(3)
+		assert.True(t, ok)
+		assert.Equal(t, 2, c.Len())
+	})
+
+	t.Run("expires entries after ttl", func(t *testing.T) {
+		now := time.Now()
+		c := New[int, string](2, time.Second)
+		c.now = func() time.Time { return now }
+		c.Set(1, "one")
+
+		now = now.Add(999 * time.Millisecond)
+		_, ok := c.Get(1)
+		assert.True(t, ok)
+
+		now = now.Add(time.Millisecond)
+		_, ok = c.Get(1)
+		assert.False(t, ok)
+		assert.Equal(t, 0, c.Len())
+	})
+
+	t.Run("set refreshes existing entry", func(t *testing.T) {
+		c := New[int, string](2, time.Minute)
+		c.Set(1, "one")
+		c.Set(1, "uno")
+
+		value, ok := c.Get(1)
+		assert.True(t, ok)
+		assert.Equal(t, "uno", value)
+		assert.Equal(t, 1, c.Len())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c := New[int, string](2, time.Minute)
+		c.Set(1, "one")
+		c.Delete(1)
+		c.Delete(2)
+
+		_, ok := c.Get(1)
+		assert.False(t, ok)
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		c := New[int, string](50, time.Minute)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					key := (n*100 + j) % 75
+					c.Set(key, strconv.Itoa(key))
+					c.Get(key)
+					if j%10 == 0 {
+						c.Delete(key)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, c.Len(), 50)
+	})
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Cache shared between replicas. Values are stored as JSON under
+// prefix + key and expire after ttl.
+type Redis[K comparable, V any] struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisClient creates a client for addr with short timeouts and no retries,
+// so an unreachable Redis degrades lookups quickly instead of stalling requests
+func NewRedisClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         addr,
+		DialTimeout:  250 * time.Millisecond,
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+		MaxRetries:   -1,
+	})
+}
+
+// NewRedis creates a Redis-backed cache using the given key prefix
+func NewRedis[K comparable, V any](client *redis.Client, prefix string, ttl time.Duration) *Redis[K, V] {
+	return &Redis[K, V]{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// Get returns the cached value for key. A missing key is not an error.
+func (r *Redis[K, V]) Get(ctx context.Context, key K) (V, bool, error) {
+	var value V
+	data, err := r.client.Get(ctx, r.key(key)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return value, false, nil
+		}
+		return value, false, err
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, false, fmt.Errorf("failed to decode cached value: %w", err)
+	}
+	return value, true, nil
+}
+
+// Set stores value under key
+func (r *Redis[K, V]) Set(ctx context.Context, key K, value V) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode cached value: %w", err)
+	}
+	return r.client.Set(ctx, r.key(key), data, r.ttl).Err()
+}
+
+// Delete removes key from the cache
+func (r *Redis[K, V]) Delete(ctx context.Context, key K) error {
+	return r.client.Del(ctx, r.key(key)).Err()
+}
+
+func (r *Redis[K, V]) key(key K) string {
+	return fmt.Sprintf("%s%v", r.prefix, key)
+}
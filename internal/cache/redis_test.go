@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type cachedUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+
+	newCache := func(t *testing.T) (*Redis[int, cachedUser], *miniredis.Miniredis) {
+		server := miniredis.RunT(t)
+		client := NewRedisClient(server.Addr())
+		t.Cleanup(func() { _ = client.Close() })
+		return NewRedis[int, cachedUser](client, "user:", time.Minute), server
+	}
+
+	t.Run("miss", func(t *testing.T) {
+		c, _ := newCache(t)
+
+		_, ok, err := c.Get(ctx, 1)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("hit", func(t *testing.T) {
+		c, server := newCache(t)
+		assert.NoError(t, c.Set(ctx, 1, cachedUser{ID: 1, Name: "John Doe"}))
+
+		value, ok, err := c.Get(ctx, 1)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, cachedUser{ID: 1, Name: "John Doe"}, value)
+
+		stored, err := server.Get("user:1")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"id":1,"name":"John Doe"}`, stored)
+		assert.Equal(t, time.Minute, server.TTL("user:1"))
+	})
+
+	t.Run("expires entries after ttl", func(t *testing.T) {
+		c, server := newCache(t)
+		assert.NoError(t, c.Set(ctx, 1, cachedUser{ID: 1}))
+		server.FastForward(time.Minute)
+
+		_, ok, err := c.Get(ctx, 1)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c, _ := newCache(t)
+		assert.NoError(t, c.Set(ctx, 1, cachedUser{ID: 1}))
+		assert.NoError(t, c.Delete(ctx, 1))
+		assert.NoError(t, c.Delete(ctx, 2))
+
+		_, ok, err := c.Get(ctx, 1)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("undecodable value", func(t *testing.T) {
+		c, server := newCache(t)
+		assert.NoError(t, server.Set("user:1", "not json"))
+
+		_, ok, err := c.Get(ctx, 1)
+		assert.Error(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		c, server := newCache(t)
+		server.Close()
+
+		_, ok, err := c.Get(ctx, 1)
+		assert.Error(t, err)
+		assert.False(t, ok)
+		assert.Error(t, c.Set(ctx, 1, cachedUser{ID: 1}))
+		assert.Error(t, c.Delete(ctx, 1))
+	})
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	var c Cache[int, string] = NewMemory[int, string](2, time.Minute)
+
+	_, ok, err := c.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, c.Set(ctx, 1, "one"))
+	value, ok, err := c.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "one", value)
+
+	assert.NoError(t, c.Delete(ctx, 1))
+	_, ok, err = c.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"math"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -14,11 +17,166 @@ func TestLoad(t *testing.T) {
 	if cfg.LogLevel != "info" {
 		t.Errorf("Expected LogLevel to be info, got %s", cfg.LogLevel)
 	}
-	if cfg.RateLimit.RequestsPerSecond != 10.0 {
-		t.Errorf("Expected RateLimit.RequestsPerSecond to be 10.0, got %f", cfg.RateLimit.RequestsPerSecond)
+	if cfg.DBBackend != "postgres" {
+		t.Errorf("Expected DBBackend to be postgres, got %s", cfg.DBBackend)
 	}
-	if cfg.RateLimit.BurstSize != 20 {
-		t.Errorf("Expected RateLimit.BurstSize to be 20, got %d", cfg.RateLimit.BurstSize)
+	if cfg.DBUsersTable != "users" {
+		t.Errorf("Expected DBUsersTable to be users, got %s", cfg.DBUsersTable)
+	}
+	if cfg.DBMaxConns != 10 {
+		t.Errorf("Expected DBMaxConns to be 10, got %d", cfg.DBMaxConns)
+	}
+	if len(cfg.DatabaseReplicaURLs) != 0 {
+		t.Errorf("Expected no DatabaseReplicaURLs, got %v", cfg.DatabaseReplicaURLs)
+	}
+	for class, budget := range map[string]RateBudget{"Read": cfg.RateLimit.Read, "Write": cfg.RateLimit.Write} {
+		if budget != (RateBudget{RequestsPerSecond: 10.0, BurstSize: 20}) {
+			t.Errorf("Expected RateLimit.%s to be 10 per second with a burst of 20, got %+v", class, budget)
+		}
+	}
+	if cfg.RateLimit.Export != (RateBudget{RequestsPerSecond: 1.0, BurstSize: 5}) {
+		t.Errorf("Expected RateLimit.Export to be 1 per second with a burst of 5, got %+v", cfg.RateLimit.Export)
+	}
+	if cfg.Cache.TTL != 30*time.Second {
+		t.Errorf("Expected Cache.TTL to be 30s, got %s", cfg.Cache.TTL)
+	}
+	if cfg.Cache.Size != 1000 {
+		t.Errorf("Expected Cache.Size to be 1000, got %d", cfg.Cache.Size)
+	}
+	if cfg.Cache.RedisAddr != "" {
+		t.Errorf("Expected Cache.RedisAddr to be empty, got %s", cfg.Cache.RedisAddr)
+	}
+	if cfg.Idempotency.TTL != 24*time.Hour || cfg.Idempotency.Size != 10000 {
+		t.Errorf("Expected 10000 idempotency keys kept for 24h, got %+v", cfg.Idempotency)
+	}
+	if want := map[string]int{"GET /users/export": 10, "GET /users/export.csv": 10}; !reflect.DeepEqual(cfg.ConcurrencyLimits, want) {
+		t.Errorf("Expected exports limited to 10 at once, got %v", cfg.ConcurrencyLimits)
+	}
+	if want := map[string]int{"GET /users/export": 20, "GET /users/export.csv": 20}; !reflect.DeepEqual(cfg.ConcurrencyQueues, want) {
+		t.Errorf("Expected up to 20 exports to wait, got %v", cfg.ConcurrencyQueues)
+	}
+	if cfg.ConcurrencyQueueTimeout != 5*time.Second {
+		t.Errorf("Expected a queue timeout of 5s, got %v", cfg.ConcurrencyQueueTimeout)
+	}
+	if cfg.MaxConcurrentRequests != 1000 {
+		t.Errorf("Expected MaxConcurrentRequests to be 1000, got %d", cfg.MaxConcurrentRequests)
+	}
+	if cfg.MaxUserID != math.MaxInt32 {
+		t.Errorf("Expected MaxUserID to be %d, got %d", math.MaxInt32, cfg.MaxUserID)
+	}
+	if cfg.AllowedEmailDomains != nil {
+		t.Errorf("Expected every email domain to be allowed, got %v", cfg.AllowedEmailDomains)
+	}
+	if cfg.GravatarFallback {
+		t.Error("Expected GravatarFallback to be false")
+	}
+	if cfg.JSONFieldCase != "snake" {
+		t.Errorf("Expected JSONFieldCase to be snake, got %s", cfg.JSONFieldCase)
+	}
+	if cfg.JSONFieldCaseV2 != "camel" {
+		t.Errorf("Expected JSONFieldCaseV2 to be camel, got %s", cfg.JSONFieldCaseV2)
+	}
+	if cfg.RequestTimeout != 15*time.Second {
+		t.Errorf("Expected RequestTimeout to be 15s, got %s", cfg.RequestTimeout)
+	}
+	if cfg.SlowRequestThreshold != time.Second {
+		t.Errorf("Expected SlowRequestThreshold to be 1s, got %s", cfg.SlowRequestThreshold)
+	}
+	if want := []string{"/metrics", "/health", "/readyz", "/livez", "/favicon.ico"}; !reflect.DeepEqual(cfg.InternalPaths, want) {
+		t.Errorf("Expected InternalPaths to be %v, got %v", want, cfg.InternalPaths)
+	}
+	if cfg.LogQueryParams {
+		t.Error("Expected query parameters not to be logged by default")
+	}
+	if want := []string{"token", "password", "api_key"}; !reflect.DeepEqual(cfg.LogRedactParams, want) {
+		t.Errorf("Expected LogRedactParams to be %v, got %v", want, cfg.LogRedactParams)
+	}
+	if cfg.LogPII != "redact" {
+		t.Errorf("Expected LogPII to be redact, got %s", cfg.LogPII)
+	}
+	if want := []string{"email", "name", "display_name"}; !reflect.DeepEqual(cfg.LogPIIFields, want) {
+		t.Errorf("Expected LogPIIFields to be %v, got %v", want, cfg.LogPIIFields)
+	}
+	if cfg.AdminToken != "" {
+		t.Errorf("Expected AdminToken to be empty, got %s", cfg.AdminToken)
+	}
+	if cfg.UserTokens != nil {
+		t.Errorf("Expected no UserTokens, got %v", cfg.UserTokens)
+	}
+	if cfg.HealthDetailToken != "" {
+		t.Errorf("Expected HealthDetailToken to be empty, got %s", cfg.HealthDetailToken)
+	}
+	if cfg.HeartbeatTimeout != 3*time.Minute {
+		t.Errorf("Expected HeartbeatTimeout to be 3m, got %v", cfg.HeartbeatTimeout)
+	}
+	if cfg.Events.KafkaURL != "" {
+		t.Errorf("Expected Events.KafkaURL to be empty, got %s", cfg.Events.KafkaURL)
+	}
+	if cfg.Events.KafkaTopic != "user-events" {
+		t.Errorf("Expected Events.KafkaTopic to be user-events, got %s", cfg.Events.KafkaTopic)
+	}
+	if cfg.WebhookMaxFailures != 10 {
+		t.Errorf("Expected WebhookMaxFailures to be 10, got %d", cfg.WebhookMaxFailures)
+	}
+	if cfg.WebhookURL != "" || cfg.WebhookSecret != "" {
+		t.Errorf("Expected WebhookURL and WebhookSecret to be empty, got %s and %s", cfg.WebhookURL, cfg.WebhookSecret)
+	}
+	if cfg.EnableGraphQL {
+		t.Error("Expected EnableGraphQL to be false")
+	}
+	if cfg.ListCacheTTL != 2*time.Second {
+		t.Errorf("Expected ListCacheTTL to be 2s, got %s", cfg.ListCacheTTL)
+	}
+	if !cfg.EmailAvailability.Enabled {
+		t.Error("Expected EmailAvailability to be enabled")
+	}
+	if cfg.EmailAvailability.RateLimit != (RateBudget{RequestsPerSecond: 1.0, BurstSize: 5}) {
+		t.Errorf("Expected EmailAvailability.RateLimit to be 1 rps with a burst of 5, got %+v", cfg.EmailAvailability.RateLimit)
+	}
+	if cfg.EmailAvailability.CacheTTL != 5*time.Second {
+		t.Errorf("Expected EmailAvailability.CacheTTL to be 5s, got %s", cfg.EmailAvailability.CacheTTL)
+	}
+	if cfg.DBQueryTimeout != 3*time.Second {
+		t.Errorf("Expected DBQueryTimeout to be 3s, got %s", cfg.DBQueryTimeout)
+	}
+	if cfg.DBSlowQueryThreshold != 500*time.Millisecond {
+		t.Errorf("Expected DBSlowQueryThreshold to be 500ms, got %s", cfg.DBSlowQueryThreshold)
+	}
+	if cfg.DBLogArgs {
+		t.Error("Expected DBLogArgs to be false")
+	}
+	if cfg.ImportMaxBytes != 10<<20 {
+		t.Errorf("Expected ImportMaxBytes to be %d, got %d", 10<<20, cfg.ImportMaxBytes)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 0 {
+		t.Errorf("Expected no CORS.AllowedOrigins, got %v", cfg.CORS.AllowedOrigins)
+	}
+	if cfg.CORS.MaxAge != 10*time.Minute {
+		t.Errorf("Expected CORS.MaxAge to be 10m, got %s", cfg.CORS.MaxAge)
+	}
+	if cfg.GRPC.Port != ":50051" {
+		t.Errorf("Expected GRPC.Port to be :50051, got %s", cfg.GRPC.Port)
+	}
+	if cfg.GRPC.AuthToken != "" {
+		t.Errorf("Expected GRPC.AuthToken to be empty, got %s", cfg.GRPC.AuthToken)
+	}
+	if cfg.Metrics.Namespace != "" || cfg.Metrics.Subsystem != "" {
+		t.Errorf("Expected no metrics prefix, got %q and %q", cfg.Metrics.Namespace, cfg.Metrics.Subsystem)
+	}
+	if cfg.Metrics.HTTPBuckets != nil || cfg.Metrics.DBBuckets != nil {
+		t.Errorf("Expected the default metric buckets, got %v and %v", cfg.Metrics.HTTPBuckets, cfg.Metrics.DBBuckets)
+	}
+	if cfg.Metrics.Backend != "prometheus" {
+		t.Errorf("Expected the prometheus metrics backend, got %q", cfg.Metrics.Backend)
+	}
+	if cfg.Metrics.StatsDAddr != "127.0.0.1:8125" || cfg.Metrics.StatsDFlushInterval != 10*time.Second {
+		t.Errorf("Expected the local StatsD agent every 10s, got %q every %v", cfg.Metrics.StatsDAddr, cfg.Metrics.StatsDFlushInterval)
+	}
+	if cfg.Metrics.PushgatewayURL != "" {
+		t.Errorf("Expected no Pushgateway, got %q", cfg.Metrics.PushgatewayURL)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
 	}
 
 	// Test with environment variables
@@ -28,12 +186,192 @@ func TestLoad(t *testing.T) {
 	if err := os.Setenv("LOG_LEVEL", "debug"); err != nil {
 		t.Fatalf("Failed to set LOG_LEVEL: %v", err)
 	}
+	if err := os.Setenv("DB_BACKEND", "memory"); err != nil {
+		t.Fatalf("Failed to set DB_BACKEND: %v", err)
+	}
+	if err := os.Setenv("DB_USERS_TABLE", "tenant_a.users"); err != nil {
+		t.Fatalf("Failed to set DB_USERS_TABLE: %v", err)
+	}
+	if err := os.Setenv("DB_MAX_CONNS", "25"); err != nil {
+		t.Fatalf("Failed to set DB_MAX_CONNS: %v", err)
+	}
+	if err := os.Setenv("DATABASE_REPLICA_URLS", "postgres://replica-a/db, ,postgres://replica-b/db"); err != nil {
+		t.Fatalf("Failed to set DATABASE_REPLICA_URLS: %v", err)
+	}
 	if err := os.Setenv("RATE_LIMIT_RPS", "100.0"); err != nil {
 		t.Fatalf("Failed to set RATE_LIMIT_RPS: %v", err)
 	}
 	if err := os.Setenv("RATE_LIMIT_BURST", "200"); err != nil {
 		t.Fatalf("Failed to set RATE_LIMIT_BURST: %v", err)
 	}
+	if err := os.Setenv("RATE_LIMIT_WRITE_RPS", "2.5"); err != nil {
+		t.Fatalf("Failed to set RATE_LIMIT_WRITE_RPS: %v", err)
+	}
+	if err := os.Setenv("RATE_LIMIT_WRITE_BURST", "5"); err != nil {
+		t.Fatalf("Failed to set RATE_LIMIT_WRITE_BURST: %v", err)
+	}
+	if err := os.Setenv("RATE_LIMIT_EXPORT_RPS", "0.1"); err != nil {
+		t.Fatalf("Failed to set RATE_LIMIT_EXPORT_RPS: %v", err)
+	}
+	if err := os.Setenv("RATE_LIMIT_EXPORT_BURST", "2"); err != nil {
+		t.Fatalf("Failed to set RATE_LIMIT_EXPORT_BURST: %v", err)
+	}
+	if err := os.Setenv("CACHE_TTL", "0"); err != nil {
+		t.Fatalf("Failed to set CACHE_TTL: %v", err)
+	}
+	if err := os.Setenv("CACHE_SIZE", "50"); err != nil {
+		t.Fatalf("Failed to set CACHE_SIZE: %v", err)
+	}
+	if err := os.Setenv("REDIS_ADDR", "redis:6379"); err != nil {
+		t.Fatalf("Failed to set REDIS_ADDR: %v", err)
+	}
+	if err := os.Setenv("MAX_CONCURRENT_REQUESTS", "200"); err != nil {
+		t.Fatalf("Failed to set MAX_CONCURRENT_REQUESTS: %v", err)
+	}
+	if err := os.Setenv("USER_ID_MAX", "1000000"); err != nil {
+		t.Fatalf("Failed to set USER_ID_MAX: %v", err)
+	}
+	if err := os.Setenv("ALLOWED_EMAIL_DOMAINS", "example.com, corp.example.org"); err != nil {
+		t.Fatalf("Failed to set ALLOWED_EMAIL_DOMAINS: %v", err)
+	}
+	if err := os.Setenv("GRAVATAR_FALLBACK", "true"); err != nil {
+		t.Fatalf("Failed to set GRAVATAR_FALLBACK: %v", err)
+	}
+	if err := os.Setenv("JSON_FIELD_CASE", "camel"); err != nil {
+		t.Fatalf("Failed to set JSON_FIELD_CASE: %v", err)
+	}
+	if err := os.Setenv("JSON_FIELD_CASE_V2", "snake"); err != nil {
+		t.Fatalf("Failed to set JSON_FIELD_CASE_V2: %v", err)
+	}
+	if err := os.Setenv("REQUEST_TIMEOUT", "5s"); err != nil {
+		t.Fatalf("Failed to set REQUEST_TIMEOUT: %v", err)
+	}
+	if err := os.Setenv("SLOW_REQUEST_THRESHOLD", "250ms"); err != nil {
+		t.Fatalf("Failed to set SLOW_REQUEST_THRESHOLD: %v", err)
+	}
+	if err := os.Setenv("INTERNAL_PATHS", "/metrics, /status"); err != nil {
+		t.Fatalf("Failed to set INTERNAL_PATHS: %v", err)
+	}
+	if err := os.Setenv("LOG_QUERY_PARAMS", "true"); err != nil {
+		t.Fatalf("Failed to set LOG_QUERY_PARAMS: %v", err)
+	}
+	if err := os.Setenv("LOG_REDACT_PARAMS", "token, ssn"); err != nil {
+		t.Fatalf("Failed to set LOG_REDACT_PARAMS: %v", err)
+	}
+	if err := os.Setenv("LOG_PII", "allow"); err != nil {
+		t.Fatalf("Failed to set LOG_PII: %v", err)
+	}
+	if err := os.Setenv("LOG_PII_FIELDS", "email,phone"); err != nil {
+		t.Fatalf("Failed to set LOG_PII_FIELDS: %v", err)
+	}
+	if err := os.Setenv("CONCURRENCY_LIMITS", "GET /users/export=2, POST /users/import = 1"); err != nil {
+		t.Fatalf("Failed to set CONCURRENCY_LIMITS: %v", err)
+	}
+	if err := os.Setenv("CONCURRENCY_QUEUES", "GET /users/export=4"); err != nil {
+		t.Fatalf("Failed to set CONCURRENCY_QUEUES: %v", err)
+	}
+	if err := os.Setenv("CONCURRENCY_QUEUE_TIMEOUT", "2s"); err != nil {
+		t.Fatalf("Failed to set CONCURRENCY_QUEUE_TIMEOUT: %v", err)
+	}
+	if err := os.Setenv("IDEMPOTENCY_TTL", "1h"); err != nil {
+		t.Fatalf("Failed to set IDEMPOTENCY_TTL: %v", err)
+	}
+	if err := os.Setenv("IDEMPOTENCY_CACHE_SIZE", "500"); err != nil {
+		t.Fatalf("Failed to set IDEMPOTENCY_CACHE_SIZE: %v", err)
+	}
+	if err := os.Setenv("ADMIN_TOKEN", "secret"); err != nil {
+		t.Fatalf("Failed to set ADMIN_TOKEN: %v", err)
+	}
+	if err := os.Setenv("USER_TOKENS", "t0ken=auth0|alice, YmFzZTY0==google|bob"); err != nil {
+		t.Fatalf("Failed to set USER_TOKENS: %v", err)
+	}
+	if err := os.Setenv("HEALTH_DETAIL_TOKEN", "ops"); err != nil {
+		t.Fatalf("Failed to set HEALTH_DETAIL_TOKEN: %v", err)
+	}
+	if err := os.Setenv("HEARTBEAT_TIMEOUT", "10m"); err != nil {
+		t.Fatalf("Failed to set HEARTBEAT_TIMEOUT: %v", err)
+	}
+	if err := os.Setenv("EVENTS_KAFKA_URL", "http://kafka-rest:8082"); err != nil {
+		t.Fatalf("Failed to set EVENTS_KAFKA_URL: %v", err)
+	}
+	if err := os.Setenv("EVENTS_KAFKA_TOPIC", "users"); err != nil {
+		t.Fatalf("Failed to set EVENTS_KAFKA_TOPIC: %v", err)
+	}
+	if err := os.Setenv("WEBHOOK_MAX_FAILURES", "3"); err != nil {
+		t.Fatalf("Failed to set WEBHOOK_MAX_FAILURES: %v", err)
+	}
+	if err := os.Setenv("WEBHOOK_URL", "https://partner.example.com/users"); err != nil {
+		t.Fatalf("Failed to set WEBHOOK_URL: %v", err)
+	}
+	if err := os.Setenv("WEBHOOK_SECRET", "s3cret"); err != nil {
+		t.Fatalf("Failed to set WEBHOOK_SECRET: %v", err)
+	}
+	if err := os.Setenv("ENABLE_GRAPHQL", "true"); err != nil {
+		t.Fatalf("Failed to set ENABLE_GRAPHQL: %v", err)
+	}
+	if err := os.Setenv("LIST_CACHE_TTL", "0"); err != nil {
+		t.Fatalf("Failed to set LIST_CACHE_TTL: %v", err)
+	}
+	if err := os.Setenv("EMAIL_AVAILABILITY_ENABLED", "false"); err != nil {
+		t.Fatalf("Failed to set EMAIL_AVAILABILITY_ENABLED: %v", err)
+	}
+	if err := os.Setenv("EMAIL_AVAILABILITY_RPS", "0.5"); err != nil {
+		t.Fatalf("Failed to set EMAIL_AVAILABILITY_RPS: %v", err)
+	}
+	if err := os.Setenv("EMAIL_AVAILABILITY_BURST", "2"); err != nil {
+		t.Fatalf("Failed to set EMAIL_AVAILABILITY_BURST: %v", err)
+	}
+	if err := os.Setenv("EMAIL_AVAILABILITY_CACHE_TTL", "1s"); err != nil {
+		t.Fatalf("Failed to set EMAIL_AVAILABILITY_CACHE_TTL: %v", err)
+	}
+	if err := os.Setenv("DB_QUERY_TIMEOUT", "1s"); err != nil {
+		t.Fatalf("Failed to set DB_QUERY_TIMEOUT: %v", err)
+	}
+	if err := os.Setenv("DB_SLOW_QUERY_THRESHOLD", "0"); err != nil {
+		t.Fatalf("Failed to set DB_SLOW_QUERY_THRESHOLD: %v", err)
+	}
+	if err := os.Setenv("DB_LOG_ARGS", "true"); err != nil {
+		t.Fatalf("Failed to set DB_LOG_ARGS: %v", err)
+	}
+	if err := os.Setenv("IMPORT_MAX_BYTES", "1024"); err != nil {
+		t.Fatalf("Failed to set IMPORT_MAX_BYTES: %v", err)
+	}
+	if err := os.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com,https://admin.example.com"); err != nil {
+		t.Fatalf("Failed to set CORS_ALLOWED_ORIGINS: %v", err)
+	}
+	if err := os.Setenv("CORS_MAX_AGE", "1h"); err != nil {
+		t.Fatalf("Failed to set CORS_MAX_AGE: %v", err)
+	}
+	if err := os.Setenv("GRPC_PORT", ":6000"); err != nil {
+		t.Fatalf("Failed to set GRPC_PORT: %v", err)
+	}
+	if err := os.Setenv("GRPC_AUTH_TOKEN", "internal"); err != nil {
+		t.Fatalf("Failed to set GRPC_AUTH_TOKEN: %v", err)
+	}
+	if err := os.Setenv("METRICS_NAMESPACE", "acme"); err != nil {
+		t.Fatalf("Failed to set METRICS_NAMESPACE: %v", err)
+	}
+	if err := os.Setenv("METRICS_SUBSYSTEM", "users"); err != nil {
+		t.Fatalf("Failed to set METRICS_SUBSYSTEM: %v", err)
+	}
+	if err := os.Setenv("METRICS_HTTP_BUCKETS", "0.005, 0.01,0.02,0.05"); err != nil {
+		t.Fatalf("Failed to set METRICS_HTTP_BUCKETS: %v", err)
+	}
+	if err := os.Setenv("METRICS_DB_BUCKETS", "0.001,0.01"); err != nil {
+		t.Fatalf("Failed to set METRICS_DB_BUCKETS: %v", err)
+	}
+	if err := os.Setenv("METRICS_BACKEND", "statsd"); err != nil {
+		t.Fatalf("Failed to set METRICS_BACKEND: %v", err)
+	}
+	if err := os.Setenv("STATSD_ADDR", "datadog-agent:8125"); err != nil {
+		t.Fatalf("Failed to set STATSD_ADDR: %v", err)
+	}
+	if err := os.Setenv("STATSD_FLUSH_INTERVAL", "2s"); err != nil {
+		t.Fatalf("Failed to set STATSD_FLUSH_INTERVAL: %v", err)
+	}
+	if err := os.Setenv("PUSHGATEWAY_URL", "http://pushgateway:9091"); err != nil {
+		t.Fatalf("Failed to set PUSHGATEWAY_URL: %v", err)
+	}
 
 	cfg = Load()
 	if cfg.Port != ":9090" {
@@ -42,11 +380,174 @@ func TestLoad(t *testing.T) {
 	if cfg.LogLevel != "debug" {
 		t.Errorf("Expected LogLevel to be debug, got %s", cfg.LogLevel)
 	}
-	if cfg.RateLimit.RequestsPerSecond != 100.0 {
-		t.Errorf("Expected RateLimit.RequestsPerSecond to be 100.0, got %f", cfg.RateLimit.RequestsPerSecond)
+	if cfg.DBBackend != "memory" {
+		t.Errorf("Expected DBBackend to be memory, got %s", cfg.DBBackend)
+	}
+	if cfg.DBUsersTable != "tenant_a.users" {
+		t.Errorf("Expected DBUsersTable to be tenant_a.users, got %s", cfg.DBUsersTable)
+	}
+	if cfg.DBMaxConns != 25 {
+		t.Errorf("Expected DBMaxConns to be 25, got %d", cfg.DBMaxConns)
+	}
+	if len(cfg.DatabaseReplicaURLs) != 2 || cfg.DatabaseReplicaURLs[0] != "postgres://replica-a/db" || cfg.DatabaseReplicaURLs[1] != "postgres://replica-b/db" {
+		t.Errorf("Expected two DatabaseReplicaURLs, got %v", cfg.DatabaseReplicaURLs)
+	}
+	// Reads fall back to the shared variables, while writes have their own
+	if cfg.RateLimit.Read != (RateBudget{RequestsPerSecond: 100.0, BurstSize: 200}) {
+		t.Errorf("Expected RateLimit.Read to be 100 per second with a burst of 200, got %+v", cfg.RateLimit.Read)
+	}
+	if cfg.RateLimit.Write != (RateBudget{RequestsPerSecond: 2.5, BurstSize: 5}) {
+		t.Errorf("Expected RateLimit.Write to be 2.5 per second with a burst of 5, got %+v", cfg.RateLimit.Write)
+	}
+	if cfg.RateLimit.Export != (RateBudget{RequestsPerSecond: 0.1, BurstSize: 2}) {
+		t.Errorf("Expected RateLimit.Export to be 0.1 per second with a burst of 2, got %+v", cfg.RateLimit.Export)
+	}
+	if cfg.Cache.TTL != 0 {
+		t.Errorf("Expected Cache.TTL to be 0, got %s", cfg.Cache.TTL)
+	}
+	if cfg.Cache.Size != 50 {
+		t.Errorf("Expected Cache.Size to be 50, got %d", cfg.Cache.Size)
 	}
-	if cfg.RateLimit.BurstSize != 200 {
-		t.Errorf("Expected RateLimit.BurstSize to be 200, got %d", cfg.RateLimit.BurstSize)
+	if cfg.Cache.RedisAddr != "redis:6379" {
+		t.Errorf("Expected Cache.RedisAddr to be redis:6379, got %s", cfg.Cache.RedisAddr)
+	}
+	if cfg.Idempotency.TTL != time.Hour || cfg.Idempotency.Size != 500 {
+		t.Errorf("Expected 500 idempotency keys kept for 1h, got %+v", cfg.Idempotency)
+	}
+	if want := map[string]int{"GET /users/export": 2, "POST /users/import": 1}; !reflect.DeepEqual(cfg.ConcurrencyLimits, want) {
+		t.Errorf("Expected the configured concurrency limits %v, got %v", want, cfg.ConcurrencyLimits)
+	}
+	if want := map[string]int{"GET /users/export": 4}; !reflect.DeepEqual(cfg.ConcurrencyQueues, want) {
+		t.Errorf("Expected the configured concurrency queues %v, got %v", want, cfg.ConcurrencyQueues)
+	}
+	if cfg.ConcurrencyQueueTimeout != 2*time.Second {
+		t.Errorf("Expected a queue timeout of 2s, got %v", cfg.ConcurrencyQueueTimeout)
+	}
+	if cfg.MaxConcurrentRequests != 200 {
+		t.Errorf("Expected MaxConcurrentRequests to be 200, got %d", cfg.MaxConcurrentRequests)
+	}
+	if cfg.MaxUserID != 1000000 {
+		t.Errorf("Expected MaxUserID to be 1000000, got %d", cfg.MaxUserID)
+	}
+	if want := []string{"example.com", "corp.example.org"}; !reflect.DeepEqual(cfg.AllowedEmailDomains, want) {
+		t.Errorf("Expected AllowedEmailDomains to be %v, got %v", want, cfg.AllowedEmailDomains)
+	}
+	if !cfg.GravatarFallback {
+		t.Error("Expected GravatarFallback to be true")
+	}
+	if cfg.JSONFieldCase != "camel" {
+		t.Errorf("Expected JSONFieldCase to be camel, got %s", cfg.JSONFieldCase)
+	}
+	if cfg.JSONFieldCaseV2 != "snake" {
+		t.Errorf("Expected JSONFieldCaseV2 to be snake, got %s", cfg.JSONFieldCaseV2)
+	}
+	if cfg.RequestTimeout != 5*time.Second {
+		t.Errorf("Expected RequestTimeout to be 5s, got %s", cfg.RequestTimeout)
+	}
+	if cfg.SlowRequestThreshold != 250*time.Millisecond {
+		t.Errorf("Expected SlowRequestThreshold to be 250ms, got %s", cfg.SlowRequestThreshold)
+	}
+	if want := []string{"/metrics", "/status"}; !reflect.DeepEqual(cfg.InternalPaths, want) {
+		t.Errorf("Expected InternalPaths to be %v, got %v", want, cfg.InternalPaths)
+	}
+	if !cfg.LogQueryParams {
+		t.Error("Expected query parameters to be logged")
+	}
+	if want := []string{"token", "ssn"}; !reflect.DeepEqual(cfg.LogRedactParams, want) {
+		t.Errorf("Expected LogRedactParams to be %v, got %v", want, cfg.LogRedactParams)
+	}
+	if cfg.LogPII != "allow" {
+		t.Errorf("Expected LogPII to be allow, got %s", cfg.LogPII)
+	}
+	if want := []string{"email", "phone"}; !reflect.DeepEqual(cfg.LogPIIFields, want) {
+		t.Errorf("Expected LogPIIFields to be %v, got %v", want, cfg.LogPIIFields)
+	}
+	if cfg.AdminToken != "secret" {
+		t.Errorf("Expected AdminToken to be secret, got %s", cfg.AdminToken)
+	}
+	if want := map[string]string{"t0ken": "auth0|alice", "YmFzZTY0=": "google|bob"}; !reflect.DeepEqual(cfg.UserTokens, want) {
+		t.Errorf("Expected UserTokens to be %v, got %v", want, cfg.UserTokens)
+	}
+	if cfg.HealthDetailToken != "ops" {
+		t.Errorf("Expected HealthDetailToken to be ops, got %s", cfg.HealthDetailToken)
+	}
+	if cfg.HeartbeatTimeout != 10*time.Minute {
+		t.Errorf("Expected HeartbeatTimeout to be 10m, got %v", cfg.HeartbeatTimeout)
+	}
+	if cfg.Events.KafkaURL != "http://kafka-rest:8082" {
+		t.Errorf("Expected Events.KafkaURL to be http://kafka-rest:8082, got %s", cfg.Events.KafkaURL)
+	}
+	if cfg.Events.KafkaTopic != "users" {
+		t.Errorf("Expected Events.KafkaTopic to be users, got %s", cfg.Events.KafkaTopic)
+	}
+	if cfg.WebhookMaxFailures != 3 {
+		t.Errorf("Expected WebhookMaxFailures to be 3, got %d", cfg.WebhookMaxFailures)
+	}
+	if cfg.WebhookURL != "https://partner.example.com/users" {
+		t.Errorf("Expected WebhookURL to be https://partner.example.com/users, got %s", cfg.WebhookURL)
+	}
+	if cfg.WebhookSecret != "s3cret" {
+		t.Errorf("Expected WebhookSecret to be s3cret, got %s", cfg.WebhookSecret)
+	}
+	if !cfg.EnableGraphQL {
+		t.Error("Expected EnableGraphQL to be true")
+	}
+	if cfg.ListCacheTTL != 0 {
+		t.Errorf("Expected ListCacheTTL to be 0, got %s", cfg.ListCacheTTL)
+	}
+	if cfg.EmailAvailability.Enabled {
+		t.Error("Expected EmailAvailability to be disabled")
+	}
+	if cfg.EmailAvailability.RateLimit != (RateBudget{RequestsPerSecond: 0.5, BurstSize: 2}) {
+		t.Errorf("Expected EmailAvailability.RateLimit to be 0.5 rps with a burst of 2, got %+v", cfg.EmailAvailability.RateLimit)
+	}
+	if cfg.EmailAvailability.CacheTTL != time.Second {
+		t.Errorf("Expected EmailAvailability.CacheTTL to be 1s, got %s", cfg.EmailAvailability.CacheTTL)
+	}
+	if cfg.DBQueryTimeout != time.Second {
+		t.Errorf("Expected DBQueryTimeout to be 1s, got %s", cfg.DBQueryTimeout)
+	}
+	if cfg.DBSlowQueryThreshold != 0 {
+		t.Errorf("Expected DBSlowQueryThreshold to be 0, got %s", cfg.DBSlowQueryThreshold)
+	}
+	if !cfg.DBLogArgs {
+		t.Error("Expected DBLogArgs to be true")
+	}
+	if cfg.ImportMaxBytes != 1024 {
+		t.Errorf("Expected ImportMaxBytes to be 1024, got %d", cfg.ImportMaxBytes)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.CORS.AllowedOrigins[0] != "https://app.example.com" || cfg.CORS.AllowedOrigins[1] != "https://admin.example.com" {
+		t.Errorf("Expected two CORS.AllowedOrigins, got %v", cfg.CORS.AllowedOrigins)
+	}
+	if cfg.CORS.MaxAge != time.Hour {
+		t.Errorf("Expected CORS.MaxAge to be 1h, got %s", cfg.CORS.MaxAge)
+	}
+	if cfg.GRPC.Port != ":6000" {
+		t.Errorf("Expected GRPC.Port to be :6000, got %s", cfg.GRPC.Port)
+	}
+	if cfg.GRPC.AuthToken != "internal" {
+		t.Errorf("Expected GRPC.AuthToken to be internal, got %s", cfg.GRPC.AuthToken)
+	}
+	if cfg.Metrics.Namespace != "acme" || cfg.Metrics.Subsystem != "users" {
+		t.Errorf("Expected the acme_users metrics prefix, got %q and %q", cfg.Metrics.Namespace, cfg.Metrics.Subsystem)
+	}
+	if !reflect.DeepEqual(cfg.Metrics.HTTPBuckets, []float64{0.005, 0.01, 0.02, 0.05}) {
+		t.Errorf("Expected four HTTP buckets, got %v", cfg.Metrics.HTTPBuckets)
+	}
+	if !reflect.DeepEqual(cfg.Metrics.DBBuckets, []float64{0.001, 0.01}) {
+		t.Errorf("Expected two DB buckets, got %v", cfg.Metrics.DBBuckets)
+	}
+	if cfg.Metrics.Backend != "statsd" {
+		t.Errorf("Expected the statsd metrics backend, got %q", cfg.Metrics.Backend)
+	}
+	if cfg.Metrics.StatsDAddr != "datadog-agent:8125" || cfg.Metrics.StatsDFlushInterval != 2*time.Second {
+		t.Errorf("Expected datadog-agent:8125 every 2s, got %q every %v", cfg.Metrics.StatsDAddr, cfg.Metrics.StatsDFlushInterval)
+	}
+	if cfg.Metrics.PushgatewayURL != "http://pushgateway:9091" {
+		t.Errorf("Expected the Pushgateway at http://pushgateway:9091, got %q", cfg.Metrics.PushgatewayURL)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the configuration to be valid, got %v", err)
 	}
 
 	// Clean up environment variables
@@ -56,26 +557,272 @@ func TestLoad(t *testing.T) {
 	if err := os.Unsetenv("LOG_LEVEL"); err != nil {
 		t.Logf("Warning: failed to unset LOG_LEVEL: %v", err)
 	}
+	if err := os.Unsetenv("DB_BACKEND"); err != nil {
+		t.Logf("Warning: failed to unset DB_BACKEND: %v", err)
+	}
+	if err := os.Unsetenv("DB_USERS_TABLE"); err != nil {
+		t.Logf("Warning: failed to unset DB_USERS_TABLE: %v", err)
+	}
+	if err := os.Unsetenv("DB_MAX_CONNS"); err != nil {
+		t.Logf("Warning: failed to unset DB_MAX_CONNS: %v", err)
+	}
+	if err := os.Unsetenv("DATABASE_REPLICA_URLS"); err != nil {
+		t.Logf("Warning: failed to unset DATABASE_REPLICA_URLS: %v", err)
+	}
 	if err := os.Unsetenv("RATE_LIMIT_RPS"); err != nil {
 		t.Logf("Warning: failed to unset RATE_LIMIT_RPS: %v", err)
 	}
 	if err := os.Unsetenv("RATE_LIMIT_BURST"); err != nil {
 		t.Logf("Warning: failed to unset RATE_LIMIT_BURST: %v", err)
 	}
+	if err := os.Unsetenv("RATE_LIMIT_WRITE_RPS"); err != nil {
+		t.Logf("Warning: failed to unset RATE_LIMIT_WRITE_RPS: %v", err)
+	}
+	if err := os.Unsetenv("RATE_LIMIT_WRITE_BURST"); err != nil {
+		t.Logf("Warning: failed to unset RATE_LIMIT_WRITE_BURST: %v", err)
+	}
+	if err := os.Unsetenv("RATE_LIMIT_EXPORT_RPS"); err != nil {
+		t.Logf("Warning: failed to unset RATE_LIMIT_EXPORT_RPS: %v", err)
+	}
+	if err := os.Unsetenv("RATE_LIMIT_EXPORT_BURST"); err != nil {
+		t.Logf("Warning: failed to unset RATE_LIMIT_EXPORT_BURST: %v", err)
+	}
+	if err := os.Unsetenv("CACHE_TTL"); err != nil {
+		t.Logf("Warning: failed to unset CACHE_TTL: %v", err)
+	}
+	if err := os.Unsetenv("CACHE_SIZE"); err != nil {
+		t.Logf("Warning: failed to unset CACHE_SIZE: %v", err)
+	}
+	if err := os.Unsetenv("REDIS_ADDR"); err != nil {
+		t.Logf("Warning: failed to unset REDIS_ADDR: %v", err)
+	}
+	if err := os.Unsetenv("MAX_CONCURRENT_REQUESTS"); err != nil {
+		t.Logf("Warning: failed to unset MAX_CONCURRENT_REQUESTS: %v", err)
+	}
+	if err := os.Unsetenv("USER_ID_MAX"); err != nil {
+		t.Logf("Warning: failed to unset USER_ID_MAX: %v", err)
+	}
+	if err := os.Unsetenv("ALLOWED_EMAIL_DOMAINS"); err != nil {
+		t.Logf("Warning: failed to unset ALLOWED_EMAIL_DOMAINS: %v", err)
+	}
+	if err := os.Unsetenv("GRAVATAR_FALLBACK"); err != nil {
+		t.Logf("Warning: failed to unset GRAVATAR_FALLBACK: %v", err)
+	}
+	if err := os.Unsetenv("JSON_FIELD_CASE"); err != nil {
+		t.Logf("Warning: failed to unset JSON_FIELD_CASE: %v", err)
+	}
+	if err := os.Unsetenv("JSON_FIELD_CASE_V2"); err != nil {
+		t.Logf("Warning: failed to unset JSON_FIELD_CASE_V2: %v", err)
+	}
+	if err := os.Unsetenv("REQUEST_TIMEOUT"); err != nil {
+		t.Logf("Warning: failed to unset REQUEST_TIMEOUT: %v", err)
+	}
+	if err := os.Unsetenv("SLOW_REQUEST_THRESHOLD"); err != nil {
+		t.Logf("Warning: failed to unset SLOW_REQUEST_THRESHOLD: %v", err)
+	}
+	if err := os.Unsetenv("INTERNAL_PATHS"); err != nil {
+		t.Logf("Warning: failed to unset INTERNAL_PATHS: %v", err)
+	}
+	if err := os.Unsetenv("LOG_QUERY_PARAMS"); err != nil {
+		t.Logf("Warning: failed to unset LOG_QUERY_PARAMS: %v", err)
+	}
+	if err := os.Unsetenv("LOG_REDACT_PARAMS"); err != nil {
+		t.Logf("Warning: failed to unset LOG_REDACT_PARAMS: %v", err)
+	}
+	if err := os.Unsetenv("LOG_PII"); err != nil {
+		t.Logf("Warning: failed to unset LOG_PII: %v", err)
+	}
+	if err := os.Unsetenv("LOG_PII_FIELDS"); err != nil {
+		t.Logf("Warning: failed to unset LOG_PII_FIELDS: %v", err)
+	}
+	if err := os.Unsetenv("CONCURRENCY_LIMITS"); err != nil {
+		t.Logf("Warning: failed to unset CONCURRENCY_LIMITS: %v", err)
+	}
+	if err := os.Unsetenv("CONCURRENCY_QUEUES"); err != nil {
+		t.Logf("Warning: failed to unset CONCURRENCY_QUEUES: %v", err)
+	}
+	if err := os.Unsetenv("CONCURRENCY_QUEUE_TIMEOUT"); err != nil {
+		t.Logf("Warning: failed to unset CONCURRENCY_QUEUE_TIMEOUT: %v", err)
+	}
+	if err := os.Unsetenv("IDEMPOTENCY_TTL"); err != nil {
+		t.Logf("Warning: failed to unset IDEMPOTENCY_TTL: %v", err)
+	}
+	if err := os.Unsetenv("IDEMPOTENCY_CACHE_SIZE"); err != nil {
+		t.Logf("Warning: failed to unset IDEMPOTENCY_CACHE_SIZE: %v", err)
+	}
+	if err := os.Unsetenv("ADMIN_TOKEN"); err != nil {
+		t.Logf("Warning: failed to unset ADMIN_TOKEN: %v", err)
+	}
+	if err := os.Unsetenv("USER_TOKENS"); err != nil {
+		t.Logf("Warning: failed to unset USER_TOKENS: %v", err)
+	}
+	if err := os.Unsetenv("HEALTH_DETAIL_TOKEN"); err != nil {
+		t.Logf("Warning: failed to unset HEALTH_DETAIL_TOKEN: %v", err)
+	}
+	if err := os.Unsetenv("HEARTBEAT_TIMEOUT"); err != nil {
+		t.Logf("Warning: failed to unset HEARTBEAT_TIMEOUT: %v", err)
+	}
+	if err := os.Unsetenv("EVENTS_KAFKA_URL"); err != nil {
+		t.Logf("Warning: failed to unset EVENTS_KAFKA_URL: %v", err)
+	}
+	if err := os.Unsetenv("EVENTS_KAFKA_TOPIC"); err != nil {
+		t.Logf("Warning: failed to unset EVENTS_KAFKA_TOPIC: %v", err)
+	}
+	if err := os.Unsetenv("WEBHOOK_MAX_FAILURES"); err != nil {
+		t.Logf("Warning: failed to unset WEBHOOK_MAX_FAILURES: %v", err)
+	}
+	if err := os.Unsetenv("WEBHOOK_URL"); err != nil {
+		t.Logf("Warning: failed to unset WEBHOOK_URL: %v", err)
+	}
+	if err := os.Unsetenv("WEBHOOK_SECRET"); err != nil {
+		t.Logf("Warning: failed to unset WEBHOOK_SECRET: %v", err)
+	}
+	if err := os.Unsetenv("ENABLE_GRAPHQL"); err != nil {
+		t.Logf("Warning: failed to unset ENABLE_GRAPHQL: %v", err)
+	}
+	if err := os.Unsetenv("LIST_CACHE_TTL"); err != nil {
+		t.Logf("Warning: failed to unset LIST_CACHE_TTL: %v", err)
+	}
+	if err := os.Unsetenv("EMAIL_AVAILABILITY_ENABLED"); err != nil {
+		t.Logf("Warning: failed to unset EMAIL_AVAILABILITY_ENABLED: %v", err)
+	}
+	if err := os.Unsetenv("EMAIL_AVAILABILITY_RPS"); err != nil {
+		t.Logf("Warning: failed to unset EMAIL_AVAILABILITY_RPS: %v", err)
+	}
+	if err := os.Unsetenv("EMAIL_AVAILABILITY_BURST"); err != nil {
+		t.Logf("Warning: failed to unset EMAIL_AVAILABILITY_BURST: %v", err)
+	}
+	if err := os.Unsetenv("EMAIL_AVAILABILITY_CACHE_TTL"); err != nil {
+		t.Logf("Warning: failed to unset EMAIL_AVAILABILITY_CACHE_TTL: %v", err)
+	}
+	if err := os.Unsetenv("DB_QUERY_TIMEOUT"); err != nil {
+		t.Logf("Warning: failed to unset DB_QUERY_TIMEOUT: %v", err)
+	}
+	if err := os.Unsetenv("DB_SLOW_QUERY_THRESHOLD"); err != nil {
+		t.Logf("Warning: failed to unset DB_SLOW_QUERY_THRESHOLD: %v", err)
+	}
+	if err := os.Unsetenv("DB_LOG_ARGS"); err != nil {
+		t.Logf("Warning: failed to unset DB_LOG_ARGS: %v", err)
+	}
+	if err := os.Unsetenv("IMPORT_MAX_BYTES"); err != nil {
+		t.Logf("Warning: failed to unset IMPORT_MAX_BYTES: %v", err)
+	}
+	if err := os.Unsetenv("CORS_ALLOWED_ORIGINS"); err != nil {
+		t.Logf("Warning: failed to unset CORS_ALLOWED_ORIGINS: %v", err)
+	}
+	if err := os.Unsetenv("CORS_MAX_AGE"); err != nil {
+		t.Logf("Warning: failed to unset CORS_MAX_AGE: %v", err)
+	}
+	if err := os.Unsetenv("GRPC_PORT"); err != nil {
+		t.Logf("Warning: failed to unset GRPC_PORT: %v", err)
+	}
+	if err := os.Unsetenv("GRPC_AUTH_TOKEN"); err != nil {
+		t.Logf("Warning: failed to unset GRPC_AUTH_TOKEN: %v", err)
+	}
+	if err := os.Unsetenv("METRICS_NAMESPACE"); err != nil {
+		t.Logf("Warning: failed to unset METRICS_NAMESPACE: %v", err)
+	}
+	if err := os.Unsetenv("METRICS_SUBSYSTEM"); err != nil {
+		t.Logf("Warning: failed to unset METRICS_SUBSYSTEM: %v", err)
+	}
+	if err := os.Unsetenv("METRICS_HTTP_BUCKETS"); err != nil {
+		t.Logf("Warning: failed to unset METRICS_HTTP_BUCKETS: %v", err)
+	}
+	if err := os.Unsetenv("METRICS_DB_BUCKETS"); err != nil {
+		t.Logf("Warning: failed to unset METRICS_DB_BUCKETS: %v", err)
+	}
+	if err := os.Unsetenv("METRICS_BACKEND"); err != nil {
+		t.Logf("Warning: failed to unset METRICS_BACKEND: %v", err)
+	}
+	if err := os.Unsetenv("STATSD_ADDR"); err != nil {
+		t.Logf("Warning: failed to unset STATSD_ADDR: %v", err)
+	}
+	if err := os.Unsetenv("STATSD_FLUSH_INTERVAL"); err != nil {
+		t.Logf("Warning: failed to unset STATSD_FLUSH_INTERVAL: %v", err)
+	}
+	if err := os.Unsetenv("PUSHGATEWAY_URL"); err != nil {
+		t.Logf("Warning: failed to unset PUSHGATEWAY_URL: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr string
+	}{
+		{"bucket that is not a number", "METRICS_HTTP_BUCKETS", "0.01,20ms", `METRICS_HTTP_BUCKETS: bucket "20ms" is not a number`},
+		{"NaN bucket", "METRICS_DB_BUCKETS", "NaN", `METRICS_DB_BUCKETS: bucket "NaN" is not a number`},
+		{"buckets out of order", "METRICS_HTTP_BUCKETS", "0.05,0.01", "METRICS_HTTP_BUCKETS: buckets must be in increasing order, got 0.01 after 0.05"},
+		{"repeated bucket", "METRICS_DB_BUCKETS", "0.01,0.01", "METRICS_DB_BUCKETS: buckets must be in increasing order, got 0.01 after 0.01"},
+		{"namespace with a dash", "METRICS_NAMESPACE", "user-service", `METRICS_NAMESPACE "user-service" must be letters, digits and underscores, not starting with a digit`},
+		{"subsystem starting with a digit", "METRICS_SUBSYSTEM", "2fa", `METRICS_SUBSYSTEM "2fa" must be letters, digits and underscores, not starting with a digit`},
+		{"users table with SQL", "DB_USERS_TABLE", "users; DROP TABLE users", `DB_USERS_TABLE "users; DROP TABLE users" must be a lowercase table name, optionally schema-qualified as in tenant_a.users`},
+		{"users table quoted", "DB_USERS_TABLE", `"Users"`, `DB_USERS_TABLE "\"Users\"" must be a lowercase table name, optionally schema-qualified as in tenant_a.users`},
+		{"users table nested too deep", "DB_USERS_TABLE", "a.b.users", `DB_USERS_TABLE "a.b.users" must be a lowercase table name, optionally schema-qualified as in tenant_a.users`},
+		{"concurrency limit without a route", "CONCURRENCY_LIMITS", "4", `CONCURRENCY_LIMITS: "4" must be a route and a positive limit, as in GET /users/export=4`},
+		{"zero concurrency limit", "CONCURRENCY_LIMITS", "GET /users/export=0", `CONCURRENCY_LIMITS: "GET /users/export=0" must be a route and a positive limit, as in GET /users/export=4`},
+		{"user token without a subject", "USER_TOKENS", "t0ken=auth0|alice,s3cret", "USER_TOKENS: entry 2 must be a token and a subject, as in 3f9ad1=auth0|alice"},
+		{"user token with an empty subject", "USER_TOKENS", "t0ken=", "USER_TOKENS: entry 1 must be a token and a subject, as in 3f9ad1=auth0|alice"},
+		{"unknown metrics backend", "METRICS_BACKEND", "graphite", `METRICS_BACKEND "graphite" must be prometheus or statsd`},
+		{"unknown JSON field case", "JSON_FIELD_CASE", "kebab", `JSON_FIELD_CASE "kebab" must be snake or camel`},
+		{"unknown v2 JSON field case", "JSON_FIELD_CASE_V2", "pascal", `JSON_FIELD_CASE_V2 "pascal" must be snake or camel`},
+		{"unknown PII policy", "LOG_PII", "mask", `LOG_PII "mask" must be redact or allow`},
+		{"empty connection pool", "DB_MAX_CONNS", "0", "DB_MAX_CONNS 0 must be between 1 and 2147483647"},
+		{"zero user ID maximum", "USER_ID_MAX", "0", "USER_ID_MAX 0 must be between 1 and 2147483647"},
+		{"user ID maximum past the id column", "USER_ID_MAX", "4294967296", "USER_ID_MAX 4294967296 must be between 1 and 2147483647"},
+		{"email address as a domain", "ALLOWED_EMAIL_DOMAINS", "example.com,admin@example.org", `ALLOWED_EMAIL_DOMAINS "admin@example.org" must be a domain, as in example.com`},
+		{"zero read burst", "RATE_LIMIT_READ_BURST", "0", "RATE_LIMIT_READ_BURST 0 must be at least 1"},
+		{"negative write burst", "RATE_LIMIT_WRITE_BURST", "-5", "RATE_LIMIT_WRITE_BURST -5 must be at least 1"},
+		{"zero burst for both", "RATE_LIMIT_BURST", "0", "RATE_LIMIT_READ_BURST 0 must be at least 1\nRATE_LIMIT_WRITE_BURST 0 must be at least 1"},
+		{"zero write rate", "RATE_LIMIT_WRITE_RPS", "0", "RATE_LIMIT_WRITE_RPS 0 must be above 0"},
+		{"NaN read rate", "RATE_LIMIT_READ_RPS", "NaN", "RATE_LIMIT_READ_RPS NaN must be above 0"},
+		{"zero export rate", "RATE_LIMIT_EXPORT_RPS", "0", "RATE_LIMIT_EXPORT_RPS 0 must be above 0"},
+		{"zero email availability burst", "EMAIL_AVAILABILITY_BURST", "0", "EMAIL_AVAILABILITY_BURST 0 must be at least 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Setenv(tt.key, tt.value); err != nil {
+				t.Fatalf("Failed to set %s: %v", tt.key, err)
+			}
+			defer func() {
+				if err := os.Unsetenv(tt.key); err != nil {
+					t.Logf("Warning: failed to unset %s: %v", tt.key, err)
+				}
+			}()
+
+			err := Load().Validate()
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
 }
 
-func TestGetRateLimiter(t *testing.T) {
-	cfg := &Config{
-		RateLimit: struct {
-			RequestsPerSecond float64
-			BurstSize         int
-		}{
-			RequestsPerSecond: 5.0,
-			BurstSize:         10,
-		},
+func TestValidateUserTokens(t *testing.T) {
+	for key, value := range map[string]string{"ADMIN_TOKEN": "secret", "USER_TOKENS": "t0ken=auth0|alice,secret=auth0|bob"} {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+		defer func() {
+			if err := os.Unsetenv(key); err != nil {
+				t.Logf("Warning: failed to unset %s: %v", key, err)
+			}
+		}()
 	}
 
-	limiter := cfg.GetRateLimiter()
+	err := Load().Validate()
+	if want := "USER_TOKENS must not include ADMIN_TOKEN"; err == nil || err.Error() != want {
+		t.Errorf("Expected error %q, got %v", want, err)
+	}
+}
+
+func TestRateBudgetLimiter(t *testing.T) {
+	budget := RateBudget{RequestsPerSecond: 5.0, BurstSize: 10}
+
+	limiter := budget.Limiter()
 	if limiter == nil {
 		t.Error("expected non-nil rate limiter")
 	}
@@ -88,4 +835,14 @@ func TestGetRateLimiter(t *testing.T) {
 	if limiter.Burst() != 10 {
 		t.Errorf("expected burst to be 10, got %d", limiter.Burst())
 	}
+
+	// A fresh limiter lets the whole burst through at once, and no more
+	for i := range budget.BurstSize {
+		if !limiter.Allow() {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	if limiter.Allow() {
+		t.Error("expected the request past the burst to be limited")
+	}
 }
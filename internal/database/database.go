@@ -2,10 +2,16 @@ package database
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
+	"strings"
+	"syscall"
 
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // DBTX is an interface for database operations, allowing for both real connections and mocks.
@@ -16,8 +22,27 @@ type DBTX interface {
 	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
 }
 
-func NewConnection(databaseUrl string) (*pgx.Conn, error) {
-	conn, err := pgx.Connect(context.Background(), databaseUrl)
+// preparer creates named prepared statements. It is satisfied by *pgx.Conn.
+type preparer interface {
+	Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error)
+}
+
+// errPrepareUnsupported is returned when preparing through a connection that cannot prepare statements
+var errPrepareUnsupported = errors.New("connection does not support prepared statements")
+
+// statementCacheCapacity bounds the number of queries kept prepared per connection
+const statementCacheCapacity = 512
+
+// NewConnection connects to databaseUrl with a statement cache, so repeated queries
+// are parsed once per connection and then run as prepared statements. Every
+// statement is reported to logger unless it is nil.
+func NewConnection(databaseUrl string, logger pgx.Logger) (*pgx.Conn, error) {
+	config, err := connConfig(databaseUrl, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pgx.ConnectConfig(context.Background(), config)
 	if err != nil {
 		return nil, err
 	}
@@ -25,3 +50,77 @@ func NewConnection(databaseUrl string) (*pgx.Conn, error) {
 	slog.Info("Database connection established")
 	return conn, nil
 }
+
+// NewPool connects a pool of up to maxConns connections to databaseUrl, each set
+// up as NewConnection sets up its connection. A transaction begun on the pool
+// holds one of them until it ends, so concurrent transactions never share one.
+func NewPool(databaseUrl string, maxConns int, logger pgx.Logger) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	configure(config.ConnConfig, logger)
+	config.MaxConns = int32(maxConns)
+
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Database pool established", "max_conns", maxConns)
+	return pool, nil
+}
+
+// connConfig parses databaseUrl into the configuration NewConnection connects with
+func connConfig(databaseUrl string, logger pgx.Logger) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	configure(config, logger)
+	return config, nil
+}
+
+// configure gives config the statement cache and the logger of every connection
+func configure(config *pgx.ConnConfig, logger pgx.Logger) {
+	config.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+		return stmtcache.New(conn, stmtcache.ModePrepare, statementCacheCapacity)
+	}
+	if logger != nil {
+		config.Logger = logger
+	}
+}
+
+// transientCodes are the Postgres errors after which the same statement may well
+// succeed, besides the connection exceptions of class 08
+var transientCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P01": true, // admin_shutdown, as when the primary fails over
+	"57P03": true, // cannot_connect_now, as while a standby is promoted
+}
+
+// Transient reports whether err is a failure that running the statement again
+// may not meet: a serialization failure or deadlock, the server shutting down or
+// losing the connection, a reset connection, or an error pgx marks safe to retry
+// because the statement never reached the server. Timeouts and cancellations are
+// not transient, since a retry would have even less time, and neither are the
+// errors of statements ReconnectingConn already ran again.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var retried *retriedError
+	if errors.As(err, &retried) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08")
+	}
+	var retryable interface{ SafeToRetry() bool }
+	if errors.As(err, &retryable) && retryable.SafeToRetry() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
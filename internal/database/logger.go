@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"user-service/internal/metrics"
+	"user-service/internal/reqctx"
+)
+
+// QueryLogger is a pgx.Logger that reports every statement a connection runs
+// with its duration and the request ID of its context. Each duration is observed
+// in db_query_duration_seconds, with the request's trace as an exemplar.
+// Statements are logged at debug level once they finish, and failed ones at warn
+// level with their error and counted in errors_total{type="database"}. Arguments
+// are redacted unless logArgs is set, since they hold personal data.
+type QueryLogger struct {
+	metrics metrics.Recorder
+	logArgs bool
+}
+
+// NewQueryLogger creates a logger for pgx connections, logging statement
+// arguments only when logArgs is set
+func NewQueryLogger(metricsCollector metrics.Recorder, logArgs bool) *QueryLogger {
+	return &QueryLogger{metrics: metricsCollector, logArgs: logArgs}
+}
+
+// Log implements pgx.Logger
+func (l *QueryLogger) Log(ctx context.Context, _ pgx.LogLevel, msg string, data map[string]interface{}) {
+	duration, timed := data["time"].(time.Duration)
+	if timed {
+		l.metrics.RecordDBQueryDuration(ctx, msg, duration)
+	}
+
+	err, failed := data["err"]
+	if !failed && !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	requestID := reqctx.RequestIDFromContext(ctx)
+	attrs := []any{"operation", msg, "request_id", requestID}
+	if sql, ok := data["sql"].(string); ok {
+		attrs = append(attrs, "sql", strings.Join(strings.Fields(sql), " "))
+	}
+	if timed {
+		attrs = append(attrs, "duration", duration)
+	}
+	if args, ok := data["args"].([]interface{}); ok && len(args) > 0 {
+		if l.logArgs {
+			attrs = append(attrs, "args", args)
+		} else {
+			attrs = append(attrs, "args", strconv.Itoa(len(args))+" redacted")
+		}
+	}
+	if rows, ok := data["rowCount"]; ok {
+		attrs = append(attrs, "rows", rows)
+	}
+
+	if failed {
+		l.metrics.RecordError("database", msg)
+		slog.Warn("Database statement failed", append(attrs, "error", err)...)
+		return
+	}
+	slog.Debug("Database statement", attrs...)
+}
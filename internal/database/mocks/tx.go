@@ -0,0 +1,123 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockBeginner is a mock type for the database.Beginner interface
+type MockBeginner struct {
+	mock.Mock
+}
+
+func (m *MockBeginner) Begin(ctx context.Context) (pgx.Tx, error) {
+	args := m.Called(ctx)
+	var tx pgx.Tx
+	if args.Get(0) != nil {
+		tx = args.Get(0).(pgx.Tx)
+	}
+	return tx, args.Error(1)
+}
+
+// MockTx is a mock type for the pgx.Tx interface
+type MockTx struct {
+	mock.Mock
+}
+
+func (m *MockTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	args := m.Called(ctx)
+	var tx pgx.Tx
+	if args.Get(0) != nil {
+		tx = args.Get(0).(pgx.Tx)
+	}
+	return tx, args.Error(1)
+}
+
+func (m *MockTx) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	args := m.Called(ctx, f)
+	return args.Error(0)
+}
+
+func (m *MockTx) Commit(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *MockTx) Rollback(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *MockTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	args := m.Called(ctx, tableName, columnNames, rowSrc)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	args := m.Called(ctx, b)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(pgx.BatchResults)
+}
+
+func (m *MockTx) LargeObjects() pgx.LargeObjects {
+	args := m.Called()
+	return args.Get(0).(pgx.LargeObjects)
+}
+
+func (m *MockTx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	args := m.Called(ctx, name, sql)
+	var sd *pgconn.StatementDescription
+	if args.Get(0) != nil {
+		sd = args.Get(0).(*pgconn.StatementDescription)
+	}
+	return sd, args.Error(1)
+}
+
+func (m *MockTx) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	allArgs := make([]interface{}, 0, 2+len(arguments))
+	allArgs = append(allArgs, ctx, sql)
+	allArgs = append(allArgs, arguments...)
+	args := m.Called(allArgs...)
+	return args.Get(0).(pgconn.CommandTag), args.Error(1)
+}
+
+func (m *MockTx) Query(ctx context.Context, sql string, queryArgs ...interface{}) (pgx.Rows, error) {
+	allArgs := make([]interface{}, 0, 2+len(queryArgs))
+	allArgs = append(allArgs, ctx, sql)
+	allArgs = append(allArgs, queryArgs...)
+	args := m.Called(allArgs...)
+	var rows pgx.Rows
+	if args.Get(0) != nil {
+		rows = args.Get(0).(pgx.Rows)
+	}
+	return rows, args.Error(1)
+}
+
+func (m *MockTx) QueryRow(ctx context.Context, sql string, queryArgs ...interface{}) pgx.Row {
+	allArgs := make([]interface{}, 0, 2+len(queryArgs))
+	allArgs = append(allArgs, ctx, sql)
+	allArgs = append(allArgs, queryArgs...)
+	args := m.Called(allArgs...)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(pgx.Row)
+}
+
+func (m *MockTx) QueryFunc(ctx context.Context, sql string, queryArgs []interface{}, scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	args := m.Called(ctx, sql, queryArgs, scans, f)
+	return args.Get(0).(pgconn.CommandTag), args.Error(1)
+}
+
+func (m *MockTx) Conn() *pgx.Conn {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(*pgx.Conn)
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"user-service/internal/metrics"
+)
+
+// DefaultPoolStatsInterval is how often the pool gauges are refreshed
+const DefaultPoolStatsInterval = 15 * time.Second
+
+// PoolStat is the part of a pool's statistics the pool gauges report
+type PoolStat struct {
+	// Acquired, Idle and Total count the pool's connections that are in use, that
+	// wait to be used, and all of them, including those still connecting
+	Acquired int32
+	Idle     int32
+	Total    int32
+	// AcquireCount and AcquireDuration are the acquires since the pool opened and
+	// the time they spent waiting for a connection, summed
+	AcquireCount    int64
+	AcquireDuration time.Duration
+}
+
+// PoolStatSource reports a pool's statistics. PgxPoolStats adapts a *pgxpool.Pool.
+type PoolStatSource interface {
+	PoolStat() PoolStat
+}
+
+// PgxPoolStats returns the statistics source of pool
+func PgxPoolStats(pool *pgxpool.Pool) PoolStatSource {
+	return pgxPoolStats{pool: pool}
+}
+
+type pgxPoolStats struct {
+	pool *pgxpool.Pool
+}
+
+func (s pgxPoolStats) PoolStat() PoolStat {
+	stat := s.pool.Stat()
+	return PoolStat{
+		Acquired:        stat.AcquiredConns(),
+		Idle:            stat.IdleConns(),
+		Total:           stat.TotalConns(),
+		AcquireCount:    stat.AcquireCount(),
+		AcquireDuration: stat.AcquireDuration(),
+	}
+}
+
+// PoolStats keeps the pool gauges current. The pool only sums the time acquires
+// wait, so each refresh records the mean wait of the acquires since the last one.
+type PoolStats struct {
+	source   PoolStatSource
+	metrics  metrics.Recorder
+	interval time.Duration
+	last     PoolStat
+}
+
+// NewPoolStats creates a poller recording the statistics of source every interval
+func NewPoolStats(source PoolStatSource, metricsCollector metrics.Recorder, interval time.Duration) *PoolStats {
+	return &PoolStats{
+		source:   source,
+		metrics:  metricsCollector,
+		interval: interval,
+	}
+}
+
+// Run refreshes the gauges on every tick until ctx is cancelled
+func (p *PoolStats) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.Tick()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick records the current connection counts, and the mean acquire wait when
+// any connection was acquired since the last tick
+func (p *PoolStats) Tick() {
+	stat := p.source.PoolStat()
+	p.metrics.SetDBPoolConns("acquired", float64(stat.Acquired))
+	p.metrics.SetDBPoolConns("idle", float64(stat.Idle))
+	p.metrics.SetDBPoolConns("total", float64(stat.Total))
+
+	if acquires := stat.AcquireCount - p.last.AcquireCount; acquires > 0 {
+		p.metrics.RecordDBPoolAcquireWait((stat.AcquireDuration - p.last.AcquireDuration) / time.Duration(acquires))
+	}
+	p.last = stat
+}
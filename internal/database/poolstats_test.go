@@ -0,0 +1,105 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"user-service/internal/database"
+	"user-service/internal/metrics"
+)
+
+// fakePoolStats is a pool statistics source returning stat
+type fakePoolStats struct {
+	stat database.PoolStat
+}
+
+func (s *fakePoolStats) PoolStat() database.PoolStat {
+	return s.stat
+}
+
+// poolConns returns the db_pool_connections gauge for state
+func poolConns(t *testing.T, reg *prometheus.Registry, state string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "db_pool_connections" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() == state {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	return -1
+}
+
+// acquireWaits returns the observation count and sum of db_pool_acquire_wait_seconds
+func acquireWaits(t *testing.T, reg *prometheus.Registry) (uint64, float64) {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "db_pool_acquire_wait_seconds" {
+			h := family.GetMetric()[0].GetHistogram()
+			return h.GetSampleCount(), h.GetSampleSum()
+		}
+	}
+	return 0, 0
+}
+
+func TestPoolStats(t *testing.T) {
+	newPoolStats := func(source database.PoolStatSource) (*database.PoolStats, *prometheus.Registry) {
+		reg := prometheus.NewRegistry()
+		return database.NewPoolStats(source, metrics.New(reg, reg), time.Minute), reg
+	}
+
+	t.Run("sets the connection gauges", func(t *testing.T) {
+		source := &fakePoolStats{stat: database.PoolStat{Acquired: 3, Idle: 2, Total: 5}}
+		stats, reg := newPoolStats(source)
+
+		stats.Tick()
+
+		assert.Equal(t, 3.0, poolConns(t, reg, "acquired"))
+		assert.Equal(t, 2.0, poolConns(t, reg, "idle"))
+		assert.Equal(t, 5.0, poolConns(t, reg, "total"))
+
+		source.stat = database.PoolStat{Acquired: 1, Idle: 4, Total: 5}
+		stats.Tick()
+
+		assert.Equal(t, 1.0, poolConns(t, reg, "acquired"))
+		assert.Equal(t, 4.0, poolConns(t, reg, "idle"))
+	})
+
+	t.Run("records the mean wait of the acquires since the last tick", func(t *testing.T) {
+		source := &fakePoolStats{stat: database.PoolStat{AcquireCount: 10, AcquireDuration: time.Second}}
+		stats, reg := newPoolStats(source)
+
+		stats.Tick()
+		count, sum := acquireWaits(t, reg)
+		assert.Equal(t, uint64(1), count)
+		assert.InDelta(t, 0.1, sum, 1e-9)
+
+		// 4 more acquires waited 2 seconds between them
+		source.stat = database.PoolStat{AcquireCount: 14, AcquireDuration: 3 * time.Second}
+		stats.Tick()
+		count, sum = acquireWaits(t, reg)
+		assert.Equal(t, uint64(2), count)
+		assert.InDelta(t, 0.6, sum, 1e-9)
+	})
+
+	t.Run("records no wait when nothing was acquired", func(t *testing.T) {
+		source := &fakePoolStats{stat: database.PoolStat{AcquireCount: 2, AcquireDuration: time.Millisecond}}
+		stats, reg := newPoolStats(source)
+
+		stats.Tick()
+		stats.Tick()
+
+		count, _ := acquireWaits(t, reg)
+		assert.Equal(t, uint64(1), count)
+	})
+}
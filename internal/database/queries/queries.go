@@ -0,0 +1,166 @@
+// Package queries holds every SQL statement run against the users table, so the
+// repository and the test mocks share one definition of each query. The
+// statements are built for a table by New, as DB_USERS_TABLE may name another
+// table than users.
+package queries
+
+import (
+	"strconv"
+
+	"user-service/internal/models"
+)
+
+// userColumns lists the columns scanned into a models.User, in UserDest order
+const userColumns = "id, name, email, updated_at, role, created_at, status, avatar_url, display_name"
+
+// orderByID ends every query listing users. Postgres returns rows in no
+// particular order otherwise, and pages would shift between requests.
+const orderByID = " ORDER BY id"
+
+// DefaultUsersTable is the table users are stored in unless DB_USERS_TABLE names another
+const DefaultUsersTable = "users"
+
+// Queries are the statements run against one users table. Every query except
+// ListAllUsers, CountDeletedUsers, RestoreUser, EmailExists and EmailOwner only sees users
+// that have not been soft-deleted.
+type Queries struct {
+	GetUserByID    string
+	GetUserByEmail string
+	// GetUserBySubject finds the user an identity provider subject is linked to
+	GetUserBySubject string
+	UserExists       string
+	// Deleted users keep their email, which the unique constraint still covers
+	EmailExists string
+	// EmailOwner finds the lowest ID among the users with an email in any case
+	EmailOwner         string
+	ListUsers          string
+	ListUsersByRole    string
+	ListAllUsers       string
+	ExportUsers        string
+	CountUsers         string
+	CountDeletedUsers  string
+	CountUsersByStatus string
+	InsertUser         string
+	UpdateUser         string
+	DeleteUser         string
+	RestoreUser        string
+	LinkUserSubject    string
+	// SetUserStatus sets the status of user $2 to $1. Setting the status a user
+	// already has leaves updated_at alone, so repeating it is a no-op.
+	SetUserStatus string
+
+	// GetUserByIDStatement names the prepared form of GetUserByID. It is named
+	// after the table, so repositories for two tables can share a connection.
+	GetUserByIDStatement string
+
+	table string
+	// listUsers selects the users that ListUsersQuery filters, ordered with orderByID
+	listUsers string
+}
+
+// Default are the queries for DefaultUsersTable
+var Default = New(DefaultUsersTable)
+
+// New builds the queries for table, which may be schema-qualified, as in
+// tenant_a.users. It is written into the SQL as is, so it must be an identifier
+// validated by the configuration.
+func New(table string) *Queries {
+	listUsers := "SELECT " + userColumns + " FROM " + table + " WHERE deleted_at IS NULL"
+	return &Queries{
+		GetUserByID:        "SELECT " + userColumns + " FROM " + table + " WHERE id = $1 AND deleted_at IS NULL",
+		GetUserByEmail:     "SELECT " + userColumns + " FROM " + table + " WHERE email = $1 AND deleted_at IS NULL",
+		GetUserBySubject:   "SELECT " + userColumns + " FROM " + table + " WHERE subject = $1 AND deleted_at IS NULL",
+		UserExists:         "SELECT 1 FROM " + table + " WHERE id = $1 AND deleted_at IS NULL",
+		EmailExists:        "SELECT EXISTS(SELECT 1 FROM " + table + " WHERE lower(email) = lower($1))",
+		EmailOwner:         "SELECT id FROM " + table + " WHERE lower(email) = lower($1) ORDER BY id LIMIT 1",
+		ListUsers:          listUsers + orderByID,
+		ListUsersByRole:    listUsers + " AND role = $1" + orderByID,
+		ListAllUsers:       "SELECT " + userColumns + ", deleted_at FROM " + table + orderByID,
+		ExportUsers:        listUsers + orderByID,
+		CountUsers:         "SELECT COUNT(*) FROM " + table + " WHERE deleted_at IS NULL",
+		CountDeletedUsers:  "SELECT COUNT(*) FROM " + table + " WHERE deleted_at IS NOT NULL",
+		CountUsersByStatus: "SELECT status, COUNT(*) FROM " + table + " WHERE deleted_at IS NULL GROUP BY status",
+		InsertUser:         "INSERT INTO " + table + " (name, email, role, avatar_url, display_name) VALUES ($1, $2, $3, $4, $5)",
+		UpdateUser:         "UPDATE " + table + " SET name = $1, email = $2, avatar_url = $3, display_name = $4, updated_at = now() WHERE id = $5 AND deleted_at IS NULL",
+		DeleteUser:         "UPDATE " + table + " SET deleted_at = now(), updated_at = now() WHERE id = $1 AND deleted_at IS NULL",
+		RestoreUser:        "UPDATE " + table + " SET deleted_at = NULL, updated_at = now() WHERE id = $1 AND deleted_at IS NOT NULL",
+		LinkUserSubject:    "UPDATE " + table + " SET subject = $1 WHERE id = $2 AND deleted_at IS NULL",
+		SetUserStatus:      "UPDATE " + table + " SET updated_at = CASE WHEN status = $1 THEN updated_at ELSE now() END, status = $1 WHERE id = $2 AND deleted_at IS NULL",
+
+		GetUserByIDStatement: "get_user_by_id:" + table,
+
+		table:     table,
+		listUsers: listUsers,
+	}
+}
+
+// UserDest returns the scan destinations for a row selected with userColumns
+func UserDest(user *models.User) []interface{} {
+	return []interface{}{&user.ID, &user.Name, &user.Email, &user.UpdatedAt, &user.Role, &user.CreatedAt, &user.Status, &user.AvatarURL, &user.DisplayName}
+}
+
+// DeletedUserDest returns the scan destinations for a row selected by ListAllUsers
+func DeletedUserDest(user *models.User) []interface{} {
+	return append(UserDest(user), &user.DeletedAt)
+}
+
+// ListUsersQuery returns the list query and its arguments for the set fields of
+// filter, ordered by ID
+func (q *Queries) ListUsersQuery(filter models.UserFilter) (string, []interface{}) {
+	sql := q.listUsers
+	var args []interface{}
+	condition := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		sql += " AND " + clause + " $" + strconv.Itoa(len(args))
+	}
+
+	if filter.Role != "" {
+		condition("role =", filter.Role)
+	}
+	if filter.Status != "" {
+		condition("status =", filter.Status)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		condition("created_at >", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		condition("created_at <", filter.CreatedBefore)
+	}
+	return sql + orderByID, args
+}
+
+// PatchUserQuery returns the update and its arguments setting only the fields patch
+// sets on user id. Like UpdateUser, it always moves updated_at.
+func (q *Queries) PatchUserQuery(id int, patch models.UserPatch) (string, []interface{}) {
+	sql := "UPDATE " + q.table + " SET updated_at = now()"
+	var args []interface{}
+	set := func(column string, arg interface{}) {
+		args = append(args, arg)
+		sql += ", " + column + " = $" + strconv.Itoa(len(args))
+	}
+
+	if patch.Name != nil {
+		set("name", *patch.Name)
+	}
+	if patch.Email != nil {
+		set("email", *patch.Email)
+	}
+	if patch.AvatarURL != nil {
+		set("avatar_url", *patch.AvatarURL)
+	}
+	if patch.DisplayName != nil {
+		set("display_name", *patch.DisplayName)
+	}
+	args = append(args, id)
+	return sql + " WHERE id = $" + strconv.Itoa(len(args)) + " AND deleted_at IS NULL", args
+}
+
+// InsertUserArgs returns the arguments for InsertUser
+func InsertUserArgs(user models.User) []interface{} {
+	return []interface{}{user.Name, user.Email, user.Role, user.AvatarURL, user.DisplayName}
+}
+
+// UpdateUserArgs returns the arguments for UpdateUser
+func UpdateUserArgs(user models.User) []interface{} {
+	return []interface{}{user.Name, user.Email, user.AvatarURL, user.DisplayName, user.ID}
+}
@@ -0,0 +1,122 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/models"
+)
+
+func TestUserDest(t *testing.T) {
+	var user models.User
+	dest := UserDest(&user)
+	assert.Len(t, dest, 9)
+
+	createdAt := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	*dest[0].(*int) = 1
+	*dest[1].(*string) = "John Doe"
+	*dest[2].(*string) = "john@example.com"
+	*dest[3].(*time.Time) = updatedAt
+	*dest[4].(*string) = models.RoleAdmin
+	*dest[5].(*time.Time) = createdAt
+	*dest[6].(*string) = models.StatusDisabled
+	*dest[7].(*string) = "https://example.com/john.png"
+	*dest[8].(*string) = "Johnny"
+	assert.Equal(t, models.User{ID: 1, Name: "John Doe", Email: "john@example.com", Role: models.RoleAdmin, Status: models.StatusDisabled, CreatedAt: createdAt, UpdatedAt: updatedAt, AvatarURL: "https://example.com/john.png", DisplayName: "Johnny"}, user)
+}
+
+func TestArgs(t *testing.T) {
+	user := models.User{ID: 7, Name: "John Doe", Email: "john@example.com", Role: models.RoleGuest, DisplayName: "Johnny"}
+	assert.Equal(t, []interface{}{"John Doe", "john@example.com", models.RoleGuest, "", "Johnny"}, InsertUserArgs(user))
+	assert.Equal(t, []interface{}{"John Doe", "john@example.com", "", "Johnny", 7}, UpdateUserArgs(user))
+}
+
+func TestQueries(t *testing.T) {
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM users WHERE id = $1 AND deleted_at IS NULL", Default.GetUserByID)
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM users WHERE email = $1 AND deleted_at IS NULL", Default.GetUserByEmail)
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM users WHERE deleted_at IS NULL ORDER BY id", Default.ListUsers)
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name, deleted_at FROM users ORDER BY id", Default.ListAllUsers)
+	assert.Equal(t, "SELECT EXISTS(SELECT 1 FROM users WHERE lower(email) = lower($1))", Default.EmailExists)
+	assert.Equal(t, "SELECT id FROM users WHERE lower(email) = lower($1) ORDER BY id LIMIT 1", Default.EmailOwner)
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM users WHERE subject = $1 AND deleted_at IS NULL", Default.GetUserBySubject)
+}
+
+func TestNew(t *testing.T) {
+	q := New("tenant_a.users")
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM tenant_a.users WHERE id = $1 AND deleted_at IS NULL", q.GetUserByID)
+	assert.Equal(t, "INSERT INTO tenant_a.users (name, email, role, avatar_url, display_name) VALUES ($1, $2, $3, $4, $5)", q.InsertUser)
+	assert.Equal(t, "get_user_by_id:tenant_a.users", q.GetUserByIDStatement)
+
+	sql, _ := q.ListUsersQuery(models.UserFilter{Role: models.RoleAdmin})
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM tenant_a.users WHERE deleted_at IS NULL AND role = $1 ORDER BY id", sql)
+	name := "John"
+	sql, _ = q.PatchUserQuery(1, models.UserPatch{Name: &name})
+	assert.Equal(t, "UPDATE tenant_a.users SET updated_at = now(), name = $1 WHERE id = $2 AND deleted_at IS NULL", sql)
+
+	for _, query := range []string{q.GetUserByEmail, q.GetUserBySubject, q.UserExists, q.EmailExists, q.EmailOwner, q.ListUsers, q.ListUsersByRole, q.ListAllUsers, q.ExportUsers, q.CountUsers, q.CountDeletedUsers,
+		q.CountUsersByStatus, q.UpdateUser, q.DeleteUser, q.RestoreUser, q.LinkUserSubject, q.SetUserStatus} {
+		assert.Contains(t, query, " tenant_a.users ")
+		assert.NotContains(t, query, " users ")
+	}
+
+	// Building queries for another table leaves the default ones alone
+	assert.Contains(t, Default.GetUserByID, " FROM users ")
+}
+
+func TestDeletedUserDest(t *testing.T) {
+	var user models.User
+	dest := DeletedUserDest(&user)
+	assert.Len(t, dest, 10)
+
+	deletedAt := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	*dest[9].(**time.Time) = &deletedAt
+	assert.Equal(t, &deletedAt, user.DeletedAt)
+}
+
+func TestListUsersQuery(t *testing.T) {
+	sql, args := Default.ListUsersQuery(models.UserFilter{})
+	assert.Equal(t, Default.ListUsers, sql)
+	assert.Empty(t, args)
+
+	sql, args = Default.ListUsersQuery(models.UserFilter{Role: models.RoleAdmin})
+	assert.Equal(t, Default.ListUsersByRole, sql)
+	assert.Equal(t, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM users WHERE deleted_at IS NULL AND role = $1 ORDER BY id", sql)
+	assert.Equal(t, []interface{}{models.RoleAdmin}, args)
+
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	sql, args = Default.ListUsersQuery(models.UserFilter{Role: models.RoleGuest, CreatedAfter: after, CreatedBefore: before})
+	assert.Equal(t, Default.listUsers+" AND role = $1 AND created_at > $2 AND created_at < $3 ORDER BY id", sql)
+	assert.Equal(t, []interface{}{models.RoleGuest, after, before}, args)
+
+	sql, args = Default.ListUsersQuery(models.UserFilter{Status: models.StatusDisabled, CreatedBefore: before})
+	assert.Equal(t, Default.listUsers+" AND status = $1 AND created_at < $2 ORDER BY id", sql)
+	assert.Equal(t, []interface{}{models.StatusDisabled, before}, args)
+
+	sql, args = Default.ListUsersQuery(models.UserFilter{CreatedBefore: before})
+	assert.Equal(t, Default.listUsers+" AND created_at < $1 ORDER BY id", sql)
+	assert.Equal(t, []interface{}{before}, args)
+}
+
+func TestPatchUserQuery(t *testing.T) {
+	name, email := "John Updated", "john.updated@example.com"
+
+	sql, args := Default.PatchUserQuery(7, models.UserPatch{Email: &email})
+	assert.Equal(t, "UPDATE users SET updated_at = now(), email = $1 WHERE id = $2 AND deleted_at IS NULL", sql)
+	assert.Equal(t, []interface{}{email, 7}, args)
+
+	sql, args = Default.PatchUserQuery(7, models.UserPatch{Name: &name})
+	assert.Equal(t, "UPDATE users SET updated_at = now(), name = $1 WHERE id = $2 AND deleted_at IS NULL", sql)
+	assert.Equal(t, []interface{}{name, 7}, args)
+
+	sql, args = Default.PatchUserQuery(7, models.UserPatch{Name: &name, Email: &email})
+	assert.Equal(t, "UPDATE users SET updated_at = now(), name = $1, email = $2 WHERE id = $3 AND deleted_at IS NULL", sql)
+	assert.Equal(t, []interface{}{name, email, 7}, args)
+
+	avatar, displayName := "https://example.com/john.png", ""
+	sql, args = Default.PatchUserQuery(7, models.UserPatch{AvatarURL: &avatar, DisplayName: &displayName})
+	assert.Equal(t, "UPDATE users SET updated_at = now(), avatar_url = $1, display_name = $2 WHERE id = $3 AND deleted_at IS NULL", sql)
+	assert.Equal(t, []interface{}{avatar, displayName, 7}, args)
+}
@@ -0,0 +1,342 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"user-service/internal/metrics"
+)
+
+const (
+	// DefaultReconnectBackoff is how long a ReconnectingConn waits after its first
+	// failed redial, doubling with each failure up to maxReconnectBackoff
+	DefaultReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff     = 30 * time.Second
+)
+
+// ErrReconnecting is returned by ReconnectingConn.Ping while the connection is redialed
+var ErrReconnecting = errors.New("database connection lost, reconnecting")
+
+// Conn is a single database connection. It is satisfied by *pgx.Conn.
+type Conn interface {
+	DBTX
+	Beginner
+	// Prepare creates a named prepared statement on the connection
+	Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error)
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+	// IsClosed reports whether the connection is closed, as pgx closes it once
+	// it breaks
+	IsClosed() bool
+}
+
+// Dialer opens a new connection, as NewConnection does
+type Dialer func() (Conn, error)
+
+// ReconnectingConn is a Conn that survives the database going away. When a
+// statement fails and leaves the connection closed, it redials in the background
+// with a growing backoff, counting each new connection in db_reconnects_total,
+// and swaps the new connection in for every caller at once. Reads, and statements
+// that failed before reaching the server, wait for the new connection and are
+// retried on it once; other writes return their error, since they may have run.
+// A retried statement that fails again is not Transient, so callers retrying
+// transient errors themselves do not run it a third time.
+// Statements prepared through it are prepared again on every new connection
+// before it is swapped in, so their names stay valid across reconnects.
+type ReconnectingConn struct {
+	dial    Dialer
+	backoff time.Duration
+	metrics metrics.Recorder
+
+	mu   sync.RWMutex
+	conn Conn
+	// prepared holds the SQL of every statement prepared through Prepare, by name
+	prepared map[string]string
+	// reconnected is closed once the broken connection is replaced; it is nil
+	// while the connection is healthy
+	reconnected chan struct{}
+	closed      bool
+	done        chan struct{}
+}
+
+// NewReconnectingConn dials the first connection, failing if it cannot, and
+// redials with dial whenever it breaks, waiting backoff after the first failure
+func NewReconnectingConn(dial Dialer, backoff time.Duration, metricsCollector metrics.Recorder) (*ReconnectingConn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingConn{
+		dial:     dial,
+		backoff:  backoff,
+		metrics:  metricsCollector,
+		conn:     conn,
+		prepared: make(map[string]string),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// QueryRow runs a single-row query, retrying a read once the connection is replaced
+func (c *ReconnectingConn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	conn := c.current()
+	return &reconnectingRow{
+		row: conn.QueryRow(ctx, sql, args...),
+		retry: func(err error) (pgx.Row, bool) {
+			next, ok := c.retry(ctx, conn, c.reads(sql), err)
+			if !ok {
+				return nil, false
+			}
+			return retriedRow{next.QueryRow(ctx, sql, args...)}, true
+		},
+	}
+}
+
+// Query runs a multi-row query, retrying a read once the connection is replaced
+func (c *ReconnectingConn) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	conn := c.current()
+	rows, err := conn.Query(ctx, sql, args...)
+	if next, ok := c.retry(ctx, conn, c.reads(sql), err); ok {
+		rows, err := next.Query(ctx, sql, args...)
+		return rows, retried(err)
+	}
+	return rows, err
+}
+
+// Exec runs a statement, retrying it only if it never reached the server
+func (c *ReconnectingConn) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	conn := c.current()
+	tag, err := conn.Exec(ctx, sql, arguments...)
+	if next, ok := c.retry(ctx, conn, c.reads(sql), err); ok {
+		tag, err := next.Exec(ctx, sql, arguments...)
+		return tag, retried(err)
+	}
+	return tag, err
+}
+
+// Prepare prepares a named statement on the current connection and remembers it,
+// so it is prepared again on the connections that replace this one. Preparing
+// runs nothing, so it is retried once the connection is replaced.
+func (c *ReconnectingConn) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	c.mu.Lock()
+	c.prepared[name] = sql
+	conn := c.conn
+	c.mu.Unlock()
+
+	description, err := conn.Prepare(ctx, name, sql)
+	if next, ok := c.retry(ctx, conn, true, err); ok {
+		return next.Prepare(ctx, name, sql)
+	}
+	return description, err
+}
+
+// Begin starts a transaction on the current connection. The transaction stays on
+// that connection, so it fails rather than moving if the connection breaks.
+func (c *ReconnectingConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	conn := c.current()
+	tx, err := conn.Begin(ctx)
+	if next, ok := c.retry(ctx, conn, false, err); ok {
+		return next.Begin(ctx)
+	}
+	return tx, err
+}
+
+// Ping checks the current connection for readiness checks, failing with
+// ErrReconnecting while it is redialed
+func (c *ReconnectingConn) Ping(ctx context.Context) error {
+	c.mu.RLock()
+	conn, reconnecting := c.conn, c.reconnected != nil
+	c.mu.RUnlock()
+	if reconnecting {
+		return ErrReconnecting
+	}
+	err := conn.Ping(ctx)
+	if lost(conn, err) {
+		c.broken(conn)
+		return ErrReconnecting
+	}
+	return err
+}
+
+// Close stops redialing and closes the current connection
+func (c *ReconnectingConn) Close(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	return c.conn.Close(ctx)
+}
+
+// IsClosed reports whether Close was called; a broken connection is redialed instead
+func (c *ReconnectingConn) IsClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed
+}
+
+// reads reports whether sql, a query or the name of a statement prepared through
+// Prepare, only reads and may run again
+func (c *ReconnectingConn) reads(sql string) bool {
+	c.mu.RLock()
+	prepared, ok := c.prepared[sql]
+	c.mu.RUnlock()
+	if ok {
+		return isRead(prepared)
+	}
+	return isRead(sql)
+}
+
+func (c *ReconnectingConn) current() Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+// retry reports whether a statement that failed with err on conn should run
+// again, returning the connection to run it on. It starts redialing if err left
+// conn closed and, for statements that are repeatable or never reached the
+// server, waits for the new connection.
+func (c *ReconnectingConn) retry(ctx context.Context, conn Conn, repeatable bool, err error) (Conn, bool) {
+	if !lost(conn, err) {
+		return nil, false
+	}
+	reconnected := c.broken(conn)
+	if !repeatable && !pgconn.SafeToRetry(err) {
+		return nil, false
+	}
+	select {
+	case <-reconnected:
+		return c.current(), true
+	case <-ctx.Done():
+		return nil, false
+	case <-c.done:
+		return nil, false
+	}
+}
+
+// broken starts redialing unless conn was already replaced or is being redialed,
+// and returns a channel closed once it is replaced
+func (c *ReconnectingConn) broken(conn Conn) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		replaced := make(chan struct{})
+		close(replaced)
+		return replaced
+	}
+	if c.reconnected == nil && !c.closed {
+		slog.Warn("Database connection lost, reconnecting")
+		c.reconnected = make(chan struct{})
+		go c.redial(conn, c.reconnected)
+	}
+	return c.reconnected
+}
+
+// redial dials until it gets a connection to replace broken with or Close is called
+func (c *ReconnectingConn) redial(broken Conn, reconnected chan struct{}) {
+	// pgx has already closed it; this only releases what is left
+	_ = broken.Close(context.Background())
+
+	delay := c.backoff
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dial()
+		if err == nil {
+			err = c.prepare(conn)
+		}
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				_ = conn.Close(context.Background())
+				return
+			}
+			c.conn = conn
+			c.reconnected = nil
+			c.mu.Unlock()
+			close(reconnected)
+			c.metrics.RecordDBReconnect()
+			slog.Info("Database connection re-established", "attempts", attempt)
+			return
+		}
+		slog.Warn("Failed to reconnect to database", "attempt", attempt, "retry_in", delay, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+		delay = min(delay*2, maxReconnectBackoff)
+	}
+}
+
+// prepare prepares every remembered statement on conn, closing it if one fails,
+// so a connection is only swapped in once the statement names work on it
+func (c *ReconnectingConn) prepare(conn Conn) error {
+	c.mu.RLock()
+	prepared := make(map[string]string, len(c.prepared))
+	for name, sql := range c.prepared {
+		prepared[name] = sql
+	}
+	c.mu.RUnlock()
+
+	for name, sql := range prepared {
+		if _, err := conn.Prepare(context.Background(), name, sql); err != nil {
+			_ = conn.Close(context.Background())
+			return fmt.Errorf("prepare %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// lost reports whether err left conn closed, as pgx does when the server goes
+// away or a statement is interrupted mid-flight
+func lost(conn Conn, err error) bool {
+	return err != nil && !errors.Is(err, pgx.ErrNoRows) && conn.IsClosed()
+}
+
+// retriedError is the error of a statement already run again on a new connection
+type retriedError struct {
+	err error
+}
+
+func (e *retriedError) Error() string { return e.err.Error() }
+func (e *retriedError) Unwrap() error { return e.err }
+
+// retried marks err, if any, as the error of a statement already retried
+func retried(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retriedError{err: err}
+}
+
+// retriedRow is the row of a query already retried, marking its error
+type retriedRow struct {
+	pgx.Row
+}
+
+func (r retriedRow) Scan(dest ...interface{}) error {
+	return retried(r.Row.Scan(dest...))
+}
+
+// reconnectingRow retries a row once its query failed for a lost connection
+type reconnectingRow struct {
+	row   pgx.Row
+	retry func(err error) (pgx.Row, bool)
+}
+
+func (r *reconnectingRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if next, ok := r.retry(err); ok {
+		return next.Scan(dest...)
+	}
+	return err
+}
@@ -0,0 +1,378 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/metrics"
+)
+
+// connClosedError is what pgx returns for statements on a closed connection, which
+// never reach the server and are safe to retry
+type connClosedError struct{}
+
+func (connClosedError) Error() string     { return "conn closed" }
+func (connClosedError) SafeToRetry() bool { return true }
+
+// fakeConn answers every query with its id until it is closed, after which its
+// statements fail with "conn closed". Breaking it fails the next statement with
+// an error from the wire, closing it, as a *pgx.Conn does when Postgres restarts.
+type fakeConn struct {
+	id     int
+	closed atomic.Bool
+	broken atomic.Bool
+	execs  atomic.Int32
+
+	mu       sync.Mutex
+	prepared map[string]string
+}
+
+func (c *fakeConn) fail() error {
+	if c.broken.CompareAndSwap(true, false) {
+		c.closed.Store(true)
+		return io.ErrUnexpectedEOF
+	}
+	if c.closed.Load() {
+		return connClosedError{}
+	}
+	return nil
+}
+
+func (c *fakeConn) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return fakeRow{id: c.id, err: c.fail()}
+}
+
+func (c *fakeConn) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return &mocks.MockRows{}, nil
+}
+
+func (c *fakeConn) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	c.execs.Add(1)
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return pgconn.CommandTag("UPDATE 1"), nil
+}
+
+func (c *fakeConn) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prepared == nil {
+		c.prepared = make(map[string]string)
+	}
+	c.prepared[name] = sql
+	return &pgconn.StatementDescription{Name: name, SQL: sql}, nil
+}
+
+// statement returns the SQL prepared under name, if any
+func (c *fakeConn) statement(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prepared[name]
+}
+
+func (c *fakeConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return &mocks.MockTx{}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error { return c.fail() }
+
+func (c *fakeConn) Close(ctx context.Context) error {
+	c.closed.Store(true)
+	return nil
+}
+
+func (c *fakeConn) IsClosed() bool { return c.closed.Load() }
+
+type fakeRow struct {
+	id  int
+	err error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = r.id
+	return nil
+}
+
+// dialer hands out fakeConns numbered from 1, failing the dials in failures first.
+// The first broken conns it hands out are broken.
+type dialer struct {
+	mu       sync.Mutex
+	conns    []*fakeConn
+	failures []error
+	broken   int
+}
+
+func (d *dialer) dial() (database.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.failures) > 0 {
+		err := d.failures[0]
+		d.failures = d.failures[1:]
+		return nil, err
+	}
+	conn := &fakeConn{id: len(d.conns) + 1}
+	conn.broken.Store(len(d.conns) < d.broken)
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *dialer) conn(i int) *fakeConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns[i]
+}
+
+func (d *dialer) dials() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+func newReconnectingConn(t *testing.T, d *dialer) (*database.ReconnectingConn, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	conn, err := database.NewReconnectingConn(d.dial, time.Millisecond, metrics.New(reg, reg))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	return conn, reg
+}
+
+func reconnects(t *testing.T, reg *prometheus.Registry) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "db_reconnects_total" {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestReconnectingConn(t *testing.T) {
+	ctx := context.Background()
+	const read = "SELECT id FROM users WHERE id = $1"
+	const write = "UPDATE users SET name = $1 WHERE id = $2"
+
+	t.Run("retries a read once the connection is replaced", func(t *testing.T) {
+		d := &dialer{}
+		conn, reg := newReconnectingConn(t, d)
+		d.conn(0).broken.Store(true)
+
+		var id int
+		assert.NoError(t, conn.QueryRow(ctx, read, 1).Scan(&id))
+		assert.Equal(t, 2, id)
+		assert.Equal(t, 1.0, reconnects(t, reg))
+	})
+
+	t.Run("a retried read that fails again is not transient", func(t *testing.T) {
+		d := &dialer{broken: 2}
+		conn, _ := newReconnectingConn(t, d)
+
+		var id int
+		err := conn.QueryRow(ctx, read, 1).Scan(&id)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.False(t, database.Transient(err))
+		assert.Equal(t, 2, d.dials())
+	})
+
+	t.Run("retries a multi-row read", func(t *testing.T) {
+		d := &dialer{}
+		conn, reg := newReconnectingConn(t, d)
+		d.conn(0).broken.Store(true)
+
+		_, err := conn.Query(ctx, read, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1.0, reconnects(t, reg))
+	})
+
+	t.Run("does not retry a write that may have run", func(t *testing.T) {
+		d := &dialer{}
+		conn, reg := newReconnectingConn(t, d)
+		d.conn(0).broken.Store(true)
+
+		_, err := conn.Exec(ctx, write, "John", 1)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.EqualValues(t, 1, d.conn(0).execs.Load())
+
+		// The next write runs on the new connection
+		_, err = conn.Exec(ctx, write, "John", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1.0, reconnects(t, reg))
+		assert.EqualValues(t, 1, d.conn(1).execs.Load())
+	})
+
+	t.Run("retries a write that never reached the server", func(t *testing.T) {
+		d := &dialer{}
+		conn, _ := newReconnectingConn(t, d)
+		d.conn(0).closed.Store(true)
+
+		_, err := conn.Exec(ctx, write, "John", 1)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, d.conn(1).execs.Load())
+
+		tx, err := conn.Begin(ctx)
+		assert.NoError(t, err)
+		assert.NotNil(t, tx)
+	})
+
+	t.Run("prepares statements again on the new connection", func(t *testing.T) {
+		d := &dialer{}
+		conn, reg := newReconnectingConn(t, d)
+		_, err := conn.Prepare(ctx, "get_user", read)
+		require.NoError(t, err)
+		assert.Equal(t, read, d.conn(0).statement("get_user"))
+		d.conn(0).broken.Store(true)
+
+		var id int
+		assert.NoError(t, conn.QueryRow(ctx, read, 1).Scan(&id))
+		assert.Equal(t, 2, id)
+		assert.Equal(t, read, d.conn(1).statement("get_user"))
+		assert.Equal(t, 1.0, reconnects(t, reg))
+	})
+
+	t.Run("retries a read by its prepared name", func(t *testing.T) {
+		d := &dialer{}
+		conn, _ := newReconnectingConn(t, d)
+		_, err := conn.Prepare(ctx, "get_user", read)
+		require.NoError(t, err)
+		d.conn(0).broken.Store(true)
+
+		var id int
+		assert.NoError(t, conn.QueryRow(ctx, "get_user", 1).Scan(&id))
+		assert.Equal(t, 2, id)
+	})
+
+	t.Run("does not retry a write by its prepared name", func(t *testing.T) {
+		d := &dialer{}
+		conn, _ := newReconnectingConn(t, d)
+		_, err := conn.Prepare(ctx, "rename_user", write)
+		require.NoError(t, err)
+		d.conn(0).broken.Store(true)
+
+		_, err = conn.Exec(ctx, "rename_user", "John", 1)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.EqualValues(t, 1, d.conn(0).execs.Load())
+	})
+
+	t.Run("retries a prepare once the connection is replaced", func(t *testing.T) {
+		d := &dialer{}
+		conn, _ := newReconnectingConn(t, d)
+		d.conn(0).broken.Store(true)
+
+		_, err := conn.Prepare(ctx, "rename_user", write)
+		assert.NoError(t, err)
+		assert.Equal(t, write, d.conn(1).statement("rename_user"))
+	})
+
+	t.Run("leaves other errors alone", func(t *testing.T) {
+		d := &dialer{}
+		conn, _ := newReconnectingConn(t, d)
+
+		var id int
+		assert.NoError(t, conn.QueryRow(ctx, read, 1).Scan(&id))
+		assert.Equal(t, 1, id)
+		assert.NoError(t, conn.Ping(ctx))
+		assert.Equal(t, 1, d.dials())
+	})
+
+	t.Run("redials with backoff and reports the outage to readiness checks", func(t *testing.T) {
+		d := &dialer{}
+		conn, reg := newReconnectingConn(t, d)
+		d.mu.Lock()
+		d.failures = []error{errors.New("connection refused"), errors.New("connection refused")}
+		d.mu.Unlock()
+		d.conn(0).broken.Store(true)
+
+		_, err := conn.Exec(ctx, write, "John", 1)
+		assert.Error(t, err)
+		assert.ErrorIs(t, conn.Ping(ctx), database.ErrReconnecting)
+
+		// Reads wait out the failed dials
+		var id int
+		assert.NoError(t, conn.QueryRow(ctx, read, 1).Scan(&id))
+		assert.Equal(t, 2, id)
+		assert.NoError(t, conn.Ping(ctx))
+		assert.Equal(t, 1.0, reconnects(t, reg))
+	})
+
+	t.Run("a read gives up when its context is done", func(t *testing.T) {
+		d := &dialer{}
+		conn, _ := newReconnectingConn(t, d)
+		d.mu.Lock()
+		for range 1000 {
+			d.failures = append(d.failures, errors.New("connection refused"))
+		}
+		d.mu.Unlock()
+		d.conn(0).broken.Store(true)
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		var id int
+		assert.ErrorIs(t, conn.QueryRow(ctx, read, 1).Scan(&id), io.ErrUnexpectedEOF)
+	})
+
+	t.Run("concurrent callers share one redial", func(t *testing.T) {
+		d := &dialer{}
+		conn, reg := newReconnectingConn(t, d)
+		d.conn(0).closed.Store(true)
+
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var id int
+				assert.NoError(t, conn.QueryRow(ctx, read, 1).Scan(&id))
+				assert.Equal(t, 2, id)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 2, d.dials())
+		assert.Equal(t, 1.0, reconnects(t, reg))
+	})
+
+	t.Run("stops redialing once closed", func(t *testing.T) {
+		d := &dialer{}
+		reg := prometheus.NewRegistry()
+		conn, err := database.NewReconnectingConn(d.dial, time.Millisecond, metrics.New(reg, reg))
+		require.NoError(t, err)
+		d.mu.Lock()
+		for range 1000 {
+			d.failures = append(d.failures, errors.New("connection refused"))
+		}
+		d.mu.Unlock()
+		d.conn(0).closed.Store(true)
+		assert.ErrorIs(t, conn.Ping(ctx), database.ErrReconnecting)
+
+		assert.NoError(t, conn.Close(ctx))
+		assert.True(t, conn.IsClosed())
+		_, err = conn.Exec(ctx, write, "John", 1)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"user-service/internal/metrics"
+)
+
+// Router is a DBTX that sends SELECTs to read replicas round-robin and everything
+// else to the primary. A read that fails because its replica is unreachable is
+// retried on the primary, so callers never see a replica outage. Statements
+// prepared through it are classified by their SQL rather than their name.
+type Router struct {
+	primary  DBTX
+	replicas []DBTX
+	next     atomic.Uint64
+	metrics  metrics.Recorder
+
+	mu sync.RWMutex
+	// replicated holds, for every statement name prepared through Prepare, whether
+	// it reads and was prepared on every replica, so it may run on one
+	replicated map[string]bool
+}
+
+// NewRouter creates a router over a primary and any number of replicas.
+// With no replicas every statement goes to the primary.
+func NewRouter(primary DBTX, replicas []DBTX, metricsCollector metrics.Recorder) *Router {
+	return &Router{
+		primary:    primary,
+		replicas:   replicas,
+		metrics:    metricsCollector,
+		replicated: make(map[string]bool),
+	}
+}
+
+// Prepare prepares a named statement on the primary and, when it reads, on every
+// replica, so queries by its name are routed as its SQL would be. A statement a
+// replica fails to prepare only runs on the primary.
+func (r *Router) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	primary, ok := r.primary.(preparer)
+	if !ok {
+		return nil, errPrepareUnsupported
+	}
+	description, err := primary.Prepare(ctx, name, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	// Writes never reach a replica, so only reads are prepared there
+	replicated := isRead(sql)
+	for i := 0; replicated && i < len(r.replicas); i++ {
+		p, ok := r.replicas[i].(preparer)
+		if !ok {
+			replicated = false
+		} else if _, err := p.Prepare(ctx, name, sql); err != nil {
+			slog.Warn("Failed to prepare statement on replica, running it on the primary", "statement", name, "replica", replicaTarget(i), "error", err)
+			replicated = false
+		}
+	}
+
+	r.mu.Lock()
+	r.replicated[name] = replicated
+	r.mu.Unlock()
+	return description, nil
+}
+
+// QueryRow runs a single-row query, on a replica when it is a read
+func (r *Router) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if !r.reads(sql) || len(r.replicas) == 0 {
+		return &recordedRow{row: r.primary.QueryRow(ctx, sql, args...), router: r, target: "primary"}
+	}
+
+	replica, target := r.replica()
+	return &recordedRow{
+		row:    replica.QueryRow(ctx, sql, args...),
+		router: r,
+		target: target,
+		fallback: func() pgx.Row {
+			return r.primary.QueryRow(ctx, sql, args...)
+		},
+	}
+}
+
+// Query runs a multi-row query, on a replica when it is a read
+func (r *Router) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if !r.reads(sql) || len(r.replicas) == 0 {
+		rows, err := r.primary.Query(ctx, sql, args...)
+		r.record("primary", err)
+		return rows, err
+	}
+
+	replica, target := r.replica()
+	rows, err := replica.Query(ctx, sql, args...)
+	r.record(target, err)
+	if unavailable(err) {
+		r.metrics.RecordDBFallback()
+		rows, err = r.primary.Query(ctx, sql, args...)
+		r.record("primary", err)
+	}
+	return rows, err
+}
+
+// Exec runs a statement on the primary
+func (r *Router) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	tag, err := r.primary.Exec(ctx, sql, arguments...)
+	r.record("primary", err)
+	return tag, err
+}
+
+// reads reports whether sql, a query or the name of a statement prepared through
+// Prepare, may run on a replica
+func (r *Router) reads(sql string) bool {
+	r.mu.RLock()
+	replicated, prepared := r.replicated[sql]
+	r.mu.RUnlock()
+	if prepared {
+		return replicated
+	}
+	return isRead(sql)
+}
+
+// replica picks the next replica round-robin and returns it with its metrics label
+func (r *Router) replica() (DBTX, string) {
+	i := int((r.next.Add(1) - 1) % uint64(len(r.replicas)))
+	return r.replicas[i], replicaTarget(i)
+}
+
+func (r *Router) record(target string, err error) {
+	result := "ok"
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		result = "error"
+	}
+	r.metrics.RecordDBQuery(target, result)
+}
+
+// recordedRow records the outcome of a QueryRow once it is scanned, and retries
+// reads on the primary when the replica could not be reached
+type recordedRow struct {
+	row      pgx.Row
+	router   *Router
+	target   string
+	fallback func() pgx.Row
+}
+
+func (r *recordedRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	r.router.record(r.target, err)
+	if r.fallback != nil && unavailable(err) {
+		r.router.metrics.RecordDBFallback()
+		err = r.fallback().Scan(dest...)
+		r.router.record("primary", err)
+	}
+	return err
+}
+
+// isRead reports whether sql only reads and may run on a replica
+func isRead(sql string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql)), "SELECT")
+}
+
+// unavailable reports whether err means the target could not run the query at all,
+// as opposed to the query itself failing or finding no rows
+func unavailable(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	return !errors.As(err, &pgErr)
+}
+
+func replicaTarget(i int) string {
+	return "replica-" + strconv.Itoa(i)
+}
@@ -0,0 +1,238 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+)
+
+var errConnClosed = errors.New("conn closed")
+
+// preparingDB is a mock connection that also supports prepared statements
+type preparingDB struct {
+	*mocks.MockDBTX
+}
+
+func (db preparingDB) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	ret := db.Called(ctx, name, sql)
+	return &pgconn.StatementDescription{Name: name, SQL: sql}, ret.Error(0)
+}
+
+// countRow returns a mock row that scans count, or fails with err when it is set
+func countRow(count int, err error) *mocks.MockRow {
+	row := &mocks.MockRow{}
+	row.On("Scan", mock.Anything).Return(err).Run(func(args mock.Arguments) {
+		if err == nil {
+			*args.Get(0).([]interface{})[0].(*int) = count
+		}
+	})
+	return row
+}
+
+// counterValue returns the value of the named counter with the given labels
+func counterValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+
+	newRouter := func(replicas int) (*database.Router, *mocks.MockDBTX, []*mocks.MockDBTX, *prometheus.Registry) {
+		reg := prometheus.NewRegistry()
+		primary := &mocks.MockDBTX{}
+		var replicaMocks []*mocks.MockDBTX
+		var replicaDBs []database.DBTX
+		for i := 0; i < replicas; i++ {
+			replica := &mocks.MockDBTX{}
+			replicaMocks = append(replicaMocks, replica)
+			replicaDBs = append(replicaDBs, replica)
+		}
+		return database.NewRouter(primary, replicaDBs, metrics.New(reg, reg)), primary, replicaMocks, reg
+	}
+
+	t.Run("reads go to replicas round-robin", func(t *testing.T) {
+		router, primary, replicas, reg := newRouter(2)
+		replicas[0].On("QueryRow", ctx, queries.Default.CountUsers).Return(countRow(1, nil))
+		replicas[1].On("QueryRow", ctx, queries.Default.CountUsers).Return(countRow(2, nil))
+
+		var counts []int
+		for i := 0; i < 4; i++ {
+			var count int
+			assert.NoError(t, router.QueryRow(ctx, queries.Default.CountUsers).Scan(&count))
+			counts = append(counts, count)
+		}
+
+		assert.Equal(t, []int{1, 2, 1, 2}, counts)
+		replicas[0].AssertNumberOfCalls(t, "QueryRow", 2)
+		replicas[1].AssertNumberOfCalls(t, "QueryRow", 2)
+		primary.AssertNotCalled(t, "QueryRow")
+		assert.Equal(t, 2.0, counterValue(t, reg, "db_queries_total", map[string]string{"target": "replica-1", "result": "ok"}))
+	})
+
+	t.Run("multi-row reads go to replicas", func(t *testing.T) {
+		router, primary, replicas, _ := newRouter(1)
+		rows := &mocks.MockRows{}
+		replicas[0].On("Query", ctx, queries.Default.ListUsers).Return(rows, nil)
+
+		got, err := router.Query(ctx, queries.Default.ListUsers)
+		assert.NoError(t, err)
+		assert.Same(t, rows, got)
+		primary.AssertNotCalled(t, "Query")
+	})
+
+	t.Run("writes go to the primary", func(t *testing.T) {
+		router, primary, replicas, reg := newRouter(2)
+		primary.On("Exec", ctx, queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("DELETE 1"), nil)
+
+		_, err := router.Exec(ctx, queries.Default.DeleteUser, 1)
+		assert.NoError(t, err)
+		primary.AssertExpectations(t)
+		replicas[0].AssertNotCalled(t, "Exec")
+		replicas[1].AssertNotCalled(t, "Exec")
+		assert.Equal(t, 1.0, counterValue(t, reg, "db_queries_total", map[string]string{"target": "primary", "result": "ok"}))
+	})
+
+	t.Run("non-select queries go to the primary", func(t *testing.T) {
+		router, primary, replicas, _ := newRouter(1)
+		sql := "UPDATE users SET name = $1 WHERE id = $2 RETURNING id"
+		primary.On("QueryRow", ctx, sql, "John", 1).Return(countRow(1, nil))
+
+		var id int
+		assert.NoError(t, router.QueryRow(ctx, sql, "John", 1).Scan(&id))
+		primary.AssertExpectations(t)
+		replicas[0].AssertNotCalled(t, "QueryRow")
+	})
+
+	t.Run("without replicas reads go to the primary", func(t *testing.T) {
+		router, primary, _, _ := newRouter(0)
+		primary.On("QueryRow", ctx, queries.Default.CountUsers).Return(countRow(3, nil))
+
+		var count int
+		assert.NoError(t, router.QueryRow(ctx, queries.Default.CountUsers).Scan(&count))
+		assert.Equal(t, 3, count)
+		primary.AssertExpectations(t)
+	})
+
+	t.Run("unreachable replica falls back to the primary", func(t *testing.T) {
+		router, primary, replicas, reg := newRouter(1)
+		replicas[0].On("QueryRow", ctx, queries.Default.CountUsers).Return(countRow(0, errConnClosed))
+		replicas[0].On("Query", ctx, queries.Default.ListUsers).Return(nil, errConnClosed)
+		primary.On("QueryRow", ctx, queries.Default.CountUsers).Return(countRow(5, nil))
+		primary.On("Query", ctx, queries.Default.ListUsers).Return(&mocks.MockRows{}, nil)
+
+		var count int
+		assert.NoError(t, router.QueryRow(ctx, queries.Default.CountUsers).Scan(&count))
+		assert.Equal(t, 5, count)
+
+		_, err := router.Query(ctx, queries.Default.ListUsers)
+		assert.NoError(t, err)
+
+		primary.AssertExpectations(t)
+		assert.Equal(t, 2.0, counterValue(t, reg, "db_replica_fallbacks_total", nil))
+		assert.Equal(t, 2.0, counterValue(t, reg, "db_queries_total", map[string]string{"target": "replica-0", "result": "error"}))
+	})
+
+	t.Run("query errors are not retried on the primary", func(t *testing.T) {
+		router, primary, replicas, _ := newRouter(1)
+		replicas[0].On("QueryRow", ctx, queries.Default.GetUserByID, 1).Return(countRow(0, pgx.ErrNoRows))
+		replicas[0].On("QueryRow", ctx, queries.Default.GetUserByID, 2).Return(countRow(0, &pgconn.PgError{Code: "42P01"}))
+
+		var id int
+		assert.ErrorIs(t, router.QueryRow(ctx, queries.Default.GetUserByID, 1).Scan(&id), pgx.ErrNoRows)
+		var pgErr *pgconn.PgError
+		assert.ErrorAs(t, router.QueryRow(ctx, queries.Default.GetUserByID, 2).Scan(&id), &pgErr)
+		primary.AssertNotCalled(t, "QueryRow")
+	})
+
+	t.Run("repository reads and writes are routed transparently", func(t *testing.T) {
+		router, primary, replicas, _ := newRouter(1)
+		replicas[0].On("QueryRow", ctx, queries.Default.GetUserByID, 1).Return(countRow(1, nil))
+		primary.On("Exec", ctx, queries.Default.UpdateUser, "John Doe", "john@example.com", "", "", 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		repo := repository.NewPgxUserRepository(router, queries.DefaultUsersTable)
+
+		_, err := repo.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.Update(ctx, models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}))
+
+		replicas[0].AssertExpectations(t)
+		primary.AssertExpectations(t)
+		primary.AssertNotCalled(t, "QueryRow")
+	})
+	t.Run("prepared reads go to replicas by name", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		primary, replica := preparingDB{&mocks.MockDBTX{}}, preparingDB{&mocks.MockDBTX{}}
+		router := database.NewRouter(primary, []database.DBTX{replica}, metrics.New(reg, reg))
+		name := queries.Default.GetUserByIDStatement
+		primary.On("Prepare", ctx, name, queries.Default.GetUserByID).Return(nil).Once()
+		replica.On("Prepare", ctx, name, queries.Default.GetUserByID).Return(nil).Once()
+		replica.On("QueryRow", ctx, name, 1).Return(countRow(1, nil))
+		repo := repository.NewPgxUserRepository(router, queries.DefaultUsersTable)
+
+		_, err := repo.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		primary.AssertExpectations(t)
+		replica.AssertExpectations(t)
+		primary.AssertNotCalled(t, "QueryRow")
+	})
+
+	t.Run("prepared writes go to the primary by name", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		primary, replica := preparingDB{&mocks.MockDBTX{}}, preparingDB{&mocks.MockDBTX{}}
+		router := database.NewRouter(primary, []database.DBTX{replica}, metrics.New(reg, reg))
+		sql := "UPDATE users SET name = $1 WHERE id = $2 RETURNING id"
+		primary.On("Prepare", ctx, "rename_user", sql).Return(nil)
+		primary.On("QueryRow", ctx, "rename_user", "John", 1).Return(countRow(1, nil))
+
+		_, err := router.Prepare(ctx, "rename_user", sql)
+		assert.NoError(t, err)
+		var id int
+		assert.NoError(t, router.QueryRow(ctx, "rename_user", "John", 1).Scan(&id))
+		primary.AssertExpectations(t)
+		replica.AssertNotCalled(t, "Prepare", mock.Anything, mock.Anything, mock.Anything)
+		replica.AssertNotCalled(t, "QueryRow")
+	})
+
+	t.Run("prepared reads a replica could not prepare go to the primary", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		primary, replica := preparingDB{&mocks.MockDBTX{}}, preparingDB{&mocks.MockDBTX{}}
+		router := database.NewRouter(primary, []database.DBTX{replica}, metrics.New(reg, reg))
+		primary.On("Prepare", ctx, "count_users", queries.Default.CountUsers).Return(nil)
+		replica.On("Prepare", ctx, "count_users", queries.Default.CountUsers).Return(errConnClosed)
+		primary.On("QueryRow", ctx, "count_users").Return(countRow(4, nil))
+
+		_, err := router.Prepare(ctx, "count_users", queries.Default.CountUsers)
+		assert.NoError(t, err)
+		var count int
+		assert.NoError(t, router.QueryRow(ctx, "count_users").Scan(&count))
+		assert.Equal(t, 4, count)
+		replica.AssertNotCalled(t, "QueryRow")
+	})
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// TxManager runs functions inside a database transaction
+type TxManager interface {
+	// WithTx begins a transaction and passes it to fn. The transaction is committed
+	// when fn returns nil and rolled back when fn returns an error or panics.
+	WithTx(ctx context.Context, fn func(tx DBTX) error) error
+}
+
+// Beginner starts transactions. It is satisfied by *pgx.Conn.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// pgxTxManager runs transactions on a pgx connection
+type pgxTxManager struct {
+	db Beginner
+}
+
+// NewTxManager creates a transaction manager over the primary. Pass a pool, as
+// NewPool opens, so each transaction runs on a connection of its own; statements
+// of concurrent transactions begun on one shared connection would interleave.
+func NewTxManager(db Beginner) TxManager {
+	return &pgxTxManager{db: db}
+}
+
+func (m *pgxTxManager) WithTx(ctx context.Context, fn func(tx DBTX) error) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		// The function's error is more useful to the caller than a failed rollback
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
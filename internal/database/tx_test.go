@@ -0,0 +1,83 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+)
+
+// beginTx returns a transaction manager whose Begin hands out a fresh mock transaction
+func beginTx() (database.TxManager, *mocks.MockTx) {
+	tx := &mocks.MockTx{}
+	db := &mocks.MockBeginner{}
+	db.On("Begin", context.Background()).Return(tx, nil)
+	return database.NewTxManager(db), tx
+}
+
+func TestTxManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("commits when fn succeeds", func(t *testing.T) {
+		txm, tx := beginTx()
+		tx.On("Exec", ctx, "DELETE FROM users").Return(pgconn.CommandTag("DELETE 1"), nil)
+		tx.On("Commit", ctx).Return(nil)
+
+		err := txm.WithTx(ctx, func(db database.DBTX) error {
+			_, err := db.Exec(ctx, "DELETE FROM users")
+			return err
+		})
+
+		assert.NoError(t, err)
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Rollback", ctx)
+	})
+
+	t.Run("rolls back when fn fails", func(t *testing.T) {
+		txm, tx := beginTx()
+		tx.On("Rollback", ctx).Return(nil)
+
+		err := txm.WithTx(ctx, func(database.DBTX) error { return assert.AnError })
+
+		assert.ErrorIs(t, err, assert.AnError)
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+
+	t.Run("rolls back and re-panics when fn panics", func(t *testing.T) {
+		txm, tx := beginTx()
+		tx.On("Rollback", ctx).Return(nil)
+
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = txm.WithTx(ctx, func(database.DBTX) error { panic("boom") })
+		})
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+
+	t.Run("returns commit errors", func(t *testing.T) {
+		txm, tx := beginTx()
+		tx.On("Commit", ctx).Return(assert.AnError)
+
+		err := txm.WithTx(ctx, func(database.DBTX) error { return nil })
+
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+
+	t.Run("does not run fn when begin fails", func(t *testing.T) {
+		db := &mocks.MockBeginner{}
+		db.On("Begin", ctx).Return(nil, assert.AnError)
+
+		called := false
+		err := database.NewTxManager(db).WithTx(ctx, func(database.DBTX) error {
+			called = true
+			return nil
+		})
+
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.False(t, called)
+	})
+}
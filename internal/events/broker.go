@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 64
+
+// Reasons a subscription ends
+var (
+	ErrSubscriberBehind = errors.New("subscriber fell too far behind")
+	ErrShuttingDown     = errors.New("server is shutting down")
+)
+
+// Broker is an EventPublisher that hands every event to the current subscribers,
+// such as gRPC watch calls and SSE streams. A subscriber that stops reading is
+// dropped rather than slowing publishers down.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	events chan Event
+	err    error
+}
+
+// NewBroker creates a broker without subscribers
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Publish hands event to every subscriber. It never fails.
+func (b *Broker) Publish(_ context.Context, event Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			b.end(sub, ErrSubscriberBehind)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a new subscriber. Its events channel is closed once
+// Unsubscribe is called, the subscriber falls behind or the broker is closed.
+func (b *Broker) Subscribe() *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{events: make(chan Event, subscriberBuffer)}
+	if b.closed {
+		sub.err = ErrShuttingDown
+		close(sub.events)
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe unregisters sub
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		b.end(sub, nil)
+	}
+}
+
+// Close ends every subscription and refuses new ones, letting streaming calls
+// finish before the servers stop
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		b.end(sub, ErrShuttingDown)
+	}
+}
+
+// end closes sub with err; b.mu must be held
+func (b *Broker) end(sub *Subscription, err error) {
+	delete(b.subscribers, sub)
+	sub.err = err
+	close(sub.events)
+}
+
+// Events returns the channel the subscription's events arrive on
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err reports why the events channel was closed, once it has been; nil after Unsubscribe
+func (s *Subscription) Err() error {
+	return s.err
+}
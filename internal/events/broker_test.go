@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker()
+	first, second := broker.Subscribe(), broker.Subscribe()
+
+	event := Event{Type: TypeUserCreated}
+	assert.NoError(t, broker.Publish(context.Background(), event))
+	assert.Equal(t, event, <-first.Events())
+	assert.Equal(t, event, <-second.Events())
+
+	// An unsubscribed subscriber gets nothing more
+	broker.Unsubscribe(first)
+	assert.NoError(t, broker.Publish(context.Background(), event))
+	_, ok := <-first.Events()
+	assert.False(t, ok)
+	assert.NoError(t, first.Err())
+	assert.Equal(t, event, <-second.Events())
+
+	// Closing ends the remaining subscriptions and refuses new ones
+	broker.Close()
+	_, ok = <-second.Events()
+	assert.False(t, ok)
+	assert.ErrorIs(t, second.Err(), ErrShuttingDown)
+	late := broker.Subscribe()
+	_, ok = <-late.Events()
+	assert.False(t, ok)
+	assert.ErrorIs(t, late.Err(), ErrShuttingDown)
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	broker := NewBroker()
+	sub := broker.Subscribe()
+	for range subscriberBuffer + 1 {
+		assert.NoError(t, broker.Publish(context.Background(), Event{Type: TypeUserUpdated}))
+	}
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+	assert.ErrorIs(t, sub.Err(), ErrSubscriberBehind)
+
+	// Unsubscribing an ended subscription is a no-op
+	broker.Unsubscribe(sub)
+}
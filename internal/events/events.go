@@ -0,0 +1,86 @@
+// Package events publishes user changes so other services can react without polling.
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+)
+
+// Event types
+const (
+	TypeUserCreated  = "user.created"
+	TypeUserUpdated  = "user.updated"
+	TypeUserDeleted  = "user.deleted"
+	TypeUserRestored = "user.restored"
+)
+
+// Event describes one committed change to a user
+type Event struct {
+	Type string `json:"type"`
+	// User is the user after the change, or before it for user.deleted
+	User      models.User `json:"user"`
+	RequestID string      `json:"request_id"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// NewEvent builds an event of eventType for user, taking the request ID from ctx
+func NewEvent(ctx context.Context, eventType string, user models.User) Event {
+	requestID := reqctx.RequestIDFromContext(ctx)
+	return Event{
+		Type:      eventType,
+		User:      user,
+		RequestID: requestID,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// EventPublisher delivers events. Implementations must be safe for concurrent use.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// PublisherFunc adapts a function to EventPublisher
+type PublisherFunc func(ctx context.Context, event Event) error
+
+// Publish calls f
+func (f PublisherFunc) Publish(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// logPublisher writes events to the log, for running without a broker
+type logPublisher struct{}
+
+// NewLogPublisher creates a publisher that only logs events
+func NewLogPublisher() EventPublisher {
+	return logPublisher{}
+}
+
+// Publish logs the event
+func (logPublisher) Publish(_ context.Context, event Event) error {
+	slog.Info("Published event", "type", event.Type, "user_id", event.User.ID, "request_id", event.RequestID)
+	return nil
+}
+
+// multiPublisher delivers every event to several publishers
+type multiPublisher []EventPublisher
+
+// NewMultiPublisher creates a publisher that delivers each event to every one of publishers
+func NewMultiPublisher(publishers ...EventPublisher) EventPublisher {
+	return multiPublisher(publishers)
+}
+
+// Publish delivers the event to every publisher, even after one fails, and joins their errors
+func (m multiPublisher) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
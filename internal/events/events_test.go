@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+)
+
+func TestNewEvent(t *testing.T) {
+	user := models.User{ID: 7, Name: "John Doe", Email: "john@example.com"}
+
+	ctx := reqctx.WithRequestID(context.Background(), "req-1")
+	event := NewEvent(ctx, TypeUserCreated, user)
+	assert.Equal(t, TypeUserCreated, event.Type)
+	assert.Equal(t, user, event.User)
+	assert.Equal(t, "req-1", event.RequestID)
+	assert.WithinDuration(t, time.Now(), event.Timestamp, time.Second)
+	assert.Equal(t, time.UTC, event.Timestamp.Location())
+
+	// Events raised outside a request carry no request ID
+	event = NewEvent(context.Background(), TypeUserDeleted, user)
+	assert.Empty(t, event.RequestID)
+}
+
+func TestLogPublisher(t *testing.T) {
+	assert.NoError(t, NewLogPublisher().Publish(context.Background(), Event{Type: TypeUserUpdated}))
+}
+
+// countingPublisher counts the events it is given and fails with err
+type countingPublisher struct {
+	count int
+	err   error
+}
+
+func (p *countingPublisher) Publish(context.Context, Event) error {
+	p.count++
+	return p.err
+}
+
+func TestMultiPublisher(t *testing.T) {
+	failing := &countingPublisher{err: errors.New("broker unavailable")}
+	ok := &countingPublisher{}
+
+	err := NewMultiPublisher(failing, ok).Publish(context.Background(), Event{Type: TypeUserCreated})
+	assert.ErrorIs(t, err, failing.err)
+	// A failing publisher does not stop the others
+	assert.Equal(t, 1, failing.count)
+	assert.Equal(t, 1, ok.count)
+
+	assert.NoError(t, NewMultiPublisher(ok).Publish(context.Background(), Event{Type: TypeUserCreated}))
+}
+
+func TestPublisherFunc(t *testing.T) {
+	var published []string
+	publisher := PublisherFunc(func(_ context.Context, event Event) error {
+		published = append(published, event.Type)
+		return assert.AnError
+	})
+
+	assert.ErrorIs(t, publisher.Publish(context.Background(), Event{Type: TypeUserDeleted}), assert.AnError)
+	assert.Equal(t, []string{TypeUserDeleted}, published)
+}
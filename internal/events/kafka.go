@@ -0,0 +1,131 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"user-service/internal/httputil"
+	"user-service/internal/metrics"
+)
+
+// Retry policy for Kafka deliveries
+const (
+	kafkaAttempts = 3
+	kafkaBackoff  = 100 * time.Millisecond
+	kafkaTimeout  = 2 * time.Second
+)
+
+// kafkaPublisher produces events to a Kafka topic through the Kafka REST proxy.
+// Each event is keyed by user ID so one user's events stay in order on a partition.
+type kafkaPublisher struct {
+	client   *http.Client
+	endpoint string
+	metrics  metrics.Recorder
+	backoff  time.Duration
+}
+
+// NewKafkaPublisher creates a publisher producing to topic through the REST proxy
+// at proxyURL. Every delivery is counted in events_published_total.
+func NewKafkaPublisher(proxyURL, topic string, metricsCollector metrics.Recorder) EventPublisher {
+	return &kafkaPublisher{
+		client:   &http.Client{Timeout: kafkaTimeout},
+		endpoint: proxyURL + "/topics/" + url.PathEscape(topic),
+		metrics:  metricsCollector,
+		backoff:  kafkaBackoff,
+	}
+}
+
+// kafkaRecords is the REST proxy's produce request body
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Event  `json:"value"`
+}
+
+// Publish produces the event, retrying failed deliveries with a growing backoff
+func (p *kafkaPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Key: strconv.Itoa(event.User.ID), Value: event}}})
+	if err != nil {
+		p.metrics.RecordEventPublished(event.Type, "error")
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	for attempt := 1; attempt <= kafkaAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(time.Duration(attempt-1) * p.backoff):
+			case <-ctx.Done():
+				p.metrics.RecordEventPublished(event.Type, "error")
+				return fmt.Errorf("failed to publish %s event: %w", event.Type, ctx.Err())
+			}
+		}
+		if err = p.produce(ctx, body, event.RequestID); err == nil {
+			p.metrics.RecordEventPublished(event.Type, "ok")
+			return nil
+		}
+	}
+
+	p.metrics.RecordEventPublished(event.Type, "error")
+	return fmt.Errorf("failed to publish %s event: %w", event.Type, err)
+}
+
+// produce sends one produce request to the REST proxy, on behalf of the request
+// with requestID when there was one
+func (p *kafkaPublisher) produce(ctx context.Context, body []byte, requestID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	if requestID != "" {
+		req.Header.Set(httputil.RequestIDHeader, requestID)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("kafka proxy returned %s", resp.Status)
+	}
+	return nil
+}
+
+// KafkaCheck returns a readiness probe asking the REST proxy at proxyURL for the
+// metadata of topic, which fails when the proxy or its brokers are unreachable
+func KafkaCheck(proxyURL, topic string) func(ctx context.Context) error {
+	client := &http.Client{Timeout: kafkaTimeout}
+	endpoint := proxyURL + "/topics/" + url.PathEscape(topic)
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("kafka proxy returned %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+)
+
+// publishedCount returns how many events of eventType were counted with status
+func publishedCount(t *testing.T, reg *prometheus.Registry, eventType, status string) float64 {
+	labels := map[string]string{"type": eventType, "status": status}
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "events_published_total" {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// newTestKafkaPublisher returns a publisher producing to server without waiting between attempts
+func newTestKafkaPublisher(server *httptest.Server, reg *prometheus.Registry) EventPublisher {
+	p := NewKafkaPublisher(server.URL, "user-events", metrics.New(reg, reg)).(*kafkaPublisher)
+	p.backoff = time.Millisecond
+	return p
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	event := Event{Type: TypeUserCreated, User: models.User{ID: 7, Name: "John Doe", Email: "john@example.com"}, RequestID: "req-1"}
+
+	t.Run("produces keyed record", func(t *testing.T) {
+		var body kafkaRecords
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "/topics/user-events", r.URL.Path)
+			assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "req-1", r.Header.Get("X-Request-ID"))
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		}))
+		defer server.Close()
+		reg := prometheus.NewRegistry()
+
+		assert.NoError(t, newTestKafkaPublisher(server, reg).Publish(context.Background(), event))
+		if assert.Len(t, body.Records, 1) {
+			assert.Equal(t, "7", body.Records[0].Key)
+			assert.Equal(t, event.Type, body.Records[0].Value.Type)
+			assert.Equal(t, event.User.Email, body.Records[0].Value.User.Email)
+			assert.Equal(t, "req-1", body.Records[0].Value.RequestID)
+		}
+		assert.Equal(t, 1.0, publishedCount(t, reg, TypeUserCreated, "ok"))
+	})
+
+	t.Run("retries failed delivery", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+		reg := prometheus.NewRegistry()
+
+		assert.NoError(t, newTestKafkaPublisher(server, reg).Publish(context.Background(), event))
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, 1.0, publishedCount(t, reg, TypeUserCreated, "ok"))
+		assert.Equal(t, 0.0, publishedCount(t, reg, TypeUserCreated, "error"))
+	})
+
+	t.Run("gives up after three attempts", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+		reg := prometheus.NewRegistry()
+
+		err := newTestKafkaPublisher(server, reg).Publish(context.Background(), event)
+		assert.ErrorContains(t, err, "500")
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, 1.0, publishedCount(t, reg, TypeUserCreated, "error"))
+		assert.Equal(t, 0.0, publishedCount(t, reg, TypeUserCreated, "ok"))
+	})
+}
+
+func TestKafkaCheck(t *testing.T) {
+	t.Run("passes when the proxy knows the topic", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.Equal(t, "/topics/user-events", r.URL.Path)
+			w.Write([]byte(`{"name":"user-events"}`))
+		}))
+		defer server.Close()
+
+		assert.NoError(t, KafkaCheck(server.URL, "user-events")(context.Background()))
+	})
+
+	t.Run("fails when the proxy cannot serve the topic", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "broker unavailable", http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		assert.EqualError(t, KafkaCheck(server.URL, "user-events")(context.Background()), "kafka proxy returned 500 Internal Server Error")
+	})
+}
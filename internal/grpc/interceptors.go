@@ -0,0 +1,118 @@
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"user-service/internal/metrics"
+	"user-service/internal/reqctx"
+)
+
+// Actor is the audit actor of calls authorized by the gRPC auth token
+const Actor = "grpc"
+
+// interceptor wraps one call, unary or streaming, to method. call runs the rest of the
+// chain with the context it is given.
+type interceptor func(ctx context.Context, method string, call func(context.Context) error) error
+
+// unary adapts i to unary calls
+func unary(i interceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var resp any
+		err := i(ctx, info.FullMethod, func(ctx context.Context) error {
+			var err error
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// stream adapts i to streaming calls
+func stream(i interceptor) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return i(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+			return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		})
+	}
+}
+
+// serverStream overrides the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// requestID gives every call a request ID, returned in the x-request-id header
+func requestID() interceptor {
+	return func(ctx context.Context, _ string, call func(context.Context) error) error {
+		id := uuid.New().String()
+		if err := grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id)); err != nil {
+			slog.Warn("Failed to set request ID header", "error", err, "request_id", id)
+		}
+		return call(reqctx.WithRequestID(ctx, id))
+	}
+}
+
+// recordMetrics records the method, status code and duration of every call
+func recordMetrics(metricsCollector metrics.Recorder) interceptor {
+	return func(ctx context.Context, method string, call func(context.Context) error) error {
+		start := time.Now()
+		metricsCollector.UpdateLastRequestTime()
+
+		err := call(ctx)
+		metricsCollector.RecordRPC(method, status.Code(err).String(), time.Since(start))
+		return err
+	}
+}
+
+// recovery turns a panic into an INTERNAL error
+func recovery(metricsCollector metrics.Recorder) interceptor {
+	return func(ctx context.Context, method string, call func(context.Context) error) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				requestID := reqctx.RequestIDFromContext(ctx)
+				slog.Error("Panic recovered", "error", recovered, "method", method, "request_id", requestID, "stack", string(debug.Stack()))
+				metricsCollector.RecordPanicRecovery()
+				metricsCollector.RecordError("panic", method)
+				err = status.Error(codes.Internal, "internal server error")
+			}
+		}()
+		return call(ctx)
+	}
+}
+
+// auth requires "authorization: Bearer <token>" metadata on every call. An empty
+// token leaves calls unauthenticated. Authorized calls carry Actor as their reqctx.Caller.
+func auth(token string) interceptor {
+	return func(ctx context.Context, method string, call func(context.Context) error) error {
+		if token == "" {
+			return call(ctx)
+		}
+
+		var presented string
+		var ok bool
+		if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+			presented, ok = strings.CutPrefix(values[0], "Bearer ")
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			requestID := reqctx.RequestIDFromContext(ctx)
+			slog.Warn("Rejected gRPC call", "method", method, "request_id", requestID)
+			return status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return call(reqctx.WithCaller(ctx, reqctx.Caller{Subject: Actor}))
+	}
+}
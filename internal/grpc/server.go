@@ -0,0 +1,212 @@
+// Package grpc serves the userservice.v1 gRPC API next to the HTTP one, backed by
+// the same UserService so both apply the same validation and report the same errors.
+package grpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	userservicev1 "user-service/api/userservice/v1"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
+	"user-service/internal/services"
+)
+
+// Page sizes for ListUsers
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// server implements userservice.v1.UserService on top of a UserService
+type server struct {
+	userservicev1.UnimplementedUserServiceServer
+	userService *services.UserService
+	watchers    *events.Broker
+}
+
+// NewServer creates a gRPC server for userService with the request ID, metrics,
+// recovery and auth interceptors, in that order. WatchUsers streams the events
+// published to watchers; close it before stopping the server so watches end.
+func NewServer(userService *services.UserService, watchers *events.Broker, metricsCollector metrics.Recorder, authToken string) *grpc.Server {
+	chain := []interceptor{requestID(), recordMetrics(metricsCollector), recovery(metricsCollector), auth(authToken)}
+	var unaries []grpc.UnaryServerInterceptor
+	var streams []grpc.StreamServerInterceptor
+	for _, i := range chain {
+		unaries = append(unaries, unary(i))
+		streams = append(streams, stream(i))
+	}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(unaries...), grpc.ChainStreamInterceptor(streams...))
+	userservicev1.RegisterUserServiceServer(s, &server{userService: userService, watchers: watchers})
+	return s
+}
+
+// GetUser returns one user, validating its ID as GET /user does
+func (s *server) GetUser(ctx context.Context, req *userservicev1.GetUserRequest) (*userservicev1.GetUserResponse, error) {
+	id, err := models.ParseUserID(strconv.Itoa(int(req.GetId())))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := s.userService.GetUser(ctx, id)
+	if err != nil {
+		return nil, toStatus(ctx, err, "failed to get user")
+	}
+	return &userservicev1.GetUserResponse{User: toProto(user)}, nil
+}
+
+// ListUsers returns a page of users in ID order. The page token is the opaque
+// encoding of the last ID on the previous page.
+func (s *server) ListUsers(ctx context.Context, req *userservicev1.ListUsersRequest) (*userservicev1.ListUsersResponse, error) {
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize < 0 || pageSize > maxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxPageSize)
+	}
+
+	var after int
+	if token := req.GetPageToken(); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err == nil {
+			after, err = strconv.Atoi(string(decoded))
+		}
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "page_token is invalid")
+		}
+	}
+
+	filter := models.UserFilter{Role: req.GetRole(), Status: req.GetStatus()}
+	if filter.Role != "" && !models.ValidRole(filter.Role) {
+		return nil, status.Error(codes.InvalidArgument, "role is invalid")
+	}
+	if filter.Status != "" && !models.ValidStatus(filter.Status) {
+		return nil, status.Error(codes.InvalidArgument, "status is invalid")
+	}
+
+	users, err := s.userService.ListUsers(ctx, filter)
+	if err != nil {
+		return nil, toStatus(ctx, err, "failed to list users")
+	}
+
+	resp := &userservicev1.ListUsersResponse{}
+	for i, user := range users {
+		if user.ID <= after {
+			continue
+		}
+		if len(resp.Users) == pageSize {
+			resp.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(users[i-1].ID)))
+			break
+		}
+		resp.Users = append(resp.Users, toProto(user))
+	}
+	return resp, nil
+}
+
+// CreateUser creates a user and returns it as stored
+func (s *server) CreateUser(ctx context.Context, req *userservicev1.CreateUserRequest) (*userservicev1.CreateUserResponse, error) {
+	created, err := s.userService.AddUser(ctx, models.User{Name: req.GetName(), Email: req.GetEmail(), Role: req.GetRole()})
+	if err != nil {
+		return nil, toStatus(ctx, err, "failed to create user")
+	}
+	return &userservicev1.CreateUserResponse{User: toProto(created)}, nil
+}
+
+// WatchUsers streams user events until the caller goes away. Headers are sent once
+// the watch is registered, so a caller may wait for them before making changes.
+func (s *server) WatchUsers(_ *userservicev1.WatchUsersRequest, stream grpc.ServerStreamingServer[userservicev1.WatchUsersResponse]) error {
+	ctx := stream.Context()
+	watch := s.watchers.Subscribe()
+	defer s.watchers.Unsubscribe(watch)
+
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watch.Events():
+			if !ok {
+				if errors.Is(watch.Err(), events.ErrSubscriberBehind) {
+					return status.Error(codes.ResourceExhausted, watch.Err().Error())
+				}
+				return status.Error(codes.Unavailable, events.ErrShuttingDown.Error())
+			}
+			if err := stream.Send(toEventProto(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toStatus maps a service error to a gRPC status, as the HTTP handlers map them to
+// status codes. Unexpected errors are logged and hidden behind message.
+func toStatus(ctx context.Context, err error, message string) error {
+	var validationErrs models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		st := status.New(codes.InvalidArgument, validationErrs.Error())
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(validationErrs))
+		for i, e := range validationErrs {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: e.Field, Description: e.Message, Reason: e.Rule}
+		}
+		if detailed, detailErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	case errors.Is(err, repository.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, services.ErrQueryTimeout):
+		return status.Error(codes.Unavailable, services.ErrQueryTimeout.Error())
+	default:
+		requestID := reqctx.RequestIDFromContext(ctx)
+		slog.Error(fmt.Sprintf("gRPC call %s", message), "error", err, "request_id", requestID)
+		return status.Error(codes.Internal, message)
+	}
+}
+
+// toProto converts a user to its API message
+func toProto(user models.User) *userservicev1.User {
+	msg := &userservicev1.User{
+		Id:     int32(user.ID),
+		Name:   user.Name,
+		Email:  user.Email,
+		Role:   user.Role,
+		Status: user.Status,
+	}
+	if !user.CreatedAt.IsZero() {
+		msg.CreatedAt = timestamppb.New(user.CreatedAt)
+	}
+	if !user.UpdatedAt.IsZero() {
+		msg.UpdatedAt = timestamppb.New(user.UpdatedAt)
+	}
+	return msg
+}
+
+// toEventProto converts an event to its API message
+func toEventProto(event events.Event) *userservicev1.WatchUsersResponse {
+	return &userservicev1.WatchUsersResponse{
+		Type:      event.Type,
+		User:      toProto(event.User),
+		RequestId: event.RequestID,
+		Timestamp: timestamppb.New(event.Timestamp),
+	}
+}
@@ -0,0 +1,338 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	userservicev1 "user-service/api/userservice/v1"
+	"user-service/internal/audit"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+)
+
+// testServer serves the API over an in-memory listener
+type testServer struct {
+	client      userservicev1.UserServiceClient
+	userService *services.UserService
+	watchers    *events.Broker
+	audit       audit.Store
+	reg         *prometheus.Registry
+}
+
+// newTestServer starts a server for the seed users, requiring authToken when it is set
+func newTestServer(t *testing.T, authToken string) *testServer {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	watchers := events.NewBroker()
+	log := audit.NewMemoryStore()
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		services.WithAudit(log, nil), services.WithEventPublisher(watchers))
+
+	return &testServer{
+		client:      dial(t, NewServer(userService, watchers, metricsCollector, authToken)),
+		userService: userService,
+		watchers:    watchers,
+		audit:       log,
+		reg:         reg,
+	}
+}
+
+// dial serves srv over an in-memory listener and returns a client connected to it
+func dial(t *testing.T, srv *grpc.Server) userservicev1.UserServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return userservicev1.NewUserServiceClient(conn)
+}
+
+// rpcCount returns grpc_requests_total for method and code
+func (s *testServer) rpcCount(t *testing.T, method, code string) float64 {
+	families, err := s.reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "grpc_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["method"] == method && labels["code"] == code {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestGetUser(t *testing.T) {
+	s := newTestServer(t, "")
+	ctx := context.Background()
+
+	var header metadata.MD
+	resp, err := s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 1}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, "John Doe", resp.GetUser().GetName())
+	assert.Equal(t, "john@example.com", resp.GetUser().GetEmail())
+	assert.Len(t, header.Get("x-request-id"), 1)
+
+	_, err = s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 999})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 0})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "out of range")
+
+	method := userservicev1.UserService_GetUser_FullMethodName
+	assert.Equal(t, 1.0, s.rpcCount(t, method, "OK"))
+	assert.Equal(t, 1.0, s.rpcCount(t, method, "NotFound"))
+	assert.Equal(t, 1.0, s.rpcCount(t, method, "InvalidArgument"))
+}
+
+func TestGetUserFromDatabase(t *testing.T) {
+	dbMock := &mocks.MockDBTX{}
+	found := &mocks.MockRow{}
+	found.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = 1
+		*arg[1].(*string) = "John Doe"
+		*arg[2].(*string) = "john@example.com"
+	})
+	missing := &mocks.MockRow{}
+	missing.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+	broken := &mocks.MockRow{}
+	broken.On("Scan", mock.Anything).Return(assert.AnError)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(found)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 100).Return(missing)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 999).Return(broken)
+
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+	s := &testServer{client: dial(t, NewServer(userService, events.NewBroker(), metricsCollector, "")), reg: reg}
+	ctx := context.Background()
+
+	resp, err := s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), resp.GetUser().GetId())
+	assert.Equal(t, "John Doe", resp.GetUser().GetName())
+
+	_, err = s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 100})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Database failures are not leaked to callers
+	_, err = s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 999})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "failed to get user", status.Convert(err).Message())
+
+	dbMock.AssertExpectations(t)
+	method := userservicev1.UserService_GetUser_FullMethodName
+	assert.Equal(t, 1.0, s.rpcCount(t, method, "OK"))
+	assert.Equal(t, 1.0, s.rpcCount(t, method, "NotFound"))
+	assert.Equal(t, 1.0, s.rpcCount(t, method, "Internal"))
+}
+
+func TestListUsers(t *testing.T) {
+	s := newTestServer(t, "")
+	ctx := context.Background()
+
+	var ids []int32
+	req := &userservicev1.ListUsersRequest{PageSize: 3}
+	for pages := 0; ; pages++ {
+		resp, err := s.client.ListUsers(ctx, req)
+		assert.NoError(t, err)
+		for _, user := range resp.GetUsers() {
+			ids = append(ids, user.GetId())
+		}
+		if resp.GetNextPageToken() == "" || pages > 2 {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	assert.Equal(t, []int32{1, 2, 3, 4}, ids)
+
+	resp, err := s.client.ListUsers(ctx, &userservicev1.ListUsersRequest{PageSize: 4})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetUsers(), 4)
+	assert.Empty(t, resp.GetNextPageToken(), "an exactly full last page has no next page")
+
+	for _, req := range []*userservicev1.ListUsersRequest{
+		{PageSize: -1},
+		{PageSize: maxPageSize + 1},
+		{PageToken: "not a token"},
+		{Role: "superuser"},
+		{Status: "banned"},
+	} {
+		_, err := s.client.ListUsers(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "request %v", req)
+	}
+}
+
+// userRows returns rows with a user for each of ids, in the order given
+func userRows(ids ...int) *mocks.MockRows {
+	rows := &mocks.MockRows{}
+	rows.On("Close").Return()
+	rows.On("Err").Return(nil)
+	for _, id := range ids {
+		rows.On("Next").Return(true).Once()
+		rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).([]interface{})[0].(*int) = id
+		}).Once()
+	}
+	rows.On("Next").Return(false)
+	return rows
+}
+
+func TestListUsersFromDatabase(t *testing.T) {
+	// The pages are cut by ID, so they only hold together if the database
+	// returns the users in ID order
+	dbMock := &mocks.MockDBTX{}
+	dbMock.On("Query", mock.Anything, queries.Default.ListUsers).Return(userRows(1, 2, 3, 4, 5), nil).Once()
+	dbMock.On("Query", mock.Anything, queries.Default.ListUsers).Return(userRows(1, 2, 3, 4, 5), nil).Once()
+
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+	s := &testServer{client: dial(t, NewServer(userService, events.NewBroker(), metricsCollector, "")), reg: reg}
+	ctx := context.Background()
+
+	first, err := s.client.ListUsers(ctx, &userservicev1.ListUsersRequest{PageSize: 2})
+	assert.NoError(t, err)
+	second, err := s.client.ListUsers(ctx, &userservicev1.ListUsersRequest{PageSize: 2, PageToken: first.GetNextPageToken()})
+	assert.NoError(t, err)
+
+	seen := map[int32]bool{}
+	for _, page := range []*userservicev1.ListUsersResponse{first, second} {
+		assert.Len(t, page.GetUsers(), 2)
+		for _, user := range page.GetUsers() {
+			assert.False(t, seen[user.GetId()], "user %d is on both pages", user.GetId())
+			seen[user.GetId()] = true
+		}
+	}
+	assert.Equal(t, map[int32]bool{1: true, 2: true, 3: true, 4: true}, seen)
+	dbMock.AssertExpectations(t)
+}
+
+func TestCreateUser(t *testing.T) {
+	s := newTestServer(t, "internal")
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer internal")
+
+	resp, err := s.client.CreateUser(ctx, &userservicev1.CreateUserRequest{Name: " Ada Lovelace ", Email: "ada@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", resp.GetUser().GetName())
+	assert.Equal(t, models.DefaultRole, resp.GetUser().GetRole())
+	assert.NotZero(t, resp.GetUser().GetId())
+
+	entries, err := s.audit.List(ctx, audit.Filter{UserID: int(resp.GetUser().GetId())})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, Actor, entries[0].Actor)
+		assert.NotEmpty(t, entries[0].RequestID)
+	}
+
+	_, err = s.client.CreateUser(ctx, &userservicev1.CreateUserRequest{Name: "Ada", Email: "ada@example.com"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = s.client.CreateUser(ctx, &userservicev1.CreateUserRequest{Email: "invalid", Role: "superuser"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				fields = append(fields, violation.GetField())
+			}
+		}
+	}
+	assert.Equal(t, []string{"name", "email", "role"}, fields)
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t, "internal")
+
+	_, err := s.client.GetUser(context.Background(), &userservicev1.GetUserRequest{Id: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	for _, value := range []string{"Bearer wrong", "internal"} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", value)
+		_, err = s.client.GetUser(ctx, &userservicev1.GetUserRequest{Id: 1})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), "authorization %q", value)
+	}
+
+	stream, err := s.client.WatchUsers(context.Background(), &userservicev1.WatchUsersRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestWatchUsers(t *testing.T) {
+	s := newTestServer(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := s.client.WatchUsers(ctx, &userservicev1.WatchUsersRequest{})
+	assert.NoError(t, err)
+	header, err := stream.Header()
+	assert.NoError(t, err)
+	assert.Len(t, header.Get("x-request-id"), 1)
+
+	_, err = s.userService.DisableUser(ctx, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, s.userService.DeleteUser(ctx, 3))
+
+	for _, want := range []struct {
+		eventType string
+		id        int32
+	}{{events.TypeUserUpdated, 2}, {events.TypeUserDeleted, 3}} {
+		event, err := stream.Recv()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, want.eventType, event.GetType())
+		assert.Equal(t, want.id, event.GetUser().GetId())
+	}
+	assert.Equal(t, models.StatusDisabled, s.mustGet(t, 2).GetStatus())
+
+	s.watchers.Close()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// mustGet returns user id through the API
+func (s *testServer) mustGet(t *testing.T, id int32) *userservicev1.User {
+	resp, err := s.client.GetUser(context.Background(), &userservicev1.GetUserRequest{Id: id})
+	assert.NoError(t, err)
+	return resp.GetUser()
+}
+
+func TestRecovery(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	intercept := recovery(metrics.New(reg, reg))
+
+	err := intercept(context.Background(), "/test", func(context.Context) error { panic("boom") })
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
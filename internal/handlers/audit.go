@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"user-service/internal/audit"
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+	"user-service/internal/services"
+)
+
+// Page sizes for GET /admin/audit
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// AdminAuditLog handles GET /admin/audit requests, newest entries first. It takes an
+// optional ?user_id=, a ?limit= page size and a ?before= cursor; a full page carries
+// next_before, the cursor for the following page.
+func (h *UserHandler) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+	query := r.URL.Query()
+
+	filter := audit.Filter{Limit: defaultAuditLimit}
+	if idStr := query.Get("user_id"); idStr != "" {
+		id, err := models.ParseUserID(idStr)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Invalid user_id parameter", "user_id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, "user_id parameter is invalid", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = id
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			logging.FromContext(r.Context()).Warn("Invalid limit parameter", "limit", limitStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, "limit parameter must be between 1 and "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		before, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || before < 1 {
+			logging.FromContext(r.Context()).Warn("Invalid before parameter", "before", beforeStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, "before parameter is invalid", http.StatusBadRequest)
+			return
+		}
+		filter.Before = before
+	}
+
+	entries, err := h.userService.AuditLog(r.Context(), filter)
+	if err != nil {
+		if errors.Is(err, services.ErrAuditDisabled) {
+			httputil.Error(r.Context(), w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to read audit log", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	response := map[string]interface{}{"entries": entries}
+	if len(entries) == filter.Limit {
+		response["next_before"] = entries[len(entries)-1].ID
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode audit log", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned audit log", "count", len(entries), "user_id", filter.UserID, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
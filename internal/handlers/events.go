@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"user-service/internal/events"
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/reqctx"
+)
+
+// Timings of an event stream
+const (
+	// heartbeatInterval is how often an idle stream gets a comment, so proxies do
+	// not time the connection out
+	heartbeatInterval = 15 * time.Second
+	// streamWriteTimeout bounds each write, ending the stream of a client that stopped reading
+	streamWriteTimeout = 10 * time.Second
+)
+
+// EventsHandler streams user events to clients as Server-Sent Events
+type EventsHandler struct {
+	broker       *events.Broker
+	heartbeat    time.Duration
+	writeTimeout time.Duration
+}
+
+// NewEventsHandler creates a handler streaming the events published to broker
+func NewEventsHandler(broker *events.Broker) *EventsHandler {
+	return &EventsHandler{broker: broker, heartbeat: heartbeatInterval, writeTimeout: streamWriteTimeout}
+}
+
+// Stream handles GET /users/events requests. Each event is sent as a frame such as
+// "event: user.created\ndata: {...}\n\n" until the client disconnects, falls too
+// far behind or the server shuts down. A comment is sent once the stream is
+// subscribed, so clients can wait for it before making changes.
+func (h *EventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logging.FromContext(r.Context()).Error("Event stream cannot be flushed", "request_id", requestID)
+		httputil.Error(r.Context(), w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// Each write gets its own deadline in place of the server's write timeout,
+	// which would otherwise cut every stream short
+	rc := http.NewResponseController(w)
+	send := func(frame string) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(h.writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := io.WriteString(w, frame); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	sub := h.broker.Subscribe()
+	defer h.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := send(": subscribed\n\n"); err != nil {
+		return
+	}
+	logging.FromContext(r.Context()).Info("Event stream opened", "remote_addr", r.RemoteAddr, "request_id", requestID)
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var frame string
+		select {
+		case <-r.Context().Done():
+			logging.FromContext(r.Context()).Info("Event stream closed by client", "remote_addr", r.RemoteAddr, "request_id", requestID)
+			return
+		case <-heartbeat.C:
+			frame = ": heartbeat\n\n"
+		case event, ok := <-sub.Events():
+			if !ok {
+				logging.FromContext(r.Context()).Info("Event stream ended", "reason", sub.Err(), "remote_addr", r.RemoteAddr, "request_id", requestID)
+				return
+			}
+			data, err := json.Marshal(httputil.InFieldCase(r.Context(), event))
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Failed to encode event", "error", err, "type", event.Type, "request_id", requestID)
+				continue
+			}
+			frame = fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := send(frame); err != nil {
+			if httputil.ClientGone(err) {
+				logging.FromContext(r.Context()).Debug("Event stream closed by client", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+				return
+			}
+			logging.FromContext(r.Context()).Info("Event stream ended", "reason", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			return
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"user-service/internal/events"
+	"user-service/internal/models"
+)
+
+// readFrame reads lines from an event stream up to the blank line ending a frame
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var frame strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event stream: %v", err)
+		}
+		if line == "\n" {
+			return frame.String()
+		}
+		frame.WriteString(line)
+	}
+}
+
+// openStream starts streaming from server, waiting until it is subscribed
+func openStream(t *testing.T, ctx context.Context, server *httptest.Server) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected Content-Type text/event-stream, got %s", ct)
+	}
+	body := bufio.NewReader(resp.Body)
+	if frame := readFrame(t, body); frame != ": subscribed\n" {
+		t.Fatalf("Expected the subscribed comment, got %q", frame)
+	}
+	return resp, body
+}
+
+// serveStream serves a single stream from handler, signalling done once it returns
+func serveStream(t *testing.T, handler *EventsHandler) (*httptest.Server, <-chan struct{}) {
+	done := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.Stream(w, r)
+		done <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+	return server, done
+}
+
+// waitDone fails the test unless a stream returns soon
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream handler to return")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	t.Run("sends published events", func(t *testing.T) {
+		broker := events.NewBroker()
+		server, _ := serveStream(t, NewEventsHandler(broker))
+		_, body := openStream(t, context.Background(), server)
+
+		event := events.Event{Type: events.TypeUserCreated, User: models.User{ID: 5, Name: "Grace Hopper", Email: "grace@example.com"}, RequestID: "req-1"}
+		if err := broker.Publish(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+
+		frame := readFrame(t, body)
+		eventLine, dataLine, _ := strings.Cut(frame, "\n")
+		if eventLine != "event: user.created" {
+			t.Errorf("Expected event line for user.created, got %q", eventLine)
+		}
+		var got events.Event
+		if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(dataLine, "data: "), "\n")), &got); err != nil {
+			t.Fatalf("Failed to decode data line %q: %v", dataLine, err)
+		}
+		if got.User.Email != "grace@example.com" || got.RequestID != "req-1" {
+			t.Errorf("Expected the published event, got %+v", got)
+		}
+	})
+
+	t.Run("sends heartbeats while idle", func(t *testing.T) {
+		handler := NewEventsHandler(events.NewBroker())
+		handler.heartbeat = 10 * time.Millisecond
+		server, _ := serveStream(t, handler)
+		_, body := openStream(t, context.Background(), server)
+
+		if frame := readFrame(t, body); frame != ": heartbeat\n" {
+			t.Errorf("Expected a heartbeat comment, got %q", frame)
+		}
+	})
+
+	t.Run("returns when the client disconnects", func(t *testing.T) {
+		server, done := serveStream(t, NewEventsHandler(events.NewBroker()))
+		ctx, cancel := context.WithCancel(context.Background())
+		openStream(t, ctx, server)
+
+		cancel()
+		waitDone(t, done)
+	})
+
+	t.Run("ends the stream on shutdown", func(t *testing.T) {
+		broker := events.NewBroker()
+		server, done := serveStream(t, NewEventsHandler(broker))
+		_, body := openStream(t, context.Background(), server)
+
+		broker.Close()
+		waitDone(t, done)
+		if rest, err := io.ReadAll(body); err != nil || len(rest) != 0 {
+			t.Errorf("Expected the stream to end cleanly, got %q, %v", rest, err)
+		}
+	})
+
+	t.Run("ends the stream of a client that stops reading", func(t *testing.T) {
+		broker := events.NewBroker()
+		handler := NewEventsHandler(broker)
+		handler.writeTimeout = 50 * time.Millisecond
+		server, done := serveStream(t, handler)
+		openStream(t, context.Background(), server)
+
+		// Events the client never reads fill the connection, until a write times
+		// out or the subscription falls behind
+		event := events.Event{Type: events.TypeUserUpdated, User: models.User{Name: strings.Repeat("x", 64<<10)}}
+		deadline := time.After(5 * time.Second)
+		for {
+			broker.Publish(context.Background(), event)
+			select {
+			case <-done:
+				return
+			case <-deadline:
+				t.Fatal("Expected the stream handler to return")
+			case <-time.After(time.Millisecond):
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+)
+
+// exportFlushInterval is how many users an export writes between flushes
+const exportFlushInterval = 100
+
+// exportFormat renders the users of an export
+type exportFormat struct {
+	name        string
+	contentType string
+	// filename, if set, makes browsers save the export under that name
+	filename string
+	// header, if set, is written before the first user, even when there are none
+	header func() error
+	encode func(models.User) error
+	// flush, if set, writes out what encode buffered before the response is flushed
+	flush func() error
+}
+
+// ExportUsers handles GET /users/export requests. Every user is streamed as one
+// line of JSON (application/x-ndjson) as it is read from the database.
+func (h *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+	h.export(w, r, exportFormat{
+		name:        "ndjson",
+		contentType: "application/x-ndjson",
+		encode:      func(user models.User) error { return encoder.Encode(user) },
+	})
+}
+
+// csvColumns is the header row of a CSV export
+var csvColumns = []string{"id", "name", "email"}
+
+// ExportUsersCSV handles GET /users/export.csv requests, streaming the id, name
+// and email of every user as a CSV attachment for spreadsheets
+func (h *UserHandler) ExportUsersCSV(w http.ResponseWriter, r *http.Request) {
+	writer := csv.NewWriter(w)
+	h.export(w, r, exportFormat{
+		name:        "csv",
+		contentType: "text/csv; charset=utf-8",
+		filename:    "users.csv",
+		header:      func() error { return writer.Write(csvColumns) },
+		encode: func(user models.User) error {
+			return writer.Write([]string{strconv.Itoa(user.ID), csvCell(user.Name), csvCell(user.Email)})
+		},
+		flush: func() error {
+			writer.Flush()
+			return writer.Error()
+		},
+	})
+}
+
+// csvCell neutralises a value a spreadsheet would run as a formula by prefixing it
+// with a quote. encoding/csv takes care of commas, quotes and newlines.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// export streams every user in format as it is read from the database, so memory
+// stays flat however large the table is. Once the first user is sent the status
+// can no longer change, so a later failure only ends the stream early.
+func (h *UserHandler) export(w http.ResponseWriter, r *http.Request, format exportFormat) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	// Each batch gets its own write deadline in place of the server's write
+	// timeout, which would otherwise cut large exports short
+	rc := http.NewResponseController(w)
+	extendDeadline := func() error {
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+	flush := func() error {
+		if format.flush != nil {
+			if err := format.flush(); err != nil {
+				return err
+			}
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return extendDeadline()
+	}
+	started := false
+	start := func() error {
+		if err := extendDeadline(); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("X-Accel-Buffering", "no")
+		if format.filename != "" {
+			w.Header().Set("Content-Disposition", `attachment; filename="`+format.filename+`"`)
+		}
+		w.WriteHeader(http.StatusOK)
+		started = true
+		if format.header != nil {
+			return format.header()
+		}
+		return nil
+	}
+
+	count := 0
+	err := h.userService.ExportUsers(r.Context(), func(user models.User) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := format.encode(user); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = flush()
+	}
+
+	switch {
+	case err == nil:
+		logging.FromContext(r.Context()).Info("Successfully exported users", "format", format.name, "count", count, "remote_addr", r.RemoteAddr, "request_id", requestID)
+	case r.Context().Err() != nil, httputil.ClientGone(err):
+		logging.FromContext(r.Context()).Debug("Users export cancelled by the client", "format", format.name, "count", count, "remote_addr", r.RemoteAddr, "request_id", requestID)
+	case started:
+		logging.FromContext(r.Context()).Error("Users export ended early", "format", format.name, "error", err, "count", count, "request_id", requestID)
+	default:
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to export users", "format", format.name, "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to export users", http.StatusInternalServerError)
+	}
+}
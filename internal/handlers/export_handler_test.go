@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+)
+
+func TestExportUsers(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	t.Run("streams every user as a line of JSON", func(t *testing.T) {
+		// More users than one flush interval, so the export is flushed midway
+		const total = 2*exportFlushInterval + 50
+		seed := make([]models.User, 0, total)
+		for id := 1; id <= total; id++ {
+			seed = append(seed, models.User{ID: id, Name: fmt.Sprintf("User %d", id), Email: fmt.Sprintf("user%d@example.com", id)})
+		}
+		repo := repository.NewInMemoryRepository(seed...)
+		if err := repo.Delete(context.Background(), total); err != nil {
+			t.Fatal(err)
+		}
+		userHandler := NewUserHandler(services.NewUserService(repo, metricsCollector))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ExportUsers).ServeHTTP(rr, httptest.NewRequest("GET", "/users/export", nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("Expected Content-Type application/x-ndjson, got %q", got)
+		}
+		if !rr.Flushed {
+			t.Error("Expected the export to be flushed")
+		}
+
+		scanner := bufio.NewScanner(rr.Body)
+		lines := 0
+		for scanner.Scan() {
+			lines++
+			var user models.User
+			if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+				t.Fatalf("Line %d is not a user: %v", lines, err)
+			}
+			if user.ID != lines || user.Email != fmt.Sprintf("user%d@example.com", lines) {
+				t.Errorf("Line %d = %+v, want user %d in ID order", lines, user, lines)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		// The deleted user is left out
+		if lines != total-1 {
+			t.Errorf("Expected %d users, got %d", total-1, lines)
+		}
+	})
+
+	t.Run("empty table", func(t *testing.T) {
+		userHandler := NewUserHandler(services.NewUserService(repository.NewInMemoryRepository(), metricsCollector))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ExportUsers).ServeHTTP(rr, httptest.NewRequest("GET", "/users/export", nil))
+
+		if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+			t.Errorf("Expected an empty 200 response, got %d %q", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("Expected Content-Type application/x-ndjson, got %q", got)
+		}
+	})
+
+	t.Run("query failure", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("Query", mock.Anything, queries.Default.ExportUsers).Return(nil, errors.New("connection refused"))
+		userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ExportUsers).ServeHTTP(rr, httptest.NewRequest("GET", "/users/export", nil))
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+		if message := errorMessage(t, rr); message != "failed to export users" {
+			t.Errorf("handler returned wrong error: got %q", message)
+		}
+		dbMock.AssertExpectations(t)
+	})
+}
+
+func TestExportUsersCSV(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	repo := repository.NewInMemoryRepository(
+		models.User{ID: 1, Name: `Doe, John "JD"`, Email: "john@example.com"},
+		models.User{ID: 2, Name: "=HYPERLINK(\"http://evil.example\")", Email: "jane@example.com"},
+	)
+	userHandler := NewUserHandler(services.NewUserService(repo, metrics.New(reg, reg)))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(userHandler.ExportUsersCSV).ServeHTTP(rr, httptest.NewRequest("GET", "/users/export.csv", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Expected a CSV Content-Type, got %q", got)
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="users.csv"` {
+		t.Errorf("Expected an attachment, got %q", got)
+	}
+
+	want := "id,name,email\n" +
+		`1,"Doe, John ""JD""",john@example.com` + "\n" +
+		`2,"'=HYPERLINK(""http://evil.example"")",jane@example.com` + "\n"
+	if body := rr.Body.String(); body != want {
+		t.Errorf("handler returned wrong CSV:\ngot  %q\nwant %q", body, want)
+	}
+
+	// The output reads back as the users
+	records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse the CSV: %v", err)
+	}
+	if len(records) != 3 || records[1][1] != `Doe, John "JD"` {
+		t.Errorf("Expected the header and 2 users, got %q", records)
+	}
+}
+
+func TestExportUsersCSVEmpty(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	userHandler := NewUserHandler(services.NewUserService(repository.NewInMemoryRepository(), metrics.New(reg, reg)))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(userHandler.ExportUsersCSV).ServeHTTP(rr, httptest.NewRequest("GET", "/users/export.csv", nil))
+
+	// The header row is written even without users
+	if rr.Code != http.StatusOK || rr.Body.String() != "id,name,email\n" {
+		t.Errorf("Expected only the header row, got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/location"
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
+	"user-service/internal/services"
+)
+
+// Page sizes for the users query
+const (
+	defaultGraphQLFirst = 20
+	maxGraphQLFirst     = 100
+)
+
+// maxGraphQLRequestBytes bounds a GraphQL request body
+const maxGraphQLRequestBytes = 64 << 10
+
+// Codes carried in the extensions of a GraphQL error, each with the status REST
+// responds with for the same failure
+const (
+	codeBadRequest  = "BAD_REQUEST"
+	codeNotFound    = "NOT_FOUND"
+	codeConflict    = "CONFLICT"
+	codeValidation  = "VALIDATION"
+	codeUnavailable = "UNAVAILABLE"
+	codeInternal    = "INTERNAL"
+)
+
+var codeStatus = map[string]int{
+	codeBadRequest:  http.StatusBadRequest,
+	codeNotFound:    http.StatusNotFound,
+	codeConflict:    http.StatusConflict,
+	codeValidation:  http.StatusUnprocessableEntity,
+	codeUnavailable: http.StatusServiceUnavailable,
+	codeInternal:    http.StatusInternalServerError,
+}
+
+// graphQLRequest is the body of a POST /graphql request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLError is a failed field or query, reported with extensions such as
+// {"code":"NOT_FOUND","status":404}
+type graphQLError struct {
+	code    string
+	message string
+	// details lists every failed rule of a VALIDATION error
+	details models.ValidationErrors
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+// Extensions is added to the error in the response by graphql-go
+func (e *graphQLError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.code, "status": codeStatus[e.code]}
+	if e.details != nil {
+		extensions["details"] = e.details
+	}
+	return extensions
+}
+
+// badRequest reports invalid arguments, as REST does with a 400
+func badRequest(format string, args ...interface{}) error {
+	return &graphQLError{code: codeBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// toGraphQLError maps a service error to the code REST uses for it. Unexpected
+// errors are logged and reported as message without their cause.
+func toGraphQLError(ctx context.Context, err error, message string) error {
+	var validationErrs models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		return &graphQLError{code: codeValidation, message: validationErrs.Error(), details: validationErrs}
+	case errors.Is(err, repository.ErrNotFound):
+		return &graphQLError{code: codeNotFound, message: err.Error()}
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		return &graphQLError{code: codeConflict, message: err.Error()}
+	case errors.Is(err, services.ErrQueryTimeout):
+		return &graphQLError{code: codeUnavailable, message: services.ErrQueryTimeout.Error()}
+	}
+
+	requestID := reqctx.RequestIDFromContext(ctx)
+	logging.FromContext(ctx).Error("GraphQL resolver failed", "error", err, "message", message, "request_id", requestID)
+	return &graphQLError{code: codeInternal, message: message}
+}
+
+// GraphQLHandler serves user queries and mutations over GraphQL
+type GraphQLHandler struct {
+	schema graphql.Schema
+}
+
+// NewGraphQLHandler creates a GraphQL handler resolved by userService, recording
+// the duration of every resolver
+func NewGraphQLHandler(userService *services.UserService, metricsCollector metrics.Recorder) *GraphQLHandler {
+	// timed records how long resolve takes under field, as in "Query.user"
+	timed := func(field string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			start := time.Now()
+			defer func() { metricsCollector.RecordResolver(field, time.Since(start)) }()
+			return resolve(p)
+		}
+	}
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"name":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"email":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"role":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"status": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"createdAt": &graphql.Field{
+				Type:    graphql.DateTime,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(models.User).CreatedAt, nil },
+			},
+			"updatedAt": &graphql.Field{
+				Type:    graphql.DateTime,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(models.User).UpdatedAt, nil },
+			},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"endCursor":   &graphql.Field{Type: graphql.String},
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+
+	userConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"nodes":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType)))},
+			"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: timed("Query.user", func(p graphql.ResolveParams) (interface{}, error) {
+					// Validate the ID as GET /user does, with the same messages
+					id, err := models.ParseUserID(strconv.Itoa(p.Args["id"].(int)))
+					if err != nil {
+						return nil, badRequest("%v", err)
+					}
+					user, err := userService.GetUser(p.Context, id)
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err, "failed to get user")
+					}
+					return user, nil
+				}),
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewNonNull(userConnectionType),
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultGraphQLFirst},
+					"after": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: timed("Query.users", func(p graphql.ResolveParams) (interface{}, error) {
+					first := p.Args["first"].(int)
+					if first < 1 || first > maxGraphQLFirst {
+						return nil, badRequest("first must be between 1 and %d", maxGraphQLFirst)
+					}
+					var after int
+					if cursor, ok := p.Args["after"].(string); ok {
+						var err error
+						if after, err = decodeCursor(cursor); err != nil {
+							return nil, badRequest("after is not a valid cursor")
+						}
+					}
+
+					users, err := userService.ListUsers(p.Context, models.UserFilter{})
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err, "failed to list users")
+					}
+					return userConnection(users, first, after), nil
+				}),
+			},
+		},
+	})
+
+	createUserInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CreateUserInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"email": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"role":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(createUserInput)},
+				},
+				Resolve: timed("Mutation.createUser", func(p graphql.ResolveParams) (interface{}, error) {
+					input := p.Args["input"].(map[string]interface{})
+					name, _ := input["name"].(string)
+					email, _ := input["email"].(string)
+					role, _ := input["role"].(string)
+
+					created, err := userService.AddUser(p.Context, models.User{Name: name, Email: email, Role: role})
+					if err != nil {
+						return nil, toGraphQLError(p.Context, err, "failed to save user")
+					}
+					return created, nil
+				}),
+			},
+		},
+	})
+
+	// The schema is fixed, so failing to build it is a programming error
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
+	}
+	return &GraphQLHandler{schema: schema}
+}
+
+// userConnection returns the page of up to first users in ID order whose IDs
+// follow after, as a UserConnection
+func userConnection(users []models.User, first, after int) map[string]interface{} {
+	slices.SortFunc(users, func(a, b models.User) int { return cmp.Compare(a.ID, b.ID) })
+	total := len(users)
+	i, _ := slices.BinarySearchFunc(users, after+1, func(u models.User, id int) int { return cmp.Compare(u.ID, id) })
+	users = users[i:]
+
+	hasNextPage := len(users) > first
+	users = users[:min(first, len(users))]
+	var endCursor interface{}
+	if len(users) > 0 {
+		endCursor = encodeCursor(users[len(users)-1].ID)
+	}
+
+	return map[string]interface{}{
+		"nodes":      users,
+		"pageInfo":   map[string]interface{}{"endCursor": endCursor, "hasNextPage": hasNextPage},
+		"totalCount": total,
+	}
+}
+
+// encodeCursor returns the opaque cursor for the user with id, encoded as gRPC page tokens are
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+// decodeCursor returns the user ID a cursor from encodeCursor refers to
+func decodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(decoded))
+}
+
+// Query handles POST /graphql requests. As is usual for GraphQL, a query that fails,
+// for example on a missing user, still gets a 200 with the failure in its errors.
+// Every error carries the code and status REST reports the same failure with.
+func (h *GraphQLHandler) Query(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	var body graphQLRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLRequestBytes)).Decode(&body); err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid GraphQL body", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httputil.Error(r.Context(), w, "request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			httputil.Error(r.Context(), w, "invalid request body", http.StatusBadRequest)
+		}
+		return
+	}
+	if body.Query == "" {
+		httputil.Error(r.Context(), w, "query is missing", http.StatusBadRequest)
+		return
+	}
+
+	var result *graphql.Result
+	if err := checkLimits(body.Query, body.Variables); err != nil {
+		logging.FromContext(r.Context()).Warn("GraphQL query over limits", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		result = &graphql.Result{Errors: []gqlerrors.FormattedError{{
+			Message:    err.Error(),
+			Locations:  []location.SourceLocation{},
+			Extensions: err.Extensions(),
+		}}}
+	} else {
+		result = graphql.Do(graphql.Params{
+			Schema:         h.schema,
+			RequestString:  body.Query,
+			VariableValues: body.Variables,
+			OperationName:  body.OperationName,
+			Context:        r.Context(),
+		})
+	}
+
+	// Resolvers report their own codes. The rest are syntax and validation errors,
+	// which have no path, and panics recovered by graphql-go, which do. Every error
+	// carries the request ID, as REST error bodies do.
+	for i, e := range result.Errors {
+		if e.Extensions == nil {
+			code := codeBadRequest
+			if len(e.Path) > 0 {
+				logging.FromContext(r.Context()).Error("GraphQL resolver panicked", "error", e.Message, "path", e.Path, "request_id", requestID)
+				code = codeInternal
+				result.Errors[i].Message = "internal server error"
+			}
+			result.Errors[i].Extensions = (&graphQLError{code: code}).Extensions()
+		}
+		if requestID != "" {
+			result.Errors[i].Extensions["request_id"] = requestID
+		}
+	}
+
+	// The keys of data are the fields the query asked for, so they keep their case
+	if err := writeJSON(w, r.WithContext(httputil.WithFieldCase(r.Context(), httputil.SnakeCase)), http.StatusOK, result); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode GraphQL result", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Served GraphQL query", "errors", len(result.Errors), "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+)
+
+// graphQLResponse is the body of a GraphQL response
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code    string `json:"code"`
+			Status  int    `json:"status"`
+			Details []struct {
+				Field string `json:"field"`
+				Rule  string `json:"rule"`
+			} `json:"details"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// graphQLQuery posts body to handler, decoding the response when it is a 200
+func graphQLQuery(t *testing.T, handler *GraphQLHandler, body string) (int, graphQLResponse) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.Query(rr, req)
+	var resp graphQLResponse
+	if rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return rr.Code, resp
+}
+
+func TestGraphQLHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	handler := NewGraphQLHandler(userService, metricsCollector)
+	query := func(body string) (int, graphQLResponse) {
+		return graphQLQuery(t, handler, body)
+	}
+
+	t.Run("selects only the requested field", func(t *testing.T) {
+		code, resp := query(`{"query":"{ user(id: 1) { name } }"}`)
+		if code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+		if len(resp.Errors) != 0 {
+			t.Fatalf("Expected no errors, got %+v", resp.Errors)
+		}
+		if got, want := string(resp.Data["user"]), `{"name":"John Doe"}`; got != want {
+			t.Errorf("Expected user %s, got %s", want, got)
+		}
+	})
+
+	t.Run("pages users with a cursor", func(t *testing.T) {
+		const page = `query Page($first: Int, $after: String) { users(first: $first, after: $after) { nodes { id } pageInfo { endCursor hasNextPage } totalCount } }`
+		type connection struct {
+			Nodes []struct {
+				ID int `json:"id"`
+			} `json:"nodes"`
+			PageInfo struct {
+				EndCursor   *string `json:"endCursor"`
+				HasNextPage bool    `json:"hasNextPage"`
+			} `json:"pageInfo"`
+			TotalCount int `json:"totalCount"`
+		}
+
+		var ids []int
+		variables := map[string]interface{}{"first": 3}
+		for pages := 0; pages < 3; pages++ {
+			body, _ := json.Marshal(map[string]interface{}{"query": page, "variables": variables})
+			_, resp := query(string(body))
+			if len(resp.Errors) != 0 {
+				t.Fatalf("Expected no errors, got %+v", resp.Errors)
+			}
+			var users connection
+			if err := json.Unmarshal(resp.Data["users"], &users); err != nil {
+				t.Fatalf("Failed to decode users: %v", err)
+			}
+			if users.TotalCount != 4 {
+				t.Errorf("Expected totalCount 4, got %d", users.TotalCount)
+			}
+			for _, node := range users.Nodes {
+				ids = append(ids, node.ID)
+			}
+			if !users.PageInfo.HasNextPage {
+				break
+			}
+			variables["after"] = *users.PageInfo.EndCursor
+		}
+		if got := fmt.Sprint(ids); got != "[1 2 3 4]" {
+			t.Errorf("Expected users [1 2 3 4], got %s", got)
+		}
+	})
+
+	t.Run("creates a user", func(t *testing.T) {
+		_, resp := query(`{"query":"mutation { createUser(input: {name: \"Grace Hopper\", email: \" grace@example.com \", role: \"admin\"}) { id email role status } }"}`)
+		if len(resp.Errors) != 0 {
+			t.Fatalf("Expected no errors, got %+v", resp.Errors)
+		}
+		if got, want := string(resp.Data["createUser"]), `{"email":"grace@example.com","id":5,"role":"admin","status":"active"}`; got != want {
+			t.Errorf("Expected created user %s, got %s", want, got)
+		}
+	})
+
+	errorTests := []struct {
+		name       string
+		body       string
+		wantErr    string
+		wantCode   string
+		wantStatus int
+	}{
+		{"missing user", `{"query":"{ user(id: 999) { name } }"}`, "user not found", "NOT_FOUND", http.StatusNotFound},
+		{"out of range id", `{"query":"{ user(id: 0) { name } }"}`, "out of range", "BAD_REQUEST", http.StatusBadRequest},
+		{"first too large", `{"query":"{ users(first: 101) { totalCount } }"}`, "first must be between 1 and 100", "BAD_REQUEST", http.StatusBadRequest},
+		{"first zero", `{"query":"{ users(first: 0) { totalCount } }"}`, "first must be between 1 and 100", "BAD_REQUEST", http.StatusBadRequest},
+		{"invalid cursor", `{"query":"{ users(after: \"!\") { totalCount } }"}`, "after is not a valid cursor", "BAD_REQUEST", http.StatusBadRequest},
+		{"duplicate email", `{"query":"mutation { createUser(input: {name: \"John\", email: \"john@example.com\"}) { id } }"}`, "email already exists", "CONFLICT", http.StatusConflict},
+		{"unknown field", `{"query":"{ user(id: 1) { password } }"}`, "Cannot query field", "BAD_REQUEST", http.StatusBadRequest},
+		{"syntax error", `{"query":"{ user(id: 1) { name }"}`, "Syntax Error", "BAD_REQUEST", http.StatusBadRequest},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := query(tt.body)
+			if code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+			if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, tt.wantErr) {
+				t.Fatalf("Expected one error containing %q, got %+v", tt.wantErr, resp.Errors)
+			}
+			if ext := resp.Errors[0].Extensions; ext.Code != tt.wantCode || ext.Status != tt.wantStatus {
+				t.Errorf("Expected code %s and status %d, got %s and %d", tt.wantCode, tt.wantStatus, ext.Code, ext.Status)
+			}
+		})
+	}
+
+	t.Run("reports every failed validation rule", func(t *testing.T) {
+		_, resp := query(`{"query":"mutation { createUser(input: {name: \"\", email: \"invalid\"}) { id } }"}`)
+		if len(resp.Errors) != 1 {
+			t.Fatalf("Expected one error, got %+v", resp.Errors)
+		}
+		ext := resp.Errors[0].Extensions
+		if ext.Code != "VALIDATION" || ext.Status != http.StatusUnprocessableEntity {
+			t.Errorf("Expected code VALIDATION and status 422, got %s and %d", ext.Code, ext.Status)
+		}
+		var fields []string
+		for _, detail := range ext.Details {
+			fields = append(fields, detail.Field)
+		}
+		if got := strings.Join(fields, ","); got != "name,email" {
+			t.Errorf("Expected failed fields name,email, got %s", got)
+		}
+	})
+
+	t.Run("records resolver durations", func(t *testing.T) {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Failed to gather metrics: %v", err)
+		}
+		counts := map[string]uint64{}
+		for _, family := range families {
+			if family.GetName() != "graphql_resolver_duration_seconds" {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				counts[m.GetLabel()[0].GetValue()] = m.GetHistogram().GetSampleCount()
+			}
+		}
+		for _, field := range []string{"Query.user", "Query.users", "Mutation.createUser"} {
+			if counts[field] == 0 {
+				t.Errorf("Expected durations recorded for %s, got %v", field, counts)
+			}
+		}
+	})
+
+	if code, _ := query(`{"query":""}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an empty query, got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := query(`not json`); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid body, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	handler := NewGraphQLHandler(userService, metricsCollector)
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		wantErr   string
+	}{
+		{"largest page of every field", `{ users(first: 100) { nodes { id name email role status createdAt updatedAt } pageInfo { endCursor hasNextPage } totalCount } }`, nil, ""},
+		{"deep introspection", `{ __schema { types { fields { type { ofType { ofType { ofType { ofType { ofType { ofType { name } } } } } } } } } } }`, nil, "query depth 11 exceeds the limit of 10"},
+		{"aliased pages", `{ a: users(first: 100) { nodes { id name email role status } } b: users(first: 100) { nodes { id name email role status } } }`, nil, "query complexity 1004 exceeds the limit of 1000"},
+		{"pages through fragments", `{ ...pages } fragment pages on Query { a: users(first: 100) { ...fields } b: users(first: 100) { ...fields } } fragment fields on UserConnection { nodes { id name email role status } }`, nil, "query complexity 1004 exceeds the limit of 1000"},
+		{"page size from a variable", `query Page($first: Int) { a: users(first: $first) { nodes { id name email role status } } b: users(first: $first) { nodes { id name email role status } } }`, map[string]interface{}{"first": 100}, "query complexity 1004 exceeds the limit of 1000"},
+		{"fragment cycle", `{ ...a } fragment a on Query { ...b } fragment b on Query { ...a }`, nil, `fragment "a" spreads itself`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"query": tt.query, "variables": tt.variables})
+			_, resp := graphQLQuery(t, handler, string(body))
+			if tt.wantErr == "" {
+				if len(resp.Errors) != 0 {
+					t.Errorf("Expected no errors, got %+v", resp.Errors)
+				}
+				return
+			}
+			if len(resp.Errors) == 0 || !strings.Contains(resp.Errors[0].Message, tt.wantErr) {
+				t.Fatalf("Expected an error containing %q, got %+v", tt.wantErr, resp.Errors)
+			}
+			if code := resp.Errors[0].Extensions.Code; code != "BAD_REQUEST" {
+				t.Errorf("Expected code BAD_REQUEST, got %s", code)
+			}
+			if resp.Data != nil {
+				t.Errorf("Expected no data for a rejected query, got %v", resp.Data)
+			}
+		})
+	}
+}
+
+func TestGraphQLResolversFromDatabase(t *testing.T) {
+	dbMock := &mocks.MockDBTX{}
+	found := &mocks.MockRow{}
+	found.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = 1
+		*arg[1].(*string) = "John Doe"
+		*arg[2].(*string) = "john@example.com"
+	})
+	missing := &mocks.MockRow{}
+	missing.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+	broken := &mocks.MockRow{}
+	broken.On("Scan", mock.Anything).Return(errors.New("connection reset"))
+	dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(found)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 100).Return(missing)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 999).Return(broken)
+
+	// Rows come back in no particular order, as the query has no ORDER BY
+	rows := &mocks.MockRows{}
+	rows.On("Close").Return()
+	rows.On("Next").Return(true).Times(3)
+	rows.On("Next").Return(false).Once()
+	rows.On("Err").Return(nil)
+	ids := []int{3, 1, 2}
+	rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = ids[0]
+		*arg[1].(*string) = fmt.Sprintf("User %d", ids[0])
+		ids = ids[1:]
+	})
+	dbMock.On("Query", mock.Anything, queries.Default.ListUsers).Return(rows, nil)
+
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+	handler := NewGraphQLHandler(userService, metricsCollector)
+
+	_, resp := graphQLQuery(t, handler, `{"query":"{ user(id: 1) { id name email } }"}`)
+	if got, want := string(resp.Data["user"]), `{"email":"john@example.com","id":1,"name":"John Doe"}`; got != want {
+		t.Errorf("Expected user %s, got %s", want, got)
+	}
+
+	_, resp = graphQLQuery(t, handler, `{"query":"{ user(id: 100) { id } }"}`)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions.Code != "NOT_FOUND" {
+		t.Errorf("Expected a NOT_FOUND error, got %+v", resp.Errors)
+	}
+
+	// Database failures are not leaked to clients
+	_, resp = graphQLQuery(t, handler, `{"query":"{ user(id: 999) { id } }"}`)
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != "failed to get user" || resp.Errors[0].Extensions.Code != "INTERNAL" {
+		t.Errorf("Expected an INTERNAL failed to get user error, got %+v", resp.Errors)
+	}
+
+	_, resp = graphQLQuery(t, handler, `{"query":"{ users(first: 2) { nodes { id } pageInfo { hasNextPage } } }"}`)
+	if got, want := string(resp.Data["users"]), `{"nodes":[{"id":1},{"id":2}],"pageInfo":{"hasNextPage":true}}`; got != want {
+		t.Errorf("Expected users %s, got %s", want, got)
+	}
+
+	dbMock.AssertExpectations(t)
+}
+
+// graphQLSchema is the schema served at /graphql. Changing it changes the API
+// clients depend on, so update it deliberately.
+const graphQLSchema = `input CreateUserInput {
+  email: String!
+  name: String!
+  role: String
+}
+
+type Mutation {
+  createUser(input: CreateUserInput!): User!
+}
+
+type PageInfo {
+  endCursor: String
+  hasNextPage: Boolean!
+}
+
+type Query {
+  user(id: Int!): User
+  users(after: String, first: Int = 20): UserConnection!
+}
+
+type User {
+  createdAt: DateTime
+  email: String!
+  id: Int!
+  name: String!
+  role: String!
+  status: String!
+  updatedAt: DateTime
+}
+
+type UserConnection {
+  nodes: [User!]!
+  pageInfo: PageInfo!
+  totalCount: Int!
+}
+`
+
+func TestGraphQLSchema(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	handler := NewGraphQLHandler(services.NewUserService(repository.NewInMemoryRepository(), metricsCollector), metricsCollector)
+
+	if got := printSchema(handler.schema); got != graphQLSchema {
+		t.Errorf("Schema changed. Got:\n%s\nWant:\n%s", got, graphQLSchema)
+	}
+}
+
+// printSchema renders the object and input types of schema in SDL, sorted by
+// name, leaving out introspection and built-in scalar types
+func printSchema(schema graphql.Schema) string {
+	var names []string
+	for name := range schema.TypeMap() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		switch typ := schema.TypeMap()[name].(type) {
+		case *graphql.Object:
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+			fmt.Fprintf(&b, "type %s {\n", name)
+			fields := typ.Fields()
+			for _, fieldName := range sortedKeys(fields) {
+				field := fields[fieldName]
+				var args []string
+				for _, arg := range field.Args {
+					s := arg.PrivateName + ": " + arg.Type.String()
+					if arg.DefaultValue != nil {
+						s += fmt.Sprintf(" = %v", arg.DefaultValue)
+					}
+					args = append(args, s)
+				}
+				sort.Strings(args)
+				if len(args) > 0 {
+					fmt.Fprintf(&b, "  %s(%s): %s\n", fieldName, strings.Join(args, ", "), field.Type)
+				} else {
+					fmt.Fprintf(&b, "  %s: %s\n", fieldName, field.Type)
+				}
+			}
+			b.WriteString("}\n\n")
+		case *graphql.InputObject:
+			fmt.Fprintf(&b, "input %s {\n", name)
+			fields := typ.Fields()
+			for _, fieldName := range sortedKeys(fields) {
+				fmt.Fprintf(&b, "  %s: %s\n", fieldName, fields[fieldName].Type)
+			}
+			b.WriteString("}\n\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Limits on a GraphQL operation, checked before it runs so that a client cannot
+// make the service resolve an unbounded number of fields
+const (
+	maxGraphQLDepth      = 10
+	maxGraphQLComplexity = 1000
+)
+
+// checkLimits rejects a query any of whose operations nests fields deeper than
+// maxGraphQLDepth or may resolve more than maxGraphQLComplexity of them. It also
+// rejects fragments that spread themselves, which overflow the stack of graphql-go's
+// validation. A query that does not parse passes, leaving graphql-go to report the
+// syntax error.
+func checkLimits(query string, variables map[string]interface{}) *graphQLError {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return nil
+	}
+
+	c := queryCost{fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			c.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		depth, complexity := c.measure(op.SelectionSet, 1, map[string]bool{})
+		if c.cycle != "" {
+			return &graphQLError{code: codeBadRequest, message: fmt.Sprintf("fragment %q spreads itself", c.cycle)}
+		}
+		if depth > maxGraphQLDepth {
+			return &graphQLError{code: codeBadRequest, message: fmt.Sprintf("query depth %d exceeds the limit of %d", depth, maxGraphQLDepth)}
+		}
+		if complexity > maxGraphQLComplexity {
+			return &graphQLError{code: codeBadRequest, message: fmt.Sprintf("query complexity %d exceeds the limit of %d", complexity, maxGraphQLComplexity)}
+		}
+	}
+	return nil
+}
+
+// queryCost measures the operations of a parsed query
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// cycle names a fragment found spreading itself
+	cycle string
+}
+
+// measure returns how deeply the fields of set nest and how many may be resolved.
+// Each field costs one, and the selections of nodes cost once for each of the up
+// to page users on the page. Fragments in visiting are being measured already, so
+// spreading one again is recorded as a cycle.
+func (c *queryCost) measure(set *ast.SelectionSet, page int, visiting map[string]bool) (depth, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, selection := range set.Selections {
+		var d, n int
+		switch s := selection.(type) {
+		case *ast.Field:
+			childPage := 1
+			if s.Name.Value == "users" {
+				childPage = c.first(s)
+			}
+			d, n = c.measure(s.SelectionSet, childPage, visiting)
+			if s.Name.Value == "nodes" {
+				n *= page
+			}
+			d, n = d+1, n+1
+		case *ast.InlineFragment:
+			d, n = c.measure(s.SelectionSet, page, visiting)
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			fragment, ok := c.fragments[name]
+			if !ok {
+				continue
+			}
+			if visiting[name] {
+				c.cycle = name
+				continue
+			}
+			visiting[name] = true
+			d, n = c.measure(fragment.SelectionSet, page, visiting)
+			delete(visiting, name)
+		}
+		depth = max(depth, d)
+		complexity += n
+	}
+	return depth, complexity
+}
+
+// first returns the page size a users field asks for, capped at the largest the
+// resolver accepts so that a larger one is reported by the resolver itself
+func (c *queryCost) first(field *ast.Field) int {
+	first := defaultGraphQLFirst
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			first, _ = strconv.Atoi(v.Value)
+		case *ast.Variable:
+			// JSON numbers decode as float64
+			if n, ok := c.variables[v.Name.Value].(float64); ok {
+				first = int(n)
+			}
+		}
+	}
+	return max(0, min(first, maxGraphQLFirst))
+}
@@ -1,37 +1,51 @@
 package handlers
 
 import (
-	"encoding/json"
-	"log/slog"
+	"crypto/subtle"
 	"net/http"
 	"time"
 
-	"user-service/internal/middleware"
+	"user-service/internal/health"
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/reqctx"
 	"user-service/internal/services"
 )
 
 // HealthHandler handles health check requests
 type HealthHandler struct {
 	userService *services.UserService
+	// checks are run by /readyz
+	checks *health.CheckRegistry
+	// heartbeats are watched by /livez
+	heartbeats *health.Heartbeats
+	// detailToken unlocks per-check /readyz and /livez detail; detail is never shown when it is empty
+	detailToken string
 }
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler(userService *services.UserService) *HealthHandler {
+// NewHealthHandler creates a new health handler whose readiness runs checks and
+// whose liveness watches heartbeats. Requests carrying detailToken in
+// X-Health-Token get the per-check detail.
+func NewHealthHandler(userService *services.UserService, checks *health.CheckRegistry, heartbeats *health.Heartbeats, detailToken string) *HealthHandler {
 	return &HealthHandler{
 		userService: userService,
+		checks:      checks,
+		heartbeats:  heartbeats,
+		detailToken: detailToken,
 	}
 }
 
 // Health handles GET /health requests
 func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
-	requestID, _ := r.Context().Value(middleware.RequestIDKey).(string)
+	requestID := reqctx.RequestIDFromContext(r.Context())
 
-	w.Header().Set("Content-Type", "application/json")
-
-	usersCount, err := h.userService.GetUsersCount()
+	usersCount, err := h.userService.GetUsersCount(r.Context())
 	if err != nil {
-		slog.Error("Failed to get users count for health check", "error", err, "request_id", requestID)
-		http.Error(w, "Failed to get users count", http.StatusInternalServerError)
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get users count for health check", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "Failed to get users count", http.StatusInternalServerError)
 		return
 	}
 
@@ -41,8 +55,68 @@ func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 		"service":     "user-service",
 		"users_count": usersCount,
 	}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		slog.Error("Failed to encode health response", "error", err, "request_id", requestID)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := writeJSON(w, r, http.StatusOK, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode health response", "error", err, "request_id", requestID)
+	}
+}
+
+// Ready handles GET /readyz requests. It runs every registered check concurrently
+// and answers 200 when the required ones pass, reporting "degraded" when an
+// optional one failed, and 503 "down" otherwise. The body is a terse status
+// unless the request carries the detail token, since check errors and latencies
+// reveal internals.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	report := h.checks.Run(r.Context())
+	for name, result := range report.Checks {
+		if result.Status != health.StatusOK {
+			logging.FromContext(r.Context()).Warn("Readiness check failed", "check", name, "error", result.Error, "request_id", requestID)
+		}
+	}
+
+	code := http.StatusOK
+	if report.Status == health.StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	response := map[string]interface{}{"status": report.Status}
+	if h.showDetail(r) {
+		response["checks"] = report.Checks
+	}
+	if err := writeJSON(w, r, code, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode readiness response", "error", err, "request_id", requestID)
+	}
+}
+
+// Live handles GET /livez requests. It answers 200 while every background worker
+// keeps beating and 503 "down" once one is stale, so that the orchestrator
+// restarts a service whose workers are stuck. Only requests carrying the detail
+// token see which workers are stale.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	stale := h.heartbeats.Stale()
+	for _, name := range stale {
+		logging.FromContext(r.Context()).Warn("Background worker stopped beating", "worker", name, "request_id", requestID)
+	}
+
+	code, status := http.StatusOK, health.StatusOK
+	if len(stale) > 0 {
+		code, status = http.StatusServiceUnavailable, health.StatusDown
+	}
+	response := map[string]interface{}{"status": status}
+	if h.showDetail(r) && len(stale) > 0 {
+		response["stale"] = stale
+	}
+	if err := writeJSON(w, r, code, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode liveness response", "error", err, "request_id", requestID)
+	}
+}
+
+// showDetail reports whether the request carries the configured detail token
+func (h *HealthHandler) showDetail(r *http.Request) bool {
+	if h.detailToken == "" {
+		return false
 	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Health-Token")), []byte(h.detailToken)) == 1
 }
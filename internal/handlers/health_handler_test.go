@@ -2,18 +2,32 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/mock"
 	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/health"
 	"user-service/internal/metrics"
+	"user-service/internal/repository"
 	"user-service/internal/services"
 )
 
+// readinessChecks registers the service's checks and extra in a new registry
+func readinessChecks(userService *services.UserService, extra ...health.Check) *health.CheckRegistry {
+	checks := health.NewCheckRegistry()
+	checks.Register(userService.ReadinessChecks()...)
+	checks.Register(extra...)
+	return checks
+}
+
 func TestHealthHandler(t *testing.T) {
 	// Create a mock for DBTX
 	dbMock := &mocks.MockDBTX{}
@@ -24,12 +38,12 @@ func TestHealthHandler(t *testing.T) {
 		arg := args.Get(0).([]interface{})
 		*arg[0].(*int) = 5 // Mock a count of 5 users
 	})
-	dbMock.On("QueryRow", context.Background(), "SELECT COUNT(*) FROM users", mock.Anything).Return(mockRow)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers, mock.Anything).Return(mockRow)
 
 	reg := prometheus.NewRegistry()
 	metricsCollector := metrics.New(reg, reg)
-	userService := services.NewUserService(dbMock, metricsCollector)
-	healthHandler := NewHealthHandler(userService)
+	userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+	healthHandler := NewHealthHandler(userService, readinessChecks(userService), nil, "")
 
 	req, err := http.NewRequest("GET", "/health", nil)
 	if err != nil {
@@ -57,12 +71,12 @@ func TestHealthHandlerError(t *testing.T) {
 	// Expect GetUsersCount to fail with an error
 	mockRow := &mocks.MockRow{}
 	mockRow.On("Scan", mock.Anything).Return(errors.New("database error"))
-	dbMock.On("QueryRow", context.Background(), "SELECT COUNT(*) FROM users", mock.Anything).Return(mockRow)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers, mock.Anything).Return(mockRow)
 
 	reg := prometheus.NewRegistry()
 	metricsCollector := metrics.New(reg, reg)
-	userService := services.NewUserService(dbMock, metricsCollector)
-	healthHandler := NewHealthHandler(userService)
+	userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+	healthHandler := NewHealthHandler(userService, readinessChecks(userService), nil, "")
 
 	req, err := http.NewRequest("GET", "/health", nil)
 	if err != nil {
@@ -82,3 +96,138 @@ func TestHealthHandlerError(t *testing.T) {
 	// Assert that the mock expectations were met
 	dbMock.AssertExpectations(t)
 }
+
+func TestReadyHandler(t *testing.T) {
+	// readyService returns a service whose storage check fails with checkErr, if set
+	readyService := func(checkErr error) *services.UserService {
+		dbMock := &mocks.MockDBTX{}
+		mockRow := &mocks.MockRow{}
+		mockRow.On("Scan", mock.Anything).Return(checkErr)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(mockRow)
+
+		reg := prometheus.NewRegistry()
+		return services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+	}
+
+	// cacheCheck is an optional check failing with err, if set
+	cacheCheck := func(err error) health.Check {
+		return health.Check{Name: "cache", Optional: true, Run: func(context.Context) error { return err }}
+	}
+
+	type readyResponse struct {
+		Status string                   `json:"status"`
+		Checks map[string]health.Result `json:"checks"`
+	}
+
+	tests := []struct {
+		name       string
+		checkErr   error
+		cacheErr   error
+		token      string
+		wantStatus int
+		wantBody   string
+		wantDetail bool
+	}{
+		{"terse by default", nil, nil, "", http.StatusOK, "ok", false},
+		{"terse when down", errors.New("connection refused"), nil, "", http.StatusServiceUnavailable, "down", false},
+		{"detail with token", errors.New("connection refused"), nil, "ops-token", http.StatusServiceUnavailable, "down", true},
+		{"terse with wrong token", errors.New("connection refused"), nil, "guess", http.StatusServiceUnavailable, "down", false},
+		{"degraded when an optional check fails", nil, errors.New("cache unreachable"), "", http.StatusOK, "degraded", false},
+		{"down when required and optional checks fail", errors.New("connection refused"), errors.New("cache unreachable"), "", http.StatusServiceUnavailable, "down", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userService := readyService(tt.checkErr)
+			healthHandler := NewHealthHandler(userService, readinessChecks(userService, cacheCheck(tt.cacheErr)), nil, "ops-token")
+
+			req := httptest.NewRequest("GET", "/readyz", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Health-Token", tt.token)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(healthHandler.Ready).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if !tt.wantDetail {
+				if body := strings.TrimSpace(rr.Body.String()); body != `{"status":"`+tt.wantBody+`"}` {
+					t.Errorf("expected terse body, got %s", body)
+				}
+				return
+			}
+
+			var response readyResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Status != tt.wantBody {
+				t.Errorf("status = %q, want %q", response.Status, tt.wantBody)
+			}
+			storage, ok := response.Checks["storage"]
+			if !ok {
+				t.Fatalf("expected a storage check in %+v", response.Checks)
+			}
+			if storage.Status != health.StatusFailed || storage.Error != "connection refused" {
+				t.Errorf("storage check = %+v, want failed with the error", storage)
+			}
+			if cache := response.Checks["cache"]; cache.Status != health.StatusOK {
+				t.Errorf("cache check = %+v, want ok", cache)
+			}
+		})
+	}
+
+	t.Run("detail disabled without a configured token", func(t *testing.T) {
+		userService := readyService(nil)
+		healthHandler := NewHealthHandler(userService, readinessChecks(userService), nil, "")
+
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		req.Header.Set("X-Health-Token", "")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(healthHandler.Ready).ServeHTTP(rr, req)
+
+		if body := strings.TrimSpace(rr.Body.String()); body != `{"status":"ok"}` {
+			t.Errorf("expected terse body, got %s", body)
+		}
+	})
+}
+
+func TestLiveHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"alive", time.Hour, "", http.StatusOK, `{"status":"ok"}`},
+		{"alive with token", time.Hour, "ops-token", http.StatusOK, `{"status":"ok"}`},
+		{"terse when stale", time.Millisecond, "", http.StatusServiceUnavailable, `{"status":"down"}`},
+		{"stale workers with token", time.Millisecond, "ops-token", http.StatusServiceUnavailable, `{"stale":["uptime"],"status":"down"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heartbeats := health.NewHeartbeats(tt.timeout)
+			heartbeats.Register("uptime")
+			// The worker misses its beats for longer than the short timeout
+			time.Sleep(10 * time.Millisecond)
+			healthHandler := NewHealthHandler(nil, nil, heartbeats, "ops-token")
+
+			req := httptest.NewRequest("GET", "/livez", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Health-Token", tt.token)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(healthHandler.Live).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if body := strings.TrimSpace(rr.Body.String()); body != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+	"user-service/internal/services"
+)
+
+// Limits of a user import
+const (
+	// importBatchSize is how many users are saved in each transaction
+	importBatchSize = 500
+	// maxImportErrors caps the row errors listed in an import summary
+	maxImportErrors = 100
+	// maxImportLineBytes caps a single NDJSON line
+	maxImportLineBytes = 64 << 10
+)
+
+// ImportHandler bulk-creates users from uploaded files
+type ImportHandler struct {
+	userService *services.UserService
+	maxBytes    int64
+	batchSize   int
+}
+
+// NewImportHandler creates a handler accepting uploads of at most maxBytes
+func NewImportHandler(userService *services.UserService, maxBytes int64) *ImportHandler {
+	return &ImportHandler{userService: userService, maxBytes: maxBytes, batchSize: importBatchSize}
+}
+
+// importRowError reports a row that was not imported, by the line of the file it starts on
+type importRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// importSummary is the outcome of an import. Invalid counts every rejected row,
+// of which at most maxImportErrors are listed in Errors. Error is set when the
+// upload could not be read to the end; the rows before it are still imported.
+type importSummary struct {
+	Imported          int              `json:"imported"`
+	SkippedDuplicates int              `json:"skipped_duplicates"`
+	Invalid           int              `json:"invalid"`
+	Errors            []importRowError `json:"errors"`
+	Error             string           `json:"error,omitempty"`
+}
+
+// reject records a row that was not imported
+func (s *importSummary) reject(row int, message string) {
+	s.Invalid++
+	if len(s.Errors) < maxImportErrors {
+		s.Errors = append(s.Errors, importRowError{Row: row, Message: message})
+	}
+}
+
+// rowError is a malformed row, after which reading can go on
+type rowError struct {
+	row int
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// rowReader reads users from an upload one row at a time
+type rowReader interface {
+	// next returns the next user and the line it starts on, io.EOF at the end of
+	// the upload, or a *rowError for a malformed row
+	next() (int, userRequest, error)
+}
+
+// Import handles POST /admin/users/import requests. The "file" field of a
+// multipart upload, or the raw body, holds either CSV with a header row naming the name, email and
+// optionally role columns, or NDJSON with one user object per line. Rows are
+// streamed through validation and saved in batches, each in its own
+// transaction, so memory stays bounded whatever the size of the file. Users
+// whose email is taken are skipped.
+func (h *ImportHandler) Import(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	file, format, ok := h.uploadedFile(w, r)
+	if !ok {
+		return
+	}
+	if format == "" {
+		httputil.Error(r.Context(), w, "unsupported file type; upload CSV or NDJSON", http.StatusBadRequest)
+		return
+	}
+
+	var rows rowReader
+	var err error
+	if format == "csv" {
+		if rows, err = newCSVRows(file); err != nil {
+			status, message := uploadFailure(err)
+			httputil.Error(r.Context(), w, message, status)
+			return
+		}
+	} else {
+		rows = newNDJSONRows(file)
+	}
+
+	summary := importSummary{Errors: []importRowError{}}
+	batch := make([]models.User, 0, h.batchSize)
+	flush := func() error {
+		imported, skipped, err := h.userService.ImportUsers(r.Context(), batch)
+		summary.Imported += imported
+		summary.SkippedDuplicates += skipped
+		batch = batch[:0]
+		return err
+	}
+	fail := func(status int, message string) {
+		summary.Error = message
+		if err := writeJSON(w, r, status, summary); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to encode import summary", "error", err, "request_id", requestID)
+		}
+	}
+
+	for {
+		line, body, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var malformed *rowError
+		if errors.As(err, &malformed) {
+			summary.reject(malformed.row, malformed.Error())
+			continue
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Failed to read import", "error", err, "imported", summary.Imported, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			if err := flush(); err != nil {
+				logging.FromContext(r.Context()).Error("Failed to import users", "error", err, "request_id", requestID)
+			}
+			fail(uploadFailure(err))
+			return
+		}
+
+		user := models.User{Name: body.Name, Email: body.Email, Role: body.Role, AvatarURL: body.AvatarURL, DisplayName: body.DisplayName}
+		user.Sanitize()
+		if err := user.Validate(); err != nil {
+			summary.reject(line, err.Error())
+			continue
+		}
+		batch = append(batch, user)
+		if len(batch) == h.batchSize {
+			if err := flush(); err != nil {
+				logging.FromContext(r.Context()).Error("Failed to import users", "error", err, "imported", summary.Imported, "request_id", requestID)
+				fail(http.StatusInternalServerError, "failed to import users")
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to import users", "error", err, "imported", summary.Imported, "request_id", requestID)
+		fail(http.StatusInternalServerError, "failed to import users")
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, summary); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode import summary", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully imported users", "imported", summary.Imported, "skipped_duplicates", summary.SkippedDuplicates,
+		"invalid", summary.Invalid, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// uploadedFile caps the request body at the handler's limit and returns the
+// uploaded file with its format, as importFormat judges it. The file is either
+// the "file" field of a multipart upload or the whole body when its content type
+// is CSV or NDJSON. It writes the error response and returns false when there is
+// no file.
+func (h *ImportHandler) uploadedFile(w http.ResponseWriter, r *http.Request) (io.Reader, string, bool) {
+	if r.ContentLength > h.maxBytes {
+		httputil.Error(r.Context(), w, "request body too large", http.StatusRequestEntityTooLarge)
+		return nil, "", false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBytes)
+	if format := importFormat("", r.Header.Get("Content-Type")); format != "" {
+		return r.Body, format, true
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		httputil.Error(r.Context(), w, "expected a multipart/form-data upload", http.StatusBadRequest)
+		return nil, "", false
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			httputil.Error(r.Context(), w, "missing file field", http.StatusBadRequest)
+			return nil, "", false
+		}
+		if err != nil {
+			status, message := uploadFailure(err)
+			httputil.Error(r.Context(), w, message, status)
+			return nil, "", false
+		}
+		if part.FormName() == "file" {
+			return part, importFormat(part.FileName(), part.Header.Get("Content-Type")), true
+		}
+	}
+}
+
+// uploadFailure maps an error reading an upload to a status and message
+func uploadFailure(err error) (int, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, "request body too large"
+	}
+	return http.StatusBadRequest, "invalid upload: " + err.Error()
+}
+
+// importFormat returns "csv" or "ndjson" for an uploaded file, judged by its
+// extension and then its content type, or "" for any other file
+func importFormat(filename, contentType string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return "csv"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/jsonl":
+		return "ndjson"
+	}
+	return ""
+}
+
+// csvRows reads users from CSV, finding their fields by the header row
+type csvRows struct {
+	r *csv.Reader
+	// Column of each field; role is -1 when the file has none
+	name, email, role int
+}
+
+// newCSVRows reads the header row of r
+func newCSVRows(r io.Reader) (*csvRows, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+	}
+	rows := &csvRows{
+		r:     cr,
+		name:  slices.Index(columns, "name"),
+		email: slices.Index(columns, "email"),
+		role:  slices.Index(columns, "role"),
+	}
+	if rows.name < 0 || rows.email < 0 {
+		return nil, errors.New("CSV header must name the name and email columns")
+	}
+	return rows, nil
+}
+
+func (c *csvRows) next() (int, userRequest, error) {
+	record, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.StartLine, userRequest{}, &rowError{row: parseErr.StartLine, err: parseErr.Err}
+	}
+	if err != nil {
+		return 0, userRequest{}, err
+	}
+
+	line, _ := c.r.FieldPos(0)
+	body := userRequest{Name: record[c.name], Email: record[c.email]}
+	if c.role >= 0 {
+		body.Role = record[c.role]
+	}
+	return line, body, nil
+}
+
+// ndjsonRows reads users from newline-delimited JSON, skipping blank lines
+type ndjsonRows struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONRows(r io.Reader) *ndjsonRows {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineBytes)
+	return &ndjsonRows{scanner: scanner}
+}
+
+func (n *ndjsonRows) next() (int, userRequest, error) {
+	for n.scanner.Scan() {
+		n.line++
+		data := bytes.TrimSpace(n.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var body userRequest
+		if err := json.Unmarshal(data, &body); err != nil {
+			return n.line, userRequest{}, &rowError{row: n.line, err: errors.New("invalid JSON")}
+		}
+		return n.line, body, nil
+	}
+	if errors.Is(n.scanner.Err(), bufio.ErrTooLong) {
+		return 0, userRequest{}, fmt.Errorf("line %d is longer than %d bytes", n.line+1, maxImportLineBytes)
+	}
+	if err := n.scanner.Err(); err != nil {
+		return 0, userRequest{}, err
+	}
+	return 0, userRequest{}, io.EOF
+}
+
+// Modes of a CSV import
+const (
+	// importPartial saves the valid rows and reports the others
+	importPartial = "partial"
+	// importAtomic saves nothing unless every row is valid
+	importAtomic = "atomic"
+)
+
+// importFailure is a row of a CSV import that was not saved, by the line it starts on
+type importFailure struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// csvImportSummary is the outcome of a CSV import. Invalid counts every rejected
+// row, of which at most maxImportErrors are listed in Failed.
+type csvImportSummary struct {
+	Imported          int             `json:"imported"`
+	SkippedDuplicates int             `json:"skipped_duplicates"`
+	Invalid           int             `json:"invalid"`
+	Failed            []importFailure `json:"failed"`
+}
+
+// ImportCSV handles POST /users/import requests, uploading a CSV file as the
+// "file" field of a multipart form or as a text/csv body. Its header row names
+// the name, email and optionally role columns. The whole file is validated
+// before the valid rows are saved in a single transaction, skipping users whose
+// email is taken. With ?mode=partial, the default, invalid rows are reported and
+// the others saved; with ?mode=atomic any invalid row fails the import with a
+// 422 and nothing is saved.
+func (h *ImportHandler) ImportCSV(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importPartial
+	}
+	if mode != importPartial && mode != importAtomic {
+		httputil.Error(r.Context(), w, "mode parameter must be partial or atomic", http.StatusBadRequest)
+		return
+	}
+
+	file, format, ok := h.uploadedFile(w, r)
+	if !ok {
+		return
+	}
+	if format != "csv" {
+		httputil.Error(r.Context(), w, "unsupported file type; upload CSV", http.StatusBadRequest)
+		return
+	}
+	rows, err := newCSVRows(file)
+	if err != nil {
+		status, message := uploadFailure(err)
+		httputil.Error(r.Context(), w, message, status)
+		return
+	}
+
+	summary := csvImportSummary{Failed: []importFailure{}}
+	reject := func(row int, message string) {
+		summary.Invalid++
+		if len(summary.Failed) < maxImportErrors {
+			summary.Failed = append(summary.Failed, importFailure{Row: row, Error: message})
+		}
+	}
+	var users []models.User
+	for {
+		line, body, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var malformed *rowError
+		if errors.As(err, &malformed) {
+			reject(malformed.row, malformed.Error())
+			continue
+		}
+		if err != nil {
+			// Nothing is saved from a file that cannot be read to the end
+			logging.FromContext(r.Context()).Warn("Failed to read import", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			status, message := uploadFailure(err)
+			httputil.Error(r.Context(), w, message, status)
+			return
+		}
+
+		user := models.User{Name: body.Name, Email: body.Email, Role: body.Role, AvatarURL: body.AvatarURL, DisplayName: body.DisplayName}
+		user.Sanitize()
+		if err := user.Validate(); err != nil {
+			reject(line, err.Error())
+			continue
+		}
+		users = append(users, user)
+	}
+
+	if mode == importAtomic && summary.Invalid > 0 {
+		logging.FromContext(r.Context()).Warn("Rejected atomic import with invalid rows", "invalid", summary.Invalid, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		if err := writeJSON(w, r, http.StatusUnprocessableEntity, summary); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to encode import summary", "error", err, "request_id", requestID)
+		}
+		return
+	}
+
+	summary.Imported, summary.SkippedDuplicates, err = h.userService.ImportUsers(r.Context(), users)
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to import users", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to import users", http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, summary); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode import summary", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully imported users", "mode", mode, "imported", summary.Imported, "skipped_duplicates", summary.SkippedDuplicates,
+		"invalid", summary.Invalid, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+)
+
+// newImportRequest uploads content as the file field of a multipart form
+func newImportRequest(t *testing.T, filename, content string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("note", "nightly sync"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/users/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// newImportHandler returns a handler over a store already holding john@example.com
+func newImportHandler(maxBytes int64) (*ImportHandler, *services.UserService) {
+	reg := prometheus.NewRegistry()
+	repo := repository.NewInMemoryRepository(models.User{ID: 1, Name: "John Doe", Email: "john@example.com"})
+	userService := services.NewUserService(repo, metrics.New(reg, reg))
+	return NewImportHandler(userService, maxBytes), userService
+}
+
+// serveImport runs req through handler, decoding the summary it responds with
+func serveImport(t *testing.T, handler *ImportHandler, req *http.Request, status int) importSummary {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.Import(rr, req)
+	if rr.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, rr.Code, rr.Body.String())
+	}
+	var summary importSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to decode import summary %q: %v", rr.Body.String(), err)
+	}
+	return summary
+}
+
+func TestImportHandler(t *testing.T) {
+	t.Run("imports well-formed CSV", func(t *testing.T) {
+		handler, userService := newImportHandler(1 << 20)
+		handler.batchSize = 2
+		csv := "email,name,role\n" +
+			"ann@example.com,Ann,admin\n" +
+			"\"bob@example.com\",\"Bob, Jr.\",\n" +
+			"john@example.com,John Again,\n" +
+			"cat@example.com,Cat,guest\n" +
+			"ann@example.com,Ann Again,\n"
+
+		summary := serveImport(t, handler, newImportRequest(t, "users.csv", csv), http.StatusOK)
+		if summary.Imported != 3 || summary.SkippedDuplicates != 2 || summary.Invalid != 0 {
+			t.Errorf("Expected 3 imported and 2 duplicates skipped, got %+v", summary)
+		}
+		if len(summary.Errors) != 0 {
+			t.Errorf("Expected no row errors, got %+v", summary.Errors)
+		}
+
+		ann, err := userService.GetUserByEmail(context.Background(), "ann@example.com")
+		if err != nil {
+			t.Fatalf("Expected ann@example.com to be imported: %v", err)
+		}
+		if ann.Name != "Ann" || ann.Role != models.RoleAdmin {
+			t.Errorf("Expected Ann with the admin role, got %+v", ann)
+		}
+		if bob, err := userService.GetUserByEmail(context.Background(), "bob@example.com"); err != nil || bob.Name != "Bob, Jr." {
+			t.Errorf("Expected Bob, Jr. to be imported, got %+v, %v", bob, err)
+		}
+	})
+
+	t.Run("imports well-formed NDJSON", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+		ndjson := `{"name":"Ann","email":"ann@example.com"}` + "\n\n" +
+			`{"name":"Bob","email":"bob@example.com","role":"guest"}` + "\n"
+
+		summary := serveImport(t, handler, newImportRequest(t, "users.ndjson", ndjson), http.StatusOK)
+		if summary.Imported != 2 || summary.SkippedDuplicates != 0 || summary.Invalid != 0 {
+			t.Errorf("Expected 2 imported, got %+v", summary)
+		}
+	})
+
+	t.Run("reports bad rows by line and imports the rest", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+		csv := "name,email\n" +
+			"Ann,ann@example.com\n" +
+			"Bob,not-an-email\n" +
+			"Cat,cat@example.com,extra\n" +
+			",dan@example.com\n" +
+			"Eve,eve@example.com\n"
+
+		summary := serveImport(t, handler, newImportRequest(t, "users.csv", csv), http.StatusOK)
+		if summary.Imported != 2 || summary.Invalid != 3 {
+			t.Errorf("Expected 2 imported and 3 invalid, got %+v", summary)
+		}
+		var rows []int
+		for _, rowErr := range summary.Errors {
+			rows = append(rows, rowErr.Row)
+			if rowErr.Message == "" {
+				t.Errorf("Expected a message for row %d", rowErr.Row)
+			}
+		}
+		if fmt.Sprint(rows) != "[3 4 5]" {
+			t.Errorf("Expected errors on rows 3, 4 and 5, got %v", rows)
+		}
+
+		ndjson := `{"name":"Fay","email":"fay@example.com"}` + "\n" + `{"name":` + "\n"
+		summary = serveImport(t, handler, newImportRequest(t, "users.jsonl", ndjson), http.StatusOK)
+		if summary.Imported != 1 || summary.Invalid != 1 || summary.Errors[0].Row != 2 {
+			t.Errorf("Expected 1 imported and invalid JSON on row 2, got %+v", summary)
+		}
+	})
+
+	t.Run("caps the listed row errors", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+		var csv strings.Builder
+		csv.WriteString("name,email\n")
+		for i := range maxImportErrors + 50 {
+			fmt.Fprintf(&csv, "User %d,user%d\n", i, i)
+		}
+
+		summary := serveImport(t, handler, newImportRequest(t, "users.csv", csv.String()), http.StatusOK)
+		if summary.Invalid != maxImportErrors+50 {
+			t.Errorf("Expected %d invalid rows, got %d", maxImportErrors+50, summary.Invalid)
+		}
+		if len(summary.Errors) != maxImportErrors {
+			t.Errorf("Expected %d listed errors, got %d", maxImportErrors, len(summary.Errors))
+		}
+	})
+
+	t.Run("rejects an oversized upload up front", func(t *testing.T) {
+		handler, userService := newImportHandler(256)
+		req := newImportRequest(t, "users.csv", "name,email\n"+strings.Repeat("Ann,ann@example.com\n", 50))
+
+		rr := httptest.NewRecorder()
+		handler.Import(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+		}
+		if count, _ := userService.GetUsersCount(context.Background()); count != 1 {
+			t.Errorf("Expected nothing to be imported, got %d users", count)
+		}
+	})
+
+	t.Run("stops a streamed upload at the limit", func(t *testing.T) {
+		handler, _ := newImportHandler(512)
+		var csv strings.Builder
+		csv.WriteString("name,email\n")
+		for i := range 100 {
+			fmt.Fprintf(&csv, "User %d,user%d@example.com\n", i, i)
+		}
+		req := newImportRequest(t, "users.csv", csv.String())
+		// An upload of unknown length is only stopped once it is read past the limit
+		req.ContentLength = -1
+
+		summary := serveImport(t, handler, req, http.StatusRequestEntityTooLarge)
+		if summary.Error != "request body too large" {
+			t.Errorf("Expected the body too large error, got %q", summary.Error)
+		}
+		if summary.Imported == 0 || summary.Imported >= 100 {
+			t.Errorf("Expected the rows before the limit to be imported, got %d", summary.Imported)
+		}
+	})
+
+	t.Run("rejects bad uploads", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+		tests := []struct {
+			name string
+			req  *http.Request
+		}{
+			{"not multipart", httptest.NewRequest("POST", "/admin/users/import", strings.NewReader("name,email\n"))},
+			{"unsupported file type", newImportRequest(t, "users.xlsx", "name,email\n")},
+			{"CSV without an email column", newImportRequest(t, "users.csv", "name,mail\nAnn,ann@example.com\n")},
+			{"empty CSV", newImportRequest(t, "users.csv", "")},
+			{"NDJSON line too long", newImportRequest(t, "users.ndjson", `{"name":"`+strings.Repeat("x", maxImportLineBytes)+`"}`)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := httptest.NewRecorder()
+				handler.Import(rr, tt.req)
+				if rr.Code != http.StatusBadRequest {
+					t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+				}
+			})
+		}
+	})
+}
+
+// serveCSVImport runs req through ImportCSV, decoding the summary it responds with
+func serveCSVImport(t *testing.T, handler *ImportHandler, req *http.Request, status int) csvImportSummary {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ImportCSV(rr, req)
+	if rr.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, rr.Code, rr.Body.String())
+	}
+	var summary csvImportSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to decode import summary %q: %v", rr.Body.String(), err)
+	}
+	return summary
+}
+
+// newCSVImportRequest posts content to /users/import as a raw text/csv body
+func newCSVImportRequest(target, content string) *http.Request {
+	req := httptest.NewRequest("POST", target, strings.NewReader(content))
+	req.Header.Set("Content-Type", "text/csv")
+	return req
+}
+
+func TestImportCSV(t *testing.T) {
+	const withInvalidRow = "name,email\n" +
+		"Ann,ann@example.com\n" +
+		"Bob,not-an-email\n" +
+		"Cat,cat@example.com\n"
+
+	t.Run("imports a clean file", func(t *testing.T) {
+		handler, userService := newImportHandler(1 << 20)
+		csv := "name,email,role\n" +
+			"Ann,ann@example.com,admin\n" +
+			"\"Bob, Jr.\",bob@example.com,\n" +
+			"John Again,john@example.com,\n"
+
+		summary := serveCSVImport(t, handler, newCSVImportRequest("/users/import", csv), http.StatusOK)
+		if summary.Imported != 2 || summary.SkippedDuplicates != 1 || len(summary.Failed) != 0 {
+			t.Errorf("Expected 2 imported and 1 duplicate skipped, got %+v", summary)
+		}
+		if bob, err := userService.GetUserByEmail(context.Background(), "bob@example.com"); err != nil || bob.Name != "Bob, Jr." {
+			t.Errorf("Expected Bob, Jr. to be imported, got %+v, %v", bob, err)
+		}
+	})
+
+	t.Run("accepts a multipart upload", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+
+		summary := serveCSVImport(t, handler, newImportRequest(t, "users.csv", "name,email\nAnn,ann@example.com\n"), http.StatusOK)
+		if summary.Imported != 1 {
+			t.Errorf("Expected 1 imported, got %+v", summary)
+		}
+	})
+
+	t.Run("partial mode saves the valid rows", func(t *testing.T) {
+		handler, userService := newImportHandler(1 << 20)
+
+		summary := serveCSVImport(t, handler, newCSVImportRequest("/users/import", withInvalidRow), http.StatusOK)
+		if summary.Imported != 2 || summary.Invalid != 1 {
+			t.Errorf("Expected 2 imported and 1 invalid, got %+v", summary)
+		}
+		if len(summary.Failed) != 1 || summary.Failed[0].Row != 3 || !strings.Contains(summary.Failed[0].Error, "email") {
+			t.Errorf("Expected line 3 to fail on its email, got %+v", summary.Failed)
+		}
+		if count, _ := userService.GetUsersCount(context.Background()); count != 3 {
+			t.Errorf("Expected 3 users after the import, got %d", count)
+		}
+	})
+
+	t.Run("atomic mode saves nothing when a row is invalid", func(t *testing.T) {
+		handler, userService := newImportHandler(1 << 20)
+
+		summary := serveCSVImport(t, handler, newCSVImportRequest("/users/import?mode=atomic", withInvalidRow), http.StatusUnprocessableEntity)
+		if summary.Imported != 0 || len(summary.Failed) != 1 || summary.Failed[0].Row != 3 {
+			t.Errorf("Expected nothing imported and line 3 reported, got %+v", summary)
+		}
+		if _, err := userService.GetUserByEmail(context.Background(), "ann@example.com"); err == nil {
+			t.Error("Expected ann@example.com not to be imported")
+		}
+	})
+
+	t.Run("atomic mode imports a clean file", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+
+		summary := serveCSVImport(t, handler, newCSVImportRequest("/users/import?mode=atomic", "name,email\nAnn,ann@example.com\n"), http.StatusOK)
+		if summary.Imported != 1 {
+			t.Errorf("Expected 1 imported, got %+v", summary)
+		}
+	})
+
+	t.Run("rejects an oversized upload without saving", func(t *testing.T) {
+		handler, userService := newImportHandler(256)
+		var csv strings.Builder
+		csv.WriteString("name,email\n")
+		for i := range 50 {
+			fmt.Fprintf(&csv, "User %d,user%d@example.com\n", i, i)
+		}
+		req := newCSVImportRequest("/users/import", csv.String())
+		// An unknown length is only caught while reading
+		req.ContentLength = -1
+
+		rr := httptest.NewRecorder()
+		handler.ImportCSV(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+		}
+		if count, _ := userService.GetUsersCount(context.Background()); count != 1 {
+			t.Errorf("Expected no users to be imported, got %d users", count)
+		}
+	})
+
+	t.Run("rejects bad requests", func(t *testing.T) {
+		handler, _ := newImportHandler(1 << 20)
+		tests := []struct {
+			name string
+			req  *http.Request
+		}{
+			{"unknown mode", newCSVImportRequest("/users/import?mode=best-effort", "name,email\n")},
+			{"NDJSON upload", newImportRequest(t, "users.ndjson", `{"name":"Ann","email":"ann@example.com"}`)},
+			{"plain text body", httptest.NewRequest("POST", "/users/import", strings.NewReader("name,email\n"))},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := httptest.NewRecorder()
+				handler.ImportCSV(rr, tt.req)
+				if rr.Code != http.StatusBadRequest {
+					t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+				}
+			})
+		}
+	})
+}
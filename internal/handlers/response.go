@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/reqctx"
+)
+
+// writeJSON writes v as a JSON response with keys in the request's field case,
+// indented when the request asks for ?pretty=true. Indented envelopes, such as
+// the lists, are streamed an element at a time rather than encoded whole. A
+// client that went away before the response reached it is logged at debug and
+// not reported: there is nobody left to answer, and it is no fault of ours. The
+// returned error is a response that could not be encoded or written otherwise,
+// for the caller to log.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	var err error
+	envelope, isEnvelope := v.(map[string]interface{})
+	switch {
+	case pretty(r) && isEnvelope:
+		err = httputil.StreamIndentedJSON(r.Context(), w, status, envelope)
+	case pretty(r):
+		err = httputil.WriteIndentedJSON(r.Context(), w, status, httputil.InFieldCase(r.Context(), v))
+	default:
+		err = httputil.WriteJSON(r.Context(), w, status, httputil.InFieldCase(r.Context(), v))
+	}
+	if err != nil && (httputil.ClientGone(err) || r.Context().Err() != nil) {
+		requestID := reqctx.RequestIDFromContext(r.Context())
+		logging.FromContext(r.Context()).Debug("Client went away before the response was written", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		return nil
+	}
+	return err
+}
+
+// RouteNotFound answers a request for a path no route serves in the JSON error
+// envelope, without echoing the path back
+func RouteNotFound(w http.ResponseWriter, r *http.Request) {
+	body := httputil.ErrorBody{Code: "ROUTE_NOT_FOUND", Message: "no route matches the request"}
+	if err := httputil.WriteError(r.Context(), w, http.StatusNotFound, body); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to write error response", "error", err, "request_id", reqctx.RequestIDFromContext(r.Context()))
+	}
+}
+
+// MethodNotAllowed answers a request whose path is served only for other methods,
+// listed in the Allow header, in the JSON error envelope
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	httputil.Error(r.Context(), w, "method not allowed; see the Allow header", http.StatusMethodNotAllowed)
+}
+
+// pretty reports whether the request asked for indented JSON. Unparseable values mean compact output.
+func pretty(r *http.Request) bool {
+	indent, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return indent
+}
+
+// setLastModified sets the Last-Modified header to updatedAt, truncated to the
+// second as HTTP dates are, and returns what it was set to. A zero updatedAt sets
+// nothing and returns the zero time.
+func setLastModified(w http.ResponseWriter, updatedAt time.Time) time.Time {
+	if updatedAt.IsZero() {
+		return time.Time{}
+	}
+	lastModified := updatedAt.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	return lastModified
+}
+
+// notModified reports whether the request's If-Modified-Since is at or after
+// lastModified, so the client's copy is current. A malformed date is ignored, as
+// is one later than both our clock and lastModified, which only a client with a
+// skewed clock could have sent; lastModified itself may be ahead of our clock when
+// the database's is.
+func notModified(r *http.Request, lastModified time.Time) bool {
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	if since.After(time.Now()) && since.After(lastModified) {
+		return false
+	}
+	return !lastModified.After(since)
+}
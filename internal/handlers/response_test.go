@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"user-service/internal/httputil"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	repo := repository.NewInMemoryRepository(models.User{ID: 1, Name: "John Doe", Email: "john@example.com"})
+	userHandler := NewUserHandler(services.NewUserService(repo, metrics.New(reg, reg)))
+
+	get := func(target string) []byte {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.GetUser).ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s returned status %d", target, rr.Code)
+		}
+		return rr.Body.Bytes()
+	}
+
+	compact := get("/user?id=1")
+	indented := get("/user?id=1&pretty=true")
+
+	var want bytes.Buffer
+	if err := json.Indent(&want, compact, "", "  "); err != nil {
+		t.Fatalf("Failed to indent compact body: %v", err)
+	}
+	if !bytes.Equal(indented, want.Bytes()) {
+		t.Errorf("pretty body = %s, want %s", indented, want.Bytes())
+	}
+	if bytes.Contains(compact, []byte("\n  ")) {
+		t.Errorf("expected compact body by default, got %s", compact)
+	}
+
+	if invalid := get("/user?id=1&pretty=maybe"); !bytes.Equal(invalid, compact) {
+		t.Errorf("expected an invalid pretty value to give compact output, got %s", invalid)
+	}
+}
+
+func TestResponseFormats(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	at := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+	repo := repository.NewInMemoryRepository(models.User{ID: 1, Name: "John Doe", Email: "john@example.com", CreatedAt: at, UpdatedAt: at})
+	userHandler := NewUserHandler(services.NewUserService(repo, metrics.New(reg, reg)))
+
+	tests := []struct {
+		name      string
+		target    string
+		fieldCase string
+		want      string
+	}{
+		{
+			name:      "snake case, compact",
+			target:    "/users",
+			fieldCase: httputil.SnakeCase,
+			want: `{"total":1,"users":[{"id":1,"name":"John Doe","email":"john@example.com","role":"user","status":"active",` +
+				`"created_at":"2024-03-01T12:30:00Z","updated_at":"2024-03-01T12:30:00Z"}]}` + "\n",
+		},
+		{
+			name:      "camel case, compact",
+			target:    "/users",
+			fieldCase: httputil.CamelCase,
+			want: `{"total":1,"users":[{"id":1,"name":"John Doe","email":"john@example.com","role":"user","status":"active",` +
+				`"createdAt":"2024-03-01T12:30:00Z","updatedAt":"2024-03-01T12:30:00Z"}]}` + "\n",
+		},
+		{
+			name:      "snake case, pretty",
+			target:    "/users?pretty=true",
+			fieldCase: httputil.SnakeCase,
+			want: `{
+  "total": 1,
+  "users": [
+    {
+      "id": 1,
+      "name": "John Doe",
+      "email": "john@example.com",
+      "role": "user",
+      "status": "active",
+      "created_at": "2024-03-01T12:30:00Z",
+      "updated_at": "2024-03-01T12:30:00Z"
+    }
+  ]
+}
+`,
+		},
+		{
+			name:      "camel case, pretty",
+			target:    "/users?pretty=1",
+			fieldCase: httputil.CamelCase,
+			want: `{
+  "total": 1,
+  "users": [
+    {
+      "id": 1,
+      "name": "John Doe",
+      "email": "john@example.com",
+      "role": "user",
+      "status": "active",
+      "createdAt": "2024-03-01T12:30:00Z",
+      "updatedAt": "2024-03-01T12:30:00Z"
+    }
+  ]
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req = req.WithContext(httputil.WithFieldCase(req.Context(), tt.fieldCase))
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("GET %s returned status %d", tt.target, rr.Code)
+			}
+			if got := rr.Body.String(); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// brokenPipeWriter fails every body write the way a connection the client closed does
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenPipeWriter) Write([]byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+}
+
+func TestClientDisconnects(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(defaultLogger)
+
+	reg := prometheus.NewRegistry()
+	seed := make([]models.User, 0, 2*exportFlushInterval)
+	for id := 1; id <= 2*exportFlushInterval; id++ {
+		seed = append(seed, models.User{ID: id, Name: "User", Email: "user" + strconv.Itoa(id) + "@example.com"})
+	}
+	userHandler := NewUserHandler(services.NewUserService(repository.NewInMemoryRepository(seed...), metrics.New(reg, reg)))
+
+	tests := []struct {
+		target  string
+		handler http.HandlerFunc
+	}{
+		{"/users", userHandler.ListUsers},
+		{"/users/export", userHandler.ExportUsers},
+		{"/users/export.csv", userHandler.ExportUsersCSV},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			logs.Reset()
+			tt.handler.ServeHTTP(brokenPipeWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", tt.target, nil))
+
+			if strings.Contains(logs.String(), `"level":"ERROR"`) {
+				t.Errorf("Expected no error logged for a client that went away, got %s", logs.String())
+			}
+			if !strings.Contains(logs.String(), `"level":"DEBUG"`) {
+				t.Errorf("Expected the disconnect logged at debug, got %s", logs.String())
+			}
+		})
+	}
+
+	t.Run("encoding failures are still errors", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		err := writeJSON(rr, httptest.NewRequest("GET", "/users", nil), http.StatusOK, map[string]float64{"bad": math.Inf(1)})
+		if err == nil || httputil.ClientGone(err) {
+			t.Errorf("Expected an encoding error, got %v", err)
+		}
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+	})
+}
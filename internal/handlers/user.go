@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log/slog"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
-	"user-service/internal/middleware"
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
 	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
 	"user-service/internal/services"
 )
 
@@ -24,58 +30,668 @@ func NewUserHandler(userService *services.UserService) *UserHandler {
 
 // GetUser handles GET /user requests
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
-	requestID, _ := r.Context().Value(middleware.RequestIDKey).(string)
+	requestID := reqctx.RequestIDFromContext(r.Context())
 
 	// Extract and validate ID parameter
 	idStr := r.URL.Query().Get("id")
 	id, err := models.ParseUserID(idStr)
 	if err != nil {
-		slog.Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Get user from service
-	user, err := h.userService.GetUser(id)
+	user, err := h.userService.GetUser(r.Context(), id)
 	if err != nil {
-		slog.Warn("User not found", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
-		http.Error(w, err.Error(), http.StatusNotFound)
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Warn("User not found", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Honor date-based validation when the user has a modification time
+	if lastModified := setLastModified(w, user.UpdatedAt); notModified(r, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	// Set response headers and encode JSON
+	if err := writeJSON(w, r, http.StatusOK, user); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned user", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// HeadUser handles HEAD /user requests, answering 200 or 404 for whether the user
+// exists. It checks without reading the user, so the headers only GET can compute
+// from it, such as Last-Modified, are not sent. Errors are answered as GET answers
+// them; net/http drops their bodies.
+func (h *UserHandler) HeadUser(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.URL.Query().Get("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := h.userService.UserExists(r.Context(), id)
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to check user", "error", err, "id", id, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to check user", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		httputil.Error(r.Context(), w, repository.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		slog.Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	w.WriteHeader(http.StatusOK)
+}
+
+// profileNotFoundCode is the error code of GET /me for a caller no user is linked to
+const profileNotFoundCode = "PROFILE_NOT_FOUND"
+
+// GetMe handles GET /me requests, answering with the user the caller's subject is
+// linked to in the shape GET /user answers with. Anonymous requests get 401, and
+// callers linked to no user 404 with code PROFILE_NOT_FOUND.
+func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	caller, ok := reqctx.CallerFromContext(r.Context())
+	if !ok || caller.Subject == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httputil.Error(r.Context(), w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userService.GetUserBySubject(r.Context(), caller.Subject)
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			logging.FromContext(r.Context()).Warn("No user linked to caller", "caller", caller.Subject, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			body := httputil.ErrorBody{Code: profileNotFoundCode, Message: "no user is linked to the caller"}
+			if err := httputil.WriteError(r.Context(), w, http.StatusNotFound, body); err != nil {
+				logging.FromContext(r.Context()).Error("Failed to write error response", "error", err, "request_id", requestID)
+			}
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get user by subject", "error", err, "caller", caller.Subject, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	if lastModified := setLastModified(w, user.UpdatedAt); notModified(r, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	slog.Info("Successfully returned user", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+	if err := writeJSON(w, r, http.StatusOK, user); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", user.ID, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned caller's user", "id", user.ID, "remote_addr", r.RemoteAddr, "request_id", requestID)
 }
 
-// ListUsers handles GET /users requests
+// ListUsers handles GET /users requests, optionally filtered with ?role=, ?status=,
+// ?created_after= and ?created_before= (RFC3339 timestamps). Users are always
+// listed in ID order, so the same users come back in the same order across requests.
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	requestID, _ := r.Context().Value(middleware.RequestIDKey).(string)
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	filter := models.UserFilter{Role: r.URL.Query().Get("role"), Status: r.URL.Query().Get("status")}
+	if filter.Role != "" && !models.ValidRole(filter.Role) {
+		logging.FromContext(r.Context()).Warn("Invalid role parameter", "role", filter.Role, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, "role parameter is invalid", http.StatusBadRequest)
+		return
+	}
+	if filter.Status != "" && !models.ValidStatus(filter.Status) {
+		logging.FromContext(r.Context()).Warn("Invalid status parameter", "status", filter.Status, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, "status parameter is invalid", http.StatusBadRequest)
+		return
+	}
 
-	users, err := h.userService.ListUsers()
+	bounds := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	}
+	for _, bound := range bounds {
+		param := r.URL.Query().Get(bound.name)
+		if param == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Invalid timestamp parameter", "parameter", bound.name, "value", param, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, bound.name+" parameter must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		*bound.value = parsed
+	}
+
+	users, err := h.userService.ListUsers(r.Context(), filter)
 	if err != nil {
-		slog.Error("Failed to list users", "error", err, "request_id", requestID)
-		http.Error(w, "failed to list users", http.StatusInternalServerError)
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to list users", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to list users", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	// The page is as recent as its latest change. If-Modified-Since is not honored:
+	// deleting a user drops it from the page without making the page any newer.
+	var updatedAt time.Time
+	for _, user := range users {
+		if user.UpdatedAt.After(updatedAt) {
+			updatedAt = user.UpdatedAt
+		}
+	}
+	setLastModified(w, updatedAt)
+
 	response := map[string]interface{}{
 		"users": users,
 		"total": len(users),
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		slog.Error("Failed to encode users list", "error", err, "request_id", requestID)
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	if err := writeJSON(w, r, http.StatusOK, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode users list", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned users list", "count", len(users), "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// CountUsers handles GET /users/count requests
+func (h *UserHandler) CountUsers(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	count, err := h.userService.GetUsersCount(r.Context())
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to count users", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to count users", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"count": count,
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode users count", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned users count", "count", count, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// EmailAvailable handles GET /users/email-available?email= requests for signup
+// forms, answering {"available":true} when no user, deleted ones included, has
+// the email. Emails that could never sign up get 400.
+func (h *UserHandler) EmailAvailable(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	lookup := models.User{Email: r.URL.Query().Get("email")}
+	lookup.Sanitize()
+	if err := models.ValidateEmail(lookup.Email); err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid email parameter", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, "email parameter is invalid: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	taken, err := h.userService.EmailExists(r.Context(), lookup.Email)
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to check email", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to check email", http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, map[string]bool{"available": !taken}); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode email availability", "error", err, "request_id", requestID)
+		return
+	}
+
+	// The email itself is not logged, so the log cannot be mined for who signed up
+	logging.FromContext(r.Context()).Info("Successfully checked email availability", "available", !taken, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// userRequest is the body accepted when creating or updating a user
+type userRequest struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Role        string `json:"role"`
+	AvatarURL   string `json:"avatar_url"`
+	DisplayName string `json:"display_name"`
+}
+
+// updateUserRequest is the body accepted when replacing a user. It has no role to
+// set: Role only records whether the body had one, which is rejected rather than
+// ignored, as the update keeps the user's role.
+type updateUserRequest struct {
+	Name        string          `json:"name"`
+	Email       string          `json:"email"`
+	Role        json.RawMessage `json:"role"`
+	AvatarURL   string          `json:"avatar_url"`
+	DisplayName string          `json:"display_name"`
+}
+
+// maxUserRequestBytes bounds a create or update body, well above any valid user
+const maxUserRequestBytes = 16 << 10
+
+// decodeUserRequest reads a create or update body, writing the error response itself when it cannot
+func decodeUserRequest(w http.ResponseWriter, r *http.Request) (userRequest, bool) {
+	var body userRequest
+	return body, decodeUserBody(w, r, &body)
+}
+
+// decodeUserBody reads a user body into dst, writing the error response itself when it cannot
+func decodeUserBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUserRequestBytes)).Decode(dst)
+	if err == nil {
+		return true
+	}
+
+	logging.FromContext(r.Context()).Warn("Invalid user body", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httputil.Error(r.Context(), w, "request body too large", http.StatusRequestEntityTooLarge)
+	} else {
+		httputil.Error(r.Context(), w, "invalid request body", http.StatusBadRequest)
+	}
+	return false
+}
+
+// CreateUser handles POST /users requests
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	body, ok := decodeUserRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Sanitize here too so the email is checked as it will be stored
+	user := models.User{Name: body.Name, Email: body.Email, Role: body.Role, AvatarURL: body.AvatarURL, DisplayName: body.DisplayName}
+	user.Sanitize()
+
+	// Turn away a taken email before inserting. The unique constraint still
+	// catches one taken in between, and a failed check leaves it to the constraint.
+	taken, err := h.userService.EmailExists(r.Context(), user.Email)
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Warn("Failed to check for a duplicate email", "error", err, "request_id", requestID)
+	}
+	if taken {
+		h.writeEmailTaken(w, r, user.Email)
+		return
+	}
+
+	created, err := h.userService.AddUser(r.Context(), user)
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			h.writeEmailTaken(w, r, user.Email)
+			return
+		}
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusCreated, created); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", created.ID, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully created user", "id", created.ID, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// UpdateUser handles PUT /user?id= requests
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.URL.Query().Get("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body updateUserRequest
+	if !decodeUserBody(w, r, &body) {
+		return
+	}
+	if body.Role != nil {
+		logging.FromContext(r.Context()).Warn("Role in user update", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		writeValidationErrors(w, r, models.ValidationErrors{{Field: "role", Rule: models.RuleReadOnly, Message: "cannot be changed with PUT /user"}})
+		return
+	}
+
+	updated, err := h.userService.UpdateUser(r.Context(), models.User{ID: id, Name: body.Name, Email: body.Email, AvatarURL: body.AvatarURL, DisplayName: body.DisplayName})
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			h.writeEmailTaken(w, r, body.Email)
+			return
+		}
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, updated); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
 		return
 	}
 
-	slog.Info("Successfully returned users list", "count", len(users), "remote_addr", r.RemoteAddr, "request_id", requestID)
+	logging.FromContext(r.Context()).Info("Successfully updated user", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// PatchUser handles PATCH /user?id= requests, changing only the fields the body
+// has, as in {"email":"new@example.com"}. A body with none of them answers 400.
+func (h *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.URL.Query().Get("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var patch models.UserPatch
+	if !decodeUserBody(w, r, &patch) {
+		return
+	}
+	if patch.Empty() {
+		logging.FromContext(r.Context()).Warn("Empty user patch", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, "patch changes nothing: set name, email, avatar_url or display_name", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.userService.PatchUser(r.Context(), id, patch)
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateEmail) && patch.Email != nil {
+			h.writeEmailTaken(w, r, *patch.Email)
+			return
+		}
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, updated); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully patched user", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// DeleteUser handles DELETE /user?id= requests. Users are soft-deleted and can be restored by an admin.
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.URL.Query().Get("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userService.DeleteUser(r.Context(), id); err != nil {
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logging.FromContext(r.Context()).Info("Successfully deleted user", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// AdminListUsers handles GET /admin/users requests. Deleted users are
+// included with ?include_deleted=true.
+func (h *UserHandler) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	includeDeleted := false
+	if value := r.URL.Query().Get("include_deleted"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Invalid include_deleted parameter", "include_deleted", value, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, "include_deleted parameter is invalid", http.StatusBadRequest)
+			return
+		}
+		includeDeleted = parsed
+	}
+
+	users, err := h.userService.ListAllUsers(r.Context(), includeDeleted)
+	if err != nil {
+		if queryTimedOut(w, r, err) {
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to list users", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to list users", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"users": users,
+		"total": len(users),
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode users list", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned admin users list", "count", len(users), "include_deleted", includeDeleted, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// RestoreUser handles POST /admin/users/{id}/restore requests
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.PathValue("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restored, err := h.userService.RestoreUser(r.Context(), id)
+	if err != nil {
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, restored); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully restored user", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// subjectTakenCode is the error code of linking a subject another user is linked to
+const subjectTakenCode = "SUBJECT_TAKEN"
+
+// linkSubjectRequest is the body of PUT /admin/users/{id}/subject
+type linkSubjectRequest struct {
+	Subject string `json:"subject"`
+}
+
+// LinkSubject handles PUT /admin/users/{id}/subject requests, linking the user to
+// the identity provider subject in {"subject":"auth0|alice"} so that the caller
+// authenticating as it is answered GET /me with the user. A subject linked to
+// another user gets 409 with code SUBJECT_TAKEN.
+func (h *UserHandler) LinkSubject(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.PathValue("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req linkSubjectRequest
+	if !decodeUserBody(w, r, &req) {
+		return
+	}
+	subject, err := models.SanitizeSubject(req.Subject)
+	if err != nil {
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	linked, err := h.userService.LinkSubject(r.Context(), id, subject)
+	if err != nil {
+		if errors.Is(err, repository.ErrSubjectTaken) {
+			logging.FromContext(r.Context()).Warn("Subject already linked", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			body := httputil.ErrorBody{Code: subjectTakenCode, Message: err.Error()}
+			if err := httputil.WriteError(r.Context(), w, http.StatusConflict, body); err != nil {
+				logging.FromContext(r.Context()).Error("Failed to write error response", "error", err, "request_id", requestID)
+			}
+			return
+		}
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, linked); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully linked user to subject", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// DisableUser handles POST /users/{id}/disable requests
+func (h *UserHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserStatus(w, r, models.StatusDisabled, h.userService.DisableUser)
+}
+
+// EnableUser handles POST /users/{id}/enable requests
+func (h *UserHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserStatus(w, r, models.StatusActive, h.userService.EnableUser)
+}
+
+// setUserStatus applies a status change to the user in the path and responds with the user
+func (h *UserHandler) setUserStatus(w http.ResponseWriter, r *http.Request, status string, apply func(ctx context.Context, id int) (models.User, error)) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.PathValue("id")
+	id, err := models.ParseUserID(idStr)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Invalid id parameter", "error", err, "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := apply(r.Context(), id)
+	if err != nil {
+		h.writeSaveError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, user); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode user", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully set user status", "id", id, "status", status, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// writeSaveError maps an error from a user write to a response
+func (h *UserHandler) writeSaveError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	var validationErrs models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		logging.FromContext(r.Context()).Warn("User failed validation", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		writeValidationErrors(w, r, validationErrs)
+	case errors.Is(err, repository.ErrNotFound):
+		httputil.Error(r.Context(), w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		h.writeEmailTaken(w, r, "")
+	case errors.Is(err, services.ErrQueryTimeout):
+		queryTimedOut(w, r, err)
+	default:
+		logging.FromContext(r.Context()).Error("Failed to save user", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to save user", http.StatusInternalServerError)
+	}
+}
+
+// emailTakenCode is the error code of a write that would reuse another user's email
+const emailTakenCode = "EMAIL_ALREADY_EXISTS"
+
+// writeEmailTaken answers a write that would reuse another user's email with a 409.
+// Admins are also told which user has email, when it is known, in
+// {"details":{"existing_user_id":7}}; other callers are not, so the API cannot be
+// used to find out who signed up with an address. The user is looked up as the
+// unique constraint and EmailExists match it, in any case and deleted or not.
+func (h *UserHandler) writeEmailTaken(w http.ResponseWriter, r *http.Request, email string) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	body := httputil.ErrorBody{Code: emailTakenCode, Message: repository.ErrDuplicateEmail.Error()}
+	if caller, _ := reqctx.CallerFromContext(r.Context()); email != "" && caller.HasRole(reqctx.AdminRole) {
+		lookup := models.User{Email: email}
+		lookup.Sanitize()
+		if id, err := h.userService.EmailOwner(r.Context(), lookup.Email); err == nil {
+			body.Details = map[string]int{"existing_user_id": id}
+		}
+	}
+	logging.FromContext(r.Context()).Warn("Email already exists", "remote_addr", r.RemoteAddr, "request_id", requestID)
+	if err := httputil.WriteError(r.Context(), w, http.StatusConflict, body); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to write error response", "error", err, "request_id", requestID)
+	}
+}
+
+// queryTimedOut responds 503 when err is a database query that ran past its
+// timeout, reporting whether it did
+func queryTimedOut(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, services.ErrQueryTimeout) {
+		return false
+	}
+	requestID := reqctx.RequestIDFromContext(r.Context())
+	logging.FromContext(r.Context()).Warn("Database query timed out", "error", err, "request_id", requestID)
+	httputil.Error(r.Context(), w, "database query timed out", http.StatusServiceUnavailable)
+	return true
+}
+
+// writeValidationErrors renders every failed rule as a 422 response:
+// {"error":{"code":"VALIDATION","message":"email must contain @","details":[{"field":"email","rule":"email_format","message":"must contain @"}],"request_id":"..."}}
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs models.ValidationErrors) {
+	body := httputil.ErrorBody{Code: "VALIDATION", Message: errs.Error(), Details: errs}
+	if err := httputil.WriteError(r.Context(), w, http.StatusUnprocessableEntity, body); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode validation errors", "error", err)
+	}
 }
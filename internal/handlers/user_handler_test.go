@@ -2,16 +2,28 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/mock"
+	"user-service/internal/database"
 	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/httputil"
 	"user-service/internal/metrics"
+	"user-service/internal/middleware"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
 	"user-service/internal/services"
 )
 
@@ -30,10 +42,12 @@ func TestUserHandler(t *testing.T) {
 			*arg[0].(*int) = 1
 			*arg[1].(*string) = "John Doe"
 			*arg[2].(*string) = "john@example.com"
+			*arg[3].(*time.Time) = time.Date(2024, 3, 2, 8, 30, 0, 0, time.UTC)
+			*arg[5].(*time.Time) = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
 		})
-		dbMock.On("QueryRow", context.Background(), "SELECT id, name, email FROM users WHERE id = $1", 1).Return(row)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(row)
 
-		userService := services.NewUserService(dbMock, metricsCollector)
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
 		userHandler := NewUserHandler(userService)
 		req, err := http.NewRequest("GET", "/user?id=1", nil)
 		if err != nil {
@@ -49,6 +63,14 @@ func TestUserHandler(t *testing.T) {
 			t.Errorf("handler returned wrong status code: got %v want %v",
 				status, http.StatusOK)
 		}
+
+		var response map[string]interface{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response["created_at"] != "2024-03-01T12:00:00Z" || response["updated_at"] != "2024-03-02T08:30:00Z" {
+			t.Errorf("expected RFC3339 created_at and updated_at, got %v", response)
+		}
 		dbMock.AssertExpectations(t)
 	})
 
@@ -59,19 +81,23 @@ func TestUserHandler(t *testing.T) {
 		// Setup expectations for GetUser (non-existent)
 		notFoundRow := &mocks.MockRow{}
 		notFoundRow.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
-		dbMock.On("QueryRow", context.Background(), "SELECT id, name, email FROM users WHERE id = $1", 100).Return(notFoundRow)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 100).Return(notFoundRow)
 
-		userService := services.NewUserService(dbMock, metricsCollector)
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
 		userHandler := NewUserHandler(userService)
 
 		tests := []struct {
 			name       string
 			url        string
 			wantStatus int
+			wantBody   string
 		}{
-			{"missing id", "/user", http.StatusBadRequest},
-			{"invalid id", "/user?id=abc", http.StatusBadRequest},
-			{"not found id", "/user?id=100", http.StatusNotFound},
+			{"missing id", "/user", http.StatusBadRequest, "id parameter is missing"},
+			{"invalid id", "/user?id=abc", http.StatusBadRequest, "id parameter is invalid"},
+			{"overflowing id", "/user?id=99999999999999999999", http.StatusBadRequest, "id parameter is out of range: must be between 1 and 2147483647"},
+			{"zero id", "/user?id=0", http.StatusBadRequest, "id parameter is out of range: must be between 1 and 2147483647"},
+			{"signed id", "/user?id=%2B5", http.StatusBadRequest, "id parameter is invalid"},
+			{"not found id", "/user?id=100", http.StatusNotFound, ""},
 		}
 
 		for _, tt := range tests {
@@ -90,6 +116,9 @@ func TestUserHandler(t *testing.T) {
 					t.Errorf("handler returned wrong status code: got %v want %v",
 						status, tt.wantStatus)
 				}
+				if message := errorMessage(t, rr); tt.wantBody != "" && message != tt.wantBody {
+					t.Errorf("handler returned wrong error: got %q want %q", message, tt.wantBody)
+				}
 			})
 		}
 		dbMock.AssertExpectations(t)
@@ -104,15 +133,16 @@ func TestUserHandler(t *testing.T) {
 		rows.On("Close").Return()
 		rows.On("Next").Return(true).Once()
 		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
 		rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 			arg := args.Get(0).([]interface{})
 			*arg[0].(*int) = 1
 			*arg[1].(*string) = "John Doe"
 			*arg[2].(*string) = "john@example.com"
 		})
-		dbMock.On("Query", context.Background(), "SELECT id, name, email FROM users").Return(rows, nil)
+		dbMock.On("Query", context.Background(), queries.Default.ListUsers).Return(rows, nil)
 
-		userService := services.NewUserService(dbMock, metricsCollector)
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
 		userHandler := NewUserHandler(userService)
 
 		req, err := http.NewRequest("GET", "/users", nil)
@@ -132,14 +162,116 @@ func TestUserHandler(t *testing.T) {
 		dbMock.AssertExpectations(t)
 	})
 
+	t.Run("list users filtered by role", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(true).Once()
+		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
+		rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int) = 1
+			*arg[1].(*string) = "Ada Admin"
+			*arg[2].(*string) = "ada@example.com"
+			*arg[4].(*string) = models.RoleAdmin
+		})
+		dbMock.On("Query", context.Background(), queries.Default.ListUsersByRole, models.RoleAdmin).Return(rows, nil)
+		userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+		req := httptest.NewRequest("GET", "/users?role=admin", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var response struct {
+			Users []models.User `json:"users"`
+			Total int           `json:"total"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Total != 1 || response.Users[0].Role != models.RoleAdmin {
+			t.Errorf("expected one admin user, got %+v", response.Users)
+		}
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("list users invalid role", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+		req := httptest.NewRequest("GET", "/users?role=superuser", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+		dbMock.AssertNotCalled(t, "Query")
+	})
+
+	t.Run("list users invalid status", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+		req := httptest.NewRequest("GET", "/users?status=banned", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+		dbMock.AssertNotCalled(t, "Query")
+	})
+
+	t.Run("list users filtered by creation time", func(t *testing.T) {
+		after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		before := time.Date(2024, 2, 1, 0, 0, 0, 0, time.FixedZone("", 2*60*60))
+		sql, args := queries.Default.ListUsersQuery(models.UserFilter{CreatedAfter: after, CreatedBefore: before})
+
+		dbMock := &mocks.MockDBTX{}
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
+		dbMock.On("Query", append([]interface{}{context.Background(), sql}, args...)...).Return(rows, nil)
+		userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+		req := httptest.NewRequest("GET", "/users?created_after=2024-01-01T00:00:00Z&created_before=2024-02-01T00:00:00%2B02:00", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("list users invalid created_after", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+		req := httptest.NewRequest("GET", "/users?created_after=yesterday", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+		dbMock.AssertNotCalled(t, "Query")
+	})
+
 	t.Run("list users database error", func(t *testing.T) {
 		// Create a mock for DBTX
 		dbMock := &mocks.MockDBTX{}
 
 		// Setup expectations for database error
-		dbMock.On("Query", context.Background(), "SELECT id, name, email FROM users").Return(nil, errors.New("database error"))
+		dbMock.On("Query", context.Background(), queries.Default.ListUsers).Return(nil, errors.New("database error"))
 
-		userService := services.NewUserService(dbMock, metricsCollector)
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
 		userHandler := NewUserHandler(userService)
 
 		req, err := http.NewRequest("GET", "/users", nil)
@@ -158,4 +290,964 @@ func TestUserHandler(t *testing.T) {
 		}
 		dbMock.AssertExpectations(t)
 	})
+
+	t.Run("list users query timeout", func(t *testing.T) {
+		// The query blocks until the service's query timeout cancels it
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("Query", mock.Anything, queries.Default.ListUsers).Return(nil, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector,
+			services.WithQueryLimits(10*time.Millisecond, 0))
+		userHandler := NewUserHandler(userService)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, httptest.NewRequest("GET", "/users", nil))
+
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+		}
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("count users", func(t *testing.T) {
+		// Create a mock for DBTX
+		dbMock := &mocks.MockDBTX{}
+
+		// Setup expectations for GetUsersCount
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int) = 4
+		})
+		dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row)
+
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+		userHandler := NewUserHandler(userService)
+
+		req, err := http.NewRequest("GET", "/users/count", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		h := http.HandlerFunc(userHandler.CountUsers)
+
+		h.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("handler returned wrong content type: got %v want application/json", contentType)
+		}
+
+		var response map[string]interface{}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(response) != 1 || response["count"] != float64(4) {
+			t.Errorf("handler returned unexpected body: got %v want map[count:4]", response)
+		}
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("count users database error", func(t *testing.T) {
+		// Create a mock for DBTX
+		dbMock := &mocks.MockDBTX{}
+
+		// Setup expectations for database error
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(errors.New("database error"))
+		dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row)
+
+		userService := services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+		userHandler := NewUserHandler(userService)
+
+		req, err := http.NewRequest("GET", "/users/count", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		h := http.HandlerFunc(userHandler.CountUsers)
+
+		h.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusInternalServerError {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusInternalServerError)
+		}
+		dbMock.AssertExpectations(t)
+	})
+}
+
+func TestUserHandlerLastModified(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	updatedAt := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+
+	newHandler := func(updatedAt time.Time) (*UserHandler, *mocks.MockDBTX) {
+		dbMock := &mocks.MockDBTX{}
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int) = 1
+			*arg[1].(*string) = "John Doe"
+			*arg[2].(*string) = "john@example.com"
+			*arg[3].(*time.Time) = updatedAt
+		})
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(row)
+		return NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)), dbMock
+	}
+
+	tests := []struct {
+		name             string
+		updatedAt        time.Time
+		ifModifiedSince  string
+		wantStatus       int
+		wantLastModified string
+	}{
+		{"no validator", updatedAt, "", http.StatusOK, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"client copy is current", updatedAt, "Fri, 01 Mar 2024 12:30:00 GMT", http.StatusNotModified, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"client copy is newer", updatedAt, "Sat, 02 Mar 2024 00:00:00 GMT", http.StatusNotModified, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"client copy is stale", updatedAt, "Thu, 29 Feb 2024 00:00:00 GMT", http.StatusOK, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"malformed header is ignored", updatedAt, "not a date", http.StatusOK, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"date ahead of our clock is ignored", updatedAt, "Fri, 01 Jan 2100 00:00:00 GMT", http.StatusOK, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"sub-second changes are truncated", updatedAt.Add(750 * time.Millisecond), "Fri, 01 Mar 2024 12:30:00 GMT", http.StatusNotModified, "Fri, 01 Mar 2024 12:30:00 GMT"},
+		{"zero updated at omits header", time.Time{}, "Fri, 01 Mar 2024 12:30:00 GMT", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userHandler, dbMock := newHandler(tt.updatedAt)
+			req, err := http.NewRequest("GET", "/user?id=1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifModifiedSince != "" {
+				req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(userHandler.GetUser).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if lastModified := rr.Header().Get("Last-Modified"); lastModified != tt.wantLastModified {
+				t.Errorf("handler returned wrong Last-Modified: got %q want %q", lastModified, tt.wantLastModified)
+			}
+			if tt.wantStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("handler returned a body with 304: %q", rr.Body.String())
+			}
+			dbMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListUsersLastModified(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	created := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	repo := repository.NewInMemoryRepository(
+		models.User{ID: 1, Name: "John Doe", Email: "john@example.com", CreatedAt: created, UpdatedAt: created.Add(48 * time.Hour)},
+		models.User{ID: 2, Name: "Jane Doe", Email: "jane@example.com", CreatedAt: created, UpdatedAt: created.Add(time.Hour)},
+	)
+	userHandler := NewUserHandler(services.NewUserService(repo, metricsCollector))
+
+	req, err := http.NewRequest("GET", "/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lists are always served in full
+	req.Header.Set("If-Modified-Since", "Wed, 03 Jan 2024 00:00:00 GMT")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(userHandler.ListUsers).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if lastModified, want := rr.Header().Get("Last-Modified"), "Wed, 03 Jan 2024 00:00:00 GMT"; lastModified != want {
+		t.Errorf("handler returned wrong Last-Modified: got %q want %q", lastModified, want)
+	}
+}
+
+func TestHeadUser(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	// Only the existence check is mocked, so reading the user would fail the test
+	dbMock := &mocks.MockDBTX{}
+	found := &mocks.MockRow{}
+	found.On("Scan", mock.Anything).Return(nil)
+	missing := &mocks.MockRow{}
+	missing.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.UserExists, 1).Return(found)
+	dbMock.On("QueryRow", mock.Anything, queries.Default.UserExists, 99).Return(missing)
+	userHandler := NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector))
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"existing user", "/user?id=1", http.StatusOK},
+		{"missing user", "/user?id=99", http.StatusNotFound},
+		{"invalid id", "/user?id=abc", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			userHandler.HeadUser(rr, httptest.NewRequest("HEAD", tt.target, nil))
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && rr.Body.Len() != 0 {
+				t.Errorf("handler returned a body: %q", rr.Body.String())
+			}
+		})
+	}
+	dbMock.AssertExpectations(t)
+}
+
+func TestGetMe(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	if _, err := userService.LinkSubject(context.Background(), 1, "auth0|john"); err != nil {
+		t.Fatalf("Failed to link subject: %v", err)
+	}
+	userHandler := NewUserHandler(userService)
+
+	tests := []struct {
+		name       string
+		caller     *reqctx.Caller
+		wantStatus int
+		wantCode   string
+	}{
+		{"anonymous", nil, http.StatusUnauthorized, ""},
+		{"no subject", &reqctx.Caller{Roles: []string{"user"}}, http.StatusUnauthorized, ""},
+		{"unlinked subject", &reqctx.Caller{Subject: "auth0|nobody"}, http.StatusNotFound, "PROFILE_NOT_FOUND"},
+		{"linked subject", &reqctx.Caller{Subject: "auth0|john"}, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/me", nil)
+			if tt.caller != nil {
+				req = req.WithContext(reqctx.WithCaller(req.Context(), *tt.caller))
+			}
+			rr := httptest.NewRecorder()
+			userHandler.GetMe(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			switch tt.wantStatus {
+			case http.StatusUnauthorized:
+				if rr.Header().Get("WWW-Authenticate") != "Bearer" {
+					t.Error("Expected a WWW-Authenticate challenge")
+				}
+			case http.StatusNotFound:
+				var response struct {
+					Error httputil.ErrorBody `json:"error"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+					t.Fatalf("Failed to decode error body %q: %v", rr.Body.String(), err)
+				}
+				if response.Error.Code != tt.wantCode {
+					t.Errorf("Expected code %s, got %+v", tt.wantCode, response.Error)
+				}
+			case http.StatusOK:
+				var user models.User
+				if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+					t.Fatalf("Failed to decode user %q: %v", rr.Body.String(), err)
+				}
+				if user.ID != 1 || user.Email != "john@example.com" {
+					t.Errorf("Expected user 1, got %+v", user)
+				}
+			}
+		})
+	}
+}
+
+func TestLinkSubject(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	userService := services.NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector)
+	if _, err := userService.LinkSubject(context.Background(), 2, "auth0|jane"); err != nil {
+		t.Fatalf("Failed to link subject: %v", err)
+	}
+	userHandler := NewUserHandler(userService)
+
+	tests := []struct {
+		name       string
+		id         string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"links the subject", "1", `{"subject":" auth0|john "}`, http.StatusOK, ""},
+		{"invalid id", "abc", `{"subject":"auth0|john"}`, http.StatusBadRequest, ""},
+		{"invalid body", "1", `{"subject":`, http.StatusBadRequest, ""},
+		{"empty subject", "1", `{"subject":"  "}`, http.StatusUnprocessableEntity, "VALIDATION"},
+		{"unknown user", "999", `{"subject":"auth0|ghost"}`, http.StatusNotFound, ""},
+		{"subject of another user", "1", `{"subject":"auth0|jane"}`, http.StatusConflict, "SUBJECT_TAKEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/admin/users/"+tt.id+"/subject", strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			userHandler.LinkSubject(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantCode != "" {
+				var response struct {
+					Error httputil.ErrorBody `json:"error"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+					t.Fatalf("Failed to decode error body %q: %v", rr.Body.String(), err)
+				}
+				if response.Error.Code != tt.wantCode {
+					t.Errorf("Expected code %s, got %+v", tt.wantCode, response.Error)
+				}
+			}
+		})
+	}
+
+	user, err := userService.GetUserBySubject(context.Background(), "auth0|john")
+	if err != nil || user.ID != 1 {
+		t.Errorf("Expected auth0|john to be linked to user 1, got %+v, %v", user, err)
+	}
+}
+
+func TestEmailAvailable(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	repo := repository.NewInMemoryRepository(repository.SeedUsers()...)
+	userHandler := NewUserHandler(services.NewUserService(repo, metrics.New(reg, reg)))
+
+	tests := []struct {
+		name          string
+		target        string
+		wantStatus    int
+		wantAvailable bool
+	}{
+		{"free email", "/users/email-available?email=new@example.com", http.StatusOK, true},
+		{"taken email", "/users/email-available?email=john@example.com", http.StatusOK, false},
+		{"taken email in another case", "/users/email-available?email=%20John@Example.COM%20", http.StatusOK, false},
+		{"missing email", "/users/email-available", http.StatusBadRequest, false},
+		{"email without @", "/users/email-available?email=john.example.com", http.StatusBadRequest, false},
+		{"email with control characters", "/users/email-available?email=john%00@example.com", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			userHandler.EmailAvailable(rr, httptest.NewRequest("GET", tt.target, nil))
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response struct {
+				Available bool `json:"available"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response %q: %v", rr.Body.String(), err)
+			}
+			if response.Available != tt.wantAvailable {
+				t.Errorf("Expected available %v, got %v", tt.wantAvailable, response.Available)
+			}
+		})
+	}
+
+	// A deleted user keeps the email
+	if err := repo.Delete(context.Background(), 2); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	userHandler.EmailAvailable(rr, httptest.NewRequest("GET", "/users/email-available?email=jane@example.com", nil))
+	if !strings.Contains(rr.Body.String(), `"available":false`) {
+		t.Errorf("Expected a deleted user's email to stay taken, got %s", rr.Body.String())
+	}
+}
+
+func TestUserHandlerWrites(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	newHandler := func() *UserHandler {
+		repo := repository.NewInMemoryRepository(models.User{ID: 1, Name: "John Doe", Email: "john@example.com"})
+		return NewUserHandler(services.NewUserService(repo, metricsCollector))
+	}
+
+	// validationResponse is the body rendered for a request that fails validation
+	type validationResponse struct {
+		Error struct {
+			Code    string              `json:"code"`
+			Message string              `json:"message"`
+			Details []models.FieldError `json:"details"`
+		} `json:"error"`
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		handler     func(*UserHandler) http.HandlerFunc
+		wantStatus  int
+		wantDetails []models.FieldError
+	}{
+		{
+			name:       "create user",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"Jane Smith","email":"jane@example.com","role":"admin"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "create user reports every invalid field",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"","email":"invalid-email","role":"superuser"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetails: []models.FieldError{
+				{Field: "name", Rule: "required", Message: "cannot be empty"},
+				{Field: "email", Rule: "email_format", Message: "must contain @"},
+				{Field: "role", Rule: "one_of", Message: "must be one of admin, user, guest"},
+			},
+		},
+		{
+			name:       "create user reports several rules per field",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"` + strings.Repeat("a", 101) + `\u0007b","email":"bad\tmail","role":"root"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetails: []models.FieldError{
+				{Field: "name", Rule: "max_length", Message: "must be at most 100 characters"},
+				{Field: "name", Rule: "no_control_chars", Message: "must not contain control characters"},
+				{Field: "email", Rule: "email_format", Message: "must contain @"},
+				{Field: "email", Rule: "no_control_chars", Message: "must not contain control characters"},
+				{Field: "role", Rule: "one_of", Message: "must be one of admin, user, guest"},
+			},
+		},
+		{
+			name:       "create user invalid avatar",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"Jane Smith","email":"jane@example.com","avatar_url":"javascript:alert(1)"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetails: []models.FieldError{
+				{Field: "avatar_url", Rule: "url_format", Message: "must be an absolute http or https URL"},
+			},
+		},
+		{
+			name:       "create user duplicate email",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"Other John","email":"john@example.com"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "create user trims input",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"  Trim Me  ","email":" trim@example.com\n"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "create user oversized body",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":"` + strings.Repeat("a", 20<<10) + `","email":"big@example.com"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "create user malformed body",
+			method:     "POST",
+			target:     "/users",
+			body:       `{"name":`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.CreateUser },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "update user",
+			method:     "PUT",
+			target:     "/user?id=1",
+			body:       `{"name":"John Updated","email":"john.updated@example.com"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.UpdateUser },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "update user reports every invalid field",
+			method:     "PUT",
+			target:     "/user?id=1",
+			body:       `{"name":"","email":""}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.UpdateUser },
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetails: []models.FieldError{
+				{Field: "name", Rule: "required", Message: "cannot be empty"},
+				{Field: "email", Rule: "required", Message: "cannot be empty"},
+			},
+		},
+		{
+			name:       "update user rejects a role rather than ignoring it",
+			method:     "PUT",
+			target:     "/user?id=1",
+			body:       `{"name":"John Doe","email":"john@example.com","role":"admin"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.UpdateUser },
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetails: []models.FieldError{
+				{Field: "role", Rule: "read_only", Message: "cannot be changed with PUT /user"},
+			},
+		},
+		{
+			name:       "update missing user",
+			method:     "PUT",
+			target:     "/user?id=99",
+			body:       `{"name":"Nobody","email":"nobody@example.com"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.UpdateUser },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "update user invalid id",
+			method:     "PUT",
+			target:     "/user?id=abc",
+			body:       `{"name":"John Doe","email":"john@example.com"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.UpdateUser },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "delete user",
+			method:     "DELETE",
+			target:     "/user?id=1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.DeleteUser },
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "delete missing user",
+			method:     "DELETE",
+			target:     "/user?id=99",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.DeleteUser },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "delete user invalid id",
+			method:     "DELETE",
+			target:     "/user?id=abc",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.DeleteUser },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "admin list invalid include_deleted",
+			method:     "GET",
+			target:     "/admin/users?include_deleted=maybe",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminListUsers },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "audit log invalid user_id",
+			method:     "GET",
+			target:     "/admin/audit?user_id=abc",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminAuditLog },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "audit log invalid before",
+			method:     "GET",
+			target:     "/admin/audit?before=-1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminAuditLog },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "audit log disabled",
+			method:     "GET",
+			target:     "/admin/audit",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminAuditLog },
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			tt.handler(newHandler()).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", status, tt.wantStatus, rr.Body.String())
+			}
+
+			if tt.wantDetails != nil {
+				var response validationResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatalf("Failed to decode validation response: %v", err)
+				}
+				if response.Error.Code != "VALIDATION" {
+					t.Errorf("error code = %q, want VALIDATION", response.Error.Code)
+				}
+				if !reflect.DeepEqual(response.Error.Details, tt.wantDetails) {
+					t.Errorf("details = %+v, want %+v", response.Error.Details, tt.wantDetails)
+				}
+				if response.Error.Message == "" {
+					t.Error("expected a one-line error message alongside the details")
+				}
+			}
+
+			if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusCreated {
+				var user models.User
+				if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+					t.Fatalf("Failed to decode user: %v", err)
+				}
+				if user.ID == 0 || user.Email == "" {
+					t.Errorf("expected the saved user in the response, got %+v", user)
+				}
+			}
+		})
+	}
+}
+
+// primaryTx runs every transaction without one, against whatever repository the
+// service binds to it
+type primaryTx struct{}
+
+func (primaryTx) WithTx(_ context.Context, fn func(tx database.DBTX) error) error {
+	return fn(nil)
+}
+
+func TestWritesWithLaggingReplica(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	primary := repository.NewInMemoryRepository(repository.SeedUsers()...)
+	// Reads go to a replica that has yet to see any write
+	replica := repository.NewInMemoryRepository(repository.SeedUsers()...)
+	userService := services.NewUserService(replica, metrics.New(reg, reg), services.WithCache(10, time.Minute),
+		services.WithTxManager(primaryTx{}, func(database.DBTX) repository.UserRepository { return primary }))
+	userHandler := NewUserHandler(userService)
+
+	serve := func(handler http.HandlerFunc, method, target, body string) models.User {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != http.StatusOK && rr.Code != http.StatusCreated {
+			t.Fatalf("%s %s returned status %d: %s", method, target, rr.Code, rr.Body.String())
+		}
+		var user models.User
+		if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+			t.Fatalf("Failed to decode user %q: %v", rr.Body.String(), err)
+		}
+		return user
+	}
+
+	t.Run("create answers with the user as stored", func(t *testing.T) {
+		created := serve(userHandler.CreateUser, "POST", "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`)
+		if created.ID != 5 || created.Email != "ada@example.com" {
+			t.Errorf("Expected user 5 with the new email, got %+v", created)
+		}
+	})
+
+	t.Run("update answers with the user as stored and caches nothing older", func(t *testing.T) {
+		updated := serve(userHandler.UpdateUser, "PUT", "/user?id=1", `{"name":"John Updated","email":"john@example.com"}`)
+		if updated.Name != "John Updated" {
+			t.Errorf("Expected the updated name, got %+v", updated)
+		}
+
+		// Once the replica catches up, lookups see the update rather than a cached copy of the old user
+		if err := replica.Update(context.Background(), models.User{ID: 1, Name: "John Updated", Email: "john@example.com"}); err != nil {
+			t.Fatalf("Failed to catch the replica up: %v", err)
+		}
+		user, err := userService.GetUser(context.Background(), 1)
+		if err != nil || user.Name != "John Updated" {
+			t.Errorf("Expected the updated user, got %+v, %v", user, err)
+		}
+	})
+
+	t.Run("patch answers with the user as stored and caches nothing older", func(t *testing.T) {
+		patched := serve(userHandler.PatchUser, "PATCH", "/user?id=3", `{"display_name":"Bobby"}`)
+		if patched.DisplayName != "Bobby" || patched.Name != "Bob Johnson" {
+			t.Errorf("Expected only the display name patched, got %+v", patched)
+		}
+
+		// Once the replica catches up, lookups see the patch rather than a cached copy of the old user
+		if err := replica.Update(context.Background(), patched); err != nil {
+			t.Fatalf("Failed to catch the replica up: %v", err)
+		}
+		user, err := userService.GetUser(context.Background(), 3)
+		if err != nil || user.DisplayName != "Bobby" {
+			t.Errorf("Expected the patched user, got %+v, %v", user, err)
+		}
+	})
+
+	t.Run("disable answers with the user as stored", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/users/4/disable", nil)
+		req.SetPathValue("id", "4")
+		rr := httptest.NewRecorder()
+		userHandler.DisableUser(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		var disabled models.User
+		if err := json.Unmarshal(rr.Body.Bytes(), &disabled); err != nil {
+			t.Fatalf("Failed to decode user %q: %v", rr.Body.String(), err)
+		}
+		if disabled.Status != models.StatusDisabled {
+			t.Errorf("Expected user 4 disabled, got %+v", disabled)
+		}
+	})
+
+	t.Run("restore answers with the user as stored", func(t *testing.T) {
+		// The replica has seen the delete but not yet the restore
+		if err := userService.DeleteUser(context.Background(), 2); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if err := replica.Delete(context.Background(), 2); err != nil {
+			t.Fatalf("Failed to delete user on the replica: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/admin/users/2/restore", nil)
+		req.SetPathValue("id", "2")
+		rr := httptest.NewRecorder()
+		userHandler.RestoreUser(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+		}
+		var restored models.User
+		if err := json.Unmarshal(rr.Body.Bytes(), &restored); err != nil {
+			t.Fatalf("Failed to decode user %q: %v", rr.Body.String(), err)
+		}
+		if restored.ID != 2 || restored.DeletedAt != nil {
+			t.Errorf("Expected user 2 restored, got %+v", restored)
+		}
+	})
+}
+
+func TestUserProfileFields(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	repo := repository.NewInMemoryRepository(models.User{ID: 1, Name: "John Doe", Email: "john@example.com"})
+	h := NewUserHandler(services.NewUserService(repo, metrics.New(reg, reg)))
+
+	write := func(handler http.HandlerFunc, method, target, body string) models.User {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if rr.Code != http.StatusOK && rr.Code != http.StatusCreated {
+			t.Fatalf("%s %s returned %d: %s", method, target, rr.Code, rr.Body.String())
+		}
+		var user models.User
+		if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+			t.Fatalf("Failed to decode user %q: %v", rr.Body.String(), err)
+		}
+		return user
+	}
+
+	created := write(h.CreateUser, "POST", "/users", `{"name":"Jane Smith","email":"jane@example.com","avatar_url":" https://example.com/jane.png ","display_name":"Jane"}`)
+	if created.AvatarURL != "https://example.com/jane.png" || created.DisplayName != "Jane" {
+		t.Errorf("Expected the created user's profile fields, got %+v", created)
+	}
+
+	updated := write(h.UpdateUser, "PUT", "/user?id=1", `{"name":"John Doe","email":"john@example.com","display_name":"Johnny"}`)
+	if updated.AvatarURL != "" || updated.DisplayName != "Johnny" {
+		t.Errorf("Expected the updated user's profile fields, got %+v", updated)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+		wantName   string
+		wantEmail  string
+	}{
+		{"only the email", "/user?id=1", `{"email":" john.new@example.com "}`, http.StatusOK, "John Doe", "john.new@example.com"},
+		{"only the name", "/user?id=1", `{"name":"John Updated"}`, http.StatusOK, "John Updated", "john@example.com"},
+		{"both fields", "/user?id=1", `{"name":"Johnny","email":"johnny@example.com"}`, http.StatusOK, "Johnny", "johnny@example.com"},
+		{"empty patch", "/user?id=1", `{}`, http.StatusBadRequest, "", ""},
+		{"null fields are absent", "/user?id=1", `{"name":null}`, http.StatusBadRequest, "", ""},
+		{"merged user is validated", "/user?id=1", `{"email":"not-an-email"}`, http.StatusUnprocessableEntity, "", ""},
+		{"email taken", "/user?id=1", `{"email":"jane@example.com"}`, http.StatusConflict, "", ""},
+		{"missing user", "/user?id=99", `{"name":"Nobody"}`, http.StatusNotFound, "", ""},
+		{"invalid id", "/user?id=abc", `{"name":"Nobody"}`, http.StatusBadRequest, "", ""},
+		{"malformed body", "/user?id=1", `{"name":`, http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := repository.NewInMemoryRepository(
+				models.User{ID: 1, Name: "John Doe", Email: "john@example.com"},
+				models.User{ID: 2, Name: "Jane Doe", Email: "jane@example.com"},
+			)
+			userHandler := NewUserHandler(services.NewUserService(repo, metricsCollector))
+
+			rr := httptest.NewRecorder()
+			userHandler.PatchUser(rr, httptest.NewRequest("PATCH", tt.target, strings.NewReader(tt.body)))
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", status, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var user models.User
+			if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+				t.Fatalf("Failed to decode user: %v", err)
+			}
+			if user.Name != tt.wantName || user.Email != tt.wantEmail {
+				t.Errorf("expected %s <%s>, got %s <%s>", tt.wantName, tt.wantEmail, user.Name, user.Email)
+			}
+		})
+	}
+}
+
+func TestCreateUserEmailTaken(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	// The pre-check answers taken, or with checkErr, and Postgres rejects the insert
+	// with its unique violation, naming the constraint
+	newHandler := func(taken bool, checkErr error) (*UserHandler, *mocks.MockDBTX) {
+		dbMock := &mocks.MockDBTX{}
+		check := &mocks.MockRow{}
+		check.On("Scan", mock.Anything).Return(checkErr).Run(func(args mock.Arguments) {
+			*args.Get(0).([]interface{})[0].(*bool) = taken
+		})
+		dbMock.On("QueryRow", mock.Anything, queries.Default.EmailExists, "john@example.com").Return(check)
+		dbMock.On("Exec", mock.Anything, queries.Default.InsertUser, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(pgconn.CommandTag{}, &pgconn.PgError{
+			Severity:       "ERROR",
+			Code:           "23505",
+			Message:        `duplicate key value violates unique constraint "users_email_key"`,
+			Detail:         "Key (email)=(john@example.com) already exists.",
+			TableName:      "users",
+			ConstraintName: "users_email_key",
+		})
+		owner := &mocks.MockRow{}
+		owner.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).([]interface{})[0].(*int) = 7
+		})
+		dbMock.On("QueryRow", mock.Anything, queries.Default.EmailOwner, "john@example.com").Return(owner)
+		return NewUserHandler(services.NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)), dbMock
+	}
+	create := func(h *UserHandler, caller *reqctx.Caller) (*httptest.ResponseRecorder, httputil.ErrorBody) {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"Other John","email":" john@example.com "}`))
+		if caller != nil {
+			req = req.WithContext(reqctx.WithCaller(req.Context(), *caller))
+		}
+		rr := httptest.NewRecorder()
+		h.CreateUser(rr, req)
+
+		var response struct {
+			Error httputil.ErrorBody `json:"error"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to decode error body %q: %v", rr.Body.String(), err)
+		}
+		return rr, response.Error
+	}
+
+	t.Run("turns away a taken email before inserting", func(t *testing.T) {
+		h, dbMock := newHandler(true, nil)
+		rr, body := create(h, nil)
+
+		if rr.Code != http.StatusConflict || body.Code != "EMAIL_ALREADY_EXISTS" {
+			t.Errorf("expected a 409 EMAIL_ALREADY_EXISTS, got %d %+v", rr.Code, body)
+		}
+		dbMock.AssertNotCalled(t, "Exec", mock.Anything, queries.Default.InsertUser, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("falls back to the constraint when the check fails", func(t *testing.T) {
+		h, dbMock := newHandler(false, errors.New("connection reset"))
+		rr, body := create(h, nil)
+
+		if rr.Code != http.StatusConflict || body.Code != "EMAIL_ALREADY_EXISTS" {
+			t.Errorf("expected a 409 EMAIL_ALREADY_EXISTS, got %d %+v", rr.Code, body)
+		}
+		dbMock.AssertCalled(t, "Exec", mock.Anything, queries.Default.InsertUser, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	// The email is free when checked but taken by the time of the insert
+	t.Run("answers 409 without SQL details", func(t *testing.T) {
+		h, dbMock := newHandler(false, nil)
+		rr, body := create(h, &reqctx.Caller{Subject: "alice", Roles: []string{"user"}})
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+		}
+		if body.Code != "EMAIL_ALREADY_EXISTS" || body.Message != "email already exists" {
+			t.Errorf("expected EMAIL_ALREADY_EXISTS with a plain message, got %+v", body)
+		}
+		if body.Details != nil {
+			t.Errorf("expected no existing user for a non-admin caller, got %v", body.Details)
+		}
+		for _, leak := range []string{"users_email_key", "23505", "Key (email)", "duplicate key", "SQLSTATE"} {
+			if strings.Contains(rr.Body.String(), leak) {
+				t.Errorf("expected the body not to mention %q, got %s", leak, rr.Body.String())
+			}
+		}
+		dbMock.AssertNotCalled(t, "QueryRow", mock.Anything, queries.Default.EmailOwner, mock.Anything)
+	})
+
+	t.Run("tells admins who has the email", func(t *testing.T) {
+		h, dbMock := newHandler(false, nil)
+		rr, body := create(h, &reqctx.Caller{Subject: middleware.AdminActor, Roles: []string{reqctx.AdminRole}})
+
+		if rr.Code != http.StatusConflict || body.Code != "EMAIL_ALREADY_EXISTS" {
+			t.Errorf("expected a 409 EMAIL_ALREADY_EXISTS, got %d %+v", rr.Code, body)
+		}
+		if want := map[string]interface{}{"existing_user_id": 7.0}; !reflect.DeepEqual(body.Details, want) {
+			t.Errorf("expected details %v, got %v", want, body.Details)
+		}
+		dbMock.AssertExpectations(t)
+	})
+
+	// The email belongs to a deleted user and differs from the new one in case
+	t.Run("tells admins who has the email in another case, deleted or not", func(t *testing.T) {
+		deleted := time.Now()
+		repo := repository.NewInMemoryRepository(models.User{ID: 3, Name: "John Doe", Email: "John@Example.COM", DeletedAt: &deleted})
+		h := NewUserHandler(services.NewUserService(repo, metricsCollector))
+		rr, body := create(h, &reqctx.Caller{Subject: middleware.AdminActor, Roles: []string{reqctx.AdminRole}})
+
+		if rr.Code != http.StatusConflict || body.Code != "EMAIL_ALREADY_EXISTS" {
+			t.Errorf("expected a 409 EMAIL_ALREADY_EXISTS, got %d %+v", rr.Code, body)
+		}
+		if want := map[string]interface{}{"existing_user_id": 3.0}; !reflect.DeepEqual(body.Details, want) {
+			t.Errorf("expected details %v, got %v", want, body.Details)
+		}
+	})
+}
+
+// errorMessage returns the message of the JSON error envelope rr was answered with
+func errorMessage(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode error body %q: %v", rr.Body.String(), err)
+	}
+	return response.Error.Message
 }
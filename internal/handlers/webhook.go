@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"user-service/internal/httputil"
+	"user-service/internal/logging"
+	"user-service/internal/models"
+	"user-service/internal/reqctx"
+	"user-service/internal/services"
+	"user-service/internal/webhooks"
+)
+
+// Page sizes for GET /admin/webhooks/{id}/deliveries
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// maxWebhookRequestBytes bounds a webhook create or update body
+const maxWebhookRequestBytes = 16 << 10
+
+// webhookRequest is the body accepted when creating or updating a webhook
+type webhookRequest struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+	// Enabled is only read on update, where it defaults to true
+	Enabled *bool `json:"enabled"`
+}
+
+// decodeWebhookRequest reads a create or update body, writing the error response itself when it cannot
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (webhookRequest, bool) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	var body webhookRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookRequestBytes)).Decode(&body)
+	if err == nil {
+		return body, true
+	}
+
+	logging.FromContext(r.Context()).Warn("Invalid webhook body", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httputil.Error(r.Context(), w, "request body too large", http.StatusRequestEntityTooLarge)
+	} else {
+		httputil.Error(r.Context(), w, "invalid request body", http.StatusBadRequest)
+	}
+	return webhookRequest{}, false
+}
+
+// parseWebhookID reads the {id} path value, writing a 400 response when it is not a positive integer
+func parseWebhookID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id < 1 {
+		logging.FromContext(r.Context()).Warn("Invalid webhook id", "id", idStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		httputil.Error(r.Context(), w, "id parameter is invalid", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeWebhookError maps an error from a webhook operation to a response
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	var validationErrs models.ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		logging.FromContext(r.Context()).Warn("Webhook failed validation", "error", err, "remote_addr", r.RemoteAddr, "request_id", requestID)
+		writeValidationErrors(w, r, validationErrs)
+	case errors.Is(err, services.ErrWebhooksDisabled), errors.Is(err, webhooks.ErrNotFound):
+		httputil.Error(r.Context(), w, err.Error(), http.StatusNotFound)
+	default:
+		logging.FromContext(r.Context()).Error("Webhook operation failed", "error", err, "request_id", requestID)
+		httputil.Error(r.Context(), w, "failed to process webhook", http.StatusInternalServerError)
+	}
+}
+
+// AdminCreateWebhook handles POST /admin/webhooks requests
+func (h *UserHandler) AdminCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	body, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := h.userService.CreateWebhook(r.Context(), webhooks.Webhook{URL: body.URL, Secret: body.Secret, EventTypes: body.EventTypes})
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusCreated, created); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode webhook", "error", err, "id", created.ID, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully created webhook", "id", created.ID, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// AdminListWebhooks handles GET /admin/webhooks requests
+func (h *UserHandler) AdminListWebhooks(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	list, err := h.userService.ListWebhooks(r.Context())
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []webhooks.Webhook{}
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, map[string]interface{}{"webhooks": list}); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode webhooks", "error", err, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully listed webhooks", "count", len(list), "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// AdminGetWebhook handles GET /admin/webhooks/{id} requests
+func (h *UserHandler) AdminGetWebhook(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	id, ok := parseWebhookID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, err := h.userService.GetWebhook(r.Context(), id)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, webhook); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode webhook", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned webhook", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// AdminUpdateWebhook handles PUT /admin/webhooks/{id} requests. An omitted secret
+// keeps the current one, and an omitted enabled means true.
+func (h *UserHandler) AdminUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	id, ok := parseWebhookID(w, r)
+	if !ok {
+		return
+	}
+	body, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	enabled := body.Enabled == nil || *body.Enabled
+	updated, err := h.userService.UpdateWebhook(r.Context(), webhooks.Webhook{ID: id, URL: body.URL, Secret: body.Secret, EventTypes: body.EventTypes, Enabled: enabled})
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, updated); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode webhook", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully updated webhook", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// AdminDeleteWebhook handles DELETE /admin/webhooks/{id} requests
+func (h *UserHandler) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+
+	id, ok := parseWebhookID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userService.DeleteWebhook(r.Context(), id); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	logging.FromContext(r.Context()).Info("Successfully deleted webhook", "id", id, "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
+
+// AdminWebhookDeliveries handles GET /admin/webhooks/{id}/deliveries requests, newest
+// first. It takes a ?limit= page size and a ?before= cursor; a full page carries
+// next_before, the cursor for the following page.
+func (h *UserHandler) AdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+	query := r.URL.Query()
+
+	id, ok := parseWebhookID(w, r)
+	if !ok {
+		return
+	}
+
+	filter := webhooks.DeliveryFilter{Limit: defaultDeliveriesLimit}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			logging.FromContext(r.Context()).Warn("Invalid limit parameter", "limit", limitStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, "limit parameter must be between 1 and "+strconv.Itoa(maxDeliveriesLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		before, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || before < 1 {
+			logging.FromContext(r.Context()).Warn("Invalid before parameter", "before", beforeStr, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			httputil.Error(r.Context(), w, "before parameter is invalid", http.StatusBadRequest)
+			return
+		}
+		filter.Before = before
+	}
+
+	deliveries, err := h.userService.WebhookDeliveries(r.Context(), id, filter)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []webhooks.Delivery{}
+	}
+
+	response := map[string]interface{}{"deliveries": deliveries}
+	if len(deliveries) == filter.Limit {
+		response["next_before"] = deliveries[len(deliveries)-1].ID
+	}
+
+	if err := writeJSON(w, r, http.StatusOK, response); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to encode webhook deliveries", "error", err, "id", id, "request_id", requestID)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Successfully returned webhook deliveries", "id", id, "count", len(deliveries), "remote_addr", r.RemoteAddr, "request_id", requestID)
+}
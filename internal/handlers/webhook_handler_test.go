@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/repository"
+	"user-service/internal/services"
+	"user-service/internal/webhooks"
+)
+
+func TestWebhookHandlers(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	// newHandler returns a handler whose store holds webhook 1 with one delivery
+	newHandler := func() *UserHandler {
+		store := webhooks.NewMemoryStore()
+		created, err := store.Create(context.Background(), webhooks.Webhook{URL: "https://partner.example.com/hook", Secret: "s3cret", Enabled: true})
+		if err != nil {
+			t.Fatalf("Failed to create webhook: %v", err)
+		}
+		if err := store.AddDelivery(context.Background(), created.ID, events.Event{Type: events.TypeUserCreated}); err != nil {
+			t.Fatalf("Failed to add delivery: %v", err)
+		}
+		userService := services.NewUserService(repository.NewInMemoryRepository(), metricsCollector, services.WithWebhooks(store))
+		return NewUserHandler(userService)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		id         string
+		body       string
+		handler    func(*UserHandler) http.HandlerFunc
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "create webhook",
+			method:     "POST",
+			target:     "/admin/webhooks",
+			body:       `{"url":"https://partner.example.com/users","secret":"abc","event_types":["user.created"]}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminCreateWebhook },
+			wantStatus: http.StatusCreated,
+			wantBody:   `"event_types":["user.created"],"enabled":true`,
+		},
+		{
+			name:       "create webhook reports every invalid field",
+			method:     "POST",
+			target:     "/admin/webhooks",
+			body:       `{"url":"ftp://partner.example.com","event_types":["user.banned"]}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminCreateWebhook },
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `"details":[{"field":"url","rule":"url_format"`,
+		},
+		{
+			name:       "create webhook malformed body",
+			method:     "POST",
+			target:     "/admin/webhooks",
+			body:       `{"url":`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminCreateWebhook },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "list webhooks",
+			method:     "GET",
+			target:     "/admin/webhooks",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminListWebhooks },
+			wantStatus: http.StatusOK,
+			wantBody:   `"url":"https://partner.example.com/hook"`,
+		},
+		{
+			name:       "get webhook",
+			method:     "GET",
+			target:     "/admin/webhooks/1",
+			id:         "1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminGetWebhook },
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":1`,
+		},
+		{
+			name:       "get missing webhook",
+			method:     "GET",
+			target:     "/admin/webhooks/9",
+			id:         "9",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminGetWebhook },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "get webhook invalid id",
+			method:     "GET",
+			target:     "/admin/webhooks/abc",
+			id:         "abc",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminGetWebhook },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "update webhook keeps secret",
+			method:     "PUT",
+			target:     "/admin/webhooks/1",
+			id:         "1",
+			body:       `{"url":"https://partner.example.com/v2","enabled":false}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminUpdateWebhook },
+			wantStatus: http.StatusOK,
+			wantBody:   `"url":"https://partner.example.com/v2","event_types":[],"enabled":false`,
+		},
+		{
+			name:       "update missing webhook",
+			method:     "PUT",
+			target:     "/admin/webhooks/9",
+			id:         "9",
+			body:       `{"url":"https://partner.example.com/v2"}`,
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminUpdateWebhook },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "delete webhook",
+			method:     "DELETE",
+			target:     "/admin/webhooks/1",
+			id:         "1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminDeleteWebhook },
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "delete missing webhook",
+			method:     "DELETE",
+			target:     "/admin/webhooks/9",
+			id:         "9",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminDeleteWebhook },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "list deliveries",
+			method:     "GET",
+			target:     "/admin/webhooks/1/deliveries?limit=1",
+			id:         "1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminWebhookDeliveries },
+			wantStatus: http.StatusOK,
+			wantBody:   `"status":"pending"`,
+		},
+		{
+			name:       "list deliveries of missing webhook",
+			method:     "GET",
+			target:     "/admin/webhooks/9/deliveries",
+			id:         "9",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminWebhookDeliveries },
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "list deliveries invalid limit",
+			method:     "GET",
+			target:     "/admin/webhooks/1/deliveries?limit=500",
+			id:         "1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminWebhookDeliveries },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "list deliveries invalid before",
+			method:     "GET",
+			target:     "/admin/webhooks/1/deliveries?before=0",
+			id:         "1",
+			handler:    func(h *UserHandler) http.HandlerFunc { return h.AdminWebhookDeliveries },
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			rr := httptest.NewRecorder()
+			tt.handler(newHandler()).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v (%s)", status, tt.wantStatus, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rr.Body.String(), tt.wantBody)
+			}
+			// The secret is write-only
+			if strings.Contains(rr.Body.String(), "s3cret") {
+				t.Errorf("body = %s, must not contain the secret", rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("full deliveries page carries cursor", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/webhooks/1/deliveries?limit=1", nil)
+		req.SetPathValue("id", "1")
+		rr := httptest.NewRecorder()
+		newHandler().AdminWebhookDeliveries(rr, req)
+
+		var response struct {
+			Deliveries []webhooks.Delivery `json:"deliveries"`
+			NextBefore int64               `json:"next_before"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode deliveries: %v", err)
+		}
+		if len(response.Deliveries) != 1 || response.NextBefore != response.Deliveries[0].ID {
+			t.Errorf("deliveries = %+v, next_before = %d", response.Deliveries, response.NextBefore)
+		}
+	})
+
+	t.Run("webhooks disabled", func(t *testing.T) {
+		handler := NewUserHandler(services.NewUserService(repository.NewInMemoryRepository(), metricsCollector))
+		rr := httptest.NewRecorder()
+		handler.AdminListWebhooks(rr, httptest.NewRequest("GET", "/admin/webhooks", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rr.Code, http.StatusNotFound)
+		}
+	})
+}
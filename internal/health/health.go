@@ -0,0 +1,194 @@
+// Package health probes the dependencies the service relies on, for /readyz, and
+// watches its background workers, for /livez.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a check registered without a timeout of its own
+const DefaultTimeout = 2 * time.Second
+
+// Statuses of a single check
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Statuses of the service as a whole
+const (
+	// StatusDegraded means an optional dependency is down; the service still takes traffic
+	StatusDegraded = "degraded"
+	// StatusDown means a required dependency is down
+	StatusDown = "down"
+)
+
+// Check probes one dependency
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Optional marks a dependency the service can run without, such as a cache,
+	// so its failure only degrades readiness
+	Optional bool
+	// Timeout bounds the check; DefaultTimeout when zero
+	Timeout time.Duration
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every registered check. Status is StatusOK when all
+// pass, StatusDown when a required check fails and StatusDegraded otherwise.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// CheckRegistry holds the checks components register at startup. It is safe for concurrent use.
+type CheckRegistry struct {
+	mu     sync.Mutex
+	checks []Check
+}
+
+// NewCheckRegistry creates an empty registry
+func NewCheckRegistry() *CheckRegistry {
+	return &CheckRegistry{}
+}
+
+// Register adds checks, replacing any registered under the same name
+func (r *CheckRegistry) Register(checks ...Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, check := range checks {
+		r.checks = append(deleteCheck(r.checks, check.Name), check)
+	}
+}
+
+// deleteCheck drops the check named name from checks
+func deleteCheck(checks []Check, name string) []Check {
+	kept := checks[:0]
+	for _, check := range checks {
+		if check.Name != name {
+			kept = append(kept, check)
+		}
+	}
+	return kept
+}
+
+// Run runs every check concurrently, each within its own timeout, and reports
+// their outcomes. A check that ignores its context is abandoned once it times out.
+func (r *CheckRegistry) Run(ctx context.Context) Report {
+	r.mu.Lock()
+	checks := append([]Check(nil), r.checks...)
+	r.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Go(func() {
+			results[i] = run(ctx, check)
+		})
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status == StatusOK {
+			continue
+		}
+		if !check.Optional {
+			report.Status = StatusDown
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run runs one check within its timeout
+func run(ctx context.Context, check Check) Result {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if err = ctx.Err(); errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+
+	result := Result{Status: StatusOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status, result.Error = StatusFailed, err.Error()
+	}
+	return result
+}
+
+// Heartbeats watches the background workers for /livez. Each worker beats as its
+// loop goes round, and one that has not beaten within the timeout is stale, as a
+// worker that panicked or hangs would be. It is safe for concurrent use.
+type Heartbeats struct {
+	timeout time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// NewHeartbeats creates a watcher finding workers stale once they have not beaten
+// for timeout. With a timeout of 0 no worker is ever stale.
+func NewHeartbeats(timeout time.Duration) *Heartbeats {
+	return &Heartbeats{timeout: timeout, now: time.Now, last: make(map[string]time.Time)}
+}
+
+// Register watches the worker name, as if it had just beaten, and returns the
+// function it beats with. Registering a name again restarts its watch.
+func (h *Heartbeats) Register(name string) (beat func()) {
+	beat = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.last[name] = h.now()
+	}
+	beat()
+	return beat
+}
+
+// Stale returns the names of the workers that have not beaten within the
+// timeout, sorted
+func (h *Heartbeats) Stale() []string {
+	if h.timeout <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	var stale []string
+	for name, last := range h.last {
+		if now.Sub(last) > h.timeout {
+			stale = append(stale, name)
+		}
+	}
+	slices.Sort(stale)
+	return stale
+}
@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkFailing creates a check named name that fails with err, if set
+func checkFailing(name string, err error, optional bool) Check {
+	return Check{Name: name, Optional: optional, Run: func(context.Context) error { return err }}
+}
+
+func TestCheckRegistryRun(t *testing.T) {
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		storageErr error
+		cacheErr   error
+		wantStatus string
+	}{
+		{"all checks pass", nil, nil, StatusOK},
+		{"optional check fails", nil, failure, StatusDegraded},
+		{"required check fails", failure, nil, StatusDown},
+		{"required and optional checks fail", failure, failure, StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := NewCheckRegistry()
+			checks.Register(checkFailing("storage", tt.storageErr, false), checkFailing("cache", tt.cacheErr, true))
+
+			report := checks.Run(context.Background())
+
+			assert.Equal(t, tt.wantStatus, report.Status)
+			assert.Len(t, report.Checks, 2)
+			for name, err := range map[string]error{"storage": tt.storageErr, "cache": tt.cacheErr} {
+				if err != nil {
+					assert.Equal(t, StatusFailed, report.Checks[name].Status)
+					assert.Equal(t, err.Error(), report.Checks[name].Error)
+				} else {
+					assert.Equal(t, StatusOK, report.Checks[name].Status)
+					assert.Empty(t, report.Checks[name].Error)
+				}
+			}
+		})
+	}
+
+	t.Run("runs checks concurrently", func(t *testing.T) {
+		slow := func(context.Context) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}
+		checks := NewCheckRegistry()
+		checks.Register(Check{Name: "storage", Run: slow}, Check{Name: "cache", Run: slow})
+
+		start := time.Now()
+		report := checks.Run(context.Background())
+
+		assert.Equal(t, StatusOK, report.Status)
+		assert.Less(t, time.Since(start), 190*time.Millisecond)
+		assert.GreaterOrEqual(t, report.Checks["storage"].LatencyMS, 100.0)
+	})
+
+	t.Run("abandons a check that outlives its timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		checks := NewCheckRegistry()
+		checks.Register(Check{Name: "events", Optional: true, Timeout: 20 * time.Millisecond, Run: func(context.Context) error {
+			// Ignores its context
+			<-release
+			return nil
+		}})
+
+		report := checks.Run(context.Background())
+
+		assert.Equal(t, StatusDegraded, report.Status)
+		assert.Equal(t, Result{Status: StatusFailed, LatencyMS: report.Checks["events"].LatencyMS, Error: "timed out after 20ms"}, report.Checks["events"])
+	})
+
+	t.Run("an empty registry is ok", func(t *testing.T) {
+		assert.Equal(t, Report{Status: StatusOK, Checks: map[string]Result{}}, NewCheckRegistry().Run(context.Background()))
+	})
+}
+
+func TestCheckRegistryRegister(t *testing.T) {
+	checks := NewCheckRegistry()
+	checks.Register(checkFailing("storage", errors.New("connection refused"), false), checkFailing("cache", nil, true))
+	checks.Register(checkFailing("storage", nil, false))
+
+	report := checks.Run(context.Background())
+
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Len(t, report.Checks, 2)
+}
+
+func TestHeartbeats(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	heartbeats := NewHeartbeats(time.Minute)
+	heartbeats.now = func() time.Time { return now }
+
+	uptime := heartbeats.Register("uptime")
+	heartbeats.Register("user gauges")
+	assert.Empty(t, heartbeats.Stale(), "workers start out alive")
+
+	now = now.Add(time.Minute)
+	uptime()
+	assert.Empty(t, heartbeats.Stale(), "a worker is alive up to the timeout")
+
+	// The gauges worker stops beating while uptime keeps going
+	now = now.Add(time.Second)
+	uptime()
+	assert.Equal(t, []string{"user gauges"}, heartbeats.Stale())
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, []string{"uptime", "user gauges"}, heartbeats.Stale())
+
+	t.Run("zero timeout", func(t *testing.T) {
+		heartbeats := NewHeartbeats(0)
+		heartbeats.now = func() time.Time { return now }
+		heartbeats.Register("uptime")
+		now = now.Add(24 * time.Hour)
+		assert.Empty(t, heartbeats.Stale())
+	})
+}
@@ -0,0 +1,135 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// Cases of the keys in JSON responses
+const (
+	// SnakeCase keeps the keys as the types declare them, such as "created_at"
+	SnakeCase = "snake"
+	// CamelCase rewrites them for clients that expect "createdAt"
+	CamelCase = "camel"
+)
+
+type fieldCaseKey struct{}
+
+// WithFieldCase returns a copy of ctx whose JSON responses have keys in fieldCase
+func WithFieldCase(ctx context.Context, fieldCase string) context.Context {
+	return context.WithValue(ctx, fieldCaseKey{}, fieldCase)
+}
+
+// FieldCase returns the case of the keys in JSON responses under ctx, SnakeCase unless set
+func FieldCase(ctx context.Context) string {
+	if fieldCase, ok := ctx.Value(fieldCaseKey{}).(string); ok {
+		return fieldCase
+	}
+	return SnakeCase
+}
+
+// InFieldCase returns v ready to be encoded with its keys in the field case of ctx:
+// v itself for SnakeCase, or v wrapped in a marshaller rewriting every key of its
+// JSON for CamelCase. The types keep their snake_case tags either way.
+func InFieldCase(ctx context.Context, v interface{}) interface{} {
+	if FieldCase(ctx) != CamelCase {
+		return v
+	}
+	return camelCased{v}
+}
+
+// camelCased marshals v with every object key rewritten to camelCase
+type camelCased struct {
+	v interface{}
+}
+
+func (c camelCased) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(c.v)
+	if err != nil {
+		return nil, err
+	}
+	return CamelCaseKeys(data)
+}
+
+// CamelCaseKeys rewrites every object key in the JSON document data from snake_case
+// to camelCase, keeping their order and leaving the values alone
+func CamelCaseKeys(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var out bytes.Buffer
+	if err := camelCaseValue(decoder, &out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// camelCaseValue copies the next value from decoder to out, rewriting the keys of its objects
+func camelCaseValue(decoder *json.Decoder, out *bytes.Buffer) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		// A string, number, bool or null
+		encoded, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		out.Write(encoded)
+		return nil
+	}
+
+	out.WriteRune(rune(delim))
+	for i := 0; decoder.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		if delim == '{' {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			encoded, err := json.Marshal(camelCase(key.(string)))
+			if err != nil {
+				return err
+			}
+			out.Write(encoded)
+			out.WriteByte(':')
+		}
+		if err := camelCaseValue(decoder, out); err != nil {
+			return err
+		}
+	}
+	closing, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	out.WriteRune(rune(closing.(json.Delim)))
+	return nil
+}
+
+// camelCase turns a snake_case key such as "skipped_duplicates" into "skippedDuplicates".
+// A leading underscore is kept.
+func camelCase(key string) string {
+	if !strings.Contains(key, "_") {
+		return key
+	}
+	var b strings.Builder
+	upper := false
+	for i, c := range key {
+		switch {
+		case c == '_' && i > 0:
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
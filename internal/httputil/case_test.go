@@ -0,0 +1,57 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCamelCaseKeys(t *testing.T) {
+	in := `{"id":1,"created_at":"2024-03-01T12:00:00Z","skipped_duplicates":2,"failed":[{"row_number":3,"error":"a_b"}],` +
+		`"_links":{"self_url":"/user?id=1&x=<y>"},"total":12.50,"deleted_at":null,"nested_list":[[{"inner_key":true}]]}`
+	// Strings are escaped for HTML, as by WriteJSON
+	want := `{"id":1,"createdAt":"2024-03-01T12:00:00Z","skippedDuplicates":2,"failed":[{"rowNumber":3,"error":"a_b"}],` +
+		`"_links":{"selfUrl":"/user?id=1\u0026x=\u003cy\u003e"},"total":12.50,"deletedAt":null,"nestedList":[[{"innerKey":true}]]}`
+
+	got, err := CamelCaseKeys([]byte(in))
+	if err != nil {
+		t.Fatalf("CamelCaseKeys() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("CamelCaseKeys() = %s, want %s", got, want)
+	}
+
+	if _, err := CamelCaseKeys([]byte(`{"id":`)); err == nil {
+		t.Error("expected an error for truncated JSON")
+	}
+}
+
+func TestInFieldCase(t *testing.T) {
+	user := struct {
+		ID        int       `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+	}{ID: 1, CreatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"snake_case by default", context.Background(), `{"id":1,"created_at":"2024-03-01T12:00:00Z"}`},
+		{"snake_case when asked", WithFieldCase(context.Background(), SnakeCase), `{"id":1,"created_at":"2024-03-01T12:00:00Z"}`},
+		{"camelCase when asked", WithFieldCase(context.Background(), CamelCase), `{"id":1,"createdAt":"2024-03-01T12:00:00Z"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(InFieldCase(tt.ctx, user))
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("InFieldCase() encoded to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
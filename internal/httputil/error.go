@@ -0,0 +1,42 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"user-service/internal/reqctx"
+)
+
+// RequestIDHeader carries the ID of a request, on its response and on the calls
+// made to other systems on its behalf
+const RequestIDHeader = "X-Request-ID"
+
+// ErrorBody is what every failed request is answered with, inside an envelope:
+// {"error":{"code":"NOT_FOUND","message":"user not found","request_id":"..."}}
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details lists what failed in more depth, such as every field of a validation error
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// WriteError writes body in the JSON error envelope with the given status, with
+// keys in the field case of ctx. A code left empty is derived from status, such as
+// "NOT_FOUND" for 404, and a request ID left empty is taken from ctx. The returned
+// error is the write failure, for the caller to log.
+func WriteError(ctx context.Context, w http.ResponseWriter, status int, body ErrorBody) error {
+	if body.Code == "" {
+		body.Code = strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	if body.RequestID == "" {
+		body.RequestID = reqctx.RequestIDFromContext(ctx)
+	}
+	return WriteJSON(ctx, w, status, InFieldCase(ctx, map[string]ErrorBody{"error": body}))
+}
+
+// Error replies with message in the JSON error envelope, as http.Error does in plain text
+func Error(ctx context.Context, w http.ResponseWriter, message string, status int) {
+	_ = WriteError(ctx, w, status, ErrorBody{Message: message})
+}
@@ -0,0 +1,53 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-service/internal/reqctx"
+)
+
+func TestWriteError(t *testing.T) {
+	ctx := reqctx.WithRequestID(context.Background(), "req-1")
+
+	t.Run("derives the code and request ID", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		Error(ctx, rr, "user not found", http.StatusNotFound)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rr.Code, http.StatusNotFound)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+		want := `{"error":{"code":"NOT_FOUND","message":"user not found","request_id":"req-1"}}` + "\n"
+		if got := rr.Body.String(); got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("keeps a given code and details", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		body := ErrorBody{Code: "VALIDATION", Message: "name is required", Details: []string{"name"}}
+		if err := WriteError(ctx, rr, http.StatusUnprocessableEntity, body); err != nil {
+			t.Fatalf("WriteError() error = %v", err)
+		}
+
+		want := `{"error":{"code":"VALIDATION","message":"name is required","details":["name"],"request_id":"req-1"}}` + "\n"
+		if got := rr.Body.String(); got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("leaves out the request ID outside a request", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		Error(context.Background(), rr, "too many concurrent requests", http.StatusServiceUnavailable)
+
+		want := `{"error":{"code":"SERVICE_UNAVAILABLE","message":"too many concurrent requests"}}` + "\n"
+		if got := rr.Body.String(); got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+}
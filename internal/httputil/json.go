@@ -0,0 +1,162 @@
+// Package httputil holds helpers shared by HTTP handlers.
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"syscall"
+)
+
+// EncodeFailedMessage is the message of the 500 sent in place of a response that
+// could not be encoded
+const EncodeFailedMessage = "failed to encode response"
+
+// WriteJSON writes v as a compact JSON response with the given status.
+//
+// The body is encoded before anything is written, so an encoding failure produces
+// a clean 500 in the error envelope, with the request ID of ctx, rather than a
+// truncated body behind a success status. The returned error is the encoding or
+// write failure, for the caller to log.
+func WriteJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) error {
+	return writeJSON(ctx, w, status, v, "")
+}
+
+// WriteIndentedJSON is WriteJSON with two-space indentation, for human readers
+func WriteIndentedJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) error {
+	return writeJSON(ctx, w, status, v, "  ")
+}
+
+// StreamIndentedJSON is WriteIndentedJSON for list responses, written without
+// holding the whole body encoded. envelope is the JSON object around the list,
+// such as {"total": 2, "users": [...]}, and each of its arrays is written an
+// element at a time. Its keys, and those of its values, are in the field case of ctx.
+//
+// Every element is encoded once before anything is written, so an encoding
+// failure still produces a clean 500 in the error envelope; once the headers are
+// sent, only the write can fail.
+func StreamIndentedJSON(ctx context.Context, w http.ResponseWriter, status int, envelope map[string]interface{}) error {
+	keys := make([]string, 0, len(envelope))
+	for key := range envelope {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err := forEachElement(envelope[key], func(_ int, v interface{}) error {
+			_, err := json.Marshal(InFieldCase(ctx, v))
+			return err
+		})
+		if err != nil {
+			_ = WriteError(ctx, w, http.StatusInternalServerError, ErrorBody{Message: EncodeFailedMessage})
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// Writing stops at the first error, which is returned at the end
+	var err error
+	write := func(s string) {
+		if err == nil {
+			_, err = io.WriteString(w, s)
+		}
+	}
+	// writeValue writes v indented to sit at the nesting level of its indent
+	writeValue := func(indent string, v interface{}) {
+		data, encodeErr := json.MarshalIndent(InFieldCase(ctx, v), indent, "  ")
+		if err == nil {
+			err = encodeErr
+		}
+		write(string(data))
+	}
+
+	write("{")
+	for i, key := range keys {
+		if i > 0 {
+			write(",")
+		}
+		name := key
+		if FieldCase(ctx) == CamelCase {
+			name = camelCase(key)
+		}
+		quoted, _ := json.Marshal(name)
+		write("\n  " + string(quoted) + ": ")
+
+		value := envelope[key]
+		if list := reflect.ValueOf(value); !isArray(list) || list.Len() == 0 {
+			writeValue("  ", value)
+			continue
+		}
+		write("[")
+		_ = forEachElement(value, func(i int, v interface{}) error {
+			if i > 0 {
+				write(",")
+			}
+			write("\n    ")
+			writeValue("    ", v)
+			return err
+		})
+		write("\n  ]")
+	}
+	if len(keys) > 0 {
+		write("\n")
+	}
+	write("}\n")
+	return err
+}
+
+// forEachElement calls fn with each element of v and its index when v encodes as
+// a JSON array, and with v itself otherwise, stopping at the first error fn returns
+func forEachElement(v interface{}, fn func(int, interface{}) error) error {
+	list := reflect.ValueOf(v)
+	if !isArray(list) {
+		return fn(0, v)
+	}
+	for i := 0; i < list.Len(); i++ {
+		if err := fn(i, list.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isArray reports whether v encodes as a JSON array: it is an array, or a slice
+// other than nil and []byte, which encode as null and a base64 string
+func isArray(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array:
+		return true
+	case reflect.Slice:
+		return !v.IsNil() && v.Type().Elem().Kind() != reflect.Uint8
+	}
+	return false
+}
+
+// ClientGone reports whether err writing a response means the client went away,
+// by closing or resetting the connection or cancelling the request, rather than
+// that the response could not be produced
+func ClientGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, context.Canceled)
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}, indent string) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(v); err != nil {
+		_ = WriteError(ctx, w, http.StatusInternalServerError, ErrorBody{Message: EncodeFailedMessage})
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,184 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"user-service/internal/reqctx"
+)
+
+// unmarshalable fails to encode, after the fields before it have been encoded
+type unmarshalable struct{}
+
+func (unmarshalable) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("cannot marshal")
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Run("writes status and body", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		if err := WriteJSON(context.Background(), rr, http.StatusCreated, map[string]int{"id": 1}); err != nil {
+			t.Fatalf("WriteJSON() error = %v", err)
+		}
+
+		if rr.Code != http.StatusCreated {
+			t.Errorf("status = %d, want %d", rr.Code, http.StatusCreated)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+		if got, want := rr.Body.String(), "{\"id\":1}\n"; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("encode failure returns a clean 500", func(t *testing.T) {
+		response := struct {
+			Name  string        `json:"name"`
+			Value unmarshalable `json:"value"`
+		}{Name: "partial"}
+		ctx := reqctx.WithRequestID(context.Background(), "req-1")
+		want := `{"error":{"code":"INTERNAL_SERVER_ERROR","message":"failed to encode response","request_id":"req-1"}}` + "\n"
+
+		for name, write := range map[string]func(context.Context, http.ResponseWriter, int, interface{}) error{
+			"compact":  WriteJSON,
+			"indented": WriteIndentedJSON,
+		} {
+			rr := httptest.NewRecorder()
+			if err := write(ctx, rr, http.StatusOK, response); err == nil {
+				t.Fatalf("%s: error = nil, want encode error", name)
+			}
+
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("%s: status = %d, want %d", name, rr.Code, http.StatusInternalServerError)
+			}
+			if got := rr.Body.String(); got != want {
+				t.Errorf("%s: body = %q, want only %q", name, got, want)
+			}
+		}
+	})
+
+	t.Run("indented", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		if err := WriteIndentedJSON(context.Background(), rr, http.StatusOK, map[string]int{"id": 1}); err != nil {
+			t.Fatalf("WriteIndentedJSON() error = %v", err)
+		}
+		if got, want := rr.Body.String(), "{\n  \"id\": 1\n}\n"; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+}
+
+// countingRecorder counts the writes of the body
+type countingRecorder struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *countingRecorder) Write(b []byte) (int, error) {
+	w.writes++
+	return w.ResponseRecorder.Write(b)
+}
+
+func (w *countingRecorder) WriteString(s string) (int, error) {
+	w.writes++
+	return w.ResponseRecorder.WriteString(s)
+}
+
+func TestStreamIndentedJSON(t *testing.T) {
+	type user struct {
+		ID        int    `json:"id"`
+		Email     string `json:"email"`
+		CreatedAt string `json:"created_at"`
+	}
+	users := []user{
+		{ID: 1, Email: "a<b>@example.com", CreatedAt: "2024-03-01T12:30:00Z"},
+		{ID: 2, Email: "c&d@example.com", CreatedAt: "2024-03-02T12:30:00Z"},
+	}
+	envelopes := map[string]map[string]interface{}{
+		"list":            {"users": users, "total": len(users)},
+		"empty list":      {"users": []user{}, "total": 0},
+		"nil list":        {"users": []user(nil)},
+		"array":           {"ids": [2]int{1, 2}},
+		"bytes":           {"raw_data": []byte("abc")},
+		"nested lists":    {"failed_rows": [][]int{{1}, {2, 3}}},
+		"object members":  {"last_user": users[0], "next_page": nil},
+		"empty envelope":  {},
+		"snake_case keys": {"skipped_duplicates": 1, "failed": []map[string]int{{"row_number": 3}}},
+	}
+
+	for _, fieldCase := range []string{SnakeCase, CamelCase} {
+		ctx := WithFieldCase(context.Background(), fieldCase)
+		for name, envelope := range envelopes {
+			want := httptest.NewRecorder()
+			if err := WriteIndentedJSON(ctx, want, http.StatusOK, InFieldCase(ctx, envelope)); err != nil {
+				t.Fatalf("%s, %s: WriteIndentedJSON() error = %v", fieldCase, name, err)
+			}
+			got := httptest.NewRecorder()
+			if err := StreamIndentedJSON(ctx, got, http.StatusOK, envelope); err != nil {
+				t.Fatalf("%s, %s: StreamIndentedJSON() error = %v", fieldCase, name, err)
+			}
+
+			if got.Body.String() != want.Body.String() {
+				t.Errorf("%s, %s: body = %q, want %q", fieldCase, name, got.Body.String(), want.Body.String())
+			}
+			if got.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("%s, %s: Content-Type = %q, want application/json", fieldCase, name, got.Header().Get("Content-Type"))
+			}
+		}
+	}
+
+	t.Run("writes an element at a time", func(t *testing.T) {
+		rr := &countingRecorder{ResponseRecorder: httptest.NewRecorder()}
+		if err := StreamIndentedJSON(context.Background(), rr, http.StatusOK, map[string]interface{}{"users": users}); err != nil {
+			t.Fatalf("StreamIndentedJSON() error = %v", err)
+		}
+		if rr.writes <= len(users) {
+			t.Errorf("writes = %d, want more than one per element", rr.writes)
+		}
+	})
+
+	t.Run("encode failure returns a clean 500", func(t *testing.T) {
+		ctx := reqctx.WithRequestID(context.Background(), "req-1")
+		rr := httptest.NewRecorder()
+		envelope := map[string]interface{}{"total": 2, "values": []interface{}{1, unmarshalable{}}}
+		if err := StreamIndentedJSON(ctx, rr, http.StatusOK, envelope); err == nil {
+			t.Fatal("error = nil, want encode error")
+		}
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want %d", rr.Code, http.StatusInternalServerError)
+		}
+		want := `{"error":{"code":"INTERNAL_SERVER_ERROR","message":"failed to encode response","request_id":"req-1"}}` + "\n"
+		if got := rr.Body.String(); got != want {
+			t.Errorf("body = %q, want only %q", got, want)
+		}
+	})
+}
+
+func TestClientGone(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"broken pipe", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"connection reset", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, true},
+		{"request cancelled", fmt.Errorf("export: %w", context.Canceled), true},
+		{"encoding failure", errors.New("json: unsupported value: +Inf"), false},
+		{"write timeout", os.ErrDeadlineExceeded, false},
+		{"no error", nil, false},
+	}
+	for _, tt := range tests {
+		if got := ClientGone(tt.err); got != tt.want {
+			t.Errorf("%s: ClientGone() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Package lifecycle stops the service's background components on shutdown.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// CloserFunc adapts a function to io.Closer
+type CloserFunc func() error
+
+// Close calls f
+func (f CloserFunc) Close() error {
+	return f()
+}
+
+// component is a registered closer with the name it is logged under
+type component struct {
+	name   string
+	closer io.Closer
+}
+
+// Coordinator closes the components registered with it when the service shuts down
+type Coordinator struct {
+	mu         sync.Mutex
+	components []component
+	closed     bool
+}
+
+// New creates a coordinator with no components
+func New() *Coordinator {
+	return &Coordinator{}
+}
+
+// Register adds closer to the components stopped on shutdown. A component
+// registered after shutdown is closed straight away.
+func (c *Coordinator) Register(name string, closer io.Closer) {
+	c.mu.Lock()
+	if !c.closed {
+		c.components = append(c.components, component{name: name, closer: closer})
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	if err := closer.Close(); err != nil {
+		slog.Warn("Failed to stop component", "component", name, "error", err)
+	}
+}
+
+// Go runs run in a goroutine and registers it under name. On shutdown its context
+// is cancelled and it is waited for.
+func (c *Coordinator) Go(name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	c.Register(name, CloserFunc(func() error {
+		cancel()
+		<-done
+		return nil
+	}))
+}
+
+// Shutdown closes every component once, the most recently registered first so
+// that none is stopped before a component that depends on it. A component still
+// closing when ctx is done is left to finish on its own and reported in the
+// returned error, along with any that failed to close. Later calls do nothing.
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	components := c.components
+	c.components, c.closed = nil, true
+	c.mu.Unlock()
+
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		comp := components[i]
+		done := make(chan error, 1)
+		go func() { done <- comp.closer.Close() }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to stop %s: %w", comp.name, err))
+				continue
+			}
+			slog.Info("Stopped component", "component", comp.name)
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w", comp.name, ctx.Err()))
+		}
+	}
+	return errors.Join(errs...)
+}
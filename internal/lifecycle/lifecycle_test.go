@@ -0,0 +1,105 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder counts how often each component is closed and in what order
+type recorder struct {
+	mu     sync.Mutex
+	closes map[string]int
+	order  []string
+}
+
+func (r *recorder) closer(name string, err error) CloserFunc {
+	return func() error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.closes[name]++
+		r.order = append(r.order, name)
+		return err
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	r := &recorder{closes: map[string]int{}}
+	c := New()
+	c.Register("metrics", r.closer("metrics", nil))
+	c.Register("dispatcher", r.closer("dispatcher", nil))
+	c.Register("worker", r.closer("worker", nil))
+
+	// Concurrent and repeated shutdowns still stop every component exactly once
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() { assert.NoError(t, c.Shutdown(context.Background())) })
+	}
+	wg.Wait()
+	assert.NoError(t, c.Shutdown(context.Background()))
+
+	assert.Equal(t, map[string]int{"metrics": 1, "dispatcher": 1, "worker": 1}, r.closes)
+	assert.Equal(t, []string{"worker", "dispatcher", "metrics"}, r.order)
+
+	// A component registered too late is closed straight away, and only then
+	c.Register("late", r.closer("late", nil))
+	assert.Equal(t, 1, r.closes["late"])
+	assert.NoError(t, c.Shutdown(context.Background()))
+	assert.Equal(t, 1, r.closes["late"])
+}
+
+func TestShutdownReportsFailures(t *testing.T) {
+	r := &recorder{closes: map[string]int{}}
+	c := New()
+	c.Register("first", r.closer("first", nil))
+	c.Register("broken", r.closer("broken", assert.AnError))
+
+	err := c.Shutdown(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "failed to stop broken")
+	// A failure does not keep the other components running
+	assert.Equal(t, 1, r.closes["first"])
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	r := &recorder{closes: map[string]int{}}
+	release := make(chan struct{})
+	defer close(release)
+
+	c := New()
+	c.Register("stuck", CloserFunc(func() error {
+		<-release
+		return nil
+	}))
+	c.Register("first", r.closer("first", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "failed to stop stuck")
+	assert.Equal(t, 1, r.closes["first"])
+}
+
+func TestGo(t *testing.T) {
+	c := New()
+	var mu sync.Mutex
+	var stops int
+	for range 2 {
+		c.Go("worker", func(ctx context.Context) {
+			<-ctx.Done()
+			mu.Lock()
+			stops++
+			mu.Unlock()
+		})
+	}
+
+	// Shutdown cancels each goroutine and waits for it to return
+	assert.NoError(t, c.Shutdown(context.Background()))
+	mu.Lock()
+	assert.Equal(t, 2, stops)
+	mu.Unlock()
+}
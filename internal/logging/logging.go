@@ -0,0 +1,70 @@
+// Package logging ties log records to the trace of the request they were logged
+// for and keeps personal data out of them.
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler adds the trace_id and span_id of the span active in a record's context,
+// if any, so that logs can be joined with the traces and metric exemplars of the
+// same request
+type Handler struct {
+	slog.Handler
+}
+
+// NewHandler wraps handler in a Handler
+func NewHandler(handler slog.Handler) *Handler {
+	return &Handler{Handler: handler}
+}
+
+// Handle adds the span's IDs to record and passes it on
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a Handler whose records also have attrs
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a Handler whose later attributes, the span's IDs included, are
+// nested in the group name
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{Handler: h.Handler.WithGroup(name)}
+}
+
+// FromContext returns the default logger bound to ctx, so that its records carry
+// the trace of the request ctx belongs to without the *Context logging methods.
+// Handlers and services log through it rather than the slog functions.
+func FromContext(ctx context.Context) *slog.Logger {
+	return slog.New(&boundHandler{handler: slog.Default().Handler(), ctx: ctx})
+}
+
+// boundHandler handles every record under ctx rather than the one it is logged with
+type boundHandler struct {
+	handler slog.Handler
+	ctx     context.Context
+}
+
+func (h *boundHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.handler.Enabled(h.ctx, level)
+}
+
+func (h *boundHandler) Handle(_ context.Context, record slog.Record) error {
+	return h.handler.Handle(h.ctx, record)
+}
+
+func (h *boundHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &boundHandler{handler: h.handler.WithAttrs(attrs), ctx: h.ctx}
+}
+
+func (h *boundHandler) WithGroup(name string) slog.Handler {
+	return &boundHandler{handler: h.handler.WithGroup(name), ctx: h.ctx}
+}
@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+// withSpan returns a context carrying a span, as one started for a request would
+func withSpan(t *testing.T) context.Context {
+	t.Helper()
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+	}))
+}
+
+// capture sets the default logger to a JSON one wrapped in Handler for the rest
+// of the test and returns what it writes
+func capture(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(NewHandler(slog.NewJSONHandler(&buf, nil))))
+	return &buf
+}
+
+// record decodes the single record in buf
+func record(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("Failed to decode log record %q: %v", buf.String(), err)
+	}
+	buf.Reset()
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       func(ctx context.Context)
+		withSpan  bool
+		wantTrace bool
+	}{
+		{"context method with a span", func(ctx context.Context) { slog.InfoContext(ctx, "hello") }, true, true},
+		{"context method without a span", func(ctx context.Context) { slog.InfoContext(ctx, "hello") }, false, false},
+		{"logger from a context with a span", func(ctx context.Context) { FromContext(ctx).Info("hello") }, true, true},
+		{"logger from a context without a span", func(ctx context.Context) { FromContext(ctx).Info("hello") }, false, false},
+		{"global function", func(ctx context.Context) { slog.Info("hello") }, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := capture(t)
+			ctx := context.Background()
+			if tt.withSpan {
+				ctx = withSpan(t)
+			}
+			tt.log(ctx)
+
+			rec := record(t, buf)
+			_, hasTrace := rec["trace_id"]
+			_, hasSpan := rec["span_id"]
+			if hasTrace != tt.wantTrace || hasSpan != tt.wantTrace {
+				t.Fatalf("Expected trace_id and span_id present %v, got %v", tt.wantTrace, rec)
+			}
+			if tt.wantTrace && (rec["trace_id"] != traceID || rec["span_id"] != spanID) {
+				t.Errorf("Expected trace_id %s and span_id %s, got %v and %v", traceID, spanID, rec["trace_id"], rec["span_id"])
+			}
+		})
+	}
+}
+
+func TestHandlerKeepsAttrs(t *testing.T) {
+	buf := capture(t)
+	FromContext(withSpan(t)).With("request_id", "abc").Info("hello", "user_id", 7)
+
+	rec := record(t, buf)
+	if rec["request_id"] != "abc" || rec["user_id"] != float64(7) {
+		t.Errorf("Expected request_id abc and user_id 7, got %v", rec)
+	}
+	if rec["trace_id"] != traceID {
+		t.Errorf("Expected trace_id %s, got %v", traceID, rec["trace_id"])
+	}
+}
+
+func TestFromContextLevel(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))))
+
+	FromContext(withSpan(t)).Info("quiet")
+	if buf.Len() != 0 {
+		t.Errorf("Expected records below the default logger's level to be dropped, got %s", buf.String())
+	}
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// masked replaces a personal value that is not an email
+const masked = "***"
+
+// Redact masks the local part of email but its first character, as in
+// j***@example.com, so records still show the domain. A value without a local
+// part and a domain is masked whole.
+func Redact(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return masked
+	}
+	first, _ := utf8.DecodeRuneInString(email)
+	return string(first) + masked + email[at:]
+}
+
+// RedactHandler masks the attributes named as personal data fields, whatever
+// the call site logging them, so a new log call cannot leak them. Emails are
+// masked with Redact and any other value whole.
+type RedactHandler struct {
+	slog.Handler
+	// fields are the lower-cased attribute keys to mask
+	fields map[string]bool
+}
+
+// NewRedactHandler wraps handler in a RedactHandler masking the attributes
+// whose keys are in fields, regardless of case and of the group they are in
+func NewRedactHandler(handler slog.Handler, fields []string) *RedactHandler {
+	h := &RedactHandler{Handler: handler, fields: make(map[string]bool, len(fields))}
+	for _, field := range fields {
+		h.fields[strings.ToLower(field)] = true
+	}
+	return h
+}
+
+// Handle masks the personal attributes of record and passes it on
+func (h *RedactHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redact(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+// WithAttrs returns a RedactHandler whose records also have attrs, masked
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.redact(attr)
+	}
+	return &RedactHandler{Handler: h.Handler.WithAttrs(redacted), fields: h.fields}
+}
+
+// WithGroup returns a RedactHandler whose later attributes are nested in the group name
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{Handler: h.Handler.WithGroup(name), fields: h.fields}
+}
+
+// redact masks attr if it is a personal field, or the personal fields in it if it is a group
+func (h *RedactHandler) redact(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		group := value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = h.redact(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	}
+	if !h.fields[strings.ToLower(attr.Key)] {
+		return attr
+	}
+	if value.Kind() == slog.KindString && strings.Contains(value.String(), "@") {
+		return slog.String(attr.Key, Redact(value.String()))
+	}
+	return slog.String(attr.Key, masked)
+}
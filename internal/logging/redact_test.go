@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"john@example.com", "j***@example.com"},
+		{"j@example.com", "j***@example.com"},
+		{"élodie@example.fr", "é***@example.fr"},
+		{"first@last@example.com", "f***@example.com"},
+		{"@example.com", "***"},
+		{"not an email", "***"},
+		{"", "***"},
+	}
+
+	for _, tt := range tests {
+		if got := Redact(tt.email); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewRedactHandler(slog.NewJSONHandler(&buf, nil), []string{"email", "Name"}))
+
+	tests := []struct {
+		name string
+		log  func()
+		key  string
+		want interface{}
+	}{
+		{"email", func() { logger.Info("hello", "email", "john@example.com") }, "email", "j***@example.com"},
+		{"key in any case", func() { logger.Info("hello", "EMAIL", "john@example.com") }, "EMAIL", "j***@example.com"},
+		{"value that is not an email", func() { logger.Info("hello", "name", "John Doe") }, "name", "***"},
+		{"value that is not a string", func() { logger.Info("hello", "name", 42) }, "name", "***"},
+		{"other fields", func() { logger.Info("hello", "user_id", 7) }, "user_id", float64(7)},
+		{"other fields holding an email", func() { logger.Info("hello", "contact", "john@example.com") }, "contact", "john@example.com"},
+		{"attrs bound to the logger", func() { logger.With("email", "john@example.com").Info("hello") }, "email", "j***@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.log()
+			rec := record(t, &buf)
+			if rec[tt.key] != tt.want {
+				t.Errorf("Expected %s to be %v, got %v", tt.key, tt.want, rec[tt.key])
+			}
+		})
+	}
+
+	t.Run("groups", func(t *testing.T) {
+		logger.WithGroup("user").Info("hello", "email", "john@example.com", slog.Group("profile", "name", "John Doe"))
+		user, _ := record(t, &buf)["user"].(map[string]interface{})
+		if user["email"] != "j***@example.com" {
+			t.Errorf("Expected user.email to be masked, got %v", user["email"])
+		}
+		if profile, _ := user["profile"].(map[string]interface{}); profile["name"] != "***" {
+			t.Errorf("Expected user.profile.name to be masked, got %v", user["profile"])
+		}
+	})
+}
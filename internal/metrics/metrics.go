@@ -1,37 +1,156 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Metrics structure to hold all Prometheus metrics
 type Metrics struct {
 	gatherer prometheus.Gatherer
+	// namespace and subsystem prefix the gathered metric names
+	namespace, subsystem string
+
 	// HTTP request metrics
 	requestsTotal    *prometheus.CounterVec
 	requestDuration  *prometheus.HistogramVec
-	requestsInFlight prometheus.Gauge
+	requestsInFlight *prometheus.GaugeVec
+	requestsRejected *prometheus.CounterVec
+	queueDepth       *prometheus.GaugeVec
+	queueWait        *prometheus.HistogramVec
+	internalRequests *prometheus.CounterVec
+	disconnects      *prometheus.CounterVec
+	peakInFlight     prometheus.Gauge
+
+	// inFlight counts the requests in flight on every route, and peak the most seen
+	inFlightMu     sync.Mutex
+	inFlight, peak float64
+
+	// SLO metrics
+	sloRequests *prometheus.CounterVec
+	errorWindow *Window
+	errorRatio  prometheus.GaugeFunc
+
+	// gRPC call metrics
+	rpcsTotal   *prometheus.CounterVec
+	rpcDuration *prometheus.HistogramVec
+
+	// GraphQL metrics
+	resolverDuration *prometheus.HistogramVec
 
 	// Business metrics
-	usersTotal  prometheus.Gauge
-	userLookups *prometheus.CounterVec
-	errorRate   *prometheus.CounterVec
+	usersTotal       *prometheus.GaugeVec
+	deletedUsers     prometheus.Gauge
+	userLookups      *prometheus.CounterVec
+	collapsedQueries *prometheus.CounterVec
+	errorRate        *prometheus.CounterVec
+	eventsPublished  *prometheus.CounterVec
+	outboxLag        prometheus.Gauge
+	webhookDelivery  *prometheus.CounterVec
+	exportsAborted   prometheus.Counter
+
+	// Database metrics
+	dbQueries       *prometheus.CounterVec
+	dbQueryDuration *prometheus.HistogramVec
+	dbFallbacks     prometheus.Counter
+	dbReconnects    prometheus.Counter
+	slowQueries     *prometheus.CounterVec
+	dbRetries       *prometheus.CounterVec
+	dbPoolConns     *prometheus.GaugeVec
+	dbPoolWait      prometheus.Histogram
+
+	// Cache metrics
+	cacheHits   prometheus.Counter
+	cacheMisses prometheus.Counter
+	cacheErrors prometheus.Counter
 
 	// System metrics
-	rateLimitHits   prometheus.Counter
+	rateLimitHits   *prometheus.CounterVec
 	panicRecoveries prometheus.Counter
 
 	// Custom application metrics
 	lastRequestTime prometheus.Gauge
 	uptime          prometheus.Counter
+
+	// heartbeat is called on every uptime tick
+	heartbeat func()
+	// stop ends the uptime goroutine
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Options customises the metrics. The zero value keeps the defaults.
+type Options struct {
+	// Namespace and Subsystem prefix every metric name, as in
+	// <namespace>_<subsystem>_http_requests_total, so services scraped together do not collide
+	Namespace string
+	Subsystem string
+	// HTTPBuckets are the http_request_duration_seconds buckets; prometheus.DefBuckets when nil
+	HTTPBuckets []float64
+	// DBBuckets are the db_query_duration_seconds buckets; DefaultDBBuckets when nil
+	DBBuckets []float64
+	// Heartbeat, when set, is called on every tick of the Prometheus uptime
+	// goroutine, so a watchdog notices it stop. StatsD ignores it.
+	Heartbeat func()
 }
 
-// New creates and registers all Prometheus metrics
+// DefaultDBBuckets are the default database statement duration buckets. Statements
+// are mostly far quicker than requests, so they start lower than prometheus.DefBuckets.
+var DefaultDBBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// The SLO classes of a response status. Availability is the share of requests
+// that were not server errors; throttled (429) requests are left out of it, as
+// they spend the client's quota rather than the error budget.
+const (
+	SLOSuccess     = "success"
+	SLOClientError = "client_error"
+	SLOServerError = "server_error"
+	SLOThrottled   = "throttled"
+)
+
+// SLOClass returns the SLO class of a response with status
+func SLOClass(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return SLOThrottled
+	case status >= 500:
+		return SLOServerError
+	case status >= 400:
+		return SLOClientError
+	default:
+		return SLOSuccess
+	}
+}
+
+// sloWindow is how far back http_requests_error_ratio looks, in sloWindowBuckets steps
+const (
+	sloWindow        = 5 * time.Minute
+	sloWindowBuckets = 30
+)
+
+// New creates and registers all Prometheus metrics with the default Options
 func New(reg prometheus.Registerer, gatherer prometheus.Gatherer) *Metrics {
+	return NewWithOptions(reg, gatherer, Options{})
+}
+
+// NewWithOptions creates and registers all Prometheus metrics, named and
+// bucketed as opts sets
+func NewWithOptions(reg prometheus.Registerer, gatherer prometheus.Gatherer, opts Options) *Metrics {
+	if opts.HTTPBuckets == nil {
+		opts.HTTPBuckets = prometheus.DefBuckets
+	}
+	if opts.DBBuckets == nil {
+		opts.DBBuckets = DefaultDBBuckets
+	}
 	if reg == nil {
 		reg = prometheus.DefaultRegisterer
 	}
@@ -39,113 +158,515 @@ func New(reg prometheus.Registerer, gatherer prometheus.Gatherer) *Metrics {
 		gatherer = prometheus.DefaultGatherer
 	}
 	m := &Metrics{
-		gatherer: gatherer,
+		gatherer:    gatherer,
+		namespace:   opts.Namespace,
+		subsystem:   opts.Subsystem,
+		heartbeat:   opts.Heartbeat,
+		stop:        make(chan struct{}),
+		errorWindow: NewWindow(sloWindow, sloWindowBuckets),
 		requestsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "http_requests_total",
-				Help: "Total number of HTTP requests processed",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "http_requests_total",
+				Help:      "Total number of HTTP requests processed",
 			},
 			[]string{"method", "endpoint", "status_code"},
 		),
 		requestDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:    "http_request_duration_seconds",
-				Help:    "HTTP request duration in seconds",
-				Buckets: prometheus.DefBuckets,
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "http_request_duration_seconds",
+				Help:      "HTTP request duration in seconds",
+				Buckets:   opts.HTTPBuckets,
 			},
 			[]string{"method", "endpoint"},
 		),
-		requestsInFlight: prometheus.NewGauge(
+		requestsInFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "http_requests_in_flight",
+				Help:      "Number of HTTP requests currently being processed by route pattern",
+			},
+			[]string{"route"},
+		),
+		requestsRejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "requests_rejected_total",
+				Help:      "Total number of HTTP requests turned away by route pattern and reason",
+			},
+			[]string{"route", "reason"},
+		),
+		queueDepth: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "http_requests_in_flight",
-				Help: "Number of HTTP requests currently being processed",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "request_queue_depth",
+				Help:      "Number of HTTP requests waiting for a route's concurrency limit, by route pattern",
+			},
+			[]string{"route"},
+		),
+		queueWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "request_queue_wait_seconds",
+				Help:      "Time HTTP requests waited for a route's concurrency limit in seconds, by route pattern",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"route"},
+		),
+		internalRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "internal_requests_total",
+				Help:      "Total number of scrapes, probes and other requests left out of the request metrics and logs, by path",
 			},
+			[]string{"path"},
 		),
-		usersTotal: prometheus.NewGauge(
+		disconnects: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "client_disconnects_total",
+				Help:      "Total number of HTTP requests whose client went away before the response reached it, by route pattern",
+			},
+			[]string{"route"},
+		),
+		peakInFlight: prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: "users_total",
-				Help: "Total number of users in the system",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "http_requests_in_flight_max",
+				Help:      "Most HTTP requests processed at once since the service started",
+			},
+		),
+		sloRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "http_requests_slo_total",
+				Help:      "Total number of HTTP requests by route pattern and SLO class",
+			},
+			[]string{"route", "class"},
+		),
+		rpcsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "grpc_requests_total",
+				Help:      "Total number of gRPC calls handled, by method and status code",
+			},
+			[]string{"method", "code"},
+		),
+		rpcDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "grpc_request_duration_seconds",
+				Help:      "gRPC call duration in seconds, including the whole stream for streaming calls",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"method"},
+		),
+		resolverDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "graphql_resolver_duration_seconds",
+				Help:      "GraphQL field resolver duration in seconds, by field",
+				Buckets:   prometheus.DefBuckets,
+			},
+			[]string{"field"},
+		),
+		usersTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "users_total",
+				Help:      "Number of users in the system that are not deleted, by account status",
+			},
+			[]string{"status"},
+		),
+		deletedUsers: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "deleted_users_total",
+				Help:      "Number of soft-deleted users kept for auditing",
 			},
 		),
 		userLookups: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "user_lookups_total",
-				Help: "Total number of user lookup operations",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "user_lookups_total",
+				Help:      "Total number of user lookup operations",
 			},
 			[]string{"result"},
 		),
+		collapsedQueries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "collapsed_queries_total",
+				Help:      "Total number of database queries avoided by sharing an identical in-flight query",
+			},
+			[]string{"query"},
+		),
 		errorRate: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "errors_total",
-				Help: "Total number of errors by type",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "errors_total",
+				Help:      "Total number of errors by type",
 			},
 			[]string{"type", "endpoint"},
 		),
-		rateLimitHits: prometheus.NewCounter(
+		eventsPublished: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "rate_limit_hits_total",
-				Help: "Total number of rate limit violations",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "events_published_total",
+				Help:      "Total number of user events delivered to the event broker, by event type and result",
 			},
+			[]string{"type", "status"},
+		),
+		outboxLag: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "outbox_lag_seconds",
+				Help:      "Age of the oldest user event waiting in the outbox, or 0 when it is empty",
+			},
+		),
+		webhookDelivery: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "webhook_deliveries_total",
+				Help:      "Total number of webhook delivery attempts by outcome",
+			},
+			[]string{"result"},
+		),
+		exportsAborted: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "exports_aborted_total",
+				Help:      "Total number of user exports cut short by the client going away",
+			},
+		),
+		dbQueries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_queries_total",
+				Help:      "Total number of database statements by connection target and result",
+			},
+			[]string{"target", "result"},
+		),
+		dbQueryDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_query_duration_seconds",
+				Help:      "Database statement duration in seconds, by pgx operation",
+				Buckets:   opts.DBBuckets,
+			},
+			[]string{"operation"},
+		),
+		dbFallbacks: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_replica_fallbacks_total",
+				Help:      "Total number of reads retried on the primary after a replica failed",
+			},
+		),
+		dbReconnects: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_reconnects_total",
+				Help:      "Total number of database connections re-established after they broke",
+			},
+		),
+		slowQueries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_slow_queries_total",
+				Help:      "Total number of repository calls that ran past the slow query threshold, by operation",
+			},
+			[]string{"operation"},
+		),
+		dbRetries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_retries_total",
+				Help:      "Total number of repository reads retried after a transient database error, by operation",
+			},
+			[]string{"operation"},
+		),
+		dbPoolConns: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_pool_connections",
+				Help:      "Number of connections in the transaction pool by state: acquired, idle or total",
+			},
+			[]string{"state"},
+		),
+		dbPoolWait: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "db_pool_acquire_wait_seconds",
+				Help:      "Mean time in seconds the acquires between two pool refreshes waited for a connection",
+				Buckets:   opts.DBBuckets,
+			},
+		),
+		cacheHits: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "cache_hits_total",
+				Help:      "Total number of user lookups served from the cache",
+			},
+		),
+		cacheMisses: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "cache_misses_total",
+				Help:      "Total number of user lookups not found in the cache",
+			},
+		),
+		cacheErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "cache_errors_total",
+				Help:      "Total number of failed cache operations",
+			},
+		),
+		rateLimitHits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "rate_limit_hits_total",
+				Help:      "Total number of rate limit violations by request class",
+			},
+			[]string{"class"},
 		),
 		panicRecoveries: prometheus.NewCounter(
 			prometheus.CounterOpts{
-				Name: "panic_recoveries_total",
-				Help: "Total number of panic recoveries",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "panic_recoveries_total",
+				Help:      "Total number of panic recoveries",
 			},
 		),
 		lastRequestTime: prometheus.NewGauge(
 			prometheus.GaugeOpts{
-				Name: "last_request_time_seconds",
-				Help: "Unix timestamp of the last request",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "last_request_time_seconds",
+				Help:      "Unix timestamp of the last request",
 			},
 		),
 		uptime: prometheus.NewCounter(
 			prometheus.CounterOpts{
-				Name: "uptime_seconds_total",
-				Help: "Total uptime in seconds",
+				Namespace: opts.Namespace,
+				Subsystem: opts.Subsystem,
+				Name:      "uptime_seconds_total",
+				Help:      "Total uptime in seconds",
 			},
 		),
 	}
 
-	// Register all metrics with Prometheus
-	reg.MustRegister(
-		m.requestsTotal,
-		m.requestDuration,
-		m.requestsInFlight,
-		m.usersTotal,
-		m.userLookups,
-		m.errorRate,
-		m.rateLimitHits,
-		m.panicRecoveries,
-		m.lastRequestTime,
-		m.uptime,
+	m.errorRatio = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "http_requests_error_ratio",
+			Help:      "Share of HTTP requests over the last 5 minutes that were server errors, throttled requests excluded",
+		},
+		m.errorWindow.Ratio,
 	)
 
+	// Register all metrics with Prometheus. A registry that already has them, from
+	// an earlier Metrics such as one created before a reload or by another test,
+	// keeps them and this Metrics records to those; only the Metrics that first
+	// registered http_requests_error_ratio reports its requests in it.
+	m.requestsTotal = register(reg, m.requestsTotal)
+	m.requestDuration = register(reg, m.requestDuration)
+	m.requestsInFlight = register(reg, m.requestsInFlight)
+	m.requestsRejected = register(reg, m.requestsRejected)
+	m.queueDepth = register(reg, m.queueDepth)
+	m.queueWait = register(reg, m.queueWait)
+	m.internalRequests = register(reg, m.internalRequests)
+	m.disconnects = register(reg, m.disconnects)
+	m.peakInFlight = register(reg, m.peakInFlight)
+	m.sloRequests = register(reg, m.sloRequests)
+	m.errorRatio = register(reg, m.errorRatio)
+	m.rpcsTotal = register(reg, m.rpcsTotal)
+	m.rpcDuration = register(reg, m.rpcDuration)
+	m.resolverDuration = register(reg, m.resolverDuration)
+	m.usersTotal = register(reg, m.usersTotal)
+	m.deletedUsers = register(reg, m.deletedUsers)
+	m.userLookups = register(reg, m.userLookups)
+	m.collapsedQueries = register(reg, m.collapsedQueries)
+	m.errorRate = register(reg, m.errorRate)
+	m.eventsPublished = register(reg, m.eventsPublished)
+	m.outboxLag = register(reg, m.outboxLag)
+	m.webhookDelivery = register(reg, m.webhookDelivery)
+	m.exportsAborted = register(reg, m.exportsAborted)
+	m.dbQueries = register(reg, m.dbQueries)
+	m.dbQueryDuration = register(reg, m.dbQueryDuration)
+	m.dbFallbacks = register(reg, m.dbFallbacks)
+	m.dbReconnects = register(reg, m.dbReconnects)
+	m.slowQueries = register(reg, m.slowQueries)
+	m.dbRetries = register(reg, m.dbRetries)
+	m.dbPoolConns = register(reg, m.dbPoolConns)
+	m.dbPoolWait = register(reg, m.dbPoolWait)
+	m.cacheHits = register(reg, m.cacheHits)
+	m.cacheMisses = register(reg, m.cacheMisses)
+	m.cacheErrors = register(reg, m.cacheErrors)
+	m.rateLimitHits = register(reg, m.rateLimitHits)
+	m.panicRecoveries = register(reg, m.panicRecoveries)
+	m.lastRequestTime = register(reg, m.lastRequestTime)
+	m.uptime = register(reg, m.uptime)
+
+	// A fresh registry lacks the go_* and process_* metrics the default one has.
+	// They keep their standard names whatever the namespace.
+	if reg != prometheus.DefaultRegisterer {
+		register[prometheus.Collector](reg, collectors.NewGoCollector())
+		register[prometheus.Collector](reg, collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
 	// Start uptime counter
 	go m.updateUptime()
 
 	return m
 }
 
-// Handler returns the Prometheus metrics handler
+// register registers c with reg, returning the collector reg already has in its
+// place, if any. Any other registration error is a programming error and panics,
+// as MustRegister does.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
+
+// Handler returns the Prometheus metrics handler. Scrapers asking for the
+// OpenMetrics format also get the exemplars linking latencies to traces.
 func (m *Metrics) Handler() http.Handler {
-	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
 }
 
-// RecordRequest records HTTP request metrics
-func (m *Metrics) RecordRequest(method, endpoint, statusCode string, duration time.Duration) {
+// RecordRequest records HTTP request metrics. When ctx carries a sampled trace
+// span, its trace ID is attached to the latency observation as an exemplar.
+func (m *Metrics) RecordRequest(ctx context.Context, method, endpoint, statusCode string, duration time.Duration) {
 	m.requestsTotal.WithLabelValues(method, endpoint, statusCode).Inc()
-	m.requestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
+	observeWithTrace(ctx, m.requestDuration.WithLabelValues(method, endpoint), duration)
 }
 
-// RecordRequestInFlight tracks requests currently being processed
-func (m *Metrics) RecordRequestInFlight(delta float64) {
-	m.requestsInFlight.Add(delta)
+// observeWithTrace observes duration, attaching the trace ID of the sampled span
+// in ctx, if any, as an exemplar
+func observeWithTrace(ctx context.Context, observer prometheus.Observer, duration time.Duration) {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() && span.IsSampled() {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"trace_id": span.TraceID().String()})
+		return
+	}
+	observer.Observe(duration.Seconds())
 }
 
-// SetUsersTotal sets the current users total
-func (m *Metrics) SetUsersTotal(count float64) {
-	m.usersTotal.Set(count)
+// RecordRequestSLO counts a request to route in its SLO class and, unless it was
+// throttled, in the rolling error ratio
+func (m *Metrics) RecordRequestSLO(route, class string) {
+	m.sloRequests.WithLabelValues(route, class).Inc()
+	if class != SLOThrottled {
+		m.errorWindow.Add(class == SLOServerError)
+	}
+}
+
+// RecordRequestInFlight tracks requests currently being processed on route
+func (m *Metrics) RecordRequestInFlight(route string, delta float64) {
+	m.requestsInFlight.WithLabelValues(route).Add(delta)
+
+	m.inFlightMu.Lock()
+	defer m.inFlightMu.Unlock()
+	m.inFlight += delta
+	if m.inFlight > m.peak {
+		m.peak = m.inFlight
+		m.peakInFlight.Set(m.peak)
+	}
+}
+
+// RecordRequestRejected records a request to route turned away for reason, such as "concurrency"
+func (m *Metrics) RecordRequestRejected(route, reason string) {
+	m.requestsRejected.WithLabelValues(route, reason).Inc()
+}
+
+// RecordQueueDepth tracks requests waiting for route's concurrency limit
+func (m *Metrics) RecordQueueDepth(route string, delta float64) {
+	m.queueDepth.WithLabelValues(route).Add(delta)
+}
+
+// RecordQueueWait records how long a request waited for route's concurrency
+// limit, whether it got a slot or gave up
+func (m *Metrics) RecordQueueWait(route string, wait time.Duration) {
+	m.queueWait.WithLabelValues(route).Observe(wait.Seconds())
+}
+
+// RecordInternalRequest records a request to path, such as "/metrics", that is left
+// out of the request metrics
+func (m *Metrics) RecordInternalRequest(path string) {
+	m.internalRequests.WithLabelValues(path).Inc()
+}
+
+// RecordClientDisconnect records a request to route whose client went away before
+// the response reached it
+func (m *Metrics) RecordClientDisconnect(route string) {
+	m.disconnects.WithLabelValues(route).Inc()
+}
+
+// RecordRPC records a gRPC call to method ("/userservice.v1.UserService/GetUser") ending with status code
+func (m *Metrics) RecordRPC(method, code string, duration time.Duration) {
+	m.rpcsTotal.WithLabelValues(method, code).Inc()
+	m.rpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// RecordResolver records how long the GraphQL resolver for field ("Query.user") took
+func (m *Metrics) RecordResolver(field string, duration time.Duration) {
+	m.resolverDuration.WithLabelValues(field).Observe(duration.Seconds())
+}
+
+// SetUsersTotal sets the current number of users with status
+func (m *Metrics) SetUsersTotal(status string, count float64) {
+	m.usersTotal.WithLabelValues(status).Set(count)
+}
+
+// SetDeletedUsersTotal sets the current number of soft-deleted users
+func (m *Metrics) SetDeletedUsersTotal(count float64) {
+	m.deletedUsers.Set(count)
 }
 
 // RecordUserLookup records user lookup results
@@ -153,14 +674,96 @@ func (m *Metrics) RecordUserLookup(result string) {
 	m.userLookups.WithLabelValues(result).Inc()
 }
 
+// RecordCollapsedQuery records a caller that shared another caller's in-flight query
+func (m *Metrics) RecordCollapsedQuery(query string) {
+	m.collapsedQueries.WithLabelValues(query).Inc()
+}
+
 // RecordError records application errors
 func (m *Metrics) RecordError(errorType, endpoint string) {
 	m.errorRate.WithLabelValues(errorType, endpoint).Inc()
 }
 
-// RecordRateLimitHit records rate limit violations
-func (m *Metrics) RecordRateLimitHit() {
-	m.rateLimitHits.Inc()
+// RecordEventPublished records the result ("ok" or "error") of delivering an event of eventType
+func (m *Metrics) RecordEventPublished(eventType, status string) {
+	m.eventsPublished.WithLabelValues(eventType, status).Inc()
+}
+
+// SetOutboxLag sets how long the oldest unsent event has waited in the outbox
+func (m *Metrics) SetOutboxLag(seconds float64) {
+	m.outboxLag.Set(seconds)
+}
+
+// RecordWebhookDelivery records the outcome ("succeeded", "retry" or "failed") of a webhook delivery attempt
+func (m *Metrics) RecordWebhookDelivery(result string) {
+	m.webhookDelivery.WithLabelValues(result).Inc()
+}
+
+// RecordExportAborted records a user export cut short because its client went away
+func (m *Metrics) RecordExportAborted() {
+	m.exportsAborted.Inc()
+}
+
+// RecordDBQuery records a statement sent to a database target ("primary", "replica-0", ...)
+func (m *Metrics) RecordDBQuery(target, result string) {
+	m.dbQueries.WithLabelValues(target, result).Inc()
+}
+
+// RecordDBQueryDuration records how long a database statement run by a pgx
+// operation ("Query", "Exec", ...) took, linking it to the sampled trace span in
+// ctx like RecordRequest
+func (m *Metrics) RecordDBQueryDuration(ctx context.Context, operation string, duration time.Duration) {
+	observeWithTrace(ctx, m.dbQueryDuration.WithLabelValues(operation), duration)
+}
+
+// RecordDBFallback records a read retried on the primary after a replica failed
+func (m *Metrics) RecordDBFallback() {
+	m.dbFallbacks.Inc()
+}
+
+// RecordDBReconnect records a database connection re-established after it broke
+func (m *Metrics) RecordDBReconnect() {
+	m.dbReconnects.Inc()
+}
+
+// RecordSlowQuery records a repository call ("get_user", "list_users", ...) that ran past the slow query threshold
+func (m *Metrics) RecordSlowQuery(operation string) {
+	m.slowQueries.WithLabelValues(operation).Inc()
+}
+
+// RecordDBRetry records a repository read ("get_user", "list_users", ...) retried after a transient error
+func (m *Metrics) RecordDBRetry(operation string) {
+	m.dbRetries.WithLabelValues(operation).Inc()
+}
+
+// SetDBPoolConns sets how many connections of the transaction pool are in state ("acquired", "idle" or "total")
+func (m *Metrics) SetDBPoolConns(state string, count float64) {
+	m.dbPoolConns.WithLabelValues(state).Set(count)
+}
+
+// RecordDBPoolAcquireWait records the mean time recent acquires waited for a pooled connection
+func (m *Metrics) RecordDBPoolAcquireWait(wait time.Duration) {
+	m.dbPoolWait.Observe(wait.Seconds())
+}
+
+// RecordCacheHit records a lookup served from the cache
+func (m *Metrics) RecordCacheHit() {
+	m.cacheHits.Inc()
+}
+
+// RecordCacheMiss records a lookup that had to go to the database
+func (m *Metrics) RecordCacheMiss() {
+	m.cacheMisses.Inc()
+}
+
+// RecordCacheError records a cache operation that failed
+func (m *Metrics) RecordCacheError() {
+	m.cacheErrors.Inc()
+}
+
+// RecordRateLimitHit records a request of class ("read" or "write") rejected by its rate limiter
+func (m *Metrics) RecordRateLimitHit(class string) {
+	m.rateLimitHits.WithLabelValues(class).Inc()
 }
 
 // RecordPanicRecovery records panic recoveries
@@ -178,7 +781,74 @@ func (m *Metrics) updateUptime() {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.uptime.Inc()
+	for {
+		select {
+		case <-ticker.C:
+			m.uptime.Inc()
+			if m.heartbeat != nil {
+				m.heartbeat()
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Snapshot sums up what the service served since it started
+type Snapshot struct {
+	// Requests counts the HTTP requests by SLO class
+	Requests map[string]float64
+	// MaxInFlight is the most HTTP requests processed at once
+	MaxInFlight float64
+	Uptime      time.Duration
+}
+
+// TotalRequests returns the number of HTTP requests of every class
+func (s Snapshot) TotalRequests() float64 {
+	var total float64
+	for _, count := range s.Requests {
+		total += count
+	}
+	return total
+}
+
+// Snapshot gathers the registry and sums up the requests served, the peak of
+// requests in flight and the uptime, such as to report them on shutdown
+func (m *Metrics) Snapshot() (Snapshot, error) {
+	families, err := m.gatherer.Gather()
+	if err != nil {
+		return Snapshot{}, err
 	}
+
+	snapshot := Snapshot{Requests: make(map[string]float64)}
+	for _, family := range families {
+		switch family.GetName() {
+		case prometheus.BuildFQName(m.namespace, m.subsystem, "http_requests_slo_total"):
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "class" {
+						snapshot.Requests[label.GetValue()] += metric.GetCounter().GetValue()
+					}
+				}
+			}
+		case prometheus.BuildFQName(m.namespace, m.subsystem, "http_requests_in_flight_max"):
+			snapshot.MaxInFlight = family.GetMetric()[0].GetGauge().GetValue()
+		case prometheus.BuildFQName(m.namespace, m.subsystem, "uptime_seconds_total"):
+			snapshot.Uptime = time.Duration(family.GetMetric()[0].GetCounter().GetValue()) * time.Second
+		}
+	}
+	return snapshot, nil
+}
+
+// Push sends the current value of every gathered metric to the Prometheus
+// Pushgateway at url under job, grouped by instance so that replicas do not
+// replace each other's
+func (m *Metrics) Push(ctx context.Context, url, job, instance string) error {
+	return push.New(url, job).Gatherer(m.gatherer).Grouping("instance", instance).PushContext(ctx)
+}
+
+// Close stops counting uptime. The metrics can still be recorded and served.
+func (m *Metrics) Close() error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	return nil
 }
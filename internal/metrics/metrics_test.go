@@ -1,12 +1,17 @@
 package metrics
 
 import (
+	"context"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestMetrics(t *testing.T) {
@@ -14,16 +19,47 @@ func TestMetrics(t *testing.T) {
 	metrics := New(reg, reg)
 
 	t.Run("record request", func(t *testing.T) {
-		metrics.RecordRequest("GET", "/test", "200", time.Second)
+		metrics.RecordRequest(context.Background(), "GET", "/test", "200", time.Second)
 	})
 
 	t.Run("record request in flight", func(t *testing.T) {
-		metrics.RecordRequestInFlight(1)
-		metrics.RecordRequestInFlight(-1)
+		metrics.RecordRequestInFlight("/users", 1)
+		metrics.RecordRequestInFlight("/users", -1)
+	})
+
+	t.Run("record internal request", func(t *testing.T) {
+		metrics.RecordInternalRequest("/metrics")
+	})
+
+	t.Run("record client disconnect", func(t *testing.T) {
+		metrics.RecordClientDisconnect("GET /users")
+	})
+
+	t.Run("record request rejected", func(t *testing.T) {
+		metrics.RecordRequestRejected("GET /users/export", "concurrency")
+	})
+
+	t.Run("record queue", func(t *testing.T) {
+		metrics.RecordQueueDepth("GET /users/export", 1)
+		metrics.RecordQueueWait("GET /users/export", time.Millisecond)
+		metrics.RecordQueueDepth("GET /users/export", -1)
+	})
+
+	t.Run("record rpc", func(t *testing.T) {
+		metrics.RecordRPC("/userservice.v1.UserService/GetUser", "OK", time.Millisecond)
+	})
+
+	t.Run("record resolver", func(t *testing.T) {
+		metrics.RecordResolver("Query.user", time.Millisecond)
 	})
 
 	t.Run("set users total", func(t *testing.T) {
-		metrics.SetUsersTotal(10)
+		metrics.SetUsersTotal("active", 10)
+		metrics.SetUsersTotal("disabled", 1)
+	})
+
+	t.Run("set deleted users total", func(t *testing.T) {
+		metrics.SetDeletedUsersTotal(2)
 	})
 
 	t.Run("record user lookup", func(t *testing.T) {
@@ -31,12 +67,63 @@ func TestMetrics(t *testing.T) {
 		metrics.RecordUserLookup("not_found")
 	})
 
+	t.Run("record collapsed query", func(t *testing.T) {
+		metrics.RecordCollapsedQuery("get_user")
+	})
+
+	t.Run("record event published", func(t *testing.T) {
+		metrics.RecordEventPublished("user.created", "ok")
+		metrics.RecordEventPublished("user.created", "error")
+	})
+
+	t.Run("set outbox lag", func(t *testing.T) {
+		metrics.SetOutboxLag(1.5)
+	})
+
+	t.Run("record webhook delivery", func(t *testing.T) {
+		metrics.RecordWebhookDelivery("succeeded")
+		metrics.RecordWebhookDelivery("retry")
+	})
+
+	t.Run("record export aborted", func(t *testing.T) {
+		metrics.RecordExportAborted()
+	})
+
+	t.Run("record db query and fallback", func(t *testing.T) {
+		metrics.RecordDBQuery("primary", "ok")
+		metrics.RecordDBQuery("replica-0", "error")
+		metrics.RecordDBFallback()
+	})
+
+	t.Run("record db reconnect", func(t *testing.T) {
+		metrics.RecordDBReconnect()
+	})
+
+	t.Run("record slow query", func(t *testing.T) {
+		metrics.RecordSlowQuery("list_users")
+	})
+
+	t.Run("record db retry", func(t *testing.T) {
+		metrics.RecordDBRetry("get_user")
+	})
+
+	t.Run("record db pool stats", func(t *testing.T) {
+		metrics.SetDBPoolConns("acquired", 2)
+		metrics.RecordDBPoolAcquireWait(time.Millisecond)
+	})
+
 	t.Run("record error", func(t *testing.T) {
 		metrics.RecordError("test_error", "/test")
 	})
 
+	t.Run("record cache hit, miss and error", func(t *testing.T) {
+		metrics.RecordCacheHit()
+		metrics.RecordCacheMiss()
+		metrics.RecordCacheError()
+	})
+
 	t.Run("record rate limit hit", func(t *testing.T) {
-		metrics.RecordRateLimitHit()
+		metrics.RecordRateLimitHit("write")
 	})
 
 	t.Run("record panic recovery", func(t *testing.T) {
@@ -61,4 +148,343 @@ func TestMetrics(t *testing.T) {
 			t.Errorf("expected metrics body to contain http_requests_total, got %s", body)
 		}
 	})
+
+	t.Run("close", func(t *testing.T) {
+		if err := metrics.Close(); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		// Closing again is harmless and recording still works
+		if err := metrics.Close(); err != nil {
+			t.Errorf("expected no error closing twice, got %v", err)
+		}
+		metrics.UpdateLastRequestTime()
+	})
+}
+
+func TestUptimeHeartbeat(t *testing.T) {
+	beats := make(chan struct{}, 1)
+	reg := prometheus.NewRegistry()
+	metrics := NewWithOptions(reg, reg, Options{Heartbeat: func() {
+		select {
+		case beats <- struct{}{}:
+		default:
+		}
+	}})
+	defer metrics.Close()
+
+	select {
+	case <-beats:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the uptime goroutine to beat every second")
+	}
+}
+
+// latencyExemplars returns the trace IDs of the exemplars on the request latency histogram
+func latencyExemplars(t *testing.T, reg *prometheus.Registry) []string {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	var traceIDs []string
+	for _, family := range families {
+		if family.GetName() != "http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					if label.GetName() == "trace_id" {
+						traceIDs = append(traceIDs, label.GetValue())
+					}
+				}
+			}
+		}
+	}
+	return traceIDs
+}
+
+// spanContext returns a context carrying a span of trace 4bf92f3577b34da6a3ce929d0e0e4736 with flags
+func spanContext(flags trace.TraceFlags) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: flags,
+	}))
+}
+
+func TestRecordRequestExemplar(t *testing.T) {
+	t.Run("links a sampled span", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		metrics := New(reg, reg)
+		defer metrics.Close()
+
+		metrics.RecordRequest(spanContext(trace.FlagsSampled), "GET", "/users", "200", 20*time.Millisecond)
+
+		exemplars := latencyExemplars(t, reg)
+		if len(exemplars) != 1 || exemplars[0] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected an exemplar for trace 4bf92f3577b34da6a3ce929d0e0e4736, got %v", exemplars)
+		}
+	})
+
+	t.Run("observes without an exemplar when tracing is off", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		metrics := New(reg, reg)
+		defer metrics.Close()
+
+		metrics.RecordRequest(context.Background(), "GET", "/users", "200", 20*time.Millisecond)
+		metrics.RecordRequest(spanContext(0), "GET", "/users", "200", 20*time.Millisecond)
+
+		if exemplars := latencyExemplars(t, reg); len(exemplars) != 0 {
+			t.Errorf("Expected no exemplars, got %v", exemplars)
+		}
+	})
+}
+
+func TestHandlerExposesExemplars(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := New(reg, reg)
+	defer metrics.Close()
+
+	metrics.RecordRequest(spanContext(trace.FlagsSampled), "GET", "/users", "200", 20*time.Millisecond)
+	metrics.RecordDBQueryDuration(spanContext(trace.FlagsSampled), "Query", 3*time.Millisecond)
+	metrics.RecordDBQueryDuration(context.Background(), "Exec", 3*time.Millisecond)
+
+	scrape := func(accept string) string {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+	// exemplarSeries returns the names of the buckets in body carrying the test trace's exemplar
+	exemplarSeries := func(body string) []string {
+		var series []string
+		for _, line := range strings.Split(body, "\n") {
+			if strings.Contains(line, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+				series = append(series, line[:strings.Index(line, "{")])
+			}
+		}
+		return series
+	}
+
+	series := exemplarSeries(scrape("application/openmetrics-text; version=1.0.0; charset=utf-8"))
+	if len(series) != 2 || series[0] != "db_query_duration_seconds_bucket" || series[1] != "http_request_duration_seconds_bucket" {
+		t.Errorf("Expected one exemplar on each latency histogram, got %v", series)
+	}
+	// The classic text format has no exemplars
+	if series := exemplarSeries(scrape("")); len(series) != 0 {
+		t.Errorf("Expected no exemplars without OpenMetrics, got %v", series)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := NewWithOptions(reg, reg, Options{
+		Namespace:   "acme",
+		Subsystem:   "users",
+		HTTPBuckets: []float64{.005, .01, .02},
+		DBBuckets:   []float64{.001},
+	})
+	defer metrics.Close()
+
+	metrics.RecordRequest(context.Background(), "GET", "/users", "200", 15*time.Millisecond)
+	metrics.RecordDBQueryDuration(context.Background(), "Query", time.Millisecond)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	buckets := make(map[string][]float64)
+	for _, family := range families {
+		// The Go runtime and process metrics keep their standard names
+		if strings.HasPrefix(family.GetName(), "go_") || strings.HasPrefix(family.GetName(), "process_") {
+			continue
+		}
+		if !strings.HasPrefix(family.GetName(), "acme_users_") {
+			t.Errorf("Expected every metric to be prefixed with acme_users_, got %s", family.GetName())
+		}
+		for _, metric := range family.GetMetric() {
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				buckets[family.GetName()] = append(buckets[family.GetName()], bucket.GetUpperBound())
+			}
+		}
+	}
+
+	for name, want := range map[string][]float64{
+		"acme_users_http_request_duration_seconds": {.005, .01, .02},
+		"acme_users_db_query_duration_seconds":     {.001},
+	} {
+		if got := buckets[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s buckets %v, got %v", name, want, got)
+		}
+	}
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rr.Body.String(); !strings.Contains(body, `acme_users_http_requests_total{endpoint="/users",method="GET",status_code="200"} 1`) {
+		t.Errorf("Expected the prefixed request counter in the scrape, got:\n%s", body)
+	}
+}
+
+func TestNewTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first := New(reg, reg)
+	defer first.Close()
+	// A second Metrics on the same registry, as after a reload, must not panic
+	second := New(reg, reg)
+	defer second.Close()
+
+	first.RecordRequest(context.Background(), "GET", "/users", "200", time.Millisecond)
+	second.RecordRequest(context.Background(), "GET", "/users", "200", time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	second.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rr.Body.String(); !strings.Contains(body, `http_requests_total{endpoint="/users",method="GET",status_code="200"} 2`) {
+		t.Errorf("Expected both instances to record to the registered counter, got:\n%s", body)
+	}
+}
+
+func TestRuntimeCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := NewWithOptions(reg, reg, Options{Namespace: "acme"})
+	defer metrics.Close()
+
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, name := range []string{"go_goroutines", "process_resident_memory_bytes"} {
+		if !strings.Contains(rr.Body.String(), "\n"+name+" ") {
+			t.Errorf("Expected %s on a fresh registry", name)
+		}
+	}
+}
+
+func TestSLOClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{200, SLOSuccess},
+		{204, SLOSuccess},
+		{304, SLOSuccess},
+		{400, SLOClientError},
+		{404, SLOClientError},
+		{429, SLOThrottled},
+		{500, SLOServerError},
+		{503, SLOServerError},
+	}
+
+	for _, tt := range tests {
+		if got := SLOClass(tt.status); got != tt.want {
+			t.Errorf("SLOClass(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestRecordRequestSLO(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := New(reg, reg)
+	defer metrics.Close()
+
+	for _, class := range []string{SLOSuccess, SLOSuccess, SLOClientError, SLOServerError, SLOThrottled, SLOThrottled} {
+		metrics.RecordRequestSLO("GET /users", class)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	counts := map[string]float64{}
+	var ratio float64
+	for _, family := range families {
+		switch family.GetName() {
+		case "http_requests_slo_total":
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "class" {
+						counts[label.GetValue()] = metric.GetCounter().GetValue()
+					}
+				}
+			}
+		case "http_requests_error_ratio":
+			ratio = family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	want := map[string]float64{SLOSuccess: 2, SLOClientError: 1, SLOServerError: 1, SLOThrottled: 2}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("Expected SLO counts %v, got %v", want, counts)
+	}
+	// Throttled requests are left out: 1 server error in 4 requests
+	if ratio != 0.25 {
+		t.Errorf("Expected an error ratio of 0.25, got %v", ratio)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, opts := range []Options{{}, {Namespace: "acme", Subsystem: "users"}} {
+		t.Run("prefix "+opts.Namespace+opts.Subsystem, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			metrics := NewWithOptions(reg, reg, opts)
+			defer metrics.Close()
+
+			// Three requests overlap on two routes, then a fourth runs alone
+			metrics.RecordRequestInFlight("/users", 1)
+			metrics.RecordRequestInFlight("GET /users/export", 1)
+			metrics.RecordRequestInFlight("/users", 1)
+			for _, class := range []string{SLOSuccess, SLOServerError, SLOThrottled} {
+				metrics.RecordRequestInFlight("/users", -1)
+				metrics.RecordRequestSLO("/users", class)
+			}
+			metrics.RecordRequestInFlight("/users", 1)
+			metrics.RecordRequestInFlight("/users", -1)
+			metrics.RecordRequestSLO("/users", SLOSuccess)
+
+			snapshot, err := metrics.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+			want := map[string]float64{SLOSuccess: 2, SLOServerError: 1, SLOThrottled: 1}
+			if !reflect.DeepEqual(snapshot.Requests, want) {
+				t.Errorf("Expected requests %v, got %v", want, snapshot.Requests)
+			}
+			if snapshot.TotalRequests() != 4 {
+				t.Errorf("Expected 4 requests in total, got %v", snapshot.TotalRequests())
+			}
+			if snapshot.MaxInFlight != 3 {
+				t.Errorf("Expected at most 3 requests in flight, got %v", snapshot.MaxInFlight)
+			}
+			if snapshot.Uptime < 0 || snapshot.Uptime > time.Minute {
+				t.Errorf("Expected the uptime of the test, got %v", snapshot.Uptime)
+			}
+		})
+	}
+}
+
+func TestPush(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := New(reg, reg)
+	defer metrics.Close()
+	metrics.RecordRequestSLO("/users", SLOSuccess)
+
+	var method, path, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	if err := metrics.Push(context.Background(), gateway.URL, "user-service", "pod-1"); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	// Each replica replaces only its own group
+	if method != http.MethodPut || path != "/metrics/job/user-service/instance/pod-1" {
+		t.Errorf("Expected a PUT to the pod-1 group, got %s %s", method, path)
+	}
+	if !strings.Contains(body, "http_requests_slo_total") {
+		t.Errorf("Expected the pushed metrics to include http_requests_slo_total")
+	}
 }
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Recorder records the service's metrics. Metrics, the Prometheus implementation,
+// is the default; StatsD sends them to a DogStatsD agent instead. Close flushes
+// what the recorder holds and stops its background work.
+type Recorder interface {
+	RecordRequest(ctx context.Context, method, endpoint, statusCode string, duration time.Duration)
+	RecordRequestSLO(route, class string)
+	RecordRequestInFlight(route string, delta float64)
+	RecordRequestRejected(route, reason string)
+	RecordQueueDepth(route string, delta float64)
+	RecordQueueWait(route string, wait time.Duration)
+	RecordInternalRequest(path string)
+	RecordClientDisconnect(route string)
+	RecordRPC(method, code string, duration time.Duration)
+	RecordResolver(field string, duration time.Duration)
+	SetUsersTotal(status string, count float64)
+	SetDeletedUsersTotal(count float64)
+	RecordUserLookup(result string)
+	RecordCollapsedQuery(query string)
+	RecordError(errorType, endpoint string)
+	RecordEventPublished(eventType, status string)
+	SetOutboxLag(seconds float64)
+	RecordWebhookDelivery(result string)
+	RecordExportAborted()
+	RecordDBQuery(target, result string)
+	RecordDBQueryDuration(ctx context.Context, operation string, duration time.Duration)
+	RecordDBFallback()
+	RecordDBReconnect()
+	RecordSlowQuery(operation string)
+	RecordDBRetry(operation string)
+	SetDBPoolConns(state string, count float64)
+	RecordDBPoolAcquireWait(wait time.Duration)
+	RecordCacheHit()
+	RecordCacheMiss()
+	RecordCacheError()
+	RecordRateLimitHit(class string)
+	RecordPanicRecovery()
+	UpdateLastRequestTime()
+	Close() error
+}
+
+// Exposer is a Recorder that serves its metrics for scraping, as Metrics does at /metrics
+type Exposer interface {
+	Handler() http.Handler
+}
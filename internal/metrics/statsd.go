@@ -0,0 +1,358 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxStatsDPacket keeps each datagram within a typical MTU, as DogStatsD recommends
+const maxStatsDPacket = 1432
+
+// StatsD is a Recorder sending metrics to a StatsD agent over UDP in the DogStatsD
+// format, as in "http_requests_total:1|c|#method:GET,endpoint:/users". Metric names
+// match the Prometheus ones, labels become tags and durations are sent as
+// millisecond timers with a _ms suffix in place of _seconds. Counters and gauges
+// are aggregated in memory and sent every flush interval, so the hot paths do not
+// send a datagram per request; timers are sent as they are recorded. Exemplars
+// have no StatsD equivalent and are dropped.
+type StatsD struct {
+	conn      io.WriteCloser
+	namespace string
+	subsystem string
+
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStatsD sends metrics to the agent at addr ("127.0.0.1:8125"), flushing
+// counters and gauges every flushInterval. Metric names are prefixed as
+// Options.Namespace and Options.Subsystem set; the buckets do not apply.
+func NewStatsD(addr string, flushInterval time.Duration, opts Options) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newStatsD(conn, flushInterval, opts), nil
+}
+
+// newStatsD sends metrics to conn, one datagram per write. A zero flushInterval
+// leaves flushing to Close.
+func newStatsD(conn io.WriteCloser, flushInterval time.Duration, opts Options) *StatsD {
+	s := &StatsD{
+		conn:      conn,
+		namespace: opts.Namespace,
+		subsystem: opts.Subsystem,
+		counters:  make(map[string]int64),
+		gauges:    make(map[string]float64),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if flushInterval <= 0 {
+		close(s.done)
+		return s
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// tag is a label sent as a DogStatsD tag
+type tag struct {
+	name, value string
+}
+
+// metric returns the key of name with tags, which is also the start of its line
+// on the wire: "name" or "name|#tag:value,..." with the type slotted in on send
+func (s *StatsD) metric(name string, tags ...tag) string {
+	var b strings.Builder
+	b.WriteString(prometheus.BuildFQName(s.namespace, s.subsystem, name))
+	for i, t := range tags {
+		if i == 0 {
+			b.WriteString("|#")
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(t.name)
+		b.WriteByte(':')
+		b.WriteString(tagValue.Replace(t.value))
+	}
+	return b.String()
+}
+
+// tagValue replaces the characters that would break a DogStatsD line
+var tagValue = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// line renders metric, as returned by metric, with its value and type
+func line(metric, value, kind string) string {
+	name, tags, _ := strings.Cut(metric, "|")
+	if tags != "" {
+		tags = "|" + tags
+	}
+	return name + ":" + value + "|" + kind + tags
+}
+
+func (s *StatsD) count(name string, tags ...tag) {
+	key := s.metric(name, tags...)
+	s.mu.Lock()
+	s.counters[key]++
+	s.mu.Unlock()
+}
+
+func (s *StatsD) gauge(name string, value float64, tags ...tag) {
+	key := s.metric(name, tags...)
+	s.mu.Lock()
+	s.gauges[key] = value
+	s.mu.Unlock()
+}
+
+func (s *StatsD) timing(name string, duration time.Duration, tags ...tag) {
+	ms := strconv.FormatFloat(float64(duration.Microseconds())/1000, 'f', -1, 64)
+	s.send([]string{line(s.metric(name, tags...), ms, "ms")})
+}
+
+// send writes lines in as few datagrams as fit. StatsD is fire and forget, so
+// write errors, such as no agent listening, are ignored.
+func (s *StatsD) send(lines []string) {
+	var packet []byte
+	for _, l := range lines {
+		if len(packet) > 0 && len(packet)+1+len(l) > maxStatsDPacket {
+			_, _ = s.conn.Write(packet)
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, l...)
+	}
+	if len(packet) > 0 {
+		_, _ = s.conn.Write(packet)
+	}
+}
+
+// Flush sends the counts recorded since the last flush and the current gauges,
+// in name order
+func (s *StatsD) Flush() {
+	s.mu.Lock()
+	lines := make([]string, 0, len(s.counters)+len(s.gauges))
+	for key, count := range s.counters {
+		lines = append(lines, line(key, strconv.FormatInt(count, 10), "c"))
+	}
+	clear(s.counters)
+	for key, value := range s.gauges {
+		lines = append(lines, line(key, strconv.FormatFloat(value, 'f', -1, 64), "g"))
+	}
+	s.mu.Unlock()
+
+	slices.Sort(lines)
+	s.send(lines)
+}
+
+// Close stops the periodic flush, sends what is left and closes the connection
+func (s *StatsD) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.Flush()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// RecordRequest records an HTTP request; the trace in ctx is not sent
+func (s *StatsD) RecordRequest(_ context.Context, method, endpoint, statusCode string, duration time.Duration) {
+	s.count("http_requests_total", tag{"method", method}, tag{"endpoint", endpoint}, tag{"status_code", statusCode})
+	s.timing("http_request_duration_ms", duration, tag{"method", method}, tag{"endpoint", endpoint})
+}
+
+// RecordRequestSLO counts a request to route in its SLO class. The error ratio is
+// left to the agent's backend, which can compute it from these counts.
+func (s *StatsD) RecordRequestSLO(route, class string) {
+	s.count("http_requests_slo_total", tag{"route", route}, tag{"class", class})
+}
+
+// RecordRequestInFlight tracks requests currently being processed on route
+func (s *StatsD) RecordRequestInFlight(route string, delta float64) {
+	key := s.metric("http_requests_in_flight", tag{"route", route})
+	s.mu.Lock()
+	s.gauges[key] += delta
+	s.mu.Unlock()
+}
+
+// RecordRequestRejected records a request to route turned away for reason
+func (s *StatsD) RecordRequestRejected(route, reason string) {
+	s.count("requests_rejected_total", tag{"route", route}, tag{"reason", reason})
+}
+
+// RecordQueueDepth tracks requests waiting for route's concurrency limit
+func (s *StatsD) RecordQueueDepth(route string, delta float64) {
+	key := s.metric("request_queue_depth", tag{"route", route})
+	s.mu.Lock()
+	s.gauges[key] += delta
+	s.mu.Unlock()
+}
+
+// RecordQueueWait records how long a request waited for route's concurrency limit
+func (s *StatsD) RecordQueueWait(route string, wait time.Duration) {
+	s.timing("request_queue_wait_ms", wait, tag{"route", route})
+}
+
+// RecordInternalRequest records a request to path that is left out of the request metrics
+func (s *StatsD) RecordInternalRequest(path string) {
+	s.count("internal_requests_total", tag{"path", path})
+}
+
+// RecordClientDisconnect records a request to route whose client went away
+func (s *StatsD) RecordClientDisconnect(route string) {
+	s.count("client_disconnects_total", tag{"route", route})
+}
+
+// RecordRPC records a gRPC call to method ending with status code
+func (s *StatsD) RecordRPC(method, code string, duration time.Duration) {
+	s.count("grpc_requests_total", tag{"method", method}, tag{"code", code})
+	s.timing("grpc_request_duration_ms", duration, tag{"method", method})
+}
+
+// RecordResolver records how long the GraphQL resolver for field took
+func (s *StatsD) RecordResolver(field string, duration time.Duration) {
+	s.timing("graphql_resolver_duration_ms", duration, tag{"field", field})
+}
+
+// SetUsersTotal sets the current number of users with status
+func (s *StatsD) SetUsersTotal(status string, count float64) {
+	s.gauge("users_total", count, tag{"status", status})
+}
+
+// SetDeletedUsersTotal sets the current number of soft-deleted users
+func (s *StatsD) SetDeletedUsersTotal(count float64) {
+	s.gauge("deleted_users_total", count)
+}
+
+// RecordUserLookup records user lookup results
+func (s *StatsD) RecordUserLookup(result string) {
+	s.count("user_lookups_total", tag{"result", result})
+}
+
+// RecordCollapsedQuery records a caller that shared another caller's in-flight query
+func (s *StatsD) RecordCollapsedQuery(query string) {
+	s.count("collapsed_queries_total", tag{"query", query})
+}
+
+// RecordError records application errors
+func (s *StatsD) RecordError(errorType, endpoint string) {
+	s.count("errors_total", tag{"type", errorType}, tag{"endpoint", endpoint})
+}
+
+// RecordEventPublished records the result of delivering an event of eventType
+func (s *StatsD) RecordEventPublished(eventType, status string) {
+	s.count("events_published_total", tag{"type", eventType}, tag{"status", status})
+}
+
+// SetOutboxLag sets how long the oldest unsent event has waited in the outbox
+func (s *StatsD) SetOutboxLag(seconds float64) {
+	s.gauge("outbox_lag_seconds", seconds)
+}
+
+// RecordWebhookDelivery records the outcome of a webhook delivery attempt
+func (s *StatsD) RecordWebhookDelivery(result string) {
+	s.count("webhook_deliveries_total", tag{"result", result})
+}
+
+// RecordExportAborted records a user export cut short because its client went away
+func (s *StatsD) RecordExportAborted() {
+	s.count("exports_aborted_total")
+}
+
+// RecordDBQuery records a statement sent to a database target
+func (s *StatsD) RecordDBQuery(target, result string) {
+	s.count("db_queries_total", tag{"target", target}, tag{"result", result})
+}
+
+// RecordDBQueryDuration records how long a database statement took; the trace in ctx is not sent
+func (s *StatsD) RecordDBQueryDuration(_ context.Context, operation string, duration time.Duration) {
+	s.timing("db_query_duration_ms", duration, tag{"operation", operation})
+}
+
+// RecordDBFallback records a read retried on the primary after a replica failed
+func (s *StatsD) RecordDBFallback() {
+	s.count("db_replica_fallbacks_total")
+}
+
+// RecordDBReconnect records a database connection re-established after it broke
+func (s *StatsD) RecordDBReconnect() {
+	s.count("db_reconnects_total")
+}
+
+// RecordSlowQuery records a repository call that ran past the slow query threshold
+func (s *StatsD) RecordSlowQuery(operation string) {
+	s.count("db_slow_queries_total", tag{"operation", operation})
+}
+
+// RecordDBRetry records a repository read retried after a transient error
+func (s *StatsD) RecordDBRetry(operation string) {
+	s.count("db_retries_total", tag{"operation", operation})
+}
+
+// SetDBPoolConns sets how many connections of the transaction pool are in state
+func (s *StatsD) SetDBPoolConns(state string, count float64) {
+	s.gauge("db_pool_connections", count, tag{"state", state})
+}
+
+// RecordDBPoolAcquireWait records the mean time recent acquires waited for a pooled connection
+func (s *StatsD) RecordDBPoolAcquireWait(wait time.Duration) {
+	s.timing("db_pool_acquire_wait_ms", wait)
+}
+
+// RecordCacheHit records a lookup served from the cache
+func (s *StatsD) RecordCacheHit() {
+	s.count("cache_hits_total")
+}
+
+// RecordCacheMiss records a lookup that had to go to the database
+func (s *StatsD) RecordCacheMiss() {
+	s.count("cache_misses_total")
+}
+
+// RecordCacheError records a cache operation that failed
+func (s *StatsD) RecordCacheError() {
+	s.count("cache_errors_total")
+}
+
+// RecordRateLimitHit records a request of class rejected by its rate limiter
+func (s *StatsD) RecordRateLimitHit(class string) {
+	s.count("rate_limit_hits_total", tag{"class", class})
+}
+
+// RecordPanicRecovery records panic recoveries
+func (s *StatsD) RecordPanicRecovery() {
+	s.count("panic_recoveries_total")
+}
+
+// UpdateLastRequestTime sets the last request timestamp, in Unix seconds
+func (s *StatsD) UpdateLastRequestTime() {
+	s.gauge("last_request_time_seconds", float64(time.Now().Unix()))
+}
@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn records the datagrams a StatsD sends
+type fakeConn struct {
+	mu      sync.Mutex
+	packets []string
+	closed  bool
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets = append(c.packets, string(p))
+	return len(p), nil
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// lines returns every line sent so far, in order
+func (c *fakeConn) lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var lines []string
+	for _, packet := range c.packets {
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	return lines
+}
+
+func TestStatsDWireFormat(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		record func(s *StatsD)
+		want   []string
+	}{
+		{"record request", func(s *StatsD) { s.RecordRequest(ctx, "GET", "/users", "200", 20*time.Millisecond) }, []string{
+			"http_request_duration_ms:20|ms|#method:GET,endpoint:/users",
+			"http_requests_total:1|c|#method:GET,endpoint:/users,status_code:200",
+		}},
+		{"record request slo", func(s *StatsD) { s.RecordRequestSLO("GET /users", "server_error") }, []string{
+			"http_requests_slo_total:1|c|#route:GET /users,class:server_error",
+		}},
+		{"record request in flight", func(s *StatsD) {
+			s.RecordRequestInFlight("GET /users/export", 1)
+			s.RecordRequestInFlight("GET /users/export", 1)
+			s.RecordRequestInFlight("GET /users/export", -1)
+		}, []string{"http_requests_in_flight:1|g|#route:GET /users/export"}},
+		{"record internal request", func(s *StatsD) { s.RecordInternalRequest("/metrics") }, []string{"internal_requests_total:1|c|#path:/metrics"}},
+		{"record client disconnect", func(s *StatsD) { s.RecordClientDisconnect("GET /users") }, []string{"client_disconnects_total:1|c|#route:GET /users"}},
+		{"record request rejected", func(s *StatsD) { s.RecordRequestRejected("GET /users/export", "concurrency") }, []string{
+			"requests_rejected_total:1|c|#route:GET /users/export,reason:concurrency",
+		}},
+		{"record queue depth", func(s *StatsD) {
+			s.RecordQueueDepth("GET /users/export", 1)
+			s.RecordQueueDepth("GET /users/export", 1)
+			s.RecordQueueDepth("GET /users/export", -1)
+		}, []string{"request_queue_depth:1|g|#route:GET /users/export"}},
+		{"record queue wait", func(s *StatsD) { s.RecordQueueWait("GET /users/export", 2*time.Millisecond) }, []string{
+			"request_queue_wait_ms:2|ms|#route:GET /users/export",
+		}},
+		{"record rpc", func(s *StatsD) { s.RecordRPC("/userservice.v1.UserService/GetUser", "OK", 1500*time.Microsecond) }, []string{
+			"grpc_request_duration_ms:1.5|ms|#method:/userservice.v1.UserService/GetUser",
+			"grpc_requests_total:1|c|#method:/userservice.v1.UserService/GetUser,code:OK",
+		}},
+		{"record resolver", func(s *StatsD) { s.RecordResolver("Query.user", 3*time.Millisecond) }, []string{
+			"graphql_resolver_duration_ms:3|ms|#field:Query.user",
+		}},
+		{"set users total", func(s *StatsD) { s.SetUsersTotal("active", 10) }, []string{"users_total:10|g|#status:active"}},
+		{"set deleted users total", func(s *StatsD) { s.SetDeletedUsersTotal(2) }, []string{"deleted_users_total:2|g"}},
+		{"record user lookup", func(s *StatsD) { s.RecordUserLookup("found") }, []string{"user_lookups_total:1|c|#result:found"}},
+		{"record collapsed query", func(s *StatsD) { s.RecordCollapsedQuery("get_user") }, []string{"collapsed_queries_total:1|c|#query:get_user"}},
+		{"record error", func(s *StatsD) { s.RecordError("validation", "/users") }, []string{"errors_total:1|c|#type:validation,endpoint:/users"}},
+		{"record event published", func(s *StatsD) { s.RecordEventPublished("user.created", "success") }, []string{
+			"events_published_total:1|c|#type:user.created,status:success",
+		}},
+		{"set outbox lag", func(s *StatsD) { s.SetOutboxLag(0.25) }, []string{"outbox_lag_seconds:0.25|g"}},
+		{"record webhook delivery", func(s *StatsD) { s.RecordWebhookDelivery("failed") }, []string{"webhook_deliveries_total:1|c|#result:failed"}},
+		{"record export aborted", func(s *StatsD) { s.RecordExportAborted() }, []string{"exports_aborted_total:1|c"}},
+		{"record db query", func(s *StatsD) { s.RecordDBQuery("replica-0", "success") }, []string{"db_queries_total:1|c|#target:replica-0,result:success"}},
+		{"record db query duration", func(s *StatsD) { s.RecordDBQueryDuration(ctx, "select", 250*time.Microsecond) }, []string{
+			"db_query_duration_ms:0.25|ms|#operation:select",
+		}},
+		{"record db fallback", func(s *StatsD) { s.RecordDBFallback() }, []string{"db_replica_fallbacks_total:1|c"}},
+		{"record db reconnect", func(s *StatsD) { s.RecordDBReconnect() }, []string{"db_reconnects_total:1|c"}},
+		{"record slow query", func(s *StatsD) { s.RecordSlowQuery("list") }, []string{"db_slow_queries_total:1|c|#operation:list"}},
+		{"record db retry", func(s *StatsD) { s.RecordDBRetry("get_user") }, []string{"db_retries_total:1|c|#operation:get_user"}},
+		{"set db pool conns", func(s *StatsD) { s.SetDBPoolConns("idle", 3) }, []string{"db_pool_connections:3|g|#state:idle"}},
+		{"record db pool acquire wait", func(s *StatsD) { s.RecordDBPoolAcquireWait(2 * time.Millisecond) }, []string{"db_pool_acquire_wait_ms:2|ms"}},
+		{"record cache hit", func(s *StatsD) { s.RecordCacheHit() }, []string{"cache_hits_total:1|c"}},
+		{"record cache miss", func(s *StatsD) { s.RecordCacheMiss() }, []string{"cache_misses_total:1|c"}},
+		{"record cache error", func(s *StatsD) { s.RecordCacheError() }, []string{"cache_errors_total:1|c"}},
+		{"record rate limit hit", func(s *StatsD) { s.RecordRateLimitHit("write") }, []string{"rate_limit_hits_total:1|c|#class:write"}},
+		{"record panic recovery", func(s *StatsD) { s.RecordPanicRecovery() }, []string{"panic_recoveries_total:1|c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &fakeConn{}
+			s := newStatsD(conn, 0, Options{})
+
+			tt.record(s)
+			s.Flush()
+
+			if got := conn.lines(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected lines %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStatsDUpdateLastRequestTime(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn, 0, Options{})
+
+	before := time.Now().Unix()
+	s.UpdateLastRequestTime()
+	s.Flush()
+
+	lines := conn.lines()
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "last_request_time_seconds:") || !strings.HasSuffix(lines[0], "|g") {
+		t.Fatalf("Expected a last_request_time_seconds gauge, got %q", lines)
+	}
+	value, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(lines[0], "last_request_time_seconds:"), "|g"), 10, 64)
+	if err != nil || value < before || value > time.Now().Unix() {
+		t.Errorf("Expected the current Unix time, got %q", lines[0])
+	}
+}
+
+func TestStatsDAggregatesCounters(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn, 0, Options{})
+
+	s.RecordUserLookup("found")
+	s.RecordUserLookup("found")
+	s.RecordUserLookup("not_found")
+	if len(conn.packets) != 0 {
+		t.Fatalf("Expected counters to wait for the flush, got %q", conn.packets)
+	}
+	s.Flush()
+
+	want := []string{"user_lookups_total:1|c|#result:not_found", "user_lookups_total:2|c|#result:found"}
+	if got := conn.lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected lines %q, got %q", want, got)
+	}
+	if len(conn.packets) != 1 {
+		t.Errorf("Expected the flush to fit one datagram, got %d", len(conn.packets))
+	}
+
+	// Counters start over after a flush; gauges keep their value
+	s.SetDeletedUsersTotal(3)
+	s.Flush()
+	s.Flush()
+	want = append(want, "deleted_users_total:3|g", "deleted_users_total:3|g")
+	if got := conn.lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected lines %q, got %q", want, got)
+	}
+}
+
+func TestStatsDNamespaceAndTags(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn, 0, Options{Namespace: "acme", Subsystem: "users"})
+
+	s.RecordError("bad|type,#x", "/users\n")
+	s.RecordCacheHit()
+	s.Flush()
+
+	want := []string{"acme_users_cache_hits_total:1|c", "acme_users_errors_total:1|c|#type:bad_type__x,endpoint:/users_"}
+	if got := conn.lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected lines %q, got %q", want, got)
+	}
+}
+
+func TestStatsDSplitsPackets(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn, 0, Options{})
+
+	for i := range 100 {
+		s.RecordRateLimitHit(strings.Repeat("x", i))
+	}
+	s.Flush()
+
+	if len(conn.packets) < 2 {
+		t.Fatalf("Expected the flush to need several datagrams, got %d", len(conn.packets))
+	}
+	for i, packet := range conn.packets {
+		if len(packet) > maxStatsDPacket {
+			t.Errorf("Datagram %d is %d bytes, over %d", i, len(packet), maxStatsDPacket)
+		}
+	}
+	if lines := conn.lines(); len(lines) != 100 {
+		t.Errorf("Expected 100 lines, got %d", len(lines))
+	}
+}
+
+func TestStatsDClose(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn, time.Hour, Options{})
+
+	s.RecordCacheMiss()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if want := []string{"cache_misses_total:1|c"}; !reflect.DeepEqual(conn.lines(), want) {
+		t.Errorf("Expected Close to flush %q, got %q", want, conn.lines())
+	}
+	if !conn.closed {
+		t.Error("Expected Close to close the connection")
+	}
+	// A second Close is a no-op
+	if err := s.Close(); err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+}
+
+func TestStatsDFlushesPeriodically(t *testing.T) {
+	conn := &fakeConn{}
+	s := newStatsD(conn, 10*time.Millisecond, Options{})
+	defer s.Close()
+
+	s.RecordCacheHit()
+
+	deadline := time.Now().Add(time.Second)
+	for len(conn.lines()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if want := []string{"cache_hits_total:1|c"}; !reflect.DeepEqual(conn.lines(), want) {
+		t.Errorf("Expected a periodic flush of %q, got %q", want, conn.lines())
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Window counts events and failures over a sliding window of fixed-width buckets,
+// such as the last 5 minutes in 10 second steps. The oldest bucket is dropped as
+// a new one starts, so the ratio moves in steps of one bucket width. It is safe
+// for concurrent use.
+type Window struct {
+	width time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets []windowBucket
+}
+
+// windowBucket counts the events in one step of width, numbered since the Unix epoch
+type windowBucket struct {
+	step           int64
+	total, failing uint64
+}
+
+// NewWindow creates a window spanning size, split into buckets steps
+func NewWindow(size time.Duration, buckets int) *Window {
+	return &Window{
+		width:   size / time.Duration(buckets),
+		now:     time.Now,
+		buckets: make([]windowBucket, buckets),
+	}
+}
+
+// Add counts an event, as a failure if failed
+func (w *Window) Add(failed bool) {
+	step := w.now().UnixNano() / int64(w.width)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[step%int64(len(w.buckets))]
+	if b.step != step {
+		// The bucket last counted a step that has left the window
+		*b = windowBucket{step: step}
+	}
+	b.total++
+	if failed {
+		b.failing++
+	}
+}
+
+// Ratio returns the share of the events in the window that failed, and 0 when
+// there were none
+func (w *Window) Ratio() float64 {
+	step := w.now().UnixNano() / int64(w.width)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var total, failing uint64
+	for _, b := range w.buckets {
+		if step-b.step < int64(len(w.buckets)) {
+			total += b.total
+			failing += b.failing
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(failing) / float64(total)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newWindow := func() *Window {
+		w := NewWindow(time.Minute, 6)
+		w.now = func() time.Time { return now }
+		return w
+	}
+
+	t.Run("empty window", func(t *testing.T) {
+		if got := newWindow().Ratio(); got != 0 {
+			t.Errorf("Expected a ratio of 0 without events, got %v", got)
+		}
+	})
+
+	t.Run("counts failures across buckets", func(t *testing.T) {
+		w := newWindow()
+		w.Add(false)
+		w.Add(true)
+		now = now.Add(10 * time.Second)
+		w.Add(false)
+		w.Add(false)
+
+		if got := w.Ratio(); got != 0.25 {
+			t.Errorf("Expected a ratio of 0.25, got %v", got)
+		}
+	})
+
+	t.Run("drops buckets as they leave the window", func(t *testing.T) {
+		w := newWindow()
+		w.Add(true)
+		now = now.Add(30 * time.Second)
+		w.Add(false)
+
+		// The failure is still 50 seconds old
+		now = now.Add(20 * time.Second)
+		if got := w.Ratio(); got != 0.5 {
+			t.Errorf("Expected a ratio of 0.5 within the window, got %v", got)
+		}
+
+		// A minute on, its bucket has left the window
+		now = now.Add(10 * time.Second)
+		if got := w.Ratio(); got != 0 {
+			t.Errorf("Expected the failure to have left the window, got %v", got)
+		}
+
+		// Its slot in the ring is reused for the current step
+		w.Add(true)
+		if got := w.Ratio(); got != 0.5 {
+			t.Errorf("Expected a ratio of 0.5 after reusing the slot, got %v", got)
+		}
+	})
+
+	t.Run("forgets everything after a quiet spell", func(t *testing.T) {
+		w := newWindow()
+		w.Add(true)
+		now = now.Add(time.Hour)
+
+		if got := w.Ratio(); got != 0 {
+			t.Errorf("Expected a ratio of 0 after an hour, got %v", got)
+		}
+	})
+}
+
+func TestWindowConcurrentAdds(t *testing.T) {
+	w := NewWindow(time.Hour, 60)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 1000 {
+				// One in four events fails
+				w.Add((i+j)%4 == 0)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := w.Ratio(); got != 0.25 {
+		t.Errorf("Expected a ratio of 0.25 after concurrent adds, got %v", got)
+	}
+}
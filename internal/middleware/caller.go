@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"user-service/internal/httputil"
+	"user-service/internal/reqctx"
+)
+
+// Authenticate middleware identifies callers presenting "Authorization: Bearer <adminToken>"
+// as AdminActor with reqctx.AdminRole, and callers presenting one of userTokens as
+// the subject it maps to with reqctx.UserRole, for handlers to read with
+// reqctx.CallerFromContext. Other requests pass through anonymously; routes needing
+// a caller reject them themselves.
+func Authenticate(adminToken string, userTokens map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := authenticate(r, adminToken)
+			if !ok {
+				caller, ok = authenticateUser(r, userTokens)
+			}
+			if ok {
+				r = r.WithContext(reqctx.WithCaller(r.Context(), caller))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate returns the caller identified by the request's bearer token. An
+// empty adminToken identifies no one.
+func authenticate(r *http.Request, adminToken string) (reqctx.Caller, bool) {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || adminToken == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(adminToken)) != 1 {
+		return reqctx.Caller{}, false
+	}
+	return reqctx.Caller{Subject: AdminActor, Roles: []string{reqctx.AdminRole}}, true
+}
+
+// authenticateUser returns the caller whose user token the request bears. Every
+// token is compared, in constant time, so the time taken does not tell how much of
+// one a guess got right or which one it matched.
+func authenticateUser(r *http.Request, userTokens map[string]string) (reqctx.Caller, bool) {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || presented == "" {
+		return reqctx.Caller{}, false
+	}
+	var subject string
+	for token, tokenSubject := range userTokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			subject = tokenSubject
+		}
+	}
+	if subject == "" {
+		return reqctx.Caller{}, false
+	}
+	return reqctx.Caller{Subject: subject, Roles: []string{reqctx.UserRole}}, true
+}
+
+// RequireRole middleware restricts a route to callers holding role. Anonymous
+// requests get 401, and authenticated callers without the role 403.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := reqctx.CallerFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				httputil.Error(r.Context(), w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !caller.HasRole(role) {
+				requestID := reqctx.RequestIDFromContext(r.Context())
+				slog.Warn("Rejected request lacking role", "role", role, "caller", caller.Subject, "path", r.URL.Path, "request_id", requestID)
+				httputil.Error(r.Context(), w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
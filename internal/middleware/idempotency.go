@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"user-service/internal/cache"
+	"user-service/internal/httputil"
+	"user-service/internal/reqctx"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header, as the keys are stored
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentRequestBytes bounds how much of a body is read to fingerprint it
+const maxIdempotentRequestBytes = 1 << 20
+
+// IdempotentResponse is a response kept for replaying under its Idempotency-Key,
+// along with a fingerprint of the request that produced it
+type IdempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Idempotency middleware makes retries of a request sent with an Idempotency-Key
+// header safe: the first response under a key is kept in store, and a repeat of the
+// request with the same key gets that response back, marked "Idempotent-Replayed:
+// true", instead of running again. Keys are scoped to the caller, method and path.
+// Reusing a key for a different body is rejected with 422, and a repeat arriving
+// while the first request is still running with 409. Server errors are not kept,
+// so those requests can be retried. Requests without the header pass through.
+func Idempotency(store cache.Cache[string, IdempotentResponse]) func(http.Handler) http.Handler {
+	var (
+		mu       sync.Mutex
+		inFlight = make(map[string]struct{})
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get("Idempotency-Key")
+			if idempotencyKey == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			requestID := reqctx.RequestIDFromContext(r.Context())
+			if len(idempotencyKey) > maxIdempotencyKeyLength {
+				httputil.Error(r.Context(), w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			caller, _ := reqctx.CallerFromContext(r.Context())
+			key := caller.Subject + " " + r.Method + " " + r.URL.Path + " " + idempotencyKey
+
+			// The body is fingerprinted and handed on unchanged
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentRequestBytes))
+			if err != nil {
+				httputil.Error(r.Context(), w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			mu.Lock()
+			_, running := inFlight[key]
+			if !running {
+				inFlight[key] = struct{}{}
+			}
+			mu.Unlock()
+			if running {
+				httputil.Error(r.Context(), w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+				return
+			}
+			defer func() {
+				mu.Lock()
+				delete(inFlight, key)
+				mu.Unlock()
+			}()
+
+			// An unreachable store only loses the protection against duplicates
+			stored, ok, err := store.Get(r.Context(), key)
+			if err != nil {
+				slog.Warn("Failed to look up idempotency key", "error", err, "request_id", requestID)
+			}
+			if ok {
+				if stored.Fingerprint != fingerprint {
+					httputil.Error(r.Context(), w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+					return
+				}
+				slog.Info("Replaying response for idempotency key", "status", stored.Status, "request_id", requestID)
+				for name, values := range stored.Header {
+					w.Header()[name] = append([]string(nil), values...)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				_, _ = w.Write(stored.Body)
+				return
+			}
+
+			rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if rec.status < http.StatusInternalServerError {
+				response := IdempotentResponse{Fingerprint: fingerprint, Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+				// Kept even if the client went away, as that is when it retries
+				if err := store.Set(context.WithoutCancel(r.Context()), key, response); err != nil {
+					slog.Warn("Failed to store idempotent response", "error", err, "request_id", requestID)
+				}
+			}
+
+			for name, values := range rec.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"user-service/internal/cache"
+	"user-service/internal/reqctx"
+)
+
+// failingStore is an idempotency store that cannot be reached
+type failingStore struct{}
+
+func (failingStore) Get(context.Context, string) (IdempotentResponse, bool, error) {
+	return IdempotentResponse{}, false, errors.New("connection refused")
+}
+
+func (failingStore) Set(context.Context, string, IdempotentResponse) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Delete(context.Context, string) error {
+	return errors.New("connection refused")
+}
+
+func TestIdempotency(t *testing.T) {
+	// creating echoes the body it was sent, numbered by how often it ran
+	newHandler := func(store cache.Cache[string, IdempotentResponse]) (http.Handler, *atomic.Int32) {
+		var runs atomic.Int32
+		return Idempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := runs.Add(1)
+			body, _ := io.ReadAll(r.Body)
+			if string(body) == "fail" {
+				http.Error(w, "failed", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"run":%d,"body":%q}`, n, body)
+		})), &runs
+	}
+	newStore := func() cache.Cache[string, IdempotentResponse] {
+		return cache.NewMemory[string, IdempotentResponse](100, time.Hour)
+	}
+	post := func(handler http.Handler, key, body string, caller *reqctx.Caller) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		if caller != nil {
+			req = req.WithContext(reqctx.WithCaller(req.Context(), *caller))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("replays the response to a repeated key", func(t *testing.T) {
+		handler, runs := newHandler(newStore())
+
+		first := post(handler, "k1", "ada", nil)
+		second := post(handler, "k1", "ada", nil)
+
+		if runs.Load() != 1 {
+			t.Errorf("Expected the handler to run once, ran %d times", runs.Load())
+		}
+		if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+			t.Errorf("Expected the original 201 %s, got %d %s", first.Body.String(), second.Code, second.Body.String())
+		}
+		if second.Header().Get("Content-Type") != "application/json" || second.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("Expected the original headers marked as replayed, got %v", second.Header())
+		}
+		if first.Header().Get("Idempotent-Replayed") != "" {
+			t.Error("Expected the first response not to be marked as replayed")
+		}
+	})
+
+	t.Run("runs every request without a key or under another key", func(t *testing.T) {
+		handler, runs := newHandler(newStore())
+
+		post(handler, "", "ada", nil)
+		post(handler, "", "ada", nil)
+		post(handler, "k1", "ada", nil)
+		post(handler, "k2", "ada", nil)
+
+		if runs.Load() != 4 {
+			t.Errorf("Expected the handler to run 4 times, ran %d times", runs.Load())
+		}
+	})
+
+	t.Run("rejects a key reused for another body", func(t *testing.T) {
+		handler, runs := newHandler(newStore())
+
+		post(handler, "k1", "ada", nil)
+		rr := post(handler, "k1", "grace", nil)
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+		}
+		if runs.Load() != 1 {
+			t.Errorf("Expected the handler to run once, ran %d times", runs.Load())
+		}
+	})
+
+	t.Run("scopes keys to the caller", func(t *testing.T) {
+		handler, runs := newHandler(newStore())
+
+		post(handler, "k1", "ada", &reqctx.Caller{Subject: "alice"})
+		post(handler, "k1", "ada", &reqctx.Caller{Subject: "bob"})
+
+		if runs.Load() != 2 {
+			t.Errorf("Expected the handler to run for each caller, ran %d times", runs.Load())
+		}
+	})
+
+	t.Run("lets a server error be retried", func(t *testing.T) {
+		handler, runs := newHandler(newStore())
+
+		if rr := post(handler, "k1", "fail", nil); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+		post(handler, "k1", "fail", nil)
+
+		if runs.Load() != 2 {
+			t.Errorf("Expected the retry to run again, ran %d times", runs.Load())
+		}
+	})
+
+	t.Run("conflicts with a request still running under the key", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		handler := Idempotency(newStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- post(handler, "k1", "ada", nil) }()
+		<-started
+		rr := post(handler, "k1", "ada", nil)
+		close(release)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, rr.Code)
+		}
+		if first := <-done; first.Code != http.StatusCreated {
+			t.Errorf("Expected the first request to finish with %d, got %d", http.StatusCreated, first.Code)
+		}
+	})
+
+	t.Run("rejects a key that is too long", func(t *testing.T) {
+		handler, runs := newHandler(newStore())
+
+		rr := post(handler, strings.Repeat("k", maxIdempotencyKeyLength+1), "ada", nil)
+
+		if rr.Code != http.StatusBadRequest || runs.Load() != 0 {
+			t.Errorf("Expected status %d without running, got %d after %d runs", http.StatusBadRequest, rr.Code, runs.Load())
+		}
+	})
+
+	t.Run("runs requests when the store fails", func(t *testing.T) {
+		handler, runs := newHandler(failingStore{})
+
+		first := post(handler, "k1", "ada", nil)
+		post(handler, "k1", "ada", nil)
+
+		if first.Code != http.StatusCreated || runs.Load() != 2 {
+			t.Errorf("Expected both requests to run, got %d after %d runs", first.Code, runs.Load())
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"user-service/internal/reqctx"
+)
+
+// maxMicroCacheEntries bounds how many distinct path and query pairs are kept
+const maxMicroCacheEntries = 1024
+
+// cachedResponse is a rendered 200 response and when it goes stale
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// MicroCache keeps whole 200 responses to GET and HEAD requests for a short
+// time, keyed by path and query, so bursts of identical requests are answered
+// from memory and concurrent misses render the response once. Admin callers and
+// requests sent with "Cache-Control: no-cache" bypass it. Responses carry an
+// X-Cache header of HIT, MISS or BYPASS.
+type MicroCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+	// generation counts invalidations, so a response rendered before one is not stored after it
+	generation uint64
+
+	renders singleflight.Group
+}
+
+// NewMicroCache creates a cache keeping responses for ttl. A zero ttl disables it.
+func NewMicroCache(ttl time.Duration) *MicroCache {
+	return &MicroCache{ttl: ttl, now: time.Now, entries: make(map[string]cachedResponse)}
+}
+
+// Invalidate drops every cached response, such as after a mutation
+func (c *MicroCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.generation++
+}
+
+// Wrap caches the responses of next
+func (c *MicroCache) Wrap(next http.Handler) http.Handler {
+	if c.ttl <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if caller, ok := reqctx.CallerFromContext(r.Context()); ok && caller.HasRole(reqctx.AdminRole) {
+			w.Header().Set("X-Cache", "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.URL.Path + "?" + r.URL.RawQuery
+		fresh := strings.Contains(r.Header.Get("Cache-Control"), "no-cache")
+		if !fresh {
+			if cached, ok := c.get(key); ok {
+				c.write(w, "HIT", http.StatusOK, cached.header, cached.body)
+				return
+			}
+		}
+
+		render := func() (interface{}, error) {
+			c.mu.Lock()
+			generation := c.generation
+			c.mu.Unlock()
+
+			rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if rec.status == http.StatusOK {
+				c.put(key, generation, cachedResponse{header: rec.header, body: rec.body.Bytes(), expires: c.now().Add(c.ttl)})
+			}
+			return rec, nil
+		}
+		var v interface{}
+		if fresh {
+			v, _ = render()
+		} else {
+			v, _, _ = c.renders.Do(key, render)
+		}
+		rec := v.(*bufferedResponse)
+		status := "MISS"
+		if fresh {
+			status = "BYPASS"
+		}
+		c.write(w, status, rec.status, rec.header, rec.body.Bytes())
+	})
+}
+
+// get returns the unexpired response cached under key
+func (c *MicroCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[key]
+	if !ok || !c.now().Before(cached.expires) {
+		return cachedResponse{}, false
+	}
+	return cached, true
+}
+
+// put caches response under key unless the cache was invalidated since generation.
+// Once the cache is full, expired responses are dropped and, failing that, the
+// response is not kept.
+func (c *MicroCache) put(key string, generation uint64, response cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxMicroCacheEntries {
+		now := c.now()
+		maps.DeleteFunc(c.entries, func(_ string, cached cachedResponse) bool {
+			return !now.Before(cached.expires)
+		})
+		if len(c.entries) >= maxMicroCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = response
+}
+
+// write sends a rendered response, which may be shared between requests
+func (c *MicroCache) write(w http.ResponseWriter, cacheStatus string, status int, header http.Header, body []byte) {
+	for name, values := range header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("X-Cache", cacheStatus)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// bufferedResponse holds a response in memory, with headers of its own so those
+// set by outer middleware are not captured
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status, b.wroteHeader = status, true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"user-service/internal/reqctx"
+)
+
+// countingHandler numbers each response it renders
+func countingHandler(renders *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := renders.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"render":%d}`, n)
+	})
+}
+
+func TestMicroCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newCache := func() (*MicroCache, http.Handler, *atomic.Int32) {
+		cache := NewMicroCache(2 * time.Second)
+		cache.now = func() time.Time { return now }
+		var renders atomic.Int32
+		return cache, cache.Wrap(countingHandler(&renders)), &renders
+	}
+	serve := func(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(t *testing.T, rr *httptest.ResponseRecorder, cacheStatus, body string) {
+		t.Helper()
+		if got := rr.Header().Get("X-Cache"); got != cacheStatus {
+			t.Errorf("Expected X-Cache %s, got %q", cacheStatus, got)
+		}
+		if rr.Body.String() != body {
+			t.Errorf("Expected body %s, got %s", body, rr.Body.String())
+		}
+	}
+
+	t.Run("serves repeated requests from memory", func(t *testing.T) {
+		_, handler, _ := newCache()
+
+		expect(t, serve(handler, httptest.NewRequest("GET", "/users?role=admin", nil)), "MISS", `{"render":1}`)
+		rr := serve(handler, httptest.NewRequest("GET", "/users?role=admin", nil))
+		expect(t, rr, "HIT", `{"render":1}`)
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Expected the cached Content-Type, got %q", got)
+		}
+		// Another query is another entry
+		expect(t, serve(handler, httptest.NewRequest("GET", "/users?role=user", nil)), "MISS", `{"render":2}`)
+	})
+
+	t.Run("expires responses after the ttl", func(t *testing.T) {
+		cache, handler, _ := newCache()
+
+		serve(handler, httptest.NewRequest("GET", "/users", nil))
+		cache.now = func() time.Time { return now.Add(1999 * time.Millisecond) }
+		expect(t, serve(handler, httptest.NewRequest("GET", "/users", nil)), "HIT", `{"render":1}`)
+		cache.now = func() time.Time { return now.Add(2 * time.Second) }
+		expect(t, serve(handler, httptest.NewRequest("GET", "/users", nil)), "MISS", `{"render":2}`)
+	})
+
+	t.Run("drops every response when invalidated", func(t *testing.T) {
+		cache, handler, _ := newCache()
+
+		serve(handler, httptest.NewRequest("GET", "/users", nil))
+		cache.Invalidate()
+		expect(t, serve(handler, httptest.NewRequest("GET", "/users", nil)), "MISS", `{"render":2}`)
+	})
+
+	t.Run("does not keep a response rendered before an invalidation", func(t *testing.T) {
+		cache := NewMicroCache(time.Minute)
+		var renders atomic.Int32
+		handler := cache.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if renders.Add(1) == 1 {
+				cache.Invalidate()
+			}
+			w.Write([]byte("users"))
+		}))
+
+		serve(handler, httptest.NewRequest("GET", "/users", nil))
+		if rr := serve(handler, httptest.NewRequest("GET", "/users", nil)); rr.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("Expected the stale render to be discarded, got X-Cache %q", rr.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("bypasses admin callers and no-cache requests", func(t *testing.T) {
+		_, handler, renders := newCache()
+		serve(handler, httptest.NewRequest("GET", "/users", nil))
+
+		admin := httptest.NewRequest("GET", "/users", nil)
+		admin = admin.WithContext(reqctx.WithCaller(admin.Context(), reqctx.Caller{Subject: AdminActor, Roles: []string{reqctx.AdminRole}}))
+		expect(t, serve(handler, admin), "BYPASS", `{"render":2}`)
+
+		noCache := httptest.NewRequest("GET", "/users", nil)
+		noCache.Header.Set("Cache-Control", "no-cache")
+		expect(t, serve(handler, noCache), "BYPASS", `{"render":3}`)
+		// The fresh response replaces the cached one
+		expect(t, serve(handler, httptest.NewRequest("GET", "/users", nil)), "HIT", `{"render":3}`)
+
+		if got := renders.Load(); got != 3 {
+			t.Errorf("Expected 3 renders, got %d", got)
+		}
+	})
+
+	t.Run("only caches successful GETs", func(t *testing.T) {
+		_, handler, renders := newCache()
+
+		for range 2 {
+			if rr := serve(handler, httptest.NewRequest("GET", "/users?fail=1", nil)); rr.Code != http.StatusInternalServerError {
+				t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+			}
+			serve(handler, httptest.NewRequest("POST", "/users", nil))
+		}
+		if got := renders.Load(); got != 4 {
+			t.Errorf("Expected every request to render, got %d renders", got)
+		}
+	})
+
+	t.Run("renders concurrent misses once", func(t *testing.T) {
+		cache := NewMicroCache(time.Minute)
+		release := make(chan struct{})
+		var renders atomic.Int32
+		handler := cache.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			renders.Add(1)
+			<-release
+			w.Write([]byte("users"))
+		}))
+
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Go(func() {
+				if rr := serve(handler, httptest.NewRequest("GET", "/users", nil)); rr.Body.String() != "users" {
+					t.Errorf("Expected the shared response, got %q", rr.Body.String())
+				}
+			})
+		}
+		// Let the requests queue up behind the first render
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if got := renders.Load(); got != 1 {
+			t.Errorf("Expected 1 render, got %d", got)
+		}
+	})
+
+	t.Run("a zero ttl disables caching", func(t *testing.T) {
+		var renders atomic.Int32
+		handler := NewMicroCache(0).Wrap(countingHandler(&renders))
+
+		serve(handler, httptest.NewRequest("GET", "/users", nil))
+		if rr := serve(handler, httptest.NewRequest("GET", "/users", nil)); rr.Header().Get("X-Cache") != "" || renders.Load() != 2 {
+			t.Errorf("Expected no caching, got X-Cache %q after %d renders", rr.Header().Get("X-Cache"), renders.Load())
+		}
+	})
+}
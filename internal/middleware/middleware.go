@@ -1,47 +1,110 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/time/rate"
+	"user-service/internal/httputil"
 	"user-service/internal/metrics"
+	"user-service/internal/reqctx"
+	"user-service/internal/router"
 )
 
-// Logging middleware
-func Logging() func(http.Handler) http.Handler {
+// QueryLogging says whether request logs include the query string, and which of
+// its parameters, matched regardless of case, have their values replaced by ***
+type QueryLogging struct {
+	Enabled bool
+	Redact  []string
+}
+
+// Logging middleware logs every request once it completes, at warn level when it
+// took longer than slowThreshold, with its query string if query is enabled.
+// Requests for the skipped paths, such as metric scrapes, are not logged. The
+// exempt route patterns, such as streams, are expected to run long and always log
+// at info. A slowThreshold of zero or less never warns.
+func Logging(slowThreshold time.Duration, skip []string, query QueryLogging, exempt ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(skip, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			start := time.Now()
 			wrapper := &responseWriterWrapper{ResponseWriter: w, statusCode: http.StatusOK}
 			next.ServeHTTP(wrapper, r)
 			duration := time.Since(start)
 
-			requestID, _ := r.Context().Value(RequestIDKey).(string)
+			requestID := reqctx.RequestIDFromContext(r.Context())
+			caller, _ := reqctx.CallerFromContext(r.Context())
 
-			slog.Info("request completed",
+			level := slog.LevelInfo
+			if slowThreshold > 0 && duration > slowThreshold && !slices.Contains(exempt, router.Pattern(r)) {
+				level = slog.LevelWarn
+			}
+			attrs := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", wrapper.statusCode,
 				"duration", duration,
 				"remote_addr", r.RemoteAddr,
 				"request_id", requestID,
-			)
+				"caller", caller.Subject,
+			}
+			if query.Enabled && r.URL.RawQuery != "" {
+				attrs = append(attrs, "query", redactQuery(r.URL.RawQuery, query.Redact))
+			}
+			slog.Log(r.Context(), level, "request completed", attrs...)
 		})
 	}
 }
 
-// Metrics middleware
-func Metrics(metricsCollector *metrics.Metrics) func(http.Handler) http.Handler {
+// redactQuery returns rawQuery with the values of the redact parameters replaced
+// by ***, keeping the order and encoding of the rest
+func redactQuery(rawQuery string, redact []string) string {
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if slices.ContainsFunc(redact, func(param string) bool { return strings.EqualFold(param, name) }) {
+			pairs[i] = key + "=***"
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Metrics middleware records the count, duration and status of every request by
+// route. Requests for the skipped paths, such as metric scrapes that would otherwise
+// show up in their own payload, are only counted in internal_requests_total.
+func Metrics(metricsCollector metrics.Recorder, skip ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(skip, r.URL.Path) {
+				metricsCollector.RecordInternalRequest(r.URL.Path)
+				next.ServeHTTP(w, r)
+				return
+			}
 			start := time.Now()
+			endpoint := router.Pattern(r)
+			if endpoint == "" {
+				endpoint = r.URL.Path
+			}
 
 			// Track requests in flight
-			metricsCollector.RecordRequestInFlight(1)
-			defer metricsCollector.RecordRequestInFlight(-1)
+			metricsCollector.RecordRequestInFlight(endpoint, 1)
+			defer metricsCollector.RecordRequestInFlight(endpoint, -1)
 
 			// Update last request time
 			metricsCollector.UpdateLastRequestTime()
@@ -54,24 +117,63 @@ func Metrics(metricsCollector *metrics.Metrics) func(http.Handler) http.Handler
 
 			// Record metrics after request completion
 			duration := time.Since(start)
-			endpoint := r.URL.Path
 			method := r.Method
 			statusCode := strconv.Itoa(wrapper.statusCode)
 
 			// Record request metrics
-			metricsCollector.RecordRequest(method, endpoint, statusCode, duration)
+			metricsCollector.RecordRequest(r.Context(), method, endpoint, statusCode, duration)
+			metricsCollector.RecordRequestSLO(endpoint, metrics.SLOClass(wrapper.statusCode))
+			if httputil.ClientGone(wrapper.writeErr) || errors.Is(r.Context().Err(), context.Canceled) {
+				metricsCollector.RecordClientDisconnect(endpoint)
+			}
 		})
 	}
 }
 
-// RateLimit middleware
-func RateLimit(limiter *rate.Limiter, metricsCollector *metrics.Metrics) func(http.Handler) http.Handler {
+// RateLimiters holds a budget for each class of request
+type RateLimiters struct {
+	// Read limits the safe methods: GET, HEAD and OPTIONS
+	Read *rate.Limiter
+	// Write limits every other method
+	Write *rate.Limiter
+	// Routes limits the route patterns it has, such as "GET /users/export", in
+	// place of their class, so costly routes can have tighter budgets. Routes may
+	// share a limiter.
+	Routes map[string]*rate.Limiter
+}
+
+// RateLimit middleware draws reads and writes from separate limiters, so a burst
+// of writes cannot starve reads, and the routes with a limiter of their own from
+// that one. Requests for the exempt route patterns, such as health checks, are
+// never limited. Rejected requests carry a Retry-After header with the whole
+// seconds until their limiter frees up a token, and are counted with their class,
+// "read" or "write", or with their route when it has its own limiter.
+func RateLimit(limiters RateLimiters, metricsCollector metrics.Recorder, exempt ...string) func(http.Handler) http.Handler {
+	readRetryAfter, writeRetryAfter := retryAfter(limiters.Read), retryAfter(limiters.Write)
+	routeRetryAfter := make(map[string]string, len(limiters.Routes))
+	for route, limiter := range limiters.Routes {
+		routeRetryAfter[route] = retryAfter(limiter)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := router.Pattern(r)
+			if slices.Contains(exempt, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			class, limiter, wait := "write", limiters.Write, writeRetryAfter
+			if routeLimiter, ok := limiters.Routes[route]; ok {
+				class, limiter, wait = route, routeLimiter, routeRetryAfter[route]
+			} else {
+				switch r.Method {
+				case http.MethodGet, http.MethodHead, http.MethodOptions:
+					class, limiter, wait = "read", limiters.Read, readRetryAfter
+				}
+			}
 			if !limiter.Allow() {
-				slog.Warn("Rate limit exceeded", "remote_addr", r.RemoteAddr)
-				metricsCollector.RecordRateLimitHit()
-				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				rateLimited(w, r, class, wait, metricsCollector)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -79,15 +181,274 @@ func RateLimit(limiter *rate.Limiter, metricsCollector *metrics.Metrics) func(ht
 	}
 }
 
-// CORS middleware
-func CORS() func(http.Handler) http.Handler {
+// RouteRateLimit middleware draws every request to the route it wraps from
+// limiter as well, for routes that need a tighter budget than their class, such
+// as ones that could be used to enumerate users. Rejections are counted under class.
+func RouteRateLimit(class string, limiter *rate.Limiter, metricsCollector metrics.Recorder) func(http.Handler) http.Handler {
+	wait := retryAfter(limiter)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+			if !limiter.Allow() {
+				rateLimited(w, r, class, wait, metricsCollector)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-			if r.Method == "OPTIONS" {
+// rateLimited answers a request over the budget of class with a 429, asking the
+// client to retry after wait seconds
+func rateLimited(w http.ResponseWriter, r *http.Request, class, wait string, metricsCollector metrics.Recorder) {
+	slog.Warn("Rate limit exceeded", "class", class, "remote_addr", r.RemoteAddr)
+	metricsCollector.RecordRateLimitHit(class)
+	w.Header().Set("Retry-After", wait)
+	httputil.Error(r.Context(), w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// Queue lets requests beyond a route's concurrency limit wait for a slot rather
+// than being turned away at once: up to Length of them, for at most Timeout each.
+// A zero Queue lets none wait.
+type Queue struct {
+	Length  int
+	Timeout time.Duration
+}
+
+// ConcurrencyLimit middleware lets at most max requests to route run at once, so a
+// pile-up of slow requests such as exports cannot exhaust the service. Requests
+// beyond it wait in queue and get slots in the order they arrived. Those finding
+// the queue full get 503 with "Retry-After: 1" and are counted as rejected for
+// "concurrency", as are those still waiting after its timeout, for "queue_timeout".
+// A max of zero or less leaves route unlimited.
+func ConcurrencyLimit(route string, max int, queue Queue, metricsCollector metrics.Recorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+		slots := make(chan struct{}, max)
+		if queue.Length <= 0 || queue.Timeout <= 0 {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				serveWithin(slots, w, r, next, func() {
+					rejectConcurrent(w, r, route, "concurrency", max, metricsCollector)
+				})
+			})
+		}
+
+		// admitted holds a token for every request running or waiting
+		admitted := make(chan struct{}, max+queue.Length)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case admitted <- struct{}{}:
+				defer func() { <-admitted }()
+			default:
+				rejectConcurrent(w, r, route, "concurrency", max, metricsCollector)
+				return
+			}
+
+			select {
+			case slots <- struct{}{}:
+			default:
+				// Blocked sends on a channel are woken in order, and a freed slot goes
+				// straight to the first of them, so the queue is first in, first out
+				if !waitForSlot(slots, r, route, queue.Timeout, metricsCollector) {
+					if r.Context().Err() == nil {
+						rejectConcurrent(w, r, route, "queue_timeout", max, metricsCollector)
+					}
+					return
+				}
+			}
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// waitForSlot waits up to timeout for a slot in slots, counting the request in
+// route's queue metrics. It reports false if the request gave up, either because
+// the timeout passed or because its client went away.
+func waitForSlot(slots chan struct{}, r *http.Request, route string, timeout time.Duration, metricsCollector metrics.Recorder) bool {
+	metricsCollector.RecordQueueDepth(route, 1)
+	start := time.Now()
+	defer func() {
+		metricsCollector.RecordQueueDepth(route, -1)
+		metricsCollector.RecordQueueWait(route, time.Since(start))
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// Concurrency middleware is a bulkhead: at most max requests run at once across
+// every route but the exempt ones, such as health checks, so a traffic spike
+// cannot exhaust database connections or memory. Requests beyond it get 503 with
+// "Retry-After: 1" and are counted as rejected for "capacity". A max of zero or
+// less leaves requests unlimited.
+func Concurrency(max int, metricsCollector metrics.Recorder, exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+		slots := make(chan struct{}, max)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := router.Pattern(r)
+			if slices.Contains(exempt, route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			serveWithin(slots, w, r, next, func() {
+				rejectConcurrent(w, r, route, "capacity", max, metricsCollector)
+			})
+		})
+	}
+}
+
+// serveWithin serves the request with next if slots has room, holding a slot
+// until next returns or panics, and calls reject otherwise
+func serveWithin(slots chan struct{}, w http.ResponseWriter, r *http.Request, next http.Handler, reject func()) {
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	default:
+		reject()
+	}
+}
+
+// rejectConcurrent answers a request turned away by a concurrency limit of max for reason
+func rejectConcurrent(w http.ResponseWriter, r *http.Request, route, reason string, max int, metricsCollector metrics.Recorder) {
+	requestID := reqctx.RequestIDFromContext(r.Context())
+	slog.Warn("Concurrency limit exceeded", "route", route, "reason", reason, "max", max, "remote_addr", r.RemoteAddr, "request_id", requestID)
+	metricsCollector.RecordRequestRejected(route, reason)
+	w.Header().Set("Retry-After", "1")
+	httputil.Error(r.Context(), w, "too many concurrent requests", http.StatusServiceUnavailable)
+}
+
+// Deadline middleware gives the context of each request a deadline of timeout,
+// which database calls inherit, so a request running late does not start queries
+// it has no time left to answer. The exempt route patterns, such as streams, run
+// as long as their client keeps reading. A timeout of zero or less sets none.
+func Deadline(timeout time.Duration, exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(exempt, router.Pattern(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// retryAfter returns the whole seconds limiter takes to free up a token, at least one
+func retryAfter(limiter *rate.Limiter) string {
+	if limit := limiter.Limit(); limit > 0 && limit < 1 {
+		return strconv.Itoa(int(math.Ceil(1 / float64(limit))))
+	}
+	return "1"
+}
+
+// AdminActor is the actor of requests authorized by the admin token
+const AdminActor = "admin"
+
+// AdminToken middleware restricts a route to callers with reqctx.AdminRole, authenticated
+// by "Authorization: Bearer <token>" here unless Authenticate already has. As with
+// RequireRole, anonymous requests get 401 and authenticated callers without the
+// role 403. An empty token disables the route.
+func AdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				httputil.Error(r.Context(), w, "admin endpoints are disabled", http.StatusForbidden)
+				return
+			}
+
+			caller, ok := reqctx.CallerFromContext(r.Context())
+			if !ok {
+				if caller, ok = authenticate(r, token); ok {
+					r = r.WithContext(reqctx.WithCaller(r.Context(), caller))
+				}
+			}
+			if !ok {
+				requestID := reqctx.RequestIDFromContext(r.Context())
+				slog.Warn("Rejected admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "request_id", requestID)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				httputil.Error(r.Context(), w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !caller.HasRole(reqctx.AdminRole) {
+				requestID := reqctx.RequestIDFromContext(r.Context())
+				slog.Warn("Rejected admin request lacking role", "caller", caller.Subject, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "request_id", requestID)
+				httputil.Error(r.Context(), w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FieldCase middleware sets the case of the keys in JSON responses, httputil.SnakeCase
+// or httputil.CamelCase, for handlers to honor with httputil.InFieldCase. Requests
+// for an API version in versions, keyed by its path prefix such as "/v2", get the
+// version's case instead, errors for paths it does not serve included.
+func FieldCase(fieldCase string, versions map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestCase := fieldCase
+			for prefix, versionCase := range versions {
+				if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+					requestCase = versionCase
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(httputil.WithFieldCase(r.Context(), requestCase)))
+		})
+	}
+}
+
+// CORS middleware lets browsers call the API from any origin, or only from
+// allowedOrigins when it is not empty. A listed origin is echoed back, so
+// responses then vary by Origin and say so for shared caches. Preflights are
+// answered here and cached by browsers for maxAge.
+func CORS(allowedOrigins []string, maxAge time.Duration) func(http.Handler) http.Handler {
+	echo := len(allowedOrigins) > 0 && !slices.Contains(allowedOrigins, "*")
+	maxAgeSeconds := strconv.Itoa(int(maxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			preflight := r.Method == http.MethodOptions
+			if echo {
+				header.Add("Vary", "Origin")
+				if preflight {
+					header.Add("Vary", "Access-Control-Request-Method")
+					header.Add("Vary", "Access-Control-Request-Headers")
+				}
+				if origin := r.Header.Get("Origin"); slices.Contains(allowedOrigins, origin) {
+					header.Set("Access-Control-Allow-Origin", origin)
+				}
+			} else {
+				header.Set("Access-Control-Allow-Origin", "*")
+			}
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+
+			if preflight {
+				if maxAge > 0 {
+					header.Set("Access-Control-Max-Age", maxAgeSeconds)
+				}
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -97,17 +458,22 @@ func CORS() func(http.Handler) http.Handler {
 	}
 }
 
-// Recovery middleware
-func Recovery(metricsCollector *metrics.Metrics) func(http.Handler) http.Handler {
+// Recovery middleware turns a panic into a 500 JSON error carrying the request ID:
+// {"error":{"code":"PANIC","message":"internal server error","request_id":"..."}}
+func Recovery(metricsCollector metrics.Recorder) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					requestID, _ := r.Context().Value(RequestIDKey).(string)
-					slog.Error("Panic recovered", "error", err, "request_id", requestID)
+					requestID := reqctx.RequestIDFromContext(r.Context())
+					slog.Error("Panic recovered", "error", err, "request_id", requestID, "stack", string(debug.Stack()))
 					metricsCollector.RecordPanicRecovery()
 					metricsCollector.RecordError("panic", r.URL.Path)
-					http.Error(w, "internal server error", http.StatusInternalServerError)
+
+					body := httputil.ErrorBody{Code: "PANIC", Message: "internal server error"}
+					if err := httputil.WriteError(r.Context(), w, http.StatusInternalServerError, body); err != nil {
+						slog.Error("Failed to write panic response", "error", err, "request_id", requestID)
+					}
 				}
 			}()
 			next.ServeHTTP(w, r)
@@ -126,13 +492,43 @@ func (rw *responseWriterWrapper) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush passes flushes through so streamed responses are not held back
+func (rw *responseWriterWrapper) Flush() {
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Metrics response writer wrapper
 type metricsResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	// writeErr is the first write failure, telling a client that went away
+	writeErr error
 }
 
 func (rw *metricsResponseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *metricsResponseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	if err != nil && rw.writeErr == nil {
+		rw.writeErr = err
+	}
+	return n, err
+}
+
+// Flush passes flushes through so streamed responses are not held back
+func (rw *metricsResponseWriter) Flush() {
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -1,13 +1,27 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/time/rate"
 	"user-service/internal/metrics"
+	"user-service/internal/reqctx"
+	"user-service/internal/router"
 )
 
 func TestLogging(t *testing.T) {
@@ -17,7 +31,7 @@ func TestLogging(t *testing.T) {
 	})
 
 	// Apply logging middleware
-	wrappedHandler := Logging()(handler)
+	wrappedHandler := Logging(0, nil, QueryLogging{})(handler)
 
 	// Make request
 	req := httptest.NewRequest("GET", "/test", nil)
@@ -30,6 +44,100 @@ func TestLogging(t *testing.T) {
 	}
 }
 
+// levelRecorder is a slog handler recording the level of every record
+type levelRecorder struct {
+	mu     sync.Mutex
+	levels []slog.Level
+}
+
+func (h *levelRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *levelRecorder) Handle(_ context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.levels = append(h.levels, record.Level)
+	return nil
+}
+
+func (h *levelRecorder) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *levelRecorder) WithGroup(string) slog.Handler { return h }
+
+func TestLoggingSlowRequests(t *testing.T) {
+	logs := &levelRecorder{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(logs))
+	defer slog.SetDefault(defaultLogger)
+
+	rt := router.New()
+	rt.Use(Logging(20*time.Millisecond, nil, QueryLogging{}, "GET /stream"))
+	slow := func(w http.ResponseWriter, r *http.Request) { time.Sleep(50 * time.Millisecond) }
+	rt.HandleFunc("GET /fast", func(w http.ResponseWriter, r *http.Request) {})
+	rt.HandleFunc("GET /slow", slow)
+	rt.HandleFunc("GET /stream", slow)
+
+	tests := []struct {
+		target    string
+		wantLevel slog.Level
+	}{
+		{"/fast", slog.LevelInfo},
+		{"/slow", slog.LevelWarn},
+		{"/stream", slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		logs.levels = nil
+		rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+		if len(logs.levels) != 1 || logs.levels[0] != tt.wantLevel {
+			t.Errorf("GET %s: expected one %s record, got %v", tt.target, tt.wantLevel, logs.levels)
+		}
+	}
+
+	// Without a threshold nothing is slow
+	logs.levels = nil
+	Logging(0, nil, QueryLogging{})(http.HandlerFunc(slow)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	if len(logs.levels) != 1 || logs.levels[0] != slog.LevelInfo {
+		t.Errorf("Expected one info record without a threshold, got %v", logs.levels)
+	}
+}
+
+func TestLoggingQueryParams(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name      string
+		query     QueryLogging
+		target    string
+		wantQuery interface{}
+	}{
+		{"disabled", QueryLogging{Redact: []string{"token"}}, "/users?token=secret&id=1", nil},
+		{"enabled", QueryLogging{Enabled: true, Redact: []string{"token", "password", "api_key"}}, "/users?token=secret&id=1", "token=***&id=1"},
+		{"redacts regardless of case and encoding", QueryLogging{Enabled: true, Redact: []string{"api_key"}}, "/users?API%5FKEY=secret&id=1", "API%5FKEY=***&id=1"},
+		{"redacts every value", QueryLogging{Enabled: true, Redact: []string{"token"}}, "/users?token=a&token=b", "token=***&token=***"},
+		{"without a query", QueryLogging{Enabled: true, Redact: []string{"token"}}, "/users", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+			Logging(0, nil, tt.query)(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.target, nil))
+
+			var record map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("Failed to decode log record %q: %v", buf.String(), err)
+			}
+			if got := record["query"]; got != tt.wantQuery {
+				t.Errorf("Expected query %v, got %v", tt.wantQuery, got)
+			}
+			if strings.Contains(buf.String(), "secret") {
+				t.Errorf("Expected no secret in the log, got %s", buf.String())
+			}
+		})
+	}
+}
+
 func TestMetrics(t *testing.T) {
 	reg := prometheus.NewRegistry()
 	metricsCollector := metrics.New(reg, reg)
@@ -52,55 +160,945 @@ func TestMetrics(t *testing.T) {
 	}
 }
 
+func TestInternalPaths(t *testing.T) {
+	logs := &levelRecorder{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(logs))
+	defer slog.SetDefault(defaultLogger)
+
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	rt := router.New()
+	rt.Use(Logging(0, []string{"/metrics"}, QueryLogging{}), Metrics(metricsCollector, "/metrics"))
+	rt.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {})
+	rt.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {})
+
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	if len(logs.levels) != 0 {
+		t.Errorf("Expected no access log for a scrape, got %d records", len(logs.levels))
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "internal_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetValue() == "/metrics" {
+					t.Errorf("Expected no %s series for the scrape, got %v", family.GetName(), m.GetLabel())
+				}
+			}
+		}
+	}
+	if got := routeMetric(t, reg, "internal_requests_total", "", "path", "/metrics"); got != 1 {
+		t.Errorf("Expected the scrape counted once in internal_requests_total, got %v", got)
+	}
+
+	// Other requests are logged and measured as before
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	if len(logs.levels) != 1 {
+		t.Errorf("Expected one access log record, got %d", len(logs.levels))
+	}
+	if got := routeMetric(t, reg, "http_requests_slo_total", "GET /users", "class", "success"); got != 1 {
+		t.Errorf("Expected the request measured once, got %v", got)
+	}
+}
+
+// brokenPipeWriter fails every body write the way a connection the client closed does
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenPipeWriter) Write([]byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+}
+
+func TestMetricsClientDisconnects(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	rt := router.New()
+	rt.Use(Metrics(metricsCollector))
+	rt.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"users":[]}`))
+	})
+
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+	if got := routeMetric(t, reg, "client_disconnects_total", "GET /users"); got != 0 {
+		t.Errorf("Expected no disconnects for a delivered response, got %v", got)
+	}
+
+	rt.ServeHTTP(brokenPipeWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/users", nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil).WithContext(ctx))
+	if got := routeMetric(t, reg, "client_disconnects_total", "GET /users"); got != 2 {
+		t.Errorf("Expected a broken pipe and a cancelled request counted as disconnects, got %v", got)
+	}
+}
+
+func TestMetricsSLOClasses(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	handler := Metrics(metricsCollector)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}))
+
+	for _, status := range []int{200, 201, 404, 429, 500, 503} {
+		req := httptest.NewRequest("GET", "/users?status="+strconv.Itoa(status), nil)
+		req = req.WithContext(context.WithValue(req.Context(), router.PatternKey, "GET /users"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for class, want := range map[string]float64{"success": 2, "client_error": 1, "throttled": 1, "server_error": 2} {
+		if got := routeMetric(t, reg, "http_requests_slo_total", "GET /users", "class", class); got != want {
+			t.Errorf("Expected %v %s requests, got %v", want, class, got)
+		}
+	}
+}
+
+func TestWrappersFlush(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected the wrapped writer to be an http.Flusher")
+		}
+		w.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+	})
+
+	// Both wrappers pass flushes through to the connection
+	wrappedHandler := Logging(0, nil, QueryLogging{})(Metrics(metricsCollector)(handler))
+	req := httptest.NewRequest("GET", "/events", nil)
+	rr := httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(rr, req)
+
+	if !rr.Flushed {
+		t.Error("Expected the response to be flushed")
+	}
+}
+
+// rateLimitHits returns the rate_limit_hits_total count for class
+func rateLimitHits(t *testing.T, reg *prometheus.Registry, class string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "rate_limit_hits_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() == class {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
 func TestRateLimit(t *testing.T) {
 	reg := prometheus.NewRegistry()
 	metricsCollector := metrics.New(reg, reg)
-	limiter := rate.NewLimiter(1, 1)
 	// Create a simple handler for testing
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	// Apply rate limit middleware
-	wrappedHandler := RateLimit(limiter, metricsCollector)(handler)
+	// Apply rate limit middleware, with reads budgeted well above writes
+	limiters := RateLimiters{Read: rate.NewLimiter(1, 100), Write: rate.NewLimiter(1, 2)}
+	wrappedHandler := RateLimit(limiters, metricsCollector, "/health")(handler)
 
-	// Make request
+	serve := func(method, pattern string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test", nil)
+		req = req.WithContext(context.WithValue(req.Context(), router.PatternKey, pattern))
+		rr := httptest.NewRecorder()
+		wrappedHandler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Saturate the write limiter
+	for i := range 2 {
+		if rr := serve("POST", "/users"); rr.Code != http.StatusOK {
+			t.Fatalf("Expected write %d within the burst to succeed, got %d", i, rr.Code)
+		}
+	}
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		rr := serve(method, "/users")
+		if rr.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected %s to be limited with status %d, got %d", method, http.StatusTooManyRequests, rr.Code)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Expected Retry-After 1, got %q", got)
+		}
+	}
+
+	// Reads keep succeeding from their own budget
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		if rr := serve(method, "/users"); rr.Code != http.StatusOK {
+			t.Errorf("Expected %s to succeed while writes are limited, got %d", method, rr.Code)
+		}
+	}
+
+	// Exempt routes are never limited
+	if rr := serve("POST", "/health"); rr.Code != http.StatusOK {
+		t.Errorf("Expected an exempt route to succeed, got %d", rr.Code)
+	}
+
+	if got := rateLimitHits(t, reg, "write"); got != 4 {
+		t.Errorf("Expected 4 write rate limit hits, got %v", got)
+	}
+	if got := rateLimitHits(t, reg, "read"); got != 0 {
+		t.Errorf("Expected no read rate limit hits, got %v", got)
+	}
+
+	// A limiter refilling slower than once a second asks for a longer wait
+	slow := RateLimit(RateLimiters{Read: rate.NewLimiter(0.25, 1), Write: rate.NewLimiter(1, 1)}, metricsCollector)(handler)
 	req := httptest.NewRequest("GET", "/test", nil)
+	slow.ServeHTTP(httptest.NewRecorder(), req)
 	rr := httptest.NewRecorder()
-	wrappedHandler.ServeHTTP(rr, req)
+	slow.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Retry-After"); got != "4" {
+		t.Errorf("Expected Retry-After 4, got %q", got)
+	}
+	if got := rateLimitHits(t, reg, "read"); got != 1 {
+		t.Errorf("Expected 1 read rate limit hit, got %v", got)
+	}
+}
 
-	// Check response
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+func TestRateLimitRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// newServe returns a request function through fresh limiters, reads budgeted at
+	// 3 and both exports sharing a budget of 2
+	newServe := func() func(pattern string) int {
+		export := rate.NewLimiter(0.001, 2)
+		limiters := RateLimiters{
+			Read:   rate.NewLimiter(0.001, 3),
+			Write:  rate.NewLimiter(0.001, 3),
+			Routes: map[string]*rate.Limiter{"GET /users/export": export, "GET /users/export.csv": export},
+		}
+		wrappedHandler := RateLimit(limiters, metricsCollector)(handler)
+		return func(pattern string) int {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = req.WithContext(context.WithValue(req.Context(), router.PatternKey, pattern))
+			rr := httptest.NewRecorder()
+			wrappedHandler.ServeHTTP(rr, req)
+			return rr.Code
+		}
 	}
 
-	// Make another request to trigger rate limit
-	rr = httptest.NewRecorder()
-	wrappedHandler.ServeHTTP(rr, req)
+	t.Run("exhausting exports leaves other reads alone", func(t *testing.T) {
+		serve := newServe()
+		for i := range 2 {
+			if code := serve("GET /users/export"); code != http.StatusOK {
+				t.Fatalf("Expected export %d within the burst to succeed, got %d", i, code)
+			}
+		}
+		for _, pattern := range []string{"GET /users/export", "GET /users/export.csv"} {
+			if code := serve(pattern); code != http.StatusTooManyRequests {
+				t.Errorf("Expected %s to share the used up export budget, got %d", pattern, code)
+			}
+		}
+		for i := range 3 {
+			if code := serve("/user"); code != http.StatusOK {
+				t.Errorf("Expected read %d to succeed while exports are limited, got %d", i, code)
+			}
+		}
+	})
 
-	// Check response
+	t.Run("exhausting reads leaves exports alone", func(t *testing.T) {
+		serve := newServe()
+		for i := range 3 {
+			if code := serve("/user"); code != http.StatusOK {
+				t.Fatalf("Expected read %d within the burst to succeed, got %d", i, code)
+			}
+		}
+		if code := serve("/user"); code != http.StatusTooManyRequests {
+			t.Errorf("Expected reads to be limited once used up, got %d", code)
+		}
+		for i := range 2 {
+			if code := serve("GET /users/export.csv"); code != http.StatusOK {
+				t.Errorf("Expected export %d to succeed while reads are limited, got %d", i, code)
+			}
+		}
+	})
+
+	if got := rateLimitHits(t, reg, "GET /users/export"); got != 1 {
+		t.Errorf("Expected 1 hit counted for GET /users/export, got %v", got)
+	}
+	if got := rateLimitHits(t, reg, "GET /users/export.csv"); got != 1 {
+		t.Errorf("Expected 1 hit counted for GET /users/export.csv, got %v", got)
+	}
+	if got := rateLimitHits(t, reg, "read"); got != 1 {
+		t.Errorf("Expected 1 read rate limit hit, got %v", got)
+	}
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	handler := RouteRateLimit("email_availability", rate.NewLimiter(0.5, 2), metrics.New(reg, reg))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := range 2 {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users/email-available", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected request %d within the burst to succeed, got %d", i, rr.Code)
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users/email-available", nil))
 	if rr.Code != http.StatusTooManyRequests {
-		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rr.Code)
+		t.Errorf("Expected status %d past the burst, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", got)
+	}
+	if got := rateLimitHits(t, reg, "email_availability"); got != 1 {
+		t.Errorf("Expected 1 email_availability rate limit hit, got %v", got)
 	}
 }
 
-func TestCORS(t *testing.T) {
-	// Create a simple handler for testing
+// routeMetric returns the value of the counter or gauge name for route, or the
+// sample count of the histogram, and for the label named by its value when given,
+// as in "reason", "concurrency"
+func routeMetric(t *testing.T, reg *prometheus.Registry, name, route string, label ...string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				switch {
+				case l.GetName() == "route" && l.GetValue() != route,
+					len(label) == 2 && l.GetName() == label[0] && l.GetValue() != label[1]:
+					continue metrics
+				}
+			}
+			if m.GetGauge() != nil {
+				return m.GetGauge().GetValue()
+			}
+			if m.GetHistogram() != nil {
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	// Export requests are held open until released; other routes answer at once
+	const limit = 3
+	started, release := make(chan struct{}), make(chan struct{})
+	export := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	rt := router.New()
+	rt.Handle("GET /users/export", ConcurrencyLimit("GET /users/export", limit, Queue{}, metricsCollector)(export))
+	rt.Handle("GET /users", ConcurrencyLimit("GET /users", 0, Queue{}, metricsCollector)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	rt.Use(Metrics(metricsCollector))
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		return rr
+	}
+
+	held := make(chan *httptest.ResponseRecorder, limit)
+	for range limit {
+		go func() { held <- serve("/users/export") }()
+		<-started
+	}
+	if got := routeMetric(t, reg, "http_requests_in_flight", "GET /users/export"); got != limit {
+		t.Errorf("Expected %d exports in flight, got %v", limit, got)
+	}
+
+	rr := serve("/users/export")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the export past the limit to get %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+	if got := routeMetric(t, reg, "requests_rejected_total", "GET /users/export", "reason", "concurrency"); got != 1 {
+		t.Errorf("Expected 1 rejected export, got %v", got)
+	}
+
+	// Other routes keep working while exports are saturated
+	for range 2 * limit {
+		if rr := serve("/users"); rr.Code != http.StatusOK {
+			t.Fatalf("Expected another route to succeed, got %d", rr.Code)
+		}
+	}
+
+	close(release)
+	for range limit {
+		if rr := <-held; rr.Code != http.StatusOK {
+			t.Errorf("Expected a held export to finish with %d, got %d", http.StatusOK, rr.Code)
+		}
+	}
+	if got := routeMetric(t, reg, "http_requests_in_flight", "GET /users/export"); got != 0 {
+		t.Errorf("Expected no exports in flight, got %v", got)
+	}
+
+	// Finished requests free their slots
+	go func() { held <- serve("/users/export") }()
+	<-started
+	if rr := <-held; rr.Code != http.StatusOK {
+		t.Errorf("Expected an export after the others finished to succeed, got %d", rr.Code)
+	}
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	const route = "GET /users/export"
+
+	// newRouter limits exports to one at a time, with queue. Exports tell started
+	// their ?n= and are held open until released; other routes answer at once.
+	newRouter := func(queue Queue) (*router.Router, *prometheus.Registry, chan string, chan struct{}) {
+		reg := prometheus.NewRegistry()
+		metricsCollector := metrics.New(reg, reg)
+		started, release := make(chan string), make(chan struct{})
+		rt := router.New()
+		rt.Handle(route, ConcurrencyLimit(route, 1, queue, metricsCollector)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- r.URL.Query().Get("n")
+			<-release
+			w.WriteHeader(http.StatusOK)
+		})))
+		rt.Handle("GET /users", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		return rt, reg, started, release
+	}
+	serve := func(rt *router.Router, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		return rr
+	}
+	// waitForDepth waits until depth requests are queued
+	waitForDepth := func(t *testing.T, reg *prometheus.Registry, depth float64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for routeMetric(t, reg, "request_queue_depth", route) != depth && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := routeMetric(t, reg, "request_queue_depth", route); got != depth {
+			t.Fatalf("Expected %v exports queued, got %v", depth, got)
+		}
+	}
+
+	t.Run("serves queued requests in order", func(t *testing.T) {
+		rt, reg, started, release := newRouter(Queue{Length: 3, Timeout: time.Minute})
+		done := make(chan *httptest.ResponseRecorder, 4)
+		go func() { done <- serve(rt, "/users/export?n=0") }()
+		<-started
+		for n := 1; n <= 3; n++ {
+			go func() { done <- serve(rt, fmt.Sprintf("/users/export?n=%d", n)) }()
+			waitForDepth(t, reg, float64(n))
+			// A request is counted just before it blocks on the queue
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// Light routes are not held back by a full queue
+		if rr := serve(rt, "/users"); rr.Code != http.StatusOK {
+			t.Errorf("Expected another route to succeed, got %d", rr.Code)
+		}
+
+		for n := 1; n <= 3; n++ {
+			release <- struct{}{}
+			if got := <-started; got != strconv.Itoa(n) {
+				t.Errorf("Expected queued export %d to start next, got %s", n, got)
+			}
+		}
+		close(release)
+		for range 4 {
+			if rr := <-done; rr.Code != http.StatusOK {
+				t.Errorf("Expected every export to succeed, got %d", rr.Code)
+			}
+		}
+		if got := routeMetric(t, reg, "request_queue_wait_seconds", route); got != 3 {
+			t.Errorf("Expected the waits of 3 queued exports, got %v", got)
+		}
+		if got := routeMetric(t, reg, "request_queue_depth", route); got != 0 {
+			t.Errorf("Expected an empty queue, got %v", got)
+		}
+	})
+
+	t.Run("turns requests away when the queue is full", func(t *testing.T) {
+		rt, reg, started, release := newRouter(Queue{Length: 1, Timeout: time.Minute})
+		done := make(chan *httptest.ResponseRecorder, 2)
+		go func() { done <- serve(rt, "/users/export") }()
+		<-started
+		go func() { done <- serve(rt, "/users/export") }()
+		waitForDepth(t, reg, 1)
+
+		rr := serve(rt, "/users/export")
+		if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("Expected %d with Retry-After 1 past the queue, got %d %q", http.StatusServiceUnavailable, rr.Code, rr.Header().Get("Retry-After"))
+		}
+		if got := routeMetric(t, reg, "requests_rejected_total", route, "reason", "concurrency"); got != 1 {
+			t.Errorf("Expected 1 export rejected for concurrency, got %v", got)
+		}
+
+		close(release)
+		<-started
+		for range 2 {
+			if rr := <-done; rr.Code != http.StatusOK {
+				t.Errorf("Expected the running and queued exports to succeed, got %d", rr.Code)
+			}
+		}
+	})
+
+	t.Run("turns requests away after the queue timeout", func(t *testing.T) {
+		rt, reg, started, release := newRouter(Queue{Length: 1, Timeout: 10 * time.Millisecond})
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() { done <- serve(rt, "/users/export") }()
+		<-started
+
+		rr := serve(rt, "/users/export")
+		if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("Expected %d with Retry-After 1 after waiting, got %d %q", http.StatusServiceUnavailable, rr.Code, rr.Header().Get("Retry-After"))
+		}
+		if got := routeMetric(t, reg, "requests_rejected_total", route, "reason", "queue_timeout"); got != 1 {
+			t.Errorf("Expected 1 export rejected for queue_timeout, got %v", got)
+		}
+		if got := routeMetric(t, reg, "request_queue_depth", route); got != 0 {
+			t.Errorf("Expected an empty queue, got %v", got)
+		}
+
+		close(release)
+		if rr := <-done; rr.Code != http.StatusOK {
+			t.Errorf("Expected the running export to succeed, got %d", rr.Code)
+		}
+	})
+}
+
+func TestConcurrency(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+
+	// Requests to /users are held open until released, /panic panics at once
+	const limit = 3
+	started, release := make(chan struct{}, limit), make(chan struct{})
+	rt := router.New()
+	rt.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	rt.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	rt.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// Recovery sits outside, so panics unwind through the semaphore
+	rt.Use(Recovery(metricsCollector), Concurrency(limit, metricsCollector, "/health"))
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		return rr
+	}
+
+	// Of more requests than the limit, only limit get to run
+	const requests = 3 * limit
+	results := make(chan *httptest.ResponseRecorder, requests)
+	for range requests {
+		go func() { results <- serve("/users") }()
+	}
+	for range limit {
+		<-started
+	}
+	for range requests - limit {
+		rr := <-results
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected a request past the limit to get %d, got %d", http.StatusServiceUnavailable, rr.Code)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Expected Retry-After 1, got %q", got)
+		}
+	}
+	if got := routeMetric(t, reg, "requests_rejected_total", "GET /users", "reason", "capacity"); got != requests-limit {
+		t.Errorf("Expected %d rejected requests, got %v", requests-limit, got)
+	}
+
+	// Health checks are answered while the service is saturated
+	if rr := serve("/health"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the health check to succeed, got %d", rr.Code)
+	}
+
+	close(release)
+	for range limit {
+		if rr := <-results; rr.Code != http.StatusOK {
+			t.Errorf("Expected a held request to finish with %d, got %d", http.StatusOK, rr.Code)
+		}
+	}
+
+	// Panicking requests free their slots, so more of them than the limit all
+	// reach the handler rather than filling the service up
+	for range 2 * limit {
+		if rr := serve("/panic"); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected the panic to be recovered with %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+	}
+	go func() { results <- serve("/users") }()
+	<-started
+	if rr := <-results; rr.Code != http.StatusOK {
+		t.Errorf("Expected a request after the panics to succeed, got %d", rr.Code)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	deadline := func(rt *router.Router, target string) (time.Duration, bool) {
+		var remaining time.Duration
+		var ok bool
+		rt.HandleFunc(target, func(w http.ResponseWriter, r *http.Request) {
+			var d time.Time
+			d, ok = r.Context().Deadline()
+			remaining = time.Until(d)
+		})
+		rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+		return remaining, ok
+	}
+
+	rt := router.New()
+	rt.Use(Deadline(time.Minute, "/users/export"))
+	if remaining, ok := deadline(rt, "/users"); !ok || remaining <= 0 || remaining > time.Minute {
+		t.Errorf("Expected a deadline within a minute, got %v (set: %v)", remaining, ok)
+	}
+	if _, ok := deadline(rt, "/users/export"); ok {
+		t.Error("Expected no deadline on an exempt route")
+	}
+
+	rt = router.New()
+	rt.Use(Deadline(0))
+	if _, ok := deadline(rt, "/users"); ok {
+		t.Error("Expected no deadline without a timeout")
+	}
+}
+
+func TestAdminToken(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if caller, _ := reqctx.CallerFromContext(r.Context()); caller.Subject != AdminActor {
+			t.Errorf("Expected caller %q, got %q", AdminActor, caller.Subject)
+		}
 		w.WriteHeader(http.StatusOK)
 	})
 
-	// Apply CORS middleware
-	wrappedHandler := CORS()(handler)
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		expected      int
+	}{
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong scheme", "secret", "Basic secret", http.StatusUnauthorized},
+		{"disabled", "", "Bearer ", http.StatusForbidden},
+	}
 
-	// Make request
-	req := httptest.NewRequest("OPTIONS", "/test", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/users", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			AdminToken(tt.token)(handler).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rr.Code)
+			}
+		})
+	}
+
+	t.Run("user token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		req.Header.Set("Authorization", "Bearer user")
+		rr := httptest.NewRecorder()
+		Authenticate("secret", map[string]string{"user": "alice"})(AdminToken("secret")(handler)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d for a caller without the admin role, got %d", http.StatusForbidden, rr.Code)
+		}
+		if rr.Header().Get("WWW-Authenticate") != "" {
+			t.Error("Expected no WWW-Authenticate challenge for an authenticated caller")
+		}
+	})
+}
+
+func TestRequireRole(t *testing.T) {
+	var reached bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := Authenticate("secret", map[string]string{"user": "alice"})(RequireRole(reqctx.AdminRole)(handler))
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"admin caller", "Bearer secret", http.StatusOK},
+		{"caller without the role", "Bearer user", http.StatusForbidden},
+		{"unauthenticated", "", http.StatusUnauthorized},
+		{"unknown token", "Bearer guess", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest("POST", "/users", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			wrappedHandler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rr.Code)
+			}
+			if reached != (tt.expected == http.StatusOK) {
+				t.Errorf("Expected the handler to run only for allowed callers, ran %v", reached)
+			}
+			if tt.expected == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("Expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	var caller reqctx.Caller
+	var authenticated bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, authenticated = reqctx.CallerFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Capture logs to check the caller is recorded
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	wrappedHandler := Authenticate("secret", nil)(Logging(0, nil, QueryLogging{})(handler))
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "Bearer secret")
 	rr := httptest.NewRecorder()
 	wrappedHandler.ServeHTTP(rr, req)
 
-	// Check response
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	if !authenticated || caller.Subject != AdminActor || !caller.HasRole(reqctx.AdminRole) {
+		t.Errorf("Expected caller %q with role %q, got %+v (authenticated %v)", AdminActor, reqctx.AdminRole, caller, authenticated)
+	}
+	if !strings.Contains(logs.String(), `"caller":"admin"`) {
+		t.Errorf("Expected the caller to be logged, got %s", logs.String())
+	}
+
+	// Unknown tokens and anonymous requests pass through without a caller
+	for _, authorization := range []string{"", "Bearer guess", "Basic secret"} {
+		req := httptest.NewRequest("GET", "/users", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		wrappedHandler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || authenticated {
+			t.Errorf("Authorization %q: expected an anonymous request, got status %d and caller %+v", authorization, rr.Code, caller)
+		}
+	}
+
+	// An empty token authenticates no one
+	req = httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	Authenticate("", nil)(handler).ServeHTTP(httptest.NewRecorder(), req)
+	if authenticated {
+		t.Errorf("Expected no caller with authentication disabled, got %+v", caller)
+	}
+
+	// User tokens authenticate as their subject, without the admin role
+	userTokens := map[string]string{"t0ken": "auth0|alice", "other": "auth0|bob"}
+	req = httptest.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	Authenticate("secret", userTokens)(handler).ServeHTTP(httptest.NewRecorder(), req)
+	if !authenticated || caller.Subject != "auth0|alice" || !caller.HasRole(reqctx.UserRole) || caller.HasRole(reqctx.AdminRole) {
+		t.Errorf("Expected caller auth0|alice with role %q only, got %+v (authenticated %v)", reqctx.UserRole, caller, authenticated)
+	}
+	for _, authorization := range []string{"Bearer t0ke", "Bearer t0ken2", "Bearer "} {
+		req = httptest.NewRequest("GET", "/me", nil)
+		req.Header.Set("Authorization", authorization)
+		Authenticate("secret", userTokens)(handler).ServeHTTP(httptest.NewRecorder(), req)
+		if authenticated {
+			t.Errorf("Authorization %q: expected an anonymous request, got caller %+v", authorization, caller)
+		}
+	}
+
+	// Admin routes accept the caller Authenticate established
+	req = httptest.NewRequest("GET", "/admin/users", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	Authenticate("secret", nil)(AdminToken("secret")(handler)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !authenticated {
+		t.Errorf("Expected an authorized admin request, got status %d", rr.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	// Create a simple handler for testing
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(wrappedHandler http.Handler, method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+		rr := httptest.NewRecorder()
+		wrappedHandler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("any origin", func(t *testing.T) {
+		wrappedHandler := CORS(nil, 10*time.Minute)(handler)
+
+		rr := serve(wrappedHandler, "OPTIONS", "https://app.example.com")
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("Expected Access-Control-Allow-Origin *, got %q", got)
+		}
+		if got := rr.Header().Get("Access-Control-Max-Age"); got != "600" {
+			t.Errorf("Expected Access-Control-Max-Age 600, got %q", got)
+		}
+		// The wildcard response is the same for every origin
+		if got := rr.Header().Values("Vary"); len(got) != 0 {
+			t.Errorf("Expected no Vary header, got %v", got)
+		}
+	})
+
+	t.Run("echoed origins", func(t *testing.T) {
+		wrappedHandler := CORS([]string{"https://app.example.com"}, time.Hour)(handler)
+
+		tests := []struct {
+			name       string
+			method     string
+			origin     string
+			wantOrigin string
+			wantVary   []string
+		}{
+			{"actual request", "GET", "https://app.example.com", "https://app.example.com", []string{"Origin"}},
+			{"preflight", "OPTIONS", "https://app.example.com", "https://app.example.com",
+				[]string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+			{"other origin", "GET", "https://evil.example.com", "", []string{"Origin"}},
+			{"other origin preflight", "OPTIONS", "https://evil.example.com", "",
+				[]string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := serve(wrappedHandler, tt.method, tt.origin)
+				if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+					t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tt.wantOrigin, got)
+				}
+				if got := rr.Header().Values("Vary"); strings.Join(got, ", ") != strings.Join(tt.wantVary, ", ") {
+					t.Errorf("Expected Vary %v, got %v", tt.wantVary, got)
+				}
+				wantMaxAge := ""
+				if tt.method == "OPTIONS" {
+					wantMaxAge = "3600"
+				}
+				if got := rr.Header().Get("Access-Control-Max-Age"); got != wantMaxAge {
+					t.Errorf("Expected Access-Control-Max-Age %q, got %q", wantMaxAge, got)
+				}
+			})
+		}
+	})
+
+	t.Run("keeps the Vary values of other middleware", func(t *testing.T) {
+		wrappedHandler := CORS([]string{"https://app.example.com"}, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		rr := serve(wrappedHandler, "GET", "https://app.example.com")
+		if got := strings.Join(rr.Header().Values("Vary"), ", "); got != "Origin, Accept-Encoding" {
+			t.Errorf("Expected Vary Origin, Accept-Encoding, got %q", got)
+		}
+		// A zero max age leaves preflight caching to the browser
+		if rr := serve(wrappedHandler, "OPTIONS", "https://app.example.com"); rr.Header().Get("Access-Control-Max-Age") != "" {
+			t.Errorf("Expected no Access-Control-Max-Age, got %q", rr.Header().Get("Access-Control-Max-Age"))
+		}
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = reqctx.RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		wantKept bool
+	}{
+		{"generated without a header", "", false},
+		{"kept from the client", "support-ticket_42.retry:1", true},
+		{"replaced when it has spaces", "id with spaces", false},
+		{"replaced when it is too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			requestID := rr.Header().Get("X-Request-ID")
+			if requestID == "" || requestID != seen {
+				t.Errorf("Expected the response header %q to match the context's %q", requestID, seen)
+			}
+			if kept := requestID == tt.header; kept != tt.wantKept {
+				t.Errorf("Expected the client's ID kept to be %v, got %q", tt.wantKept, requestID)
+			}
+		})
 	}
 }
 
@@ -112,8 +1110,14 @@ func TestRecovery(t *testing.T) {
 		panic("test panic")
 	})
 
-	// Apply recovery middleware
-	wrappedHandler := Recovery(metricsCollector)(handler)
+	// Capture logs to check the stack is recorded
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	// Apply recovery middleware behind the request ID middleware
+	wrappedHandler := RequestID()(Recovery(metricsCollector)(handler))
 
 	// Make request
 	req := httptest.NewRequest("GET", "/test", nil)
@@ -124,4 +1128,35 @@ func TestRecovery(t *testing.T) {
 	if rr.Code != http.StatusInternalServerError {
 		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
 	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %s", contentType)
+	}
+
+	var response struct {
+		Error struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode panic response: %v", err)
+	}
+	if response.Error.Code != "PANIC" {
+		t.Errorf("Expected code PANIC, got %s", response.Error.Code)
+	}
+	if requestID := rr.Header().Get("X-Request-ID"); requestID == "" || response.Error.RequestID != requestID {
+		t.Errorf("Expected request_id %q in body, got %q", requestID, response.Error.RequestID)
+	}
+
+	var entry struct {
+		Msg   string `json:"msg"`
+		Stack string `json:"stack"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to decode panic log: %v (%s)", err, logs.String())
+	}
+	if !strings.Contains(entry.Stack, "runtime/debug.Stack") {
+		t.Errorf("Expected the stack in the panic log, got %q", entry.Stack)
+	}
 }
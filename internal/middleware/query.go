@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/internal/httputil"
+	"user-service/internal/reqctx"
+)
+
+// ParamType is what the value of a query parameter must parse as
+type ParamType int
+
+const (
+	// StringParam takes any value
+	StringParam ParamType = iota
+	// IntParam takes a base 10 integer
+	IntParam
+	// BoolParam takes true or false, in any form strconv.ParseBool reads
+	BoolParam
+	// TimeParam takes an RFC3339 timestamp
+	TimeParam
+)
+
+// QueryParam is a query parameter a route accepts
+type QueryParam struct {
+	Name string
+	Type ParamType
+}
+
+// QueryProblems lists what is wrong with a rejected query string, as the details
+// of its 400
+type QueryProblems struct {
+	Unexpected []string `json:"unexpected,omitempty"`
+	Repeated   []string `json:"repeated,omitempty"`
+	Invalid    []string `json:"invalid,omitempty"`
+	Allowed    []string `json:"allowed"`
+}
+
+// QueryParams middleware rejects a request whose query string has parameters other
+// than params, any of them more than once, or a value that does not parse as its
+// type, answering 400 with what was wrong and what the route allows. Names are
+// case-sensitive, so ?Id= is not ?id=. Empty values are left for the handler to
+// treat as missing, as are checks of what the values mean, such as an ID's range.
+func QueryParams(params ...QueryParam) func(http.Handler) http.Handler {
+	types := make(map[string]ParamType, len(params))
+	allowed := make([]string, 0, len(params))
+	for _, param := range params {
+		types[param.Name] = param.Type
+		allowed = append(allowed, param.Name)
+	}
+	slices.Sort(allowed)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := reqctx.RequestIDFromContext(r.Context())
+
+			query, err := url.ParseQuery(r.URL.RawQuery)
+			if err != nil {
+				slog.Warn("Malformed query string", "error", err, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "request_id", requestID)
+				httputil.Error(r.Context(), w, "query string is malformed", http.StatusBadRequest)
+				return
+			}
+
+			problems := QueryProblems{Allowed: allowed}
+			var messages []string
+			for name, values := range query {
+				paramType, ok := types[name]
+				switch {
+				case !ok:
+					problems.Unexpected = append(problems.Unexpected, name)
+				case len(values) > 1:
+					problems.Repeated = append(problems.Repeated, name)
+				case !paramType.valid(values[0]):
+					problems.Invalid = append(problems.Invalid, name)
+					messages = append(messages, name+" must be "+paramType.String())
+				}
+			}
+			if problems.Unexpected == nil && problems.Repeated == nil && problems.Invalid == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			slices.Sort(problems.Unexpected)
+			slices.Sort(problems.Repeated)
+			slices.Sort(problems.Invalid)
+			slices.Sort(messages)
+			if problems.Unexpected != nil {
+				messages = append(messages, "unexpected query parameters: "+strings.Join(problems.Unexpected, ", "))
+			}
+			if problems.Repeated != nil {
+				messages = append(messages, "repeated query parameters: "+strings.Join(problems.Repeated, ", "))
+			}
+			messages = append(messages, "allowed: "+strings.Join(allowed, ", "))
+
+			slog.Warn("Rejected query parameters", "path", r.URL.Path, "unexpected", problems.Unexpected, "repeated", problems.Repeated,
+				"invalid", problems.Invalid, "remote_addr", r.RemoteAddr, "request_id", requestID)
+			body := httputil.ErrorBody{Message: strings.Join(messages, "; "), Details: problems}
+			if err := httputil.WriteError(r.Context(), w, http.StatusBadRequest, body); err != nil {
+				slog.Error("Failed to write error response", "error", err, "request_id", requestID)
+			}
+		})
+	}
+}
+
+// valid reports whether value parses as t. Empty values always do.
+func (t ParamType) valid(value string) bool {
+	if value == "" {
+		return true
+	}
+	var err error
+	switch t {
+	case IntParam:
+		// Values too large are integers all the same; their range is up to the handler.
+		// Surrounding whitespace is ignored, as models.ParseUserID ignores it.
+		_, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			err = nil
+		}
+	case BoolParam:
+		_, err = strconv.ParseBool(value)
+	case TimeParam:
+		_, err = time.Parse(time.RFC3339, value)
+	}
+	return err == nil
+}
+
+// String describes the values of t, as in "created_after must be an RFC3339 timestamp"
+func (t ParamType) String() string {
+	switch t {
+	case IntParam:
+		return "an integer"
+	case BoolParam:
+		return "true or false"
+	case TimeParam:
+		return "an RFC3339 timestamp"
+	}
+	return "a string"
+}
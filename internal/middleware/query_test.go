@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestQueryParams(t *testing.T) {
+	handler := QueryParams(
+		QueryParam{Name: "id", Type: IntParam},
+		QueryParam{Name: "since", Type: TimeParam},
+		QueryParam{Name: "active", Type: BoolParam},
+		QueryParam{Name: "pretty"},
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		query          string
+		wantStatus     int
+		wantUnexpected []string
+		wantRepeated   []string
+		wantInvalid    []string
+	}{
+		{name: "no query", query: "", wantStatus: http.StatusOK},
+		{name: "all allowed", query: "id=1&since=2024-01-01T00:00:00Z&active=true&pretty", wantStatus: http.StatusOK},
+		{name: "empty values left to the handler", query: "id=&since=", wantStatus: http.StatusOK},
+		{name: "out of range ID left to the handler", query: "id=99999999999999999999", wantStatus: http.StatusOK},
+		{name: "padded integer", query: "id=%205%20", wantStatus: http.StatusOK},
+		{name: "unknown", query: "id=1&sort=name&limit=5", wantStatus: http.StatusBadRequest, wantUnexpected: []string{"limit", "sort"}},
+		{name: "duplicated", query: "id=1&id=2", wantStatus: http.StatusBadRequest, wantRepeated: []string{"id"}},
+		{name: "case sensitive", query: "Id=1", wantStatus: http.StatusBadRequest, wantUnexpected: []string{"Id"}},
+		{name: "not an integer", query: "id=abc", wantStatus: http.StatusBadRequest, wantInvalid: []string{"id"}},
+		{name: "not a timestamp", query: "since=yesterday&active=maybe", wantStatus: http.StatusBadRequest, wantInvalid: []string{"active", "since"}},
+		{name: "malformed", query: "id=%zz", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/user?"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusOK || strings.Contains(tt.query, "%zz") {
+				return
+			}
+
+			var body struct {
+				Error struct {
+					Message string        `json:"message"`
+					Details QueryProblems `json:"details"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode error body: %v", err)
+			}
+			details := body.Error.Details
+			if !slices.Equal(details.Unexpected, tt.wantUnexpected) {
+				t.Errorf("Expected unexpected %v, got %v", tt.wantUnexpected, details.Unexpected)
+			}
+			if !slices.Equal(details.Repeated, tt.wantRepeated) {
+				t.Errorf("Expected repeated %v, got %v", tt.wantRepeated, details.Repeated)
+			}
+			if !slices.Equal(details.Invalid, tt.wantInvalid) {
+				t.Errorf("Expected invalid %v, got %v", tt.wantInvalid, details.Invalid)
+			}
+			if want := []string{"active", "id", "pretty", "since"}; !slices.Equal(details.Allowed, want) {
+				t.Errorf("Expected allowed %v, got %v", want, details.Allowed)
+			}
+			if !strings.Contains(body.Error.Message, "allowed: active, id, pretty, since") {
+				t.Errorf("Expected the message to list what is allowed, got %q", body.Error.Message)
+			}
+		})
+	}
+}
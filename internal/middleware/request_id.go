@@ -1,23 +1,47 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/google/uuid"
+	"user-service/internal/httputil"
+	"user-service/internal/reqctx"
 )
 
-type contextKey string
-
-const RequestIDKey contextKey = "requestID"
+// maxRequestIDLength bounds an X-Request-ID taken from the client
+const maxRequestIDLength = 128
 
+// RequestID middleware gives each request an ID, echoed in the X-Request-ID
+// response header and kept in the request context for logs, error bodies and
+// events. An X-Request-ID sent by the client is kept so a request can be traced
+// across systems, unless it is too long or has characters other than letters,
+// digits and "-._:"; a new UUID is used then.
 func RequestID() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			requestID := uuid.New().String()
-			w.Header().Set("X-Request-ID", requestID)
-			ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+			requestID := r.Header.Get(httputil.RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = uuid.New().String()
+			}
+			w.Header().Set(httputil.RequestIDHeader, requestID)
+			ctx := reqctx.WithRequestID(r.Context(), requestID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// validRequestID reports whether a client's request ID is safe to log and echo back
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length limits on user-supplied strings, in characters
+const (
+	MaxNameLength        = 100
+	MaxEmailLength       = 254
+	MaxSearchQueryLength = 64
+	MaxSubjectLength     = 255
+)
+
+// MaxAvatarURLLength bounds an avatar URL, in bytes
+const MaxAvatarURLLength = 2048
+
+// Sanitize trims surrounding whitespace from the user's text fields. Every
+// transport should call it before Validate so they accept the same input.
+func (u *User) Sanitize() {
+	u.Name = strings.TrimSpace(u.Name)
+	u.Email = strings.TrimSpace(u.Email)
+	u.Role = strings.TrimSpace(u.Role)
+	u.AvatarURL = strings.TrimSpace(u.AvatarURL)
+	u.DisplayName = strings.TrimSpace(u.DisplayName)
+}
+
+// SanitizeSearchQuery trims a free-text search query and applies the same guards
+// as user fields, reporting failures against the "q" field. An empty query is allowed.
+func SanitizeSearchQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+
+	var errs ValidationErrors
+	errs.checkText("q", query, MaxSearchQueryLength)
+	if len(errs) > 0 {
+		return "", errs
+	}
+	return query, nil
+}
+
+// SanitizeSubject trims an identity provider subject and applies the same guards
+// as user fields, reporting failures against the "subject" field. It cannot be empty.
+func SanitizeSubject(subject string) (string, error) {
+	subject = strings.TrimSpace(subject)
+
+	var errs ValidationErrors
+	if subject == "" {
+		errs.add("subject", RuleRequired, "cannot be empty")
+	} else {
+		errs.checkText("subject", subject, MaxSubjectLength)
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+	return subject, nil
+}
+
+// checkText applies the guards every user-supplied string shares: it must be valid
+// UTF-8, at most maxLength characters long and free of control characters such as NUL.
+func (v *ValidationErrors) checkText(field, value string, maxLength int) {
+	if !utf8.ValidString(value) {
+		v.add(field, RuleValidUTF8, "must be valid UTF-8")
+		return
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		v.add(field, RuleMaxLength, "must be at most "+strconv.Itoa(maxLength)+" characters")
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		v.add(field, RuleNoControlChars, "must not contain control characters")
+	}
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestUser_Sanitize(t *testing.T) {
+	user := User{Name: "  John Doe\n", Email: "\tjohn@example.com ", Role: " admin ", AvatarURL: " https://example.com/john.png ", DisplayName: " Johnny "}
+	user.Sanitize()
+
+	want := User{Name: "John Doe", Email: "john@example.com", Role: RoleAdmin, AvatarURL: "https://example.com/john.png", DisplayName: "Johnny"}
+	if user != want {
+		t.Errorf("Sanitize() = %+v, want %+v", user, want)
+	}
+	if err := user.Validate(); err != nil {
+		t.Errorf("Validate() after Sanitize() error = %v", err)
+	}
+}
+
+func TestUser_ValidateGuards(t *testing.T) {
+	tests := []struct {
+		name  string
+		user  User
+		field string
+		rule  string
+	}{
+		{"invalid UTF-8 name", User{Name: "John\xff", Email: "john@example.com"}, "name", RuleValidUTF8},
+		{"NUL in name", User{Name: "John\x00Doe", Email: "john@example.com"}, "name", RuleNoControlChars},
+		{"emoji name too long", User{Name: strings.Repeat("😀", MaxNameLength+1), Email: "john@example.com"}, "name", RuleMaxLength},
+		{"email too long", User{Name: "John", Email: strings.Repeat("a", MaxEmailLength) + "@x"}, "email", RuleMaxLength},
+		{"invalid UTF-8 email", User{Name: "John", Email: "john\xc0@example.com"}, "email", RuleValidUTF8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs ValidationErrors
+			if !errors.As(tt.user.Validate(), &errs) || len(errs) != 1 {
+				t.Fatalf("Validate() = %v, want exactly one error", errs)
+			}
+			if errs[0].Field != tt.field || errs[0].Rule != tt.rule {
+				t.Errorf("Validate() = %+v, want %s %s", errs[0], tt.field, tt.rule)
+			}
+		})
+	}
+}
+
+func TestSanitizeSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+		wantErr string
+	}{
+		{"trimmed", "  auth0|alice ", "auth0|alice", ""},
+		{"empty", "  ", "", RuleRequired},
+		{"too long", strings.Repeat("s", MaxSubjectLength+1), "", RuleMaxLength},
+		{"control character", "auth0|al\x00ice", "", RuleNoControlChars},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeSubject(tt.subject)
+			if tt.wantErr == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("SanitizeSubject() = %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || errs[0].Field != "subject" || errs[0].Rule != tt.wantErr {
+				t.Errorf("SanitizeSubject() error = %v, want rule %s on subject", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSanitizeSearchQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr string
+	}{
+		{"empty", "", "", ""},
+		{"trimmed", "  john  ", "john", ""},
+		{"longest allowed", strings.Repeat("q", MaxSearchQueryLength), strings.Repeat("q", MaxSearchQueryLength), ""},
+		{"too long", strings.Repeat("q", MaxSearchQueryLength+1), "", RuleMaxLength},
+		{"control character", "jo\x00hn", "", RuleNoControlChars},
+		{"invalid UTF-8", "jo\xffhn", "", RuleValidUTF8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeSearchQuery(tt.query)
+			if tt.wantErr == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("SanitizeSearchQuery() = %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || errs[0].Field != "q" || errs[0].Rule != tt.wantErr {
+				t.Errorf("SanitizeSearchQuery() error = %v, want q %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// checkSanitized fails the test unless value is something the guards accept
+func checkSanitized(t *testing.T, field, value string, maxLength int) {
+	t.Helper()
+	if !utf8.ValidString(value) {
+		t.Errorf("%s %q is not valid UTF-8", field, value)
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		t.Errorf("%s %q is longer than %d characters", field, value, maxLength)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		t.Errorf("%s %q contains a control character", field, value)
+	}
+	if strings.TrimSpace(value) != value {
+		t.Errorf("%s %q has surrounding whitespace", field, value)
+	}
+}
+
+func FuzzUserSanitize(f *testing.F) {
+	f.Add("John Doe", "john@example.com")
+	f.Add("  padded  ", " john@example.com\n")
+	f.Add("John\x00", "john\xff@example.com")
+	f.Add(strings.Repeat("😀", MaxNameLength+1), "@")
+
+	f.Fuzz(func(t *testing.T, name, email string) {
+		user := User{Name: name, Email: email}
+		user.Sanitize()
+
+		err := user.Validate()
+		if err == nil {
+			checkSanitized(t, "name", user.Name, MaxNameLength)
+			checkSanitized(t, "email", user.Email, MaxEmailLength)
+			if user.Name == "" || !strings.Contains(user.Email, "@") {
+				t.Errorf("accepted incomplete user %+v", user)
+			}
+			return
+		}
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) || len(errs) == 0 {
+			t.Fatalf("Validate() error = %v, want ValidationErrors", err)
+		}
+		for _, e := range errs {
+			if e.Field != "name" && e.Field != "email" {
+				t.Errorf("unexpected field %q in %v", e.Field, errs)
+			}
+		}
+	})
+}
+
+func FuzzSanitizeSearchQuery(f *testing.F) {
+	f.Add("john")
+	f.Add("  john doe\t")
+	f.Add("jo\x00hn")
+	f.Add(strings.Repeat("é", MaxSearchQueryLength+1))
+
+	f.Fuzz(func(t *testing.T, query string) {
+		got, err := SanitizeSearchQuery(query)
+		if err != nil {
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || errs[0].Field != "q" {
+				t.Fatalf("SanitizeSearchQuery() error = %v, want ValidationErrors on q", err)
+			}
+			return
+		}
+		checkSanitized(t, "q", got, MaxSearchQueryLength)
+	})
+}
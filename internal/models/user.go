@@ -1,42 +1,289 @@
 package models
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Roles a user can have
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+	RoleGuest = "guest"
+)
+
+// DefaultRole is assigned to users created without a role
+const DefaultRole = RoleUser
+
+// Account statuses. Disabled users keep their data but may not sign in.
+const (
+	StatusActive   = "active"
+	StatusDisabled = "disabled"
+)
+
+// Statuses lists every account status
+var Statuses = []string{StatusActive, StatusDisabled}
+
 // User represents a user in the system
 type User struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set once the user is soft-deleted. Deleted users are only visible to admins.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// AvatarURL and DisplayName are optional profile fields for the UI
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// GravatarFallback makes users without an avatar_url of their own encode with
+// their Gravatar instead. It is set at startup.
+var GravatarFallback bool
+
+// MarshalJSON encodes the user, with GravatarURL of its email as avatar_url when
+// it has none and GravatarFallback is on. The derived URL is never stored.
+func (u User) MarshalJSON() ([]byte, error) {
+	// user has User's fields without this method, so encoding it does not recurse
+	type user User
+	if u.AvatarURL == "" && u.Email != "" && GravatarFallback {
+		u.AvatarURL = GravatarURL(u.Email)
+	}
+	return json.Marshal(user(u))
+}
+
+// GravatarURL returns the Gravatar of email, which Gravatar keys by the MD5 of the
+// trimmed, lower-cased address. Emails without one get a generated identicon.
+func GravatarURL(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?d=identicon"
+}
+
+// UserFilter narrows a user listing. Zero fields do not filter.
+type UserFilter struct {
+	Role   string
+	Status string
+	// CreatedAfter and CreatedBefore are exclusive bounds on CreatedAt
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// Matches reports whether user passes the filter
+func (f UserFilter) Matches(user User) bool {
+	if f.Role != "" && user.Role != f.Role {
+		return false
+	}
+	if f.Status != "" && user.Status != f.Status {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !user.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !user.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// UserPatch lists the fields a partial update changes. Nil fields are left as they are.
+type UserPatch struct {
+	Name        *string `json:"name"`
+	Email       *string `json:"email"`
+	AvatarURL   *string `json:"avatar_url"`
+	DisplayName *string `json:"display_name"`
+}
+
+// Empty reports whether the patch changes nothing
+func (p UserPatch) Empty() bool {
+	return p.Name == nil && p.Email == nil && p.AvatarURL == nil && p.DisplayName == nil
+}
+
+// Apply returns user with the fields the patch sets replaced
+func (p UserPatch) Apply(user User) User {
+	if p.Name != nil {
+		user.Name = *p.Name
+	}
+	if p.Email != nil {
+		user.Email = *p.Email
+	}
+	if p.AvatarURL != nil {
+		user.AvatarURL = *p.AvatarURL
+	}
+	if p.DisplayName != nil {
+		user.DisplayName = *p.DisplayName
+	}
+	return user
+}
+
+// Validation rules a field can fail
+const (
+	RuleRequired       = "required"
+	RuleValidUTF8      = "valid_utf8"
+	RuleMaxLength      = "max_length"
+	RuleNoControlChars = "no_control_chars"
+	RuleEmailFormat    = "email_format"
+	RuleEmailDomain    = "email_domain"
+	RuleURLFormat      = "url_format"
+	RuleOneOf          = "one_of"
+	RuleReadOnly       = "read_only"
+)
+
+// FieldError describes one validation rule a field failed
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
-// Validate checks if the user data is valid
+// ValidationErrors lists every rule a value failed, in field order
+type ValidationErrors []FieldError
+
+// Error joins every problem into one line, for logs
+func (v ValidationErrors) Error() string {
+	problems := make([]string, len(v))
+	for i, e := range v {
+		problems[i] = e.Field + " " + e.Message
+	}
+	return strings.Join(problems, "; ")
+}
+
+// add records that field failed rule
+func (v *ValidationErrors) add(field, rule, message string) {
+	*v = append(*v, FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Validate checks if the user data is valid. It reports every failed rule
+// at once as ValidationErrors. Call Sanitize first to normalize the input.
 func (u *User) Validate() error {
+	var errs ValidationErrors
+
 	if u.Name == "" {
-		return fmt.Errorf("name cannot be empty")
+		errs.add("name", RuleRequired, "cannot be empty")
+	} else {
+		errs.checkText("name", u.Name, MaxNameLength)
 	}
-	if u.Email == "" {
-		return fmt.Errorf("email cannot be empty")
+
+	errs.checkEmail(u.Email)
+
+	// The profile fields are optional
+	if u.AvatarURL != "" {
+		if len(u.AvatarURL) > MaxAvatarURLLength {
+			errs.add("avatar_url", RuleMaxLength, "must be at most "+strconv.Itoa(MaxAvatarURLLength)+" bytes")
+		} else if avatar, err := url.Parse(u.AvatarURL); err != nil || (avatar.Scheme != "http" && avatar.Scheme != "https") || avatar.Host == "" {
+			errs.add("avatar_url", RuleURLFormat, "must be an absolute http or https URL")
+		}
 	}
-	if !strings.Contains(u.Email, "@") {
-		return fmt.Errorf("email must contain @")
+	if u.DisplayName != "" {
+		errs.checkText("display_name", u.DisplayName, MaxNameLength)
+	}
+
+	// An empty role is allowed and means DefaultRole
+	if u.Role != "" && !ValidRole(u.Role) {
+		errs.add("role", RuleOneOf, "must be one of admin, user, guest")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateEmail checks email by the rules Validate applies to a user's, reporting
+// every failed rule as ValidationErrors. Call it on a trimmed email.
+func ValidateEmail(email string) error {
+	var errs ValidationErrors
+	errs.checkEmail(email)
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
 
-// ParseUserID converts a string ID to an integer
+// checkEmail records the rules email fails
+func (v *ValidationErrors) checkEmail(email string) {
+	if email == "" {
+		v.add("email", RuleRequired, "cannot be empty")
+		return
+	}
+	if !strings.Contains(email, "@") {
+		v.add("email", RuleEmailFormat, "must contain @")
+	} else if !emailDomainAllowed(email) {
+		v.add("email", RuleEmailDomain, "must be at "+strings.Join(AllowedEmailDomains, " or "))
+	}
+	v.checkText("email", email, MaxEmailLength)
+}
+
+// AllowedEmailDomains are the only domains user emails may be at, such as
+// "example.com", compared without regard to case; subdomains are not included.
+// Empty allows any domain. It is set at startup.
+var AllowedEmailDomains []string
+
+// emailDomainAllowed reports whether email is at one of the AllowedEmailDomains
+func emailDomainAllowed(email string) bool {
+	if len(AllowedEmailDomains) == 0 {
+		return true
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range AllowedEmailDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole reports whether role is one of the allowed roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleUser, RoleGuest:
+		return true
+	}
+	return false
+}
+
+// ValidStatus reports whether status is one of the account statuses
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusActive, StatusDisabled:
+		return true
+	}
+	return false
+}
+
+// MaxUserID is the largest user ID ParseUserID accepts. It defaults to the largest
+// the id column, a Postgres integer, holds and may be lowered at startup so that
+// requests for IDs no user can have are rejected without a lookup.
+var MaxUserID = math.MaxInt32
+
+// ParseUserID converts a string ID to an integer. Surrounding whitespace is
+// ignored, and what is left must be ASCII digits, leading zeros allowed: "05" is
+// 5, while "+5" and "5.0" are invalid. A leading "-" is a number below 1 and, like
+// IDs above MaxUserID or too large for any integer, gets an out-of-range error
+// distinct from the one for non-numeric input.
 func ParseUserID(idStr string) (int, error) {
+	idStr = strings.TrimSpace(idStr)
 	if idStr == "" {
 		return 0, fmt.Errorf("id parameter is missing")
 	}
 
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
+	digits := strings.TrimPrefix(idStr, "-")
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
 		return 0, fmt.Errorf("id parameter is invalid")
 	}
+	id, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || digits != idStr || id < 1 || id > int64(MaxUserID) {
+		return 0, fmt.Errorf("id parameter is out of range: must be between 1 and %d", MaxUserID)
+	}
 
-	return id, nil
+	return int(id), nil
 }
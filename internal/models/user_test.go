@@ -1,7 +1,12 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUser_Validate(t *testing.T) {
@@ -30,6 +35,16 @@ func TestUser_Validate(t *testing.T) {
 			user:    User{Name: "test", Email: "test"},
 			wantErr: true,
 		},
+		{
+			name:    "valid role",
+			user:    User{Name: "test", Email: "test@test.com", Role: RoleAdmin},
+			wantErr: false,
+		},
+		{
+			name:    "invalid role",
+			user:    User{Name: "test", Email: "test@test.com", Role: "superuser"},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -40,12 +55,300 @@ func TestUser_Validate(t *testing.T) {
 	}
 }
 
-func TestParseUserID(t *testing.T) {
+func TestUser_ValidateReportsEveryField(t *testing.T) {
+	user := User{Email: "invalid-email", Role: "superuser"}
+	err := user.Validate()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() error = %v, want ValidationErrors", err)
+	}
+	want := ValidationErrors{
+		{Field: "name", Rule: RuleRequired, Message: "cannot be empty"},
+		{Field: "email", Rule: RuleEmailFormat, Message: "must contain @"},
+		{Field: "role", Rule: RuleOneOf, Message: "must be one of admin, user, guest"},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("Validate() = %v, want %v", errs, want)
+	}
+	if msg := err.Error(); msg != "name cannot be empty; email must contain @; role must be one of admin, user, guest" {
+		t.Errorf("Error() = %q", msg)
+	}
+}
+
+func TestUser_ValidateEmailDomain(t *testing.T) {
+	defer func(domains []string) { AllowedEmailDomains = domains }(AllowedEmailDomains)
+
 	tests := []struct {
 		name    string
-		idStr   string
-		want    int
+		domains []string
+		email   string
 		wantErr bool
+	}{
+		{"unset allows any domain", nil, "john@gmail.com", false},
+		{"allowed domain", []string{"example.com", "corp.example.org"}, "john@corp.example.org", false},
+		{"allowed domain in another case", []string{"example.com"}, "john@Example.COM", false},
+		{"disallowed domain", []string{"example.com"}, "john@gmail.com", true},
+		{"subdomain of an allowed domain", []string{"example.com"}, "john@mail.example.com", true},
+		{"allowed domain as a prefix", []string{"example.com"}, "john@example.com.evil.io", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AllowedEmailDomains = tt.domains
+			user := &User{Name: "John Doe", Email: tt.email}
+			err := user.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			want := ValidationErrors{{Field: "email", Rule: RuleEmailDomain, Message: "must be at " + strings.Join(tt.domains, " or ")}}
+			if !reflect.DeepEqual(err, want) {
+				t.Errorf("Validate() = %v, want %v", err, want)
+			}
+		})
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  []string
+	}{
+		{"john@example.com", nil},
+		{"", []string{RuleRequired}},
+		{"john.example.com", []string{RuleEmailFormat}},
+		{"john\x00@example.com", []string{RuleNoControlChars}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			var rules []string
+			var errs ValidationErrors
+			if errors.As(ValidateEmail(tt.email), &errs) {
+				for _, e := range errs {
+					rules = append(rules, e.Rule)
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("failed rules = %v, want %v", rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestUser_ValidateNameRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"longest allowed", strings.Repeat("a", MaxNameLength), nil},
+		{"longest allowed multibyte", strings.Repeat("é", MaxNameLength), nil},
+		{"too long", strings.Repeat("a", MaxNameLength+1), []string{RuleMaxLength}},
+		{"newline", "John\nDoe", []string{RuleNoControlChars}},
+		{"NUL", "John\x00", []string{RuleNoControlChars}},
+		{"too long with tab", strings.Repeat("a", MaxNameLength) + "\t", []string{RuleMaxLength, RuleNoControlChars}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := User{Name: tt.value, Email: "test@test.com"}
+			var rules []string
+			var errs ValidationErrors
+			if errors.As(user.Validate(), &errs) {
+				for _, e := range errs {
+					if e.Field != "name" {
+						t.Errorf("unexpected error on field %q", e.Field)
+					}
+					rules = append(rules, e.Rule)
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("failed rules = %v, want %v", rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestUser_JSONRole(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2024, 3, 2, 8, 30, 0, 0, time.UTC)
+	data, err := json.Marshal(User{ID: 1, Name: "test", Email: "test@test.com", Role: RoleGuest, Status: StatusActive, CreatedAt: createdAt, UpdatedAt: updatedAt})
+	if err != nil {
+		t.Fatalf("Failed to marshal user: %v", err)
+	}
+	if want := `{"id":1,"name":"test","email":"test@test.com","role":"guest","status":"active","created_at":"2024-03-01T12:00:00Z","updated_at":"2024-03-02T08:30:00Z"}`; string(data) != want {
+		t.Errorf("json.Marshal() = %s, want %s", data, want)
+	}
+
+	var user User
+	if err := json.Unmarshal(data, &user); err != nil {
+		t.Fatalf("Failed to unmarshal user: %v", err)
+	}
+	if user.Role != RoleGuest {
+		t.Errorf("Role = %q, want %q", user.Role, RoleGuest)
+	}
+	if !user.CreatedAt.Equal(createdAt) || !user.UpdatedAt.Equal(updatedAt) {
+		t.Errorf("CreatedAt, UpdatedAt = %v, %v, want %v, %v", user.CreatedAt, user.UpdatedAt, createdAt, updatedAt)
+	}
+}
+
+func TestUser_ValidateProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		avatarURL   string
+		displayName string
+		want        []FieldError
+	}{
+		{"unset", "", "", nil},
+		{"https avatar", "https://cdn.example.com/avatars/john.png", "Johnny", nil},
+		{"http avatar", "http://example.com/john.png", "", nil},
+		{"relative avatar", "/avatars/john.png", "", []FieldError{{"avatar_url", RuleURLFormat, "must be an absolute http or https URL"}}},
+		{"other scheme", "ftp://example.com/john.png", "", []FieldError{{"avatar_url", RuleURLFormat, "must be an absolute http or https URL"}}},
+		{"no host", "https:///john.png", "", []FieldError{{"avatar_url", RuleURLFormat, "must be an absolute http or https URL"}}},
+		{"longest avatar", "https://example.com/" + strings.Repeat("a", MaxAvatarURLLength-20), "", nil},
+		{"too long avatar", "https://example.com/" + strings.Repeat("a", MaxAvatarURLLength-19), "", []FieldError{{"avatar_url", RuleMaxLength, "must be at most 2048 bytes"}}},
+		{"display name with control characters", "", "John\nny", []FieldError{{"display_name", RuleNoControlChars, "must not contain control characters"}}},
+		{"too long display name", "", strings.Repeat("a", MaxNameLength+1), []FieldError{{"display_name", RuleMaxLength, "must be at most 100 characters"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := User{Name: "John Doe", Email: "john@example.com", AvatarURL: tt.avatarURL, DisplayName: tt.displayName}
+			var got []FieldError
+			var errs ValidationErrors
+			if errors.As(user.Validate(), &errs) {
+				got = errs
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUser_JSONAvatar(t *testing.T) {
+	defer func(fallback bool) { GravatarFallback = fallback }(GravatarFallback)
+	const gravatar = "https://www.gravatar.com/avatar/d4c74594d841139328695756648b6bd6?d=identicon"
+
+	if got := GravatarURL(" John@Example.COM "); got != gravatar {
+		t.Errorf("GravatarURL() = %s, want %s", got, gravatar)
+	}
+
+	tests := []struct {
+		name     string
+		fallback bool
+		user     User
+		want     string
+	}{
+		{"fallback off", false, User{Email: "john@example.com"}, ""},
+		{"derived", true, User{Email: "john@example.com"}, gravatar},
+		{"explicit", true, User{Email: "john@example.com", AvatarURL: "https://example.com/john.png"}, "https://example.com/john.png"},
+		{"explicit with fallback off", false, User{Email: "john@example.com", AvatarURL: "https://example.com/john.png"}, "https://example.com/john.png"},
+		{"no email", true, User{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			GravatarFallback = tt.fallback
+			data, err := json.Marshal(tt.user)
+			if err != nil {
+				t.Fatalf("Failed to marshal user: %v", err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Failed to unmarshal user: %v", err)
+			}
+			avatar, ok := got["avatar_url"]
+			if tt.want == "" && ok {
+				t.Errorf("Expected no avatar_url, got %v", avatar)
+			}
+			if tt.want != "" && avatar != tt.want {
+				t.Errorf("avatar_url = %v, want %s", avatar, tt.want)
+			}
+			if _, ok := got["display_name"]; ok {
+				t.Errorf("Expected an empty display_name to be left out, got %s", data)
+			}
+		})
+	}
+
+	// Pointers encode the same, and the derived URL is not kept on the user
+	GravatarFallback = true
+	user := &User{Email: "john@example.com"}
+	if data, err := json.Marshal(user); err != nil || !strings.Contains(string(data), gravatar) {
+		t.Errorf("json.Marshal(&user) = %s, %v, want the Gravatar", data, err)
+	}
+	if user.AvatarURL != "" {
+		t.Errorf("Expected AvatarURL to stay empty, got %s", user.AvatarURL)
+	}
+}
+
+func TestUserFilter_Matches(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	user := User{Role: RoleAdmin, Status: StatusDisabled, CreatedAt: created}
+
+	tests := []struct {
+		name   string
+		filter UserFilter
+		want   bool
+	}{
+		{"empty filter", UserFilter{}, true},
+		{"matching role", UserFilter{Role: RoleAdmin}, true},
+		{"other role", UserFilter{Role: RoleGuest}, false},
+		{"matching status", UserFilter{Status: StatusDisabled}, true},
+		{"other status", UserFilter{Status: StatusActive}, false},
+		{"created after earlier time", UserFilter{CreatedAfter: created.Add(-time.Hour)}, true},
+		{"created after is exclusive", UserFilter{CreatedAfter: created}, false},
+		{"created before later time", UserFilter{CreatedBefore: created.Add(time.Hour)}, true},
+		{"created before is exclusive", UserFilter{CreatedBefore: created}, false},
+		{"inside range with role", UserFilter{Role: RoleAdmin, CreatedAfter: created.Add(-time.Hour), CreatedBefore: created.Add(time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(user); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range []string{RoleAdmin, RoleUser, RoleGuest} {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false, want true", role)
+		}
+	}
+	for _, role := range []string{"", "Admin", "superuser"} {
+		if ValidRole(role) {
+			t.Errorf("ValidRole(%q) = true, want false", role)
+		}
+	}
+}
+
+func TestValidStatus(t *testing.T) {
+	for _, status := range Statuses {
+		if !ValidStatus(status) {
+			t.Errorf("ValidStatus(%q) = false, want true", status)
+		}
+	}
+	for _, status := range []string{"", "Active", "banned"} {
+		if ValidStatus(status) {
+			t.Errorf("ValidStatus(%q) = true, want false", status)
+		}
+	}
+}
+
+func TestParseUserID(t *testing.T) {
+	tests := []struct {
+		name     string
+		idStr    string
+		want     int
+		wantErr  bool
+		outRange bool
 	}{
 		{
 			name:    "valid id",
@@ -65,6 +368,70 @@ func TestParseUserID(t *testing.T) {
 			want:    0,
 			wantErr: true,
 		},
+		{
+			name:  "largest id",
+			idStr: "2147483647",
+			want:  2147483647,
+		},
+		{
+			name:     "above int32",
+			idStr:    "2147483648",
+			wantErr:  true,
+			outRange: true,
+		},
+		{
+			name:     "overflows int64",
+			idStr:    "99999999999999999999",
+			wantErr:  true,
+			outRange: true,
+		},
+		{
+			name:     "negative id",
+			idStr:    "-5",
+			wantErr:  true,
+			outRange: true,
+		},
+		{
+			name:     "zero id",
+			idStr:    "0",
+			wantErr:  true,
+			outRange: true,
+		},
+		{
+			name:  "surrounding whitespace",
+			idStr: " 5 ",
+			want:  5,
+		},
+		{
+			name:    "only whitespace",
+			idStr:   "  ",
+			wantErr: true,
+		},
+		{
+			name:    "plus sign",
+			idStr:   "+5",
+			wantErr: true,
+		},
+		{
+			name:  "leading zero",
+			idStr: "05",
+			want:  5,
+		},
+		{
+			name:    "decimal",
+			idStr:   "5.0",
+			wantErr: true,
+		},
+		{
+			name:    "inner whitespace",
+			idStr:   "5 0",
+			wantErr: true,
+		},
+		{
+			name:    "minus sign alone",
+			idStr:   "-",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -73,9 +440,25 @@ func TestParseUserID(t *testing.T) {
 				t.Errorf("ParseUserID() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if err != nil && strings.Contains(err.Error(), "out of range") != tt.outRange {
+				t.Errorf("ParseUserID() error = %v, want out of range %v", err, tt.outRange)
+			}
 			if got != tt.want {
 				t.Errorf("ParseUserID() = %v, want %v", got, tt.want)
 			}
 		})
 	}
+
+	t.Run("lowered maximum", func(t *testing.T) {
+		defer func(max int) { MaxUserID = max }(MaxUserID)
+		MaxUserID = 1000
+
+		if got, err := ParseUserID("1000"); err != nil || got != 1000 {
+			t.Errorf("ParseUserID(1000) = %v, %v, want 1000", got, err)
+		}
+		_, err := ParseUserID("1001")
+		if err == nil || err.Error() != "id parameter is out of range: must be between 1 and 1000" {
+			t.Errorf("ParseUserID(1001) error = %v, want out of range up to 1000", err)
+		}
+	})
 }
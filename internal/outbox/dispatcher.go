@@ -0,0 +1,107 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+)
+
+// Dispatch policy
+const (
+	// DefaultInterval is how often the dispatcher polls the outbox
+	DefaultInterval = time.Second
+	batchSize       = 100
+	// claimLease holds a claimed batch back from the other replicas' dispatchers. A
+	// dispatcher that dies holding one leaves its messages to them once it ends.
+	claimLease = time.Minute
+	// A message that fails is retried after retryBackoff, doubling with each failure up to maxBackoff
+	retryBackoff = time.Second
+	maxBackoff   = 5 * time.Minute
+)
+
+// Dispatcher publishes queued events. Delivery is at least once: a message that is
+// published but cannot be marked sent is published again once its claim lapses.
+// Each replica runs one, and a message is claimed by one dispatcher at a time.
+type Dispatcher struct {
+	store     Store
+	publisher events.EventPublisher
+	metrics   metrics.Recorder
+	interval  time.Duration
+	now       func() time.Time
+}
+
+// NewDispatcher creates a dispatcher publishing the messages in store through publisher every interval
+func NewDispatcher(store Store, publisher events.EventPublisher, metricsCollector metrics.Recorder, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		store:     store,
+		publisher: publisher,
+		metrics:   metricsCollector,
+		interval:  interval,
+		now:       time.Now,
+	}
+}
+
+// Run dispatches on every tick until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Tick(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to dispatch outbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick publishes the messages that are due, holding back each one that fails, and
+// updates the outbox lag gauge
+func (d *Dispatcher) Tick(ctx context.Context) error {
+	now := d.now()
+	messages, err := d.store.Claim(ctx, now, claimLease, batchSize)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	for _, m := range messages {
+		if err := d.publisher.Publish(ctx, m.Event); err != nil {
+			next := now.Add(backoff(m.Attempts + 1))
+			slog.Warn("Failed to publish outbox message", "id", m.ID, "type", m.Event.Type, "attempts", m.Attempts+1, "retry_at", next, "error", err)
+			if err := d.store.MarkFailed(ctx, m.ID, next); err != nil {
+				return fmt.Errorf("failed to mark outbox message %d failed: %w", m.ID, err)
+			}
+			continue
+		}
+		if err := d.store.MarkSent(ctx, m.ID); err != nil {
+			return fmt.Errorf("failed to mark outbox message %d sent: %w", m.ID, err)
+		}
+	}
+
+	oldest, err := d.store.OldestPending(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox lag: %w", err)
+	}
+	lag := 0.0
+	if !oldest.IsZero() {
+		lag = max(now.Sub(oldest).Seconds(), 0)
+	}
+	d.metrics.SetOutboxLag(lag)
+	return nil
+}
+
+// backoff returns how long to hold a message back after its nth failed attempt
+func backoff(attempts int) time.Duration {
+	delay := retryBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"user-service/internal/events"
+)
+
+// memoryMessage is a message with its dispatch state
+type memoryMessage struct {
+	Message
+	next time.Time
+	sent bool
+}
+
+// memoryStore keeps messages in a slice, for tests and local development
+type memoryStore struct {
+	mu       sync.Mutex
+	messages []memoryMessage
+}
+
+// NewMemoryStore creates an empty in-memory outbox
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+// Add appends a message with the next ID, due immediately
+func (s *memoryStore) Add(_ context.Context, event events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, memoryMessage{Message: Message{
+		ID:        int64(len(s.messages) + 1),
+		Event:     event,
+		CreatedAt: time.Now(),
+	}})
+	return nil
+}
+
+// Claim returns up to limit unsent messages that are due at now, oldest first,
+// and holds them back for lease
+func (s *memoryStore) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []Message
+	for i := range s.messages {
+		if len(messages) == limit {
+			break
+		}
+		if m := &s.messages[i]; !m.sent && !m.next.After(now) {
+			m.next = now.Add(lease)
+			messages = append(messages, m.Message)
+		}
+	}
+	return messages, nil
+}
+
+// MarkSent removes a message from the queue
+func (s *memoryStore) MarkSent(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.message(id); m != nil {
+		m.sent = true
+	}
+	return nil
+}
+
+// MarkFailed counts a failed attempt and holds the message back until next
+func (s *memoryStore) MarkFailed(_ context.Context, id int64, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.message(id); m != nil {
+		m.Attempts++
+		m.next = next
+	}
+	return nil
+}
+
+// OldestPending returns when the oldest unsent message was queued
+func (s *memoryStore) OldestPending(_ context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.messages {
+		if !m.sent {
+			return m.CreatedAt, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// message returns the message with id; the caller must hold mu
+func (s *memoryStore) message(id int64) *memoryMessage {
+	if id < 1 || id > int64(len(s.messages)) {
+		return nil
+	}
+	return &s.messages[id-1]
+}
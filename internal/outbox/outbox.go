@@ -0,0 +1,35 @@
+// Package outbox queues user events in the transaction of the change they describe
+// and dispatches them once it commits, so a crash between the two cannot lose an event.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"user-service/internal/events"
+)
+
+// Message is a queued event
+type Message struct {
+	ID       int64
+	Event    events.Event
+	Attempts int
+	// CreatedAt is when the event was queued
+	CreatedAt time.Time
+}
+
+// Store keeps queued events until they are sent
+type Store interface {
+	// Add queues event for dispatch
+	Add(ctx context.Context, event events.Event) error
+	// Claim returns up to limit unsent messages that are due at now, oldest first,
+	// and holds them back for lease, so dispatchers of other replicas skip them
+	// until this one has marked them sent or failed, or has died
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Message, error)
+	// MarkSent removes a message from the queue
+	MarkSent(ctx context.Context, id int64) error
+	// MarkFailed counts a failed attempt and holds the message back until next
+	MarkFailed(ctx context.Context, id int64, next time.Time) error
+	// OldestPending returns when the oldest unsent message was queued, or the zero time when there is none
+	OldestPending(ctx context.Context) (time.Time, error)
+}
@@ -0,0 +1,339 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+)
+
+// fakePublisher records published events and fails while err is set
+type fakePublisher struct {
+	published []events.Event
+	err       error
+}
+
+func (p *fakePublisher) Publish(_ context.Context, event events.Event) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+// lagValue returns the current outbox_lag_seconds gauge
+func lagValue(t *testing.T, reg *prometheus.Registry) float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "outbox_lag_seconds" {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	oldest, err := store.OldestPending(ctx)
+	assert.NoError(t, err)
+	assert.True(t, oldest.IsZero())
+
+	for _, eventType := range []string{events.TypeUserCreated, events.TypeUserUpdated, events.TypeUserDeleted} {
+		assert.NoError(t, store.Add(ctx, events.Event{Type: eventType}))
+	}
+
+	messages, err := store.Claim(ctx, now, time.Minute, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, messageIDs(messages))
+	assert.Equal(t, events.TypeUserCreated, messages[0].Event.Type)
+
+	// Claimed messages are held back from the next claim
+	messages, err = store.Claim(ctx, now, time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3}, messageIDs(messages))
+
+	assert.NoError(t, store.MarkSent(ctx, 1))
+	assert.NoError(t, store.MarkFailed(ctx, 2, now.Add(time.Second)))
+
+	// Once the lease ends, the claimed message left unmarked is due again
+	messages, err = store.Claim(ctx, now.Add(time.Second), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, messageIDs(messages))
+
+	messages, err = store.Claim(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, messageIDs(messages))
+	assert.Equal(t, 1, messages[0].Attempts)
+
+	oldest, err = store.OldestPending(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, messages[0].CreatedAt, oldest)
+}
+
+func TestPgxStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("add", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Exec", ctx, InsertMessage, events.TypeUserCreated, mock.MatchedBy(func(payload []byte) bool {
+			return assert.Contains(t, string(payload), `"type":"user.created"`)
+		})).Return(pgconn.CommandTag("INSERT 0 1"), nil)
+
+		assert.NoError(t, NewPgxStore(db).Add(ctx, events.Event{Type: events.TypeUserCreated}))
+		db.AssertExpectations(t)
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(true).Once()
+		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
+		rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int64) = 9
+			*arg[1].(*[]byte) = []byte(`{"type":"user.deleted","user":{"id":3}}`)
+			*arg[2].(*int) = 2
+			*arg[3].(*time.Time) = now
+		})
+		db := &mocks.MockDBTX{}
+		db.On("Query", ctx, ClaimMessages, now, now.Add(time.Minute), 100).Return(rows, nil)
+
+		messages, err := NewPgxStore(db).Claim(ctx, now, time.Minute, 100)
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 1) {
+			assert.Equal(t, Message{ID: 9, Event: events.Event{Type: events.TypeUserDeleted, User: models.User{ID: 3}}, Attempts: 2, CreatedAt: now}, messages[0])
+		}
+		db.AssertExpectations(t)
+	})
+
+	t.Run("mark sent and failed", func(t *testing.T) {
+		next := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		db := &mocks.MockDBTX{}
+		db.On("Exec", ctx, MarkMessageSent, int64(1)).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		db.On("Exec", ctx, MarkMessageFailed, int64(2), next).Return(pgconn.CommandTag("UPDATE 1"), nil)
+
+		store := NewPgxStore(db)
+		assert.NoError(t, store.MarkSent(ctx, 1))
+		assert.NoError(t, store.MarkFailed(ctx, 2, next))
+		db.AssertExpectations(t)
+	})
+
+	t.Run("oldest pending", func(t *testing.T) {
+		queued := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).([]interface{})[0].(**time.Time) = &queued
+		})
+		db := &mocks.MockDBTX{}
+		db.On("QueryRow", ctx, OldestPendingMessages).Return(row)
+
+		oldest, err := NewPgxStore(db).OldestPending(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, queued, oldest)
+	})
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+
+	newDispatcher := func(store Store, publisher events.EventPublisher) (*Dispatcher, *time.Time, *prometheus.Registry) {
+		reg := prometheus.NewRegistry()
+		d := NewDispatcher(store, publisher, metrics.New(reg, reg), DefaultInterval)
+		now := time.Now()
+		d.now = func() time.Time { return now }
+		return d, &now, reg
+	}
+
+	t.Run("publishes and marks sent", func(t *testing.T) {
+		store := NewMemoryStore()
+		assert.NoError(t, store.Add(ctx, events.Event{Type: events.TypeUserCreated}))
+		assert.NoError(t, store.Add(ctx, events.Event{Type: events.TypeUserDeleted}))
+		publisher := &fakePublisher{}
+		d, _, reg := newDispatcher(store, publisher)
+
+		assert.NoError(t, d.Tick(ctx))
+		assert.Len(t, publisher.published, 2)
+		assert.Equal(t, 0.0, lagValue(t, reg))
+
+		// Sent messages are never published again
+		assert.NoError(t, d.Tick(ctx))
+		assert.Len(t, publisher.published, 2)
+	})
+
+	t.Run("retries failed message after backoff", func(t *testing.T) {
+		store := NewMemoryStore()
+		assert.NoError(t, store.Add(ctx, events.Event{Type: events.TypeUserCreated}))
+		publisher := &fakePublisher{err: errors.New("broker unavailable")}
+		d, now, reg := newDispatcher(store, publisher)
+
+		assert.NoError(t, d.Tick(ctx))
+		assert.Empty(t, publisher.published)
+		assert.Greater(t, lagValue(t, reg), 0.0)
+
+		// The message is held back until its backoff has passed
+		publisher.err = nil
+		*now = now.Add(retryBackoff / 2)
+		assert.NoError(t, d.Tick(ctx))
+		assert.Empty(t, publisher.published)
+
+		*now = now.Add(retryBackoff)
+		assert.NoError(t, d.Tick(ctx))
+		assert.Len(t, publisher.published, 1)
+		assert.Equal(t, 0.0, lagValue(t, reg))
+	})
+
+	t.Run("two dispatchers publish each message once", func(t *testing.T) {
+		store := NewMemoryStore()
+		for id := 1; id <= 3*batchSize; id++ {
+			assert.NoError(t, store.Add(ctx, events.Event{Type: events.TypeUserCreated, User: models.User{ID: id}}))
+		}
+		publishers := []*fakePublisher{{}, {}}
+
+		var wg sync.WaitGroup
+		for _, publisher := range publishers {
+			d, _, _ := newDispatcher(store, publisher)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 3; i++ {
+					assert.NoError(t, d.Tick(ctx))
+				}
+			}()
+		}
+		wg.Wait()
+
+		published := map[int]int{}
+		for _, publisher := range publishers {
+			for _, event := range publisher.published {
+				published[event.User.ID]++
+			}
+		}
+		assert.Len(t, published, 3*batchSize)
+		for id, count := range published {
+			assert.Equal(t, 1, count, "user %d", id)
+		}
+	})
+
+	// pendingRows returns database rows holding one pending user.created message with id
+	pendingRows := func(id int64, attempts int) *mocks.MockRows {
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(true).Once()
+		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
+		rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int64) = id
+			*arg[1].(*[]byte) = []byte(`{"type":"user.created","user":{"id":5}}`)
+			*arg[2].(*int) = attempts
+			*arg[3].(*time.Time) = time.Now()
+		})
+		return rows
+	}
+	// emptyOutbox answers the lag query at the end of a tick with an empty outbox
+	emptyOutbox := func(db *mocks.MockDBTX) {
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(nil)
+		db.On("QueryRow", ctx, OldestPendingMessages).Return(row)
+	}
+
+	t.Run("marks the database row sent on success", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Query", ctx, ClaimMessages, mock.Anything, mock.Anything, batchSize).Return(pendingRows(7, 0), nil)
+		db.On("Exec", ctx, MarkMessageSent, int64(7)).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		emptyOutbox(db)
+		publisher := &fakePublisher{}
+		d, _, _ := newDispatcher(NewPgxStore(db), publisher)
+
+		assert.NoError(t, d.Tick(ctx))
+		if assert.Len(t, publisher.published, 1) {
+			assert.Equal(t, 5, publisher.published[0].User.ID)
+		}
+		db.AssertExpectations(t)
+		db.AssertNotCalled(t, "Exec", ctx, MarkMessageFailed, mock.Anything, mock.Anything)
+	})
+
+	t.Run("leaves the database row for retry on failure", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Query", ctx, ClaimMessages, mock.Anything, mock.Anything, batchSize).Return(pendingRows(7, 1), nil)
+		d, now, _ := newDispatcher(NewPgxStore(db), &fakePublisher{err: errors.New("broker unavailable")})
+		// The second failed attempt waits twice the base backoff
+		db.On("Exec", ctx, MarkMessageFailed, int64(7), now.Add(2*retryBackoff)).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		emptyOutbox(db)
+
+		assert.NoError(t, d.Tick(ctx))
+		db.AssertExpectations(t)
+		db.AssertNotCalled(t, "Exec", ctx, MarkMessageSent, mock.Anything)
+	})
+
+	t.Run("reports a row that cannot be marked sent", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Query", ctx, ClaimMessages, mock.Anything, mock.Anything, batchSize).Return(pendingRows(7, 0), nil)
+		db.On("Exec", ctx, MarkMessageSent, int64(7)).Return(pgconn.CommandTag(""), assert.AnError)
+		d, _, _ := newDispatcher(NewPgxStore(db), &fakePublisher{})
+
+		// The row stays unsent, so the event is published again on a later tick
+		assert.ErrorIs(t, d.Tick(ctx), assert.AnError)
+	})
+
+	t.Run("stops when the outbox cannot be read", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Query", ctx, ClaimMessages, mock.Anything, mock.Anything, batchSize).Return(nil, assert.AnError)
+		d, _, _ := newDispatcher(NewPgxStore(db), &fakePublisher{})
+
+		assert.ErrorIs(t, d.Tick(ctx), assert.AnError)
+	})
+
+	t.Run("run stops on cancel", func(t *testing.T) {
+		store := NewMemoryStore()
+		assert.NoError(t, store.Add(ctx, events.Event{Type: events.TypeUserCreated}))
+		publisher := &fakePublisher{}
+		d, _, _ := newDispatcher(store, publisher)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.Run(runCtx)
+		}()
+		assert.Eventually(t, func() bool {
+			oldest, err := store.OldestPending(ctx)
+			return err == nil && oldest.IsZero()
+		}, time.Second, time.Millisecond)
+		cancel()
+		<-done
+		assert.Len(t, publisher.published, 1)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, 2*time.Second, backoff(2))
+	assert.Equal(t, 8*time.Second, backoff(4))
+	assert.Equal(t, maxBackoff, backoff(20))
+	assert.Equal(t, maxBackoff, backoff(1000))
+}
+
+func messageIDs(messages []Message) []int64 {
+	ids := make([]int64, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+	return ids
+}
@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"user-service/internal/database"
+	"user-service/internal/events"
+)
+
+// Statements run against the outbox table
+const (
+	InsertMessage         = "INSERT INTO outbox (event_type, payload) VALUES ($1, $2)"
+	ClaimMessages         = "WITH claimed AS (UPDATE outbox SET next_attempt_at = $2 WHERE id IN (SELECT id FROM outbox WHERE sent_at IS NULL AND next_attempt_at <= $1 ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED) RETURNING id, payload, attempts, created_at) SELECT id, payload, attempts, created_at FROM claimed ORDER BY id"
+	MarkMessageSent       = "UPDATE outbox SET sent_at = now() WHERE id = $1"
+	MarkMessageFailed     = "UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2 WHERE id = $1"
+	OldestPendingMessages = "SELECT min(created_at) FROM outbox WHERE sent_at IS NULL"
+)
+
+// pgxStore keeps messages in the Postgres outbox table
+type pgxStore struct {
+	db database.DBTX
+}
+
+// NewPgxStore creates a store backed by a database connection or transaction. Pass
+// the transaction of a mutation so its events are only queued if the mutation commits.
+func NewPgxStore(db database.DBTX) Store {
+	return &pgxStore{db: db}
+}
+
+// Add inserts the event; the database assigns its ID and makes it due immediately
+func (s *pgxStore) Add(ctx context.Context, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	_, err = s.db.Exec(ctx, InsertMessage, event.Type, payload)
+	return err
+}
+
+// Claim holds the due messages back until the lease ends in the statement that
+// reads them. Rows another dispatcher is claiming at the same time are locked, and
+// SKIP LOCKED passes over them rather than waiting to claim them a second time.
+func (s *pgxStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Message, error) {
+	rows, err := s.db.Query(ctx, ClaimMessages, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		var payload []byte
+		if err := rows.Scan(&m.ID, &payload, &m.Attempts, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &m.Event); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox message %d: %w", m.ID, err)
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// MarkSent stamps the message as sent so it is never dispatched again
+func (s *pgxStore) MarkSent(ctx context.Context, id int64) error {
+	_, err := s.db.Exec(ctx, MarkMessageSent, id)
+	return err
+}
+
+// MarkFailed counts a failed attempt and holds the message back until next
+func (s *pgxStore) MarkFailed(ctx context.Context, id int64, next time.Time) error {
+	_, err := s.db.Exec(ctx, MarkMessageFailed, id, next)
+	return err
+}
+
+// OldestPending returns when the oldest unsent message was queued
+func (s *pgxStore) OldestPending(ctx context.Context) (time.Time, error) {
+	var oldest *time.Time
+	if err := s.db.QueryRow(ctx, OldestPendingMessages).Scan(&oldest); err != nil || oldest == nil {
+		return time.Time{}, err
+	}
+	return *oldest, nil
+}
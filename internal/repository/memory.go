@@ -0,0 +1,378 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/models"
+)
+
+// SeedUsers returns the users the database migrations seed, for running without Postgres
+func SeedUsers() []models.User {
+	return []models.User{
+		{ID: 1, Name: "John Doe", Email: "john@example.com"},
+		{ID: 2, Name: "Jane Smith", Email: "jane@example.com"},
+		{ID: 3, Name: "Bob Johnson", Email: "bob@example.com"},
+		{ID: 4, Name: "Sylvester Carolan", Email: "sly@example.com"},
+	}
+}
+
+// memoryUserRepository keeps users in a map, for tests and local development
+type memoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[int]models.User
+	// subjects maps each linked identity provider subject to its user's ID
+	subjects map[string]int
+	nextID   int
+	now      func() time.Time
+}
+
+// NewInMemoryRepository creates an in-memory repository holding the seed users.
+// Seed users keep a non-zero ID; the others are numbered after the highest one.
+func NewInMemoryRepository(seed ...models.User) UserRepository {
+	r := &memoryUserRepository{
+		users:    make(map[int]models.User, len(seed)),
+		subjects: make(map[string]int),
+		nextID:   1,
+		now:      time.Now,
+	}
+
+	for _, user := range seed {
+		if user.ID >= r.nextID {
+			r.nextID = user.ID + 1
+		}
+	}
+	for _, user := range seed {
+		if user.ID == 0 {
+			user.ID = r.nextID
+			r.nextID++
+		}
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = r.now()
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = user.CreatedAt
+		}
+		if user.Role == "" {
+			user.Role = models.DefaultRole
+		}
+		if user.Status == "" {
+			user.Status = models.StatusActive
+		}
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+// GetUser retrieves a user by ID
+func (r *memoryUserRepository) GetUser(_ context.Context, id int) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// Exists reports whether a user exists
+func (r *memoryUserRepository) Exists(_ context.Context, id int) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	return ok && user.DeletedAt == nil, nil
+}
+
+// EmailExists reports whether any user has email, regardless of case
+func (r *memoryUserRepository) EmailExists(_ context.Context, email string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EmailOwner returns the lowest ID of the users with email, regardless of case
+func (r *memoryUserRepository) EmailOwner(_ context.Context, email string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	owner := 0
+	for id, user := range r.users {
+		if strings.EqualFold(user.Email, email) && (owner == 0 || id < owner) {
+			owner = id
+		}
+	}
+	if owner == 0 {
+		return 0, ErrNotFound
+	}
+	return owner, nil
+}
+
+// GetUserByEmail retrieves a user by email address
+func (r *memoryUserRepository) GetUserByEmail(_ context.Context, email string) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email && user.DeletedAt == nil {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+// GetUserBySubject retrieves the user linked to subject
+func (r *memoryUserRepository) GetUserBySubject(_ context.Context, subject string) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.subjects[subject]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// ListUsers returns the users matching filter ordered by ID
+func (r *memoryUserRepository) ListUsers(_ context.Context, filter models.UserFilter) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		if user.DeletedAt == nil && filter.Matches(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// ListAllUsers returns every user ordered by ID, including deleted ones
+func (r *memoryUserRepository) ListAllUsers(_ context.Context) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// EachUser calls fn with every user ordered by ID, stopping once ctx is done.
+// The users are copied first, so fn may use the repository.
+func (r *memoryUserRepository) EachUser(ctx context.Context, fn func(models.User) error) error {
+	users, err := r.ListUsers(ctx, models.UserFilter{})
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns the current number of users
+func (r *memoryUserRepository) Count(_ context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, user := range r.users {
+		if user.DeletedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountDeleted returns the number of deleted users
+func (r *memoryUserRepository) CountDeleted(_ context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, user := range r.users {
+		if user.DeletedAt != nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountByStatus returns the number of users with each status
+func (r *memoryUserRepository) CountByStatus(_ context.Context) (map[string]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, user := range r.users {
+		if user.DeletedAt == nil {
+			counts[user.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// Create stores a new user under the next free ID
+func (r *memoryUserRepository) Create(_ context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = r.nextID
+	user.CreatedAt = r.now()
+	user.UpdatedAt = user.CreatedAt
+	if user.Role == "" {
+		user.Role = models.DefaultRole
+	}
+	user.Status = models.StatusActive
+	r.users[user.ID] = user
+	r.nextID++
+	return nil
+}
+
+// Update replaces the name, email and profile fields of an existing user
+func (r *memoryUserRepository) Update(_ context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.users[user.ID]; !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if r.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	// Like the database, updates change the name, email and profile fields only
+	user.Role = r.users[user.ID].Role
+	user.Status = r.users[user.ID].Status
+	user.CreatedAt = r.users[user.ID].CreatedAt
+	user.UpdatedAt = r.now()
+	r.users[user.ID] = user
+	return nil
+}
+
+// Patch changes the fields patch sets on an existing user
+func (r *memoryUserRepository) Patch(_ context.Context, id int, patch models.UserPatch) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if patch.Email != nil && r.emailTaken(*patch.Email, id) {
+		return ErrDuplicateEmail
+	}
+
+	user = patch.Apply(user)
+	user.UpdatedAt = r.now()
+	r.users[id] = user
+	return nil
+}
+
+// Delete marks a user as deleted
+func (r *memoryUserRepository) Delete(_ context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrNotFound
+	}
+	deletedAt := r.now()
+	user.DeletedAt = &deletedAt
+	user.UpdatedAt = deletedAt
+	r.users[id] = user
+	return nil
+}
+
+// Restore clears the deletion mark of a deleted user
+func (r *memoryUserRepository) Restore(_ context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt == nil {
+		return ErrNotFound
+	}
+	user.DeletedAt = nil
+	user.UpdatedAt = r.now()
+	r.users[id] = user
+	return nil
+}
+
+// SetStatus changes the status of a user
+func (r *memoryUserRepository) SetStatus(_ context.Context, id int, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if user.Status == status {
+		return nil
+	}
+	user.Status = status
+	user.UpdatedAt = r.now()
+	r.users[id] = user
+	return nil
+}
+
+// LinkSubject links a user to subject, unlinking the subject it had
+func (r *memoryUserRepository) LinkSubject(_ context.Context, id int, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if linked, ok := r.subjects[subject]; ok && linked != id {
+		return ErrSubjectTaken
+	}
+	for s, linked := range r.subjects {
+		if linked == id {
+			delete(r.subjects, s)
+		}
+	}
+	r.subjects[subject] = id
+	return nil
+}
+
+// emailTaken reports whether a user other than exceptID already has email.
+// The caller must hold the lock.
+func (r *memoryUserRepository) emailTaken(email string, exceptID int) bool {
+	for id, user := range r.users {
+		if id != exceptID && user.Email == email {
+			return true
+		}
+	}
+	return false
+}
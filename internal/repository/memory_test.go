@@ -0,0 +1,92 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/repository/repositorytest"
+)
+
+func TestInMemoryRepository(t *testing.T) {
+	repositorytest.TestUserRepository(t, func(t *testing.T) repository.UserRepository {
+		return repository.NewInMemoryRepository()
+	})
+}
+
+func TestInMemoryRepositorySeed(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewInMemoryRepository(
+		models.User{ID: 5, Name: "John Doe", Email: "john@example.com"},
+		models.User{Name: "Jane Smith", Email: "jane@example.com"},
+	)
+
+	john, err := repo.GetUser(ctx, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "John Doe", john.Name)
+	assert.False(t, john.UpdatedAt.IsZero())
+
+	// Users without an ID are numbered after the highest seeded one
+	jane, err := repo.GetUserByEmail(ctx, "jane@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, jane.ID)
+
+	assert.NoError(t, repo.Create(ctx, models.User{Name: "Bob Johnson", Email: "bob@example.com"}))
+	bob, err := repo.GetUserByEmail(ctx, "bob@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, bob.ID)
+
+	t.Run("default seed", func(t *testing.T) {
+		repo := repository.NewInMemoryRepository(repository.SeedUsers()...)
+
+		count, err := repo.Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, count)
+	})
+}
+
+func TestInMemoryRepositoryConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewInMemoryRepository(repository.SeedUsers()...)
+
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			email := fmt.Sprintf("user%d@example.com", n)
+			assert.NoError(t, repo.Create(ctx, models.User{Name: "User", Email: email}))
+
+			user, err := repo.GetUserByEmail(ctx, email)
+			assert.NoError(t, err)
+			assert.NoError(t, repo.Update(ctx, models.User{ID: user.ID, Name: "Updated", Email: email}))
+
+			_, err = repo.ListUsers(ctx, models.UserFilter{})
+			assert.NoError(t, err)
+			_, err = repo.GetUser(ctx, 1)
+			assert.NoError(t, err)
+
+			if n%2 == 0 {
+				assert.NoError(t, repo.Delete(ctx, user.ID))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count, err := repo.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4+workers/2, count)
+
+	users, err := repo.ListUsers(ctx, models.UserFilter{})
+	assert.NoError(t, err)
+	ids := make(map[int]bool)
+	for _, user := range users {
+		assert.False(t, ids[user.ID], "duplicate id %d", user.ID)
+		ids[user.ID] = true
+	}
+}
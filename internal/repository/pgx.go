@@ -0,0 +1,315 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"user-service/internal/database"
+	"user-service/internal/database/queries"
+	"user-service/internal/models"
+)
+
+// preparer is implemented by connections that support named prepared statements, such as *pgx.Conn
+type preparer interface {
+	Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error)
+}
+
+// pgxUserRepository stores users in a Postgres users table
+type pgxUserRepository struct {
+	db      database.DBTX
+	queries *queries.Queries
+
+	// getUserSQL is the prepared statement name for GetUser once prepared, or the raw query otherwise
+	prepareOnce sync.Once
+	getUserSQL  string
+}
+
+// NewPgxUserRepository creates a repository storing users in table, backed by a
+// database connection or transaction. The table must be a validated identifier,
+// as DB_USERS_TABLE is.
+func NewPgxUserRepository(db database.DBTX, table string) UserRepository {
+	q := queries.New(table)
+	return &pgxUserRepository{db: db, queries: q, getUserSQL: q.GetUserByID}
+}
+
+// GetUser retrieves a user by ID. It is the hottest query, so it runs as an
+// explicitly prepared statement when the connection supports it.
+func (r *pgxUserRepository) GetUser(ctx context.Context, id int) (models.User, error) {
+	r.prepareOnce.Do(func() {
+		p, ok := r.db.(preparer)
+		if !ok {
+			return
+		}
+		if _, err := p.Prepare(ctx, r.queries.GetUserByIDStatement, r.queries.GetUserByID); err != nil {
+			slog.Warn("Failed to prepare statement, using unprepared query", "statement", r.queries.GetUserByIDStatement, "error", err)
+			return
+		}
+		r.getUserSQL = r.queries.GetUserByIDStatement
+	})
+	return r.getUser(ctx, r.getUserSQL, id)
+}
+
+// GetUserByEmail retrieves a user by email address
+func (r *pgxUserRepository) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return r.getUser(ctx, r.queries.GetUserByEmail, email)
+}
+
+// GetUserBySubject retrieves the user linked to subject
+func (r *pgxUserRepository) GetUserBySubject(ctx context.Context, subject string) (models.User, error) {
+	return r.getUser(ctx, r.queries.GetUserBySubject, subject)
+}
+
+// Exists reports whether a user exists, selecting none of its columns
+func (r *pgxUserRepository) Exists(ctx context.Context, id int) (bool, error) {
+	var one int
+	err := r.db.QueryRow(ctx, r.queries.UserExists, id).Scan(&one)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// EmailExists reports whether any user has email, regardless of case
+func (r *pgxUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	if err := r.db.QueryRow(ctx, r.queries.EmailExists, email).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// EmailOwner returns the lowest ID of the users with email, regardless of case
+func (r *pgxUserRepository) EmailOwner(ctx context.Context, email string) (int, error) {
+	var id int
+	if err := r.db.QueryRow(ctx, r.queries.EmailOwner, email).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *pgxUserRepository) getUser(ctx context.Context, sql string, arg interface{}) (models.User, error) {
+	var user models.User
+	err := r.db.QueryRow(ctx, sql, arg).Scan(queries.UserDest(&user)...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, ErrNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// ListUsers returns the users matching filter ordered by ID
+func (r *pgxUserRepository) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	sql, args := r.queries.ListUsersQuery(filter)
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(queries.UserDest(&user)...); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// ListAllUsers returns every user, including deleted ones
+func (r *pgxUserRepository) ListAllUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := r.db.Query(ctx, r.queries.ListAllUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(queries.DeletedUserDest(&user)...); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// EachUser calls fn with every user ordered by ID as the rows arrive, so the
+// result set is never held in memory. It stops as soon as ctx is done, closing
+// the rows to free the connection for other queries.
+func (r *pgxUserRepository) EachUser(ctx context.Context, fn func(models.User) error) error {
+	rows, err := r.db.Query(ctx, r.queries.ExportUsers)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		// Rows already received are not read once the caller has gone away
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var user models.User
+		if err := rows.Scan(queries.UserDest(&user)...); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Count returns the current number of users
+func (r *pgxUserRepository) Count(ctx context.Context) (int, error) {
+	return r.count(ctx, r.queries.CountUsers)
+}
+
+// CountDeleted returns the number of deleted users
+func (r *pgxUserRepository) CountDeleted(ctx context.Context) (int, error) {
+	return r.count(ctx, r.queries.CountDeletedUsers)
+}
+
+// CountByStatus returns the number of users with each status
+func (r *pgxUserRepository) CountByStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := r.db.Query(ctx, r.queries.CountUsersByStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+func (r *pgxUserRepository) count(ctx context.Context, sql string) (int, error) {
+	var count int
+	if err := r.db.QueryRow(ctx, sql).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// uniqueViolation is the Postgres error code for a unique constraint violation
+const uniqueViolation = "23505"
+
+// mapWriteError translates constraint violations into repository errors
+func mapWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+// Create inserts a new user; the database assigns its ID
+func (r *pgxUserRepository) Create(ctx context.Context, user models.User) error {
+	if user.Role == "" {
+		user.Role = models.DefaultRole
+	}
+	_, err := r.db.Exec(ctx, r.queries.InsertUser, queries.InsertUserArgs(user)...)
+	return mapWriteError(err)
+}
+
+// Update replaces the name, email and profile fields of an existing user
+func (r *pgxUserRepository) Update(ctx context.Context, user models.User) error {
+	tag, err := r.db.Exec(ctx, r.queries.UpdateUser, queries.UpdateUserArgs(user)...)
+	if err != nil {
+		return mapWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Patch changes the fields patch sets on an existing user
+func (r *pgxUserRepository) Patch(ctx context.Context, id int, patch models.UserPatch) error {
+	sql, args := r.queries.PatchUserQuery(id, patch)
+	tag, err := r.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return mapWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete marks a user as deleted
+func (r *pgxUserRepository) Delete(ctx context.Context, id int) error {
+	return r.execByID(ctx, r.queries.DeleteUser, id)
+}
+
+// Restore clears the deletion mark of a deleted user
+func (r *pgxUserRepository) Restore(ctx context.Context, id int) error {
+	return r.execByID(ctx, r.queries.RestoreUser, id)
+}
+
+// LinkSubject links a user to subject
+func (r *pgxUserRepository) LinkSubject(ctx context.Context, id int, subject string) error {
+	tag, err := r.db.Exec(ctx, r.queries.LinkUserSubject, subject, id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return ErrSubjectTaken
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetStatus changes the status of a user
+func (r *pgxUserRepository) SetStatus(ctx context.Context, id int, status string) error {
+	tag, err := r.db.Exec(ctx, r.queries.SetUserStatus, status, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// execByID runs a single-user write, returning ErrNotFound when no row matched
+func (r *pgxUserRepository) execByID(ctx context.Context, sql string, id int) error {
+	tag, err := r.db.Exec(ctx, sql, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+)
+
+// preparingDB is a mock connection that also supports prepared statements
+type preparingDB struct {
+	*mocks.MockDBTX
+}
+
+func (db preparingDB) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	ret := db.Called(ctx, name, sql)
+	return &pgconn.StatementDescription{Name: name, SQL: sql}, ret.Error(0)
+}
+
+// preparingConn is a preparingDB that can stand behind a ReconnectingConn
+type preparingConn struct {
+	preparingDB
+}
+
+func (preparingConn) Begin(ctx context.Context) (pgx.Tx, error) { return &mocks.MockTx{}, nil }
+func (preparingConn) Ping(ctx context.Context) error            { return nil }
+func (preparingConn) Close(ctx context.Context) error           { return nil }
+func (preparingConn) IsClosed() bool                            { return false }
+
+func userRow(user models.User) *mocks.MockRow {
+	row := &mocks.MockRow{}
+	row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = user.ID
+		*arg[1].(*string) = user.Name
+		*arg[2].(*string) = user.Email
+	})
+	return row
+}
+
+func TestPgxUserRepositoryPreparedGetUser(t *testing.T) {
+	ctx := context.Background()
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+
+	t.Run("prepares once and queries by statement name", func(t *testing.T) {
+		db := preparingDB{&mocks.MockDBTX{}}
+		db.On("Prepare", ctx, queries.Default.GetUserByIDStatement, queries.Default.GetUserByID).Return(nil).Once()
+		db.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		repo := NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+		for i := 0; i < 3; i++ {
+			user, err := repo.GetUser(ctx, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, john, user)
+		}
+		db.AssertExpectations(t)
+		db.AssertNumberOfCalls(t, "Prepare", 1)
+	})
+
+	t.Run("prepares through a reconnecting connection", func(t *testing.T) {
+		conn := preparingConn{preparingDB{&mocks.MockDBTX{}}}
+		conn.On("Prepare", ctx, queries.Default.GetUserByIDStatement, queries.Default.GetUserByID).Return(nil).Once()
+		conn.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		reg := prometheus.NewRegistry()
+		db, err := database.NewReconnectingConn(func() (database.Conn, error) { return conn, nil }, time.Millisecond, metrics.New(reg, reg))
+		require.NoError(t, err)
+		repo := NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+		user, err := repo.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+		conn.AssertExpectations(t)
+	})
+
+	t.Run("falls back to the raw query when prepare fails", func(t *testing.T) {
+		db := preparingDB{&mocks.MockDBTX{}}
+		db.On("Prepare", ctx, queries.Default.GetUserByIDStatement, queries.Default.GetUserByID).Return(assert.AnError).Once()
+		db.On("QueryRow", ctx, queries.Default.GetUserByID, 1).Return(userRow(john))
+		repo := NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+		for i := 0; i < 2; i++ {
+			user, err := repo.GetUser(ctx, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, john, user)
+		}
+		db.AssertExpectations(t)
+		db.AssertNumberOfCalls(t, "Prepare", 1)
+	})
+
+	t.Run("connections without prepare use the raw query", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("QueryRow", ctx, queries.Default.GetUserByID, 1).Return(userRow(john))
+		repo := NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+		_, err := repo.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		db.AssertExpectations(t)
+	})
+}
+
+func TestPgxUserRepositoryDuplicateEmail(t *testing.T) {
+	ctx := context.Background()
+	db := &mocks.MockDBTX{}
+	db.On("Exec", ctx, queries.Default.InsertUser, "John Doe", "john@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag{}, &pgconn.PgError{Code: "23505"})
+	db.On("Exec", ctx, queries.Default.UpdateUser, "John Doe", "john@example.com", "", "", 2).Return(pgconn.CommandTag{}, &pgconn.PgError{Code: "23505"})
+	repo := NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+	assert.ErrorIs(t, repo.Create(ctx, models.User{Name: "John Doe", Email: "john@example.com"}), ErrDuplicateEmail)
+	assert.ErrorIs(t, repo.Update(ctx, models.User{ID: 2, Name: "John Doe", Email: "john@example.com"}), ErrDuplicateEmail)
+}
+
+func TestPgxUserRepositoryTable(t *testing.T) {
+	ctx := context.Background()
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+	tenant := queries.New("tenant_a.users")
+	db := &mocks.MockDBTX{}
+	db.On("QueryRow", ctx, "SELECT id, name, email, updated_at, role, created_at, status, avatar_url, display_name FROM tenant_a.users WHERE id = $1 AND deleted_at IS NULL", 1).Return(userRow(john))
+	db.On("Exec", ctx, tenant.DeleteUser, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+	repo := NewPgxUserRepository(db, "tenant_a.users")
+
+	user, err := repo.GetUser(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, john, user)
+	assert.NoError(t, repo.Delete(ctx, 1))
+	db.AssertExpectations(t)
+}
+
+func TestPgxUserRepositoryEachUserStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The scan never runs out of rows, as for a table far larger than the client reads
+	scanned := 0
+	rows := &mocks.MockRows{}
+	rows.On("Next").Return(true)
+	rows.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		scanned++
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = scanned
+	})
+	rows.On("Close").Return()
+	db := &mocks.MockDBTX{}
+	db.On("Query", ctx, queries.Default.ExportUsers).Return(rows, nil)
+	repo := NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+	var seen []int
+	err := repo.EachUser(ctx, func(user models.User) error {
+		seen = append(seen, user.ID)
+		if len(seen) == 3 {
+			// The client disconnects
+			cancel()
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []int{1, 2, 3}, seen)
+	rows.AssertNumberOfCalls(t, "Scan", 3)
+	rows.AssertCalled(t, "Close")
+	db.AssertExpectations(t)
+}
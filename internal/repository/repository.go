@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"user-service/internal/models"
+)
+
+// ErrNotFound is returned when no user matches the lookup or write
+var ErrNotFound = errors.New("user not found")
+
+// ErrDuplicateEmail is returned when a create or update would reuse another user's email
+var ErrDuplicateEmail = errors.New("email already exists")
+
+// ErrSubjectTaken is returned when linking a subject already linked to another user
+var ErrSubjectTaken = errors.New("subject already linked to another user")
+
+// UserRepository stores users. Implementations must be safe for concurrent use.
+//
+// Deleting a user only marks it deleted. Deleted users are invisible to every method
+// except ListAllUsers, CountDeleted, Restore, EmailExists and EmailOwner.
+type UserRepository interface {
+	GetUser(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	// GetUserBySubject retrieves the user linked to an identity provider subject
+	GetUserBySubject(ctx context.Context, subject string) (models.User, error)
+	// Exists reports whether user id exists, without reading it
+	Exists(ctx context.Context, id int) (bool, error)
+	// EmailExists reports whether any user, deleted ones included, has email in any case
+	EmailExists(ctx context.Context, email string) (bool, error)
+	// EmailOwner returns the ID of the user, deleted ones included, with email in any
+	// case, or ErrNotFound. When several match, it returns the lowest ID.
+	EmailOwner(ctx context.Context, email string) (int, error)
+	// ListUsers returns the users matching filter ordered by ID
+	ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error)
+	// ListAllUsers returns every user ordered by ID, including deleted ones
+	ListAllUsers(ctx context.Context) ([]models.User, error)
+	// EachUser calls fn with every user ordered by ID, one at a time, so callers can
+	// walk the table without holding it in memory. It stops at the first error fn
+	// returns and returns it, or with ctx's error once ctx is done.
+	EachUser(ctx context.Context, fn func(models.User) error) error
+	Count(ctx context.Context) (int, error)
+	// CountDeleted returns the number of deleted users
+	CountDeleted(ctx context.Context) (int, error)
+	// CountByStatus returns the number of users with each status. Statuses
+	// no user has are missing from the map.
+	CountByStatus(ctx context.Context) (map[string]int, error)
+	Create(ctx context.Context, user models.User) error
+	Update(ctx context.Context, user models.User) error
+	// Patch changes the fields patch sets on user id, leaving the others alone
+	Patch(ctx context.Context, id int, patch models.UserPatch) error
+	Delete(ctx context.Context, id int) error
+	// Restore undeletes a user. It returns ErrNotFound unless the user exists and is deleted.
+	Restore(ctx context.Context, id int) error
+	// SetStatus changes the status of a user. Setting the status it already has succeeds
+	// without modifying the user.
+	SetStatus(ctx context.Context, id int, status string) error
+	// LinkSubject links user id to an identity provider subject, replacing the one
+	// it had. A subject links to one user at most.
+	LinkSubject(ctx context.Context, id int, subject string) error
+}
@@ -0,0 +1,456 @@
+// Package repositorytest holds the behaviour every repository.UserRepository must share,
+// so each implementation can be checked against the same suite.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+)
+
+// TestUserRepository runs the repository contract. newRepo must return an empty repository
+// that is isolated from the ones returned to other subtests.
+func TestUserRepository(t *testing.T, newRepo func(t *testing.T) repository.UserRepository) {
+	ctx := context.Background()
+
+	// create stores a user and returns it as read back, with its assigned ID
+	create := func(t *testing.T, repo repository.UserRepository, name, email string) models.User {
+		t.Helper()
+		if !assert.NoError(t, repo.Create(ctx, models.User{Name: name, Email: email})) {
+			t.FailNow()
+		}
+		user, err := repo.GetUserByEmail(ctx, email)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return user
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		repo := newRepo(t)
+
+		users, err := repo.ListUsers(ctx, models.UserFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		count, err := repo.Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("create and get", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		assert.NotZero(t, john.ID)
+		assert.Equal(t, "John Doe", john.Name)
+		assert.False(t, john.CreatedAt.IsZero())
+		assert.False(t, john.UpdatedAt.IsZero())
+
+		user, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, john.ID, user.ID)
+		assert.Equal(t, john.Email, user.Email)
+		assert.True(t, john.UpdatedAt.Equal(user.UpdatedAt))
+	})
+
+	t.Run("create assigns distinct ids", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+		assert.NotEqual(t, john.ID, jane.ID)
+	})
+
+	t.Run("create duplicate email", func(t *testing.T) {
+		repo := newRepo(t)
+		create(t, repo, "John Doe", "john@example.com")
+
+		assert.ErrorIs(t, repo.Create(ctx, models.User{Name: "Other John", Email: "john@example.com"}), repository.ErrDuplicateEmail)
+	})
+
+	t.Run("get missing user", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.GetUser(ctx, 999)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = repo.GetUserByEmail(ctx, "nobody@example.com")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("list and count", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+
+		users, err := repo.ListUsers(ctx, models.UserFilter{})
+		assert.NoError(t, err)
+		var ids []int
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		assert.ElementsMatch(t, []int{john.ID, jane.ID}, ids)
+
+		count, err := repo.Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("role", func(t *testing.T) {
+		repo := newRepo(t)
+		if !assert.NoError(t, repo.Create(ctx, models.User{Name: "Ada Admin", Email: "ada@example.com", Role: models.RoleAdmin})) {
+			t.FailNow()
+		}
+		john := create(t, repo, "John Doe", "john@example.com")
+		assert.Equal(t, models.DefaultRole, john.Role)
+
+		admin, err := repo.GetUserByEmail(ctx, "ada@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleAdmin, admin.Role)
+
+		admins, err := repo.ListUsers(ctx, models.UserFilter{Role: models.RoleAdmin})
+		assert.NoError(t, err)
+		if assert.Len(t, admins, 1) {
+			assert.Equal(t, admin.ID, admins[0].ID)
+		}
+
+		guests, err := repo.ListUsers(ctx, models.UserFilter{Role: models.RoleGuest})
+		assert.NoError(t, err)
+		assert.Empty(t, guests)
+
+		// Updates leave the role unchanged
+		assert.NoError(t, repo.Update(ctx, models.User{ID: admin.ID, Name: "Ada Renamed", Email: "ada@example.com"}))
+		admin, err = repo.GetUser(ctx, admin.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RoleAdmin, admin.Role)
+	})
+
+	t.Run("created range", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		create(t, repo, "Jane Smith", "jane@example.com")
+
+		// Users created in one transaction can share a timestamp, so bounds stay well clear of it
+		users, err := repo.ListUsers(ctx, models.UserFilter{CreatedAfter: john.CreatedAt.Add(-time.Hour), CreatedBefore: john.CreatedAt.Add(time.Hour)})
+		assert.NoError(t, err)
+		assert.Len(t, users, 2)
+
+		users, err = repo.ListUsers(ctx, models.UserFilter{CreatedAfter: john.CreatedAt.Add(time.Hour)})
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		users, err = repo.ListUsers(ctx, models.UserFilter{CreatedBefore: john.CreatedAt.Add(-time.Hour)})
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		users, err = repo.ListUsers(ctx, models.UserFilter{Role: models.RoleAdmin, CreatedAfter: john.CreatedAt.Add(-time.Hour)})
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+
+		assert.NoError(t, repo.Update(ctx, models.User{ID: john.ID, Name: "John Updated", Email: "john.updated@example.com"}))
+
+		user, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "John Updated", user.Name)
+		assert.Equal(t, "john.updated@example.com", user.Email)
+		assert.False(t, user.UpdatedAt.Before(john.UpdatedAt))
+		assert.True(t, john.CreatedAt.Equal(user.CreatedAt))
+
+		_, err = repo.GetUserByEmail(ctx, "john@example.com")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("profile fields", func(t *testing.T) {
+		repo := newRepo(t)
+		assert.NoError(t, repo.Create(ctx, models.User{Name: "John Doe", Email: "john@example.com", AvatarURL: "https://example.com/john.png", DisplayName: "Johnny"}))
+		john, err := repo.GetUserByEmail(ctx, "john@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/john.png", john.AvatarURL)
+		assert.Equal(t, "Johnny", john.DisplayName)
+
+		displayName := "John"
+		assert.NoError(t, repo.Patch(ctx, john.ID, models.UserPatch{DisplayName: &displayName}))
+		user, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/john.png", user.AvatarURL)
+		assert.Equal(t, "John", user.DisplayName)
+
+		// Updates replace them, clearing the ones left out
+		assert.NoError(t, repo.Update(ctx, models.User{ID: john.ID, Name: "John Doe", Email: "john@example.com"}))
+		user, err = repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, user.AvatarURL)
+		assert.Empty(t, user.DisplayName)
+	})
+
+	t.Run("update missing user", func(t *testing.T) {
+		repo := newRepo(t)
+
+		err := repo.Update(ctx, models.User{ID: 999, Name: "Nobody", Email: "nobody@example.com"})
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("update to duplicate email", func(t *testing.T) {
+		repo := newRepo(t)
+		create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+
+		assert.ErrorIs(t, repo.Update(ctx, models.User{ID: jane.ID, Name: "Jane Smith", Email: "john@example.com"}), repository.ErrDuplicateEmail)
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+
+		exists, err := repo.Exists(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = repo.Exists(ctx, 999)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+		exists, err = repo.Exists(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("email exists", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+
+		exists, err := repo.EmailExists(ctx, "John@Example.COM")
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = repo.EmailExists(ctx, "jane@example.com")
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		// A deleted user keeps the email
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+		exists, err = repo.EmailExists(ctx, "john@example.com")
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("email owner", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+
+		owner, err := repo.EmailOwner(ctx, "John@Example.COM")
+		assert.NoError(t, err)
+		assert.Equal(t, john.ID, owner)
+
+		_, err = repo.EmailOwner(ctx, "jane@example.com")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		// A deleted user keeps the email
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+		owner, err = repo.EmailOwner(ctx, "JOHN@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, john.ID, owner)
+	})
+
+	t.Run("subject", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+
+		_, err := repo.GetUserBySubject(ctx, "auth0|john")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		assert.NoError(t, repo.LinkSubject(ctx, john.ID, "auth0|john"))
+		got, err := repo.GetUserBySubject(ctx, "auth0|john")
+		assert.NoError(t, err)
+		assert.Equal(t, john.ID, got.ID)
+
+		// A subject links to one user
+		assert.ErrorIs(t, repo.LinkSubject(ctx, jane.ID, "auth0|john"), repository.ErrSubjectTaken)
+		assert.ErrorIs(t, repo.LinkSubject(ctx, 999, "auth0|nobody"), repository.ErrNotFound)
+
+		// Relinking replaces the user's subject
+		assert.NoError(t, repo.LinkSubject(ctx, john.ID, "auth0|john2"))
+		_, err = repo.GetUserBySubject(ctx, "auth0|john")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		// A deleted user is not found by its subject
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+		_, err = repo.GetUserBySubject(ctx, "auth0|john2")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		create(t, repo, "Jane Smith", "jane@example.com")
+
+		email := "john.updated@example.com"
+		assert.NoError(t, repo.Patch(ctx, john.ID, models.UserPatch{Email: &email}))
+		user, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "John Doe", user.Name)
+		assert.Equal(t, email, user.Email)
+		assert.Equal(t, john.Role, user.Role)
+		assert.False(t, user.UpdatedAt.Before(john.UpdatedAt))
+
+		name := "John Updated"
+		assert.NoError(t, repo.Patch(ctx, john.ID, models.UserPatch{Name: &name}))
+		user, err = repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, name, user.Name)
+		assert.Equal(t, email, user.Email)
+
+		taken := "jane@example.com"
+		assert.ErrorIs(t, repo.Patch(ctx, john.ID, models.UserPatch{Email: &taken}), repository.ErrDuplicateEmail)
+		assert.ErrorIs(t, repo.Patch(ctx, 999, models.UserPatch{Name: &name}), repository.ErrNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+
+		_, err := repo.GetUser(ctx, john.ID)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		assert.ErrorIs(t, repo.Delete(ctx, john.ID), repository.ErrNotFound)
+	})
+
+	t.Run("delete keeps the user for admins", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+
+		_, err := repo.GetUserByEmail(ctx, "john@example.com")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		users, err := repo.ListUsers(ctx, models.UserFilter{})
+		assert.NoError(t, err)
+		if assert.Len(t, users, 1) {
+			assert.Equal(t, jane.ID, users[0].ID)
+		}
+		assert.ErrorIs(t, repo.Update(ctx, models.User{ID: john.ID, Name: "John Updated", Email: "john@example.com"}), repository.ErrNotFound)
+
+		count, err := repo.Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		deleted, err := repo.CountDeleted(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		all, err := repo.ListAllUsers(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, all, 2) {
+			assert.Equal(t, john.ID, all[0].ID)
+			assert.NotNil(t, all[0].DeletedAt)
+			assert.Nil(t, all[1].DeletedAt)
+		}
+
+		// A deleted user's email stays reserved so it can be restored
+		assert.ErrorIs(t, repo.Create(ctx, models.User{Name: "Other John", Email: "john@example.com"}), repository.ErrDuplicateEmail)
+	})
+
+	t.Run("each user", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+		deleted := create(t, repo, "Deleted User", "deleted@example.com")
+		assert.NoError(t, repo.Delete(ctx, deleted.ID))
+
+		var ids []int
+		assert.NoError(t, repo.EachUser(ctx, func(user models.User) error {
+			ids = append(ids, user.ID)
+			return nil
+		}))
+		assert.Equal(t, []int{john.ID, jane.ID}, ids)
+
+		// The first error fn returns ends the walk
+		stop := errors.New("stop")
+		calls := 0
+		assert.ErrorIs(t, repo.EachUser(ctx, func(models.User) error {
+			calls++
+			return stop
+		}), stop)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+
+		assert.ErrorIs(t, repo.Restore(ctx, john.ID), repository.ErrNotFound)
+		assert.ErrorIs(t, repo.Restore(ctx, 999), repository.ErrNotFound)
+
+		assert.NoError(t, repo.Delete(ctx, john.ID))
+		assert.NoError(t, repo.Restore(ctx, john.ID))
+
+		user, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, user.DeletedAt)
+		deleted, err := repo.CountDeleted(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		repo := newRepo(t)
+		john := create(t, repo, "John Doe", "john@example.com")
+		jane := create(t, repo, "Jane Smith", "jane@example.com")
+		assert.Equal(t, models.StatusActive, john.Status)
+
+		assert.NoError(t, repo.SetStatus(ctx, john.ID, models.StatusDisabled))
+		disabled, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.StatusDisabled, disabled.Status)
+
+		// Disabling twice succeeds and leaves the user untouched
+		assert.NoError(t, repo.SetStatus(ctx, john.ID, models.StatusDisabled))
+		again, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.True(t, disabled.UpdatedAt.Equal(again.UpdatedAt))
+
+		users, err := repo.ListUsers(ctx, models.UserFilter{Status: models.StatusDisabled})
+		assert.NoError(t, err)
+		if assert.Len(t, users, 1) {
+			assert.Equal(t, john.ID, users[0].ID)
+		}
+		users, err = repo.ListUsers(ctx, models.UserFilter{Status: models.StatusActive})
+		assert.NoError(t, err)
+		if assert.Len(t, users, 1) {
+			assert.Equal(t, jane.ID, users[0].ID)
+		}
+
+		counts, err := repo.CountByStatus(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{models.StatusActive: 1, models.StatusDisabled: 1}, counts)
+
+		// Updates leave the status unchanged
+		assert.NoError(t, repo.Update(ctx, models.User{ID: john.ID, Name: "John Updated", Email: "john@example.com"}))
+		updated, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.StatusDisabled, updated.Status)
+
+		assert.NoError(t, repo.SetStatus(ctx, john.ID, models.StatusActive))
+		enabled, err := repo.GetUser(ctx, john.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.StatusActive, enabled.Status)
+
+		assert.ErrorIs(t, repo.SetStatus(ctx, 999, models.StatusDisabled), repository.ErrNotFound)
+		assert.NoError(t, repo.Delete(ctx, jane.ID))
+		assert.ErrorIs(t, repo.SetStatus(ctx, jane.ID, models.StatusDisabled), repository.ErrNotFound)
+
+		counts, err = repo.CountByStatus(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{models.StatusActive: 1}, counts)
+	})
+}
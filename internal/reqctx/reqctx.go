@@ -0,0 +1,59 @@
+// Package reqctx holds what a request's context carries: its ID and its caller.
+// It depends on nothing else in the service, so any layer can read them without
+// importing the middleware that sets them.
+package reqctx
+
+import (
+	"context"
+	"slices"
+)
+
+type contextKey string
+
+const (
+	requestIDKey contextKey = "requestID"
+	callerKey    contextKey = "caller"
+)
+
+// Roles of authenticated callers
+const (
+	// AdminRole is held by callers presenting the admin token
+	AdminRole = "admin"
+	// UserRole is held by callers presenting one of the user tokens
+	UserRole = "user"
+)
+
+// Caller is who made a request, as established by authentication
+type Caller struct {
+	// Subject identifies the caller and is recorded as the actor in the audit log
+	Subject string
+	Roles   []string
+}
+
+// HasRole reports whether the caller holds role
+func (c Caller) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request it belongs to
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, or "" outside of one
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// WithCaller returns a copy of ctx carrying caller
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey, caller)
+}
+
+// CallerFromContext returns the caller of an authenticated request, and false for
+// anonymous ones
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerKey).(Caller)
+	return caller, ok
+}
@@ -0,0 +1,40 @@
+package reqctx
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	if got := RequestIDFromContext(context.Background()); got != "" {
+		t.Errorf("Expected no request ID outside a request, got %q", got)
+	}
+	if got := RequestIDFromContext(WithRequestID(context.Background(), "req-1")); got != "req-1" {
+		t.Errorf("Expected request ID req-1, got %q", got)
+	}
+}
+
+func TestCaller(t *testing.T) {
+	caller, ok := CallerFromContext(context.Background())
+	if ok || !reflect.DeepEqual(caller, Caller{}) {
+		t.Errorf("Expected no caller for an anonymous request, got %+v, %v", caller, ok)
+	}
+
+	admin := Caller{Subject: "alice", Roles: []string{AdminRole}}
+	caller, ok = CallerFromContext(WithCaller(context.Background(), admin))
+	if !ok || !reflect.DeepEqual(caller, admin) {
+		t.Errorf("Expected caller %+v, got %+v, %v", admin, caller, ok)
+	}
+	if !caller.HasRole(AdminRole) || caller.HasRole("user") {
+		t.Errorf("Expected %+v to hold only the admin role", caller)
+	}
+}
+
+func TestKeysDoNotCollide(t *testing.T) {
+	// A plain string key with the same name must not be read as the request ID
+	ctx := context.WithValue(context.Background(), "requestID", "spoofed")
+	if got := RequestIDFromContext(ctx); got != "" {
+		t.Errorf("Expected a string key to be ignored, got %q", got)
+	}
+}
@@ -0,0 +1,167 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+// PatternKey is the context key holding the matched route pattern
+const PatternKey contextKey = "routePattern"
+
+// UnmatchedPattern is reported for requests that do not match any registered route
+const UnmatchedPattern = "unmatched"
+
+// Router dispatches requests to registered routes through a single middleware chain.
+// Every request, including ones for unknown paths, passes through the middleware.
+type Router struct {
+	mux              *http.ServeMux
+	handler          http.Handler
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+}
+
+// New creates an empty router, answering unmatched requests as http.ServeMux does
+func New() *Router {
+	rt := &Router{mux: http.NewServeMux()}
+	rt.handler = http.HandlerFunc(rt.dispatch)
+	return rt
+}
+
+// NotFound sets the handler answering requests for paths no route matches
+func (rt *Router) NotFound(handler http.Handler) {
+	rt.notFound = handler
+}
+
+// MethodNotAllowed sets the handler answering requests for a path whose routes
+// take other methods. The Allow header lists those methods by the time it runs.
+func (rt *Router) MethodNotAllowed(handler http.Handler) {
+	rt.methodNotAllowed = handler
+}
+
+// Handle registers a handler for the given pattern
+func (rt *Router) Handle(pattern string, handler http.Handler) {
+	rt.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a handler function for the given pattern
+func (rt *Router) HandleFunc(pattern string, handler http.HandlerFunc) {
+	rt.mux.HandleFunc(pattern, handler)
+}
+
+// Use wraps the router in the given middleware. The first middleware is the outermost.
+func (rt *Router) Use(middleware ...func(http.Handler) http.Handler) {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		rt.handler = middleware[i](rt.handler)
+	}
+}
+
+// Group returns a group of routes whose paths start with prefix, such as "/admin",
+// or that share middleware when prefix is ""
+func (rt *Router) Group(prefix string) *Group {
+	return &Group{router: rt, prefix: prefix}
+}
+
+// Group registers routes under a path prefix, each wrapped in the group's middleware.
+// Group middleware runs inside the router's, which see the full pattern.
+type Group struct {
+	router     *Router
+	prefix     string
+	middleware []func(http.Handler) http.Handler
+}
+
+// Use adds middleware to the routes registered on g from then on, the first
+// outermost, and returns g
+func (g *Group) Use(middleware ...func(http.Handler) http.Handler) *Group {
+	g.middleware = append(g.middleware, middleware...)
+	return g
+}
+
+// Group returns a group nested in g, under g's prefix and inside g's middleware
+func (g *Group) Group(prefix string) *Group {
+	return &Group{
+		router:     g.router,
+		prefix:     g.prefix + prefix,
+		middleware: append([]func(http.Handler) http.Handler(nil), g.middleware...),
+	}
+}
+
+// Pattern returns the pattern pattern is registered under in g, such as
+// "GET /admin/users" for "GET /users" in the "/admin" group
+func (g *Group) Pattern(pattern string) string {
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		return method + " " + g.prefix + path
+	}
+	return g.prefix + pattern
+}
+
+// Handle registers handler, wrapped in g's middleware, for pattern under g's prefix
+func (g *Group) Handle(pattern string, handler http.Handler) {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	g.router.Handle(g.Pattern(pattern), handler)
+}
+
+// ServeHTTP resolves the route pattern, stores it in the request context and
+// runs the middleware chain
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, pattern := rt.mux.Handler(r)
+	if pattern == "" {
+		pattern = UnmatchedPattern
+	}
+	ctx := context.WithValue(r.Context(), PatternKey, pattern)
+	rt.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// dispatch runs the matched route, or the handler set for why none matched
+func (rt *Router) dispatch(w http.ResponseWriter, r *http.Request) {
+	if Pattern(r) != UnmatchedPattern || (rt.notFound == nil && rt.methodNotAllowed == nil) {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+
+	// The mux tells a missing path from a wrong method only by answering it
+	unmatched := &unmatchedWriter{header: make(http.Header)}
+	rt.mux.ServeHTTP(unmatched, r)
+	var handler http.Handler
+	switch unmatched.status {
+	case http.StatusNotFound:
+		handler = rt.notFound
+	case http.StatusMethodNotAllowed:
+		handler = rt.methodNotAllowed
+		w.Header()["Allow"] = unmatched.header["Allow"]
+	}
+	if handler == nil {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// unmatchedWriter keeps the status and headers of the mux's answer to an
+// unmatched request, discarding its plain text body
+type unmatchedWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *unmatchedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *unmatchedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *unmatchedWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Pattern returns the route pattern stored in the request context, if any
+func Pattern(r *http.Request) string {
+	pattern, _ := r.Context().Value(PatternKey).(string)
+	return pattern
+}
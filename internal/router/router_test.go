@@ -0,0 +1,192 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var seenPattern string
+	record := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			seenPattern = Pattern(r)
+		})
+	}
+
+	r := New()
+	r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	r.Use(record)
+
+	tests := []struct {
+		name        string
+		path        string
+		wantStatus  int
+		wantPattern string
+	}{
+		{"known route", "/users", http.StatusOK, "/users"},
+		{"unknown route", "/nonexistent", http.StatusNotFound, UnmatchedPattern},
+		{"trailing slash", "/users/", http.StatusNotFound, UnmatchedPattern},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seenPattern = ""
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if seenPattern != tt.wantPattern {
+				t.Errorf("Expected pattern %q, got %q", tt.wantPattern, seenPattern)
+			}
+		})
+	}
+}
+
+func TestRouterMiddlewareOrder(t *testing.T) {
+	var order []string
+	named := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	r := New()
+	r.Use(named("outer"), named("inner"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("Expected middleware order [outer inner], got %v", order)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var ran []string
+	var seenPattern string
+	named := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ran = append(ran, name)
+				seenPattern = Pattern(r)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := New()
+	r.Group("").Handle("GET /users", ok)
+	admin := r.Group("/admin").Use(named("admin"))
+	admin.Handle("GET /users", ok)
+	admin.Group("/webhooks").Use(named("webhooks")).Handle("/{id}", ok)
+	r.Use(named("global"))
+
+	tests := []struct {
+		name        string
+		path        string
+		wantRan     []string
+		wantPattern string
+	}{
+		{"outside the group", "/users", []string{"global"}, "GET /users"},
+		{"in the group", "/admin/users", []string{"global", "admin"}, "GET /admin/users"},
+		{"nested group", "/admin/webhooks/7", []string{"global", "admin", "webhooks"}, "/admin/webhooks/{id}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			if strings.Join(ran, " ") != strings.Join(tt.wantRan, " ") {
+				t.Errorf("Expected middleware %v to run, got %v", tt.wantRan, ran)
+			}
+			if seenPattern != tt.wantPattern {
+				t.Errorf("Expected the group middleware to see pattern %q, got %q", tt.wantPattern, seenPattern)
+			}
+		})
+	}
+}
+
+func TestRouterUnmatched(t *testing.T) {
+	var seenPattern string
+	record := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			seenPattern = Pattern(r)
+		})
+	}
+	answer := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte(body))
+		})
+	}
+
+	r := New()
+	r.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	r.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	r.Use(record)
+
+	t.Run("defaults to the mux", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/userz", nil))
+		if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "404 page not found") {
+			t.Errorf("Expected the mux's 404, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	r.NotFound(answer("not found"))
+	r.MethodNotAllowed(answer("method not allowed"))
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantBody  string
+		wantAllow string
+	}{
+		{"unknown path", "GET", "/userz", "not found", ""},
+		{"wrong method", "DELETE", "/users", "method not allowed", "GET, HEAD, POST"},
+		{"matched", "GET", "/users", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seenPattern = ""
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rr.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, rr.Body.String())
+			}
+			if allow := rr.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("Expected Allow %q, got %q", tt.wantAllow, allow)
+			}
+			if tt.wantBody != "" && seenPattern != UnmatchedPattern {
+				t.Errorf("Expected the middleware to see pattern %q, got %q", UnmatchedPattern, seenPattern)
+			}
+		})
+	}
+}
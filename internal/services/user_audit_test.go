@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/audit"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/middleware"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
+)
+
+// newAuditService returns a service whose transactions all use tx and record audit entries in it
+func newAuditService() (*UserService, *mocks.MockTx) {
+	tx := &mocks.MockTx{}
+	db := &mocks.MockBeginner{}
+	db.On("Begin", mock.Anything).Return(tx, nil)
+	// Transactions can prepare statements, so user reads go through the prepared GetUserByID
+	tx.On("Prepare", mock.Anything, queries.Default.GetUserByIDStatement, queries.Default.GetUserByID).Return(&pgconn.StatementDescription{}, nil).Maybe()
+
+	reg := prometheus.NewRegistry()
+	s := NewUserService(repository.NewPgxUserRepository(&mocks.MockDBTX{}, queries.DefaultUsersTable), metrics.New(reg, reg),
+		WithTxManager(database.NewTxManager(db), newTxRepo),
+		WithAudit(audit.NewPgxStore(&mocks.MockDBTX{}), audit.NewPgxStore))
+	return s, tx
+}
+
+// expectAudit expects one audit insert for action on userID and returns its captured snapshots
+func expectAudit(tx *mocks.MockTx, ctx context.Context, action string, userID int) *[2][]byte {
+	var snapshots [2][]byte
+	tx.On("Exec", ctx, audit.InsertEntry, middleware.AdminActor, "req-1", action, userID, mock.Anything, mock.Anything).
+		Return(pgconn.CommandTag("INSERT 0 1"), nil).
+		Run(func(args mock.Arguments) {
+			snapshots[0], snapshots[1] = args.Get(6).([]byte), args.Get(7).([]byte)
+		})
+	return &snapshots
+}
+
+func TestUserServiceAudit(t *testing.T) {
+	ctx := reqctx.WithCaller(reqctx.WithRequestID(context.Background(), "req-1"), reqctx.Caller{Subject: middleware.AdminActor})
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com", Role: models.RoleUser, Status: models.StatusActive}
+	updated := models.User{ID: 1, Name: "John Updated", Email: "john@example.com", Role: models.RoleUser, Status: models.StatusActive}
+	disabled := john
+	disabled.Status = models.StatusDisabled
+
+	// snapshotName decodes the name from an audit snapshot, or "" for a null one
+	snapshotName := func(t *testing.T, snapshot []byte) string {
+		if snapshot == nil {
+			return ""
+		}
+		var user models.User
+		assert.NoError(t, json.Unmarshal(snapshot, &user))
+		return user.Name
+	}
+
+	t.Run("add user", func(t *testing.T) {
+		s, tx := newAuditService()
+		tx.On("Exec", ctx, queries.Default.InsertUser, "John Doe", "john@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByEmail, "john@example.com").Return(userRow(john))
+		snapshots := expectAudit(tx, ctx, audit.ActionCreate, 1)
+		tx.On("Commit", ctx).Return(nil)
+
+		created, err := s.AddUser(ctx, models.User{Name: "John Doe", Email: "john@example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, created.ID)
+		tx.AssertExpectations(t)
+		assert.Nil(t, snapshots[0])
+		assert.Equal(t, "John Doe", snapshotName(t, snapshots[1]))
+	})
+
+	t.Run("add users", func(t *testing.T) {
+		s, tx := newAuditService()
+		jane := models.User{ID: 2, Name: "Jane Smith", Email: "jane@example.com", Role: models.RoleUser}
+		tx.On("Exec", ctx, queries.Default.InsertUser, "John Doe", "john@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Jane Smith", "jane@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByEmail, "john@example.com").Return(userRow(john))
+		tx.On("QueryRow", ctx, queries.Default.GetUserByEmail, "jane@example.com").Return(userRow(jane))
+		expectAudit(tx, ctx, audit.ActionCreate, 1)
+		expectAudit(tx, ctx, audit.ActionCreate, 2)
+		tx.On("Commit", ctx).Return(nil)
+
+		assert.NoError(t, s.AddUsers(ctx, []models.User{
+			{Name: "John Doe", Email: "john@example.com"},
+			{Name: "Jane Smith", Email: "jane@example.com"},
+		}))
+		tx.AssertExpectations(t)
+	})
+
+	t.Run("update user", func(t *testing.T) {
+		s, tx := newAuditService()
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john)).Once()
+		tx.On("Exec", ctx, queries.Default.UpdateUser, "John Updated", "john@example.com", "", "", 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(updated)).Once()
+		snapshots := expectAudit(tx, ctx, audit.ActionUpdate, 1)
+		tx.On("Commit", ctx).Return(nil)
+
+		user, err := s.UpdateUser(ctx, models.User{ID: 1, Name: "John Updated", Email: "john@example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "John Updated", user.Name)
+		tx.AssertExpectations(t)
+		assert.Equal(t, "John Doe", snapshotName(t, snapshots[0]))
+		assert.Equal(t, "John Updated", snapshotName(t, snapshots[1]))
+	})
+
+	t.Run("delete user", func(t *testing.T) {
+		s, tx := newAuditService()
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		tx.On("Exec", ctx, queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		snapshots := expectAudit(tx, ctx, audit.ActionDelete, 1)
+		tx.On("Commit", ctx).Return(nil)
+
+		assert.NoError(t, s.DeleteUser(ctx, 1))
+		tx.AssertExpectations(t)
+		assert.Equal(t, "John Doe", snapshotName(t, snapshots[0]))
+		assert.Nil(t, snapshots[1])
+	})
+
+	t.Run("restore user", func(t *testing.T) {
+		s, tx := newAuditService()
+		tx.On("Exec", ctx, queries.Default.RestoreUser, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		expectAudit(tx, ctx, audit.ActionRestore, 1)
+		tx.On("Commit", ctx).Return(nil)
+
+		restored, err := s.RestoreUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, restored)
+		tx.AssertExpectations(t)
+	})
+
+	t.Run("disable and enable user", func(t *testing.T) {
+		s, tx := newAuditService()
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john)).Once()
+		tx.On("Exec", ctx, queries.Default.SetUserStatus, models.StatusDisabled, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(disabled)).Once()
+		expectAudit(tx, ctx, audit.ActionDisable, 1)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(disabled)).Once()
+		tx.On("Exec", ctx, queries.Default.SetUserStatus, models.StatusActive, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john)).Once()
+		expectAudit(tx, ctx, audit.ActionEnable, 1)
+		tx.On("Commit", ctx).Return(nil)
+
+		disabled, err := s.DisableUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, models.StatusDisabled, disabled.Status)
+		_, err = s.EnableUser(ctx, 1)
+		assert.NoError(t, err)
+		tx.AssertExpectations(t)
+	})
+
+	t.Run("failed audit insert rolls back the mutation", func(t *testing.T) {
+		s, tx := newAuditService()
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		tx.On("Exec", ctx, queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("Exec", ctx, audit.InsertEntry, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(pgconn.CommandTag{}, assert.AnError)
+		tx.On("Rollback", ctx).Return(nil)
+
+		err := s.DeleteUser(ctx, 1)
+		assert.ErrorIs(t, err, assert.AnError)
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+}
+
+func TestUserServiceAuditLog(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	ctx := context.Background()
+
+	s := NewUserService(repository.NewInMemoryRepository(), metricsCollector)
+	_, err := s.AuditLog(ctx, audit.Filter{})
+	assert.ErrorIs(t, err, ErrAuditDisabled)
+
+	s = NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector, WithAudit(audit.NewMemoryStore(), nil))
+	_, err = s.DisableUser(ctx, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, s.DeleteUser(ctx, 2))
+	// A failed mutation records nothing
+	assert.ErrorIs(t, s.DeleteUser(ctx, 99), repository.ErrNotFound)
+
+	entries, err := s.AuditLog(ctx, audit.Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, audit.ActionDelete, entries[0].Action)
+		assert.Equal(t, 2, entries[0].UserID)
+		assert.Equal(t, audit.ActionDisable, entries[1].Action)
+		assert.Equal(t, audit.AnonymousActor, entries[1].Actor)
+	}
+}
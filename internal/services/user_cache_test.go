@@ -0,0 +1,358 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/cache"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+)
+
+// userRow returns a mock row that scans the given user
+func userRow(user models.User) *mocks.MockRow {
+	row := &mocks.MockRow{}
+	row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = user.ID
+		*arg[1].(*string) = user.Name
+		*arg[2].(*string) = user.Email
+		*arg[4].(*string) = user.Role
+		*arg[6].(*string) = user.Status
+	})
+	return row
+}
+
+// heldRepository holds the first user lookup after reading the user, closing read
+// once it has and returning once release is closed
+type heldRepository struct {
+	repository.UserRepository
+	read    chan struct{}
+	release chan struct{}
+	held    atomic.Bool
+}
+
+func (r *heldRepository) GetUser(ctx context.Context, id int) (models.User, error) {
+	user, err := r.UserRepository.GetUser(ctx, id)
+	if r.held.CompareAndSwap(false, true) {
+		close(r.read)
+		<-r.release
+	}
+	return user, err
+}
+
+func TestUserServiceCache(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+
+	t.Run("get user is served from cache", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).Once()
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector, WithCache(10, time.Minute))
+
+		for i := 0; i < 3; i++ {
+			user, err := userService.GetUser(context.Background(), 1)
+			assert.NoError(t, err)
+			assert.Equal(t, john, user)
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+	})
+
+	t.Run("get user by email is served from cache", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", context.Background(), queries.Default.GetUserByEmail, "john@example.com").Return(userRow(john)).Once()
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector, WithCache(10, time.Minute))
+
+		for i := 0; i < 3; i++ {
+			user, err := userService.GetUserByEmail(context.Background(), "john@example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, john, user)
+		}
+
+		// Lookups by ID share the same cache
+		user, err := userService.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+	})
+
+	t.Run("not found is not cached", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 100).Return(row)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector, WithCache(10, time.Minute))
+
+		_, err := userService.GetUser(context.Background(), 100)
+		assert.Error(t, err)
+		_, err = userService.GetUser(context.Background(), 100)
+		assert.Error(t, err)
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 2)
+	})
+
+	t.Run("update invalidates cached user", func(t *testing.T) {
+		updated := models.User{ID: 1, Name: "John Updated", Email: "john.updated@example.com"}
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).Once()
+		dbMock.On("Exec", context.Background(), queries.Default.UpdateUser, updated.Name, updated.Email, "", "", 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		// Read back by the update, then by the lookup after it
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(updated)).Twice()
+		dbMock.On("QueryRow", context.Background(), queries.Default.GetUserByEmail, "john@example.com").Return(func() *mocks.MockRow {
+			row := &mocks.MockRow{}
+			row.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+			return row
+		}()).Once()
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector, WithCache(10, time.Minute))
+
+		user, err := userService.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+
+		_, err = userService.UpdateUser(context.Background(), updated)
+		assert.NoError(t, err)
+
+		user, err = userService.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, updated, user)
+
+		// The old email no longer resolves to the cached user
+		_, err = userService.GetUserByEmail(context.Background(), "john@example.com")
+		assert.Error(t, err)
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("a read racing an update does not cache the old user", func(t *testing.T) {
+		repo := &heldRepository{
+			UserRepository: repository.NewInMemoryRepository(repository.SeedUsers()...),
+			read:           make(chan struct{}),
+			release:        make(chan struct{}),
+		}
+		userService := NewUserService(repo, metricsCollector, WithCache(10, time.Minute))
+
+		stale := make(chan models.User)
+		go func() {
+			user, _ := userService.GetUser(context.Background(), 1)
+			stale <- user
+		}()
+		<-repo.read
+
+		_, err := userService.UpdateUser(context.Background(), models.User{ID: 1, Name: "John Updated", Email: "john@example.com"})
+		assert.NoError(t, err)
+
+		// A lookup after the update does not join the one that began before it
+		fresh := make(chan models.User)
+		go func() {
+			user, _ := userService.GetUser(context.Background(), 1)
+			fresh <- user
+		}()
+		select {
+		case user := <-fresh:
+			assert.Equal(t, "John Updated", user.Name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("lookup after the update joined the one before it")
+		}
+
+		close(repo.release)
+		assert.Equal(t, "John Doe", (<-stale).Name)
+
+		user, err := userService.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "John Updated", user.Name)
+	})
+
+	t.Run("delete invalidates cached user", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		notFound := &mocks.MockRow{}
+		notFound.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).Once()
+		dbMock.On("Exec", context.Background(), queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("DELETE 1"), nil)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(notFound).Once()
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector, WithCache(10, time.Minute))
+
+		_, err := userService.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+
+		assert.NoError(t, userService.DeleteUser(context.Background(), 1))
+
+		_, err = userService.GetUser(context.Background(), 1)
+		assert.Error(t, err)
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("zero ttl disables the cache", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john))
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector, WithCache(10, 0))
+
+		for i := 0; i < 3; i++ {
+			_, err := userService.GetUser(context.Background(), 1)
+			assert.NoError(t, err)
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 3)
+	})
+}
+
+func TestUserServiceRedisCache(t *testing.T) {
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com", UpdatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+	newService := func(t *testing.T, dbMock *mocks.MockDBTX) (*UserService, *miniredis.Miniredis, *prometheus.Registry) {
+		reg := prometheus.NewRegistry()
+		server := miniredis.RunT(t)
+		client := cache.NewRedisClient(server.Addr())
+		t.Cleanup(func() { _ = client.Close() })
+		return NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg), WithRedisCache(client, time.Minute)), server, reg
+	}
+
+	t.Run("miss then hit", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(timestampedUserRow(john)).Once()
+		userService, server, reg := newService(t, dbMock)
+
+		for i := 0; i < 3; i++ {
+			user, err := userService.GetUser(context.Background(), 1)
+			assert.NoError(t, err)
+			assert.Equal(t, john, user)
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+		assert.True(t, server.Exists("user:id:1"))
+		assert.Equal(t, 2.0, counterValue(t, reg, "cache_hits_total"))
+		assert.Equal(t, 1.0, counterValue(t, reg, "cache_misses_total"))
+	})
+
+	t.Run("shared between replicas", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", context.Background(), queries.Default.GetUserByEmail, "john@example.com").Return(timestampedUserRow(john)).Once()
+		userService, server, _ := newService(t, dbMock)
+		client := cache.NewRedisClient(server.Addr())
+		t.Cleanup(func() { _ = client.Close() })
+		replica := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), userService.metrics, WithRedisCache(client, time.Minute))
+
+		_, err := userService.GetUserByEmail(context.Background(), "john@example.com")
+		assert.NoError(t, err)
+
+		user, err := replica.GetUserByEmail(context.Background(), "john@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+	})
+
+	t.Run("delete invalidates cached user", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		notFound := &mocks.MockRow{}
+		notFound.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(timestampedUserRow(john)).Once()
+		dbMock.On("Exec", context.Background(), queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("DELETE 1"), nil)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(notFound).Once()
+		userService, server, _ := newService(t, dbMock)
+
+		_, err := userService.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+
+		assert.NoError(t, userService.DeleteUser(context.Background(), 1))
+		assert.False(t, server.Exists("user:id:1"))
+
+		_, err = userService.GetUser(context.Background(), 1)
+		assert.Error(t, err)
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("redis unavailable falls back to database", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(timestampedUserRow(john))
+		dbMock.On("Exec", context.Background(), queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("DELETE 1"), nil)
+		userService, server, reg := newService(t, dbMock)
+		server.Close()
+
+		for i := 0; i < 2; i++ {
+			user, err := userService.GetUser(context.Background(), 1)
+			assert.NoError(t, err)
+			assert.Equal(t, john, user)
+		}
+		assert.NoError(t, userService.DeleteUser(context.Background(), 1))
+
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 2)
+		// Each lookup fails to read and to write back both entries, plus the failed invalidation
+		assert.Equal(t, 7.0, counterValue(t, reg, "cache_errors_total"))
+		assert.Equal(t, 0.0, counterValue(t, reg, "cache_hits_total"))
+	})
+}
+
+// timestampedUserRow returns a mock row that scans the given user including its UpdatedAt
+func timestampedUserRow(user models.User) *mocks.MockRow {
+	row := userRow(user)
+	row.ExpectedCalls[0].Run(func(args mock.Arguments) {
+		arg := args.Get(0).([]interface{})
+		*arg[0].(*int) = user.ID
+		*arg[1].(*string) = user.Name
+		*arg[2].(*string) = user.Email
+		*arg[3].(*time.Time) = user.UpdatedAt
+	})
+	return row
+}
+
+// counterValue returns the current value of the named counter in reg
+func counterValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// staticDB is a minimal DBTX that always returns the same user without mock bookkeeping
+type staticDB struct {
+	database.DBTX
+	user models.User
+}
+
+func (db staticDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return staticRow(db)
+}
+
+type staticRow staticDB
+
+func (r staticRow) Scan(dest ...interface{}) error {
+	*dest[0].(*int) = r.user.ID
+	*dest[1].(*string) = r.user.Name
+	*dest[2].(*string) = r.user.Email
+	*dest[3].(*time.Time) = r.user.UpdatedAt
+	return nil
+}
+
+func benchmarkGetUser(b *testing.B, opts ...Option) {
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	db := staticDB{user: models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}}
+	userService := NewUserService(repository.NewPgxUserRepository(db, queries.DefaultUsersTable), metricsCollector, opts...)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := userService.GetUser(context.Background(), 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUserUncached(b *testing.B) {
+	benchmarkGetUser(b)
+}
+
+func BenchmarkGetUserCached(b *testing.B) {
+	benchmarkGetUser(b, WithCache(1000, time.Minute))
+}
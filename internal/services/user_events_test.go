@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
+)
+
+// fakePublisher keeps published events in memory, failing every publish when err is set
+type fakePublisher struct {
+	mu     sync.Mutex
+	events []events.Event
+	err    error
+}
+
+func (p *fakePublisher) Publish(_ context.Context, event events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return p.err
+}
+
+func TestUserServiceEvents(t *testing.T) {
+	ctx := reqctx.WithRequestID(context.Background(), "req-1")
+	newUser := models.User{Name: "New User", Email: "new@example.com"}
+
+	tests := []struct {
+		name     string
+		mutate   func(s *UserService) error
+		wantType string
+		wantID   int
+		check    func(t *testing.T, user models.User)
+	}{
+		{
+			name: "add",
+			mutate: func(s *UserService) error {
+				_, err := s.AddUser(ctx, newUser)
+				return err
+			},
+			wantType: events.TypeUserCreated,
+			wantID:   5,
+			check: func(t *testing.T, user models.User) {
+				assert.Equal(t, "new@example.com", user.Email)
+				assert.Equal(t, models.StatusActive, user.Status)
+			},
+		},
+		{
+			name: "update",
+			mutate: func(s *UserService) error {
+				_, err := s.UpdateUser(ctx, models.User{ID: 1, Name: "John Updated", Email: "john@example.com"})
+				return err
+			},
+			wantType: events.TypeUserUpdated,
+			wantID:   1,
+			check:    func(t *testing.T, user models.User) { assert.Equal(t, "John Updated", user.Name) },
+		},
+		{
+			name: "disable",
+			mutate: func(s *UserService) error {
+				_, err := s.DisableUser(ctx, 1)
+				return err
+			},
+			wantType: events.TypeUserUpdated,
+			wantID:   1,
+			check:    func(t *testing.T, user models.User) { assert.Equal(t, models.StatusDisabled, user.Status) },
+		},
+		{
+			name:     "delete",
+			mutate:   func(s *UserService) error { return s.DeleteUser(ctx, 2) },
+			wantType: events.TypeUserDeleted,
+			wantID:   2,
+			// A deleted user is announced as it was before the delete
+			check: func(t *testing.T, user models.User) { assert.Nil(t, user.DeletedAt) },
+		},
+		{
+			name: "restore",
+			mutate: func(s *UserService) error {
+				if err := s.DeleteUser(ctx, 2); err != nil {
+					return err
+				}
+				_, err := s.RestoreUser(ctx, 2)
+				return err
+			},
+			wantType: events.TypeUserRestored,
+			wantID:   2,
+			check:    func(t *testing.T, user models.User) { assert.Nil(t, user.DeletedAt) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			publisher := &fakePublisher{}
+			s := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metrics.New(reg, reg), WithEventPublisher(publisher))
+
+			assert.NoError(t, tt.mutate(s))
+
+			if assert.NotEmpty(t, publisher.events) {
+				event := publisher.events[len(publisher.events)-1]
+				assert.Equal(t, tt.wantType, event.Type)
+				assert.Equal(t, tt.wantID, event.User.ID)
+				assert.Equal(t, "req-1", event.RequestID)
+				assert.False(t, event.Timestamp.IsZero())
+				tt.check(t, event.User)
+			}
+		})
+	}
+}
+
+func TestUserServiceEventsPerMutation(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	ctx := context.Background()
+	publisher := &fakePublisher{}
+	s := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metrics.New(reg, reg), WithEventPublisher(publisher))
+
+	assert.NoError(t, s.AddUsers(ctx, []models.User{
+		{Name: "First", Email: "first@example.com"},
+		{Name: "Second", Email: "second@example.com"},
+	}))
+	_, err := s.EnableUser(ctx, 1)
+	assert.NoError(t, err)
+
+	// Failed mutations publish nothing
+	_, err = s.AddUser(ctx, models.User{Name: "Invalid"})
+	assert.Error(t, err)
+	assert.ErrorIs(t, s.DeleteUser(ctx, 99), repository.ErrNotFound)
+	assert.Error(t, s.AddUsers(ctx, []models.User{{Name: "Third", Email: "third@example.com"}, {Name: "Duplicate", Email: "first@example.com"}}))
+
+	var types []string
+	for _, event := range publisher.events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{events.TypeUserCreated, events.TypeUserCreated, events.TypeUserUpdated}, types)
+	assert.Equal(t, "first@example.com", publisher.events[0].User.Email)
+	assert.Equal(t, "second@example.com", publisher.events[1].User.Email)
+}
+
+func TestUserServicePublishFailureKeepsWrite(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	ctx := context.Background()
+	publisher := &fakePublisher{err: errors.New("broker unavailable")}
+	s := NewUserService(repository.NewInMemoryRepository(), metrics.New(reg, reg), WithEventPublisher(publisher))
+
+	_, err := s.AddUser(ctx, models.User{Name: "New User", Email: "new@example.com"})
+	assert.NoError(t, err)
+	assert.Len(t, publisher.events, 1)
+
+	user, err := s.GetUserByEmail(context.Background(), "new@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "New User", user.Name)
+}
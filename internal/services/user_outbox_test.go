@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/outbox"
+	"user-service/internal/repository"
+)
+
+func TestUserServiceOutbox(t *testing.T) {
+	ctx := context.Background()
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com", Role: models.RoleUser, Status: models.StatusActive}
+
+	// newOutboxService returns a service whose transactions all use tx and queue events in it
+	newOutboxService := func() (*UserService, *mocks.MockTx) {
+		tx := &mocks.MockTx{}
+		db := &mocks.MockBeginner{}
+		db.On("Begin", mock.Anything).Return(tx, nil)
+		tx.On("Prepare", mock.Anything, queries.Default.GetUserByIDStatement, queries.Default.GetUserByID).Return(&pgconn.StatementDescription{}, nil).Maybe()
+
+		reg := prometheus.NewRegistry()
+		s := NewUserService(repository.NewPgxUserRepository(&mocks.MockDBTX{}, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithTxManager(database.NewTxManager(db), newTxRepo),
+			WithOutbox(outbox.NewPgxStore(&mocks.MockDBTX{}), outbox.NewPgxStore))
+		return s, tx
+	}
+
+	t.Run("event is queued in the mutation's transaction", func(t *testing.T) {
+		s, tx := newOutboxService()
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		tx.On("Exec", ctx, queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("Exec", ctx, outbox.InsertMessage, events.TypeUserDeleted, mock.Anything).Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("Commit", ctx).Return(nil)
+
+		assert.NoError(t, s.DeleteUser(ctx, 1))
+		tx.AssertExpectations(t)
+	})
+
+	t.Run("failed queue insert rolls back the mutation", func(t *testing.T) {
+		s, tx := newOutboxService()
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(john))
+		tx.On("Exec", ctx, queries.Default.DeleteUser, 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("Exec", ctx, outbox.InsertMessage, events.TypeUserDeleted, mock.Anything).Return(pgconn.CommandTag{}, assert.AnError)
+		tx.On("Rollback", ctx).Return(nil)
+
+		assert.ErrorIs(t, s.DeleteUser(ctx, 1), assert.AnError)
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+
+	t.Run("queued events survive a crash before dispatch", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		metricsCollector := metrics.New(reg, reg)
+		store := outbox.NewMemoryStore()
+		publisher := &fakePublisher{}
+		s := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+			WithOutbox(store, nil), WithEventPublisher(publisher))
+
+		_, err := s.AddUser(ctx, models.User{Name: "New User", Email: "new@example.com"})
+		assert.NoError(t, err)
+		assert.NoError(t, s.DeleteUser(ctx, 2))
+
+		// The process dies before any dispatcher runs: nothing was published and the rows remain
+		assert.Empty(t, publisher.events)
+		pending, err := store.Claim(ctx, time.Now(), 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, pending, 2)
+
+		// The dispatcher of the next process picks them up on its first tick
+		assert.NoError(t, outbox.NewDispatcher(store, publisher, metricsCollector, outbox.DefaultInterval).Tick(ctx))
+		var types []string
+		for _, event := range publisher.events {
+			types = append(types, event.Type)
+		}
+		assert.Equal(t, []string{events.TypeUserCreated, events.TypeUserDeleted}, types)
+
+		pending, err = store.Claim(ctx, time.Now(), 0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"user-service/internal/database"
+	"user-service/internal/logging"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
+)
+
+// ErrQueryTimeout is returned when a repository call runs past the query timeout
+var ErrQueryTimeout = errors.New("database query timed out")
+
+// maxRetryLatency bounds a retried read, so a retry adds at most this much to a request
+const maxRetryLatency = time.Second
+
+// WithQueryLimits bounds every repository call to timeout and logs the calls that
+// take slowThreshold or longer. A zero value disables either limit.
+func WithQueryLimits(timeout, slowThreshold time.Duration) Option {
+	return func(s *UserService) {
+		s.queryTimeout = timeout
+		s.slowQuery = slowThreshold
+	}
+}
+
+// limitQueries wraps repo so its calls honor the service's query limits and,
+// when retryReads is set, its reads failing with a transient database error are
+// retried once. Reads in a transaction are not, as a failed transaction is
+// aborted. Without limits or retries it returns repo unchanged.
+func (s *UserService) limitQueries(repo repository.UserRepository, retryReads bool) repository.UserRepository {
+	if !retryReads && s.queryTimeout <= 0 && s.slowQuery <= 0 {
+		return repo
+	}
+	return &limitedRepository{repo: repo, timeout: s.queryTimeout, slow: s.slowQuery, retryReads: retryReads, metrics: s.metrics}
+}
+
+// limitedRepository times out and reports slow calls to the repository it wraps,
+// and retries its reads
+type limitedRepository struct {
+	repo       repository.UserRepository
+	timeout    time.Duration
+	slow       time.Duration
+	retryReads bool
+	metrics    metrics.Recorder
+}
+
+// runQuery runs one repository call named operation with the query timeout applied
+// to ctx. The timeout only ever shortens a deadline ctx already has, such as the
+// request's, and a call whose deadline has passed is not started at all. A call
+// cut short by either fails with ErrQueryTimeout.
+func runQuery[T any](r *limitedRepository, ctx context.Context, operation string, call func(ctx context.Context) (T, error)) (T, error) {
+	// budget is how long the call may take, for the timeout error
+	budget := r.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			var zero T
+			return zero, fmt.Errorf("%s not started past the deadline: %w", operation, ErrQueryTimeout)
+		}
+		if budget <= 0 || remaining < budget {
+			budget = remaining
+		}
+	}
+
+	queryCtx, cancel := ctx, context.CancelFunc(func() {})
+	if r.timeout > 0 {
+		queryCtx, cancel = context.WithTimeout(ctx, r.timeout)
+	}
+	defer cancel()
+
+	start := time.Now()
+	result, err := call(queryCtx)
+	duration := time.Since(start)
+
+	if r.slow > 0 && duration >= r.slow {
+		requestID := reqctx.RequestIDFromContext(ctx)
+		logging.FromContext(ctx).Warn("Slow database query", "operation", operation, "duration", duration, "request_id", requestID)
+		r.metrics.RecordSlowQuery(operation)
+	}
+	if err != nil && errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("%s after %s: %w", operation, budget.Round(time.Millisecond), ErrQueryTimeout)
+	}
+	return result, err
+}
+
+// runRead runs a read like runQuery and, if it fails with a transient database
+// error, as during a failover, runs it once more while ctx has time left. The
+// retry takes at most maxRetryLatency. Writes are never retried, as they may
+// have run. A read ReconnectingConn already retried on a new connection fails
+// with an error that is not transient, so it runs twice at most, not three times.
+func runRead[T any](r *limitedRepository, ctx context.Context, operation string, call func(ctx context.Context) (T, error)) (T, error) {
+	result, err := runQuery(r, ctx, operation, call)
+	if !r.retryReads || !database.Transient(err) || ctx.Err() != nil {
+		return result, err
+	}
+
+	requestID := reqctx.RequestIDFromContext(ctx)
+	logging.FromContext(ctx).Warn("Retrying database read after a transient error", "operation", operation, "error", err, "request_id", requestID)
+	r.metrics.RecordDBRetry(operation)
+	retryCtx, cancel := context.WithTimeout(ctx, maxRetryLatency)
+	defer cancel()
+	return runQuery(r, retryCtx, operation, call)
+}
+
+// runExec runs a repository call that returns only an error
+func runExec(r *limitedRepository, ctx context.Context, operation string, call func(ctx context.Context) error) error {
+	_, err := runQuery(r, ctx, operation, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, call(ctx)
+	})
+	return err
+}
+
+func (r *limitedRepository) GetUser(ctx context.Context, id int) (models.User, error) {
+	return runRead(r, ctx, "get_user", func(ctx context.Context) (models.User, error) {
+		return r.repo.GetUser(ctx, id)
+	})
+}
+
+func (r *limitedRepository) Exists(ctx context.Context, id int) (bool, error) {
+	return runRead(r, ctx, "user_exists", func(ctx context.Context) (bool, error) {
+		return r.repo.Exists(ctx, id)
+	})
+}
+
+func (r *limitedRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	return runRead(r, ctx, "email_exists", func(ctx context.Context) (bool, error) {
+		return r.repo.EmailExists(ctx, email)
+	})
+}
+
+func (r *limitedRepository) EmailOwner(ctx context.Context, email string) (int, error) {
+	return runRead(r, ctx, "email_owner", func(ctx context.Context) (int, error) {
+		return r.repo.EmailOwner(ctx, email)
+	})
+}
+
+func (r *limitedRepository) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return runRead(r, ctx, "get_user_by_email", func(ctx context.Context) (models.User, error) {
+		return r.repo.GetUserByEmail(ctx, email)
+	})
+}
+
+func (r *limitedRepository) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	return runRead(r, ctx, "list_users", func(ctx context.Context) ([]models.User, error) {
+		return r.repo.ListUsers(ctx, filter)
+	})
+}
+
+func (r *limitedRepository) ListAllUsers(ctx context.Context) ([]models.User, error) {
+	return runRead(r, ctx, "list_all_users", r.repo.ListAllUsers)
+}
+
+// EachUser runs as long as the caller keeps consuming users, so it is not bounded
+// by the query timeout
+func (r *limitedRepository) EachUser(ctx context.Context, fn func(models.User) error) error {
+	return r.repo.EachUser(ctx, fn)
+}
+
+func (r *limitedRepository) Count(ctx context.Context) (int, error) {
+	return runRead(r, ctx, "count", r.repo.Count)
+}
+
+func (r *limitedRepository) CountDeleted(ctx context.Context) (int, error) {
+	return runRead(r, ctx, "count_deleted", r.repo.CountDeleted)
+}
+
+func (r *limitedRepository) CountByStatus(ctx context.Context) (map[string]int, error) {
+	return runRead(r, ctx, "count_by_status", r.repo.CountByStatus)
+}
+
+func (r *limitedRepository) Create(ctx context.Context, user models.User) error {
+	return runExec(r, ctx, "create", func(ctx context.Context) error {
+		return r.repo.Create(ctx, user)
+	})
+}
+
+func (r *limitedRepository) Update(ctx context.Context, user models.User) error {
+	return runExec(r, ctx, "update", func(ctx context.Context) error {
+		return r.repo.Update(ctx, user)
+	})
+}
+
+func (r *limitedRepository) Patch(ctx context.Context, id int, patch models.UserPatch) error {
+	return runExec(r, ctx, "patch", func(ctx context.Context) error {
+		return r.repo.Patch(ctx, id, patch)
+	})
+}
+
+func (r *limitedRepository) Delete(ctx context.Context, id int) error {
+	return runExec(r, ctx, "delete", func(ctx context.Context) error {
+		return r.repo.Delete(ctx, id)
+	})
+}
+
+func (r *limitedRepository) Restore(ctx context.Context, id int) error {
+	return runExec(r, ctx, "restore", func(ctx context.Context) error {
+		return r.repo.Restore(ctx, id)
+	})
+}
+
+func (r *limitedRepository) GetUserBySubject(ctx context.Context, subject string) (models.User, error) {
+	return runRead(r, ctx, "get_user_by_subject", func(ctx context.Context) (models.User, error) {
+		return r.repo.GetUserBySubject(ctx, subject)
+	})
+}
+
+func (r *limitedRepository) LinkSubject(ctx context.Context, id int, subject string) error {
+	return runExec(r, ctx, "link_subject", func(ctx context.Context) error {
+		return r.repo.LinkSubject(ctx, id, subject)
+	})
+}
+
+func (r *limitedRepository) SetStatus(ctx context.Context, id int, status string) error {
+	return runExec(r, ctx, "set_status", func(ctx context.Context) error {
+		return r.repo.SetStatus(ctx, id, status)
+	})
+}
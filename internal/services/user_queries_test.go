@@ -0,0 +1,238 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+	"user-service/internal/reqctx"
+)
+
+func TestUserServiceQueryLimits(t *testing.T) {
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+	ctx := reqctx.WithRequestID(context.Background(), "req-1")
+
+	t.Run("logs and counts queries past the slow threshold", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).After(30 * time.Millisecond)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, 20*time.Millisecond))
+
+		var logs bytes.Buffer
+		defaultLogger := slog.Default()
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+		defer slog.SetDefault(defaultLogger)
+
+		user, err := userService.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+
+		var record struct {
+			Level     string `json:"level"`
+			Msg       string `json:"msg"`
+			Operation string `json:"operation"`
+			Duration  int64  `json:"duration"`
+			RequestID string `json:"request_id"`
+		}
+		assert.NoError(t, json.Unmarshal(logs.Bytes(), &record))
+		assert.Equal(t, "WARN", record.Level)
+		assert.Equal(t, "Slow database query", record.Msg)
+		assert.Equal(t, "get_user", record.Operation)
+		assert.Equal(t, "req-1", record.RequestID)
+		assert.GreaterOrEqual(t, time.Duration(record.Duration), 30*time.Millisecond)
+		assert.Equal(t, float64(1), counterValue(t, reg, "db_slow_queries_total"))
+	})
+
+	t.Run("does not report fast queries", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john))
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, time.Second))
+
+		_, err := userService.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(0), counterValue(t, reg, "db_slow_queries_total"))
+	})
+
+	t.Run("cuts queries short at the timeout", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("Query", mock.Anything, queries.Default.ListUsers).Return(nil, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(20*time.Millisecond, 0))
+
+		start := time.Now()
+		_, err := userService.ListUsers(ctx, models.UserFilter{})
+		assert.ErrorIs(t, err, ErrQueryTimeout)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("inherits a tighter request deadline", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("Query", mock.Anything, queries.Default.ListUsers).Return(nil, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Minute, 0))
+
+		requestCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := userService.ListUsers(requestCtx, models.UserFilter{})
+		assert.ErrorIs(t, err, ErrQueryTimeout)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.NotContains(t, err.Error(), "1m0s")
+	})
+
+	t.Run("keeps the request deadline for collapsed reads", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(context.DeadlineExceeded)
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(row).Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Minute, 0))
+
+		requestCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := userService.GetUser(requestCtx, 1)
+		assert.ErrorIs(t, err, ErrQueryTimeout)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("does not start a query past the request deadline", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Minute, 0))
+
+		requestCtx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Millisecond))
+		defer cancel()
+		_, err := userService.ListUsers(requestCtx, models.UserFilter{})
+		assert.ErrorIs(t, err, ErrQueryTimeout)
+		dbMock.AssertNotCalled(t, "Query", mock.Anything, queries.Default.ListUsers)
+	})
+
+	t.Run("leaves other errors alone", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		userService := NewUserService(repository.NewInMemoryRepository(), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, time.Second))
+
+		_, err := userService.GetUser(ctx, 100)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		assert.NotErrorIs(t, err, ErrQueryTimeout)
+	})
+}
+
+func TestUserServiceReadRetries(t *testing.T) {
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+	ctx := reqctx.WithRequestID(context.Background(), "req-1")
+
+	// failingRow fails to scan with err, as a read cut off by a failover does
+	failingRow := func(err error) *mocks.MockRow {
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(err)
+		return row
+	}
+
+	t.Run("retries a read once after a transient error", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(failingRow(&pgconn.PgError{Code: "57P01"})).Once()
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).Once()
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, 0))
+
+		user, err := userService.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+		dbMock.AssertExpectations(t)
+		assert.Equal(t, float64(1), counterValue(t, reg, "db_retries_total"))
+	})
+
+	t.Run("retries without query limits", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(failingRow(&pgconn.PgError{Code: "57P01"})).Once()
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).Once()
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+
+		user, err := userService.GetUser(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, john, user)
+		assert.Equal(t, float64(1), counterValue(t, reg, "db_retries_total"))
+	})
+
+	t.Run("retries only once", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(failingRow(&pgconn.PgError{Code: "40001"}))
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, 0))
+
+		_, err := userService.GetUsersCount(ctx)
+		assert.Error(t, err)
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 2)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(failingRow(assert.AnError))
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, 0))
+
+		_, err := userService.GetUser(ctx, 1)
+		assert.ErrorIs(t, err, assert.AnError)
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+		assert.Equal(t, float64(0), counterValue(t, reg, "db_retries_total"))
+	})
+
+	t.Run("does not retry past the request deadline", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(failingRow(&pgconn.PgError{Code: "57P01"})).After(30 * time.Millisecond)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, 0))
+
+		requestCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err := userService.GetUser(requestCtx, 1)
+		assert.ErrorIs(t, err, ErrQueryTimeout)
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+		assert.Equal(t, float64(0), counterValue(t, reg, "db_retries_total"))
+	})
+
+	t.Run("does not retry writes", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("Exec", mock.Anything, queries.Default.RestoreUser, 1).Return(pgconn.CommandTag(nil), &pgconn.PgError{Code: "40001"})
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg),
+			WithQueryLimits(time.Second, 0))
+
+		_, err := userService.RestoreUser(ctx, 1)
+		assert.Error(t, err)
+		dbMock.AssertNumberOfCalls(t, "Exec", 1)
+		assert.Equal(t, float64(0), counterValue(t, reg, "db_retries_total"))
+	})
+}
@@ -2,84 +2,853 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+	"user-service/internal/audit"
+	"user-service/internal/cache"
 	"user-service/internal/database"
+	"user-service/internal/events"
+	"user-service/internal/health"
+	"user-service/internal/logging"
 	"user-service/internal/metrics"
 	"user-service/internal/models"
+	"user-service/internal/outbox"
+	"user-service/internal/repository"
+	"user-service/internal/webhooks"
 )
 
+// ErrAuditDisabled is returned when reading the audit log of a service that does not keep one
+var ErrAuditDisabled = errors.New("audit log is disabled")
+
+// ErrWebhooksDisabled is returned when managing webhooks on a service without a webhook store
+var ErrWebhooksDisabled = errors.New("webhooks are disabled")
+
 // UserService handles user-related business logic
 type UserService struct {
-	db      database.DBTX
-	metrics *metrics.Metrics
+	repo    repository.UserRepository
+	metrics metrics.Recorder
+
+	// txm runs multi-statement writes atomically, using txRepo to bind a repository to
+	// each transaction. Both are nil when the storage has no transactions.
+	txm    database.TxManager
+	txRepo func(database.DBTX) repository.UserRepository
+
+	// audit records every mutation; txAudit binds it to a mutation's transaction.
+	// audit is nil when auditing is off, and txAudit when the store has no transactions.
+	audit   audit.Store
+	txAudit func(database.DBTX) audit.Store
+
+	// events announces committed changes; nil when no publisher is configured.
+	// outbox instead queues them for a dispatcher, within the mutation's transaction
+	// when txOutbox binds it to one.
+	events   events.EventPublisher
+	outbox   outbox.Store
+	txOutbox func(database.DBTX) outbox.Store
+
+	// webhooks keeps partner webhook subscriptions; nil when webhooks are off
+	webhooks webhooks.Store
+
+	// Read-through cache of users by ID, plus an email to ID index. Both are nil when caching is disabled.
+	cache      cache.Cache[int, models.User]
+	emailIndex cache.Cache[string, int]
+
+	// queries collapses identical concurrent database lookups into one round trip
+	queries singleflight.Group
+
+	// invalidations counts the cached users dropped by writes, so a read that began
+	// before one does not cache the row it read from before the write
+	invalidations atomic.Uint64
+
+	// Every repository call is cut short after queryTimeout and logged when it takes
+	// slowQuery or longer. Zero disables either limit.
+	queryTimeout time.Duration
+	slowQuery    time.Duration
+
+	// cacheDown is set after a cache operation fails so the outage is only logged once
+	cacheDown atomic.Bool
+}
+
+// Option configures optional UserService behaviour
+type Option func(*UserService)
+
+// WithCache enables an in-process read-through cache for user lookups.
+// A zero ttl or size leaves the cache disabled.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(s *UserService) {
+		if size <= 0 || ttl <= 0 {
+			return
+		}
+		s.cache = cache.NewMemory[int, models.User](size, ttl)
+		s.emailIndex = cache.NewMemory[string, int](size, ttl)
+	}
+}
+
+// WithRedisCache enables a read-through cache for user lookups shared between
+// replicas through Redis. A nil client or zero ttl leaves the cache disabled.
+func WithRedisCache(client *redis.Client, ttl time.Duration) Option {
+	return func(s *UserService) {
+		if client == nil || ttl <= 0 {
+			return
+		}
+		s.cache = cache.NewRedis[int, models.User](client, "user:id:", ttl)
+		s.emailIndex = cache.NewRedis[string, int](client, "user:email:", ttl)
+	}
+}
+
+// WithTxManager runs writes inside database transactions from txm, using
+// newRepo to create a repository for each transaction.
+func WithTxManager(txm database.TxManager, newRepo func(database.DBTX) repository.UserRepository) Option {
+	return func(s *UserService) {
+		s.txm = txm
+		s.txRepo = newRepo
+	}
+}
+
+// WithAudit records every mutation in store. When the service has a transaction
+// manager, newStore binds the store to the mutation's transaction so an entry that
+// fails to save rolls the mutation back. newStore may be nil for stores without transactions.
+func WithAudit(store audit.Store, newStore func(database.DBTX) audit.Store) Option {
+	return func(s *UserService) {
+		s.audit = store
+		s.txAudit = newStore
+	}
 }
 
-// NewUserService creates a new user service with a database connection and metrics
-func NewUserService(db database.DBTX, metricsCollector *metrics.Metrics) *UserService {
-	return &UserService{
-		db:      db,
+// WithEventPublisher publishes an event after every committed mutation
+func WithEventPublisher(publisher events.EventPublisher) Option {
+	return func(s *UserService) {
+		s.events = publisher
+	}
+}
+
+// WithOutbox queues an event in store for every mutation instead of publishing it
+// directly. When the service has a transaction manager, newStore binds the store to
+// the mutation's transaction so the event is kept exactly when the mutation commits.
+// newStore may be nil for stores without transactions.
+func WithOutbox(store outbox.Store, newStore func(database.DBTX) outbox.Store) Option {
+	return func(s *UserService) {
+		s.outbox = store
+		s.txOutbox = newStore
+	}
+}
+
+// WithWebhooks lets admins manage webhook subscriptions kept in store
+func WithWebhooks(store webhooks.Store) Option {
+	return func(s *UserService) {
+		s.webhooks = store
+	}
+}
+
+// NewUserService creates a new user service with a repository and metrics
+func NewUserService(repo repository.UserRepository, metricsCollector metrics.Recorder, opts ...Option) *UserService {
+	s := &UserService{
+		repo:    repo,
 		metrics: metricsCollector,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.repo = s.limitQueries(s.repo, true)
+	if newRepo := s.txRepo; newRepo != nil {
+		s.txRepo = func(tx database.DBTX) repository.UserRepository {
+			return s.limitQueries(newRepo(tx), false)
+		}
+	}
+	return s
 }
 
 // GetUser retrieves a user by ID
-func (s *UserService) GetUser(id int) (models.User, error) {
-	var user models.User
-	err := s.db.QueryRow(context.Background(), "SELECT id, name, email FROM users WHERE id = $1", id).Scan(&user.ID, &user.Name, &user.Email)
+func (s *UserService) GetUser(ctx context.Context, id int) (models.User, error) {
+	if s.cache != nil {
+		if user, ok := s.cached(id); ok {
+			s.metrics.RecordCacheHit()
+			s.metrics.RecordUserLookup("found")
+			return user, nil
+		}
+		s.metrics.RecordCacheMiss()
+	}
+
+	v, err := s.collapse(ctx, "get_user", userKey(id), func(ctx context.Context) (interface{}, error) {
+		since := s.invalidations.Load()
+		user, err := s.repo.GetUser(ctx, id)
+		if err == nil {
+			s.store(user, since)
+		}
+		return user, err
+	})
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			s.metrics.RecordUserLookup("not_found")
-			return models.User{}, fmt.Errorf("user not found")
 		}
 		return models.User{}, err
 	}
 
 	s.metrics.RecordUserLookup("found")
+	return v.(models.User), nil
+}
+
+// UserExists reports whether user id exists, answering from the cache when it holds
+// the user and otherwise checking without reading the user
+func (s *UserService) UserExists(ctx context.Context, id int) (bool, error) {
+	if s.cache != nil {
+		if _, ok := s.cached(id); ok {
+			s.metrics.RecordCacheHit()
+			s.metrics.RecordUserLookup("found")
+			return true, nil
+		}
+		s.metrics.RecordCacheMiss()
+	}
+
+	exists, err := s.repo.Exists(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		s.metrics.RecordUserLookup("found")
+	} else {
+		s.metrics.RecordUserLookup("not_found")
+	}
+	return exists, nil
+}
+
+// GetUserBySubject retrieves the user an identity provider subject signs in as.
+// It returns repository.ErrNotFound when the subject is linked to no user.
+func (s *UserService) GetUserBySubject(ctx context.Context, subject string) (models.User, error) {
+	since := s.invalidations.Load()
+	user, err := s.repo.GetUserBySubject(ctx, subject)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.metrics.RecordUserLookup("not_found")
+		}
+		return models.User{}, err
+	}
+
+	s.metrics.RecordUserLookup("found")
+	s.store(user, since)
 	return user, nil
 }
 
-// ListUsers returns all users
-func (s *UserService) ListUsers() ([]models.User, error) {
-	rows, err := s.db.Query(context.Background(), "SELECT id, name, email FROM users")
+// LinkSubject links user id to an identity provider subject, so that GET /me
+// answers the subject's requests with the user, and returns the user read within
+// the write
+func (s *UserService) LinkSubject(ctx context.Context, id int, subject string) (models.User, error) {
+	var user models.User
+	err := s.WithTx(ctx, func(repo repository.UserRepository) error {
+		if err := repo.LinkSubject(ctx, id, subject); err != nil {
+			return err
+		}
+		var err error
+		user, err = repo.GetUser(ctx, id)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return models.User{}, err
 	}
-	defer rows.Close()
+	return user, nil
+}
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Email); err != nil {
-			return nil, err
+// EmailExists reports whether email is taken by any user, deleted ones included,
+// in any case. Creating a user can still fail with repository.ErrDuplicateEmail
+// when another request takes the email in between.
+func (s *UserService) EmailExists(ctx context.Context, email string) (bool, error) {
+	return s.repo.EmailExists(ctx, email)
+}
+
+// EmailOwner returns the ID of the user that has email, deleted ones included, in
+// any case, or repository.ErrNotFound
+func (s *UserService) EmailOwner(ctx context.Context, email string) (int, error) {
+	return s.repo.EmailOwner(ctx, email)
+}
+
+// GetUserByEmail retrieves a user by email address
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if s.cache != nil {
+		id, ok, err := s.emailIndex.Get(context.Background(), email)
+		s.cacheResult(err)
+		if ok {
+			if user, ok := s.cached(id); ok && user.Email == email {
+				s.metrics.RecordCacheHit()
+				s.metrics.RecordUserLookup("found")
+				return user, nil
+			}
 		}
-		users = append(users, user)
+		s.metrics.RecordCacheMiss()
 	}
 
-	return users, nil
+	since := s.invalidations.Load()
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.metrics.RecordUserLookup("not_found")
+		}
+		return models.User{}, err
+	}
+
+	s.metrics.RecordUserLookup("found")
+	s.store(user, since)
+	return user, nil
+}
+
+// ListUsers returns the users matching filter ordered by ID
+func (s *UserService) ListUsers(ctx context.Context, filter models.UserFilter) ([]models.User, error) {
+	return s.repo.ListUsers(ctx, filter)
 }
 
-// GetUsersCount returns the current number of users
-func (s *UserService) GetUsersCount() (int, error) {
-	var count int
-	err := s.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&count)
+// ListAllUsers returns every user for admins, including deleted ones when includeDeleted is set
+func (s *UserService) ListAllUsers(ctx context.Context, includeDeleted bool) ([]models.User, error) {
+	if !includeDeleted {
+		return s.repo.ListUsers(ctx, models.UserFilter{})
+	}
+	return s.repo.ListAllUsers(ctx)
+}
+
+// ExportUsers calls fn with every user ordered by ID as they are read, stopping
+// at the first error fn returns or once ctx is done, such as when the client
+// disconnects. Exports cut short by ctx are counted as aborted.
+func (s *UserService) ExportUsers(ctx context.Context, fn func(models.User) error) error {
+	err := s.repo.EachUser(ctx, fn)
+	if err != nil && ctx.Err() != nil {
+		s.metrics.RecordExportAborted()
+	}
+	return err
+}
+
+// GetUsersCount returns the current number of users, not counting deleted ones
+func (s *UserService) GetUsersCount(ctx context.Context) (int, error) {
+	v, err := s.collapse(ctx, "count", "count", func(ctx context.Context) (interface{}, error) {
+		return s.repo.Count(ctx)
+	})
 	if err != nil {
 		return 0, err
 	}
-	return count, nil
+	return v.(int), nil
+}
+
+// RefreshUserGauges sets the per-status and deleted user gauges from the repository
+func (s *UserService) RefreshUserGauges(ctx context.Context) error {
+	counts, err := s.repo.CountByStatus(ctx)
+	if err != nil {
+		return err
+	}
+	deleted, err := s.repo.CountDeleted(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Every status is set so one that no user has any more drops to zero
+	for _, status := range models.Statuses {
+		s.metrics.SetUsersTotal(status, float64(counts[status]))
+	}
+	s.metrics.SetDeletedUsersTotal(float64(deleted))
+	return nil
 }
 
-// AddUser adds a new user (for future use)
-func (s *UserService) AddUser(user models.User) error {
+// AddUser adds a new user and returns it as stored, read within the write so that
+// it has its assigned ID even when the replicas have yet to see it
+func (s *UserService) AddUser(ctx context.Context, user models.User) (models.User, error) {
+	user.Sanitize()
 	if err := user.Validate(); err != nil {
+		return models.User{}, err
+	}
+
+	var stored models.User
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		created, err := s.create(ctx, repo, log, user)
+		if err != nil {
+			return nil, err
+		}
+		if created == nil {
+			if stored, err = repo.GetUserByEmail(ctx, user.Email); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+		stored = *created
+		return s.changed(ctx, events.TypeUserCreated, created), nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if s.emailIndex != nil {
+		s.cacheResult(s.emailIndex.Delete(context.Background(), user.Email))
+	}
+	return stored, nil
+}
+
+// AddUsers adds several users at once. Either all of them are created or, when
+// any is invalid or fails to save, none are.
+func (s *UserService) AddUsers(ctx context.Context, users []models.User) error {
+	users = append([]models.User(nil), users...)
+	for i := range users {
+		users[i].Sanitize()
+		if err := users[i].Validate(); err != nil {
+			return fmt.Errorf("user %d: %w", i, err)
+		}
+	}
+
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		var changes []events.Event
+		for i, user := range users {
+			created, err := s.create(ctx, repo, log, user)
+			if err != nil {
+				return nil, fmt.Errorf("user %d: %w", i, err)
+			}
+			changes = append(changes, s.changed(ctx, events.TypeUserCreated, created)...)
+		}
+		return changes, nil
+	})
+	if err != nil {
 		return err
 	}
 
-	_, err := s.db.Exec(context.Background(), "INSERT INTO users (name, email) VALUES ($1, $2)", user.Name, user.Email)
+	if s.emailIndex != nil {
+		for _, user := range users {
+			s.cacheResult(s.emailIndex.Delete(context.Background(), user.Email))
+		}
+	}
+	return nil
+}
+
+// ImportUsers adds a batch of users in one transaction, skipping those whose email
+// is already taken, by an existing user or one earlier in the batch. It returns how
+// many users were imported and how many skipped. An invalid user fails the batch
+// before anything is saved.
+func (s *UserService) ImportUsers(ctx context.Context, users []models.User) (imported, skipped int, err error) {
+	users = append([]models.User(nil), users...)
+	for i := range users {
+		users[i].Sanitize()
+		if err := users[i].Validate(); err != nil {
+			return 0, 0, fmt.Errorf("user %d: %w", i, err)
+		}
+	}
+
+	// An email held only by a deleted user is not found up front, and inserting it
+	// aborts the transaction, so each such user is dropped and the batch retried
+	for {
+		var created []models.User
+		duplicate := -1
+		err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+			created = created[:0]
+			var changes []events.Event
+			for i, user := range users {
+				if _, err := repo.GetUserByEmail(ctx, user.Email); err == nil {
+					continue
+				} else if !errors.Is(err, repository.ErrNotFound) {
+					return nil, fmt.Errorf("user %d: %w", i, err)
+				}
+				stored, err := s.create(ctx, repo, log, user)
+				if errors.Is(err, repository.ErrDuplicateEmail) {
+					if s.txm == nil {
+						// Without a transaction the failed insert aborted nothing
+						continue
+					}
+					duplicate = i
+				}
+				if err != nil {
+					return nil, fmt.Errorf("user %d: %w", i, err)
+				}
+				created = append(created, user)
+				changes = append(changes, s.changed(ctx, events.TypeUserCreated, stored)...)
+			}
+			return changes, nil
+		})
+		if duplicate >= 0 {
+			users = slices.Delete(users, duplicate, duplicate+1)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return 0, skipped, err
+		}
+
+		if s.emailIndex != nil {
+			for _, user := range created {
+				s.cacheResult(s.emailIndex.Delete(context.Background(), user.Email))
+			}
+		}
+		return len(created), skipped + len(users) - len(created), nil
+	}
+}
+
+// create stores user and audits it. It returns the user as read back with its
+// assigned ID, or nil when the service does not track changes.
+func (s *UserService) create(ctx context.Context, repo repository.UserRepository, log audit.Store, user models.User) (*models.User, error) {
+	if err := repo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	if !s.tracked() {
+		return nil, nil
+	}
+	created, err := repo.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &created, record(ctx, log, audit.ActionCreate, created.ID, nil, &created)
+}
+
+// UpdateUser replaces the name, email and profile fields of an existing user and
+// returns it as stored, read within the write
+func (s *UserService) UpdateUser(ctx context.Context, user models.User) (models.User, error) {
+	user.Sanitize()
+	if err := user.Validate(); err != nil {
+		return models.User{}, err
+	}
+
+	var after models.User
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		before, err := s.snapshot(ctx, repo, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.Update(ctx, user); err != nil {
+			return nil, err
+		}
+		if after, err = repo.GetUser(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		return s.changed(ctx, events.TypeUserUpdated, &after), record(ctx, log, audit.ActionUpdate, user.ID, before, &after)
+	})
+	s.invalidate(user.ID)
+	if err != nil {
+		return models.User{}, err
+	}
+	return after, nil
+}
+
+// PatchUser changes the fields patch sets on an existing user, leaving the others
+// alone, and returns it as stored, read within the write. The user they make is
+// sanitized and validated as a whole, as UpdateUser's is.
+func (s *UserService) PatchUser(ctx context.Context, id int, patch models.UserPatch) (models.User, error) {
+	var after models.User
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		before, err := repo.GetUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		merged := patch.Apply(before)
+		merged.Sanitize()
+		if err := merged.Validate(); err != nil {
+			return nil, err
+		}
+
+		// Store the fields as sanitized
+		sanitized := models.UserPatch{}
+		if patch.Name != nil {
+			sanitized.Name = &merged.Name
+		}
+		if patch.Email != nil {
+			sanitized.Email = &merged.Email
+		}
+		if patch.AvatarURL != nil {
+			sanitized.AvatarURL = &merged.AvatarURL
+		}
+		if patch.DisplayName != nil {
+			sanitized.DisplayName = &merged.DisplayName
+		}
+		if err := repo.Patch(ctx, id, sanitized); err != nil {
+			return nil, err
+		}
+		if after, err = repo.GetUser(ctx, id); err != nil {
+			return nil, err
+		}
+		return s.changed(ctx, events.TypeUserUpdated, &after), record(ctx, log, audit.ActionUpdate, id, &before, &after)
+	})
+	s.invalidate(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	return after, nil
+}
+
+// DeleteUser soft-deletes a user by ID. The user disappears from every lookup
+// but is kept for auditing and can be restored.
+func (s *UserService) DeleteUser(ctx context.Context, id int) error {
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		before, err := s.snapshot(ctx, repo, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.Delete(ctx, id); err != nil {
+			return nil, err
+		}
+		return s.changed(ctx, events.TypeUserDeleted, before), record(ctx, log, audit.ActionDelete, id, before, nil)
+	})
+	s.invalidate(id)
+	return err
+}
+
+// RestoreUser undeletes a soft-deleted user and returns it as stored, read within
+// the write
+func (s *UserService) RestoreUser(ctx context.Context, id int) (models.User, error) {
+	var after models.User
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		if err := repo.Restore(ctx, id); err != nil {
+			return nil, err
+		}
+		var err error
+		if after, err = repo.GetUser(ctx, id); err != nil {
+			return nil, err
+		}
+		return s.changed(ctx, events.TypeUserRestored, &after), record(ctx, log, audit.ActionRestore, id, nil, &after)
+	})
+	s.invalidate(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	return after, nil
+}
+
+// DisableUser blocks a user from signing in without deleting it and returns it as
+// stored. Disabling a disabled user succeeds.
+func (s *UserService) DisableUser(ctx context.Context, id int) (models.User, error) {
+	return s.setStatus(ctx, id, models.StatusDisabled, audit.ActionDisable)
+}
+
+// EnableUser reactivates a disabled user and returns it as stored. Enabling an
+// active user succeeds.
+func (s *UserService) EnableUser(ctx context.Context, id int) (models.User, error) {
+	return s.setStatus(ctx, id, models.StatusActive, audit.ActionEnable)
+}
+
+// setStatus sets the status of user id, returning the user read within the write
+func (s *UserService) setStatus(ctx context.Context, id int, status, action string) (models.User, error) {
+	var after models.User
+	err := s.mutate(ctx, func(repo repository.UserRepository, log audit.Store) ([]events.Event, error) {
+		before, err := s.snapshot(ctx, repo, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.SetStatus(ctx, id, status); err != nil {
+			return nil, err
+		}
+		if after, err = repo.GetUser(ctx, id); err != nil {
+			return nil, err
+		}
+		return s.changed(ctx, events.TypeUserUpdated, &after), record(ctx, log, action, id, before, &after)
+	})
+	s.invalidate(id)
+	if err != nil {
+		return models.User{}, err
+	}
+	return after, nil
+}
+
+// AuditLog returns a page of the audit log, newest first
+func (s *UserService) AuditLog(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	if s.audit == nil {
+		return nil, ErrAuditDisabled
+	}
+	return s.audit.List(ctx, filter)
+}
+
+// mutate runs fn with the repository and audit log, both bound to one transaction
+// when the service has a transaction manager. The audit log is nil when auditing is off.
+// The events fn returns are queued in the outbox within the same transaction, or
+// published once it commits when the service has no outbox.
+func (s *UserService) mutate(ctx context.Context, fn func(repo repository.UserRepository, log audit.Store) ([]events.Event, error)) error {
+	var changes []events.Event
+	run := func(repo repository.UserRepository, log audit.Store, queue outbox.Store) error {
+		var err error
+		if changes, err = fn(repo, log); err != nil {
+			return err
+		}
+		if queue == nil {
+			return nil
+		}
+		for _, event := range changes {
+			if err := queue.Add(ctx, event); err != nil {
+				return fmt.Errorf("failed to queue %s event: %w", event.Type, err)
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if s.txm == nil {
+		err = run(s.repo, s.audit, s.outbox)
+	} else {
+		err = s.txm.WithTx(ctx, func(tx database.DBTX) error {
+			log, queue := s.audit, s.outbox
+			if log != nil && s.txAudit != nil {
+				log = s.txAudit(tx)
+			}
+			if queue != nil && s.txOutbox != nil {
+				queue = s.txOutbox(tx)
+			}
+			return run(s.txRepo(tx), log, queue)
+		})
+	}
 	if err != nil {
 		return err
 	}
 
+	if s.outbox == nil {
+		for _, event := range changes {
+			s.publish(ctx, event)
+		}
+	}
+	return nil
+}
+
+// tracked reports whether mutations snapshot the users they change, for the audit log or events
+func (s *UserService) tracked() bool {
+	return s.audit != nil || s.events != nil || s.outbox != nil
+}
+
+// snapshot reads user id as part of a mutation. It reads nothing when the service
+// does not track changes.
+func (s *UserService) snapshot(ctx context.Context, repo repository.UserRepository, id int) (*models.User, error) {
+	if !s.tracked() {
+		return nil, nil
+	}
+	user, err := repo.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// changed returns the event announcing a change to user, or none when nobody listens
+func (s *UserService) changed(ctx context.Context, eventType string, user *models.User) []events.Event {
+	if (s.events == nil && s.outbox == nil) || user == nil {
+		return nil
+	}
+	return []events.Event{events.NewEvent(ctx, eventType, *user)}
+}
+
+// record writes an audit entry when auditing is on. Its error fails the mutation.
+func record(ctx context.Context, log audit.Store, action string, userID int, before, after *models.User) error {
+	if log == nil {
+		return nil
+	}
+	entry, err := audit.NewEntry(ctx, action, userID, before, after)
+	if err != nil {
+		return err
+	}
+	if err := log.Record(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
 	return nil
 }
+
+// publish announces a committed change. The change is already saved, so a failed
+// delivery is only logged; the publisher counts it in its metrics.
+func (s *UserService) publish(ctx context.Context, event events.Event) {
+	if s.events == nil {
+		return
+	}
+	// The event must go out even if the request is cancelled after the commit
+	if err := s.events.Publish(context.WithoutCancel(ctx), event); err != nil {
+		logging.FromContext(ctx).Warn("Failed to publish user event", "type", event.Type, "user_id", event.User.ID, "error", err)
+	}
+}
+
+// ReadinessChecks returns the checks that must pass before the service can take traffic
+func (s *UserService) ReadinessChecks() []health.Check {
+	return []health.Check{
+		{Name: "storage", Run: func(ctx context.Context) error {
+			_, err := s.repo.Count(ctx)
+			return err
+		}},
+	}
+}
+
+// WithTx runs fn with a repository bound to a single transaction, committing it
+// when fn returns nil and rolling it back otherwise. Without a transaction manager
+// fn runs directly against the service's repository.
+func (s *UserService) WithTx(ctx context.Context, fn func(repo repository.UserRepository) error) error {
+	if s.txm == nil {
+		return fn(s.repo)
+	}
+	return s.txm.WithTx(ctx, func(tx database.DBTX) error {
+		return fn(s.txRepo(tx))
+	})
+}
+
+// collapse runs fn once for all concurrent callers with the same key, sharing its
+// result and error. Callers that did not run fn are counted against query. fn runs
+// with the first caller's context, detached from its cancellation so that caller
+// going away does not fail the others, but keeping its deadline so the query does
+// not outlive the request that started it.
+func (s *UserService) collapse(ctx context.Context, query, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	executed := false
+	v, err, shared := s.queries.Do(key, func() (interface{}, error) {
+		executed = true
+		detached := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			detached, cancel = context.WithDeadline(detached, deadline)
+			defer cancel()
+		}
+		return fn(detached)
+	})
+	if shared && !executed {
+		s.metrics.RecordCollapsedQuery(query)
+	}
+	return v, err
+}
+
+// cached returns the user with the given ID from the cache
+func (s *UserService) cached(id int) (models.User, bool) {
+	user, ok, err := s.cache.Get(context.Background(), id)
+	s.cacheResult(err)
+	return user, ok
+}
+
+// userKey is the key lookups of user id are collapsed by
+func userKey(id int) string {
+	return "user:" + strconv.Itoa(id)
+}
+
+// store caches a user that was just read from the database by a read that began
+// when s.invalidations was since. A write invalidating users in between may have
+// changed it, so the user is not cached then, and it is dropped again when the
+// write lands between the check and the set.
+func (s *UserService) store(user models.User, since uint64) {
+	if s.cache == nil || s.invalidations.Load() != since {
+		return
+	}
+	s.cacheResult(s.cache.Set(context.Background(), user.ID, user))
+	s.cacheResult(s.emailIndex.Set(context.Background(), user.Email, user.ID))
+	if s.invalidations.Load() != since {
+		s.cacheResult(s.cache.Delete(context.Background(), user.ID))
+	}
+}
+
+// invalidate drops any cached copy of the user with the given ID, and stops later
+// lookups from joining one already running, which may return the user as it was.
+// Email index entries pointing at it are detected as stale on lookup.
+func (s *UserService) invalidate(id int) {
+	s.invalidations.Add(1)
+	s.queries.Forget(userKey(id))
+	if s.cache == nil {
+		return
+	}
+	s.cacheResult(s.cache.Delete(context.Background(), id))
+}
+
+// cacheResult records the outcome of a cache operation. Failures never fail the
+// request; they are counted and logged once until the cache recovers.
+func (s *UserService) cacheResult(err error) {
+	if err == nil {
+		if s.cacheDown.CompareAndSwap(true, false) {
+			slog.Info("User cache recovered")
+		}
+		return
+	}
+
+	s.metrics.RecordCacheError()
+	if !s.cacheDown.Swap(true) {
+		slog.Warn("User cache unavailable, falling back to database", "error", err)
+	}
+}
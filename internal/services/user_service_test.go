@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
@@ -10,15 +11,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
 	"user-service/internal/metrics"
 	"user-service/internal/models"
+	"user-service/internal/repository"
 )
 
 func TestUserService(t *testing.T) {
 	dbMock := &mocks.MockDBTX{}
 	reg := prometheus.NewRegistry()
 	metricsCollector := metrics.New(reg, reg)
-	userService := NewUserService(dbMock, metricsCollector)
+	userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
 
 	t.Run("get user", func(t *testing.T) {
 		row := &mocks.MockRow{}
@@ -29,9 +32,9 @@ func TestUserService(t *testing.T) {
 			*arg[2].(*string) = "john@example.com"
 		})
 
-		dbMock.On("QueryRow", context.Background(), "SELECT id, name, email FROM users WHERE id = $1", 1).Return(row)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(row)
 
-		user, err := userService.GetUser(1)
+		user, err := userService.GetUser(context.Background(), 1)
 		assert.NoError(t, err)
 		assert.Equal(t, 1, user.ID)
 		dbMock.AssertExpectations(t)
@@ -40,24 +43,67 @@ func TestUserService(t *testing.T) {
 	t.Run("get non-existent user", func(t *testing.T) {
 		row := &mocks.MockRow{}
 		row.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
-		dbMock.On("QueryRow", context.Background(), "SELECT id, name, email FROM users WHERE id = $1", 100).Return(row)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 100).Return(row)
 
-		_, err := userService.GetUser(100)
+		_, err := userService.GetUser(context.Background(), 100)
 		assert.Error(t, err)
 		dbMock.AssertExpectations(t)
 	})
 
+	t.Run("get user by subject", func(t *testing.T) {
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			arg := args.Get(0).([]interface{})
+			*arg[0].(*int) = 1
+			*arg[2].(*string) = "john@example.com"
+		})
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserBySubject, "auth0|john").Return(row)
+
+		user, err := userService.GetUserBySubject(context.Background(), "auth0|john")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.ID)
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("get user by unlinked subject", func(t *testing.T) {
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserBySubject, "auth0|nobody").Return(row)
+
+		_, err := userService.GetUserBySubject(context.Background(), "auth0|nobody")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		dbMock.AssertExpectations(t)
+	})
+
+	t.Run("link subject", func(t *testing.T) {
+		dbMock.On("Exec", mock.Anything, queries.Default.LinkUserSubject, "auth0|john", 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		dbMock.On("Exec", mock.Anything, queries.Default.LinkUserSubject, "auth0|john", 2).Return(pgconn.CommandTag{}, &pgconn.PgError{Code: "23505"})
+		dbMock.On("Exec", mock.Anything, queries.Default.LinkUserSubject, "auth0|john", 100).Return(pgconn.CommandTag("UPDATE 0"), nil)
+
+		// The linked user is read back with the get user expectation above
+
+		linked, err := userService.LinkSubject(context.Background(), 1, "auth0|john")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, linked.ID)
+		_, err = userService.LinkSubject(context.Background(), 2, "auth0|john")
+		assert.ErrorIs(t, err, repository.ErrSubjectTaken)
+		_, err = userService.LinkSubject(context.Background(), 100, "auth0|john")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		dbMock.AssertExpectations(t)
+	})
+
 	t.Run("list users", func(t *testing.T) {
 		rows := &mocks.MockRows{}
 		rows.On("Close").Return()
 		rows.On("Next").Return(true).Once()
 		rows.On("Next").Return(true).Once()
 		rows.On("Next").Return(false).Once()
+		rows.On("Err").Return(nil)
 		rows.On("Scan", mock.Anything).Return(nil).Times(2)
 
-		dbMock.On("Query", context.Background(), "SELECT id, name, email FROM users").Return(rows, nil)
+		dbMock.On("Query", context.Background(), queries.Default.ListUsers).Return(rows, nil)
 
-		users, err := userService.ListUsers()
+		users, err := userService.ListUsers(context.Background(), models.UserFilter{})
 		assert.NoError(t, err)
 		assert.Len(t, users, 2)
 		dbMock.AssertExpectations(t)
@@ -69,90 +115,305 @@ func TestUserService(t *testing.T) {
 			arg := args.Get(0).([]interface{})
 			*arg[0].(*int) = 5
 		})
-		dbMock.On("QueryRow", context.Background(), "SELECT COUNT(*) FROM users").Return(row)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row)
 
-		count, err := userService.GetUsersCount()
+		count, err := userService.GetUsersCount(context.Background())
 		assert.NoError(t, err)
 		assert.Equal(t, 5, count)
 		dbMock.AssertExpectations(t)
 	})
 
 	t.Run("add user", func(t *testing.T) {
-		dbMock.On("Exec", context.Background(), "INSERT INTO users (name, email) VALUES ($1, $2)", "Test User", "test@user.com").Return(pgconn.CommandTag{}, nil)
+		dbMock.On("Exec", context.Background(), queries.Default.InsertUser, "Test User", "test@user.com", models.RoleUser, "", "").Return(pgconn.CommandTag{}, nil)
+		dbMock.On("QueryRow", context.Background(), queries.Default.GetUserByEmail, "test@user.com").Return(userRow(models.User{ID: 5, Name: "Test User", Email: "test@user.com"}))
 
 		user := models.User{Name: "Test User", Email: "test@user.com"}
-		err := userService.AddUser(user)
+		created, err := userService.AddUser(context.Background(), user)
 		assert.NoError(t, err)
+		assert.Equal(t, 5, created.ID)
 		dbMock.AssertExpectations(t)
 	})
 
 	t.Run("add user validation error", func(t *testing.T) {
 		// Test with invalid user data - create separate service to avoid mock conflicts
 		dbMockValidation := &mocks.MockDBTX{}
-		userServiceValidation := NewUserService(dbMockValidation, metricsCollector)
+		userServiceValidation := NewUserService(repository.NewPgxUserRepository(dbMockValidation, queries.DefaultUsersTable), metricsCollector)
 		user := models.User{Name: "", Email: "invalid-email"} // Empty name and invalid email
-		err := userServiceValidation.AddUser(user)
+		_, err := userServiceValidation.AddUser(context.Background(), user)
 		assert.Error(t, err)
 		// Should not call database since validation fails
 	})
 
 	t.Run("add user database error", func(t *testing.T) {
 		dbMockAddError := &mocks.MockDBTX{}
-		userServiceAddError := NewUserService(dbMockAddError, metricsCollector)
-		dbMockAddError.On("Exec", context.Background(), "INSERT INTO users (name, email) VALUES ($1, $2)", "Test User", "test@example.com").Return(pgconn.CommandTag{}, assert.AnError)
+		userServiceAddError := NewUserService(repository.NewPgxUserRepository(dbMockAddError, queries.DefaultUsersTable), metricsCollector)
+		dbMockAddError.On("Exec", context.Background(), queries.Default.InsertUser, "Test User", "test@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag{}, assert.AnError)
 
 		user := models.User{Name: "Test User", Email: "test@example.com"}
-		err := userServiceAddError.AddUser(user)
+		_, err := userServiceAddError.AddUser(context.Background(), user)
 		assert.Error(t, err)
 		dbMockAddError.AssertExpectations(t)
 	})
 
 	t.Run("get user database error", func(t *testing.T) {
 		dbMockGetError := &mocks.MockDBTX{}
-		userServiceGetError := NewUserService(dbMockGetError, metricsCollector)
+		userServiceGetError := NewUserService(repository.NewPgxUserRepository(dbMockGetError, queries.DefaultUsersTable), metricsCollector)
 		row := &mocks.MockRow{}
 		row.On("Scan", mock.Anything).Return(assert.AnError)
-		dbMockGetError.On("QueryRow", context.Background(), "SELECT id, name, email FROM users WHERE id = $1", 999).Return(row)
+		dbMockGetError.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 999).Return(row)
 
-		_, err := userServiceGetError.GetUser(999)
+		_, err := userServiceGetError.GetUser(context.Background(), 999)
 		assert.Error(t, err)
 		dbMockGetError.AssertExpectations(t)
 	})
 
 	t.Run("list users database error", func(t *testing.T) {
 		dbMock2 := &mocks.MockDBTX{}
-		userService2 := NewUserService(dbMock2, metricsCollector)
-		dbMock2.On("Query", context.Background(), "SELECT id, name, email FROM users").Return(nil, assert.AnError)
+		userService2 := NewUserService(repository.NewPgxUserRepository(dbMock2, queries.DefaultUsersTable), metricsCollector)
+		dbMock2.On("Query", context.Background(), queries.Default.ListUsers).Return(nil, assert.AnError)
 
-		_, err := userService2.ListUsers()
+		_, err := userService2.ListUsers(context.Background(), models.UserFilter{})
 		assert.Error(t, err)
 		dbMock2.AssertExpectations(t)
 	})
 
 	t.Run("list users scan error", func(t *testing.T) {
 		dbMock3 := &mocks.MockDBTX{}
-		userService3 := NewUserService(dbMock3, metricsCollector)
+		userService3 := NewUserService(repository.NewPgxUserRepository(dbMock3, queries.DefaultUsersTable), metricsCollector)
 		rows := &mocks.MockRows{}
 		rows.On("Close").Return()
 		rows.On("Next").Return(true).Once()
 		rows.On("Scan", mock.Anything).Return(assert.AnError)
 
-		dbMock3.On("Query", context.Background(), "SELECT id, name, email FROM users").Return(rows, nil)
+		dbMock3.On("Query", context.Background(), queries.Default.ListUsers).Return(rows, nil)
 
-		_, err := userService3.ListUsers()
+		_, err := userService3.ListUsers(context.Background(), models.UserFilter{})
 		assert.Error(t, err)
 		dbMock3.AssertExpectations(t)
 	})
 
+	t.Run("list users iteration error", func(t *testing.T) {
+		dbMock := &mocks.MockDBTX{}
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metricsCollector)
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(true).Once()
+		rows.On("Next").Return(false).Once()
+		rows.On("Scan", mock.Anything).Return(nil)
+		// The connection drops after the first row
+		rows.On("Err").Return(assert.AnError)
+
+		dbMock.On("Query", context.Background(), queries.Default.ListUsers).Return(rows, nil)
+
+		users, err := userService.ListUsers(context.Background(), models.UserFilter{})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Nil(t, users)
+		rows.AssertExpectations(t)
+	})
+
 	t.Run("get users count database error", func(t *testing.T) {
 		dbMock4 := &mocks.MockDBTX{}
-		userService4 := NewUserService(dbMock4, metricsCollector)
+		userService4 := NewUserService(repository.NewPgxUserRepository(dbMock4, queries.DefaultUsersTable), metricsCollector)
 		row := &mocks.MockRow{}
 		row.On("Scan", mock.Anything).Return(assert.AnError)
-		dbMock4.On("QueryRow", context.Background(), "SELECT COUNT(*) FROM users").Return(row)
+		dbMock4.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row)
 
-		_, err := userService4.GetUsersCount()
+		_, err := userService4.GetUsersCount(context.Background())
 		assert.Error(t, err)
 		dbMock4.AssertExpectations(t)
 	})
+
+	t.Run("get user by email not found", func(t *testing.T) {
+		dbMock5 := &mocks.MockDBTX{}
+		userService5 := NewUserService(repository.NewPgxUserRepository(dbMock5, queries.DefaultUsersTable), metricsCollector)
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		dbMock5.On("QueryRow", context.Background(), queries.Default.GetUserByEmail, "nobody@example.com").Return(row)
+
+		_, err := userService5.GetUserByEmail(context.Background(), "nobody@example.com")
+		assert.EqualError(t, err, "user not found")
+		dbMock5.AssertExpectations(t)
+	})
+
+	t.Run("update user not found", func(t *testing.T) {
+		dbMock6 := &mocks.MockDBTX{}
+		userService6 := NewUserService(repository.NewPgxUserRepository(dbMock6, queries.DefaultUsersTable), metricsCollector)
+		dbMock6.On("Exec", context.Background(), queries.Default.UpdateUser, "Test User", "test@example.com", "", "", 999).Return(pgconn.CommandTag("UPDATE 0"), nil)
+
+		_, err := userService6.UpdateUser(context.Background(), models.User{ID: 999, Name: "Test User", Email: "test@example.com"})
+		assert.EqualError(t, err, "user not found")
+		dbMock6.AssertExpectations(t)
+	})
+
+	t.Run("update user validation error", func(t *testing.T) {
+		dbMock7 := &mocks.MockDBTX{}
+		userService7 := NewUserService(repository.NewPgxUserRepository(dbMock7, queries.DefaultUsersTable), metricsCollector)
+
+		_, err := userService7.UpdateUser(context.Background(), models.User{ID: 1, Name: "", Email: "invalid-email"})
+		assert.Error(t, err)
+		dbMock7.AssertNotCalled(t, "Exec")
+	})
+
+	t.Run("delete user not found", func(t *testing.T) {
+		dbMock8 := &mocks.MockDBTX{}
+		userService8 := NewUserService(repository.NewPgxUserRepository(dbMock8, queries.DefaultUsersTable), metricsCollector)
+		dbMock8.On("Exec", context.Background(), queries.Default.DeleteUser, 999).Return(pgconn.CommandTag("DELETE 0"), nil)
+
+		err := userService8.DeleteUser(context.Background(), 999)
+		assert.EqualError(t, err, "user not found")
+		dbMock8.AssertExpectations(t)
+	})
+}
+
+func TestUserServiceInMemoryRepository(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	userService := NewUserService(repository.NewInMemoryRepository(), metrics.New(reg, reg), WithCache(10, time.Minute))
+
+	created, err := userService.AddUser(context.Background(), models.User{Name: "John Doe", Email: "john@example.com"})
+	assert.NoError(t, err)
+
+	user, err := userService.GetUserByEmail(context.Background(), "john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, created, user)
+
+	updated, err := userService.UpdateUser(context.Background(), models.User{ID: user.ID, Name: "John Updated", Email: "john@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "John Updated", updated.Name)
+	user, err = userService.GetUser(context.Background(), user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "John Updated", user.Name)
+
+	count, err := userService.GetUsersCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, userService.DeleteUser(context.Background(), user.ID))
+	_, err = userService.GetUser(context.Background(), user.ID)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.ErrorIs(t, userService.DeleteUser(context.Background(), user.ID), repository.ErrNotFound)
+}
+
+func TestUserServiceSoftDelete(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	userService := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metrics.New(reg, reg), WithCache(10, time.Minute))
+
+	// Warm the cache so the delete has to invalidate it
+	_, err := userService.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.NoError(t, userService.DeleteUser(context.Background(), 1))
+
+	_, err = userService.GetUser(context.Background(), 1)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	users, err := userService.ListAllUsers(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+	users, err = userService.ListAllUsers(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Len(t, users, 4)
+
+	assert.NoError(t, userService.RefreshUserGauges(context.Background()))
+	assert.Equal(t, 3.0, gaugeValue(t, reg, "users_total", models.StatusActive))
+	assert.Equal(t, 1.0, gaugeValue(t, reg, "deleted_users_total"))
+
+	restored, err := userService.RestoreUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	_, err = userService.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	_, err = userService.RestoreUser(context.Background(), 1)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestUserServiceStatus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	userService := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metrics.New(reg, reg), WithCache(10, time.Minute))
+
+	// Warm the cache so the status change has to invalidate it
+	user, err := userService.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusActive, user.Status)
+
+	disabled, err := userService.DisableUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusDisabled, disabled.Status)
+	user, err = userService.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusDisabled, user.Status)
+
+	// Disabling again is a no-op
+	_, err = userService.DisableUser(context.Background(), 1)
+	assert.NoError(t, err)
+	again, err := userService.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, user, again)
+
+	listed, err := userService.ListUsers(context.Background(), models.UserFilter{Status: models.StatusDisabled})
+	assert.NoError(t, err)
+	assert.Len(t, listed, 1)
+
+	assert.NoError(t, userService.RefreshUserGauges(context.Background()))
+	assert.Equal(t, 3.0, gaugeValue(t, reg, "users_total", models.StatusActive))
+	assert.Equal(t, 1.0, gaugeValue(t, reg, "users_total", models.StatusDisabled))
+
+	_, err = userService.EnableUser(context.Background(), 1)
+	assert.NoError(t, err)
+	user, err = userService.GetUser(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusActive, user.Status)
+
+	assert.NoError(t, userService.RefreshUserGauges(context.Background()))
+	assert.Equal(t, 4.0, gaugeValue(t, reg, "users_total", models.StatusActive))
+	assert.Equal(t, 0.0, gaugeValue(t, reg, "users_total", models.StatusDisabled))
+
+	_, err = userService.DisableUser(context.Background(), 99)
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+// gaugeValue returns the value of the gauge name, or of its series whose status label is status
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string, status ...string) float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if len(status) == 0 {
+				return metric.GetGauge().GetValue()
+			}
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "status" && label.GetValue() == status[0] {
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestUserServiceExportUsersAborted(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	userService := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metrics.New(reg, reg))
+
+	// A complete export is not counted
+	assert.NoError(t, userService.ExportUsers(context.Background(), func(models.User) error { return nil }))
+	assert.Equal(t, 0.0, counterValue(t, reg, "exports_aborted_total"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exported := 0
+	err := userService.ExportUsers(ctx, func(models.User) error {
+		exported++
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, exported)
+	assert.Equal(t, 1.0, counterValue(t, reg, "exports_aborted_total"))
+
+	// Neither is an export fn ended itself
+	assert.Error(t, userService.ExportUsers(context.Background(), func(models.User) error { return assert.AnError }))
+	assert.Equal(t, 1.0, counterValue(t, reg, "exports_aborted_total"))
 }
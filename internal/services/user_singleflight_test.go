@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+)
+
+// runConcurrently calls fn from n goroutines and releases the database once they are all waiting
+func runConcurrently(n int, release chan time.Time, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestUserServiceSingleflight(t *testing.T) {
+	const callers = 50
+	john := models.User{ID: 1, Name: "John Doe", Email: "john@example.com"}
+
+	t.Run("concurrent lookups of the same user share one query", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		release := make(chan time.Time)
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).WaitUntil(release)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+
+		users := make([]models.User, callers)
+		errs := make([]error, callers)
+		runConcurrently(callers, release, func(i int) {
+			users[i], errs[i] = userService.GetUser(context.Background(), 1)
+		})
+
+		for i := 0; i < callers; i++ {
+			assert.NoError(t, errs[i])
+			assert.Equal(t, john, users[i])
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+		assert.Equal(t, float64(callers-1), counterValue(t, reg, "collapsed_queries_total"))
+	})
+
+	t.Run("lookups of different users are not shared", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		release := make(chan time.Time)
+		jane := models.User{ID: 2, Name: "Jane Smith", Email: "jane@example.com"}
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john)).WaitUntil(release)
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 2).Return(userRow(jane)).WaitUntil(release)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+
+		users := make([]models.User, callers)
+		errs := make([]error, callers)
+		runConcurrently(callers, release, func(i int) {
+			users[i], errs[i] = userService.GetUser(context.Background(), i%2+1)
+		})
+
+		for i := 0; i < callers; i++ {
+			assert.NoError(t, errs[i])
+			assert.Equal(t, i%2+1, users[i].ID)
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 2)
+	})
+
+	t.Run("errors propagate to every waiter", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		release := make(chan time.Time)
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 100).Return(row).WaitUntil(release)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+
+		errs := make([]error, callers)
+		runConcurrently(callers, release, func(i int) {
+			_, errs[i] = userService.GetUser(context.Background(), 100)
+		})
+
+		for i := 0; i < callers; i++ {
+			assert.ErrorIs(t, errs[i], repository.ErrNotFound)
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+	})
+
+	t.Run("concurrent counts share one query", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		release := make(chan time.Time)
+		row := &mocks.MockRow{}
+		row.On("Scan", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			*args.Get(0).([]interface{})[0].(*int) = 4
+		})
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.CountUsers).Return(row).WaitUntil(release)
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+
+		counts := make([]int, callers)
+		runConcurrently(callers, release, func(i int) {
+			counts[i], _ = userService.GetUsersCount(context.Background())
+		})
+
+		for i := 0; i < callers; i++ {
+			assert.Equal(t, 4, counts[i])
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 1)
+		assert.Equal(t, float64(callers-1), counterValue(t, reg, "collapsed_queries_total"))
+	})
+
+	t.Run("sequential lookups are not shared", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		dbMock := &mocks.MockDBTX{}
+		dbMock.On("QueryRow", mock.Anything, queries.Default.GetUserByID, 1).Return(userRow(john))
+		userService := NewUserService(repository.NewPgxUserRepository(dbMock, queries.DefaultUsersTable), metrics.New(reg, reg))
+
+		for i := 0; i < 3; i++ {
+			_, err := userService.GetUser(context.Background(), 1)
+			assert.NoError(t, err)
+		}
+		dbMock.AssertNumberOfCalls(t, "QueryRow", 3)
+		assert.Equal(t, 0.0, counterValue(t, reg, "collapsed_queries_total"))
+	})
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database"
+	"user-service/internal/database/mocks"
+	"user-service/internal/database/queries"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/repository"
+)
+
+// newTxRepo creates the repository of a transaction over the default users table
+func newTxRepo(tx database.DBTX) repository.UserRepository {
+	return repository.NewPgxUserRepository(tx, queries.DefaultUsersTable)
+}
+
+// newTxService returns a service over a mock connection whose transactions all use tx
+func newTxService() (*UserService, *mocks.MockBeginner, *mocks.MockTx) {
+	tx := &mocks.MockTx{}
+	db := &mocks.MockBeginner{}
+	db.On("Begin", context.Background()).Return(tx, nil)
+	tx.On("Prepare", mock.Anything, queries.Default.GetUserByIDStatement, queries.Default.GetUserByID).Return(&pgconn.StatementDescription{}, nil).Maybe()
+
+	reg := prometheus.NewRegistry()
+	s := NewUserService(repository.NewPgxUserRepository(&mocks.MockDBTX{}, queries.DefaultUsersTable), metrics.New(reg, reg),
+		WithTxManager(database.NewTxManager(db), newTxRepo))
+	return s, db, tx
+}
+
+func TestUserServiceTransactions(t *testing.T) {
+	ctx := context.Background()
+	users := []models.User{
+		{Name: "Ann", Email: "ann@example.com"},
+		{Name: "Bob", Email: "bob@example.com", Role: models.RoleAdmin},
+	}
+
+	t.Run("add users commits on success", func(t *testing.T) {
+		s, _, tx := newTxService()
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Ann", "ann@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Bob", "bob@example.com", models.RoleAdmin, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("Commit", ctx).Return(nil)
+
+		assert.NoError(t, s.AddUsers(context.Background(), users))
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Rollback", ctx)
+	})
+
+	t.Run("add users rolls back when an insert fails", func(t *testing.T) {
+		s, _, tx := newTxService()
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Ann", "ann@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Bob", "bob@example.com", models.RoleAdmin, "", "").Return(pgconn.CommandTag{}, assert.AnError)
+		tx.On("Rollback", ctx).Return(nil)
+
+		err := s.AddUsers(context.Background(), users)
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Contains(t, err.Error(), "user 1")
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+
+	t.Run("add users validates before starting a transaction", func(t *testing.T) {
+		s, db, _ := newTxService()
+
+		err := s.AddUsers(context.Background(), []models.User{users[0], {Name: "", Email: "nobody"}})
+		var validationErrs models.ValidationErrors
+		assert.ErrorAs(t, err, &validationErrs)
+		db.AssertNotCalled(t, "Begin", ctx)
+	})
+
+	t.Run("update user commits on success", func(t *testing.T) {
+		s, _, tx := newTxService()
+		tx.On("Exec", ctx, queries.Default.UpdateUser, "Ann", "ann@example.com", "", "", 1).Return(pgconn.CommandTag("UPDATE 1"), nil)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByIDStatement, 1).Return(userRow(models.User{ID: 1, Name: "Ann", Email: "ann@example.com"}))
+		tx.On("Commit", ctx).Return(nil)
+
+		user, err := s.UpdateUser(context.Background(), models.User{ID: 1, Name: "Ann", Email: "ann@example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Ann", user.Name)
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Rollback", ctx)
+	})
+
+	t.Run("update user rolls back when the user does not exist", func(t *testing.T) {
+		s, _, tx := newTxService()
+		tx.On("Exec", ctx, queries.Default.UpdateUser, "Ann", "ann@example.com", "", "", 999).Return(pgconn.CommandTag("UPDATE 0"), nil)
+		tx.On("Rollback", ctx).Return(nil)
+
+		_, err := s.UpdateUser(context.Background(), models.User{ID: 999, Name: "Ann", Email: "ann@example.com"})
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+
+	t.Run("with tx rolls back when fn panics", func(t *testing.T) {
+		s, _, tx := newTxService()
+		tx.On("Rollback", ctx).Return(nil)
+
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = s.WithTx(ctx, func(repository.UserRepository) error { panic("boom") })
+		})
+		tx.AssertExpectations(t)
+		tx.AssertNotCalled(t, "Commit", ctx)
+	})
+}
+
+func TestUserServiceAddUsersInMemory(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := NewUserService(repository.NewInMemoryRepository(), metrics.New(reg, reg))
+
+	assert.NoError(t, s.AddUsers(context.Background(), []models.User{
+		{Name: "Ann", Email: "ann@example.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+	}))
+
+	count, err := s.GetUsersCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestUserServiceImportUsers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("skips emails already taken", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		s := NewUserService(repository.NewInMemoryRepository(), metrics.New(reg, reg))
+		assert.NoError(t, s.AddUsers(ctx, []models.User{
+			{Name: "Ann", Email: "ann@example.com"},
+			{Name: "Dan", Email: "dan@example.com"},
+		}))
+		dan, err := s.GetUserByEmail(context.Background(), "dan@example.com")
+		assert.NoError(t, err)
+		assert.NoError(t, s.DeleteUser(ctx, dan.ID))
+
+		imported, skipped, err := s.ImportUsers(ctx, []models.User{
+			{Name: "Ann Again", Email: "ann@example.com"},
+			{Name: "Bob", Email: " bob@example.com "},
+			{Name: "Bob Again", Email: "bob@example.com"},
+			{Name: "Dan Again", Email: "dan@example.com"},
+			{Name: "Cat", Email: "cat@example.com"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, imported)
+		assert.Equal(t, 3, skipped)
+
+		bob, err := s.GetUserByEmail(context.Background(), "bob@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Bob", bob.Name)
+	})
+
+	t.Run("retries a batch without a user whose insert conflicts", func(t *testing.T) {
+		s, db, tx := newTxService()
+		missing := &mocks.MockRow{}
+		missing.On("Scan", mock.Anything).Return(pgx.ErrNoRows)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByEmail, "ann@example.com").Return(missing)
+		tx.On("QueryRow", ctx, queries.Default.GetUserByEmail, "dan@example.com").Return(missing)
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Ann", "ann@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag("INSERT 0 1"), nil)
+		tx.On("Exec", ctx, queries.Default.InsertUser, "Dan", "dan@example.com", models.RoleUser, "", "").Return(pgconn.CommandTag{}, &pgconn.PgError{Code: "23505"})
+		tx.On("Rollback", ctx).Return(nil).Once()
+		tx.On("Commit", ctx).Return(nil).Once()
+
+		imported, skipped, err := s.ImportUsers(ctx, []models.User{
+			{Name: "Ann", Email: "ann@example.com"},
+			{Name: "Dan", Email: "dan@example.com"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, imported)
+		assert.Equal(t, 1, skipped)
+		db.AssertNumberOfCalls(t, "Begin", 2)
+		tx.AssertExpectations(t)
+	})
+
+	t.Run("fails the batch when a user is invalid", func(t *testing.T) {
+		s, db, _ := newTxService()
+
+		_, _, err := s.ImportUsers(ctx, []models.User{{Name: "Ann", Email: "ann@example.com"}, {Name: "", Email: "nobody"}})
+		var validationErrs models.ValidationErrors
+		assert.ErrorAs(t, err, &validationErrs)
+		assert.Contains(t, err.Error(), "user 1")
+		db.AssertNotCalled(t, "Begin", ctx)
+	})
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+
+	"user-service/internal/webhooks"
+)
+
+// CreateWebhook validates and saves a new webhook subscription, enabled
+func (s *UserService) CreateWebhook(ctx context.Context, webhook webhooks.Webhook) (webhooks.Webhook, error) {
+	if s.webhooks == nil {
+		return webhooks.Webhook{}, ErrWebhooksDisabled
+	}
+	if err := webhook.Validate(); err != nil {
+		return webhooks.Webhook{}, err
+	}
+	webhook.Enabled = true
+	return s.webhooks.Create(ctx, webhook)
+}
+
+// ListWebhooks returns every webhook subscription
+func (s *UserService) ListWebhooks(ctx context.Context) ([]webhooks.Webhook, error) {
+	if s.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+	return s.webhooks.List(ctx)
+}
+
+// GetWebhook returns the webhook subscription with id
+func (s *UserService) GetWebhook(ctx context.Context, id int64) (webhooks.Webhook, error) {
+	if s.webhooks == nil {
+		return webhooks.Webhook{}, ErrWebhooksDisabled
+	}
+	return s.webhooks.Get(ctx, id)
+}
+
+// UpdateWebhook validates and saves a webhook subscription. An empty secret keeps
+// the current one. Saving resets the failure count, so it also re-enables a webhook
+// that was disabled after failing.
+func (s *UserService) UpdateWebhook(ctx context.Context, webhook webhooks.Webhook) (webhooks.Webhook, error) {
+	if s.webhooks == nil {
+		return webhooks.Webhook{}, ErrWebhooksDisabled
+	}
+	if webhook.Secret == "" {
+		existing, err := s.webhooks.Get(ctx, webhook.ID)
+		if err != nil {
+			return webhooks.Webhook{}, err
+		}
+		webhook.Secret = existing.Secret
+	}
+	if err := webhook.Validate(); err != nil {
+		return webhooks.Webhook{}, err
+	}
+	return s.webhooks.Update(ctx, webhook)
+}
+
+// DeleteWebhook removes a webhook subscription with its delivery history
+func (s *UserService) DeleteWebhook(ctx context.Context, id int64) error {
+	if s.webhooks == nil {
+		return ErrWebhooksDisabled
+	}
+	return s.webhooks.Delete(ctx, id)
+}
+
+// WebhookDeliveries returns a page of a webhook's deliveries, newest first
+func (s *UserService) WebhookDeliveries(ctx context.Context, id int64, filter webhooks.DeliveryFilter) ([]webhooks.Delivery, error) {
+	if s.webhooks == nil {
+		return nil, ErrWebhooksDisabled
+	}
+	if _, err := s.webhooks.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.webhooks.Deliveries(ctx, id, filter)
+}
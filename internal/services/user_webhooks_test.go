@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+	"user-service/internal/outbox"
+	"user-service/internal/repository"
+	"user-service/internal/webhooks"
+)
+
+func TestUserCreatedWebhook(t *testing.T) {
+	ctx := context.Background()
+	const secret = "s3cret"
+
+	type capture struct {
+		body      []byte
+		signature string
+	}
+	captured := make(chan capture, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		captured <- capture{body: body, signature: r.Header.Get(webhooks.SignatureHeader)}
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metricsCollector := metrics.New(reg, reg)
+	queue := outbox.NewMemoryStore()
+	hooks := webhooks.NewMemoryStore()
+	_, err := webhooks.Register(ctx, hooks, webhooks.Webhook{URL: server.URL, Secret: secret, EventTypes: []string{events.TypeUserCreated}})
+	assert.NoError(t, err)
+
+	s := NewUserService(repository.NewInMemoryRepository(repository.SeedUsers()...), metricsCollector,
+		WithOutbox(queue, nil), WithWebhooks(hooks))
+	_, err = s.AddUser(ctx, models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	assert.NoError(t, err)
+	_, err = s.UpdateUser(ctx, models.User{ID: 1, Name: "John Updated", Email: "john@example.com"})
+	assert.NoError(t, err)
+
+	// The request returned before anything was sent; the background workers deliver it
+	assert.Empty(t, captured)
+	assert.NoError(t, outbox.NewDispatcher(queue, webhooks.NewPublisher(hooks), metricsCollector, outbox.DefaultInterval).Tick(ctx))
+	assert.NoError(t, webhooks.NewWorker(hooks, metricsCollector, 10, webhooks.DefaultInterval).Tick(ctx))
+
+	if !assert.Len(t, captured, 1, "only the creation is delivered") {
+		return
+	}
+	got := <-captured
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(got.body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), got.signature)
+
+	var event events.Event
+	assert.NoError(t, json.Unmarshal(got.body, &event))
+	assert.Equal(t, events.TypeUserCreated, event.Type)
+	assert.Equal(t, "Ada Lovelace", event.User.Name)
+	assert.Equal(t, "ada@example.com", event.User.Email)
+	assert.NotZero(t, event.User.ID)
+}
@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"user-service/internal/events"
+)
+
+// memoryStore keeps webhooks and deliveries in memory, for tests and local development
+type memoryStore struct {
+	mu             sync.RWMutex
+	webhooks       map[int64]Webhook
+	nextID         int64
+	deliveries     []Delivery
+	nextDeliveryID int64
+}
+
+// NewMemoryStore creates an empty in-memory webhook store
+func NewMemoryStore() Store {
+	return &memoryStore{webhooks: make(map[int64]Webhook)}
+}
+
+// Create saves a new webhook with the next ID
+func (s *memoryStore) Create(_ context.Context, webhook Webhook) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	now := time.Now()
+	webhook.ID = s.nextID
+	webhook.EventTypes = append([]string{}, webhook.EventTypes...)
+	webhook.ConsecutiveFailures = 0
+	webhook.CreatedAt, webhook.UpdatedAt = now, now
+	s.webhooks[webhook.ID] = webhook
+	return webhook, nil
+}
+
+// Get returns the webhook with id
+func (s *memoryStore) Get(_ context.Context, id int64) (Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	return webhook, nil
+}
+
+// List returns every webhook ordered by ID
+func (s *memoryStore) List(_ context.Context) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := make([]Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	slices.SortFunc(webhooks, func(a, b Webhook) int { return cmp.Compare(a.ID, b.ID) })
+	return webhooks, nil
+}
+
+// Update replaces a webhook's settings and resets its failure count
+func (s *memoryStore) Update(_ context.Context, webhook Webhook) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.webhooks[webhook.ID]
+	if !ok {
+		return Webhook{}, ErrNotFound
+	}
+	existing.URL = webhook.URL
+	existing.Secret = webhook.Secret
+	existing.EventTypes = append([]string{}, webhook.EventTypes...)
+	existing.Enabled = webhook.Enabled
+	existing.ConsecutiveFailures = 0
+	existing.UpdatedAt = time.Now()
+	s.webhooks[webhook.ID] = existing
+	return existing, nil
+}
+
+// Delete removes a webhook with its deliveries
+func (s *memoryStore) Delete(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d Delivery) bool { return d.WebhookID == id })
+	return nil
+}
+
+// SetHealth saves a webhook's consecutive failure count and whether it is enabled
+func (s *memoryStore) SetHealth(_ context.Context, id int64, failures int, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	webhook.ConsecutiveFailures = failures
+	webhook.Enabled = enabled
+	webhook.UpdatedAt = time.Now()
+	s.webhooks[id] = webhook
+	return nil
+}
+
+// AddDelivery queues a delivery with the next ID, due immediately
+func (s *memoryStore) AddDelivery(_ context.Context, webhookID int64, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextDeliveryID++
+	now := time.Now()
+	s.deliveries = append(s.deliveries, Delivery{
+		ID:            s.nextDeliveryID,
+		WebhookID:     webhookID,
+		EventType:     event.Type,
+		Payload:       payload,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	return nil
+}
+
+// ClaimDeliveries returns up to limit pending deliveries due at now, oldest first,
+// and holds them back for lease
+func (s *memoryStore) ClaimDeliveries(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []Delivery
+	for i := range s.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d := &s.deliveries[i]; d.Status == StatusPending && !d.NextAttemptAt.After(now) {
+			d.NextAttemptAt = now.Add(lease)
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return deliveries, nil
+}
+
+// SaveAttempt saves the outcome of a delivery attempt
+func (s *memoryStore) SaveAttempt(_ context.Context, delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		if d.ID != delivery.ID {
+			continue
+		}
+		d.Status = delivery.Status
+		d.Attempts = delivery.Attempts
+		d.ResponseCode = delivery.ResponseCode
+		d.Error = delivery.Error
+		d.NextAttemptAt = delivery.NextAttemptAt
+		d.UpdatedAt = time.Now()
+		return nil
+	}
+	return nil
+}
+
+// Deliveries returns a page of a webhook's deliveries, newest first
+func (s *memoryStore) Deliveries(_ context.Context, webhookID int64, filter DeliveryFilter) ([]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var deliveries []Delivery
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(deliveries) == filter.Limit {
+			break
+		}
+		d := s.deliveries[i]
+		if d.WebhookID != webhookID || (filter.Before != 0 && d.ID >= filter.Before) {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
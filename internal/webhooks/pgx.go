@@ -0,0 +1,195 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"user-service/internal/database"
+	"user-service/internal/events"
+)
+
+// webhookColumns and deliveryColumns are selected, in this order, by every webhook and delivery query
+const (
+	webhookColumns  = "id, url, secret, event_types, enabled, consecutive_failures, created_at, updated_at"
+	deliveryColumns = "id, webhook_id, event_type, payload, status, attempts, response_code, error, next_attempt_at, created_at, updated_at"
+)
+
+// Statements run against the webhooks and webhook_deliveries tables
+const (
+	InsertWebhook    = "INSERT INTO webhooks (url, secret, event_types, enabled) VALUES ($1, $2, $3, $4) RETURNING " + webhookColumns
+	GetWebhook       = "SELECT " + webhookColumns + " FROM webhooks WHERE id = $1"
+	ListWebhooks     = "SELECT " + webhookColumns + " FROM webhooks ORDER BY id"
+	UpdateWebhook    = "UPDATE webhooks SET url = $2, secret = $3, event_types = $4, enabled = $5, consecutive_failures = 0, updated_at = now() WHERE id = $1 RETURNING " + webhookColumns
+	DeleteWebhook    = "DELETE FROM webhooks WHERE id = $1"
+	SetWebhookHealth = "UPDATE webhooks SET consecutive_failures = $2, enabled = $3, updated_at = now() WHERE id = $1"
+	InsertDelivery   = "INSERT INTO webhook_deliveries (webhook_id, event_type, payload) VALUES ($1, $2, $3)"
+	ClaimDeliveries  = "WITH claimed AS (UPDATE webhook_deliveries SET next_attempt_at = $2 WHERE id IN (SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= $1 ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED) RETURNING " + deliveryColumns + ") SELECT " + deliveryColumns + " FROM claimed ORDER BY id"
+	SaveAttempt      = "UPDATE webhook_deliveries SET status = $2, attempts = $3, response_code = $4, error = $5, next_attempt_at = $6, updated_at = now() WHERE id = $1"
+	ListDeliveries   = "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE webhook_id = $1"
+)
+
+// pgxStore keeps webhooks and deliveries in Postgres
+type pgxStore struct {
+	db database.DBTX
+}
+
+// NewPgxStore creates a store backed by a database connection
+func NewPgxStore(db database.DBTX) Store {
+	return &pgxStore{db: db}
+}
+
+// webhookDest returns the scan destinations for webhookColumns
+func webhookDest(webhook *Webhook) []interface{} {
+	return []interface{}{&webhook.ID, &webhook.URL, &webhook.Secret, &webhook.EventTypes, &webhook.Enabled, &webhook.ConsecutiveFailures, &webhook.CreatedAt, &webhook.UpdatedAt}
+}
+
+// deliveryDest returns the scan destinations for deliveryColumns
+func deliveryDest(delivery *Delivery) []interface{} {
+	return []interface{}{&delivery.ID, &delivery.WebhookID, &delivery.EventType, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.ResponseCode, &delivery.Error, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt}
+}
+
+// eventTypes returns types as a non-nil slice, so an empty list is stored as '{}' rather than NULL
+func eventTypes(types []string) []string {
+	if types == nil {
+		return []string{}
+	}
+	return types
+}
+
+// scanWebhook reads one webhook, mapping a missing row to ErrNotFound
+func scanWebhook(row pgx.Row) (Webhook, error) {
+	var webhook Webhook
+	if err := row.Scan(webhookDest(&webhook)...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Webhook{}, ErrNotFound
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// Create inserts a webhook; the database assigns its ID and timestamps
+func (s *pgxStore) Create(ctx context.Context, webhook Webhook) (Webhook, error) {
+	return scanWebhook(s.db.QueryRow(ctx, InsertWebhook, webhook.URL, webhook.Secret, eventTypes(webhook.EventTypes), webhook.Enabled))
+}
+
+// Get returns the webhook with id
+func (s *pgxStore) Get(ctx context.Context, id int64) (Webhook, error) {
+	return scanWebhook(s.db.QueryRow(ctx, GetWebhook, id))
+}
+
+// List returns every webhook ordered by ID
+func (s *pgxStore) List(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.Query(ctx, ListWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(webhookDest(&webhook)...); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// Update replaces a webhook's settings and resets its failure count
+func (s *pgxStore) Update(ctx context.Context, webhook Webhook) (Webhook, error) {
+	return scanWebhook(s.db.QueryRow(ctx, UpdateWebhook, webhook.ID, webhook.URL, webhook.Secret, eventTypes(webhook.EventTypes), webhook.Enabled))
+}
+
+// Delete removes a webhook; its deliveries are removed by the foreign key cascade
+func (s *pgxStore) Delete(ctx context.Context, id int64) error {
+	tag, err := s.db.Exec(ctx, DeleteWebhook, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetHealth saves a webhook's consecutive failure count and whether it is enabled
+func (s *pgxStore) SetHealth(ctx context.Context, id int64, failures int, enabled bool) error {
+	tag, err := s.db.Exec(ctx, SetWebhookHealth, id, failures, enabled)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddDelivery queues a delivery of event; the database makes it due immediately
+func (s *pgxStore) AddDelivery(ctx context.Context, webhookID int64, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, InsertDelivery, webhookID, event.Type, payload)
+	return err
+}
+
+// ClaimDeliveries holds the due deliveries back until the lease ends in the
+// statement that reads them, skipping the rows another worker is claiming
+func (s *pgxStore) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	return s.queryDeliveries(ctx, ClaimDeliveries, now, now.Add(lease), limit)
+}
+
+// SaveAttempt saves the outcome of a delivery attempt
+func (s *pgxStore) SaveAttempt(ctx context.Context, delivery Delivery) error {
+	_, err := s.db.Exec(ctx, SaveAttempt, delivery.ID, delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error, delivery.NextAttemptAt)
+	return err
+}
+
+// Deliveries returns a page of a webhook's deliveries, newest first
+func (s *pgxStore) Deliveries(ctx context.Context, webhookID int64, filter DeliveryFilter) ([]Delivery, error) {
+	sql, args := ListDeliveriesQuery(webhookID, filter)
+	return s.queryDeliveries(ctx, sql, args...)
+}
+
+// ListDeliveriesQuery returns the query and arguments listing a page of webhookID's deliveries
+func ListDeliveriesQuery(webhookID int64, filter DeliveryFilter) (string, []interface{}) {
+	sql := ListDeliveries
+	args := []interface{}{webhookID}
+	if filter.Before != 0 {
+		args = append(args, filter.Before)
+		sql += " AND id < $" + strconv.Itoa(len(args))
+	}
+	sql += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		sql += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return sql, args
+}
+
+func (s *pgxStore) queryDeliveries(ctx context.Context, sql string, args ...interface{}) ([]Delivery, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var delivery Delivery
+		if err := rows.Scan(deliveryDest(&delivery)...); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
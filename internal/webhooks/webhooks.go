@@ -0,0 +1,182 @@
+// Package webhooks delivers user events to partner endpoints over HTTP, signing each
+// delivery with the subscription's secret.
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"user-service/internal/events"
+	"user-service/internal/models"
+)
+
+// ErrNotFound is returned when no webhook has the requested ID
+var ErrNotFound = errors.New("webhook not found")
+
+// MaxURLLength bounds a webhook URL, matching the webhooks.url column
+const MaxURLLength = 2048
+
+// EventTypes lists the event types a webhook can subscribe to
+var EventTypes = []string{events.TypeUserCreated, events.TypeUserUpdated, events.TypeUserDeleted, events.TypeUserRestored}
+
+// Webhook is a partner endpoint subscribed to user events
+type Webhook struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+	// Secret signs every delivery; it is never returned by the API
+	Secret string `json:"-"`
+	// EventTypes the webhook receives; empty means every type
+	EventTypes []string `json:"event_types"`
+	// Enabled is cleared after too many consecutive failed deliveries
+	Enabled             bool      `json:"enabled"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the webhook receives events of eventType
+func (w *Webhook) Subscribes(eventType string) bool {
+	return len(w.EventTypes) == 0 || slices.Contains(w.EventTypes, eventType)
+}
+
+// Validate checks the webhook can be saved, reporting every failed rule at once as
+// models.ValidationErrors
+func (w *Webhook) Validate() error {
+	var errs models.ValidationErrors
+	add := func(field, rule, message string) {
+		errs = append(errs, models.FieldError{Field: field, Rule: rule, Message: message})
+	}
+
+	if w.URL == "" {
+		add("url", models.RuleRequired, "cannot be empty")
+	} else if len(w.URL) > MaxURLLength {
+		add("url", models.RuleMaxLength, "must be at most 2048 bytes")
+	} else if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("url", models.RuleURLFormat, "must be an absolute http or https URL")
+	}
+
+	if w.Secret == "" {
+		add("secret", models.RuleRequired, "cannot be empty")
+	}
+
+	for _, eventType := range w.EventTypes {
+		if !slices.Contains(EventTypes, eventType) {
+			add("event_types", models.RuleOneOf, "must each be one of "+strings.Join(EventTypes, ", "))
+			break
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Delivery is one event sent, or still to be sent, to a webhook
+type Delivery struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhook_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// ResponseCode is the status of the last attempt, or 0 when it got no response
+	ResponseCode int `json:"response_code,omitempty"`
+	// Error describes why the last attempt failed
+	Error         string    `json:"error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DeliveryFilter selects a page of a webhook's deliveries, newest first
+type DeliveryFilter struct {
+	// Before returns only deliveries with a smaller ID, for paging
+	Before int64
+	Limit  int
+}
+
+// Store keeps webhooks and their deliveries
+type Store interface {
+	Create(ctx context.Context, webhook Webhook) (Webhook, error)
+	Get(ctx context.Context, id int64) (Webhook, error)
+	List(ctx context.Context) ([]Webhook, error)
+	// Update replaces the URL, secret, event types and enabled flag, and resets the failure count
+	Update(ctx context.Context, webhook Webhook) (Webhook, error)
+	// Delete removes a webhook with its deliveries
+	Delete(ctx context.Context, id int64) error
+	// SetHealth saves a webhook's consecutive failure count and whether it is enabled
+	SetHealth(ctx context.Context, id int64, failures int, enabled bool) error
+
+	// AddDelivery queues a delivery, due immediately
+	AddDelivery(ctx context.Context, webhookID int64, event events.Event) error
+	// ClaimDeliveries returns up to limit pending deliveries due at now, oldest first,
+	// and holds them back for lease, so the workers of other replicas skip them
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	// SaveAttempt saves the status, attempts, response, error and next attempt time of a delivery
+	SaveAttempt(ctx context.Context, delivery Delivery) error
+	// Deliveries returns a page of a webhook's deliveries, newest first
+	Deliveries(ctx context.Context, webhookID int64, filter DeliveryFilter) ([]Delivery, error)
+}
+
+// Register saves webhook as the subscription for its URL, creating it or replacing
+// the secret and event types of an existing one, which is re-enabled. It lets a
+// webhook come from configuration and stay the same subscription across restarts.
+func Register(ctx context.Context, store Store, webhook Webhook) (Webhook, error) {
+	if err := webhook.Validate(); err != nil {
+		return Webhook{}, err
+	}
+	webhook.Enabled = true
+
+	existing, err := store.List(ctx)
+	if err != nil {
+		return Webhook{}, err
+	}
+	for _, w := range existing {
+		if w.URL == webhook.URL {
+			webhook.ID = w.ID
+			return store.Update(ctx, webhook)
+		}
+	}
+	return store.Create(ctx, webhook)
+}
+
+// publisher queues a delivery for every webhook subscribed to an event
+type publisher struct {
+	store Store
+}
+
+// NewPublisher creates an event publisher that hands events to the webhooks in store.
+// Deliveries are made by a Worker.
+func NewPublisher(store Store) events.EventPublisher {
+	return &publisher{store: store}
+}
+
+// Publish queues a delivery of event for every enabled webhook subscribed to it
+func (p *publisher) Publish(ctx context.Context, event events.Event) error {
+	webhooks, err := p.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Enabled || !webhook.Subscribes(event.Type) {
+			continue
+		}
+		if err := p.store.AddDelivery(ctx, webhook.ID, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
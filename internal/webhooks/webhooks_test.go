@@ -0,0 +1,230 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"user-service/internal/database/mocks"
+	"user-service/internal/events"
+	"user-service/internal/models"
+)
+
+func TestWebhook_Validate(t *testing.T) {
+	valid := Webhook{URL: "https://partner.example.com/hook", Secret: "s3cret", EventTypes: []string{events.TypeUserCreated}}
+	assert.NoError(t, valid.Validate())
+
+	everyType := Webhook{URL: "http://localhost:9000", Secret: "s3cret"}
+	assert.NoError(t, everyType.Validate())
+
+	var errs models.ValidationErrors
+	invalid := Webhook{URL: "/relative", EventTypes: []string{events.TypeUserCreated, "user.banned"}}
+	if assert.True(t, errors.As(invalid.Validate(), &errs)) {
+		assert.Equal(t, []string{"url", "secret", "event_types"}, fields(errs))
+		assert.Equal(t, models.RuleURLFormat, errs[0].Rule)
+	}
+
+	empty := Webhook{}
+	if assert.True(t, errors.As(empty.Validate(), &errs)) {
+		assert.Equal(t, models.ValidationErrors{
+			{Field: "url", Rule: models.RuleRequired, Message: "cannot be empty"},
+			{Field: "secret", Rule: models.RuleRequired, Message: "cannot be empty"},
+		}, errs)
+	}
+}
+
+func TestWebhook_Subscribes(t *testing.T) {
+	all := Webhook{}
+	assert.True(t, all.Subscribes(events.TypeUserDeleted))
+
+	some := Webhook{EventTypes: []string{events.TypeUserCreated}}
+	assert.True(t, some.Subscribes(events.TypeUserCreated))
+	assert.False(t, some.Subscribes(events.TypeUserDeleted))
+}
+
+func TestSign(t *testing.T) {
+	// Known HMAC-SHA256 of "hello" keyed by "secret"
+	assert.Equal(t, "sha256=88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b", Sign("secret", []byte("hello")))
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	first, err := store.Create(ctx, Webhook{URL: "https://a.example.com", Secret: "a", Enabled: true})
+	assert.NoError(t, err)
+	second, err := store.Create(ctx, Webhook{URL: "https://b.example.com", Secret: "b", Enabled: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, []int64{first.ID, second.ID})
+
+	assert.NoError(t, store.SetHealth(ctx, first.ID, 3, false))
+	got, err := store.Get(ctx, first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, got.ConsecutiveFailures)
+	assert.False(t, got.Enabled)
+
+	// Updating resets the failure count
+	updated, err := store.Update(ctx, Webhook{ID: first.ID, URL: "https://a2.example.com", Secret: "a", Enabled: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, updated.ConsecutiveFailures)
+	assert.Equal(t, "https://a2.example.com", updated.URL)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, store.AddDelivery(ctx, first.ID, events.Event{Type: events.TypeUserCreated}))
+	}
+	assert.NoError(t, store.AddDelivery(ctx, second.ID, events.Event{Type: events.TypeUserDeleted}))
+
+	deliveries, err := store.Deliveries(ctx, first.ID, DeliveryFilter{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 2}, deliveryIDs(deliveries))
+	deliveries, err = store.Deliveries(ctx, first.ID, DeliveryFilter{Before: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, deliveryIDs(deliveries))
+
+	delivery := deliveries[0]
+	delivery.Status, delivery.Attempts, delivery.ResponseCode = StatusSucceeded, 1, 200
+	assert.NoError(t, store.SaveAttempt(ctx, delivery))
+	pending, err := store.ClaimDeliveries(ctx, time.Now(), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3, 4}, deliveryIDs(pending))
+
+	// Claimed deliveries are held back until the lease ends
+	pending, err = store.ClaimDeliveries(ctx, time.Now(), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Deleting a webhook removes its deliveries
+	assert.NoError(t, store.Delete(ctx, first.ID))
+	assert.ErrorIs(t, store.Delete(ctx, first.ID), ErrNotFound)
+	_, err = store.Get(ctx, first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	pending, err = store.ClaimDeliveries(ctx, time.Now().Add(time.Minute), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4}, deliveryIDs(pending))
+}
+
+func TestPgxStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delete missing webhook", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Exec", ctx, DeleteWebhook, int64(9)).Return(pgconn.CommandTag("DELETE 0"), nil)
+
+		assert.ErrorIs(t, NewPgxStore(db).Delete(ctx, 9), ErrNotFound)
+	})
+
+	t.Run("add delivery", func(t *testing.T) {
+		db := &mocks.MockDBTX{}
+		db.On("Exec", ctx, InsertDelivery, int64(1), events.TypeUserUpdated, mock.MatchedBy(func(payload []byte) bool {
+			return assert.Contains(t, string(payload), `"type":"user.updated"`)
+		})).Return(pgconn.CommandTag("INSERT 0 1"), nil)
+
+		assert.NoError(t, NewPgxStore(db).AddDelivery(ctx, 1, events.Event{Type: events.TypeUserUpdated}))
+		db.AssertExpectations(t)
+	})
+
+	t.Run("claim deliveries", func(t *testing.T) {
+		now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		rows := &mocks.MockRows{}
+		rows.On("Close").Return()
+		rows.On("Next").Return(false)
+		rows.On("Err").Return(nil)
+		db := &mocks.MockDBTX{}
+		db.On("Query", ctx, ClaimDeliveries, now, now.Add(time.Minute), 50).Return(rows, nil)
+
+		deliveries, err := NewPgxStore(db).ClaimDeliveries(ctx, now, time.Minute, 50)
+		assert.NoError(t, err)
+		assert.Empty(t, deliveries)
+		db.AssertExpectations(t)
+	})
+
+	t.Run("save attempt", func(t *testing.T) {
+		next := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		db := &mocks.MockDBTX{}
+		db.On("Exec", ctx, SaveAttempt, int64(4), StatusPending, 2, 503, "endpoint returned 503", next).Return(pgconn.CommandTag("UPDATE 1"), nil)
+
+		err := NewPgxStore(db).SaveAttempt(ctx, Delivery{ID: 4, Status: StatusPending, Attempts: 2, ResponseCode: 503, Error: "endpoint returned 503", NextAttemptAt: next})
+		assert.NoError(t, err)
+		db.AssertExpectations(t)
+	})
+}
+
+func TestListDeliveriesQuery(t *testing.T) {
+	sql, args := ListDeliveriesQuery(7, DeliveryFilter{})
+	assert.Equal(t, ListDeliveries+" ORDER BY id DESC", sql)
+	assert.Equal(t, []interface{}{int64(7)}, args)
+
+	sql, args = ListDeliveriesQuery(7, DeliveryFilter{Before: 100, Limit: 50})
+	assert.Equal(t, ListDeliveries+" AND id < $2 ORDER BY id DESC LIMIT $3", sql)
+	assert.Equal(t, []interface{}{int64(7), int64(100), 50}, args)
+}
+
+func TestPublisher(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	all, _ := store.Create(ctx, Webhook{URL: "https://a.example.com", Secret: "a", Enabled: true})
+	created, _ := store.Create(ctx, Webhook{URL: "https://b.example.com", Secret: "b", EventTypes: []string{events.TypeUserCreated}, Enabled: true})
+	disabled, _ := store.Create(ctx, Webhook{URL: "https://c.example.com", Secret: "c"})
+
+	publisher := NewPublisher(store)
+	assert.NoError(t, publisher.Publish(ctx, events.Event{Type: events.TypeUserCreated}))
+	assert.NoError(t, publisher.Publish(ctx, events.Event{Type: events.TypeUserDeleted}))
+
+	count := func(id int64) int {
+		deliveries, err := store.Deliveries(ctx, id, DeliveryFilter{})
+		assert.NoError(t, err)
+		return len(deliveries)
+	}
+	assert.Equal(t, 2, count(all.ID))
+	assert.Equal(t, 1, count(created.ID))
+	assert.Equal(t, 0, count(disabled.ID))
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	other, _ := store.Create(ctx, Webhook{URL: "https://other.example.com", Secret: "o", Enabled: true})
+
+	first, err := Register(ctx, store, Webhook{URL: "https://partner.example.com/users", Secret: "old", EventTypes: []string{events.TypeUserCreated}})
+	assert.NoError(t, err)
+	assert.True(t, first.Enabled)
+	assert.NotEqual(t, other.ID, first.ID)
+
+	// Registering the URL again keeps the subscription, re-enabling it with the new secret
+	assert.NoError(t, store.SetHealth(ctx, first.ID, 10, false))
+	second, err := Register(ctx, store, Webhook{URL: "https://partner.example.com/users", Secret: "new", EventTypes: []string{events.TypeUserCreated}})
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, "new", second.Secret)
+	assert.True(t, second.Enabled)
+	assert.Zero(t, second.ConsecutiveFailures)
+
+	webhooks, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, webhooks, 2)
+
+	var errs models.ValidationErrors
+	_, err = Register(ctx, store, Webhook{URL: "https://partner.example.com/users"})
+	assert.ErrorAs(t, err, &errs)
+	assert.Equal(t, []string{"secret"}, fields(errs))
+}
+
+func fields(errs models.ValidationErrors) []string {
+	fields := make([]string, len(errs))
+	for i, e := range errs {
+		fields[i] = e.Field
+	}
+	return fields
+}
+
+func deliveryIDs(deliveries []Delivery) []int64 {
+	ids := make([]int64, len(deliveries))
+	for i, d := range deliveries {
+		ids[i] = d.ID
+	}
+	return ids
+}
@@ -0,0 +1,224 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"user-service/internal/httputil"
+	"user-service/internal/metrics"
+)
+
+// Delivery policy
+const (
+	// DefaultInterval is how often the worker looks for due deliveries
+	DefaultInterval = time.Second
+	// MaxAttempts is how often a delivery is tried before it is given up
+	MaxAttempts   = 5
+	deliveryBatch = 50
+	// claimLease holds a claimed batch back from the other replicas' workers for
+	// longer than its deliveries can take, each timing out after deliveryTimeout
+	claimLease = 5 * time.Minute
+	// A delivery that fails is retried after retryBackoff, doubling with each attempt up to maxBackoff
+	retryBackoff    = 5 * time.Second
+	maxBackoff      = 10 * time.Minute
+	deliveryTimeout = 5 * time.Second
+)
+
+// Headers sent with every delivery. X-Request-ID is sent too when the event
+// came from a request, so the receiver can correlate it with the service's logs.
+const (
+	SignatureHeader = "X-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Sign returns the X-Signature value for body: "sha256=" and the hex HMAC-SHA256 of body keyed by secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Worker delivers queued webhook deliveries. A delivery that times out or gets a 5xx
+// or 429 response is retried with a growing backoff; any other failure is final.
+// Each replica runs one, and a delivery is claimed by one worker at a time.
+type Worker struct {
+	store       Store
+	client      *http.Client
+	metrics     metrics.Recorder
+	interval    time.Duration
+	maxFailures int
+	backoff     time.Duration
+	now         func() time.Time
+}
+
+// NewWorker creates a worker delivering from store every interval. A webhook is
+// disabled after maxFailures consecutive failed attempts.
+func NewWorker(store Store, metricsCollector metrics.Recorder, maxFailures int, interval time.Duration) *Worker {
+	return &Worker{
+		store:       store,
+		client:      &http.Client{Timeout: deliveryTimeout},
+		metrics:     metricsCollector,
+		interval:    interval,
+		maxFailures: maxFailures,
+		backoff:     retryBackoff,
+		now:         time.Now,
+	}
+}
+
+// Run delivers on every tick until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Tick(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to deliver webhooks", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick makes one attempt at every due delivery
+func (w *Worker) Tick(ctx context.Context) error {
+	now := w.now()
+	deliveries, err := w.store.ClaimDeliveries(ctx, now, claimLease, deliveryBatch)
+	if err != nil {
+		return fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+
+	webhooks := make(map[int64]*Webhook)
+	for _, delivery := range deliveries {
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			found, err := w.store.Get(ctx, delivery.WebhookID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return fmt.Errorf("failed to read webhook %d: %w", delivery.WebhookID, err)
+			}
+			if err == nil {
+				webhook = &found
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+		if err := w.attempt(ctx, now, webhook, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attempt makes one delivery attempt and saves its outcome on the delivery and the webhook.
+// webhook is nil when it was deleted.
+func (w *Worker) attempt(ctx context.Context, now time.Time, webhook *Webhook, delivery Delivery) error {
+	if webhook == nil || !webhook.Enabled {
+		delivery.Status = StatusFailed
+		delivery.Error = "webhook is disabled"
+		w.metrics.RecordWebhookDelivery(StatusFailed)
+		return w.saveAttempt(ctx, delivery)
+	}
+
+	code, err := w.send(ctx, webhook, delivery)
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.Error = ""
+
+	if err == nil {
+		delivery.Status = StatusSucceeded
+		w.metrics.RecordWebhookDelivery(StatusSucceeded)
+		if webhook.ConsecutiveFailures > 0 {
+			webhook.ConsecutiveFailures = 0
+			if err := w.store.SetHealth(ctx, webhook.ID, 0, true); err != nil {
+				return fmt.Errorf("failed to reset webhook %d failures: %w", webhook.ID, err)
+			}
+		}
+		return w.saveAttempt(ctx, delivery)
+	}
+
+	delivery.Error = err.Error()
+	result := StatusFailed
+	if retryable(code) && delivery.Attempts < MaxAttempts {
+		result = "retry"
+		delivery.NextAttemptAt = now.Add(w.retryAfter(delivery.Attempts))
+	} else {
+		delivery.Status = StatusFailed
+	}
+	w.metrics.RecordWebhookDelivery(result)
+	slog.Warn("Webhook delivery failed", "webhook_id", webhook.ID, "delivery_id", delivery.ID, "attempts", delivery.Attempts, "result", result, "error", err)
+
+	webhook.ConsecutiveFailures++
+	if webhook.ConsecutiveFailures >= w.maxFailures {
+		webhook.Enabled = false
+		slog.Warn("Disabling webhook after consecutive failures", "webhook_id", webhook.ID, "failures", webhook.ConsecutiveFailures)
+	}
+	if err := w.store.SetHealth(ctx, webhook.ID, webhook.ConsecutiveFailures, webhook.Enabled); err != nil {
+		return fmt.Errorf("failed to save webhook %d failures: %w", webhook.ID, err)
+	}
+	return w.saveAttempt(ctx, delivery)
+}
+
+func (w *Worker) saveAttempt(ctx context.Context, delivery Delivery) error {
+	if err := w.store.SaveAttempt(ctx, delivery); err != nil {
+		return fmt.Errorf("failed to save webhook delivery %d: %w", delivery.ID, err)
+	}
+	return nil
+}
+
+// send POSTs the delivery's payload to the webhook. It returns the response status,
+// or 0 when there was no response, and an error unless the status is 2xx.
+func (w *Worker) send(ctx context.Context, webhook *Webhook, delivery Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	var event struct {
+		RequestID string `json:"request_id"`
+	}
+	if json.Unmarshal(delivery.Payload, &event) == nil && event.RequestID != "" {
+		req.Header.Set(httputil.RequestIDHeader, event.RequestID)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether an attempt that got code, or no response for 0, may succeed later
+func retryable(code int) bool {
+	return code == 0 || code >= 500 || code == http.StatusTooManyRequests
+}
+
+// retryAfter returns how long to wait after the nth failed attempt
+func (w *Worker) retryAfter(attempts int) time.Duration {
+	delay := w.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -0,0 +1,248 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/events"
+	"user-service/internal/metrics"
+	"user-service/internal/models"
+)
+
+// deliveryCount returns the webhook_deliveries_total counter for result
+func deliveryCount(t *testing.T, reg *prometheus.Registry, result string) float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "webhook_deliveries_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() == result {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// receiver is a partner endpoint that checks every signature and answers with the
+// next of its statuses, repeating the last one
+type receiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+	calls    atomic.Int32
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := int(rc.calls.Add(1))
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(rc.t, err)
+	assert.Equal(rc.t, "application/json", r.Header.Get("Content-Type"))
+	assert.NotEmpty(rc.t, r.Header.Get(DeliveryHeader))
+	assert.Equal(rc.t, events.TypeUserCreated, r.Header.Get(EventHeader))
+	if !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(Sign(rc.secret, body))) {
+		rc.t.Errorf("signature %q does not match the body", r.Header.Get(SignatureHeader))
+	}
+	w.WriteHeader(rc.statuses[min(call, len(rc.statuses))-1])
+}
+
+func TestWorker(t *testing.T) {
+	ctx := context.Background()
+	event := events.Event{Type: events.TypeUserCreated, User: models.User{ID: 7, Name: "John Doe"}}
+
+	// setup returns a worker with a fake clock delivering to rc through one webhook with one delivery
+	setup := func(t *testing.T, rc *receiver, maxFailures int) (*Worker, Store, *time.Time, *prometheus.Registry) {
+		server := httptest.NewServer(rc)
+		t.Cleanup(server.Close)
+
+		store := NewMemoryStore()
+		webhook, err := store.Create(ctx, Webhook{URL: server.URL, Secret: rc.secret, Enabled: true})
+		assert.NoError(t, err)
+		assert.NoError(t, store.AddDelivery(ctx, webhook.ID, event))
+
+		reg := prometheus.NewRegistry()
+		w := NewWorker(store, metrics.New(reg, reg), maxFailures, DefaultInterval)
+		now := time.Now()
+		w.now = func() time.Time { return now }
+		return w, store, &now, reg
+	}
+
+	// delivery returns the only delivery of webhook 1
+	delivery := func(t *testing.T, store Store) Delivery {
+		deliveries, err := store.Deliveries(ctx, 1, DeliveryFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+		return deliveries[0]
+	}
+
+	t.Run("signed delivery succeeds", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusNoContent}}
+		w, store, _, reg := setup(t, rc, 10)
+
+		assert.NoError(t, w.Tick(ctx))
+		got := delivery(t, store)
+		assert.Equal(t, StatusSucceeded, got.Status)
+		assert.Equal(t, 1, got.Attempts)
+		assert.Equal(t, http.StatusNoContent, got.ResponseCode)
+		assert.Equal(t, 1.0, deliveryCount(t, reg, StatusSucceeded))
+
+		// A delivered event is not sent again
+		assert.NoError(t, w.Tick(ctx))
+		assert.Equal(t, int32(1), rc.calls.Load())
+	})
+
+	t.Run("5xx is retried after backoff", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}}
+		w, store, now, reg := setup(t, rc, 10)
+
+		assert.NoError(t, w.Tick(ctx))
+		got := delivery(t, store)
+		assert.Equal(t, StatusPending, got.Status)
+		assert.Equal(t, http.StatusBadGateway, got.ResponseCode)
+		assert.Equal(t, now.Add(retryBackoff), got.NextAttemptAt)
+		hook, _ := store.Get(ctx, 1)
+		assert.Equal(t, 1, hook.ConsecutiveFailures)
+
+		// Nothing is sent before the backoff has passed
+		assert.NoError(t, w.Tick(ctx))
+		assert.Equal(t, int32(1), rc.calls.Load())
+
+		*now = now.Add(retryBackoff)
+		assert.NoError(t, w.Tick(ctx))
+		assert.Equal(t, now.Add(2*retryBackoff), delivery(t, store).NextAttemptAt)
+
+		*now = now.Add(2 * retryBackoff)
+		assert.NoError(t, w.Tick(ctx))
+		got = delivery(t, store)
+		assert.Equal(t, StatusSucceeded, got.Status)
+		assert.Equal(t, 3, got.Attempts)
+		assert.Empty(t, got.Error)
+		assert.Equal(t, 2.0, deliveryCount(t, reg, "retry"))
+		assert.Equal(t, 1.0, deliveryCount(t, reg, StatusSucceeded))
+
+		// Success resets the webhook's failure count
+		hook, _ = store.Get(ctx, 1)
+		assert.Equal(t, 0, hook.ConsecutiveFailures)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusInternalServerError}}
+		w, store, now, reg := setup(t, rc, 100)
+
+		for i := 0; i < MaxAttempts+2; i++ {
+			assert.NoError(t, w.Tick(ctx))
+			*now = now.Add(maxBackoff)
+		}
+		got := delivery(t, store)
+		assert.Equal(t, StatusFailed, got.Status)
+		assert.Equal(t, MaxAttempts, got.Attempts)
+		assert.Equal(t, int32(MaxAttempts), rc.calls.Load())
+		assert.Equal(t, float64(MaxAttempts-1), deliveryCount(t, reg, "retry"))
+		assert.Equal(t, 1.0, deliveryCount(t, reg, StatusFailed))
+	})
+
+	t.Run("4xx is not retried", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusGone}}
+		w, store, _, _ := setup(t, rc, 10)
+
+		assert.NoError(t, w.Tick(ctx))
+		got := delivery(t, store)
+		assert.Equal(t, StatusFailed, got.Status)
+		assert.Equal(t, http.StatusGone, got.ResponseCode)
+		assert.Contains(t, got.Error, "410")
+	})
+
+	t.Run("unreachable endpoint is retried", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusOK}}
+		w, store, _, _ := setup(t, rc, 10)
+		hook, _ := store.Get(ctx, 1)
+		_, err := store.Update(ctx, Webhook{ID: 1, URL: "http://127.0.0.1:1", Secret: hook.Secret, Enabled: true})
+		assert.NoError(t, err)
+
+		assert.NoError(t, w.Tick(ctx))
+		got := delivery(t, store)
+		assert.Equal(t, StatusPending, got.Status)
+		assert.Equal(t, 0, got.ResponseCode)
+		assert.NotEmpty(t, got.Error)
+	})
+
+	t.Run("consecutive failures disable the webhook", func(t *testing.T) {
+		rc := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusInternalServerError}}
+		w, store, now, _ := setup(t, rc, 2)
+		assert.NoError(t, store.AddDelivery(ctx, 1, event))
+		*now = time.Now()
+
+		// Both deliveries fail in the first tick, which disables the webhook
+		assert.NoError(t, w.Tick(ctx))
+		hook, _ := store.Get(ctx, 1)
+		assert.False(t, hook.Enabled)
+		assert.Equal(t, 2, hook.ConsecutiveFailures)
+
+		// Their retries are dropped without calling the endpoint
+		*now = now.Add(maxBackoff)
+		assert.NoError(t, w.Tick(ctx))
+		assert.Equal(t, int32(2), rc.calls.Load())
+		deliveries, err := store.Deliveries(ctx, 1, DeliveryFilter{})
+		assert.NoError(t, err)
+		for _, d := range deliveries {
+			assert.Equal(t, StatusFailed, d.Status)
+			assert.Equal(t, "webhook is disabled", d.Error)
+		}
+	})
+
+	t.Run("two workers send each delivery once", func(t *testing.T) {
+		var mu sync.Mutex
+		received := map[string]int{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			received[r.Header.Get(DeliveryHeader)]++
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(server.Close)
+
+		store := NewMemoryStore()
+		webhook, err := store.Create(ctx, Webhook{URL: server.URL, Secret: "s3cret", Enabled: true})
+		assert.NoError(t, err)
+		for i := 0; i < 3*deliveryBatch; i++ {
+			assert.NoError(t, store.AddDelivery(ctx, webhook.ID, event))
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			reg := prometheus.NewRegistry()
+			w := NewWorker(store, metrics.New(reg, reg), 10, DefaultInterval)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 3; i++ {
+					assert.NoError(t, w.Tick(ctx))
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Len(t, received, 3*deliveryBatch)
+		for id, count := range received {
+			assert.Equal(t, 1, count, "delivery %s", id)
+		}
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	w := &Worker{backoff: retryBackoff}
+	assert.Equal(t, retryBackoff, w.retryAfter(1))
+	assert.Equal(t, 4*retryBackoff, w.retryAfter(3))
+	assert.Equal(t, maxBackoff, w.retryAfter(50))
+}
@@ -0,0 +1,2 @@
+-- Duplicate email checks compare addresses without regard to case
+CREATE INDEX IF NOT EXISTS users_lower_email_idx ON users (lower(email));
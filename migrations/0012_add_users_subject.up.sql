@@ -0,0 +1,3 @@
+-- The identity provider's subject of the account that signs in as the user, for GET /me
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS subject VARCHAR(255) UNIQUE;
@@ -0,0 +1,4 @@
+-- Optional profile fields; empty means unset
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS display_name VARCHAR(100) NOT NULL DEFAULT '';
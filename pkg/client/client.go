@@ -0,0 +1,185 @@
+// Package client is a Go client for the user service HTTP API. It maps error
+// responses to typed errors, can retry throttled requests and only depends on
+// the standard library, so any service can import it.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Retry backoff bounds. Each retry waits twice as long as the previous one, with
+// jitter, unless the server asks for longer with Retry-After.
+const (
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// Client calls the user service. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	auth       Auth
+	maxRetries int
+	// sleep waits between retries; replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Auth adds credentials to a request
+type Auth func(req *http.Request)
+
+// BearerToken authenticates with "Authorization: Bearer <token>", as the admin routes require
+func BearerToken(token string) Auth {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// APIKey authenticates with an "X-API-Key: <key>" header
+func APIKey(key string) Auth {
+	return func(req *http.Request) {
+		req.Header.Set("X-API-Key", key)
+	}
+}
+
+// Option configures optional Client behaviour
+type Option func(*Client)
+
+// WithHTTPClient sends requests through httpClient instead of http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAuth adds credentials to every request
+func WithAuth(auth Auth) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithRetries retries a request up to n times when it is rate limited (429), and
+// idempotent requests also when the service is unavailable (503)
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.maxRetries = n
+	}
+}
+
+// New creates a client for the service at baseURL, such as "http://localhost:8082"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		sleep:      sleep,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// do sends a request to path with query and, when it is not nil, body encoded as JSON.
+// A 2xx response is decoded into out when it is not nil; any other becomes an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.auth != nil {
+			c.auth(req)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		apiErr := readError(resp)
+		if apiErr == nil {
+			err = decode(resp, out)
+			resp.Body.Close()
+			return err
+		}
+		resp.Body.Close()
+
+		if attempt >= c.maxRetries || !retryable(method, apiErr.StatusCode) {
+			return apiErr
+		}
+		if err := c.sleep(ctx, backoff(attempt, apiErr.RetryAfter)); err != nil {
+			return err
+		}
+	}
+}
+
+// decode reads a successful response into out
+func decode(resp *http.Response, out interface{}) error {
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether a response with status may be retried. Only idempotent
+// methods are retried after a 503, which may have come after the request was handled.
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+	}
+	return false
+}
+
+// backoff returns how long to wait before retry attempt+1: the server's Retry-After
+// when it gave one, otherwise an exponential delay with full jitter
+func backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := min(retryBaseDelay<<attempt, retryMaxDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"user-service/internal/app"
+	"user-service/internal/config"
+)
+
+const adminToken = "secret"
+
+// newServer serves the real routes over the seed users
+func newServer(t *testing.T) *httptest.Server {
+	cfg := config.Load()
+	cfg.DBBackend = "memory"
+	cfg.ListCacheTTL = 0
+	cfg.AdminToken = adminToken
+	cfg.RateLimit.Read = config.RateBudget{RequestsPerSecond: 1000, BurstSize: 1000}
+	cfg.RateLimit.Write = cfg.RateLimit.Read
+
+	service, err := app.New(cfg, app.Deps{Registry: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatalf("Failed to set up the service: %v", err)
+	}
+	server := httptest.NewServer(service.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUsers(t *testing.T) {
+	server := newServer(t)
+	c := New(server.URL, WithAuth(BearerToken(adminToken)))
+	ctx := context.Background()
+
+	user, err := c.GetUser(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "John Doe", user.Name)
+
+	users, err := c.ListUsers(ctx, ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, users, 4)
+
+	created, err := c.CreateUser(ctx, User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "user", created.Role)
+	assert.NotZero(t, created.ID)
+
+	created.Name = "Ada King"
+	updated, err := c.UpdateUser(ctx, created)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ada King", updated.Name)
+
+	disabled, err := c.DisableUser(ctx, created.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "disabled", disabled.Status)
+	users, err = c.ListUsers(ctx, ListOptions{Status: "disabled"})
+	assert.NoError(t, err)
+	assert.Equal(t, []User{disabled}, users)
+	enabled, err := c.EnableUser(ctx, created.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "active", enabled.Status)
+
+	assert.NoError(t, c.DeleteUser(ctx, created.ID))
+	_, err = c.GetUser(ctx, created.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	all, err := c.ListAllUsers(ctx, true)
+	assert.NoError(t, err)
+	assert.Len(t, all, 5)
+	restored, err := c.RestoreUser(ctx, created.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+
+	count, err := c.CountUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	health, err := c.Health(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "healthy", health.Status)
+}
+
+func TestErrors(t *testing.T) {
+	server := newServer(t)
+	c := New(server.URL)
+	admin := New(server.URL, WithAuth(BearerToken(adminToken)))
+	ctx := context.Background()
+
+	_, err := c.GetUser(ctx, 999)
+	assert.ErrorIs(t, err, ErrNotFound)
+	var apiErr *Error
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, "user not found", apiErr.Message)
+		assert.NotEmpty(t, apiErr.RequestID)
+	}
+
+	_, err = c.GetUser(ctx, 0)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = admin.CreateUser(ctx, User{Email: "invalid", Role: "superuser"})
+	assert.ErrorIs(t, err, ErrInvalid)
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, "VALIDATION", apiErr.Code)
+		var fields []string
+		for _, detail := range apiErr.Details {
+			fields = append(fields, detail.Field)
+		}
+		assert.Equal(t, []string{"name", "email", "role"}, fields)
+	}
+
+	_, err = admin.CreateUser(ctx, User{Name: "John", Email: "john@example.com"})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// Writes and admin routes without credentials
+	_, err = c.CreateUser(ctx, User{Name: "Ada", Email: "ada@example.com"})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = c.DisableUser(ctx, 1)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = New(server.URL, WithAuth(BearerToken("wrong"))).RestoreUser(ctx, 1)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "3")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1,"name":"John Doe"}`))
+	}))
+	defer throttled.Close()
+
+	var waits []time.Duration
+	fakeSleep := func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	t.Run("gives up without retries", func(t *testing.T) {
+		calls.Store(0)
+		_, err := New(throttled.URL).GetUser(context.Background(), 1)
+		assert.ErrorIs(t, err, ErrRateLimited)
+		var apiErr *Error
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, 3*time.Second, apiErr.RetryAfter)
+		}
+	})
+
+	t.Run("retries honoring Retry-After", func(t *testing.T) {
+		calls.Store(0)
+		waits = nil
+		c := New(throttled.URL, WithRetries(2))
+		c.sleep = fakeSleep
+		user, err := c.GetUser(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "John Doe", user.Name)
+		assert.Equal(t, []time.Duration{3 * time.Second, 3 * time.Second}, waits)
+	})
+
+	t.Run("stops after the last retry", func(t *testing.T) {
+		calls.Store(0)
+		c := New(throttled.URL, WithRetries(1))
+		c.sleep = fakeSleep
+		_, err := c.GetUser(context.Background(), 1)
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("does not retry a create after 503", func(t *testing.T) {
+		var creates atomic.Int32
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			creates.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer unavailable.Close()
+
+		c := New(unavailable.URL, WithRetries(3))
+		c.sleep = fakeSleep
+		_, err := c.CreateUser(context.Background(), User{Name: "Ada", Email: "ada@example.com"})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, int32(1), creates.Load())
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := range 10 {
+		delay := min(retryBaseDelay<<attempt, retryMaxDelay)
+		got := backoff(attempt, 0)
+		assert.GreaterOrEqual(t, got, delay/2)
+		assert.LessOrEqual(t, got, delay)
+	}
+	assert.Equal(t, 2*time.Second, backoff(0, 2*time.Second))
+}
+
+func TestContextCancellation(t *testing.T) {
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer blocked.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := New(blocked.URL).GetUser(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A retry wait ends with the context too
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = New(throttled.URL, WithRetries(1)).GetUser(ctx, 1)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "err = %v", err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "k3y", r.Header.Get("X-API-Key"))
+		_, _ = w.Write([]byte(`{"count":3}`))
+	}))
+	defer server.Close()
+
+	count, err := New(server.URL+"/", WithAuth(APIKey("k3y"))).CountUsers(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+// TestDependencies keeps the package importable by other services: it may only
+// use the standard library
+func TestDependencies(t *testing.T) {
+	out, err := exec.Command("go", "list", "-deps", "-f", "{{if not .Standard}}{{.ImportPath}}{{end}}", ".").Output()
+	if err != nil {
+		t.Skipf("go list unavailable: %v", err)
+	}
+	assert.Equal(t, "user-service/pkg/client", strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors that an *Error matches with errors.Is, by response status
+var (
+	ErrInvalid      = errors.New("invalid request")     // 400, 413 and 422
+	ErrUnauthorized = errors.New("unauthorized")        // 401 and 403
+	ErrNotFound     = errors.New("not found")           // 404
+	ErrConflict     = errors.New("conflict")            // 409
+	ErrRateLimited  = errors.New("rate limited")        // 429
+	ErrUnavailable  = errors.New("service unavailable") // 503
+)
+
+// maxErrorBytes bounds how much of an error body is read
+const maxErrorBytes = 64 << 10
+
+// Error is a non-2xx response from the service
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable code of a JSON error envelope, such as "VALIDATION"
+	Code    string
+	Message string
+	// Details lists every failed field of a validation error
+	Details []FieldError
+	// RequestID identifies the request in the service's logs
+	RequestID string
+	// RetryAfter is how long the service asked callers to wait, for 429 and 503 responses
+	RetryAfter time.Duration
+}
+
+// FieldError is one failed validation rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error describes the failure with its status and message
+func (e *Error) Error() string {
+	return fmt.Sprintf("user service: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches the sentinel error for the response status
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return target == ErrInvalid
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return false
+}
+
+// envelope is the JSON error body failures are reported with:
+// {"error":{"code":"VALIDATION","message":"...","details":[...],"request_id":"..."}}
+type envelope struct {
+	Error struct {
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Details   []FieldError `json:"details"`
+		RequestID string       `json:"request_id"`
+	} `json:"error"`
+}
+
+// readError returns the *Error for a non-2xx response, or nil for a successful one.
+// Bodies that are not a JSON envelope are taken as a plain-text message.
+func readError(resp *http.Response) *Error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	apiErr := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+	var env envelope
+	if json.Unmarshal(body, &env) == nil && env.Error.Message != "" {
+		apiErr.Code = env.Error.Code
+		apiErr.Message = env.Error.Message
+		apiErr.Details = env.Error.Details
+		if env.Error.RequestID != "" {
+			apiErr.RequestID = env.Error.RequestID
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// User is a user as returned by the service
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set on soft-deleted users, which only admin listings include
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// AvatarURL is the user's avatar, or their Gravatar when the server derives it
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// userRequest is the body of a create or update
+type userRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role,omitempty"`
+}
+
+// ListOptions narrows ListUsers. Zero fields do not filter.
+type ListOptions struct {
+	Role   string
+	Status string
+	// CreatedAfter and CreatedBefore are exclusive bounds on CreatedAt
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// usersPage is the body of a user listing
+type usersPage struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+// Health is the service's liveness report
+type Health struct {
+	Status     string    `json:"status"`
+	Service    string    `json:"service"`
+	Timestamp  time.Time `json:"timestamp"`
+	UsersCount int       `json:"users_count"`
+}
+
+// GetUser returns the user with id
+func (c *Client) GetUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := c.do(ctx, http.MethodGet, "/user", idQuery(id), nil, &user)
+	return user, err
+}
+
+// ListUsers returns the users matching opts, ordered by ID
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) ([]User, error) {
+	query := url.Values{}
+	if opts.Role != "" {
+		query.Set("role", opts.Role)
+	}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if !opts.CreatedAfter.IsZero() {
+		query.Set("created_after", opts.CreatedAfter.Format(time.RFC3339))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		query.Set("created_before", opts.CreatedBefore.Format(time.RFC3339))
+	}
+
+	var page usersPage
+	err := c.do(ctx, http.MethodGet, "/users", query, nil, &page)
+	return page.Users, err
+}
+
+// ListAllUsers returns every user, including soft-deleted ones when includeDeleted
+// is set. It requires admin credentials.
+func (c *Client) ListAllUsers(ctx context.Context, includeDeleted bool) ([]User, error) {
+	var page usersPage
+	err := c.do(ctx, http.MethodGet, "/admin/users", url.Values{"include_deleted": {strconv.FormatBool(includeDeleted)}}, nil, &page)
+	return page.Users, err
+}
+
+// CountUsers returns the number of users that are not deleted
+func (c *Client) CountUsers(ctx context.Context) (int, error) {
+	var body struct {
+		Count int `json:"count"`
+	}
+	err := c.do(ctx, http.MethodGet, "/users/count", nil, nil, &body)
+	return body.Count, err
+}
+
+// CreateUser creates a user from the name, email and role of user, which gets the
+// default role when Role is empty. It returns the user as stored.
+func (c *Client) CreateUser(ctx context.Context, user User) (User, error) {
+	var created User
+	err := c.do(ctx, http.MethodPost, "/users", nil, userRequest{Name: user.Name, Email: user.Email, Role: user.Role}, &created)
+	return created, err
+}
+
+// UpdateUser replaces the name and email of the user with user.ID and returns it as
+// stored. Its role cannot be changed, so user.Role is not sent.
+func (c *Client) UpdateUser(ctx context.Context, user User) (User, error) {
+	var updated User
+	err := c.do(ctx, http.MethodPut, "/user", idQuery(user.ID), userRequest{Name: user.Name, Email: user.Email}, &updated)
+	return updated, err
+}
+
+// DeleteUser soft-deletes the user with id
+func (c *Client) DeleteUser(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/user", idQuery(id), nil, nil)
+}
+
+// RestoreUser undeletes the user with id. It requires admin credentials.
+func (c *Client) RestoreUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/users/%d/restore", id), nil, nil, &user)
+	return user, err
+}
+
+// DisableUser disables the user with id. It requires admin credentials.
+func (c *Client) DisableUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/users/%d/disable", id), nil, nil, &user)
+	return user, err
+}
+
+// EnableUser re-enables the user with id. It requires admin credentials.
+func (c *Client) EnableUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/users/%d/enable", id), nil, nil, &user)
+	return user, err
+}
+
+// Health returns the service's liveness report
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.do(ctx, http.MethodGet, "/health", nil, nil, &health)
+	return health, err
+}
+
+// idQuery is the ?id= query of the single-user routes
+func idQuery(id int) url.Values {
+	return url.Values{"id": {strconv.Itoa(id)}}
+}
@@ -0,0 +1,58 @@
+package integration
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"user-service/internal/database"
+	"user-service/internal/database/queries"
+	"user-service/internal/repository"
+)
+
+// benchmarkGetUser measures GetUser against a connection configured by configure.
+// Unless prepare is set the connection is hidden behind DBTX so the repository cannot prepare it.
+func benchmarkGetUser(b *testing.B, configure func(*pgx.ConnConfig), prepare bool) {
+	ctx := context.Background()
+	config, err := pgx.ParseConfig(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	configure(config)
+
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close(ctx)
+
+	var db database.DBTX = struct{ database.DBTX }{conn}
+	if prepare {
+		db = conn
+	}
+	repo := repository.NewPgxUserRepository(db, queries.DefaultUsersTable)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.GetUser(ctx, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func withoutStatementCache(config *pgx.ConnConfig) {
+	config.BuildStatementCache = nil
+}
+
+func BenchmarkIntegration_GetUserUnprepared(b *testing.B) {
+	benchmarkGetUser(b, withoutStatementCache, false)
+}
+
+func BenchmarkIntegration_GetUserStatementCache(b *testing.B) {
+	benchmarkGetUser(b, func(*pgx.ConnConfig) {}, false)
+}
+
+func BenchmarkIntegration_GetUserPrepared(b *testing.B) {
+	benchmarkGetUser(b, withoutStatementCache, true)
+}
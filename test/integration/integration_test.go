@@ -17,12 +17,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"user-service/internal/app"
 	"user-service/internal/config"
-	"user-service/internal/handlers"
-	"user-service/internal/metrics"
-	"user-service/internal/middleware"
+	"user-service/internal/database/queries"
 	"user-service/internal/models"
-	"user-service/internal/services"
+	"user-service/internal/repository"
+	"user-service/internal/repository/repositorytest"
 )
 
 func setupTestDatabase(t *testing.T) (string, func()) {
@@ -59,24 +59,31 @@ func setupTestDatabase(t *testing.T) (string, func()) {
 	}
 	defer conn.Close(ctx)
 
-	migrations, err := os.ReadFile("../../migrations/0001_create_users_table.up.sql")
-	if err != nil {
-		t.Fatalf("failed to read migration file: %s", err)
-	}
-
-	_, err = conn.Exec(ctx, string(migrations))
-	if err != nil {
-		t.Fatalf("failed to run migration: %s", err)
-	}
-
-	seed, err := os.ReadFile("../../migrations/0002_seed_users_table.up.sql")
-	if err != nil {
-		t.Fatalf("failed to read seed file: %s", err)
+	migrations := []string{
+		"../../migrations/0001_create_users_table.up.sql",
+		"../../migrations/0002_seed_users_table.up.sql",
+		"../../migrations/0003_add_users_updated_at.up.sql",
+		"../../migrations/0004_add_users_role.up.sql",
+		"../../migrations/0005_add_users_deleted_at.up.sql",
+		"../../migrations/0006_add_users_created_at.up.sql",
+		"../../migrations/0007_add_users_status.up.sql",
+		"../../migrations/0008_create_audit_log.up.sql",
+		"../../migrations/0009_create_outbox.up.sql",
+		"../../migrations/0010_create_webhooks.up.sql",
+		"../../migrations/0011_add_users_email_lower_index.up.sql",
+		"../../migrations/0012_add_users_subject.up.sql",
+		"../../migrations/0013_add_users_profile.up.sql",
 	}
+	for _, path := range migrations {
+		migration, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read migration file %s: %s", path, err)
+		}
 
-	_, err = conn.Exec(ctx, string(seed))
-	if err != nil {
-		t.Fatalf("failed to run seed: %s", err)
+		_, err = conn.Exec(ctx, string(migration))
+		if err != nil {
+			t.Fatalf("failed to run migration %s: %s", path, err)
+		}
 	}
 
 	return databaseURL, func() {
@@ -86,21 +93,19 @@ func setupTestDatabase(t *testing.T) (string, func()) {
 	}
 }
 
-func createTestServer(db *pgx.Conn) *httptest.Server {
-	// Create test registry to avoid conflicts
-	testRegistry := prometheus.NewRegistry()
-	metricsCollector := metrics.New(testRegistry, testRegistry)
-
-	// Create service
-	userService := services.NewUserService(db, metricsCollector)
-
-	// Load configuration
+func createTestServer(t *testing.T, db *pgx.Conn) *httptest.Server {
+	// Load configuration, serving the test database
 	cfg := config.Load()
+	cfg.DBBackend = "postgres"
+	// The outbox dispatcher that clears cached lists only runs once the service is started
+	cfg.ListCacheTTL = 0
 
-	// Setup routes with middleware
-	mux := setupRoutes(userService, metricsCollector, cfg)
-
-	return httptest.NewServer(mux)
+	// Create test registry to avoid conflicts
+	service, err := app.New(cfg, app.Deps{Registry: prometheus.NewRegistry(), DB: db})
+	if err != nil {
+		t.Fatalf("failed to set up the service: %s", err)
+	}
+	return httptest.NewServer(service.Handler())
 }
 
 // Helper function to make HTTP requests to test server
@@ -140,7 +145,7 @@ func TestIntegration_CompleteUserWorkflow(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	t.Run("Health check works", func(t *testing.T) {
@@ -223,7 +228,7 @@ func TestIntegration_MiddlewareChain(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	t.Run("CORS headers are present", func(t *testing.T) {
@@ -295,7 +300,7 @@ func TestIntegration_ConcurrentRequests(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	numGoroutines := 10
@@ -403,7 +408,7 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	tests := []struct {
@@ -471,7 +476,7 @@ func TestIntegration_ResponseFormat(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	t.Run("User response has correct JSON format", func(t *testing.T) {
@@ -533,6 +538,148 @@ func TestIntegration_ResponseFormat(t *testing.T) {
 	})
 }
 
+func TestIntegration_Routing(t *testing.T) {
+	db, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+
+	server := createTestServer(t, db)
+	defer server.Close()
+
+	t.Run("Unknown paths are labelled as unmatched in metrics", func(t *testing.T) {
+		resp, err := makeRequest(server, "GET", "/does-not-exist", nil)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		closeResponseBody(t, resp)
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+		if resp.Header.Get("X-Request-ID") == "" {
+			t.Error("Expected X-Request-ID header on 404 response")
+		}
+
+		resp, err = makeRequest(server, "GET", "/metrics", nil)
+		if err != nil {
+			t.Fatalf("Failed to get metrics: %v", err)
+		}
+		defer closeResponseBody(t, resp)
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read metrics body: %v", err)
+		}
+
+		expected := `http_requests_total{endpoint="unmatched",method="GET",status_code="404"}`
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %s", expected)
+		}
+		if strings.Contains(string(body), `endpoint="/does-not-exist"`) {
+			t.Error("Expected unknown path not to be used as a metrics label")
+		}
+	})
+
+	t.Run("Unknown paths answer the JSON error envelope", func(t *testing.T) {
+		resp, err := makeRequest(server, "GET", "/userz", nil)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer closeResponseBody(t, resp)
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %q", contentType)
+		}
+		var body struct {
+			Error struct {
+				Code      string `json:"code"`
+				Message   string `json:"message"`
+				RequestID string `json:"request_id"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode error body: %v", err)
+		}
+		if body.Error.Code != "ROUTE_NOT_FOUND" {
+			t.Errorf("Expected code ROUTE_NOT_FOUND, got %q", body.Error.Code)
+		}
+		if body.Error.Message == "" {
+			t.Error("Expected an error message")
+		}
+		if body.Error.RequestID != resp.Header.Get("X-Request-ID") {
+			t.Errorf("Expected request ID %q in the body, got %q", resp.Header.Get("X-Request-ID"), body.Error.RequestID)
+		}
+	})
+
+	t.Run("Wrong methods answer 405 with the allowed ones", func(t *testing.T) {
+		resp, err := makeRequest(server, "DELETE", "/users/export", nil)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer closeResponseBody(t, resp)
+
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %q", contentType)
+		}
+		if allow := resp.Header.Get("Allow"); allow != "GET, HEAD" {
+			t.Errorf("Expected Allow: GET, HEAD, got %q", allow)
+		}
+	})
+
+	t.Run("Known routes are labelled with their pattern", func(t *testing.T) {
+		resp, err := makeRequest(server, "GET", "/user?id=1", nil)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		closeResponseBody(t, resp)
+
+		resp, err = makeRequest(server, "GET", "/metrics", nil)
+		if err != nil {
+			t.Fatalf("Failed to get metrics: %v", err)
+		}
+		defer closeResponseBody(t, resp)
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read metrics body: %v", err)
+		}
+
+		if !strings.Contains(string(body), `endpoint="/user"`) {
+			t.Error("Expected metrics to contain endpoint=\"/user\"")
+		}
+	})
+
+	t.Run("Trailing slash is not redirected", func(t *testing.T) {
+		client := &http.Client{
+			Timeout: 5 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		resp, err := client.Get(server.URL + "/users/")
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer closeResponseBody(t, resp)
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			t.Errorf("Expected no redirect, got Location: %s", location)
+		}
+	})
+}
+
 // Performance integration test
 func TestIntegration_Performance(t *testing.T) {
 	db, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
@@ -541,7 +688,7 @@ func TestIntegration_Performance(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	// Warm up
@@ -597,7 +744,7 @@ func TestIntegration_ServerLifecycle(t *testing.T) {
 	}
 	defer db.Close(context.Background())
 
-	server := createTestServer(db)
+	server := createTestServer(t, db)
 	defer server.Close()
 
 	// Test that server is responding
@@ -620,32 +767,29 @@ func TestIntegration_ServerLifecycle(t *testing.T) {
 		t.Error("Server should not be responding after close")
 	}
 }
-func setupRoutes(userService *services.UserService, metricsCollector *metrics.Metrics, cfg *config.Config) *http.ServeMux {
-	mux := http.NewServeMux()
-
-	// Create handlers
-	userHandler := handlers.NewUserHandler(userService)
-	healthHandler := handlers.NewHealthHandler(userService)
-
-	// Apply middleware chain
-	var handler http.Handler = mux
-	handler = middleware.Recovery(metricsCollector)(handler)
-	handler = middleware.CORS()(handler)
-	handler = middleware.RateLimit(cfg.GetRateLimiter(), metricsCollector)(handler)
-	handler = middleware.Metrics(metricsCollector)(handler)
-	handler = middleware.Logging()(handler)
-
-	// Register application routes
-	mux.HandleFunc("/user", userHandler.GetUser)
-	mux.HandleFunc("/users", userHandler.ListUsers)
-	mux.HandleFunc("/health", healthHandler.Health)
-
-	// Register metrics endpoint
-	mux.Handle("/metrics", metricsCollector.Handler())
-
-	// Wrap the final handler
-	finalMux := http.NewServeMux()
-	finalMux.Handle("/", handler)
-
-	return finalMux
+
+func TestIntegration_PgxUserRepository(t *testing.T) {
+	db, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(context.Background())
+
+	// Each subtest gets an empty users table inside a transaction that is rolled back afterwards
+	repositorytest.TestUserRepository(t, func(t *testing.T) repository.UserRepository {
+		tx, err := db.Begin(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := tx.Rollback(context.Background()); err != nil {
+				t.Logf("Error rolling back transaction: %v", err)
+			}
+		})
+
+		if _, err := tx.Exec(context.Background(), "DELETE FROM users"); err != nil {
+			t.Fatal(err)
+		}
+		return repository.NewPgxUserRepository(tx, queries.DefaultUsersTable)
+	})
 }